@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Aggregate is what an AggregateFunc derives from a cycle's results. It is
+// shown as a synthetic tile and exported as the mosaic_aggregate metric.
+type Aggregate struct {
+	Value    float64 // Shown on the tile and exported in /metrics
+	Unit     string  // Appended to the value on the tile, e.g. "%"
+	Alive    bool    // False turns the tile red
+	Degraded bool    // True turns the tile yellow
+	Detail   string  // Tooltip text
+}
+
+// AggregateFunc derives an Aggregate, such as a business-specific health
+// score, from the latest status of every monitored host. It runs after every
+// cycle, on the cycle's goroutine, so it must be quick and must not modify
+// statuses.
+type AggregateFunc func(statuses []HostStatus) Aggregate
+
+// aggregator is a registered AggregateFunc and its latest result.
+type aggregator struct {
+	name string
+	fn   AggregateFunc
+	last Aggregate
+}
+
+var (
+	aggregatorsMu sync.Mutex
+	aggregators   []*aggregator // In registration order, which is tile order
+)
+
+// RegisterAggregator adds fn as an aggregation function whose result appears
+// as a tile called name after the monitored hosts and in /metrics. mosaic is
+// extended by adding a file to the build that registers its functions from
+// init, so the core loop stays untouched:
+//
+//	func init() {
+//		RegisterAggregator("checkout-health", func(s []HostStatus) Aggregate {
+//			...
+//		})
+//	}
+//
+// Like http.Handle it panics if name is empty or already registered.
+func RegisterAggregator(name string, fn AggregateFunc) {
+	aggregatorsMu.Lock()
+	defer aggregatorsMu.Unlock()
+	if name == "" || fn == nil {
+		panic("mosaic: aggregator needs a name and a function")
+	}
+	for _, a := range aggregators {
+		if a.name == name {
+			panic("mosaic: aggregator " + name + " registered twice")
+		}
+	}
+	aggregators = append(aggregators, &aggregator{name: name, fn: fn})
+}
+
+// runAggregators runs every registered aggregation function over statuses.
+// A function that panics is reported on its tile instead of stopping the
+// loop.
+//
+// Returns:
+//   - []HostStatus: One synthetic tile per aggregation function
+func runAggregators(statuses []HostStatus) []HostStatus {
+	aggregatorsMu.Lock()
+	defer aggregatorsMu.Unlock()
+	tiles := make([]HostStatus, 0, len(aggregators))
+	for _, a := range aggregators {
+		a.last = a.run(statuses)
+		tiles = append(tiles, HostStatus{
+			Host:     a.name,
+			Alive:    a.last.Alive,
+			Degraded: a.last.Degraded,
+			Detail:   a.last.Detail,
+			Value:    strconv.FormatFloat(math.Round(a.last.Value*100)/100, 'f', -1, 64) + a.last.Unit,
+		})
+	}
+	return tiles
+}
+
+// run calls the function on a copy of statuses, recovering from a panic.
+func (a *aggregator) run(statuses []HostStatus) (result Aggregate) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = Aggregate{Detail: fmt.Sprintf("aggregator failed: %v", r)}
+		}
+	}()
+	return a.fn(append([]HostStatus(nil), statuses...))
+}
+
+// writeAggregateMetrics writes the latest value of every aggregation
+// function in the Prometheus text exposition format.
+func writeAggregateMetrics(b *strings.Builder) {
+	aggregatorsMu.Lock()
+	defer aggregatorsMu.Unlock()
+	if len(aggregators) == 0 {
+		return
+	}
+	b.WriteString("# HELP mosaic_aggregate Latest value of a custom aggregation function.\n# TYPE mosaic_aggregate gauge\n")
+	for _, a := range aggregators {
+		fmt.Fprintf(b, "mosaic_aggregate{name=%q} %s\n", a.name, strconv.FormatFloat(a.last.Value, 'g', -1, 64))
+	}
+}
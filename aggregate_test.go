@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withAggregators restores the registered aggregators after the test.
+func withAggregators(t *testing.T) {
+	saved := aggregators
+	aggregators = nil
+	t.Cleanup(func() { aggregators = saved })
+}
+
+func TestRunAggregators(t *testing.T) {
+	withAggregators(t)
+	RegisterAggregator("checkout-health", func(s []HostStatus) Aggregate {
+		up := 0
+		for _, st := range s {
+			if st.Alive {
+				up++
+			}
+		}
+		score := 100 * float64(up) / float64(len(s))
+		return Aggregate{Value: score, Unit: "%", Alive: score > 50, Degraded: score < 100, Detail: "share of checkout hosts up"}
+	})
+	RegisterAggregator("broken", func(s []HostStatus) Aggregate {
+		s[0].Alive = false
+		panic("no data")
+	})
+
+	statuses := []HostStatus{{Host: "web01", Alive: true}, {Host: "web02", Alive: true}, {Host: "db01"}}
+	tiles := runAggregators(statuses)
+	if assert.Len(t, tiles, 2) {
+		assert.Equal(t, HostStatus{Host: "checkout-health", Alive: true, Degraded: true, Detail: "share of checkout hosts up", Value: "66.67%"}, tiles[0])
+		assert.Equal(t, "broken", tiles[1].Host)
+		assert.False(t, tiles[1].Alive)
+		assert.Contains(t, tiles[1].Detail, "no data", "a panic is reported on the tile")
+	}
+	assert.True(t, statuses[0].Alive, "functions get a copy of the statuses")
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), "mosaic_aggregate{name=\"checkout-health\"} 66.66666666666667\n")
+}
+
+func TestRegisterAggregatorTwice(t *testing.T) {
+	withAggregators(t)
+	fn := func([]HostStatus) Aggregate { return Aggregate{} }
+	RegisterAggregator("score", fn)
+	assert.Panics(t, func() { RegisterAggregator("score", fn) })
+	assert.Panics(t, func() { RegisterAggregator("", fn) })
+}
+
+func TestRunCycleAddsAggregateTiles(t *testing.T) {
+	withHosts(t, "sim://a?latency=0s")
+	withAggregators(t)
+	defer func(saved *schedulerStats) { scheduler = saved }(scheduler)
+	scheduler = newSchedulerStats()
+	var seen []string
+	RegisterAggregator("fleet", func(s []HostStatus) Aggregate {
+		for _, st := range s {
+			seen = append(seen, st.Host)
+		}
+		return Aggregate{Value: 1, Alive: true}
+	})
+
+	runCycle(context.Background(), false)
+	assert.Equal(t, []string{"sim://a?latency=0s"}, seen)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// splitAlias splits a logical host entry such as
+// "core-sw1=10.0.0.1|2001:db8::1|ssh://192.168.100.1" into its name and the
+// entries of its addresses. ok is false for ordinary host entries; the name
+// must be a plain label so "?key=value" options are not mistaken for one.
+func splitAlias(host string) (name string, members []string, ok bool) {
+	name, rest, found := strings.Cut(host, "=")
+	if !found || name == "" || rest == "" || strings.ContainsAny(name, ":/?#[]@") {
+		return "", nil, false
+	}
+	for _, m := range strings.Split(rest, "|") {
+		if m = strings.TrimSpace(m); m != "" {
+			members = append(members, m)
+		}
+	}
+	return strings.TrimSpace(name), members, len(members) > 0
+}
+
+// pingAlias probes every address of a logical host in parallel and merges
+// them into one status. The host is up while any address answers and
+// degraded while some do not, with the per-address results in Paths.
+//
+// Parameters:
+//   - host: The full alias entry
+//   - name: Display name of the logical host
+//   - members: Host entries of its addresses
+//
+// Returns:
+//   - HostStatus: Merged status; latency is that of the first answering address
+func pingAlias(host, name string, members []string) HostStatus {
+	results := make([]HostStatus, len(members))
+	var wg sync.WaitGroup
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m string) {
+			defer wg.Done()
+			results[i] = pingHost(m)
+		}(i, m)
+	}
+	wg.Wait()
+
+	status := HostStatus{Host: host, Name: name}
+	var loss float64
+	var down []string
+	for _, r := range results {
+		status.Paths = append(status.Paths, PathStatus{Path: r.Host, Alive: r.Alive, LatencyMs: r.LatencyMs, PacketLoss: r.PacketLoss})
+		loss += r.PacketLoss
+		if !r.Alive {
+			down = append(down, r.Host)
+			continue
+		}
+		if !status.Alive {
+			status.Alive = true
+			status.LatencyMs = r.LatencyMs
+		}
+		status.Degraded = status.Degraded || r.Degraded
+	}
+	status.PacketLoss = loss / float64(len(results))
+	if status.Alive && len(down) > 0 {
+		status.Degraded = true
+		status.Detail = "down: " + strings.Join(down, ", ")
+	}
+	return status
+}
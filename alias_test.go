@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitAlias(t *testing.T) {
+	name, members, ok := splitAlias("core-sw1=10.0.0.1| 2001:db8::1 |ssh://192.168.100.1")
+	assert.True(t, ok)
+	assert.Equal(t, "core-sw1", name)
+	assert.Equal(t, []string{"10.0.0.1", "2001:db8::1", "ssh://192.168.100.1"}, members)
+
+	for _, host := range []string{"8.8.8.8", "10.0.0.7?iface=wg0", "smtp://mail?ehlo=x", "https://a/#contains=ok", "core=", "=10.0.0.1"} {
+		_, _, ok = splitAlias(host)
+		assert.False(t, ok, host)
+	}
+
+	assert.NoError(t, validateHost("core=10.0.0.1|ssh://10.0.0.2"))
+	assert.Error(t, validateHost("core=10.0.0.1|gopher://x"))
+	assert.Error(t, validateHost("core=a=b"))
+}
+
+func TestPingAlias(t *testing.T) {
+	defer func(saved func(string) probeResult) { probers[""] = saved }(probers[""])
+	probers[""] = func(addr string) probeResult {
+		if addr == "2001:db8::1" {
+			return probeResult{Sent: 1}
+		}
+		return probeResult{Sent: 1, Recv: 1}
+	}
+	host := "core-sw1=10.0.0.1|2001:db8::1"
+	defer dropHostLocked(host)
+
+	status := pingHost(host)
+	assert.Equal(t, host, status.Host)
+	assert.Equal(t, "core-sw1", status.Name)
+	assert.True(t, status.Alive)
+	assert.True(t, status.Degraded)
+	assert.Equal(t, "down: 2001:db8::1", status.Detail)
+	assert.Equal(t, 50.0, status.PacketLoss)
+	assert.Equal(t, []PathStatus{
+		{Path: "10.0.0.1", Alive: true},
+		{Path: "2001:db8::1", Alive: false, PacketLoss: 100},
+	}, status.Paths)
+	assert.Equal(t, []string{"net:10.0.0.0/24"}, correlationKeys(host))
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryFile is the -inventory value: an Ansible inventory whose hosts
+// are monitored along with those of -file and -hosts, so the monitoring set
+// follows what automation manages. It is re-read like -file.
+var inventoryFile string
+
+// ansibleInventory collects the hosts of an Ansible inventory as they are
+// parsed: each host with its variables, and the groups with their direct
+// hosts and child groups.
+type ansibleInventory struct {
+	order    []string                     // Inventory hostnames in the order first seen
+	vars     map[string]map[string]string // Host variables, e.g. ansible_host
+	members  map[string][]string          // Group -> hosts listed in it
+	children map[string][]string          // Group -> its child groups
+}
+
+// newAnsibleInventory creates an empty inventory.
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		vars:     make(map[string]map[string]string),
+		members:  make(map[string][]string),
+		children: make(map[string][]string),
+	}
+}
+
+// addHost adds host to group with the variables vars, keeping the first
+// value given for each variable.
+func (inv *ansibleInventory) addHost(group, host string, vars map[string]string) {
+	if _, ok := inv.vars[host]; !ok {
+		inv.order = append(inv.order, host)
+		inv.vars[host] = make(map[string]string)
+	}
+	for k, v := range vars {
+		if _, ok := inv.vars[host][k]; !ok {
+			inv.vars[host][k] = v
+		}
+	}
+	inv.members[group] = append(inv.members[group], host)
+}
+
+// readInventory reads the Ansible inventory at path: YAML if the file ends
+// in .yml, .yaml or .json, INI otherwise. Each host is monitored at its
+// ansible_host, labeled with its inventory hostname, or at the inventory
+// hostname itself. A trailing ":port" is the SSH port and is dropped.
+// Hosts are in the groups they are listed in and in the parents of those
+// groups, except for the implicit "all" and "ungrouped".
+//
+// Parameters:
+//   - path: The inventory file
+//
+// Returns:
+//   - []string: The host entries in inventory order
+//   - map[string]HostLabel: The name and groups of each host
+//   - error: If the file cannot be read or parsed
+func readInventory(path string) ([]string, map[string]HostLabel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	inv := newAnsibleInventory()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml", ".json":
+		err = inv.parseYAML(data)
+	default:
+		err = inv.parseINI(data)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("inventory %s: %v", path, err)
+	}
+	hosts, labels := inv.hosts()
+	return hosts, labels, nil
+}
+
+// hosts returns the host entries of inv and their labels.
+func (inv *ansibleInventory) hosts() ([]string, map[string]HostLabel) {
+	groups := make(map[string][]string)
+	var visit func(group string, seen map[string]bool) []string
+	// visit returns the hosts of group and of its children, guarding
+	// against groups that are their own descendants
+	visit = func(group string, seen map[string]bool) []string {
+		if seen[group] {
+			return nil
+		}
+		seen[group] = true
+		hosts := append([]string(nil), inv.members[group]...)
+		for _, child := range inv.children[group] {
+			hosts = append(hosts, visit(child, seen)...)
+		}
+		return hosts
+	}
+	names := make([]string, 0, len(inv.members)+len(inv.children))
+	for g := range inv.members {
+		names = append(names, g)
+	}
+	for g := range inv.children {
+		if _, ok := inv.members[g]; !ok {
+			names = append(names, g)
+		}
+	}
+	sort.Strings(names)
+	for _, g := range names {
+		if g == "all" || g == "ungrouped" {
+			continue
+		}
+		for _, h := range visit(g, make(map[string]bool)) {
+			if l := groups[h]; len(l) == 0 || l[len(l)-1] != g {
+				groups[h] = append(groups[h], g)
+			}
+		}
+	}
+
+	entries := make([]string, 0, len(inv.order))
+	labels := make(map[string]HostLabel, len(inv.order))
+	seen := make(map[string]bool, len(inv.order))
+	for _, h := range inv.order {
+		entry, name := stripSSHPort(h), ""
+		if addr := inv.vars[h]["ansible_host"]; addr != "" {
+			entry, name = addr, entry
+		}
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		entries = append(entries, entry)
+		labels[entry] = HostLabel{Name: name, Groups: groups[h]}
+	}
+	return entries, labels
+}
+
+// stripSSHPort drops the SSH port of an inventory hostname such as
+// "db01.example.com:2222". IPv6 addresses keep their colons.
+func stripSSHPort(host string) string {
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.Atoi(port); err == nil {
+			return h
+		}
+	}
+	return host
+}
+
+// parseINI parses an inventory in INI format:
+//
+//	mail.example.com
+//	[webservers]
+//	web[01:03].example.com
+//	db01 ansible_host=10.0.5.21
+//	[prod:children]
+//	webservers
+//	[prod:vars]
+//	ntp_server=ntp.example.com
+//
+// Hosts before the first section are ungrouped, variables sections are
+// skipped.
+func (inv *ansibleInventory) parseINI(data []byte) error {
+	group, kind := "ungrouped", "hosts"
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("line %d: invalid section %q", n, line)
+			}
+			group, kind, _ = strings.Cut(line[1:len(line)-1], ":")
+			if kind == "" {
+				kind = "hosts"
+			}
+			continue
+		}
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		fields := strings.Fields(line)
+		switch kind {
+		case "hosts":
+			vars := make(map[string]string)
+			for _, f := range fields[1:] {
+				k, v, ok := strings.Cut(f, "=")
+				if !ok {
+					return fmt.Errorf("line %d: invalid variable %q", n, f)
+				}
+				vars[k] = strings.Trim(v, `"'`)
+			}
+			hosts, err := expandInventoryPattern(fields[0])
+			if err != nil {
+				return fmt.Errorf("line %d: %v", n, err)
+			}
+			for _, h := range hosts {
+				inv.addHost(group, h, vars)
+			}
+		case "children":
+			inv.children[group] = append(inv.children[group], fields[0])
+		case "vars":
+		default:
+			return fmt.Errorf("line %d: invalid section type %q", n, kind)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseYAML parses an inventory in YAML format, in which each group may
+// have hosts, children and vars:
+//
+//	all:
+//	  children:
+//	    webservers:
+//	      hosts:
+//	        web[01:03].example.com:
+//	        db01: {ansible_host: 10.0.5.21}
+func (inv *ansibleInventory) parseYAML(data []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+	top := root.Content[0]
+	if top.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a mapping of groups")
+	}
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		if err := inv.parseYAMLGroup(top.Content[i].Value, top.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseYAMLGroup adds the hosts and children of the group mapping node.
+func (inv *ansibleInventory) parseYAMLGroup(group string, node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("group %s: expected hosts, children or vars", group)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+			continue
+		}
+		switch key {
+		case "hosts":
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("group %s: hosts must be a mapping", group)
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				vars := make(map[string]string)
+				if v := value.Content[j+1]; v.Kind == yaml.MappingNode {
+					for k := 0; k+1 < len(v.Content); k += 2 {
+						if v.Content[k+1].Kind == yaml.ScalarNode {
+							vars[v.Content[k].Value] = v.Content[k+1].Value
+						}
+					}
+				}
+				hosts, err := expandInventoryPattern(value.Content[j].Value)
+				if err != nil {
+					return fmt.Errorf("group %s: %v", group, err)
+				}
+				for _, h := range hosts {
+					inv.addHost(group, h, vars)
+				}
+			}
+		case "children":
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("group %s: children must be a mapping", group)
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				child := value.Content[j].Value
+				inv.children[group] = append(inv.children[group], child)
+				if err := inv.parseYAMLGroup(child, value.Content[j+1]); err != nil {
+					return err
+				}
+			}
+		case "vars":
+		default:
+			return fmt.Errorf("group %s: unknown key %q", group, key)
+		}
+	}
+	return nil
+}
+
+// expandInventoryPattern expands the host ranges of an inventory hostname,
+// e.g. "web[01:03].example.com" to web01 to web03, keeping leading zeros,
+// or "db-[a:c]" to db-a to db-c. A range may have a stride,
+// e.g. "[1:9:2]". The result is capped at expandLimit hosts.
+func expandInventoryPattern(pattern string) ([]string, error) {
+	open := strings.Index(pattern, "[")
+	closing := strings.Index(pattern, "]")
+	if open < 0 || closing < open || !strings.Contains(pattern[open:closing], ":") || net.ParseIP(pattern[open+1:closing]) != nil {
+		// No range, or a bracketed IPv6 address
+		return []string{pattern}, nil
+	}
+	prefix, spec, suffix := pattern[:open], pattern[open+1:closing], pattern[closing+1:]
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid host range %q", pattern)
+	}
+	stride := 1
+	if len(parts) == 3 {
+		s, err := strconv.Atoi(parts[2])
+		if err != nil || s < 1 {
+			return nil, fmt.Errorf("invalid stride in %q", pattern)
+		}
+		stride = s
+	}
+	var items []string
+	from, errFrom := strconv.Atoi(parts[0])
+	to, errTo := strconv.Atoi(parts[1])
+	switch {
+	case errFrom == nil && errTo == nil:
+		format := "%d"
+		if len(parts[0]) > 1 && strings.HasPrefix(parts[0], "0") {
+			format = fmt.Sprintf("%%0%dd", len(parts[0]))
+		}
+		for i := from; i <= to && len(items) <= expandLimit; i += stride {
+			items = append(items, fmt.Sprintf(format, i))
+		}
+	case isLetter(parts[0]) && isLetter(parts[1]):
+		for c := int(parts[0][0]); c <= int(parts[1][0]); c += stride {
+			items = append(items, string(rune(c)))
+		}
+	default:
+		return nil, fmt.Errorf("invalid host range %q", pattern)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("host range %q ends before it starts", pattern)
+	}
+	var hosts []string
+	for _, item := range items {
+		rest, err := expandInventoryPattern(suffix)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rest {
+			hosts = append(hosts, prefix+item+r)
+		}
+		if len(hosts) > expandLimit {
+			return nil, fmt.Errorf("%s expands to more than %d hosts", pattern, expandLimit)
+		}
+	}
+	return hosts, nil
+}
+
+// isLetter reports whether s is a single ASCII letter.
+func isLetter(s string) bool {
+	return len(s) == 1 && ('a' <= s[0] && s[0] <= 'z' || 'A' <= s[0] && s[0] <= 'Z')
+}
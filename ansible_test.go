@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeInventory writes an inventory named name and returns its path.
+func writeInventory(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadInventoryINI(t *testing.T) {
+	path := writeInventory(t, "hosts", `# Lab inventory
+mail.example.com
+
+[webservers]
+web[01:02].example.com
+db01 ansible_host=10.0.5.21 ansible_user="deploy"  # primary
+
+[dbservers]
+db01
+backup.example.com:2222
+
+[prod:children]
+webservers
+dbservers
+
+[prod:vars]
+ntp_server=ntp.example.com
+`)
+	hosts, l, err := readInventory(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mail.example.com", "web01.example.com", "web02.example.com", "10.0.5.21", "backup.example.com"}, hosts)
+	assert.Equal(t, map[string]HostLabel{
+		"mail.example.com":   {},
+		"web01.example.com":  {Groups: []string{"prod", "webservers"}},
+		"web02.example.com":  {Groups: []string{"prod", "webservers"}},
+		"10.0.5.21":          {Name: "db01", Groups: []string{"dbservers", "prod", "webservers"}},
+		"backup.example.com": {Groups: []string{"dbservers", "prod"}},
+	}, l)
+
+	_, _, err = readInventory(writeInventory(t, "hosts", "[web]\nweb01 ansible_host\n"))
+	assert.ErrorContains(t, err, "line 2")
+	_, _, err = readInventory(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestReadInventoryYAML(t *testing.T) {
+	path := writeInventory(t, "hosts.yml", `all:
+  hosts:
+    mail.example.com:
+  children:
+    prod:
+      children:
+        webservers:
+          hosts:
+            web[01:02].example.com:
+            db01: {ansible_host: 10.0.5.21}
+          vars:
+            http_port: 80
+        dbservers:
+          hosts:
+            db01:
+`)
+	hosts, l, err := readInventory(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mail.example.com", "web01.example.com", "web02.example.com", "10.0.5.21"}, hosts)
+	assert.Equal(t, HostLabel{Name: "db01", Groups: []string{"dbservers", "prod", "webservers"}}, l["10.0.5.21"])
+	assert.Equal(t, HostLabel{Groups: []string{"prod", "webservers"}}, l["web01.example.com"])
+
+	_, _, err = readInventory(writeInventory(t, "hosts.yaml", "web:\n  host: [web01]\n"))
+	assert.ErrorContains(t, err, `unknown key "host"`)
+}
+
+func TestExpandInventoryPattern(t *testing.T) {
+	for pattern, want := range map[string][]string{
+		"web[01:03].lab": {"web01.lab", "web02.lab", "web03.lab"},
+		"db-[a:c]":       {"db-a", "db-b", "db-c"},
+		"node[1:9:4]":    {"node1", "node5", "node9"},
+		"r[1:2]-[a:b]":   {"r1-a", "r1-b", "r2-a", "r2-b"},
+		"[2001:db8::1]":  {"[2001:db8::1]"},
+		"plain.lab":      {"plain.lab"},
+	} {
+		got, err := expandInventoryPattern(pattern)
+		assert.NoError(t, err, pattern)
+		assert.Equal(t, want, got, pattern)
+	}
+	for _, bad := range []string{"web[3:1]", "web[1:x]", "web[1:5:0]", "web[0:5000]"} {
+		_, err := expandInventoryPattern(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestReadHostsInventory(t *testing.T) {
+	defer func(f string) { inventoryFile = f }(inventoryFile)
+	inventoryFile = writeInventory(t, "hosts", "[core]\nrouter\nsw1 ansible_host=10.0.5.2\n")
+	hosts, fileLabels, err := readHosts("", "nas")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"router", "10.0.5.2", "nas"}, hosts)
+	assert.Equal(t, map[string]HostLabel{"router": {Groups: []string{"core"}}, "10.0.5.2": {Name: "sw1", Groups: []string{"core"}}}, fileLabels)
+}
+
+func TestInventoryGroups(t *testing.T) {
+	defer setLabels(labels())
+	setLabels(map[string]HostLabel{"10.0.5.21": {Groups: []string{"dbservers", "prod"}}})
+	assert.True(t, matchGroup("@prod", "10.0.5.21"))
+	assert.False(t, matchGroup("@web", "10.0.5.21"))
+	assert.False(t, matchGroup("@prod", "10.0.5.22"))
+	assert.Equal(t, []string{"group:dbservers", "group:prod", "net:10.0.5.0/24"}, correlationKeys("10.0.5.21"))
+	assert.Equal(t, `"db01" @dbservers @prod`, HostLabel{Name: "db01", Groups: []string{"dbservers", "prod"}}.String())
+}
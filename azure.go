@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// azureSubscriptions is the -azure-subscription value: the
+	// comma-separated Azure subscription IDs whose VMs are monitored. Empty
+	// turns Azure discovery off.
+	azureSubscriptions string
+	// azureTag is the -azure-tag value: "key" or "key=value" of the tag a
+	// VM must carry to be monitored, or "" for all VMs.
+	azureTag string
+	// azurePublic monitors the public IPs of VMs instead of their private
+	// ones, set with -azure-public.
+	azurePublic bool
+)
+
+var (
+	// azureManagementURL is the base URL of Azure Resource Manager, a
+	// variable to allow mocking in tests.
+	azureManagementURL = "https://management.azure.com"
+	// azureLoginURL is the base URL of Microsoft Entra ID, a variable to
+	// allow mocking in tests.
+	azureLoginURL = "https://login.microsoftonline.com"
+)
+
+// azureProvider is the VMs of an Azure subscription.
+type azureProvider struct {
+	subscription string
+	token        *bearerToken
+	http         *http.Client
+}
+
+// azureProviders returns a provider for each -azure-subscription.
+func azureProviders() []Discovery {
+	client := &http.Client{Timeout: time.Minute}
+	token := &bearerToken{fetch: func(ctx context.Context) (string, time.Duration, error) {
+		return azureToken(ctx, client)
+	}}
+	var providers []Discovery
+	for _, s := range strings.Split(azureSubscriptions, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			providers = append(providers, azureProvider{subscription: s, token: token, http: client})
+		}
+	}
+	return providers
+}
+
+// String implements Discovery.
+func (p azureProvider) String() string { return "Azure " + p.subscription }
+
+// azureToken gets an access token for Azure Resource Manager for the
+// service principal given by AZURE_TENANT_ID, AZURE_CLIENT_ID and
+// AZURE_CLIENT_SECRET, or else for the managed identity of the VM mosaic
+// runs on.
+func azureToken(ctx context.Context, client *http.Client) (string, time.Duration, error) {
+	var req *http.Request
+	var err error
+	if tenant := os.Getenv("AZURE_TENANT_ID"); tenant != "" {
+		form := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {os.Getenv("AZURE_CLIENT_ID")},
+			"client_secret": {os.Getenv("AZURE_CLIENT_SECRET")},
+			"scope":         {azureManagementURL + "/.default"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, azureLoginURL+"/"+url.PathEscape(tenant)+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureManagementURL + "/"}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, imdsURL+"/metadata/identity/oauth2/token?"+query.Encode(), nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata", "true")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("no AZURE_TENANT_ID and no managed identity: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("Azure token: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var t struct {
+		AccessToken string          `json:"access_token"`
+		ExpiresIn   json.RawMessage `json:"expires_in"` // A number, or a string from managed identities
+	}
+	if err := json.Unmarshal(body, &t); err != nil {
+		return "", 0, fmt.Errorf("Azure token: %v", err)
+	}
+	seconds, _ := strconv.Atoi(strings.Trim(string(t.ExpiresIn), `"`))
+	return t.AccessToken, time.Duration(seconds) * time.Second, nil
+}
+
+// get lists the resources at path in the subscription, following
+// nextLink, and calls each with the raw JSON of every resource.
+func (p azureProvider) get(ctx context.Context, path string, query url.Values, each func(json.RawMessage) error) error {
+	u := azureManagementURL + "/subscriptions/" + url.PathEscape(p.subscription) + path + "?" + query.Encode()
+	for u != "" {
+		token, err := p.token.get(ctx)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := p.http.Do(req)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Value    []json.RawMessage `json:"value"`
+			NextLink string            `json:"nextLink"`
+			Error    struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			if page.Error.Code != "" {
+				return fmt.Errorf("%s: %s", page.Error.Code, page.Error.Message)
+			}
+			return errors.New(resp.Status)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid response to %s: %v", path, err)
+		}
+		for _, v := range page.Value {
+			if err := each(v); err != nil {
+				return fmt.Errorf("invalid response to %s: %v", path, err)
+			}
+		}
+		u = page.NextLink
+	}
+	return nil
+}
+
+// azureVM is the part of an Azure VM mosaic reads.
+type azureVM struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Location   string            `json:"location"`
+	Tags       map[string]string `json:"tags"`
+	Properties struct {
+		NetworkProfile struct {
+			NetworkInterfaces []struct {
+				ID string `json:"id"`
+			} `json:"networkInterfaces"`
+		} `json:"networkProfile"`
+		InstanceView struct {
+			Statuses []struct {
+				Code string `json:"code"`
+			} `json:"statuses"`
+		} `json:"instanceView"`
+	} `json:"properties"`
+}
+
+// running reports whether the power state of vm is running.
+func (vm azureVM) running() bool {
+	for _, s := range vm.Properties.InstanceView.Statuses {
+		if s.Code == "PowerState/running" {
+			return true
+		}
+	}
+	return false
+}
+
+// tagged reports whether vm carries -azure-tag.
+func (vm azureVM) tagged() bool {
+	if azureTag == "" {
+		return true
+	}
+	key, value, hasValue := strings.Cut(azureTag, "=")
+	v, ok := vm.Tags[key]
+	return ok && (!hasValue || v == value)
+}
+
+// List implements Discovery: the running VMs of the subscription carrying
+// -azure-tag keyed by resource ID, as logical hosts named after the VM with
+// the private IP of its first network interface, or the public IP with
+// -azure-public, in the groups "azure" and "azure/<location>".
+func (p azureProvider) List(ctx context.Context) (map[string]discovered, error) {
+	var vms []azureVM
+	err := p.get(ctx, "/providers/Microsoft.Compute/virtualMachines", url.Values{"api-version": {"2024-03-01"}, "statusOnly": {"true"}}, func(raw json.RawMessage) error {
+		var vm azureVM
+		if err := json.Unmarshal(raw, &vm); err != nil {
+			return err
+		}
+		if vm.running() && vm.tagged() && len(vm.Properties.NetworkProfile.NetworkInterfaces) > 0 {
+			vms = append(vms, vm)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Resource IDs are case-insensitive
+	nics := make(map[string]struct{ private, publicID string })
+	err = p.get(ctx, "/providers/Microsoft.Network/networkInterfaces", url.Values{"api-version": {"2023-09-01"}}, func(raw json.RawMessage) error {
+		var nic struct {
+			ID         string `json:"id"`
+			Properties struct {
+				IPConfigurations []struct {
+					Properties struct {
+						Primary          bool   `json:"primary"`
+						PrivateIPAddress string `json:"privateIPAddress"`
+						PublicIPAddress  struct {
+							ID string `json:"id"`
+						} `json:"publicIPAddress"`
+					} `json:"properties"`
+				} `json:"ipConfigurations"`
+			} `json:"properties"`
+		}
+		if err := json.Unmarshal(raw, &nic); err != nil {
+			return err
+		}
+		for i, c := range nic.Properties.IPConfigurations {
+			if c.Properties.Primary || i == 0 {
+				nics[strings.ToLower(nic.ID)] = struct{ private, publicID string }{c.Properties.PrivateIPAddress, strings.ToLower(c.Properties.PublicIPAddress.ID)}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	publicIPs := make(map[string]string)
+	if azurePublic {
+		err = p.get(ctx, "/providers/Microsoft.Network/publicIPAddresses", url.Values{"api-version": {"2023-09-01"}}, func(raw json.RawMessage) error {
+			var ip struct {
+				ID         string `json:"id"`
+				Properties struct {
+					IPAddress string `json:"ipAddress"`
+				} `json:"properties"`
+			}
+			if err := json.Unmarshal(raw, &ip); err != nil {
+				return err
+			}
+			publicIPs[strings.ToLower(ip.ID)] = ip.Properties.IPAddress
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hosts := make(map[string]discovered)
+	for _, vm := range vms {
+		nic := nics[strings.ToLower(vm.Properties.NetworkProfile.NetworkInterfaces[0].ID)]
+		addr := nic.private
+		if azurePublic {
+			addr = publicIPs[nic.publicID]
+		}
+		if addr != "" {
+			hosts[strings.ToLower(vm.ID)] = discovered{entry: logicalEntry(hostLabel(vm.Name), []string{addr}), groups: cloudGroups("azure", vm.Location)}
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAzureList(t *testing.T) {
+	t.Setenv("AZURE_TENANT_ID", "")
+	oldManagement, oldIMDS, oldTag, oldPublic := azureManagementURL, imdsURL, azureTag, azurePublic
+	t.Cleanup(func() { azureManagementURL, imdsURL, azureTag, azurePublic = oldManagement, oldIMDS, oldTag, oldPublic })
+	azureTag = "env=prod"
+
+	const sub = "/subscriptions/0000-1111"
+	const rg = sub + "/resourceGroups/shop"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/identity/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get("Metadata"))
+		fmt.Fprint(w, `{"access_token":"eyJ0","expires_in":"86399"}`)
+	})
+	var srvURL string
+	mux.HandleFunc(sub+"/providers/Microsoft.Compute/virtualMachines", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer eyJ0", r.Header.Get("Authorization"))
+		assert.Equal(t, "true", r.URL.Query().Get("statusOnly"))
+		running := `"instanceView":{"statuses":[{"code":"ProvisioningState/succeeded"},{"code":"PowerState/running"}]}`
+		if r.URL.Query().Get("page") == "" {
+			fmt.Fprintf(w, `{"value":[
+				{"id":"%[1]s/providers/Microsoft.Compute/virtualMachines/web-1","name":"web-1","location":"westeurope","tags":{"env":"prod"},
+				 "properties":{"networkProfile":{"networkInterfaces":[{"id":"%[1]s/providers/Microsoft.Network/networkInterfaces/WEB-1-NIC"}]},%[2]s}},
+				{"id":"%[1]s/providers/Microsoft.Compute/virtualMachines/lab","name":"lab","location":"westeurope","tags":{"env":"dev"},
+				 "properties":{"networkProfile":{"networkInterfaces":[{"id":"%[1]s/providers/Microsoft.Network/networkInterfaces/lab-nic"}]},%[2]s}}],
+				"nextLink":"%[3]s%[4]s/providers/Microsoft.Compute/virtualMachines?statusOnly=true&page=2"}`, rg, running, srvURL, sub)
+			return
+		}
+		fmt.Fprintf(w, `{"value":[{"id":"%s/providers/Microsoft.Compute/virtualMachines/db-1","name":"db-1","location":"northeurope","tags":{"env":"prod"},
+			"properties":{"networkProfile":{"networkInterfaces":[{"id":"%[1]s/providers/Microsoft.Network/networkInterfaces/db-1-nic"}]},
+			"instanceView":{"statuses":[{"code":"PowerState/deallocated"}]}}}]}`, rg)
+	})
+	mux.HandleFunc(sub+"/providers/Microsoft.Network/networkInterfaces", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"value":[{"id":"%[1]s/providers/Microsoft.Network/networkInterfaces/web-1-nic","properties":{"ipConfigurations":[
+			{"properties":{"primary":true,"privateIPAddress":"10.1.0.4","publicIPAddress":{"id":"%[1]s/providers/Microsoft.Network/publicIPAddresses/web-1-ip"}}}]}}]}`, rg)
+	})
+	mux.HandleFunc(sub+"/providers/Microsoft.Network/publicIPAddresses", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"value":[{"id":"%s/providers/Microsoft.Network/publicIPAddresses/web-1-ip","properties":{"ipAddress":"20.1.2.3"}}]}`, rg)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	srvURL = srv.URL
+	azureManagementURL, imdsURL = srv.URL, srv.URL
+
+	oldSubscriptions := azureSubscriptions
+	t.Cleanup(func() { azureSubscriptions = oldSubscriptions })
+	azureSubscriptions = "0000-1111"
+	p := azureProviders()[0]
+	hosts, err := p.List(context.Background())
+	assert.NoError(t, err)
+	id := "/subscriptions/0000-1111/resourcegroups/shop/providers/microsoft.compute/virtualmachines/web-1"
+	assert.Equal(t, map[string]discovered{id: {entry: "web-1=10.1.0.4", groups: []string{"azure", "azure/westeurope"}}}, hosts,
+		"only running VMs with the tag, NICs matched regardless of case")
+
+	azurePublic = true
+	hosts, err = p.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "web-1=20.1.2.3", hosts[id].entry)
+}
+
+func TestAzureListError(t *testing.T) {
+	t.Setenv("AZURE_TENANT_ID", "")
+	oldManagement, oldIMDS := azureManagementURL, imdsURL
+	t.Cleanup(func() { azureManagementURL, imdsURL = oldManagement, oldIMDS })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metadata/identity/oauth2/token" {
+			fmt.Fprint(w, `{"access_token":"eyJ0","expires_in":"86399"}`)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":{"code":"AuthorizationFailed","message":"no read access"}}`)
+	}))
+	defer srv.Close()
+	azureManagementURL, imdsURL = srv.URL, srv.URL
+
+	p := azureProvider{subscription: "s", token: &bearerToken{fetch: func(ctx context.Context) (string, time.Duration, error) {
+		return azureToken(ctx, srv.Client())
+	}}, http: srv.Client()}
+	_, err := p.List(context.Background())
+	assert.ErrorContains(t, err, "AuthorizationFailed: no read access")
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// backupFormat is the version of the /api/backup archive layout, recorded
+// in its manifest. "mosaic restore" refuses archives of a newer one.
+const backupFormat = 1
+
+// The files of a backup archive.
+const (
+	backupManifest = "manifest.json"
+	backupConfig   = "config.yaml"
+	backupHistory  = "history.jsonl"
+)
+
+// backupMaxLine is the longest line of history.jsonl "mosaic restore"
+// reads; a result takes a few hundred bytes at most.
+const backupMaxLine = 1 << 20
+
+// BackupManifest describes a backup archive as its manifest.json.
+type BackupManifest struct {
+	Format   int       `json:"format"`
+	Created  time.Time `json:"created"`
+	Instance string    `json:"instance,omitempty"` // -history-instance of the backed up mosaic
+	Backend  string    `json:"backend"`            // Its -history-backend, or "memory" without -history-db
+	Hosts    int       `json:"hosts"`              // Hosts in config.yaml
+	Results  int       `json:"results"`            // Lines in history.jsonl
+}
+
+// backupHandler streams a zip archive of the running configuration and the
+// whole stored history, for "mosaic restore" to load on another machine.
+// It needs the -api-token, since host entries may carry credentials. The
+// history is read a page at a time and written as it is read, so it takes
+// little memory however long it is.
+//
+//	GET /api/backup
+func backupHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireToken(w, r, "/api/backup") {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c := currentConfig()
+	config, err := yaml.Marshal(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="mosaic-backup-%s.zip"`, now.UTC().Format("20060102-150405")))
+	m := BackupManifest{Format: backupFormat, Created: now, Instance: historyInstance, Backend: historyBackend, Hosts: len(c.Hosts)}
+	if historyDB == "" {
+		m.Backend = "memory"
+	}
+	// The status is sent; a failure can only cut the archive short, which
+	// "mosaic restore" then refuses as corrupt
+	if err := writeBackup(w, config, &m, now); err != nil {
+		log.Printf("Backup: %v", err)
+	}
+}
+
+// writeBackup writes a backup archive to out: config, the stored results
+// up to now, and m, counting the results, last.
+func writeBackup(out io.Writer, config []byte, m *BackupManifest, now time.Time) error {
+	zw := zip.NewWriter(out)
+	f, err := zw.Create(backupConfig)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, inventoryHeader, now.Format(time.RFC3339))
+	if _, err := f.Write(config); err != nil {
+		return err
+	}
+	if f, err = zw.Create(backupHistory); err != nil {
+		return err
+	}
+	if history != nil {
+		enc := json.NewEncoder(f)
+		err = history.store.each("", time.Time{}, now, func(res HistoryResult) error {
+			m.Results++
+			return enc.Encode(res)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if f, err = zw.Create(backupManifest); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(m); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// runRestore implements "mosaic restore": it loads the history of a backup
+// archive into a -history-db and writes its configuration to a -config
+// file, for the mosaic started with them afterwards. It must not run on a
+// database a running mosaic has open.
+//
+// Parameters:
+//   - args: Command-line arguments following "restore"
+//   - w: Where to report what was restored
+//
+// Returns:
+//   - error: Invalid flags, an unreadable archive, or a target that
+//     already has data and no -force
+func runRestore(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.StringVar(&historyDB, "history-db", "", "Database to load the history of the backup into")
+	fs.StringVar(&historyBackend, "history-backend", historyBackend, "Storage of -history-db: sqlite, bolt, postgres or rrd")
+	fs.StringVar(&historyInstance, "history-instance", "", "Name of the mosaic the history is loaded for in a shared -history-db (default the hostname)")
+	fs.DurationVar(&pingInterval, "interval", pingInterval, "Time between ping cycles, which sizes new rrd files")
+	fs.DurationVar(&historyRetention, "history-retention", historyRetention, "How long results are kept as they are, which sizes new rrd files")
+	fs.Var(&historyDownsample, "history-downsample", "Downsampling tiers, which size new rrd files")
+	configOut := fs.String("config", "", "YAML file to write the configuration of the backup to")
+	force := fs.Bool("force", false, "Overwrite an existing -config file and add to a -history-db that already has results")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || (historyDB == "" && *configOut == "") {
+		return errors.New("usage: mosaic restore [-history-db path] [-config file] [-force] backup.zip")
+	}
+	zr, err := zip.OpenReader(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	var m BackupManifest
+	if err := readBackupFile(&zr.Reader, backupManifest, func(r io.Reader) error { return json.NewDecoder(r).Decode(&m) }); err != nil {
+		return err
+	}
+	if m.Format > backupFormat {
+		return fmt.Errorf("backup format %d is newer than this mosaic reads (%d)", m.Format, backupFormat)
+	}
+	if *configOut != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if *force {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		err := readBackupFile(&zr.Reader, backupConfig, func(r io.Reader) error {
+			f, err := os.OpenFile(*configOut, flags, 0o600)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, r); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		})
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s exists, restore with -force to overwrite it", *configOut)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Wrote the configuration of %d hosts to %s\n", m.Hosts, *configOut)
+	}
+	if historyDB == "" {
+		return nil
+	}
+	n, err := restoreHistory(&zr.Reader, *force)
+	if err != nil {
+		return fmt.Errorf("%s: %v", historyDB, err)
+	}
+	fmt.Fprintf(w, "Restored %d results to %s\n", n, historyDB)
+	return nil
+}
+
+// readBackupFile calls fn with the content of the file name of a backup
+// archive.
+func readBackupFile(zr *zip.Reader, name string, fn func(io.Reader) error) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("not a mosaic backup: %v", err)
+	}
+	defer f.Close()
+	if err := fn(f); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// restoreHistory writes the results of a backup archive to -history-db, in
+// batches of historyPage. Without force, it refuses a database that
+// already has results, since restoring twice would store them twice.
+//
+// Returns:
+//   - int: The results written
+//   - error: If the database cannot be opened or written, or has results
+func restoreHistory(zr *zip.Reader, force bool) (int, error) {
+	if embeddedBuild {
+		return 0, errors.New("not supported in the embedded build")
+	}
+	open, ok := historyBackends[historyBackend]
+	if !ok {
+		return 0, fmt.Errorf("unknown history backend %q", historyBackend)
+	}
+	if historyInstance == "" {
+		historyInstance, _ = os.Hostname()
+	}
+	store, err := open(historyDB)
+	if err != nil {
+		return 0, err
+	}
+	defer store.close()
+	if !force {
+		if s, err := store.summarize("", time.Time{}, time.Now().Add(24*time.Hour)); err != nil {
+			return 0, err
+		} else if s.Results > 0 {
+			return 0, fmt.Errorf("has %d results already, restore with -force to add to them", s.Results)
+		}
+	}
+	n := 0
+	err = readBackupFile(zr, backupHistory, func(r io.Reader) error {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, backupMaxLine)
+		batch := make([]HistoryResult, 0, historyPage)
+		for sc.Scan() {
+			var res HistoryResult
+			if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+				return fmt.Errorf("line %d: %v", n+len(batch)+1, err)
+			}
+			if batch = append(batch, res); len(batch) == historyPage {
+				if err := store.write(batch); err != nil {
+					return err
+				}
+				n, batch = n+len(batch), batch[:0]
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			if err := store.write(batch); err != nil {
+				return err
+			}
+			n += len(batch)
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRestore(t *testing.T) {
+	withHosts(t, "10.0.0.1", "10.0.0.2")
+	defer func(h *resultHistory) { history = h }(history)
+	defer func(db, backend, instance string, interval, retention time.Duration, tiers historyTiers) {
+		historyDB, historyBackend, historyInstance = db, backend, instance
+		pingInterval, historyRetention, historyDownsample = interval, retention, tiers
+	}(historyDB, historyBackend, historyInstance, pingInterval, historyRetention, historyDownsample)
+	path := testHistoryPath(t, "sqlite")
+	s := newMemoryStore(10)
+	history = &resultHistory{store: s}
+	at := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	stored := []HistoryResult{
+		{Time: at.Add(-48 * time.Hour), Host: "10.0.0.1", LatencyMs: 14, PacketLoss: 50, State: "degraded", Samples: 60, Up: 30},
+		{Time: at, Host: "10.0.0.1", LatencyMs: 12, State: "up"},
+		{Time: at, Host: "10.0.0.2", PacketLoss: 100, State: "down"},
+	}
+	s.write(stored)
+
+	w := httptest.NewRecorder()
+	backupHandler(w, httptest.NewRequest(http.MethodGet, "/api/backup", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code, "off without -api-token")
+	withAPIToken(t)
+	w = httptest.NewRecorder()
+	backupHandler(w, httptest.NewRequest(http.MethodGet, "/api/backup", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	backupHandler(w, hostsRequest(http.MethodGet, "/api/backup", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "backup.zip")
+	if err := os.WriteFile(archive, w.Body.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "hosts.yaml")
+	var out bytes.Buffer
+	err := runRestore([]string{"-history-db", path, "-config", config, archive}, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Restored 3 results")
+
+	c, err := readConfigFile(config)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, c.Hosts)
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	got, err := store.query("10.0.0.1", time.Time{}, time.Now(), historyLimit)
+	assert.NoError(t, err)
+	if assert.Len(t, got, 2) {
+		assert.True(t, got[0].Time.Equal(stored[0].Time))
+		assert.Equal(t, 60, got[0].Samples, "downsampled results keep their weight")
+		assert.Equal(t, 30, got[0].Up)
+		assert.Equal(t, 12, got[1].LatencyMs)
+	}
+	got, err = store.query("10.0.0.2", time.Time{}, time.Now(), historyLimit)
+	assert.NoError(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "down", got[0].State)
+	}
+
+	// Restoring twice would store everything twice
+	err = runRestore([]string{"-history-db", path, archive}, &out)
+	assert.ErrorContains(t, err, "-force")
+	err = runRestore([]string{"-config", config, archive}, &out)
+	assert.ErrorContains(t, err, "-force")
+	assert.NoError(t, runRestore([]string{"-config", config, "-force", archive}, &out))
+
+	os.WriteFile(archive, []byte("not a zip"), 0o600)
+	assert.Error(t, runRestore([]string{"-config", filepath.Join(dir, "other.yaml"), archive}, &out))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// benchRound holds the latencies measured for the failed hosts of one round.
+type benchRound struct {
+	detect   []int // Milliseconds from failure until a probe reported the host down
+	dispatch []int // Milliseconds from failure until the result was broadcast
+}
+
+// runBench implements "mosaic bench": it monitors a fleet of simulated hosts
+// with the regular ping cycle, fails some of them at a random point within a
+// cycle and measures how long it takes until the failure is detected by a
+// probe and until it is dispatched to dashboard clients.
+//
+// Parameters:
+//   - args: Command-line arguments following "bench"
+//
+// Returns:
+//   - error: Invalid flags, or the p95 dispatch latency exceeding -target
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fleet := fs.Int("hosts", 1000, "Number of simulated hosts")
+	fail := fs.Int("fail", 10, "Hosts failed per round")
+	rounds := fs.Int("rounds", 5, "Number of failure rounds")
+	fs.DurationVar(&pingInterval, "interval", pingInterval, "Time between ping cycles")
+	fs.DurationVar(&simulation.latency, "probe-latency", simulation.latency, "Simulated probe round-trip time")
+	target := fs.Duration("target", 0, "Fail if the p95 dispatch latency exceeds this (0 disables)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *fleet < 1 || *fail < 1 || *fail > *fleet || *rounds < 1 {
+		return fmt.Errorf("bench: need 1 <= -fail <= -hosts and -rounds >= 1")
+	}
+
+	hostsMu.Lock()
+	hosts = hosts[:0]
+	for i := 0; i < *fleet; i++ {
+		hosts = append(hosts, fmt.Sprintf("sim://host-%05d", i))
+	}
+	hostsMu.Unlock()
+
+	results := make([]benchRound, *rounds)
+	for r := range results {
+		results[r] = benchFailRound(*fleet, *fail)
+	}
+	p95 := printBench(os.Stdout, results, *fleet, *fail)
+	if *target > 0 && p95 > *target {
+		return fmt.Errorf("bench: p95 dispatch latency %v exceeds target %v", p95, *target)
+	}
+	return nil
+}
+
+// benchFailRound fails n random hosts of the simulated fleet at a random
+// point of the next cycle and runs cycles until all of them were dispatched.
+func benchFailRound(fleet, n int) benchRound {
+	victims := make(map[string]bool, n)
+	pending := make(map[string]bool, n)
+	for _, i := range rand.Perm(fleet)[:n] {
+		victims[fmt.Sprintf("host-%05d", i)] = true
+		pending[fmt.Sprintf("host-%05d", i)] = true
+	}
+
+	var mu sync.Mutex
+	var failedAt time.Time
+	detected := make(map[string]time.Time, n)
+	saved := probers["sim"]
+	probers["sim"] = func(addr string) probeResult {
+		res := saved(addr)
+		name, _ := splitOptions(addr)
+		if res.Recv == 0 && victims[name] {
+			mu.Lock()
+			if _, ok := detected[name]; !ok {
+				detected[name] = time.Now()
+			}
+			mu.Unlock()
+		}
+		return res
+	}
+	defer func() { probers["sim"] = saved }()
+
+	time.AfterFunc(time.Duration(rand.Int63n(int64(pingInterval))), func() {
+		mu.Lock()
+		defer mu.Unlock()
+		failedAt = time.Now()
+		for name := range victims {
+			simulation.setDown(name, true)
+		}
+	})
+
+	var round benchRound
+	for len(round.dispatch) < n {
+		runCycle(context.Background(), false)
+		dispatched := time.Now()
+		mu.Lock()
+		for name, at := range detected {
+			if !pending[name] {
+				continue
+			}
+			delete(pending, name)
+			round.detect = append(round.detect, int(at.Sub(failedAt).Milliseconds()))
+			round.dispatch = append(round.dispatch, int(dispatched.Sub(failedAt).Milliseconds()))
+		}
+		mu.Unlock()
+		time.Sleep(scheduler.endCycle(pingInterval, time.Now()))
+	}
+
+	mu.Lock()
+	for name := range detected {
+		simulation.setDown(name, false)
+	}
+	mu.Unlock()
+	return round
+}
+
+// printBench writes a per-round and overall summary to w and returns the
+// overall p95 dispatch latency.
+func printBench(w io.Writer, results []benchRound, fleet, fail int) time.Duration {
+	fmt.Fprintf(w, "fleet %d hosts, %d failed per round, interval %v\n", fleet, fail, pingInterval)
+	fmt.Fprintf(w, "%-6s %21s %21s\n", "round", "detect p50/max (ms)", "dispatch p50/max (ms)")
+	var detect, dispatch []int
+	for i, r := range results {
+		sort.Ints(r.detect)
+		sort.Ints(r.dispatch)
+		fmt.Fprintf(w, "%-6d %10d/%-10d %10d/%-10d\n", i+1,
+			percentile(r.detect, 50), r.detect[len(r.detect)-1],
+			percentile(r.dispatch, 50), r.dispatch[len(r.dispatch)-1])
+		detect = append(detect, r.detect...)
+		dispatch = append(dispatch, r.dispatch...)
+	}
+	sort.Ints(detect)
+	sort.Ints(dispatch)
+	fmt.Fprintf(w, "detect   p50 %dms p95 %dms max %dms\n", percentile(detect, 50), percentile(detect, 95), detect[len(detect)-1])
+	fmt.Fprintf(w, "dispatch p50 %dms p95 %dms max %dms\n", percentile(dispatch, 50), percentile(dispatch, 95), dispatch[len(dispatch)-1])
+	return time.Duration(percentile(dispatch, 95)) * time.Millisecond
+}
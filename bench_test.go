@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunBench(t *testing.T) {
+	defer func(saved []string) { hosts = saved }(hosts)
+	defer func(saved *simulator) { simulation = saved }(simulation)
+	defer func(saved time.Duration) { pingInterval = saved }(pingInterval)
+	simulation = newSimulator()
+
+	args := []string{"-hosts", "20", "-fail", "3", "-rounds", "2", "-interval", "20ms", "-probe-latency", "1ms"}
+	assert.NoError(t, runBench(args))
+	assert.Len(t, hosts, 20)
+	assert.Empty(t, simulation.down, "failed hosts are restored after each round")
+
+	err := runBench(append(args, "-target", "1ns"))
+	assert.ErrorContains(t, err, "exceeds target")
+	assert.Error(t, runBench([]string{"-hosts", "2", "-fail", "3"}))
+}
+
+func TestPrintBench(t *testing.T) {
+	var buf bytes.Buffer
+	p95 := printBench(&buf, []benchRound{
+		{detect: []int{10, 30}, dispatch: []int{20, 40}},
+		{detect: []int{50}, dispatch: []int{60}},
+	}, 100, 2)
+	assert.Equal(t, 60*time.Millisecond, p95)
+	assert.Contains(t, buf.String(), "dispatch p50 40ms p95 60ms max 60ms")
+}
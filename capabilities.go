@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// wsProtocolVersion is the version of the WebSocket message format. It is
+// raised when messages change in a way old clients can't handle.
+const wsProtocolVersion = 1
+
+// topicCapabilities is the topic of the message sent to every client that
+// chose its topics as soon as it connects. It cannot be subscribed to.
+const topicCapabilities = "capabilities"
+
+// Capabilities describes what this mosaic instance supports, so the
+// dashboard and third-party clients can enable features at runtime instead
+// of assuming them. Features missing from Features are not available.
+type Capabilities struct {
+	Protocol int              `json:"protocol"` // WebSocket message format version
+	Topics   []string         `json:"topics"`   // Topics that can be subscribed to
+	Features map[string]bool  `json:"features"`
+	Limits   CapabilityLimits `json:"limits"`
+	PingMode PingMode         `json:"ping_mode"`
+}
+
+// CapabilityLimits are the limits a client should respect or show.
+type CapabilityLimits struct {
+	IntervalMs     float64 `json:"interval_ms"`      // Time between ping cycles
+	TimeoutMs      float64 `json:"timeout_ms"`       // Default probe timeout
+	MaxPPS         int     `json:"max_pps"`          // Outbound packet rate limit, 0 for none
+	Events         int     `json:"events"`           // Events retained by /api/events
+	DeadLetters    int     `json:"dead_letters"`     // Undeliverable notifications retained
+	MaxConfigBytes int     `json:"max_config_bytes"` // Largest body /api/config/reload accepts
+}
+
+// capabilities returns the capabilities of the running instance.
+func capabilities() Capabilities {
+	s := currentSettings()
+	macsMu.RLock()
+	wol := len(hostMACs) > 0
+	macsMu.RUnlock()
+	notifications.mu.Lock()
+	notify := len(notifications.queues) > 0
+	notifications.mu.Unlock()
+	demoMu.Lock()
+	demo := demoEnabled
+	demoMu.Unlock()
+
+	features := map[string]bool{
+		"runtime_hosts":  !embeddedBuild,                   // /api/hosts
+		"config_reload":  !embeddedBuild,                   // /api/config/reload and /api/config/export
+		"config_api":     !embeddedBuild && apiToken != "", // /api/config
+		"correlation":    !embeddedBuild,                   // Correlated incidents in status messages
+		"sla":            !embeddedBuild,                   // /api/sla
+		"thresholds":     !embeddedBuild,                   // /api/thresholds
+		"events":         !embeddedBuild,                   // /api/events
+		"maintenance":    !embeddedBuild,                   // /api/maintenance
+		"history":        history != nil,                   // /api/history
+		"incidents":      history != nil,                   // /api/incidents
+		"backup":         history != nil && apiToken != "", // /api/backup
+		"demo":           demo,
+		"wol":            wol,
+		"notifications":  notify,
+		"self_tile":      selfTile,
+		"tunnels":        len(tunnels) > 0,
+		"dual_stack":     dualStack,
+		"dscp":           probeDSCP > 0,
+		"neighbor_watch": watchNeighbors,
+		"shared_icmp":    icmpShared,
+		"dns_refresh":    dnsRefresh,
+	}
+	for name, on := range features {
+		if !on {
+			delete(features, name)
+		}
+	}
+	return Capabilities{
+		Protocol: wsProtocolVersion,
+		Topics:   wsTopics,
+		Features: features,
+		Limits: CapabilityLimits{
+			IntervalMs:     msFloat(s.Interval),
+			TimeoutMs:      msFloat(s.Timeout),
+			MaxPPS:         packetLimit.rate(),
+			Events:         eventLogSize,
+			DeadLetters:    deadLetterSize,
+			MaxConfigBytes: maxConfigBytes,
+		},
+		PingMode: currentPingMode(),
+	}
+}
+
+// capabilitiesHandler serves the capabilities as JSON, for clients that
+// don't use the WebSocket.
+func capabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(capabilities())
+}
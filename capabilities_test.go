@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func TestCapabilities(t *testing.T) {
+	defer func(d bool) { demoEnabled = d }(demoEnabled)
+	defer func(m map[string]net.HardwareAddr) { hostMACs = m }(hostMACs)
+	demoEnabled = false
+	hostMACs = map[string]net.HardwareAddr{}
+
+	c := capabilities()
+	assert.Equal(t, wsProtocolVersion, c.Protocol)
+	assert.Equal(t, wsTopics, c.Topics)
+	assert.Equal(t, !embeddedBuild, c.Features["runtime_hosts"])
+	assert.NotContains(t, c.Features, "demo", "disabled features are left out")
+	assert.NotContains(t, c.Features, "wol")
+	assert.Equal(t, eventLogSize, c.Limits.Events)
+	assert.Equal(t, msFloat(pingInterval), c.Limits.IntervalMs)
+
+	demoEnabled = true
+	hostMACs = map[string]net.HardwareAddr{"nas": {0, 1, 2, 3, 4, 5}}
+	c = capabilities()
+	assert.True(t, c.Features["demo"])
+	assert.True(t, c.Features["wol"])
+
+	w := httptest.NewRecorder()
+	capabilitiesHandler(w, httptest.NewRequest(http.MethodGet, "/api/capabilities", nil))
+	var got Capabilities
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.True(t, got.Features["demo"])
+}
+
+func TestWSCapabilitiesFirst(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(wsHandler))
+	defer server.Close()
+	clientsMu.Lock()
+	before := len(clients)
+	clientsMu.Unlock()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?topics=alerts", "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	var msg struct {
+		Topic string       `json:"topic"`
+		Data  Capabilities `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(receive(t, ws)), &msg))
+	assert.Equal(t, topicCapabilities, msg.Topic)
+	assert.Equal(t, capabilities().Limits, msg.Data.Limits)
+
+	// Let the handler unregister the client before other tests count them
+	ws.Close()
+	assert.Eventually(t, func() bool {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		return len(clients) == before
+	}, time.Second, 5*time.Millisecond)
+}
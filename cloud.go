@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// discoverInterval is how often cloud providers and DNS records are listed
+// again and discovered hosts are reconciled with the monitored ones, set
+// with -discover-interval.
+var discoverInterval = time.Minute
+
+// polledProviders returns the providers of -ec2-region, -gcp-project,
+// -azure-subscription, -dns-srv, -dns-axfr, -mdns, -discover-lan,
+// -tailscale and -lldp by the source they are shown as.
+func polledProviders() map[string][]Discovery {
+	providers := make(map[string][]Discovery)
+	if ec2Regions != "" {
+		providers["EC2"] = ec2Providers()
+	}
+	if gcpProjects != "" {
+		providers["GCP"] = gcpProviders()
+	}
+	if azureSubscriptions != "" {
+		providers["Azure"] = azureProviders()
+	}
+	if dnsSRV != "" || dnsAXFR != "" {
+		providers["DNS"] = dnsProviders()
+	}
+	if mdnsBrowse {
+		providers["mDNS"] = []Discovery{mdnsProvider{}}
+	}
+	if discoverLAN {
+		providers["LAN"] = []Discovery{&lanProvider{}}
+	}
+	if tailscaleSource != "" {
+		providers["Tailscale"] = []Discovery{newTailscaleProvider(tailscaleSource)}
+	}
+	if lldpSeeds != "" {
+		providers["LLDP"] = []Discovery{lldpProvider{}}
+	}
+	return providers
+}
+
+// discoverPolled monitors the hosts of the polled providers until ctx is
+// done, listing each again every -discover-interval.
+func discoverPolled(ctx context.Context) {
+	for source, providers := range polledProviders() {
+		d := startReconciler(ctx, source)
+		for _, p := range providers {
+			go runDiscovery(ctx, p.String()+" discovery", func(ctx context.Context) error {
+				return pollDiscovery(ctx, d, p.String(), p.List)
+			})
+		}
+	}
+}
+
+// pollDiscovery replaces the objects of kind in d with those list returns,
+// every -discover-interval until ctx is done.
+//
+// Returns:
+//   - error: The first error of list
+func pollDiscovery(ctx context.Context, d *reconciler, kind string, list func(context.Context) (map[string]discovered, error)) error {
+	for {
+		hosts, err := list(ctx)
+		if err != nil {
+			return err
+		}
+		d.replace(kind, hosts)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(discoverInterval):
+		}
+	}
+}
+
+// cloudGroups returns the groups of a host of cloud in region, e.g. "aws"
+// and "aws/us-east-1", so @aws and @aws/us-east-1 set thresholds for all
+// AWS hosts or those of one region, and outages of a region correlate.
+func cloudGroups(cloud, region string) []string {
+	if region == "" {
+		return []string{cloud}
+	}
+	return []string{cloud, cloud + "/" + region}
+}
+
+// bearerToken caches an OAuth access token of a cloud API until shortly
+// before it expires.
+type bearerToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	// fetch gets a new token and how long it is valid
+	fetch func(ctx context.Context) (string, time.Duration, error)
+}
+
+// get returns the cached token, fetching a new one when it is about to
+// expire.
+func (b *bearerToken) get(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token != "" && time.Until(b.expires) > time.Minute {
+		return b.token, nil
+	}
+	token, ttl, err := b.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("empty access token")
+	}
+	b.token, b.expires = token, time.Now().Add(ttl)
+	return token, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudProviders(t *testing.T) {
+	oldEC2, oldGCP, oldAzure := ec2Regions, gcpProjects, azureSubscriptions
+	t.Cleanup(func() { ec2Regions, gcpProjects, azureSubscriptions = oldEC2, oldGCP, oldAzure })
+
+	ec2Regions, gcpProjects, azureSubscriptions = "", "", ""
+	assert.Empty(t, polledProviders())
+
+	ec2Regions, gcpProjects, azureSubscriptions = "us-east-1, eu-west-1", "shop-prod", "0000-1111"
+	var names []string
+	for _, source := range []string{"EC2", "GCP", "Azure"} {
+		for _, p := range polledProviders()[source] {
+			names = append(names, p.String())
+		}
+	}
+	assert.Equal(t, []string{"EC2 us-east-1", "EC2 eu-west-1", "GCP shop-prod", "Azure 0000-1111"}, names)
+}
+
+func TestCloudGroups(t *testing.T) {
+	assert.Equal(t, []string{"gcp", "gcp/europe-west1"}, cloudGroups("gcp", "europe-west1"))
+	assert.Equal(t, []string{"azure"}, cloudGroups("azure", ""))
+}
+
+func TestBearerToken(t *testing.T) {
+	fetches := 0
+	ttl := time.Hour
+	b := &bearerToken{fetch: func(context.Context) (string, time.Duration, error) {
+		fetches++
+		return "t" + string(rune('0'+fetches)), ttl, nil
+	}}
+	token, err := b.get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "t1", token)
+	token, _ = b.get(context.Background())
+	assert.Equal(t, "t1", token, "cached")
+
+	b.expires = time.Now().Add(30 * time.Second)
+	token, _ = b.get(context.Background())
+	assert.Equal(t, "t2", token, "renewed shortly before it expires")
+}
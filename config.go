@@ -0,0 +1,491 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the part of the configuration that can be replaced at runtime:
+// the statically configured hosts, the per-host latency thresholds, probe
+// settings, labels and parents, and the global probe settings. Global
+// settings left empty keep their running value.
+type Config struct {
+	Hosts      []string                `json:"hosts" yaml:"hosts"`
+	Thresholds map[string]Thresholds   `json:"thresholds,omitempty" yaml:"thresholds,omitempty"`
+	Overrides  map[string]HostOverride `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+	Labels     map[string]HostLabel    `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Parents    map[string]string       `json:"parents,omitempty" yaml:"parents,omitempty"`   // Host -> the host it depends on
+	Interval   string                  `json:"interval,omitempty" yaml:"interval,omitempty"` // Duration, e.g. "5s"
+	Count      int                     `json:"count,omitempty" yaml:"count,omitempty"`
+	Timeout    string                  `json:"timeout,omitempty" yaml:"timeout,omitempty"` // Duration, e.g. "1s"
+	Size       int                     `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+// OverrideChange describes per-host probe settings that differ between two
+// configs. A nil side means the host uses the global settings there.
+type OverrideChange struct {
+	Host string        `json:"host"`
+	Old  *HostOverride `json:"old,omitempty"`
+	New  *HostOverride `json:"new,omitempty"`
+}
+
+// SettingChange describes a probe setting that differs between two configs.
+type SettingChange struct {
+	Name string `json:"name"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// LabelChange describes a host label that differs between two configs. A
+// nil side means the host has no label there.
+type LabelChange struct {
+	Host string     `json:"host"`
+	Old  *HostLabel `json:"old,omitempty"`
+	New  *HostLabel `json:"new,omitempty"`
+}
+
+// ParentChange describes a dependency that differs between two configs. An
+// empty side means the host depends on no other there.
+type ParentChange struct {
+	Host string `json:"host"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// ThresholdChange describes thresholds that differ between two configs.
+// A nil side means the host has no thresholds there.
+type ThresholdChange struct {
+	Host string      `json:"host"`
+	Old  *Thresholds `json:"old,omitempty"`
+	New  *Thresholds `json:"new,omitempty"`
+}
+
+// ConfigDiff lists what applying a config would change.
+type ConfigDiff struct {
+	HostsAdded        []string          `json:"hosts_added"`
+	HostsRemoved      []string          `json:"hosts_removed"`
+	ThresholdsChanged []ThresholdChange `json:"thresholds_changed"`
+	SettingsChanged   []SettingChange   `json:"settings_changed"`
+	OverridesChanged  []OverrideChange  `json:"overrides_changed"`
+	LabelsChanged     []LabelChange     `json:"labels_changed"`
+	ParentsChanged    []ParentChange    `json:"parents_changed"`
+}
+
+// ConfigReloadResult is the response of /api/config/reload.
+type ConfigReloadResult struct {
+	DryRun  bool       `json:"dry_run"`
+	Applied bool       `json:"applied"`
+	Diff    ConfigDiff `json:"diff"`
+	Text    string     `json:"text"`            // Human-readable version of Diff
+	Token   string     `json:"token,omitempty"` // Pass as ?confirm= to apply the previewed change
+}
+
+// maxConfigBytes is the largest config /api/config/reload reads.
+const maxConfigBytes = 10 << 20
+
+// hostsFile and hostsFlag are the -file and -hosts values, re-read when a
+// reload request carries no config of its own.
+var hostsFile, hostsFlag string
+
+// currentConfig returns the running configuration. Hosts added through
+// /api/hosts are runtime state and not part of it.
+func currentConfig() Config {
+	hostsMu.RLock()
+	c := Config{Hosts: []string{}}
+	for _, h := range hosts {
+		if _, ok := dynamicHosts[h]; !ok {
+			c.Hosts = append(c.Hosts, h)
+		}
+	}
+	hostsMu.RUnlock()
+	c.Thresholds = advisor.thresholds()
+	c.Overrides = overrides()
+	c.Labels = labels()
+	c.Parents = hostStates.dependencies()
+	return c.withSettings(currentSettings())
+}
+
+// settings returns the probe settings of c, taking those it leaves empty
+// from base.
+func (c Config) settings(base ProbeSettings) (ProbeSettings, error) {
+	if c.Size != 0 {
+		base.Size = c.Size
+	}
+	return HostOverride{Interval: c.Interval, Timeout: c.Timeout, Count: c.Count}.apply(base)
+}
+
+// withSettings returns c with every probe setting spelled out, those it
+// leaves empty taken from base, so configs compare equal regardless of how
+// their durations were written.
+func (c Config) withSettings(base ProbeSettings) Config {
+	if s, err := c.settings(base); err == nil {
+		c.Interval, c.Count, c.Timeout, c.Size = s.Interval.String(), s.Count, s.Timeout.String(), s.Size
+	}
+	return c
+}
+
+// validate reports the first problem that would stop c from being applied.
+func (c Config) validate() error {
+	if len(c.Hosts) == 0 && !discovering() {
+		return fmt.Errorf("no hosts configured")
+	}
+	seen := make(map[string]bool)
+	for _, h := range c.Hosts {
+		if err := validateHost(h); err != nil {
+			return err
+		}
+		if seen[h] {
+			return fmt.Errorf("%s is listed twice", h)
+		}
+		seen[h] = true
+	}
+	for h, th := range c.Thresholds {
+		if err := th.validate(); err != nil {
+			return fmt.Errorf("%s: %v", h, err)
+		}
+	}
+	global, err := c.settings(currentSettings())
+	if err != nil {
+		return err
+	}
+	for h, o := range c.Overrides {
+		if !seen[h] {
+			return fmt.Errorf("override for %s, which is not a configured host", h)
+		}
+		if _, err := o.apply(global); err != nil {
+			return fmt.Errorf("%s: %v", h, err)
+		}
+	}
+	for h, l := range c.Labels {
+		if !seen[h] {
+			return fmt.Errorf("label for %s, which is not a configured host", h)
+		}
+		if err := validatePriority(l.Priority); err != nil {
+			return fmt.Errorf("%s: %v", h, err)
+		}
+	}
+	if err := validateParents(c.Parents, seen); err != nil {
+		return err
+	}
+	return nil
+}
+
+// diffConfig compares two configurations.
+func diffConfig(old, new Config) ConfigDiff {
+	d := ConfigDiff{HostsAdded: []string{}, HostsRemoved: []string{}, ThresholdsChanged: []ThresholdChange{}, SettingsChanged: []SettingChange{}, OverridesChanged: []OverrideChange{}, LabelsChanged: []LabelChange{}, ParentsChanged: []ParentChange{}}
+	oldHosts := make(map[string]bool)
+	for _, h := range old.Hosts {
+		oldHosts[h] = true
+	}
+	newHosts := make(map[string]bool)
+	for _, h := range new.Hosts {
+		newHosts[h] = true
+		if !oldHosts[h] {
+			d.HostsAdded = append(d.HostsAdded, h)
+		}
+	}
+	for _, h := range old.Hosts {
+		if !newHosts[h] {
+			d.HostsRemoved = append(d.HostsRemoved, h)
+		}
+	}
+	for h, th := range new.Thresholds {
+		if prev, ok := old.Thresholds[h]; !ok || prev != th {
+			change := ThresholdChange{Host: h, New: &th}
+			if ok {
+				change.Old = &prev
+			}
+			d.ThresholdsChanged = append(d.ThresholdsChanged, change)
+		}
+	}
+	for h, th := range old.Thresholds {
+		if _, ok := new.Thresholds[h]; !ok {
+			d.ThresholdsChanged = append(d.ThresholdsChanged, ThresholdChange{Host: h, Old: &th})
+		}
+	}
+	for h, o := range new.Overrides {
+		if prev, ok := old.Overrides[h]; !ok || !prev.equal(o) {
+			change := OverrideChange{Host: h, New: &o}
+			if ok {
+				change.Old = &prev
+			}
+			d.OverridesChanged = append(d.OverridesChanged, change)
+		}
+	}
+	for h, o := range old.Overrides {
+		if _, ok := new.Overrides[h]; !ok {
+			d.OverridesChanged = append(d.OverridesChanged, OverrideChange{Host: h, Old: &o})
+		}
+	}
+	for h, l := range new.Labels {
+		if prev, ok := old.Labels[h]; !ok || !prev.equal(l) {
+			change := LabelChange{Host: h, New: &l}
+			if ok {
+				change.Old = &prev
+			}
+			d.LabelsChanged = append(d.LabelsChanged, change)
+		}
+	}
+	for h, l := range old.Labels {
+		if _, ok := new.Labels[h]; !ok {
+			d.LabelsChanged = append(d.LabelsChanged, LabelChange{Host: h, Old: &l})
+		}
+	}
+	for h, p := range new.Parents {
+		if old.Parents[h] != p {
+			d.ParentsChanged = append(d.ParentsChanged, ParentChange{Host: h, Old: old.Parents[h], New: p})
+		}
+	}
+	for h, p := range old.Parents {
+		if _, ok := new.Parents[h]; !ok {
+			d.ParentsChanged = append(d.ParentsChanged, ParentChange{Host: h, Old: p})
+		}
+	}
+	for _, c := range []SettingChange{
+		{"interval", old.Interval, new.Interval},
+		{"count", strconv.Itoa(old.Count), strconv.Itoa(new.Count)},
+		{"timeout", old.Timeout, new.Timeout},
+		{"size", strconv.Itoa(old.Size), strconv.Itoa(new.Size)},
+	} {
+		if c.Old != c.New {
+			d.SettingsChanged = append(d.SettingsChanged, c)
+		}
+	}
+	sort.Strings(d.HostsAdded)
+	sort.Strings(d.HostsRemoved)
+	sort.Slice(d.ThresholdsChanged, func(i, j int) bool { return d.ThresholdsChanged[i].Host < d.ThresholdsChanged[j].Host })
+	sort.Slice(d.OverridesChanged, func(i, j int) bool { return d.OverridesChanged[i].Host < d.OverridesChanged[j].Host })
+	sort.Slice(d.LabelsChanged, func(i, j int) bool { return d.LabelsChanged[i].Host < d.LabelsChanged[j].Host })
+	sort.Slice(d.ParentsChanged, func(i, j int) bool { return d.ParentsChanged[i].Host < d.ParentsChanged[j].Host })
+	return d
+}
+
+// empty reports whether the diff has no changes.
+func (d ConfigDiff) empty() bool {
+	return len(d.HostsAdded)+len(d.HostsRemoved)+len(d.ThresholdsChanged)+len(d.SettingsChanged)+len(d.OverridesChanged)+len(d.LabelsChanged)+len(d.ParentsChanged) == 0
+}
+
+// String renders the diff one change per line, e.g. "+ db01".
+func (d ConfigDiff) String() string {
+	if d.empty() {
+		return "no changes\n"
+	}
+	var b strings.Builder
+	for _, h := range d.HostsAdded {
+		fmt.Fprintf(&b, "+ %s\n", h)
+	}
+	for _, h := range d.HostsRemoved {
+		fmt.Fprintf(&b, "- %s\n", h)
+	}
+	for _, c := range d.ThresholdsChanged {
+		fmt.Fprintf(&b, "~ %s thresholds %s -> %s\n", c.Host, formatThresholds(c.Old), formatThresholds(c.New))
+	}
+	for _, c := range d.SettingsChanged {
+		fmt.Fprintf(&b, "~ %s %s -> %s\n", c.Name, c.Old, c.New)
+	}
+	for _, c := range d.OverridesChanged {
+		fmt.Fprintf(&b, "~ %s settings %s -> %s\n", c.Host, formatOverride(c.Old), formatOverride(c.New))
+	}
+	for _, c := range d.LabelsChanged {
+		fmt.Fprintf(&b, "~ %s label %s -> %s\n", c.Host, formatLabel(c.Old), formatLabel(c.New))
+	}
+	for _, c := range d.ParentsChanged {
+		fmt.Fprintf(&b, "~ %s parent %s -> %s\n", c.Host, formatParent(c.Old), formatParent(c.New))
+	}
+	return b.String()
+}
+
+// formatThresholds renders thresholds as "warn/crit ms", followed by
+// ", loss warn/crit %" if loss thresholds are set, or "default".
+func formatThresholds(th *Thresholds) string {
+	if th == nil {
+		return "default"
+	}
+	s := fmt.Sprintf("%d/%d ms", th.WarnMs, th.CritMs)
+	if th.LossWarnPct != 0 || th.LossCritPct != 0 {
+		s += fmt.Sprintf(", loss %g/%g %%", th.LossWarnPct, th.LossCritPct)
+	}
+	return s
+}
+
+// formatOverride renders per-host settings, or "default".
+func formatOverride(o *HostOverride) string {
+	if o == nil {
+		return "default"
+	}
+	return o.String()
+}
+
+// formatLabel renders a host label, or "none".
+func formatLabel(l *HostLabel) string {
+	if l == nil {
+		return "none"
+	}
+	return l.String()
+}
+
+// formatParent renders the parent of a host, or "none".
+func formatParent(p string) string {
+	if p == "" {
+		return "none"
+	}
+	return p
+}
+
+// configToken identifies the change from old to new. A dry run hands it out
+// and applying requires it back, so the change applied is exactly the one
+// previewed and nothing else changed in between.
+func configToken(old, new Config) string {
+	data, _ := json.Marshal([]Config{old, new})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// applyConfig replaces the running configuration with c. Hosts added through
+// /api/hosts are kept unless c lists them, which makes them permanent. New
+// probe settings take effect from the next cycle.
+func applyConfig(c Config, d ConfigDiff) {
+	hostsMu.Lock()
+	for _, h := range d.HostsRemoved {
+		dropHostLocked(h)
+	}
+	for _, h := range c.Hosts {
+		if _, ok := dynamicHosts[h]; ok {
+			delete(dynamicHosts, h)
+			continue
+		}
+		found := false
+		for _, existing := range hosts {
+			found = found || existing == h
+		}
+		if !found {
+			hosts = append(hosts, h)
+		}
+	}
+	hostsMu.Unlock()
+	advisor.setThresholds(c.Thresholds)
+	setOverrides(c.Overrides)
+	setLabels(c.Labels)
+	hostStates.setDependencies(c.Parents)
+	if len(d.SettingsChanged) > 0 {
+		if s, err := c.settings(currentSettings()); err == nil {
+			queueSettings(s)
+		}
+	}
+}
+
+// diskConfig re-reads the configuration from -config, or the hosts from
+// -file and -hosts with the running thresholds, overrides, labels and
+// parents without one.
+func diskConfig() (Config, error) {
+	if configFile != "" {
+		c, err := reloadConfigFile()
+		if err != nil {
+			return c, fmt.Errorf("failed to read config: %v", err)
+		}
+		return c, nil
+	}
+	hosts, fileLabels, err := readHosts(hostsFile, hostsFlag)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read hosts: %v", err)
+	}
+	// Without -config the names and groups of the hosts file and the
+	// inventory are the only ones kept on disk, so a change there replaces
+	// the running ones
+	l := labels()
+	for h, f := range fileLabels {
+		v := l[h]
+		if f.Name != "" {
+			v.Name = f.Name
+		}
+		v.Groups = f.Groups
+		l[h] = v
+	}
+	return Config{Hosts: hosts, Thresholds: advisor.thresholds(), Overrides: overrides(), Labels: l, Parents: hostStates.dependencies()}, nil
+}
+
+// configReloadHandler validates a new configuration and previews or applies
+// it. The config is taken from the JSON request body, or re-read from
+// -config, -file and -hosts when the body is empty.
+//
+//	POST /api/config/reload?dry-run=true      preview the diff, returns a token
+//	POST /api/config/reload?confirm=<token>   apply the previewed change
+//
+// Previewing a re-read of the files needs the -api-token if one is set.
+// A config in the body, and applying anything, need it in any case, since
+// they replace the whole host list; without an -api-token they are refused.
+// Hosts that run a command are refused unless -allow-exec is set.
+func configReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkToken(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hasBody := len(strings.TrimSpace(string(body))) > 0
+	if (hasBody || r.URL.Query().Get("confirm") != "") && !requireToken(w, r, "applying a config through /api/config/reload") {
+		return
+	}
+	var next Config
+	if hasBody {
+		if next, err = decodeConfig(body, r.Header.Get("Content-Type")); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if next, err = diskConfig(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowExec {
+		for _, h := range next.Hosts {
+			if runsCommand(h) {
+				http.Error(w, h+": exec:// hosts need -allow-exec", http.StatusForbidden)
+				return
+			}
+		}
+	}
+	if err := next.validate(); err != nil {
+		http.Error(w, "invalid config: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	running := currentConfig()
+	next = next.withSettings(currentSettings())
+	diff := diffConfig(running, next)
+	token := configToken(running, next)
+	result := ConfigReloadResult{Diff: diff, Text: diff.String()}
+	status := http.StatusOK
+	switch confirm := r.URL.Query().Get("confirm"); {
+	case r.URL.Query().Get("dry-run") == "true":
+		result.DryRun = true
+		result.Token = token
+	case confirm == "":
+		http.Error(w, "run with ?dry-run=true first, then apply with ?confirm=<token>", http.StatusBadRequest)
+		return
+	case confirm != token:
+		// Either the config or the running state changed since the dry run
+		result.Token = token
+		status = http.StatusConflict
+	default:
+		applyConfig(next, diff)
+		result.Applied = true
+		events.add(Event{Time: time.Now(), Type: "config_applied", Message: "configuration applied: " + strings.TrimSpace(strings.ReplaceAll(result.Text, "\n", "; "))})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := Config{
+		Hosts:      []string{"a", "b"},
+		Thresholds: map[string]Thresholds{"a": {WarnMs: 100, CritMs: 200}, "b": {WarnMs: 50, CritMs: 80}},
+	}
+	next := Config{
+		Hosts:      []string{"b", "c"},
+		Thresholds: map[string]Thresholds{"b": {WarnMs: 60, CritMs: 90}, "c": {WarnMs: 10, CritMs: 20}},
+	}
+	d := diffConfig(old, next)
+	assert.Equal(t, []string{"c"}, d.HostsAdded)
+	assert.Equal(t, []string{"a"}, d.HostsRemoved)
+	assert.Len(t, d.ThresholdsChanged, 3)
+	assert.Equal(t, "+ c\n- a\n"+
+		"~ a thresholds 100/200 ms -> default\n"+
+		"~ b thresholds 50/80 ms -> 60/90 ms\n"+
+		"~ c thresholds default -> 10/20 ms\n", d.String())
+
+	withParent := old
+	withParent.Parents = map[string]string{"b": "a"}
+	assert.Equal(t, "~ b parent none -> a\n", diffConfig(old, withParent).String())
+
+	assert.Equal(t, "no changes\n", diffConfig(old, old).String())
+	assert.NotEqual(t, configToken(old, next), configToken(old, old))
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, Config{Hosts: []string{"8.8.8.8"}}.validate())
+	assert.Error(t, Config{}.validate())
+	assert.Error(t, Config{Hosts: []string{"a", "a"}}.validate())
+	assert.Error(t, Config{Hosts: []string{"gopher://x"}}.validate())
+	assert.Error(t, Config{Hosts: []string{"a"}, Thresholds: map[string]Thresholds{"a": {WarnMs: 200, CritMs: 100}}}.validate())
+	assert.NoError(t, Config{Hosts: []string{"a"}, Interval: "10s", Count: 5}.validate())
+	assert.Error(t, Config{Hosts: []string{"a"}, Interval: "soon"}.validate())
+	assert.Error(t, Config{Hosts: []string{"a"}, Size: 8}.validate())
+	assert.Error(t, Config{Hosts: []string{"a"}, Parents: map[string]string{"a": "b"}}.validate())
+}
+
+func TestConfigSettings(t *testing.T) {
+	base := ProbeSettings{Interval: 2 * time.Second, Count: 1, Timeout: 2 * time.Second, Size: 24}
+	c := Config{Hosts: []string{"a"}, Interval: "5000ms", Count: 3}.withSettings(base)
+	assert.Equal(t, "5s", c.Interval)
+	assert.Equal(t, "2s", c.Timeout)
+	assert.Equal(t, 3, c.Count)
+	assert.Equal(t, 24, c.Size)
+
+	d := diffConfig(Config{Hosts: []string{"a"}}.withSettings(base), c)
+	assert.Equal(t, []SettingChange{{"interval", "2s", "5s"}, {"count", "1", "3"}}, d.SettingsChanged)
+	assert.Equal(t, "~ interval 2s -> 5s\n~ count 1 -> 3\n", d.String())
+}
+
+func TestConfigOverrides(t *testing.T) {
+	withHosts(t, "sat01", "lan01")
+	sat := HostOverride{Interval: "10s", Timeout: "8s"}
+	assert.NoError(t, Config{Hosts: []string{"sat01"}, Overrides: map[string]HostOverride{"sat01": sat}}.validate())
+	assert.Error(t, Config{Hosts: []string{"lan01"}, Overrides: map[string]HostOverride{"sat01": sat}}.validate(), "overrides need a configured host")
+	assert.Error(t, Config{Hosts: []string{"sat01"}, Overrides: map[string]HostOverride{"sat01": {Interval: "soon"}}}.validate())
+
+	old := Config{Hosts: []string{"sat01", "lan01"}, Overrides: map[string]HostOverride{"lan01": {Interval: "1s"}}}
+	next := Config{Hosts: []string{"sat01", "lan01"}, Overrides: map[string]HostOverride{"sat01": sat}}
+	d := diffConfig(old, next)
+	assert.Len(t, d.OverridesChanged, 2)
+	assert.Equal(t, "~ lan01 settings interval=1s -> default\n"+
+		"~ sat01 settings default -> interval=10s timeout=8s\n", d.String())
+
+	defer setOverrides(overrides())
+	applyConfig(next, d)
+	assert.Equal(t, 10*time.Second, settingsFor("sat01").Interval)
+	assert.Equal(t, pingInterval, settingsFor("lan01").Interval)
+}
+
+func TestConfigReloadHandler(t *testing.T) {
+	withHosts(t, "a", "b")
+	withAPIToken(t)
+	assert.NoError(t, addHost("runtime", time.Hour, time.Now()))
+	defer advisor.setThresholds(advisor.thresholds())
+	advisor.setThresholds(nil)
+
+	post := func(query, body string) (*httptest.ResponseRecorder, ConfigReloadResult) {
+		r := httptest.NewRequest(http.MethodPost, "/api/config/reload"+query, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+apiToken)
+		w := httptest.NewRecorder()
+		configReloadHandler(w, r)
+		var res ConfigReloadResult
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return w, res
+	}
+	body := `{"hosts":["b","c"],"thresholds":{"c":{"warn_ms":10,"crit_ms":20}}}`
+
+	// Applying without a preview is refused
+	w, _ := post("", body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, res := post("?dry-run=true", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, res.DryRun)
+	assert.False(t, res.Applied)
+	assert.Equal(t, []string{"c"}, res.Diff.HostsAdded)
+	assert.Equal(t, []string{"a"}, res.Diff.HostsRemoved)
+	assert.NotEmpty(t, res.Token)
+	assert.Equal(t, []string{"a", "b", "runtime"}, currentHosts(), "a dry run changes nothing")
+
+	// A different config does not match the previewed token
+	w, _ = post("?confirm="+res.Token, `{"hosts":["b"]}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, applied := post("?confirm="+res.Token, body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, applied.Applied)
+	assert.Equal(t, []string{"b", "runtime", "c"}, currentHosts())
+	assert.Equal(t, map[string]Thresholds{"c": {WarnMs: 10, CritMs: 20}}, advisor.thresholds())
+
+	// Probe settings wait for the next cycle
+	defer currentSettings().apply()
+	w, res = post("?dry-run=true", `{"hosts":["b","c"],"thresholds":{"c":{"warn_ms":10,"crit_ms":20}},"count":4}`)
+	assert.Equal(t, "~ count 1 -> 4\n", res.Text)
+	w, _ = post("?confirm="+res.Token, `{"hosts":["b","c"],"thresholds":{"c":{"warn_ms":10,"crit_ms":20}},"count":4}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, probeCount)
+	applyPendingSettings()
+	assert.Equal(t, 4, probeCount)
+
+	// Replaying the token after the change is a conflict
+	w, _ = post("?confirm="+res.Token, body)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, _ = post("?dry-run=true", `{"hosts":["gopher://x"]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// Commands only with -allow-exec, also as members of a logical host
+	w, _ = post("?dry-run=true", `{"hosts":["b","exec:///bin/true"]}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = post("?dry-run=true", `{"hosts":["b","vpn=10.0.0.1|exec:///bin/true"]}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestConfigReloadNeedsToken(t *testing.T) {
+	withHosts(t, "a", "b")
+	defer func(token string) { apiToken = token }(apiToken)
+	apiToken = ""
+	post := func(query, body string) int {
+		w := httptest.NewRecorder()
+		configReloadHandler(w, httptest.NewRequest(http.MethodPost, "/api/config/reload"+query, strings.NewReader(body)))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, post("?dry-run=true", `{"hosts":["c"]}`), "a config in the body needs the -api-token")
+	assert.Equal(t, http.StatusForbidden, post("?confirm=x", ""), "so does applying")
+	assert.Equal(t, []string{"a", "b"}, currentHosts())
+}
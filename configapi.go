@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiToken is the bearer token /api/config and changes through /api/hosts
+// require, set with -api-token. Without one those are off, since they dump
+// and replace the whole configuration, including credentials in host
+// entries, or change what is probed. The same goes for /api/config/export
+// and for sending or applying a config through /api/config/reload.
+var apiToken string
+
+// canonicalConfig renders c as canonical JSON: keys in a fixed order, maps
+// sorted by key, indented by two spaces and ending in a newline, so the
+// same configuration always renders to the same bytes and exports can be
+// diffed and kept under version control.
+func canonicalConfig(c Config) ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// authorized reports whether r carries the -api-token as a bearer token.
+func authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1
+}
+
+// checkToken answers 401 if an -api-token is set and r does not carry it.
+// Every /api/config route checks it, since they all hand out or replace
+// the configuration.
+//
+// Returns:
+//   - bool: True if the handler may go on
+func checkToken(w http.ResponseWriter, r *http.Request) bool {
+	if apiToken == "" || authorized(r) {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="mosaic"`)
+	http.Error(w, "missing or invalid API token", http.StatusUnauthorized)
+	return false
+}
+
+// requireToken is checkToken for endpoints that are off without an
+// -api-token: it answers 403 if none is set.
+//
+// Parameters:
+//   - w: Response the error is written to
+//   - r: Request that must carry the token
+//   - endpoint: What the token enables, for the 403 message
+//
+// Returns:
+//   - bool: True if the handler may go on
+func requireToken(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	if apiToken == "" {
+		http.Error(w, "start with -api-token to enable "+endpoint, http.StatusForbidden)
+		return false
+	}
+	return checkToken(w, r)
+}
+
+// configHandler dumps the complete runtime configuration as canonical JSON
+// (GET) or replaces it with the one in the request body (PUT), for backups
+// and for promoting a golden configuration between environments. Both need
+// the -api-token. Unlike /api/config/reload, a PUT applies at once; fields
+// the body leaves out are cleared, except for the global probe settings,
+// which keep their running values. Hosts added through /api/hosts are kept.
+//
+//	GET /api/config
+//	PUT /api/config
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireToken(w, r, "/api/config") {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		data, err := canonicalConfig(currentConfig())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var next Config
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&next); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := next.validate(); err != nil {
+			http.Error(w, "invalid config: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		next = next.withSettings(currentSettings())
+		diff := diffConfig(currentConfig(), next)
+		result := ConfigReloadResult{Diff: diff, Text: diff.String(), Applied: !diff.empty()}
+		if result.Applied {
+			applyConfig(next, diff)
+			events.add(Event{Time: time.Now(), Type: "config_applied", Message: "configuration restored: " + strings.TrimSpace(strings.ReplaceAll(result.Text, "\n", "; "))})
+			wakeLoop()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
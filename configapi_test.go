@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHandler(t *testing.T) {
+	withHosts(t, "a", "b")
+	assert.NoError(t, addHost("runtime", time.Hour, time.Now()))
+	defer advisor.setThresholds(advisor.thresholds())
+	defer setLabels(labels())
+	advisor.setThresholds(nil)
+	setLabels(map[string]HostLabel{"b": {Name: "NAS", Groups: []string{"storage"}}})
+	defer func(token string) { apiToken = token }(apiToken)
+
+	do := func(method, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/config", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		configHandler(w, r)
+		return w
+	}
+
+	apiToken = ""
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "secret", "").Code, "off without -api-token")
+	apiToken = "secret"
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "guess", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodPost, "secret", "{}").Code)
+
+	w := do(http.MethodGet, "secret", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	backup := w.Body.String()
+	assert.Contains(t, backup, "{\n  \"hosts\": [\n    \"a\",\n    \"b\"\n  ],\n  \"labels\": {\n    \"b\": {\n      \"name\": \"NAS\",\n      \"groups\": [\n        \"storage\"\n      ]", "runtime hosts are not part of it")
+	assert.True(t, strings.HasSuffix(backup, "}\n"))
+	assert.Equal(t, backup, do(http.MethodGet, "secret", "").Body.String(), "the same config renders the same")
+
+	w = do(http.MethodPut, "secret", `{"hosts":["b","c"],"thresholds":{"c":{"warn_ms":10}}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var res ConfigReloadResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.True(t, res.Applied)
+	assert.Equal(t, []string{"c"}, res.Diff.HostsAdded)
+	assert.Equal(t, []string{"b", "runtime", "c"}, currentHosts())
+	assert.Equal(t, map[string]Thresholds{"c": {WarnMs: 10}}, advisor.thresholds())
+	assert.Empty(t, labels(), "left out fields are cleared")
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "secret", `{"hosts":["b"],"hostz":[]}`).Code, "unknown fields are rejected")
+	assert.Equal(t, http.StatusUnprocessableEntity, do(http.MethodPut, "secret", `{"hosts":[]}`).Code)
+
+	// Restoring the backup brings everything back
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "secret", backup).Code)
+	var saved, restored Config
+	assert.NoError(t, json.Unmarshal([]byte(backup), &saved))
+	assert.NoError(t, json.Unmarshal(do(http.MethodGet, "secret", "").Body.Bytes(), &restored))
+	assert.True(t, diffConfig(saved, restored).empty())
+	assert.Equal(t, []string{"b", "runtime", "a"}, currentHosts(), "restored hosts are added at the end")
+	w = do(http.MethodPut, "secret", backup)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.False(t, res.Applied, "nothing to change")
+}
+
+func TestConfigRoutesNeedToken(t *testing.T) {
+	withHosts(t, "a", "b")
+	withAPIToken(t)
+	do := func(handler http.HandlerFunc, method, target, token string) int {
+		r := httptest.NewRequest(method, target, strings.NewReader(`{"hosts":["a"]}`))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(configExportHandler, http.MethodGet, "/api/config/export", ""))
+	assert.Equal(t, http.StatusUnauthorized, do(configExportHandler, http.MethodGet, "/api/config/export", "guess"))
+	assert.Equal(t, http.StatusOK, do(configExportHandler, http.MethodGet, "/api/config/export", apiToken))
+
+	assert.Equal(t, http.StatusUnauthorized, do(configReloadHandler, http.MethodPost, "/api/config/reload?dry-run=true", ""), "no confirm token without the API token")
+	assert.Equal(t, http.StatusUnauthorized, do(configReloadHandler, http.MethodPost, "/api/config/reload?confirm=x", "guess"))
+	assert.Equal(t, http.StatusOK, do(configReloadHandler, http.MethodPost, "/api/config/reload?dry-run=true", apiToken))
+	assert.Equal(t, []string{"a", "b"}, currentHosts())
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryHeader explains an exported inventory to whoever edits it.
+const inventoryHeader = `# mosaic host inventory, exported %s
+#
+# Edit this file and import it on the dashboard, or preview and apply it with
+#   curl -X POST -H 'Content-Type: application/yaml' --data-binary @hosts.yaml 'http://localhost:8080/api/config/reload?dry-run=true'
+# Hosts added at runtime through /api/hosts are not listed and are kept on import.
+`
+
+// isYAML reports whether a Content-Type header announces YAML.
+func isYAML(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// decodeConfig parses a config sent to /api/config/reload, as YAML if the
+// Content-Type says so and as JSON otherwise. Unknown YAML keys are
+// rejected, so a misspelled section in a hand-edited inventory is reported
+// instead of silently dropping its hosts.
+//
+// Parameters:
+//   - body: The request body
+//   - contentType: The request's Content-Type header
+//
+// Returns:
+//   - Config: The parsed config
+//   - error: Why the body could not be parsed
+func decodeConfig(body []byte, contentType string) (Config, error) {
+	var c Config
+	if !isYAML(contentType) {
+		return c, json.Unmarshal(body, &c)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// configExportHandler serves the running configuration as a YAML file for
+// editing and re-importing through /api/config/reload. Like /api/backup,
+// it needs the -api-token and is off without one, since host entries may
+// carry credentials.
+func configExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireToken(w, r, "/api/config/export") {
+		return
+	}
+	data, err := yaml.Marshal(currentConfig())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="mosaic-hosts.yaml"`)
+	fmt.Fprintf(w, inventoryHeader, time.Now().Format(time.RFC3339))
+	w.Write(data)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	c, err := decodeConfig([]byte(`
+hosts:
+  - sat01
+  - db01:5432
+thresholds:
+  db01:5432: {warn_ms: 20, crit_ms: 50}
+overrides:
+  sat01: {interval: 10s, count: 3}
+interval: 5s
+`), "application/yaml; charset=utf-8")
+	assert.NoError(t, err)
+	assert.Equal(t, Config{
+		Hosts:      []string{"sat01", "db01:5432"},
+		Thresholds: map[string]Thresholds{"db01:5432": {WarnMs: 20, CritMs: 50}},
+		Overrides:  map[string]HostOverride{"sat01": {Interval: "10s", Count: 3}},
+		Interval:   "5s",
+	}, c)
+
+	_, err = decodeConfig([]byte("host:\n  - sat01\n"), "text/yaml")
+	assert.Error(t, err, "misspelled keys are rejected")
+
+	c, err = decodeConfig([]byte(`{"hosts":["a"]}`), "application/json")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, c.Hosts)
+}
+
+func TestConfigExportRoundTrip(t *testing.T) {
+	withHosts(t, "a", "b")
+	withAPIToken(t)
+	assert.NoError(t, addHost("runtime", time.Hour, time.Now()))
+	defer advisor.setThresholds(advisor.thresholds())
+	advisor.setThresholds(map[string]Thresholds{"b": {WarnMs: 10, CritMs: 20}})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/config/export", nil)
+	r.Header.Set("Authorization", "Bearer "+apiToken)
+	configExportHandler(w, r)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "mosaic-hosts.yaml")
+	exported := w.Body.String()
+	assert.True(t, strings.HasPrefix(exported, "# mosaic host inventory"))
+	assert.NotContains(t, exported, "- runtime", "runtime hosts are not part of the inventory")
+
+	reload := func(query, body string) ConfigReloadResult {
+		r := httptest.NewRequest(http.MethodPost, "/api/config/reload"+query, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/yaml")
+		r.Header.Set("Authorization", "Bearer "+apiToken)
+		w := httptest.NewRecorder()
+		configReloadHandler(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var res ConfigReloadResult
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res
+	}
+
+	// Importing the unchanged export changes nothing
+	assert.Equal(t, "no changes\n", reload("?dry-run=true", exported).Text)
+
+	// An edited export is previewed and applied like any reload
+	edited := strings.Replace(exported, "    - b\n", "    - b\n    - c\n", 1)
+	res := reload("?dry-run=true", edited)
+	assert.Equal(t, "+ c\n", res.Text)
+	assert.True(t, reload("?confirm="+res.Token, edited).Applied)
+	assert.Equal(t, []string{"a", "b", "runtime", "c"}, currentHosts())
+}
+
+func TestConfigExportNeedsToken(t *testing.T) {
+	withHosts(t, "a")
+	defer func(token string) { apiToken = token }(apiToken)
+	apiToken = ""
+	w := httptest.NewRecorder()
+	configExportHandler(w, httptest.NewRequest(http.MethodGet, "/api/config/export", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code, "off without an -api-token")
+	assert.NotContains(t, w.Body.String(), "hosts:")
+}
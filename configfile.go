@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the -config file. Its settings apply where no flag is given
+// and its hosts where none of -file, -inventory, -nmap-xml, -dhcp-leases and
+// -hosts is.
+var configFile string
+
+// flagsGiven holds the flags given on the command line, as opposed to those
+// taken from configFile, so reloading the file does not undo them.
+var flagsGiven = make(map[string]bool)
+
+// FileConfig is the layout of a -config file: the Config that can also be
+// reloaded at runtime at the top level, and the settings that only take
+// effect at startup in the server and display sections.
+//
+//	hosts: [router, db01:5432]
+//	interval: 5s
+//	overrides:
+//	  sat01: {interval: 10s, timeout: 8s}
+//	pause: [db01:5432]
+//	server:
+//	  listen: ":9090"
+//	  workers: 128
+//	display:
+//	  show_loss: true
+//	  confirm: 3
+type FileConfig struct {
+	Config    `yaml:",inline"`
+	Pause     []string      `yaml:"pause,omitempty"`     // Hosts to start in maintenance
+	Inventory string        `yaml:"inventory,omitempty"` // Ansible inventory to take hosts from, see -inventory
+	NmapXML   string        `yaml:"nmap_xml,omitempty"`  // nmap scan report to take hosts from, see -nmap-xml
+	K8s       K8sConfig     `yaml:"k8s,omitempty"`       // Kubernetes discovery, see -k8s
+	Docker    DockerConfig  `yaml:"docker,omitempty"`    // Docker container discovery, see -docker
+	Consul    ConsulConfig  `yaml:"consul,omitempty"`    // Consul catalog discovery, see -consul
+	EC2       EC2Config     `yaml:"ec2,omitempty"`       // EC2 instance discovery, see -ec2-region
+	GCP       GCPConfig     `yaml:"gcp,omitempty"`       // Compute Engine VM discovery, see -gcp-project
+	Azure     AzureConfig   `yaml:"azure,omitempty"`     // Azure VM discovery, see -azure-subscription
+	DNS       DNSConfig     `yaml:"dns,omitempty"`       // DNS discovery, see -dns-srv and -dns-axfr
+	MDNS      MDNSConfig    `yaml:"mdns,omitempty"`      // mDNS discovery, see -mdns
+	LAN       LANConfig     `yaml:"lan,omitempty"`       // ARP sweep discovery, see -discover-lan
+	Tailscale string        `yaml:"tailscale,omitempty"` // Tailscale discovery, see -tailscale
+	LLDP      LLDPConfig    `yaml:"lldp,omitempty"`      // LLDP/CDP neighbor discovery, see -lldp
+	Include   string        `yaml:"include,omitempty"`   // Regular expressions filtering the hosts
+	Exclude   string        `yaml:"exclude,omitempty"`
+	Server    ServerConfig  `yaml:"server,omitempty"`
+	Display   DisplayConfig `yaml:"display,omitempty"`
+
+	// DiscoverInterval is how often cloud providers and DNS are listed, see
+	// -discover-interval
+	DiscoverInterval string `yaml:"discover_interval,omitempty"`
+	// Leases is a DHCP lease file to take hosts from, see -dhcp-leases
+	Leases string `yaml:"dhcp_leases,omitempty"`
+}
+
+// K8sConfig holds the Kubernetes discovery settings of a -config file.
+type K8sConfig struct {
+	Source    string `yaml:"source,omitempty"` // "in-cluster" or a kubeconfig path
+	Pods      string `yaml:"pods,omitempty"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// DockerConfig holds the Docker container discovery settings of a -config
+// file.
+type DockerConfig struct {
+	Endpoint string `yaml:"endpoint,omitempty"` // Socket path or tcp://host:port
+	Label    string `yaml:"label,omitempty"`
+}
+
+// ConsulConfig holds the Consul catalog discovery settings of a -config
+// file.
+type ConsulConfig struct {
+	Address string `yaml:"address,omitempty"`
+	Tag     string `yaml:"tag,omitempty"`
+}
+
+// EC2Config holds the EC2 instance discovery settings of a -config file.
+type EC2Config struct {
+	Regions []string `yaml:"regions,omitempty"`
+	Filter  string   `yaml:"filter,omitempty"`
+	Public  *bool    `yaml:"public,omitempty"`
+}
+
+// GCPConfig holds the Compute Engine VM discovery settings of a -config
+// file.
+type GCPConfig struct {
+	Projects []string `yaml:"projects,omitempty"`
+	Filter   string   `yaml:"filter,omitempty"`
+	Public   *bool    `yaml:"public,omitempty"`
+}
+
+// AzureConfig holds the Azure VM discovery settings of a -config file.
+type AzureConfig struct {
+	Subscriptions []string `yaml:"subscriptions,omitempty"`
+	Tag           string   `yaml:"tag,omitempty"`
+	Public        *bool    `yaml:"public,omitempty"`
+}
+
+// DNSConfig holds the DNS discovery settings of a -config file.
+type DNSConfig struct {
+	SRV  []string `yaml:"srv,omitempty"`
+	AXFR []string `yaml:"axfr,omitempty"`
+}
+
+// MDNSConfig holds the mDNS discovery settings of a -config file.
+type MDNSConfig struct {
+	Enabled  *bool    `yaml:"enabled,omitempty"`
+	Services []string `yaml:"services,omitempty"`
+}
+
+// LANConfig holds the ARP sweep discovery settings of a -config file.
+type LANConfig struct {
+	Enabled *bool    `yaml:"enabled,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// LLDPConfig holds the LLDP/CDP neighbor discovery settings of a -config
+// file.
+type LLDPConfig struct {
+	Seeds     []string `yaml:"seeds,omitempty"`
+	Depth     *int     `yaml:"depth,omitempty"`
+	Community string   `yaml:"community,omitempty"`
+}
+
+// ServerConfig holds the server and probing settings of a -config file.
+// Each field corresponds to the flag of the same name; empty fields keep
+// the flag's default.
+type ServerConfig struct {
+	Listen         string   `yaml:"listen,omitempty"`
+	AllowedOrigins []string `yaml:"allowed_origins,omitempty"`
+	APIToken       string   `yaml:"api_token,omitempty"`
+	EnvAllow       []string `yaml:"env_allow,omitempty"`
+	Notify         []string `yaml:"notify,omitempty"`
+	Workers        int      `yaml:"workers,omitempty"`
+	MaxPPS         int      `yaml:"max_pps,omitempty"`
+	PingMode       string   `yaml:"ping_mode,omitempty"`
+	Source         string   `yaml:"source,omitempty"`
+	DSCP           string   `yaml:"dscp,omitempty"`
+	Jitter         float64  `yaml:"jitter,omitempty"`
+	Stagger        *bool    `yaml:"stagger,omitempty"`
+	DownInterval   string   `yaml:"down_interval,omitempty"` // Duration, e.g. "1s"
+	DNSRefresh     *bool    `yaml:"dns_refresh,omitempty"`
+	WatchFile      *bool    `yaml:"watch_file,omitempty"`
+	PersistHosts   *bool    `yaml:"persist_hosts,omitempty"`
+	Dedupe         *bool    `yaml:"dedupe,omitempty"`
+	HistoryDB      string   `yaml:"history_db,omitempty"`
+	Retention      string   `yaml:"history_retention,omitempty"`  // Duration for -history-retention, e.g. "168h"
+	Downsample     *string  `yaml:"history_downsample,omitempty"` // See -history-downsample; "" turns it off
+	HistoryBackend string   `yaml:"history_backend,omitempty"`
+	Instance       string   `yaml:"history_instance,omitempty"` // See -history-instance
+	HistorySize    int      `yaml:"history_size,omitempty"`
+	ResultsLog     string   `yaml:"results_log,omitempty"`
+	ResultsLogSize int      `yaml:"results_log_size,omitempty"`
+	ResultsLogKeep *int     `yaml:"results_log_keep,omitempty"`
+	ReportEmail    string   `yaml:"report_email,omitempty"`
+	ReportSchedule string   `yaml:"report_schedule,omitempty"`
+	OTLPEndpoint   string   `yaml:"otlp_endpoint,omitempty"`
+	OTLPHeaders    string   `yaml:"otlp_headers,omitempty"`
+	OTLPSpans      *bool    `yaml:"otlp_spans,omitempty"`
+	InfluxURL      string   `yaml:"influx_url,omitempty"`
+	InfluxDB       string   `yaml:"influx_db,omitempty"`
+	InfluxBucket   string   `yaml:"influx_bucket,omitempty"`
+	InfluxOrg      string   `yaml:"influx_org,omitempty"`
+	InfluxToken    string   `yaml:"influx_token,omitempty"`
+	Graphite       string   `yaml:"graphite,omitempty"`
+	GraphitePrefix string   `yaml:"graphite_prefix,omitempty"`
+	Statsd         string   `yaml:"statsd,omitempty"`
+	StatsdPrefix   string   `yaml:"statsd_prefix,omitempty"`
+	StatsdTags     *bool    `yaml:"statsd_tags,omitempty"`
+	Kafka          string   `yaml:"kafka,omitempty"`
+	KafkaTopic     string   `yaml:"kafka_topic,omitempty"`
+	KafkaEvents    string   `yaml:"kafka_event_topic,omitempty"`
+	KafkaTLS       *bool    `yaml:"kafka_tls,omitempty"`
+}
+
+// DisplayConfig holds the dashboard settings of a -config file. Each field
+// corresponds to the flag of the same name; empty fields keep the flag's
+// default.
+type DisplayConfig struct {
+	ShowLoss   *bool   `yaml:"show_loss,omitempty"`
+	SelfTile   *bool   `yaml:"self_tile,omitempty"`
+	Smoothing  float64 `yaml:"smoothing,omitempty"`
+	Confirm    int     `yaml:"confirm,omitempty"`
+	LossWindow string  `yaml:"loss_window,omitempty"` // Duration, e.g. "5m"
+	LossProbes int     `yaml:"loss_probes,omitempty"`
+	FlapCount  *int    `yaml:"flap_count,omitempty"`
+	FlapWindow string  `yaml:"flap_window,omitempty"` // Duration, e.g. "10m"
+	Warn       int     `yaml:"warn,omitempty"`
+	Crit       int     `yaml:"crit,omitempty"`
+	LossWarn   float64 `yaml:"loss_warn,omitempty"`
+	LossCrit   float64 `yaml:"loss_crit,omitempty"`
+}
+
+// readConfigFile reads a -config file. Unknown keys are an error, so a typo
+// does not silently leave a setting at its default.
+func readConfigFile(path string) (FileConfig, error) {
+	var fc FileConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fc, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil {
+		return fc, fmt.Errorf("%s: %v", path, err)
+	}
+	return fc, nil
+}
+
+// flagValues returns the settings of fc as values of the flags they
+// correspond to. Repeatable flags get one value per entry.
+func (fc FileConfig) flagValues() map[string][]string {
+	values := make(map[string][]string)
+	str := func(name, v string) {
+		if v != "" {
+			values[name] = []string{v}
+		}
+	}
+	num := func(name string, v int) {
+		if v != 0 {
+			str(name, strconv.Itoa(v))
+		}
+	}
+	float := func(name string, v float64) {
+		if v != 0 {
+			str(name, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	boolean := func(name string, v *bool) {
+		if v != nil {
+			str(name, strconv.FormatBool(*v))
+		}
+	}
+	str("interval", fc.Interval)
+	num("count", fc.Count)
+	str("timeout", fc.Timeout)
+	num("size", fc.Size)
+	str("pause", strings.Join(fc.Pause, ","))
+	str("inventory", fc.Inventory)
+	str("nmap-xml", fc.NmapXML)
+	str("dhcp-leases", fc.Leases)
+	str("k8s", fc.K8s.Source)
+	str("k8s-pods", fc.K8s.Pods)
+	str("k8s-namespace", fc.K8s.Namespace)
+	str("docker", fc.Docker.Endpoint)
+	str("docker-label", fc.Docker.Label)
+	str("consul", fc.Consul.Address)
+	str("consul-tag", fc.Consul.Tag)
+	str("ec2-region", strings.Join(fc.EC2.Regions, ","))
+	str("ec2-filter", fc.EC2.Filter)
+	boolean("ec2-public", fc.EC2.Public)
+	str("gcp-project", strings.Join(fc.GCP.Projects, ","))
+	str("gcp-filter", fc.GCP.Filter)
+	boolean("gcp-public", fc.GCP.Public)
+	str("azure-subscription", strings.Join(fc.Azure.Subscriptions, ","))
+	str("azure-tag", fc.Azure.Tag)
+	boolean("azure-public", fc.Azure.Public)
+	str("dns-srv", strings.Join(fc.DNS.SRV, ","))
+	str("dns-axfr", strings.Join(fc.DNS.AXFR, ","))
+	boolean("mdns", fc.MDNS.Enabled)
+	str("mdns-service", strings.Join(fc.MDNS.Services, ","))
+	boolean("discover-lan", fc.LAN.Enabled)
+	str("discover-lan-exclude", strings.Join(fc.LAN.Exclude, ","))
+	str("tailscale", fc.Tailscale)
+	str("lldp", strings.Join(fc.LLDP.Seeds, ","))
+	if fc.LLDP.Depth != nil {
+		str("lldp-depth", strconv.Itoa(*fc.LLDP.Depth))
+	}
+	str("snmp-community", fc.LLDP.Community)
+	str("discover-interval", fc.DiscoverInterval)
+	str("include", fc.Include)
+	str("exclude", fc.Exclude)
+
+	s := fc.Server
+	str("listen", s.Listen)
+	str("allowed-origins", strings.Join(s.AllowedOrigins, ","))
+	str("api-token", s.APIToken)
+	str("env-allow", strings.Join(s.EnvAllow, ","))
+	if len(s.Notify) > 0 {
+		values["notify"] = s.Notify
+	}
+	num("workers", s.Workers)
+	num("max-pps", s.MaxPPS)
+	str("ping-mode", s.PingMode)
+	str("source", s.Source)
+	str("dscp", s.DSCP)
+	float("jitter", s.Jitter)
+	boolean("stagger", s.Stagger)
+	str("down-interval", s.DownInterval)
+	boolean("dns-refresh", s.DNSRefresh)
+	boolean("watch-file", s.WatchFile)
+	boolean("persist-hosts", s.PersistHosts)
+	boolean("dedupe", s.Dedupe)
+	str("history-db", s.HistoryDB)
+	str("history-retention", s.Retention)
+	if s.Downsample != nil {
+		values["history-downsample"] = []string{*s.Downsample}
+	}
+	str("history-backend", s.HistoryBackend)
+	str("history-instance", s.Instance)
+	num("history-size", s.HistorySize)
+	str("results-log", s.ResultsLog)
+	num("results-log-size", s.ResultsLogSize)
+	if s.ResultsLogKeep != nil {
+		str("results-log-keep", strconv.Itoa(*s.ResultsLogKeep))
+	}
+	str("report-email", s.ReportEmail)
+	str("report-schedule", s.ReportSchedule)
+	str("otlp-endpoint", s.OTLPEndpoint)
+	str("otlp-headers", s.OTLPHeaders)
+	boolean("otlp-spans", s.OTLPSpans)
+	str("influx-url", s.InfluxURL)
+	str("influx-db", s.InfluxDB)
+	str("influx-bucket", s.InfluxBucket)
+	str("influx-org", s.InfluxOrg)
+	str("influx-token", s.InfluxToken)
+	str("graphite", s.Graphite)
+	str("graphite-prefix", s.GraphitePrefix)
+	str("statsd", s.Statsd)
+	str("statsd-prefix", s.StatsdPrefix)
+	boolean("statsd-tags", s.StatsdTags)
+	str("kafka", s.Kafka)
+	str("kafka-topic", s.KafkaTopic)
+	str("kafka-event-topic", s.KafkaEvents)
+	boolean("kafka-tls", s.KafkaTLS)
+
+	d := fc.Display
+	boolean("show-loss", d.ShowLoss)
+	boolean("self-tile", d.SelfTile)
+	float("smoothing", d.Smoothing)
+	num("confirm", d.Confirm)
+	str("loss-window", d.LossWindow)
+	num("loss-probes", d.LossProbes)
+	if d.FlapCount != nil {
+		values["flap-count"] = []string{strconv.Itoa(*d.FlapCount)}
+	}
+	str("flap-window", d.FlapWindow)
+	num("warn", d.Warn)
+	num("crit", d.Crit)
+	float("loss-warn", d.LossWarn)
+	float("loss-crit", d.LossCrit)
+	return values
+}
+
+// applyFileFlags sets the flags of fs that fc has a value for, unless they
+// were given on the command line, and records the given ones in flagsGiven.
+//
+// Parameters:
+//   - fs: The parsed command line flags
+//   - fc: The -config file
+//
+// Returns:
+//   - error: If a value of fc is invalid for its flag
+func applyFileFlags(fs *flag.FlagSet, fc FileConfig) error {
+	fs.Visit(func(f *flag.Flag) { flagsGiven[f.Name] = true })
+	for name, values := range fc.flagValues() {
+		if flagsGiven[name] {
+			continue
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("invalid %s in %s: %v", name, configFile, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runtimeConfig returns the part of fc applied at startup and by
+// /api/config/reload, with the -override and -parents flags on top of its
+// thresholds, overrides and parents and without the global probe settings
+// given as flags, so flags keep taking precedence over the file.
+func (fc FileConfig) runtimeConfig(flags overrideFlag, given map[string]bool) Config {
+	c := fc.Config
+	c.Thresholds = make(map[string]Thresholds)
+	for h, th := range fc.Thresholds {
+		c.Thresholds[h] = th
+	}
+	for h, th := range flags.thresholds {
+		c.Thresholds[h] = th
+	}
+	c.Overrides = make(map[string]HostOverride)
+	for h, o := range fc.Overrides {
+		c.Overrides[h] = o
+	}
+	for h, o := range flags.overrides {
+		c.Overrides[h] = o
+	}
+	c.Parents = make(map[string]string)
+	for h, p := range fc.Parents {
+		c.Parents[h] = p
+	}
+	for h, p := range parentsFlag {
+		c.Parents[h] = p
+	}
+	if given["interval"] {
+		c.Interval = ""
+	}
+	if given["count"] {
+		c.Count = 0
+	}
+	if given["timeout"] {
+		c.Timeout = ""
+	}
+	if given["size"] {
+		c.Size = 0
+	}
+	return c
+}
+
+// configuredHosts reads the static hosts: those of -file, -inventory,
+// -nmap-xml, -dhcp-leases and -hosts, or those of the -config file if none is given,
+// filtered like readHosts.
+//
+// Parameters:
+//   - fc: The -config file, zero without one
+//
+// Returns:
+//   - []string: The hosts
+//   - map[string]HostLabel: The display names given in a hosts file in
+//     /etc/hosts format, the names and groups of -inventory hosts and the
+//     names of -nmap-xml hosts and the hostnames of -dhcp-leases clients
+//   - error: If the hosts file cannot be read or a CIDR or range entry
+//     cannot be expanded
+func configuredHosts(fc FileConfig) ([]string, map[string]HostLabel, error) {
+	if configFile != "" && hostsFile == "" && hostsFlag == "" && inventoryFile == "" && nmapFile == "" && leaseFile == "" {
+		hosts, err := intakeHosts(fc.Hosts)
+		return hosts, nil, err
+	}
+	return readHosts(hostsFile, hostsFlag)
+}
+
+// reloadConfigFile re-reads the -config file for /api/config/reload.
+//
+// Returns:
+//   - Config: The file's hosts, thresholds, overrides and probe settings, as
+//     they would apply at startup
+//   - error: If the file or the hosts cannot be read
+func reloadConfigFile() (Config, error) {
+	fc, err := readConfigFile(configFile)
+	if err != nil {
+		return Config{}, err
+	}
+	c := fc.runtimeConfig(overrideFlags, flagsGiven)
+	hosts, fileLabels, err := configuredHosts(fc)
+	if err != nil {
+		return Config{}, err
+	}
+	c.Hosts, c.Labels = hosts, withFileLabels(c.Labels, fileLabels)
+	return c, nil
+}
+
+// serverURL returns the dashboard URL for the -listen address addr, with
+// localhost standing in for an empty host.
+func serverURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfigFile = `
+hosts: [router, "db01:5432"]
+interval: 5s
+thresholds:
+  router: {warn_ms: 50, crit_ms: 100}
+overrides:
+  router: {timeout: 1s}
+pause: ["db01:5432"]
+server:
+  listen: ":9090"
+  workers: 16
+  stagger: true
+display:
+  show_loss: true
+  confirm: 3
+  flap_count: 0
+  loss_crit: 50
+`
+
+// writeConfigFile writes body to a config file in a temporary directory.
+func writeConfigFile(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "mosaic.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFile(t *testing.T) {
+	fc, err := readConfigFile(writeConfigFile(t, testConfigFile))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"router", "db01:5432"}, fc.Hosts)
+	assert.Equal(t, "5s", fc.Interval)
+	assert.Equal(t, Thresholds{WarnMs: 50, CritMs: 100}, fc.Thresholds["router"])
+	assert.Equal(t, ":9090", fc.Server.Listen)
+	assert.Equal(t, 3, fc.Display.Confirm)
+
+	values := fc.flagValues()
+	assert.Equal(t, []string{"5s"}, values["interval"])
+	assert.Equal(t, []string{"db01:5432"}, values["pause"])
+	assert.Equal(t, []string{"16"}, values["workers"])
+	assert.Equal(t, []string{"true"}, values["stagger"])
+	assert.Equal(t, []string{"true"}, values["show-loss"])
+	assert.Equal(t, []string{"0"}, values["flap-count"])
+	assert.Equal(t, []string{"50"}, values["loss-crit"])
+	assert.NotContains(t, values, "timeout")
+
+	_, err = readConfigFile(writeConfigFile(t, "hosts: [a]\ndisplay:\n  show_los: true\n"))
+	assert.Error(t, err, "unknown keys are rejected")
+	_, err = readConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestApplyFileFlagsKeepsCommandLine(t *testing.T) {
+	defer func(given map[string]bool) { flagsGiven = given }(flagsGiven)
+	flagsGiven = make(map[string]bool)
+	fs := flag.NewFlagSet("mosaic", flag.ContinueOnError)
+	interval := fs.Duration("interval", 2*time.Second, "")
+	workers := fs.Int("workers", 64, "")
+	listen := fs.String("listen", ":8080", "")
+	showLoss := fs.Bool("show-loss", false, "")
+	fs.String("pause", "", "")
+	fs.Int("confirm", 1, "")
+	fs.Int("flap-count", 5, "")
+	fs.Bool("stagger", false, "")
+	lossCrit := fs.Float64("loss-crit", 20, "")
+	assert.NoError(t, fs.Parse([]string{"-interval", "10s", "-workers", "8"}))
+
+	fc, err := readConfigFile(writeConfigFile(t, testConfigFile))
+	assert.NoError(t, err)
+	assert.NoError(t, applyFileFlags(fs, fc))
+	assert.Equal(t, 10*time.Second, *interval, "flags override the file")
+	assert.Equal(t, 8, *workers)
+	assert.Equal(t, ":9090", *listen)
+	assert.True(t, *showLoss)
+	assert.Equal(t, 50.0, *lossCrit)
+	assert.Equal(t, map[string]bool{"interval": true, "workers": true}, flagsGiven)
+
+	flagsGiven = make(map[string]bool)
+	fs = flag.NewFlagSet("mosaic", flag.ContinueOnError)
+	fs.Duration("interval", 2*time.Second, "")
+	assert.Error(t, applyFileFlags(fs, FileConfig{Config: Config{Interval: "soon"}}))
+}
+
+func TestRuntimeConfigFlagsWin(t *testing.T) {
+	fc, err := readConfigFile(writeConfigFile(t, testConfigFile))
+	assert.NoError(t, err)
+	var flags overrideFlag
+	assert.NoError(t, flags.Set("router timeout=3s warn=80 crit=160"))
+
+	c := fc.runtimeConfig(flags, map[string]bool{"interval": true})
+	assert.Equal(t, Thresholds{WarnMs: 80, CritMs: 160}, c.Thresholds["router"])
+	assert.Equal(t, "3s", c.Overrides["router"].Timeout)
+	assert.Empty(t, c.Interval, "-interval keeps its flag value")
+	assert.Equal(t, "1s", fc.Overrides["router"].Timeout, "the file config is not changed")
+
+	c = fc.runtimeConfig(overrideFlag{}, nil)
+	assert.Equal(t, "5s", c.Interval)
+	assert.Equal(t, Thresholds{WarnMs: 50, CritMs: 100}, c.Thresholds["router"])
+}
+
+func TestConfiguredHosts(t *testing.T) {
+	defer func(c, f, h string) { configFile, hostsFile, hostsFlag = c, f, h }(configFile, hostsFile, hostsFlag)
+	fc := FileConfig{Config: Config{Hosts: []string{"router"}}}
+	configFile, hostsFile, hostsFlag = "mosaic.yaml", "", ""
+	got, _, err := configuredHosts(fc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"router"}, got)
+
+	hostsFlag = "a,b"
+	got, _, err = configuredHosts(fc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got, "-hosts replaces the file's hosts")
+}
+
+func TestServerURL(t *testing.T) {
+	assert.Equal(t, "http://localhost:8080", serverURL(":8080"))
+	assert.Equal(t, "http://192.0.2.1:9090", serverURL("192.0.2.1:9090"))
+	assert.Equal(t, "http://[::1]:9090", serverURL("[::1]:9090"))
+	assert.Equal(t, "http://localhost:80", serverURL("0.0.0.0:80"))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// consulAddr is the -consul value: the HTTP address of a Consul agent,
+	// e.g. http://127.0.0.1:8500. Empty turns catalog discovery off.
+	consulAddr string
+	// consulTag is the -consul-tag value: the tag a service instance must
+	// carry to be monitored, or "" for all instances.
+	consulTag string
+)
+
+// consulWait is how long a blocking query of the catalog waits for a
+// change before it returns and is sent again.
+const consulWait = 5 * time.Minute
+
+// consulClient talks to the HTTP API of a Consul agent.
+type consulClient struct {
+	base  string // e.g. http://127.0.0.1:8500
+	token string // ACL token from CONSUL_HTTP_TOKEN, or ""
+	http  *http.Client
+}
+
+// newConsulClient creates a client for a -consul address. A bare
+// host:port means plain HTTP. The ACL token is taken from the
+// CONSUL_HTTP_TOKEN environment variable, like the Consul CLI does.
+func newConsulClient(addr string) (*consulClient, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Consul address %q", addr)
+	}
+	return &consulClient{
+		base:  strings.TrimSuffix(u.String(), "/"),
+		token: os.Getenv("CONSUL_HTTP_TOKEN"),
+		http:  &http.Client{Timeout: consulWait + time.Minute},
+	}, nil
+}
+
+// get sends a GET request for path to the agent and decodes the JSON
+// response into v. A non-zero index makes it a blocking query that returns
+// once the catalog changes past index, or after consulWait.
+//
+// Returns:
+//   - uint64: The X-Consul-Index of the response
+//   - error: If the agent cannot be reached or rejects the request
+func (c *consulClient) get(ctx context.Context, path string, query url.Values, index uint64, v any) (uint64, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWait.String())
+	}
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("%s %s", http.StatusText(resp.StatusCode), strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return 0, fmt.Errorf("invalid response to %s: %v", path, err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return next, nil
+}
+
+// consulInstance is a service instance in the Consul catalog.
+type consulInstance struct {
+	Node           string `json:"Node"`
+	Address        string `json:"Address"` // Address of the node
+	ServiceID      string `json:"ServiceID"`
+	ServiceName    string `json:"ServiceName"`
+	ServiceAddress string `json:"ServiceAddress"` // Empty if the service uses the node address
+	ServicePort    int    `json:"ServicePort"`
+}
+
+// instanceEntry returns the host entry of a service instance: a logical
+// host named "<service ID>.<node>" with a TCP probe of the service port,
+// e.g. "web-1.node-a=10.0.5.7:8080", or a ping of the address for services
+// without a port.
+func instanceEntry(i consulInstance) string {
+	addr := i.ServiceAddress
+	if addr == "" {
+		addr = i.Address
+	}
+	if addr == "" {
+		return ""
+	}
+	if i.ServicePort > 0 {
+		addr = net.JoinHostPort(addr, strconv.Itoa(i.ServicePort))
+	}
+	id := i.ServiceID
+	if id == "" {
+		id = i.ServiceName
+	}
+	return logicalEntry(hostLabel(id+"."+i.Node), []string{addr})
+}
+
+// discoverConsul monitors the service instances of the Consul catalog, or
+// those tagged -consul-tag, until ctx is done. Each instance is in the
+// group named after its service.
+func discoverConsul(ctx context.Context, c *consulClient) {
+	d := startReconciler(ctx, "Consul")
+	go runDiscovery(ctx, "Consul discovery", func(ctx context.Context) error {
+		return followConsul(ctx, c, d)
+	})
+}
+
+// followConsul reads the service instances of the catalog into d, and
+// again whenever a blocking query of the service list returns a new index,
+// which happens when any instance registers or deregisters.
+//
+// Returns:
+//   - error: If the agent cannot be reached or rejects a request
+func followConsul(ctx context.Context, c *consulClient, d *reconciler) error {
+	var index uint64
+	for {
+		var services map[string][]string
+		next, err := c.get(ctx, "/v1/catalog/services", nil, index, &services)
+		if err != nil {
+			return err
+		}
+		switch {
+		case next != 0 && next == index:
+			continue // The wait timed out without a change
+		case next < index:
+			// The index went backwards, e.g. after a restore: start over
+			index = 0
+			continue
+		}
+		names := make([]string, 0, len(services))
+		for name, tags := range services {
+			if consulTag == "" || slices.Contains(tags, consulTag) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		entries := make(map[string]discovered)
+		for _, name := range names {
+			var query url.Values
+			if consulTag != "" {
+				query = url.Values{"tag": {consulTag}}
+			}
+			var instances []consulInstance
+			if _, err := c.get(ctx, "/v1/catalog/service/"+url.PathEscape(name), query, 0, &instances); err != nil {
+				return err
+			}
+			for _, i := range instances {
+				if e := instanceEntry(i); e != "" {
+					entries[i.Node+"/"+i.ServiceID] = discovered{entry: e, groups: []string{i.ServiceName}}
+				}
+			}
+		}
+		d.replace("service", entries)
+		if next == 0 {
+			// Without an index blocking is not possible; poll instead
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(discoveryRetry):
+			}
+		}
+		index = next
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceEntry(t *testing.T) {
+	i := consulInstance{Node: "node-a", Address: "10.0.5.7", ServiceID: "web-1", ServiceName: "web", ServicePort: 8080}
+	assert.Equal(t, "web-1.node-a=10.0.5.7:8080", instanceEntry(i))
+	assert.NoError(t, validateHost(instanceEntry(i)))
+
+	i.ServiceAddress, i.ServicePort = "fd00::7", 0
+	assert.Equal(t, "web-1.node-a=fd00::7", instanceEntry(i), "service address, pinged without a port")
+
+	i.ServiceID = "redis:6379"
+	assert.Equal(t, "redis-6379.node-a=fd00::7", instanceEntry(i), "delimiters are replaced")
+
+	assert.Empty(t, instanceEntry(consulInstance{Node: "x", ServiceName: "y"}))
+}
+
+func TestNewConsulClient(t *testing.T) {
+	t.Setenv("CONSUL_HTTP_TOKEN", "acl")
+	c, err := newConsulClient("127.0.0.1:8500")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8500", c.base)
+	assert.Equal(t, "acl", c.token)
+
+	_, err = newConsulClient("ftp://consul")
+	assert.Error(t, err)
+}
+
+func TestConsulDiscoveryFollowsCatalog(t *testing.T) {
+	withHosts(t)
+	setLabels(nil)
+	oldTag := consulTag
+	consulTag = "prod"
+	t.Cleanup(func() {
+		consulTag = oldTag
+		labelsMu.Lock()
+		discoveredGroups = make(map[string][]string)
+		labelsMu.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var polls atomic.Int32
+	var listed []string // Hosts after the first read of the catalog
+	var groups []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/catalog/services", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "acl", r.Header.Get("X-Consul-Token"))
+		switch polls.Add(1) {
+		case 1:
+			assert.Empty(t, r.URL.Query().Get("index"))
+			w.Header().Set("X-Consul-Index", "7")
+			fmt.Fprint(w, `{"web":["prod"],"batch":["dev"]}`)
+		case 2:
+			listed, groups = currentHosts(), hostGroups("web.n1=10.0.0.1:80")
+			assert.Equal(t, "7", r.URL.Query().Get("index"))
+			w.Header().Set("X-Consul-Index", "9")
+			fmt.Fprint(w, `{"batch":["dev"]}`)
+		default:
+			cancel()
+			w.Header().Set("X-Consul-Index", "9")
+			fmt.Fprint(w, `{}`)
+		}
+	})
+	mux.HandleFunc("/v1/catalog/service/web", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "prod", r.URL.Query().Get("tag"))
+		fmt.Fprint(w, `[{"Node":"n1","Address":"10.0.0.1","ServiceID":"web","ServiceName":"web","ServicePort":80},
+			{"Node":"n2","Address":"10.0.0.2","ServiceID":"web","ServiceName":"web","ServiceAddress":"10.0.1.2","ServicePort":80}]`)
+	})
+	mux.HandleFunc("/v1/catalog/service/batch", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("services without the tag are not read")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Setenv("CONSUL_HTTP_TOKEN", "acl")
+	c, err := newConsulClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = followConsul(ctx, c, newReconciler("Consul"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"web.n1=10.0.0.1:80", "web.n2=10.0.1.2:80"}, listed)
+	assert.Equal(t, []string{"web"}, groups, "instances are grouped by service")
+	assert.Empty(t, currentHosts(), "instances of deregistered services are removed")
+}
+
+func TestDiscoveredGroups(t *testing.T) {
+	setLabels(map[string]HostLabel{"b": {Groups: []string{"core"}}})
+	t.Cleanup(func() {
+		setLabels(nil)
+		labelsMu.Lock()
+		discoveredGroups = make(map[string][]string)
+		labelsMu.Unlock()
+	})
+	setDiscoveredGroups("a", []string{"web"})
+	setDiscoveredGroups("b", []string{"web"})
+
+	assert.Equal(t, []string{"web"}, hostGroups("a"))
+	assert.Equal(t, []string{"core"}, hostGroups("b"), "labels win")
+	assert.True(t, matchGroup("@web", "a"))
+	statuses := []HostStatus{{Host: "a"}, {Host: "b"}}
+	annotateLabels(statuses)
+	assert.Equal(t, []string{"web"}, statuses[0].Groups)
+	assert.Equal(t, []string{"core"}, statuses[1].Groups)
+
+	setDiscoveredGroups("a", nil)
+	assert.Empty(t, hostGroups("a"))
+}
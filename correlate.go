@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CorrelatedIncident is a group of hosts sharing a likely common cause
+// (same subnet or parent domain) that became unhealthy together.
+type CorrelatedIncident struct {
+	Key     string    `json:"key"`     // Correlation group, e.g. "net:10.0.5.0/24"
+	Since   time.Time `json:"since"`   // When the incident was opened
+	Members []string  `json:"members"` // Currently unhealthy hosts in the group
+}
+
+const (
+	// correlationMinHosts is how many hosts of a group must be unhealthy
+	// together before a correlated incident is opened.
+	correlationMinHosts = 2
+	// correlationWindow is how close together hosts must have turned
+	// unhealthy to count as simultaneous.
+	correlationWindow = 30 * time.Second
+)
+
+// correlator tracks unhealthy hosts and groups simultaneous failures.
+type correlator struct {
+	mu        sync.Mutex
+	since     map[string]time.Time           // When each unhealthy host turned unhealthy
+	incidents map[string]*CorrelatedIncident // Open incidents by group key
+}
+
+var correlations = newCorrelator()
+
+// newCorrelator creates a correlator without any state.
+func newCorrelator() *correlator {
+	return &correlator{
+		since:     make(map[string]time.Time),
+		incidents: make(map[string]*CorrelatedIncident),
+	}
+}
+
+// unhealthy reports whether s counts as degraded for correlation purposes:
+// down, degraded, or slower than its warning threshold.
+func unhealthy(s HostStatus) bool {
+	warn := s.WarnMs
+	if warn == 0 {
+		warn = globalThresholds.WarnMs
+	}
+	return !s.Alive || s.Degraded || s.LatencyMs > warn
+}
+
+// correlationKeys returns the groups a host belongs to: its /24 (or /64 for
+// IPv6) when it is an IP address, otherwise its parent domain, and the
+// groups of its label.
+func correlationKeys(host string) []string {
+	var keys []string
+	for _, g := range hostGroups(host) {
+		keys = append(keys, "group:"+g)
+	}
+	if _, members, ok := splitAlias(host); ok {
+		host = members[0]
+	}
+	_, addr := splitScheme(host)
+	addr, _ = splitOptions(addr)
+	addr, _, _ = strings.Cut(addr, ",")
+	if net.ParseIP(strings.Trim(addr, "[]")) != nil {
+		addr = strings.Trim(addr, "[]")
+	} else if u, err := url.Parse("//" + addr); err == nil && u.Hostname() != "" {
+		addr = u.Hostname()
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		if v4 := ip.To4(); v4 != nil {
+			return append(keys, "net:"+(&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String())
+		}
+		return append(keys, "net:"+(&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String())
+	}
+	labels := strings.Split(strings.TrimSuffix(addr, "."), ".")
+	if len(labels) < 3 {
+		return keys
+	}
+	return append(keys, "domain:"+strings.Join(labels[1:], "."))
+}
+
+// update feeds the latest cycle into the correlator, emitting one event when
+// a correlated incident opens and one when it resolves.
+//
+// Parameters:
+//   - statuses: Result of the latest ping cycle
+//   - now: Time at which the cycle finished
+//
+// Returns:
+//   - []CorrelatedIncident: Incidents open after this cycle, ordered by key
+func (c *correlator) update(statuses []HostStatus, now time.Time) []CorrelatedIncident {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	groups := make(map[string][]string)
+	for _, s := range statuses {
+		// A flapping host keeps the health it had when it started flapping,
+		// so it doesn't open and resolve incidents over and over
+		if s.Flapping {
+			if _, ok := c.since[s.Host]; ok {
+				for _, key := range correlationKeys(s.Host) {
+					groups[key] = append(groups[key], s.Host)
+				}
+			}
+			continue
+		}
+		// Hosts unreachable behind a parent that is down raise no alerts of
+		// their own, the parent stands for them
+		if s.Paused || s.Unreachable || !unhealthy(s) {
+			delete(c.since, s.Host)
+			continue
+		}
+		if _, ok := c.since[s.Host]; !ok {
+			c.since[s.Host] = now
+		}
+		for _, key := range correlationKeys(s.Host) {
+			groups[key] = append(groups[key], s.Host)
+		}
+	}
+
+	for key, members := range groups {
+		sort.Strings(members)
+		if inc, ok := c.incidents[key]; ok {
+			inc.Members = members
+			continue
+		}
+		recent := 0
+		for _, h := range members {
+			if now.Sub(c.since[h]) <= correlationWindow {
+				recent++
+			}
+		}
+		if recent < correlationMinHosts {
+			continue
+		}
+		c.incidents[key] = &CorrelatedIncident{Key: key, Since: now, Members: members}
+		events.add(Event{
+			Time:    now,
+			Type:    "correlated_incident",
+			Key:     key,
+			Hosts:   members,
+			Message: fmt.Sprintf("%d hosts in %s degraded together", len(members), key),
+		})
+	}
+
+	for key, inc := range c.incidents {
+		if len(groups[key]) >= correlationMinHosts {
+			continue
+		}
+		delete(c.incidents, key)
+		events.add(Event{
+			Time:    now,
+			Type:    "correlated_incident_resolved",
+			Key:     key,
+			Hosts:   inc.Members,
+			Message: fmt.Sprintf("correlated incident in %s resolved after %s", key, now.Sub(inc.Since).Round(time.Second)),
+		})
+	}
+
+	open := make([]CorrelatedIncident, 0, len(c.incidents))
+	for _, inc := range c.incidents {
+		open = append(open, *inc)
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].Key < open[j].Key })
+	return open
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationKeys(t *testing.T) {
+	assert.Equal(t, []string{"net:10.0.5.0/24"}, correlationKeys("10.0.5.17"))
+	assert.Equal(t, []string{"net:10.0.5.0/24"}, correlationKeys("ssh://10.0.5.18:2222"))
+	assert.Equal(t, []string{"net:2001:db8:1:2::/64"}, correlationKeys("2001:db8:1:2::7"))
+	assert.Equal(t, []string{"domain:dc1.example.com"}, correlationKeys("web01.dc1.example.com"))
+	assert.Equal(t, []string{"domain:example.com"}, correlationKeys("postgres://u:p@db01.example.com:5432/app"))
+	assert.Nil(t, correlationKeys("localhost"))
+	assert.Nil(t, correlationKeys("example.com"))
+}
+
+func TestUnhealthy(t *testing.T) {
+	assert.True(t, unhealthy(HostStatus{Alive: false}))
+	assert.True(t, unhealthy(HostStatus{Alive: true, Degraded: true}))
+	assert.True(t, unhealthy(HostStatus{Alive: true, LatencyMs: 151}))
+	assert.False(t, unhealthy(HostStatus{Alive: true, LatencyMs: 151, WarnMs: 300}))
+	assert.False(t, unhealthy(HostStatus{Alive: true, LatencyMs: 10}))
+}
+
+func TestCorrelatorOpensAndResolves(t *testing.T) {
+	old := events
+	defer func() { events = old }()
+	events = newEventLog(10)
+
+	c := newCorrelator()
+	now := time.Now()
+	up := func(h string) HostStatus { return HostStatus{Host: h, Alive: true, LatencyMs: 5} }
+	down := func(h string) HostStatus { return HostStatus{Host: h, Alive: false} }
+
+	// A single failing host is not an incident
+	open := c.update([]HostStatus{down("10.0.5.1"), up("10.0.5.2"), up("10.0.6.1")}, now)
+	assert.Empty(t, open)
+
+	// A second host in the same /24 fails shortly after: one incident for both
+	open = c.update([]HostStatus{down("10.0.5.1"), down("10.0.5.2"), down("10.0.6.1")}, now.Add(2*time.Second))
+	assert.Len(t, open, 1)
+	assert.Equal(t, "net:10.0.5.0/24", open[0].Key)
+	assert.Equal(t, []string{"10.0.5.1", "10.0.5.2"}, open[0].Members)
+	assert.Len(t, events.recent(), 1)
+	assert.Equal(t, "correlated_incident", events.recent()[0].Type)
+
+	// Staying down does not emit more events
+	open = c.update([]HostStatus{down("10.0.5.1"), down("10.0.5.2"), down("10.0.6.1")}, now.Add(4*time.Second))
+	assert.Len(t, open, 1)
+	assert.Len(t, events.recent(), 1)
+
+	open = c.update([]HostStatus{up("10.0.5.1"), down("10.0.5.2"), down("10.0.6.1")}, now.Add(6*time.Second))
+	assert.Empty(t, open)
+	assert.Len(t, events.recent(), 2)
+	assert.Equal(t, "correlated_incident_resolved", events.recent()[0].Type)
+}
+
+func TestCorrelatorIgnoresUnrelatedTiming(t *testing.T) {
+	old := events
+	defer func() { events = old }()
+	events = newEventLog(10)
+
+	c := newCorrelator()
+	now := time.Now()
+	c.update([]HostStatus{{Host: "10.0.5.1"}}, now)
+	// The neighbour fails long after the first host: not simultaneous
+	open := c.update([]HostStatus{{Host: "10.0.5.1"}, {Host: "10.0.5.2"}}, now.Add(time.Hour))
+	assert.Empty(t, open)
+	assert.Empty(t, events.recent())
+}
+
+func TestEventLogRetention(t *testing.T) {
+	l := newEventLog(2)
+	l.add(Event{Type: "a"})
+	l.add(Event{Type: "b"})
+	l.add(Event{Type: "c"})
+	recent := l.recent()
+	assert.Len(t, recent, 2)
+	assert.Equal(t, "c", recent[0].Type)
+	assert.Equal(t, "b", recent[1].Type)
+	assert.False(t, recent[0].Time.IsZero())
+}
+
+func TestCorrelatorIgnoresFlapping(t *testing.T) {
+	old := events
+	defer func() { events = old }()
+	events = newEventLog(10)
+
+	c := newCorrelator()
+	now := time.Now()
+	c.update([]HostStatus{{Host: "10.0.5.1"}, {Host: "10.0.5.2"}}, now)
+	assert.Len(t, events.recent(), 1)
+
+	// A flapping host stays in the incident whatever its latest probe says
+	open := c.update([]HostStatus{{Host: "10.0.5.1", Alive: true, Flapping: true}, {Host: "10.0.5.2"}}, now.Add(2*time.Second))
+	assert.Len(t, open, 1)
+	assert.Len(t, events.recent(), 1)
+
+	// And one that was healthy doesn't open one
+	c = newCorrelator()
+	open = c.update([]HostStatus{{Host: "10.0.6.1", Flapping: true}, {Host: "10.0.6.2"}}, now)
+	assert.Empty(t, open)
+}
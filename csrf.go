@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// Browser sessions get a random ID in the session cookie when the dashboard
+// is loaded and the matching CSRF token embedded in the page. Mutating
+// requests that carry the cookie must send the token in the X-CSRF-Token
+// header, so another site open on the same NOC machine cannot make the
+// browser act on mosaic. Requests without the cookie, such as curl or
+// scripts, don't need a token but are still rejected when a browser marks
+// them as coming from another origin.
+const (
+	sessionCookie   = "mosaic_session"
+	csrfHeader      = "X-CSRF-Token"
+	csrfPlaceholder = "{{CSRF_TOKEN}}"
+)
+
+var (
+	// csrfSecret signs CSRF tokens. It is random per process, so sessions
+	// end when mosaic restarts.
+	csrfSecret = func() []byte {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		return b
+	}()
+
+	originsMu sync.Mutex
+	// allowedOrigins are origins besides mosaic's own that may call
+	// mutating endpoints and open the WebSocket, set with -allowed-origins.
+	allowedOrigins = make(map[string]bool)
+)
+
+// csrfToken returns the CSRF token belonging to a session ID.
+func csrfToken(session string) string {
+	mac := hmac.New(sha256.New, csrfSecret)
+	mac.Write([]byte(session))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ensureSession returns the session of the request's browser, starting a
+// new one with a fresh cookie if it has none.
+//
+// Parameters:
+//   - w: Response the session cookie is set on
+//   - r: Request that may carry the session cookie
+//
+// Returns:
+//   - string: The CSRF token of the session
+func ensureSession(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		return csrfToken(c.Value)
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfToken(id)
+}
+
+// setAllowedOrigins parses a comma-separated list of origins such as
+// "https://noc.example.com".
+func setAllowedOrigins(s string) error {
+	origins := make(map[string]bool)
+	for _, o := range strings.Split(s, ",") {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid origin %q", o)
+		}
+		origins[u.Scheme+"://"+u.Host] = true
+	}
+	originsMu.Lock()
+	allowedOrigins = origins
+	originsMu.Unlock()
+	return nil
+}
+
+// originAllowed reports whether a request from origin may act on mosaic
+// served at host: the origin is mosaic itself or explicitly allowed.
+func originAllowed(origin *url.URL, host string) bool {
+	if strings.EqualFold(origin.Host, host) {
+		return true
+	}
+	originsMu.Lock()
+	defer originsMu.Unlock()
+	return allowedOrigins[origin.Scheme+"://"+origin.Host]
+}
+
+// requestOrigin returns the origin a browser request comes from, taken from
+// the Origin header or, for browsers that don't send one, the Referer. It is
+// nil for requests that carry neither; an unparsable or "null" origin yields
+// an empty URL that no host matches.
+func requestOrigin(r *http.Request) *url.URL {
+	v := r.Header.Get("Origin")
+	if v == "" {
+		v = r.Header.Get("Referer")
+	}
+	if v == "" {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return &url.URL{}
+	}
+	return u
+}
+
+// checkCSRF returns why a request must be rejected, or "" if it may proceed.
+// Safe methods are always allowed.
+func checkCSRF(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ""
+	}
+	if origin := requestOrigin(r); origin != nil && !originAllowed(origin, r.Host) {
+		return "cross-origin request rejected"
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		token := r.Header.Get(csrfHeader)
+		if token == "" || !hmac.Equal([]byte(token), []byte(csrfToken(c.Value))) {
+			return "missing or invalid CSRF token"
+		}
+	}
+	return ""
+}
+
+// csrfProtect wraps a handler of mutating endpoints with the CSRF checks.
+func csrfProtect(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if reason := checkCSRF(r); reason != "" {
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// wsHandshake accepts WebSocket connections only from mosaic's own pages
+// and allowed origins, since clients can send commands over the socket.
+// Non-browser clients must send an Origin header as well.
+func wsHandshake(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || !originAllowed(origin, r.Host) {
+		return fmt.Errorf("origin %v not allowed", origin)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// dashboardHandler serves the dashboard with the CSRF token of the
+// browser's session.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	// The embedded build has nothing to protect with a session
+	var token string
+	if !embeddedBuild {
+		token = ensureSession(w, r)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(strings.Replace(getDashboardHTML(), csrfPlaceholder, token, 1)))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+// csrfRequest sends a request through csrfProtect and returns the status.
+func csrfRequest(method string, headers map[string]string, cookie *http.Cookie) int {
+	r := httptest.NewRequest(method, "http://mosaic.local:8080/api/hosts", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	csrfProtect(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+	return w.Code
+}
+
+func TestDashboardSession(t *testing.T) {
+	if embeddedBuild {
+		t.Skip("the embedded build has no sessions")
+	}
+	w := httptest.NewRecorder()
+	dashboardHandler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected a session cookie, got %v", cookies)
+	}
+	assert.Equal(t, sessionCookie, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Contains(t, w.Body.String(), csrfToken(cookies[0].Value))
+	assert.NotContains(t, w.Body.String(), csrfPlaceholder)
+
+	// A returning browser keeps its session
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	dashboardHandler(w, r)
+	assert.Empty(t, w.Result().Cookies())
+	assert.Contains(t, w.Body.String(), csrfToken(cookies[0].Value))
+}
+
+func TestCSRFProtect(t *testing.T) {
+	session := &http.Cookie{Name: sessionCookie, Value: "abc"}
+	token := csrfToken("abc")
+
+	// Safe methods and cookie-less API clients pass
+	assert.Equal(t, http.StatusOK, csrfRequest(http.MethodGet, map[string]string{"Origin": "https://evil.example"}, session))
+	assert.Equal(t, http.StatusOK, csrfRequest(http.MethodPost, nil, nil))
+
+	// Browser sessions need the token
+	assert.Equal(t, http.StatusForbidden, csrfRequest(http.MethodPost, nil, session))
+	assert.Equal(t, http.StatusForbidden, csrfRequest(http.MethodPost, map[string]string{csrfHeader: csrfToken("other")}, session))
+	assert.Equal(t, http.StatusOK, csrfRequest(http.MethodDelete, map[string]string{csrfHeader: token}, session))
+	assert.Equal(t, http.StatusOK, csrfRequest(http.MethodPost, map[string]string{csrfHeader: token, "Origin": "http://mosaic.local:8080"}, session))
+
+	// Other origins are rejected, token or not
+	assert.Equal(t, http.StatusForbidden, csrfRequest(http.MethodPost, map[string]string{csrfHeader: token, "Origin": "https://evil.example"}, session))
+	assert.Equal(t, http.StatusForbidden, csrfRequest(http.MethodPost, map[string]string{"Origin": "https://evil.example"}, nil))
+	assert.Equal(t, http.StatusForbidden, csrfRequest(http.MethodPost, map[string]string{"Origin": "null"}, nil))
+	assert.Equal(t, http.StatusForbidden, csrfRequest(http.MethodPost, map[string]string{"Referer": "https://evil.example/page"}, nil))
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	defer setAllowedOrigins("")
+	assert.Error(t, setAllowedOrigins("noc.example.com"))
+	assert.NoError(t, setAllowedOrigins("https://noc.example.com, https://wall.example.com:8443"))
+
+	assert.Equal(t, http.StatusOK, csrfRequest(http.MethodPost, map[string]string{"Origin": "https://noc.example.com"}, nil))
+	assert.Equal(t, http.StatusOK, csrfRequest(http.MethodPost, map[string]string{"Origin": "https://wall.example.com:8443"}, nil))
+	assert.Equal(t, http.StatusForbidden, csrfRequest(http.MethodPost, map[string]string{"Origin": "http://noc.example.com"}, nil))
+}
+
+func TestWSHandshakeOrigin(t *testing.T) {
+	server := httptest.NewServer(websocket.Server{Handler: wsHandler, Handshake: wsHandshake})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	_, err := websocket.Dial(url, "", "https://evil.example/")
+	assert.Error(t, err)
+
+	clientsMu.Lock()
+	before := len(clients)
+	clientsMu.Unlock()
+	ws, err := websocket.Dial(url, "", server.URL+"/")
+	if assert.NoError(t, err) {
+		ws.Close()
+	}
+	// Let the handler unregister the client before other tests count them
+	assert.Eventually(t, func() bool {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		return len(clients) == before
+	}, time.Second, 5*time.Millisecond)
+}
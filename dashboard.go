@@ -20,5 +20,5 @@ var dashboardHTML string
 // Returns:
 //   - string: The complete HTML, CSS, and JavaScript for the dashboard
 func getDashboardHTML() string {
-    return dashboardHTML
+	return dashboardHTML
 }
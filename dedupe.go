@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// dedupeByIP probes hosts that resolve to the same address only once, set
+// with -dedupe, and shows the others as aliases on the first one's tile, so
+// they don't double-count in the statistics. Hostnames are compared by the
+// address their last probe resolved them to, which needs -dns-refresh.
+var dedupeByIP bool
+
+// ipDedup remembers which hosts were collapsed into which.
+type ipDedup struct {
+	mu      sync.Mutex
+	primary map[string]string   // Collapsed host -> host probed in its place
+	aliases map[string][]string // Probed host -> hosts collapsed into it
+}
+
+var dedup = newIPDedup()
+
+// newIPDedup creates an ipDedup with nothing collapsed.
+func newIPDedup() *ipDedup {
+	return &ipDedup{primary: make(map[string]string), aliases: make(map[string][]string)}
+}
+
+// collapse picks the hosts of hosts to probe: of hosts sharing an address
+// only the first. Probed hosts are compared by the address in their latest
+// status; collapsed ones are resolved again whenever their DNS record
+// expires, so one that moves to another address is probed again.
+//
+// Parameters:
+//   - hosts: The monitored hosts, in order
+//   - latest: The latest status of each host that has one
+//
+// Returns:
+//   - []string: The hosts to probe and show
+func (d *ipDedup) collapse(hosts []string, latest []HostStatus) []string {
+	if !dedupeByIP {
+		return hosts
+	}
+	ips := make(map[string]string, len(latest))
+	for _, st := range latest {
+		ips[st.Host] = st.IP
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	byIP := make(map[string]string)
+	primary := make(map[string]string)
+	aliases := make(map[string][]string)
+	probed := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		ip := ips[h]
+		if _, ok := d.primary[h]; ok {
+			_, ip, _ = resolvedAddr(h, h)
+		}
+		if net.ParseIP(strings.Trim(h, "[]")) != nil {
+			ip = strings.Trim(h, "[]")
+		}
+		if ip == "" || strings.Contains(h, "?") {
+			probed = append(probed, h)
+			continue
+		}
+		if p, ok := byIP[ip]; ok {
+			primary[h] = p
+			aliases[p] = append(aliases[p], h)
+			if d.primary[h] != p {
+				log.Printf("%s resolves to %s like %s, probing it once", h, ip, p)
+			}
+			continue
+		}
+		byIP[ip] = h
+		probed = append(probed, h)
+	}
+	d.primary, d.aliases = primary, aliases
+	return probed
+}
+
+// annotate sets the aliases of statuses from the last collapse.
+func (d *ipDedup) annotate(statuses []HostStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range statuses {
+		statuses[i].Aliases = d.aliases[statuses[i].Host]
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollapseByIP(t *testing.T) {
+	defer func(enabled bool, cache *dnsCache) { dedupeByIP, resolved = enabled, cache }(dedupeByIP, resolved)
+	resolved = newDNSCache()
+	table := map[string][]string{"nas.example": {"192.0.2.10"}, "files.example": {"192.0.2.10"}}
+	mockLookupIP(t, table)
+	mockLookupTTL(t, 0)
+	hosts := []string{"nas.example", "tcp://nas.example:445", "files.example", "192.0.2.10", "files.example?count=3", "new.example"}
+	latest := []HostStatus{
+		{Host: "nas.example", IP: "192.0.2.10"},
+		{Host: "tcp://nas.example:445"},
+		{Host: "files.example", IP: "192.0.2.10"},
+		{Host: "192.0.2.10"},
+		{Host: "files.example?count=3", IP: "192.0.2.10"},
+	}
+
+	d := newIPDedup()
+	dedupeByIP = false
+	assert.Equal(t, hosts, d.collapse(hosts, latest))
+
+	dedupeByIP = true
+	assert.Equal(t, []string{"nas.example", "tcp://nas.example:445", "files.example?count=3", "new.example"}, d.collapse(hosts, latest),
+		"only plain ICMP hosts are collapsed, and new ones are probed first")
+	statuses := []HostStatus{{Host: "nas.example"}, {Host: "new.example"}}
+	d.annotate(statuses)
+	assert.Equal(t, []string{"files.example", "192.0.2.10"}, statuses[0].Aliases)
+	assert.Nil(t, statuses[1].Aliases)
+
+	// A collapsed host that moves to another address is probed again
+	table["files.example"] = []string{"192.0.2.11"}
+	resolved.forget("files.example")
+	assert.Equal(t, []string{"nas.example", "tcp://nas.example:445", "files.example", "files.example?count=3", "new.example"}, d.collapse(hosts, latest))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// demoSite is one location of the simulated demo fleet. Hosts are named
+// <role>.<site>.demo so each site is its own correlation group.
+type demoSite struct {
+	name       string
+	latency    time.Duration // Typical round-trip time from mosaic to the site
+	thresholds Thresholds    // Latency thresholds of its hosts, dashboard default if zero
+}
+
+var demoSites = []demoSite{
+	{"fra", 12 * time.Millisecond, Thresholds{}},
+	{"nyc", 85 * time.Millisecond, Thresholds{}},
+	{"sgp", 190 * time.Millisecond, Thresholds{WarnMs: 250, CritMs: 400}},
+}
+
+// demoRoles are the hosts at each site and their downtime weight.
+var demoRoles = []struct {
+	name   string
+	weight float64
+}{
+	{"core-sw", 5},
+	{"web01", 1},
+	{"web02", 1},
+	{"db01", 10},
+}
+
+// DemoScene is one step of the scripted outage replayed in demo mode.
+type DemoScene struct {
+	At   time.Duration `json:"-"`
+	Down []string      `json:"down"` // Simulated hosts that are down during the scene
+	Note string        `json:"note"` // What the scene shows and where to look
+}
+
+// demoScript is the scripted outage, replayed every demoScriptPeriod.
+var demoScript = []DemoScene{
+	{0, nil, "All sites are healthy. Hover a tile for its latency; sgp is slow but within its thresholds."},
+	{30 * time.Second, []string{"db01.nyc.demo"}, "db01 in nyc stopped answering. It weighs 10, so /api/sla ranks its downtime above the web servers'."},
+	{60 * time.Second, nil, "db01 is back. The outage stays in /api/sla and /api/events."},
+	{90 * time.Second, []string{"core-sw.sgp.demo", "web01.sgp.demo", "web02.sgp.demo", "db01.sgp.demo"},
+		"The whole sgp site went dark. The hosts failed together, so mosaic reports one correlated incident instead of four alerts."},
+	{150 * time.Second, nil, "sgp recovered and the incident resolved."},
+	{180 * time.Second, []string{"gw-a.fra.demo"}, "One uplink of the fra gateway failed. edge-gw has two addresses, so its tile turns yellow instead of red."},
+	{210 * time.Second, nil, "Both uplinks are back. The script starts over in half a minute."},
+}
+
+// demoScriptPeriod is how often the scripted outage repeats.
+const demoScriptPeriod = 240 * time.Second
+
+// demoTour is the guided tour shown on the dashboard in demo mode.
+var demoTour = []string{
+	"Each tile is a host: green is up, yellow is slow or partly down, red is down. Hover a tile for details.",
+	"Hosts are grouped by site. When several hosts of a site fail together, a correlated incident appears above the tiles.",
+	"Alerts and their resolution are listed under the incidents. While the tab is hidden they are counted in its title.",
+	"/api/sla ranks hosts by weighted downtime and /api/events keeps the history of incidents.",
+	"/api/thresholds suggests latency thresholds per host once it has seen enough samples.",
+	"When you are done, run mosaic with -hosts or -file to watch your own infrastructure.",
+}
+
+// DemoStatus is the response of /api/demo.
+type DemoStatus struct {
+	Enabled bool      `json:"enabled"`
+	Scene   DemoScene `json:"scene"`
+	Tour    []string  `json:"tour"`
+}
+
+var (
+	demoMu sync.Mutex
+	// demoEnabled is set by -demo.
+	demoEnabled bool
+	demoScene   DemoScene
+)
+
+// demoFleet returns the host entries of the demo fleet with their downtime
+// weights and latency thresholds.
+func demoFleet() ([]string, map[string]float64, map[string]Thresholds) {
+	var hosts []string
+	weights := make(map[string]float64)
+	thresholds := make(map[string]Thresholds)
+	for _, site := range demoSites {
+		for _, role := range demoRoles {
+			h := fmt.Sprintf("sim://%s.%s.demo?latency=%s", role.name, site.name, site.latency)
+			hosts = append(hosts, h)
+			weights[h] = role.weight
+			if site.thresholds != (Thresholds{}) {
+				thresholds[h] = site.thresholds
+			}
+		}
+	}
+	hosts = append(hosts, "edge-gw=sim://gw-a.fra.demo?latency=3ms|sim://gw-b.fra.demo?latency=4ms")
+	return hosts, weights, thresholds
+}
+
+// demoSceneAt returns the scene of the script at elapsed time since start.
+func demoSceneAt(elapsed time.Duration) DemoScene {
+	elapsed %= demoScriptPeriod
+	scene := demoScript[0]
+	for _, s := range demoScript {
+		if s.At <= elapsed {
+			scene = s
+		}
+	}
+	return scene
+}
+
+// playDemoScene takes down exactly the hosts of scene and announces it.
+func playDemoScene(scene DemoScene) {
+	down := make(map[string]bool)
+	for _, h := range scene.Down {
+		down[h] = true
+	}
+	simulation.mu.Lock()
+	simulation.down = down
+	simulation.mu.Unlock()
+
+	demoMu.Lock()
+	demoScene = scene
+	demoMu.Unlock()
+	events.add(Event{Type: "demo_scene", Hosts: scene.Down, Message: scene.Note})
+}
+
+// runDemo replays the scripted outage forever.
+func runDemo() {
+	start := time.Now()
+	for {
+		elapsed := time.Since(start)
+		playDemoScene(demoSceneAt(elapsed))
+		next := demoScriptPeriod - elapsed%demoScriptPeriod
+		for _, s := range demoScript {
+			if d := s.At - elapsed%demoScriptPeriod; d > 0 && d < next {
+				next = d
+			}
+		}
+		time.Sleep(next)
+	}
+}
+
+// demoHandler tells the dashboard whether demo mode is on and serves the
+// guided tour and the current scene of the script.
+func demoHandler(w http.ResponseWriter, r *http.Request) {
+	demoMu.Lock()
+	status := DemoStatus{Enabled: demoEnabled, Tour: []string{}}
+	if demoEnabled {
+		status.Scene = demoScene
+		status.Tour = demoTour
+	}
+	demoMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
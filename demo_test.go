@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDemoFleet(t *testing.T) {
+	hosts, weights, thresholds := demoFleet()
+	assert.Len(t, hosts, len(demoSites)*len(demoRoles)+1)
+	for _, h := range hosts {
+		assert.NoError(t, validateHost(h), h)
+	}
+	assert.Equal(t, 10.0, weights["sim://db01.nyc.demo?latency=85ms"])
+	assert.Equal(t, 250, thresholds["sim://web01.sgp.demo?latency=190ms"].WarnMs)
+	assert.NotContains(t, thresholds, "sim://web01.fra.demo?latency=12ms")
+	assert.Equal(t, []string{"domain:sgp.demo"}, correlationKeys("sim://web01.sgp.demo?latency=190ms"))
+	assert.Equal(t, []string{"domain:fra.demo"}, correlationKeys(hosts[len(hosts)-1]))
+}
+
+func TestDemoSceneAt(t *testing.T) {
+	assert.Empty(t, demoSceneAt(0).Down)
+	assert.Equal(t, []string{"db01.nyc.demo"}, demoSceneAt(45*time.Second).Down)
+	assert.Len(t, demoSceneAt(100*time.Second).Down, 4)
+	// The script repeats
+	assert.Equal(t, demoSceneAt(45*time.Second), demoSceneAt(demoScriptPeriod+45*time.Second))
+}
+
+func TestPlayDemoScene(t *testing.T) {
+	defer func() { simulation = newSimulator() }()
+	playDemoScene(demoSceneAt(100 * time.Second))
+	assert.Equal(t, 0, probeSim("db01.sgp.demo?latency=0s").Recv)
+	assert.Equal(t, 1, probeSim("db01.nyc.demo?latency=0s").Recv)
+
+	// Hosts of the previous scene come back
+	playDemoScene(demoSceneAt(160 * time.Second))
+	assert.Equal(t, 1, probeSim("db01.sgp.demo?latency=0s").Recv)
+	assert.Equal(t, "demo_scene", events.recent()[0].Type)
+}
+
+func TestDemoHandler(t *testing.T) {
+	get := func() DemoStatus {
+		w := httptest.NewRecorder()
+		demoHandler(w, httptest.NewRequest(http.MethodGet, "/api/demo", nil))
+		var status DemoStatus
+		json.Unmarshal(w.Body.Bytes(), &status)
+		return status
+	}
+	assert.False(t, get().Enabled)
+
+	demoEnabled = true
+	defer func() { demoEnabled = false }()
+	status := get()
+	assert.True(t, status.Enabled)
+	assert.Equal(t, demoTour, status.Tour)
+}
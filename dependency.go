@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// parentsFlag holds the dependencies given with -parents, applied on top of
+// those of the -config file.
+var parentsFlag = make(map[string]string)
+
+// parseParents parses a comma-separated list of child=parent pairs, such as
+// "pc01=branch-rtr,pc02=branch-rtr".
+//
+// Parameters:
+//   - s: The -parents value
+//
+// Returns:
+//   - map[string]string: The parent of each child host
+//   - error: If a pair has no parent
+func parseParents(s string) (map[string]string, error) {
+	parents := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// Split at the last "=" since host entries may carry "?key=value" options
+		i := strings.LastIndex(pair, "=")
+		if i < 0 || strings.TrimSpace(pair[i+1:]) == "" {
+			return nil, fmt.Errorf("invalid dependency %q: expected host=parent", pair)
+		}
+		parents[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return parents, nil
+}
+
+// validateParents checks that every child and parent is one of hosts and
+// that no host depends on itself, directly or through other hosts.
+func validateParents(parents map[string]string, hosts map[string]bool) error {
+	children := make([]string, 0, len(parents))
+	for child := range parents {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	for _, child := range children {
+		parent := parents[child]
+		switch {
+		case !hosts[child]:
+			return fmt.Errorf("parent for %s, which is not a configured host", child)
+		case !hosts[parent]:
+			return fmt.Errorf("%s depends on %s, which is not a configured host", child, parent)
+		}
+		h := parent
+		for range parents {
+			if h == child {
+				return fmt.Errorf("%s depends on itself through %s", child, parent)
+			}
+			if h = parents[h]; h == "" {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// dependencies returns a copy of the parent of each host.
+func (t *statusTracker) dependencies() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := make(map[string]string, len(t.parents))
+	for child, parent := range t.parents {
+		p[child] = parent
+	}
+	return p
+}
+
+// setDependencies replaces the parent of each host.
+func (t *statusTracker) setDependencies(p map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.parents = make(map[string]string, len(p))
+	for child, parent := range p {
+		t.parents[child] = parent
+	}
+}
+
+// setDiscoveredParent sets the parent a discovery source reports for host,
+// or removes it when parent is "". A parent from the configuration takes
+// precedence.
+func (t *statusTracker) setDiscoveredParent(host, parent string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if parent == "" {
+		delete(t.found, host)
+		return
+	}
+	t.found[host] = parent
+}
+
+// parentLocked returns the configured or else the discovered parent of
+// host, for callers holding t.mu.
+func (t *statusTracker) parentLocked(host string) string {
+	if p, ok := t.parents[host]; ok {
+		return p
+	}
+	return t.found[host]
+}
+
+// markUnreachable sets the parent of each of statuses and marks those that
+// are down while their parent is down, unreachable or in maintenance as
+// unreachable: they are probably fine, only hidden behind their parent. A
+// chain of dependencies needs no walking, since a parent that is itself
+// unreachable is down too. Unreachable hosts do not count towards flapping
+// until they are reachable again.
+func (t *statusTracker) markUnreachable(statuses []HostStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	byHost := make(map[string]*HostStatus, len(statuses))
+	for i := range statuses {
+		byHost[statuses[i].Host] = &statuses[i]
+	}
+	for i := range statuses {
+		st := &statuses[i]
+		st.Parent = t.parentLocked(st.Host)
+		parent := byHost[st.Parent]
+		st.Unreachable = parent != nil && !st.Alive && !st.Paused && (!parent.Alive || parent.Paused)
+		if th := t.hosts[st.Host]; th != nil {
+			th.unreachable = st.Unreachable
+		}
+	}
+}
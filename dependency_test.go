@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseParents(t *testing.T) {
+	parents, err := parseParents("pc01=branch-rtr, pc02?count=3=branch-rtr")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"pc01": "branch-rtr", "pc02?count=3": "branch-rtr"}, parents)
+
+	for _, bad := range []string{"pc01", "pc01="} {
+		_, err := parseParents(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestValidateParents(t *testing.T) {
+	hosts := map[string]bool{"core": true, "branch-rtr": true, "pc01": true}
+	assert.NoError(t, validateParents(map[string]string{"pc01": "branch-rtr", "branch-rtr": "core"}, hosts))
+	assert.ErrorContains(t, validateParents(map[string]string{"pc02": "branch-rtr"}, hosts), "parent for pc02")
+	assert.ErrorContains(t, validateParents(map[string]string{"pc01": "lab"}, hosts), "depends on lab")
+	assert.ErrorContains(t, validateParents(map[string]string{"pc01": "pc01"}, hosts), "depends on itself")
+	assert.ErrorContains(t, validateParents(map[string]string{"core": "pc01", "pc01": "branch-rtr", "branch-rtr": "core"}, hosts), "depends on itself")
+}
+
+func TestMarkUnreachable(t *testing.T) {
+	tr := newStatusTracker()
+	tr.setDependencies(map[string]string{"branch-rtr": "core", "pc01": "branch-rtr", "pc02": "branch-rtr", "nas": "core"})
+	statuses := []HostStatus{
+		{Host: "core"},
+		{Host: "branch-rtr"},
+		{Host: "pc01"},
+		{Host: "pc02", Alive: true},
+		{Host: "nas", Paused: true},
+	}
+	tr.markUnreachable(statuses)
+	assert.False(t, statuses[0].Unreachable, "hosts without a parent are down")
+	assert.True(t, statuses[1].Unreachable)
+	assert.True(t, statuses[2].Unreachable, "a parent that is unreachable is down too")
+	assert.Equal(t, "branch-rtr", statuses[2].Parent)
+	assert.False(t, statuses[3].Unreachable, "children that answer are up")
+	assert.False(t, statuses[4].Unreachable, "paused hosts stay paused")
+
+	// A parent in maintenance, e.g. rebooting, hides its children too
+	statuses = []HostStatus{{Host: "branch-rtr", Alive: true, Paused: true}, {Host: "pc01"}}
+	tr.markUnreachable(statuses)
+	assert.True(t, statuses[1].Unreachable)
+}
+
+func TestUnreachableDoesNotFlap(t *testing.T) {
+	old := events
+	defer func() { events = old }()
+	events = newEventLog(10)
+	defer func(n int, w time.Duration) { flapCount, flapWindow = n, w }(flapCount, flapWindow)
+	flapCount, flapWindow = 1, time.Minute
+	tr := newStatusTracker()
+	tr.setDependencies(map[string]string{"pc01": "branch-rtr"})
+	now := time.Now()
+
+	tr.update(HostStatus{Host: "pc01", Alive: true}, now)
+	for i := 1; i <= 4; i++ {
+		// The child is probed first, before its parent is known to be down
+		at := now.Add(time.Duration(i) * time.Second)
+		pc := tr.update(HostStatus{Host: "pc01", Alive: i%2 == 0}, at)
+		router := tr.update(HostStatus{Host: "branch-rtr", Alive: i%2 == 0}, at)
+		tr.markUnreachable([]HostStatus{router, pc})
+	}
+	// Only the parent flaps, state changes behind it don't count
+	if assert.Len(t, events.recent(), 1) {
+		assert.Equal(t, "branch-rtr", events.recent()[0].Key)
+	}
+}
+
+func TestCorrelationSkipsUnreachable(t *testing.T) {
+	c := newCorrelator()
+	now := time.Now()
+	statuses := []HostStatus{
+		{Host: "10.0.5.1"},
+		{Host: "10.0.5.2", Unreachable: true},
+		{Host: "10.0.5.3", Unreachable: true},
+	}
+	assert.Empty(t, c.update(statuses, now), "the parent stands for its children")
+}
+
+func TestUnreachableNotifiesParentOnly(t *testing.T) {
+	old := events
+	defer func() { events = old }()
+	events = newEventLog(10)
+	tr := newStatusTracker()
+	tr.setDependencies(map[string]string{"pc01": "branch-rtr", "pc02": "branch-rtr", "pc03": "branch-rtr"})
+	now := time.Now()
+	cycle := func(at time.Time, alive map[string]bool) {
+		var statuses []HostStatus
+		for _, h := range []string{"branch-rtr", "pc01", "pc02", "pc03"} {
+			statuses = append(statuses, tr.update(HostStatus{Host: h, Alive: alive[h]}, at))
+		}
+		tr.markUnreachable(statuses)
+		tr.notifyChanges(statuses, at)
+	}
+
+	cycle(now, map[string]bool{"branch-rtr": true, "pc01": true, "pc02": true, "pc03": true})
+	cycle(now.Add(time.Second), map[string]bool{"pc03": true})
+	cycle(now.Add(2*time.Second), map[string]bool{"pc03": true})
+	if assert.Len(t, events.recent(), 1, "the children behind it are not reported") {
+		e := events.recent()[0]
+		assert.Equal(t, "host_down", e.Type)
+		assert.Equal(t, []string{"branch-rtr"}, e.Hosts)
+		assert.Equal(t, "branch-rtr is down, 2 hosts behind it unreachable: pc01, pc02", e.Message)
+	}
+
+	// A child still down once its parent is back is reported by itself
+	cycle(now.Add(time.Minute), map[string]bool{"branch-rtr": true, "pc02": true, "pc03": true})
+	recent := events.recent()
+	if assert.Len(t, recent, 3) {
+		assert.Equal(t, "host_up", recent[1].Type)
+		assert.Equal(t, "host_down", recent[0].Type)
+		assert.Equal(t, "pc01 is down", recent[0].Message)
+	}
+}
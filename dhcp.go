@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// leaseFile is the -dhcp-leases value: an ISC dhcpd or dnsmasq lease file
+// whose active leases are monitored along with the hosts of -file and
+// -hosts. It is re-read like -file.
+var leaseFile string
+
+// dhcpLease is a lease with the hostname its client sent.
+type dhcpLease struct {
+	addr     string
+	hostname string
+}
+
+// readDHCPLeases reads the active leases of the ISC dhcpd or dnsmasq lease
+// file at path. The hostname a client sent becomes its display name.
+//
+// Parameters:
+//   - path: The lease file, e.g. /var/lib/dhcp/dhcpd.leases or
+//     /var/lib/misc/dnsmasq.leases
+//
+// Returns:
+//   - []string: The leased addresses in file order
+//   - map[string]HostLabel: The names of clients that sent a hostname
+//   - error: If the file cannot be read or parsed
+func readDHCPLeases(path string) ([]string, map[string]HostLabel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var leases []dhcpLease
+	if isDnsmasqLeases(data) {
+		leases = parseDnsmasqLeases(data, time.Now())
+	} else if leases, err = parseISCLeases(data, time.Now()); err != nil {
+		return nil, nil, fmt.Errorf("lease file %s: %v", path, err)
+	}
+	var hosts []string
+	labels := make(map[string]HostLabel)
+	for _, l := range leases {
+		hosts = append(hosts, l.addr)
+		if l.hostname != "" {
+			labels[l.addr] = HostLabel{Name: l.hostname}
+		}
+	}
+	return hosts, labels, nil
+}
+
+// isDnsmasqLeases reports whether data is a dnsmasq lease file, whose
+// lines start with an expiry time or a DUID, rather than an ISC dhcpd one.
+func isDnsmasqLeases(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		_, err := strconv.ParseInt(fields[0], 10, 64)
+		return err == nil || fields[0] == "duid"
+	}
+	return false
+}
+
+// parseDnsmasqLeases parses the lines "<expiry> <MAC or IAID> <address>
+// <hostname> <client ID>" of a dnsmasq lease file, an expiry of 0 meaning
+// an infinite lease and a hostname of "*" none.
+func parseDnsmasqLeases(data []byte, now time.Time) []dhcpLease {
+	var leases []dhcpLease
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] == "duid" {
+			continue
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || (expiry != 0 && time.Unix(expiry, 0).Before(now)) {
+			continue
+		}
+		addr := fields[2]
+		if net.ParseIP(addr) == nil || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		l := dhcpLease{addr: addr}
+		if fields[3] != "*" {
+			l.hostname = fields[3]
+		}
+		leases = append(leases, l)
+	}
+	return leases
+}
+
+// parseISCLeases parses the "lease <address> { ... }" declarations of an
+// ISC dhcpd lease file. The file is a log that dhcpd appends to, so the
+// last declaration of an address counts. Leases are active while their
+// binding state is active and they have not ended.
+func parseISCLeases(data []byte, now time.Time) ([]dhcpLease, error) {
+	type state struct {
+		dhcpLease
+		active bool
+	}
+	var order []string
+	byAddr := make(map[string]state)
+	var cur *state
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case cur == nil && strings.HasPrefix(line, "lease ") && strings.HasSuffix(line, "{"):
+			addr := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "lease "), "{"))
+			if net.ParseIP(addr) == nil {
+				return nil, fmt.Errorf("line %d: invalid lease address %q", n+1, addr)
+			}
+			cur = &state{dhcpLease: dhcpLease{addr: addr}, active: true}
+		case cur != nil && line == "}":
+			if _, ok := byAddr[cur.addr]; !ok {
+				order = append(order, cur.addr)
+			}
+			byAddr[cur.addr] = *cur
+			cur = nil
+		case cur != nil:
+			stmt := strings.TrimSuffix(line, ";")
+			switch {
+			case strings.HasPrefix(stmt, "binding state "):
+				cur.active = strings.TrimPrefix(stmt, "binding state ") == "active"
+			case strings.HasPrefix(stmt, "ends "):
+				if end, ok := parseISCTime(strings.TrimPrefix(stmt, "ends ")); ok && end.Before(now) {
+					cur.active = false
+				}
+			case strings.HasPrefix(stmt, "client-hostname "):
+				cur.hostname = strings.Trim(strings.TrimPrefix(stmt, "client-hostname "), `"`)
+			}
+		}
+	}
+	var leases []dhcpLease
+	for _, addr := range order {
+		if s := byAddr[addr]; s.active {
+			leases = append(leases, s.dhcpLease)
+		}
+	}
+	return leases, nil
+}
+
+// parseISCTime parses the time of an ISC dhcpd "ends" statement:
+// "<weekday> YYYY/MM/DD HH:MM:SS" in UTC or "epoch <seconds>". "never"
+// and anything else give false.
+func parseISCTime(s string) (time.Time, bool) {
+	if secs, ok := strings.CutPrefix(s, "epoch "); ok {
+		n, err := strconv.ParseInt(strings.TrimSpace(secs), 10, 64)
+		return time.Unix(n, 0), err == nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+	return t, err == nil
+}
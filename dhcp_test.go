@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const iscLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+authoring-byte-order little-endian;
+
+lease 192.168.1.20 {
+  starts 3 2026/10/14 08:00:00;
+  ends 3 2026/10/14 20:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:00:00:20;
+  client-hostname "nas";
+}
+lease 192.168.1.21 {
+  starts 4 2026/10/15 08:00:00;
+  ends 5 2026/10/16 08:00:00;
+  binding state active;
+  hardware ethernet aa:bb:cc:00:00:21;
+  client-hostname "printer";
+}
+lease 192.168.1.22 {
+  starts 4 2026/10/15 08:00:00;
+  ends never;
+  binding state active;
+}
+lease 192.168.1.20 {
+  starts 4 2026/10/15 09:00:00;
+  ends epoch 1792166400; # 2026/10/16 16:00:00
+  binding state active;
+  client-hostname "nas";
+}
+lease 192.168.1.21 {
+  starts 4 2026/10/15 10:00:00;
+  ends 5 2026/10/16 08:00:00;
+  binding state free;
+}
+`
+
+const dnsmasqLeases = `1792166400 aa:bb:cc:00:00:30 192.168.1.30 tv 01:aa:bb:cc:00:00:30
+0 aa:bb:cc:00:00:31 192.168.1.31 * *
+1760000000 aa:bb:cc:00:00:32 192.168.1.32 old-phone *
+duid 00:01:00:01:2c:1f:aa:bb:aa:bb:cc:00:00:01
+1792166400 1234 fd00::40 laptop 00:01:00:01:2c:1f:aa:bb:aa:bb:cc:00:00:40
+`
+
+func TestParseISCLeases(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	leases, err := parseISCLeases([]byte(iscLeases), now)
+	assert.NoError(t, err)
+	assert.Equal(t, []dhcpLease{{addr: "192.168.1.20", hostname: "nas"}, {addr: "192.168.1.22"}}, leases,
+		"the last declaration of an address counts, freed and ended leases are left out")
+
+	_, err = parseISCLeases([]byte("lease nas {\n}\n"), now)
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestParseDnsmasqLeases(t *testing.T) {
+	assert.True(t, isDnsmasqLeases([]byte(dnsmasqLeases)))
+	assert.False(t, isDnsmasqLeases([]byte(iscLeases)))
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []dhcpLease{
+		{addr: "192.168.1.30", hostname: "tv"},
+		{addr: "192.168.1.31"},
+		{addr: "fd00::40", hostname: "laptop"},
+	}, parseDnsmasqLeases([]byte(dnsmasqLeases), now), "expired leases are left out, 0 never expires")
+}
+
+func TestReadHostsDHCPLeases(t *testing.T) {
+	defer func(f string) { leaseFile = f }(leaseFile)
+	leaseFile = filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(leaseFile, []byte("0 aa:bb:cc:00:00:30 192.168.1.30 tv *\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hosts, fileLabels, err := readHosts("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.30"}, hosts)
+	assert.Equal(t, map[string]HostLabel{"192.168.1.30": {Name: "tv"}}, fileLabels)
+}
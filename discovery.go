@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryRetry is how long a discovery waits after its source failed.
+const discoveryRetry = 5 * time.Second
+
+// discovering reports whether hosts are discovered at runtime, with -k8s,
+// -docker, -consul or a cloud provider, so none need to be configured.
+func discovering() bool {
+	return k8sSource != "" || dockerEndpoint != "" || consulAddr != "" || len(polledProviders()) > 0
+}
+
+// logicalEntry returns the logical host name=addr|addr for a discovered
+// object, or "" without addresses.
+func logicalEntry(name string, addrs []string) string {
+	if len(addrs) == 0 || name == "" {
+		return ""
+	}
+	return name + "=" + strings.Join(addrs, "|")
+}
+
+// hostLabel turns a name from a discovery source into the name of a
+// logical host, replacing URL delimiters and spaces with "-".
+func hostLabel(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(":/?#[]@=| \t", r) {
+			return '-'
+		}
+		return r
+	}, name)
+}
+
+// discovered is a host reported by a discovery source.
+type discovered struct {
+	entry  string   // Host entry
+	groups []string // Groups the source puts the host in, e.g. its Consul service
+	parent string   // Host entry the host depends on, e.g. the switch it was seen behind
+}
+
+// Discovery is a discovery source without change notification, such as
+// the EC2 instances of a region, the VMs of a GCP project or the records of
+// a DNS zone, which is listed every -discover-interval. New sources
+// implement it and are added to polledProviders; sources that can follow
+// changes, such as the Kubernetes API, feed a reconciler directly.
+type Discovery interface {
+	// String names the source in logs, e.g. "EC2 us-east-1".
+	String() string
+	// List returns the hosts of the source keyed by a stable ID, such as
+	// the instance ID.
+	List(ctx context.Context) (map[string]discovered, error)
+}
+
+// reconciler keeps runtime hosts in line with what a discovery source,
+// such as the Kubernetes API, reports. The source reports hosts by kind of
+// object and object key; sync adds hosts that appear as runtime hosts and
+// removes those that are gone.
+type reconciler struct {
+	source  string // Shown in events and logs, e.g. "Kubernetes"
+	mu      sync.Mutex
+	entries map[string]map[string]discovered // Kind -> object key -> host
+	added   map[string]bool                  // Host entries added as runtime hosts
+	settled bool                             // Past the initial listing, changes are notified
+}
+
+// newReconciler creates a reconciler for source that has not seen any
+// objects yet.
+func newReconciler(source string) *reconciler {
+	return &reconciler{source: source, entries: make(map[string]map[string]discovered), added: make(map[string]bool)}
+}
+
+// startReconciler creates a reconciler for source that syncs every
+// -discover-interval until ctx is done, so hosts removed through the API or
+// by their TTL while the source still reports them come back.
+func startReconciler(ctx context.Context, source string) *reconciler {
+	d := newReconciler(source)
+	go func() {
+		ticker := time.NewTicker(discoverInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.mu.Lock()
+				d.settled = true
+				d.mu.Unlock()
+				d.sync()
+			}
+		}
+	}()
+	return d
+}
+
+// replace sets the objects of kind to those of a fresh list, given as
+// object key -> host.
+func (d *reconciler) replace(kind string, entries map[string]discovered) {
+	d.mu.Lock()
+	d.entries[kind] = entries
+	d.mu.Unlock()
+	d.sync()
+}
+
+// set sets the host of an object of kind, one without an entry meaning
+// the object is gone or has no address to monitor.
+func (d *reconciler) set(kind, key string, host discovered) {
+	d.mu.Lock()
+	if d.entries[kind] == nil {
+		d.entries[kind] = make(map[string]discovered)
+	}
+	if host.entry == "" {
+		delete(d.entries[kind], key)
+	} else {
+		d.entries[kind][key] = host
+	}
+	d.mu.Unlock()
+	d.sync()
+}
+
+// sync adds the hosts of the objects seen as runtime hosts and removes
+// those of objects that are gone, recording an event for each, and updates
+// the groups and parents of the hosts. Hosts it added that are no longer
+// monitored, e.g. because they were removed through the API, are added
+// again. Once settled, each sync that changed the hosts also records a
+// discovery_changed event, which is sent to the notifiers.
+func (d *reconciler) sync() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	want := make(map[string][]string)
+	parents := make(map[string]string)
+	for _, entries := range d.entries {
+		for _, h := range entries {
+			want[h.entry] = append(want[h.entry], h.groups...)
+			if h.parent != "" {
+				parents[h.entry] = h.parent
+			}
+		}
+	}
+	monitored := monitoredHosts()
+	var gone, found []string
+	for e := range d.added {
+		if !monitored[e] {
+			delete(d.added, e)
+			setDiscoveredGroups(e, nil)
+			hostStates.setDiscoveredParent(e, "")
+		} else if _, ok := want[e]; !ok {
+			gone = append(gone, e)
+		}
+	}
+	for e := range want {
+		// Static hosts are monitored already
+		if dynamic, ok := monitored[e]; !d.added[e] && (dynamic || !ok) {
+			found = append(found, e)
+		}
+	}
+	sort.Strings(gone)
+	sort.Strings(found)
+	for _, e := range gone {
+		delete(d.added, e)
+		setDiscoveredGroups(e, nil)
+		hostStates.setDiscoveredParent(e, "")
+		if removeHost(e) {
+			events.add(Event{Type: "host_removed", Hosts: []string{e}, Message: e + " removed: gone from " + d.source})
+		}
+	}
+	now := time.Now()
+	var added []string
+	for _, e := range found {
+		if err := addHost(e, 0, now); err != nil {
+			log.Printf("%s discovery: %v", d.source, err)
+			continue
+		}
+		d.added[e] = true
+		added = append(added, e)
+		events.add(Event{Type: "host_added", Hosts: []string{e}, Message: e + " added: discovered in " + d.source})
+	}
+	if d.settled && (len(added) > 0 || len(gone) > 0) {
+		events.add(Event{
+			Type:    "discovery_changed",
+			Key:     d.source,
+			Hosts:   append(added, gone...),
+			Message: fmt.Sprintf("%s discovery: %d added, %d removed", d.source, len(added), len(gone)),
+		})
+	}
+	for e := range d.added {
+		setDiscoveredGroups(e, want[e])
+		hostStates.setDiscoveredParent(e, parents[e])
+	}
+	if len(gone) > 0 || len(found) > 0 {
+		wakeLoop()
+	}
+}
+
+// runDiscovery calls follow until ctx is done, waiting discoveryRetry after
+// each error, which is logged prefixed with what.
+func runDiscovery(ctx context.Context, what string, follow func(context.Context) error) {
+	for {
+		err := follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("%s: %v", what, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(discoveryRetry):
+			}
+		}
+	}
+}
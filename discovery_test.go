@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverySync(t *testing.T) {
+	withHosts(t, "static")
+	d := newReconciler("Test")
+
+	d.replace("vm", map[string]discovered{"1": {entry: "a=10.0.0.1"}, "2": {entry: "static"}})
+	assert.Equal(t, []string{"static", "a=10.0.0.1"}, currentHosts(), "static hosts are not added twice")
+
+	d.set("vm", "3", discovered{entry: "b=10.0.0.2"})
+	d.set("vm", "1", discovered{})
+	assert.Equal(t, []string{"static", "b=10.0.0.2"}, currentHosts())
+
+	// The same entry reported by two kinds stays until both are gone
+	d.replace("pod", map[string]discovered{"x": {entry: "b=10.0.0.2"}})
+	d.set("vm", "3", discovered{})
+	assert.Equal(t, []string{"static", "b=10.0.0.2"}, currentHosts())
+	d.replace("pod", nil)
+	assert.Equal(t, []string{"static"}, currentHosts(), "static hosts are never removed")
+}
+
+func TestDiscoveredParents(t *testing.T) {
+	withHosts(t, "core=10.0.0.1")
+	hostStates.setDependencies(map[string]string{"c=10.0.0.4": "core=10.0.0.1"})
+	t.Cleanup(func() { hostStates.setDependencies(nil) })
+	d := newReconciler("Test")
+
+	d.replace("dev", map[string]discovered{
+		"b": {entry: "b=10.0.0.3", parent: "core=10.0.0.1"},
+		"c": {entry: "c=10.0.0.4", parent: "b=10.0.0.3"},
+	})
+	statuses := []HostStatus{{Host: "core=10.0.0.1"}, {Host: "b=10.0.0.3"}, {Host: "c=10.0.0.4"}}
+	hostStates.markUnreachable(statuses)
+	assert.Equal(t, "core=10.0.0.1", statuses[1].Parent)
+	assert.Equal(t, "core=10.0.0.1", statuses[2].Parent, "configured parents win")
+	assert.True(t, statuses[1].Unreachable, "down behind a down parent")
+
+	d.replace("dev", nil)
+	hostStates.markUnreachable(statuses)
+	assert.Empty(t, statuses[1].Parent, "parents go with their hosts")
+}
+
+func TestReconcile(t *testing.T) {
+	withHosts(t, "static")
+	old := events
+	defer func() { events = old }()
+	events = newEventLog(10)
+	d := newReconciler("Test")
+
+	d.replace("vm", map[string]discovered{"1": {entry: "a=10.0.0.1"}, "2": {entry: "b=10.0.0.2"}})
+	for _, e := range events.recent() {
+		assert.NotEqual(t, "discovery_changed", e.Type, "the initial listing is not notified")
+	}
+
+	// A host removed through the API while still discovered comes back
+	assert.True(t, removeHost("a=10.0.0.1"))
+	d.settled = true
+	d.sync()
+	assert.Equal(t, []string{"static", "b=10.0.0.2", "a=10.0.0.1"}, currentHosts())
+
+	d.replace("vm", map[string]discovered{"1": {entry: "a=10.0.0.1"}, "3": {entry: "c=10.0.0.3"}})
+	e := events.recent()[0]
+	assert.Equal(t, "discovery_changed", e.Type)
+	assert.Equal(t, "Test", e.Key)
+	assert.Equal(t, []string{"c=10.0.0.3", "b=10.0.0.2"}, e.Hosts)
+	assert.Equal(t, "Test discovery: 1 added, 1 removed", e.Message)
+	assert.True(t, alertEventTypes[e.Type], "sent to the notifiers")
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsRefresh resolves the hostnames of ICMP hosts once and then again only
+// when their DNS records expire, set with -dns-refresh, so every probe goes
+// to a known address and a change of address is noticed.
+var dnsRefresh = true
+
+const (
+	// dnsMinTTL is the shortest time a resolved address is kept, so records
+	// with a TTL of zero don't mean a lookup per probe. A failed lookup is
+	// also retried after this long.
+	dnsMinTTL = 5 * time.Second
+	// dnsDefaultTTL is how long an address is kept when the TTL of its
+	// record cannot be queried, e.g. for names from /etc/hosts.
+	dnsDefaultTTL = time.Minute
+	// dnsChangeHold is how long a host's status reports its previous address
+	// after it resolved to a new one.
+	dnsChangeHold = 10 * time.Minute
+)
+
+// lookupTTL is a variable to allow mocking DNS in tests
+var lookupTTL = queryTTL
+
+// resolvedHost is the address a host's name resolved to.
+type resolvedHost struct {
+	name    string
+	ip      string
+	prev    string    // Address before the last change
+	changed time.Time // When the address last changed
+	expires time.Time
+}
+
+// dnsCache keeps the resolved address of every monitored hostname.
+type dnsCache struct {
+	mu    sync.Mutex
+	hosts map[string]*resolvedHost
+}
+
+var resolved = newDNSCache()
+
+// newDNSCache creates an empty dnsCache.
+func newDNSCache() *dnsCache {
+	return &dnsCache{hosts: make(map[string]*resolvedHost)}
+}
+
+// resolve returns the address the name of host is monitored at, looking it
+// up again once its TTL has expired. The current address is kept as long as
+// the name still resolves to it, so round-robin records don't count as a
+// change, and while lookups fail. A change is recorded as a "dns_changed"
+// event.
+//
+// Parameters:
+//   - host: The host entry
+//   - name: The hostname to resolve
+//   - family: Address family to resolve to, see familyOption
+//   - now: Current time
+//
+// Returns:
+//   - string: The address
+//   - string: The previous address if it changed within dnsChangeHold
+//   - error: If the name has never resolved
+func (c *dnsCache) resolve(host, name, family string, now time.Time) (string, string, error) {
+	c.mu.Lock()
+	r := c.hosts[host]
+	if r != nil && r.name == name && now.Before(r.expires) {
+		defer c.mu.Unlock()
+		return r.ip, r.recentPrev(now), nil
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	network := pingNetwork(family)
+	ips, err := lookupIP(ctx, network, name)
+	if err == nil && len(ips) == 0 {
+		err = fmt.Errorf("%s has no addresses", name)
+	}
+	ttl := dnsMinTTL
+	if err == nil {
+		if ttl, err = lookupTTL(ctx, network, name); err != nil {
+			ttl, err = dnsDefaultTTL, nil
+		}
+		ttl = max(ttl, dnsMinTTL)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r == nil || r.name != name {
+		if err != nil {
+			return "", "", err
+		}
+		r = &resolvedHost{name: name, ip: ips[0].String()}
+		c.hosts[host] = r
+	}
+	r.expires = now.Add(ttl)
+	if err != nil {
+		return r.ip, r.recentPrev(now), nil
+	}
+	current := false
+	for _, ip := range ips {
+		current = current || ip.String() == r.ip
+	}
+	if !current {
+		r.prev, r.ip, r.changed = r.ip, ips[0].String(), now
+		events.add(Event{Time: now, Type: "dns_changed", Key: name, Hosts: []string{host},
+			Message: fmt.Sprintf("%s now resolves to %s instead of %s", name, r.ip, r.prev)})
+	}
+	return r.ip, r.recentPrev(now), nil
+}
+
+// recentPrev returns the previous address if it changed within
+// dnsChangeHold.
+func (r *resolvedHost) recentPrev(now time.Time) string {
+	if r.prev != "" && now.Sub(r.changed) < dnsChangeHold {
+		return r.prev
+	}
+	return ""
+}
+
+// forget drops the address of host.
+func (c *dnsCache) forget(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hosts, host)
+}
+
+// resolvedAddr replaces the hostname in the address of an ICMP host with
+// the address it is monitored at, keeping the options. IP literals, and all
+// addresses without -dns-refresh, are returned as is.
+//
+// Returns:
+//   - string: The address to probe
+//   - string: The address the hostname resolved to, empty if not resolved
+//   - string: The previous address if it changed recently
+func resolvedAddr(host, addr string) (string, string, string) {
+	name, opts := splitOptions(addr)
+	bare := strings.Trim(name, "[]")
+	if !dnsRefresh || net.ParseIP(bare) != nil {
+		return addr, "", ""
+	}
+	ip, prev, err := resolved.resolve(host, bare, familyOption(opts), time.Now())
+	if err != nil {
+		// Let the probe report the failure
+		return addr, "", ""
+	}
+	return ip + strings.TrimPrefix(addr, name), ip, prev
+}
+
+// queryTTL asks the first nameserver of /etc/resolv.conf for the A or AAAA
+// records of name, which the system resolver does not expose, and returns
+// the lowest TTL among the answers.
+func queryTTL(ctx context.Context, network, name string) (time.Duration, error) {
+	server, err := nameserver("/etc/resolv.conf")
+	if err != nil {
+		return 0, err
+	}
+	typ := dnsmessage.TypeA
+	if network == "ip6" {
+		typ = dnsmessage.TypeAAAA
+	}
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return 0, err
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: typ, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return 0, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(server, "53"))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1232)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		var reply dnsmessage.Message
+		if reply.Unpack(buf[:n]) != nil || reply.ID != binary.BigEndian.Uint16(id[:]) {
+			continue
+		}
+		var ttl uint32
+		found := false
+		for _, a := range reply.Answers {
+			if !found || a.Header.TTL < ttl {
+				ttl = a.Header.TTL
+			}
+			found = true
+		}
+		if !found {
+			return 0, fmt.Errorf("no %s records for %s", typ, name)
+		}
+		return time.Duration(ttl) * time.Second, nil
+	}
+}
+
+// nameserver returns the first nameserver listed in the resolv.conf at
+// path.
+func nameserver(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver in %s", path)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockLookupTTL makes every record have the given TTL, or no TTL at all if
+// it is zero.
+func mockLookupTTL(t *testing.T, ttl time.Duration) {
+	saved := lookupTTL
+	t.Cleanup(func() { lookupTTL = saved })
+	lookupTTL = func(ctx context.Context, network, name string) (time.Duration, error) {
+		if ttl == 0 {
+			return 0, errors.New("no TTL")
+		}
+		return ttl, nil
+	}
+}
+
+func TestDNSCacheFollowsTTL(t *testing.T) {
+	table := map[string][]string{"www.example": {"192.0.2.1"}}
+	mockLookupIP(t, table)
+	mockLookupTTL(t, 30*time.Second)
+	c := newDNSCache()
+	now := time.Now()
+
+	ip, prev, err := c.resolve("www.example", "www.example", familyIPv4, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", ip)
+	assert.Empty(t, prev)
+
+	// Within the TTL the cached address is used
+	table["www.example"] = []string{"192.0.2.2"}
+	ip, _, _ = c.resolve("www.example", "www.example", familyIPv4, now.Add(29*time.Second))
+	assert.Equal(t, "192.0.2.1", ip)
+
+	// After it the change is noticed and recorded
+	ip, prev, _ = c.resolve("www.example", "www.example", familyIPv4, now.Add(31*time.Second))
+	assert.Equal(t, "192.0.2.2", ip)
+	assert.Equal(t, "192.0.2.1", prev)
+	e := events.recent()[0]
+	assert.Equal(t, "dns_changed", e.Type)
+	assert.Equal(t, "www.example now resolves to 192.0.2.2 instead of 192.0.2.1", e.Message)
+	_, prev, _ = c.resolve("www.example", "www.example", familyIPv4, now.Add(31*time.Second+dnsChangeHold))
+	assert.Empty(t, prev, "the previous address is reported for a while only")
+}
+
+func TestDNSCacheKeepsAddress(t *testing.T) {
+	table := map[string][]string{"rr.example": {"192.0.2.1", "192.0.2.2"}}
+	mockLookupIP(t, table)
+	mockLookupTTL(t, 0)
+	c := newDNSCache()
+	now := time.Now()
+	c.resolve("rr.example", "rr.example", familyIPv4, now)
+
+	// Round-robin records in another order are no change
+	table["rr.example"] = []string{"192.0.2.2", "192.0.2.1"}
+	ip, prev, _ := c.resolve("rr.example", "rr.example", familyIPv4, now.Add(dnsDefaultTTL))
+	assert.Equal(t, "192.0.2.1", ip)
+	assert.Empty(t, prev)
+
+	// Nor is a failed lookup, which is retried soon
+	delete(table, "rr.example")
+	ip, _, err := c.resolve("rr.example", "rr.example", familyIPv4, now.Add(2*dnsDefaultTTL))
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", ip)
+	assert.Equal(t, now.Add(2*dnsDefaultTTL+dnsMinTTL), c.hosts["rr.example"].expires)
+
+	_, _, err = c.resolve("missing.example", "missing.example", familyIPv4, now)
+	assert.Error(t, err)
+}
+
+func TestResolvedAddr(t *testing.T) {
+	mockLookupIP(t, map[string][]string{"db01.example": {"192.0.2.10"}})
+	mockLookupTTL(t, time.Minute)
+	defer func(saved *dnsCache) { resolved = saved }(resolved)
+	resolved = newDNSCache()
+
+	addr, ip, prev := resolvedAddr("db01.example?family=4&count=3", "db01.example?family=4&count=3")
+	assert.Equal(t, "192.0.2.10?family=4&count=3", addr)
+	assert.Equal(t, "192.0.2.10", ip)
+	assert.Empty(t, prev)
+
+	addr, ip, _ = resolvedAddr("192.0.2.20", "192.0.2.20")
+	assert.Equal(t, "192.0.2.20", addr)
+	assert.Empty(t, ip, "IP literals are not resolved")
+
+	defer func(on bool) { dnsRefresh = on }(dnsRefresh)
+	dnsRefresh = false
+	addr, _, _ = resolvedAddr("db01.example?family=4", "db01.example?family=4")
+	assert.Equal(t, "db01.example?family=4", addr)
+}
+
+func TestNameserver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(path, []byte("# generated\nsearch example.com\nnameserver 192.0.2.53\nnameserver 192.0.2.54\n"), 0o644)
+	ns, err := nameserver(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.53", ns)
+
+	os.WriteFile(path, []byte("search example.com\n"), 0o644)
+	_, err = nameserver(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	// dnsSRV is the -dns-srv value: the comma-separated SRV names whose
+	// targets are monitored, e.g. "_monitor._tcp.example.com". Empty turns
+	// SRV discovery off.
+	dnsSRV string
+	// dnsAXFR is the -dns-axfr value: the comma-separated zones whose
+	// address records are monitored, each transferred from the nameserver
+	// after "@", e.g. "corp.internal@10.0.0.2", or else from the first
+	// nameserver of /etc/resolv.conf. Empty turns zone discovery off.
+	dnsAXFR string
+)
+
+// lookupSRV looks up the SRV records of a name, a variable to allow mocking
+// in tests.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// axfrTimeout bounds a zone transfer.
+const axfrTimeout = time.Minute
+
+// dnsProviders returns a provider for each -dns-srv name and -dns-axfr
+// zone.
+func dnsProviders() []Discovery {
+	var providers []Discovery
+	for _, name := range strings.Split(dnsSRV, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			providers = append(providers, srvProvider{name: name})
+		}
+	}
+	for _, zone := range strings.Split(dnsAXFR, ",") {
+		if zone = strings.TrimSpace(zone); zone != "" {
+			zone, server, _ := strings.Cut(zone, "@")
+			providers = append(providers, axfrProvider{zone: strings.TrimSuffix(zone, "."), server: server})
+		}
+	}
+	return providers
+}
+
+// srvProvider is the targets of an SRV name.
+type srvProvider struct {
+	name string
+}
+
+// String implements Discovery.
+func (p srvProvider) String() string { return "SRV " + p.name }
+
+// List implements Discovery: the targets of the SRV records keyed by
+// target and port, as target:port hosts probed over TCP, in the group of
+// the SRV name.
+func (p srvProvider) List(ctx context.Context) (map[string]discovered, error) {
+	_, records, err := lookupSRV(ctx, "", "", p.name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		// No records left is an empty service, not a failure
+		return map[string]discovered{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]discovered)
+	for _, r := range records {
+		target := strings.TrimSuffix(r.Target, ".")
+		if target == "" {
+			continue // "." means the service is not available
+		}
+		entry := net.JoinHostPort(target, fmt.Sprint(r.Port))
+		hosts[entry] = discovered{entry: entry, groups: []string{strings.TrimSuffix(p.name, ".")}}
+	}
+	return hosts, nil
+}
+
+// axfrProvider is the address records of a zone transferred from a
+// nameserver.
+type axfrProvider struct {
+	zone   string
+	server string // host or host:port, or "" for the nameserver of /etc/resolv.conf
+}
+
+// String implements Discovery.
+func (p axfrProvider) String() string { return "AXFR " + p.zone }
+
+// List implements Discovery: the names of the zone with A or AAAA records
+// keyed by name, as logical hosts of their addresses, in the group of the
+// zone. Service names starting with "_" and wildcards are skipped.
+func (p axfrProvider) List(ctx context.Context) (map[string]discovered, error) {
+	server := p.server
+	if server == "" {
+		var err error
+		if server, err = nameserver("/etc/resolv.conf"); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	addrs, err := transferZone(ctx, server, p.zone)
+	if err != nil {
+		return nil, fmt.Errorf("transfer of %s from %s: %v", p.zone, server, err)
+	}
+	hosts := make(map[string]discovered)
+	for name, ips := range addrs {
+		if strings.HasPrefix(name, "_") || strings.HasPrefix(name, "*") {
+			continue
+		}
+		sort.Strings(ips)
+		hosts[name] = discovered{entry: logicalEntry(hostLabel(name), ips), groups: []string{p.zone}}
+	}
+	return hosts, nil
+}
+
+// transferZone transfers zone from the nameserver at server over TCP and
+// returns the addresses of each name that has A or AAAA records.
+//
+// Returns:
+//   - map[string][]string: The addresses by name without the trailing dot
+//   - error: If the transfer is refused or does not end with the SOA record
+func transferZone(ctx context.Context, server, zone string) (map[string][]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, axfrTimeout)
+	defer cancel()
+	qname, err := dnsmessage.NewName(zone + ".")
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:])},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypeAXFR, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Messages over TCP are prefixed with their length
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+		return nil, err
+	}
+
+	addrs := make(map[string][]string)
+	soas := 0
+	for soas < 2 {
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		var reply dnsmessage.Message
+		if err := reply.Unpack(buf); err != nil {
+			return nil, err
+		}
+		if reply.ID != binary.BigEndian.Uint16(id[:]) {
+			return nil, errors.New("reply to another query")
+		}
+		if reply.RCode != dnsmessage.RCodeSuccess {
+			return nil, errors.New(reply.RCode.String())
+		}
+		if len(reply.Answers) == 0 {
+			return nil, errors.New("empty reply")
+		}
+		for _, a := range reply.Answers {
+			name := strings.TrimSuffix(a.Header.Name.String(), ".")
+			switch r := a.Body.(type) {
+			case *dnsmessage.SOAResource:
+				soas++
+			case *dnsmessage.AResource:
+				addrs[name] = append(addrs[name], net.IP(r.A[:]).String())
+			case *dnsmessage.AAAAResource:
+				addrs[name] = append(addrs[name], net.IP(r.AAAA[:]).String())
+			}
+		}
+	}
+	return addrs, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSProviders(t *testing.T) {
+	oldSRV, oldAXFR := dnsSRV, dnsAXFR
+	t.Cleanup(func() { dnsSRV, dnsAXFR = oldSRV, oldAXFR })
+	dnsSRV, dnsAXFR = "_monitor._tcp.example.com", "corp.internal.@10.0.0.2, lab.internal"
+	var names []string
+	for _, p := range polledProviders()["DNS"] {
+		names = append(names, p.String())
+	}
+	assert.Equal(t, []string{"SRV _monitor._tcp.example.com", "AXFR corp.internal", "AXFR lab.internal"}, names)
+	assert.Equal(t, "10.0.0.2", dnsProviders()[1].(axfrProvider).server)
+}
+
+func TestSRVProvider(t *testing.T) {
+	oldLookup := lookupSRV
+	t.Cleanup(func() { lookupSRV = oldLookup })
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_monitor._tcp.example.com", name)
+		return "", []*net.SRV{{Target: "web1.example.com.", Port: 443}, {Target: ".", Port: 0}}, nil
+	}
+	hosts, err := srvProvider{name: "_monitor._tcp.example.com"}.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]discovered{
+		"web1.example.com:443": {entry: "web1.example.com:443", groups: []string{"_monitor._tcp.example.com"}},
+	}, hosts)
+
+	lookupSRV = func(context.Context, string, string, string) (string, []*net.SRV, error) {
+		return "", nil, &net.DNSError{Err: "no such host", IsNotFound: true}
+	}
+	hosts, err = srvProvider{name: "_monitor._tcp.example.com"}.List(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, hosts, "a name without records has no hosts")
+}
+
+// serveAXFR answers one zone transfer on a local TCP listener with the
+// records split over two messages, and returns the listener's address.
+func serveAXFR(t *testing.T, zone string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if query.Unpack(buf) != nil || len(query.Questions) != 1 || query.Questions[0].Type != dnsmessage.TypeAXFR {
+			return
+		}
+		rr := func(name string, body dnsmessage.ResourceBody) dnsmessage.Resource {
+			return dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   body,
+			}
+		}
+		soa := rr(zone+".", &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns." + zone + "."), MBox: dnsmessage.MustNewName("admin." + zone + "."), Serial: 1})
+		for _, answers := range [][]dnsmessage.Resource{
+			{soa, rr("web."+zone+".", &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}), rr("_sip._udp."+zone+".", &dnsmessage.AResource{A: [4]byte{10, 0, 0, 9}})},
+			{rr("web."+zone+".", &dnsmessage.AAAAResource{AAAA: [16]byte{0xfd, 15: 1}}), rr("db."+zone+".", &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}}), soa},
+		} {
+			reply, err := (&dnsmessage.Message{Header: dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true}, Questions: query.Questions, Answers: answers}).Pack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestAXFRProvider(t *testing.T) {
+	server := serveAXFR(t, "corp.internal")
+	hosts, err := axfrProvider{zone: "corp.internal", server: server}.List(context.Background())
+	assert.NoError(t, err)
+	groups := []string{"corp.internal"}
+	assert.Equal(t, map[string]discovered{
+		"web.corp.internal": {entry: "web.corp.internal=10.0.0.1|fd00::1", groups: groups},
+		"db.corp.internal":  {entry: "db.corp.internal=10.0.0.2", groups: groups},
+	}, hosts, "service names are skipped")
+	for _, h := range hosts {
+		assert.NoError(t, validateHost(h.entry))
+	}
+}
+
+func TestAXFRRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var size [2]byte
+		io.ReadFull(conn, size[:])
+		buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+		io.ReadFull(conn, buf)
+		var query dnsmessage.Message
+		query.Unpack(buf)
+		reply, _ := (&dnsmessage.Message{Header: dnsmessage.Header{ID: query.ID, Response: true, RCode: dnsmessage.RCodeRefused}, Questions: query.Questions}).Pack()
+		conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+	}()
+
+	_, err = axfrProvider{zone: "corp.internal", server: l.Addr().String()}.List(context.Background())
+	assert.ErrorContains(t, err, "RCodeRefused")
+}
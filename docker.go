@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var (
+	// dockerEndpoint is the -docker value: the path of the Docker socket,
+	// e.g. /var/run/docker.sock, or a tcp://host:port daemon address. Empty
+	// turns container discovery off.
+	dockerEndpoint string
+	// dockerLabel is the -docker-label value: "key" or "key=value" of the
+	// label a container must carry to be monitored, or "" for all.
+	dockerLabel string
+)
+
+// dockerClient talks to the Docker Engine API.
+type dockerClient struct {
+	base string // Base URL of requests
+	http *http.Client
+}
+
+// newDockerClient creates a client for a -docker endpoint.
+func newDockerClient(endpoint string) (*dockerClient, error) {
+	if addr, ok := strings.CutPrefix(endpoint, "tcp://"); ok {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid Docker address %q: %v", endpoint, err)
+		}
+		return &dockerClient{base: "http://" + addr, http: &http.Client{}}, nil
+	}
+	socket := strings.TrimPrefix(endpoint, "unix://")
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &dockerClient{base: "http://docker", http: &http.Client{Transport: transport}}, nil
+}
+
+// get sends a GET request for path with the JSON filters to the daemon.
+func (c *dockerClient) get(ctx context.Context, path string, filters map[string][]string) (*http.Response, error) {
+	u := c.base + path
+	if len(filters) > 0 {
+		f, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		u += "?filters=" + url.QueryEscape(string(f))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s", http.StatusText(resp.StatusCode), strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// dockerContainer is the part of a container in a list mosaic reads.
+type dockerContainer struct {
+	ID              string   `json:"Id"`
+	Names           []string `json:"Names"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// containerEntry returns the host entry of a container: a logical host
+// named after the container with its addresses on all its networks, e.g.
+// "api=172.18.0.3|fd00:18::3", or "" for containers without addresses,
+// such as those on the host network.
+func containerEntry(c dockerContainer) string {
+	name := c.ID
+	if len(name) > 12 {
+		name = name[:12]
+	}
+	if len(c.Names) > 0 {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	networks := make([]string, 0, len(c.NetworkSettings.Networks))
+	for n := range c.NetworkSettings.Networks {
+		networks = append(networks, n)
+	}
+	sort.Strings(networks)
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, n := range networks {
+		nw := c.NetworkSettings.Networks[n]
+		for _, a := range []string{nw.IPAddress, nw.GlobalIPv6Address} {
+			if a != "" && !seen[a] {
+				seen[a] = true
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	return logicalEntry(name, addrs)
+}
+
+// dockerFilters returns the list filters for -docker-label.
+func dockerFilters() map[string][]string {
+	if dockerLabel == "" {
+		return nil
+	}
+	return map[string][]string{"label": {dockerLabel}}
+}
+
+// discoverDocker monitors the running containers of the Docker daemon that
+// carry -docker-label until ctx is done. Containers are listed again
+// whenever one starts, stops or changes networks, so hosts come and go
+// with them.
+func discoverDocker(ctx context.Context, c *dockerClient) {
+	d := startReconciler(ctx, "Docker")
+	go runDiscovery(ctx, "Docker discovery", func(ctx context.Context) error {
+		return followDocker(ctx, c, d)
+	})
+}
+
+// followDocker lists the containers into d, and again on every container
+// event, until the event stream ends. It subscribes to events before the
+// first list, so no change between the two is missed.
+//
+// Returns:
+//   - error: If the daemon cannot be reached or rejects a request
+func followDocker(ctx context.Context, c *dockerClient, d *reconciler) error {
+	events, err := c.get(ctx, "/events", map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "die", "destroy", "connect", "disconnect", "rename"},
+	})
+	if err != nil {
+		return err
+	}
+	defer events.Body.Close()
+	list := func() error {
+		resp, err := c.get(ctx, "/containers/json", dockerFilters())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var containers []dockerContainer
+		if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+			return fmt.Errorf("invalid container list: %v", err)
+		}
+		entries := make(map[string]discovered, len(containers))
+		for _, ct := range containers {
+			if e := containerEntry(ct); e != "" {
+				entries[ct.ID] = discovered{entry: e}
+			}
+		}
+		d.replace("container", entries)
+		return nil
+	}
+	if err := list(); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(events.Body)
+	for {
+		var event json.RawMessage
+		if err := dec.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := list(); err != nil {
+			return err
+		}
+	}
+}
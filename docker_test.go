@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerEntry(t *testing.T) {
+	var c dockerContainer
+	assert.NoError(t, json.Unmarshal([]byte(`{"Id":"4f2a9c1d7e3b5a60","Names":["/api"],"NetworkSettings":{"Networks":{
+		"front":{"IPAddress":"172.19.0.4","GlobalIPv6Address":"fd00:19::4"},
+		"back":{"IPAddress":"172.18.0.3"}}}}`), &c))
+	assert.Equal(t, "api=172.18.0.3|172.19.0.4|fd00:19::4", containerEntry(c), "networks in name order")
+	assert.NoError(t, validateHost(containerEntry(c)))
+
+	c.NetworkSettings.Networks = nil
+	assert.Empty(t, containerEntry(c), "host network containers have no address")
+}
+
+func TestNewDockerClient(t *testing.T) {
+	c, err := newDockerClient("tcp://10.0.0.9:2375")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://10.0.0.9:2375", c.base)
+
+	_, err = newDockerClient("tcp://10.0.0.9")
+	assert.Error(t, err, "missing port")
+}
+
+func TestDockerDiscoveryFollowsContainers(t *testing.T) {
+	withHosts(t, "static")
+	oldLabel := dockerLabel
+	dockerLabel = "mosaic.monitor=true"
+	t.Cleanup(func() { dockerLabel = oldLabel })
+
+	var lists atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, `{"label":["mosaic.monitor=true"]}`, r.URL.Query().Get("filters"))
+		if lists.Add(1) == 1 {
+			fmt.Fprint(w, `[{"Id":"a1","Names":["/web"],"NetworkSettings":{"Networks":{"bridge":{"IPAddress":"172.17.0.2"}}}}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"Id":"b2","Names":["/db"],"NetworkSettings":{"Networks":{"bridge":{"IPAddress":"172.17.0.3"}}}}]`)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Type":"container","Action":"die","Actor":{"ID":"a1"}}`+"\n")
+	})
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	c, err := newDockerClient(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newReconciler("Docker")
+	assert.NoError(t, followDocker(context.Background(), c, d))
+	assert.Equal(t, int32(2), lists.Load(), "listed at first and after the event")
+	assert.Equal(t, []string{"static", "db=172.17.0.3"}, currentHosts())
+}
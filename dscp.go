@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// dscpClasses maps the standard DSCP class names to their code points.
+var dscpClasses = map[string]int{
+	"BE": 0, "CS0": 0,
+	"CS1": 8, "AF11": 10, "AF12": 12, "AF13": 14,
+	"CS2": 16, "AF21": 18, "AF22": 20, "AF23": 22,
+	"CS3": 24, "AF31": 26, "AF32": 28, "AF33": 30,
+	"CS4": 32, "AF41": 34, "AF42": 36, "AF43": 38,
+	"CS5": 40, "EF": 46, "CS6": 48, "CS7": 56,
+}
+
+// probeDSCP is the DSCP code point outgoing ICMP and TCP probes are marked
+// with, set with -dscp; 0 leaves them unmarked (best effort). ICMP hosts can
+// choose their own with the dscp option, e.g. "voip-gw?dscp=EF".
+var probeDSCP int
+
+// parseDSCP parses a DSCP value given as a class name such as "EF" or
+// "AF41", or as a number from 0 to 63.
+func parseDSCP(s string) (int, error) {
+	if v, ok := dscpClasses[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q: expected a class such as EF or AF41, or 0-63", s)
+	}
+	return v, nil
+}
+
+// dscpOption returns the DSCP classes of an ICMP host's dscp option, e.g.
+// "EF,BE" for a host whose latency is compared across two classes.
+func dscpOption(value string) ([]string, error) {
+	var classes []string
+	for _, c := range strings.Split(value, ",") {
+		c = strings.TrimSpace(c)
+		if _, err := parseDSCP(c); err != nil {
+			return nil, err
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// pingDSCPCompare pings an ICMP host with each of several DSCP markings in
+// parallel, so QoS treatment shows up as a latency difference between them.
+// The host's status is that of the first class; every class is listed in
+// Paths.
+//
+// Parameters:
+//   - host: The full host entry
+//   - addr: The address part of the entry, with a dscp option listing the classes
+//   - classes: The classes to compare, e.g. ["EF", "BE"]
+//   - probe: The ICMP prober
+//
+// Returns:
+//   - HostStatus: Status of the first class with one path per class
+func pingDSCPCompare(host, addr string, classes []string, probe func(string) probeResult) HostStatus {
+	name, opts := splitOptions(addr)
+	results := make([]probeResult, len(classes))
+	var wg sync.WaitGroup
+	for i, c := range classes {
+		opts.Set("dscp", c)
+		classAddr := name + "?" + opts.Encode()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = probe(classAddr)
+		}(i)
+	}
+	wg.Wait()
+
+	status := probeStatus(host, results[0])
+	status.Paths = []PathStatus{{Path: "DSCP " + classes[0], Alive: status.Alive, LatencyMs: status.LatencyMs, PacketLoss: status.PacketLoss}}
+	for i, c := range classes[1:] {
+		status.Paths = append(status.Paths, pathStatus("DSCP "+c, host+"#dscp-"+c, results[i+1]))
+	}
+	return status
+}
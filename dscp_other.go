@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// dscpControl returns a net.Dialer Control function that fails, since
+// marking TCP and UDP probes is only implemented on Unix systems.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return fmt.Errorf("DSCP marking of %s probes is not supported on this platform", network)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/ipv4"
+)
+
+func TestParseDSCP(t *testing.T) {
+	for in, want := range map[string]int{"EF": 46, "af41": 34, "CS0": 0, "be": 0, "46": 46, "63": 63} {
+		got, err := parseDSCP(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, bad := range []string{"", "64", "-1", "AF44", "gold"} {
+		_, err := parseDSCP(bad)
+		assert.Error(t, err, bad)
+	}
+
+	classes, err := dscpOption("EF, BE")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"EF", "BE"}, classes)
+	assert.NoError(t, validateHost("voip-gw?dscp=EF,AF41"))
+	assert.Error(t, validateHost("voip-gw?dscp=EF,gold"))
+}
+
+func TestPingDSCPCompare(t *testing.T) {
+	var mu sync.Mutex
+	var probed []string
+	probe := func(addr string) probeResult {
+		mu.Lock()
+		probed = append(probed, addr)
+		mu.Unlock()
+		if _, opts := splitOptions(addr); opts.Get("dscp") == "EF" {
+			return probeResult{Sent: 1, Recv: 1, Latency: 12 * time.Millisecond}
+		}
+		return probeResult{Sent: 1, Recv: 1, Latency: 40 * time.Millisecond}
+	}
+
+	status := pingDSCPCompare("voip-gw?dscp=EF,BE", "voip-gw?dscp=EF,BE", []string{"EF", "BE"}, probe)
+	sort.Strings(probed)
+	assert.Equal(t, []string{"voip-gw?dscp=BE", "voip-gw?dscp=EF"}, probed)
+	assert.True(t, status.Alive)
+	assert.Equal(t, 12, status.LatencyMs)
+	if assert.Len(t, status.Paths, 2) {
+		assert.Equal(t, PathStatus{Path: "DSCP EF", Alive: true, LatencyMs: 12}, status.Paths[0])
+		assert.Equal(t, PathStatus{Path: "DSCP BE", Alive: true, LatencyMs: 40}, status.Paths[1])
+	}
+}
+
+func TestDialerMarksDSCP(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("DSCP marking of TCP probes is not supported on Windows")
+	}
+	defer func(d int) { probeDSCP = d }(probeDSCP)
+	probeDSCP = 46
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+
+	conn, err := dialProbe("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	tos, err := ipv4.NewConn(conn).TOS()
+	assert.NoError(t, err)
+	assert.Equal(t, 46<<2, tos)
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dscpControl returns a net.Dialer Control function that marks the
+// connection's packets, including the TCP handshake, with dscp.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			if strings.HasSuffix(network, "6") {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscp<<2)
+			} else {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, dscp<<2)
+			}
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
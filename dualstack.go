@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Address families an ICMP host can be pinged over, selected per host with
+// the family option ("8.8.8.8?family=4", "www.example.com?family=dual") or
+// for all hosts with -4, -6 and -dual-stack.
+const (
+	familyIPv4 = "4"
+	familyIPv6 = "6"
+	familyDual = "dual"
+)
+
+var (
+	// addressFamily is the family hostnames are resolved to, set with -4 or
+	// -6; empty lets the resolver choose.
+	addressFamily string
+	// dualStack pings hostnames over both IPv4 and IPv6, set with -dual-stack.
+	dualStack bool
+)
+
+// lookupIP is a variable to allow mocking DNS in tests
+var lookupIP = net.DefaultResolver.LookupIP
+
+// familyFor returns the address family an ICMP host entry is pinged over:
+// its family option, or the global default.
+func familyFor(addr string) string {
+	_, opts := splitOptions(addr)
+	return familyOption(opts)
+}
+
+// familyOption returns the family selected by parsed host options, or the
+// global default.
+func familyOption(opts url.Values) string {
+	if f := opts.Get("family"); f != "" {
+		return f
+	}
+	if dualStack {
+		return familyDual
+	}
+	return addressFamily
+}
+
+// pingNetwork maps an address family to the network name used by pro-bing.
+func pingNetwork(family string) string {
+	switch family {
+	case familyIPv4:
+		return "ip4"
+	case familyIPv6:
+		return "ip6"
+	}
+	return "ip"
+}
+
+// dualStackFamilies returns the families a dual-stack ICMP host has
+// addresses in. IP literals only have their own.
+func dualStackFamilies(name string) []string {
+	if ip := net.ParseIP(strings.Trim(name, "[]")); ip != nil {
+		if ip.To4() != nil {
+			return []string{familyIPv4}
+		}
+		return []string{familyIPv6}
+	}
+	var families []string
+	for _, f := range []string{familyIPv4, familyIPv6} {
+		if ips, err := lookupIP(context.Background(), pingNetwork(f), name); err == nil && len(ips) > 0 {
+			families = append(families, f)
+		}
+	}
+	return families
+}
+
+// pingDualStack pings a hostname over IPv4 and IPv6 in parallel and merges
+// the results like the addresses of a logical host: up while either family
+// answers, degraded while only one does, with both results in Paths. Hosts
+// with addresses in only one family are pinged over that one.
+//
+// Parameters:
+//   - host: The full host entry
+//   - addr: The address part of the entry, with options
+//   - probe: The ICMP prober
+//
+// Returns:
+//   - HostStatus: Merged status; latency is that of IPv4 if it answers
+func pingDualStack(host, addr string, probe func(string) probeResult) HostStatus {
+	name, opts := splitOptions(addr)
+	withFamily := func(f string) string {
+		opts.Set("family", f)
+		return name + "?" + opts.Encode()
+	}
+	families := dualStackFamilies(name)
+	switch len(families) {
+	case 0:
+		// Let the pinger report why the name does not resolve
+		opts.Del("family")
+		return probeStatus(host, probe(name+"?"+opts.Encode()))
+	case 1:
+		return probeStatus(host, probe(withFamily(families[0])))
+	}
+	addrs := make([]string, len(families))
+	for i, f := range families {
+		addrs[i] = withFamily(f)
+	}
+
+	results := make([]probeResult, len(families))
+	var wg sync.WaitGroup
+	for i := range addrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = probe(addrs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var total probeResult
+	var paths []PathStatus
+	var down []string
+	for i, f := range families {
+		res := results[i]
+		path := pathStatus("IPv"+f, host+"#v"+f, res)
+		paths = append(paths, path)
+		total.Sent += res.Sent
+		total.Recv += res.Recv
+		if !path.Alive {
+			down = append(down, path.Path)
+			if total.Reason == "" {
+				total.Reason, total.Detail = res.Reason, res.Detail
+			}
+			continue
+		}
+		if total.Latency == 0 {
+			total.Latency = res.Latency
+		}
+	}
+	status := probeStatus(host, total)
+	if status.Alive && len(down) > 0 {
+		status.Degraded = true
+		status.Detail = strings.Join(down, ", ") + " down"
+	}
+	status.Paths = paths
+	return status
+}
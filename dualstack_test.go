@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFamilyFor(t *testing.T) {
+	defer func(f string, d bool) { addressFamily, dualStack = f, d }(addressFamily, dualStack)
+	addressFamily, dualStack = "", false
+	assert.Equal(t, "", familyFor("example.com"))
+	assert.Equal(t, familyIPv6, familyFor("example.com?family=6"))
+
+	addressFamily = familyIPv4
+	assert.Equal(t, familyIPv4, familyFor("example.com"))
+	dualStack = true
+	assert.Equal(t, familyDual, familyFor("example.com"))
+	assert.Equal(t, familyIPv4, familyFor("example.com?family=4"), "the host option wins")
+}
+
+func TestValidateHostFamily(t *testing.T) {
+	assert.NoError(t, validateHost("example.com?family=dual"))
+	assert.NoError(t, validateHost("icmp://2001:db8::1?family=6"))
+	assert.Error(t, validateHost("example.com?family=ipx"))
+}
+
+// mockLookupIP resolves names from a fixed table.
+func mockLookupIP(t *testing.T, table map[string][]string) {
+	saved := lookupIP
+	t.Cleanup(func() { lookupIP = saved })
+	lookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		var ips []net.IP
+		for _, s := range table[host] {
+			ip := net.ParseIP(s)
+			if (network == "ip4") == (ip.To4() != nil) {
+				ips = append(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			return nil, errors.New("no such host")
+		}
+		return ips, nil
+	}
+}
+
+func TestDualStackFamilies(t *testing.T) {
+	mockLookupIP(t, map[string][]string{
+		"dual.example":   {"192.0.2.1", "2001:db8::1"},
+		"legacy.example": {"192.0.2.2"},
+	})
+	assert.Equal(t, []string{familyIPv4, familyIPv6}, dualStackFamilies("dual.example"))
+	assert.Equal(t, []string{familyIPv4}, dualStackFamilies("legacy.example"))
+	assert.Empty(t, dualStackFamilies("missing.example"))
+	assert.Equal(t, []string{familyIPv6}, dualStackFamilies("[2001:db8::2]"))
+}
+
+func TestPingDualStack(t *testing.T) {
+	mockLookupIP(t, map[string][]string{
+		"dual.example":   {"192.0.2.1", "2001:db8::1"},
+		"legacy.example": {"192.0.2.2"},
+	})
+	var mu sync.Mutex
+	var probed []string
+	probe := func(addr string) probeResult {
+		mu.Lock()
+		probed = append(probed, addr)
+		mu.Unlock()
+		if _, opts := splitOptions(addr); opts.Get("family") == familyIPv6 {
+			return probeResult{Sent: 1, Reason: reasonHostUnreachable}
+		}
+		return probeResult{Sent: 1, Recv: 1, Latency: 12 * time.Millisecond}
+	}
+
+	status := pingDualStack("dual.example?family=dual", "dual.example?family=dual", probe)
+	assert.True(t, status.Alive)
+	assert.True(t, status.Degraded)
+	assert.Equal(t, 12, status.LatencyMs)
+	assert.Equal(t, "IPv6 down", status.Detail)
+	assert.Equal(t, 50.0, status.PacketLoss)
+	assert.Equal(t, []PathStatus{
+		{Path: "IPv4", Alive: true, LatencyMs: 12},
+		{Path: "IPv6", Alive: false, PacketLoss: 100},
+	}, status.Paths)
+	assert.ElementsMatch(t, []string{"dual.example?family=4", "dual.example?family=6"}, probed)
+
+	// Hosts with only an A record are pinged once, without paths
+	probed = nil
+	status = pingDualStack("legacy.example", "legacy.example", probe)
+	assert.True(t, status.Alive)
+	assert.Nil(t, status.Paths)
+	assert.Equal(t, []string{"legacy.example?family=4"}, probed)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ec2Regions is the -ec2-region value: the comma-separated AWS regions
+	// whose EC2 instances are monitored. Empty turns EC2 discovery off.
+	ec2Regions string
+	// ec2Filter is the -ec2-filter value: DescribeInstances filters as
+	// name=value pairs separated by commas, values of one filter by "|",
+	// e.g. "tag:Env=prod,tag:Role=web|api".
+	ec2Filter string
+	// ec2Public monitors the public IPs of instances instead of their
+	// private ones, set with -ec2-public.
+	ec2Public bool
+)
+
+var (
+	// ec2URL is the endpoint of EC2 in a region, a variable to allow
+	// mocking in tests.
+	ec2URL = func(region string) string { return "https://ec2." + region + ".amazonaws.com/" }
+	// imdsURL is the base URL of the EC2 instance metadata service, a
+	// variable to allow mocking in tests.
+	imdsURL = "http://169.254.169.254"
+)
+
+// awsCredentials are the keys requests to AWS are signed with.
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`      // Session token of temporary credentials
+	Expiration      time.Time `json:"Expiration"` // Zero for long-term keys
+}
+
+// ec2Client lists EC2 instances.
+type ec2Client struct {
+	http *http.Client
+	mu   sync.Mutex
+	// creds are the credentials of the instance role, cached until they
+	// are about to expire
+	creds awsCredentials
+}
+
+// credentials returns the AWS credentials from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables, or
+// else those of the instance role mosaic runs under, from the instance
+// metadata service (IMDSv2).
+func (c *ec2Client) credentials(ctx context.Context) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds.AccessKeyID != "" && time.Until(c.creds.Expiration) > 5*time.Minute {
+		return c.creds, nil
+	}
+	// fetch sends a request to the metadata service with the session token
+	fetch := func(method, path, token string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, method, imdsURL+path, nil)
+		if err != nil {
+			return "", err
+		}
+		if token == "" {
+			req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+		} else {
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("instance metadata %s: %s", path, resp.Status)
+		}
+		return strings.TrimSpace(string(body)), nil
+	}
+	token, err := fetch(http.MethodPut, "/latest/api/token", "")
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS_ACCESS_KEY_ID and no instance role: %v", err)
+	}
+	role, err := fetch(http.MethodGet, "/latest/meta-data/iam/security-credentials/", token)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role, _, _ = strings.Cut(role, "\n")
+	data, err := fetch(http.MethodGet, "/latest/meta-data/iam/security-credentials/"+role, token)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(data), &creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("instance role credentials: %v", err)
+	}
+	c.creds = creds
+	return creds, nil
+}
+
+// signAWS signs req with AWS Signature Version 4 for service in region.
+// The request must not have a body.
+func signAWS(req *http.Request, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+	headers := map[string]string{"host": req.URL.Host, "x-amz-date": amzDate}
+	if creds.Token != "" {
+		headers["x-amz-security-token"] = creds.Token
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	emptyHash := sha256.Sum256(nil)
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signed,
+		hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = mac(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, hex.EncodeToString(mac(key, toSign))))
+}
+
+// ec2Filters returns the DescribeInstances query parameters of -ec2-filter,
+// which only ever matches running instances.
+func ec2Filters(filter string) (url.Values, error) {
+	q := url.Values{}
+	q.Set("Filter.1.Name", "instance-state-name")
+	q.Set("Filter.1.Value.1", "running")
+	n := 2
+	for _, f := range strings.Split(filter, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(f, "=")
+		if !ok || name == "" || values == "" {
+			return nil, fmt.Errorf("invalid EC2 filter %q, expected name=value", f)
+		}
+		prefix := "Filter." + strconv.Itoa(n)
+		q.Set(prefix+".Name", name)
+		for i, v := range strings.Split(values, "|") {
+			q.Set(prefix+".Value."+strconv.Itoa(i+1), v)
+		}
+		n++
+	}
+	return q, nil
+}
+
+// ec2Instance is the part of an instance in a DescribeInstances response
+// mosaic reads.
+type ec2Instance struct {
+	ID        string `xml:"instanceId"`
+	PrivateIP string `xml:"privateIpAddress"`
+	PublicIP  string `xml:"ipAddress"`
+	Tags      []struct {
+		Key   string `xml:"key"`
+		Value string `xml:"value"`
+	} `xml:"tagSet>item"`
+}
+
+// instanceName returns the name of an instance for its host entry: its
+// Name tag followed by its ID, since instances of an autoscaling group
+// share their Name, e.g. "web.i-0a1b2c3d", or its ID alone.
+func (i ec2Instance) instanceName() string {
+	for _, t := range i.Tags {
+		if t.Key == "Name" && t.Value != "" {
+			return hostLabel(t.Value) + "." + i.ID
+		}
+	}
+	return i.ID
+}
+
+// listEC2 lists the running instances matching -ec2-filter in region and
+// returns their hosts keyed by instance ID: logical hosts named after the
+// instance with its private IP, or its public IP with -ec2-public, in the
+// groups "aws" and "aws/<region>". Instances without such an address are
+// skipped.
+func (c *ec2Client) listEC2(ctx context.Context, region string) (map[string]discovered, error) {
+	query, err := ec2Filters(ec2Filter)
+	if err != nil {
+		return nil, err
+	}
+	query.Set("Action", "DescribeInstances")
+	query.Set("Version", "2016-11-15")
+	hosts := make(map[string]discovered)
+	for {
+		creds, err := c.credentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ec2URL(region)+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		signAWS(req, creds, region, "ec2", time.Now())
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			var e struct {
+				Code    string `xml:"Errors>Error>Code"`
+				Message string `xml:"Errors>Error>Message"`
+			}
+			if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+				return nil, fmt.Errorf("%s: %s", e.Code, e.Message)
+			}
+			return nil, errors.New(resp.Status)
+		}
+		var out struct {
+			Instances []ec2Instance `xml:"reservationSet>item>instancesSet>item"`
+			NextToken string        `xml:"nextToken"`
+		}
+		if err := xml.Unmarshal(body, &out); err != nil {
+			return nil, fmt.Errorf("invalid DescribeInstances response: %v", err)
+		}
+		for _, i := range out.Instances {
+			addr := i.PrivateIP
+			if ec2Public {
+				addr = i.PublicIP
+			}
+			if addr != "" {
+				hosts[i.ID] = discovered{entry: logicalEntry(i.instanceName(), []string{addr}), groups: cloudGroups("aws", region)}
+			}
+		}
+		if out.NextToken == "" {
+			return hosts, nil
+		}
+		query.Set("NextToken", out.NextToken)
+	}
+}
+
+// ec2Provider is the EC2 instances of a region.
+type ec2Provider struct {
+	client *ec2Client
+	region string
+}
+
+// String implements Discovery.
+func (p ec2Provider) String() string { return "EC2 " + p.region }
+
+// List implements Discovery.
+func (p ec2Provider) List(ctx context.Context) (map[string]discovered, error) {
+	return p.client.listEC2(ctx, p.region)
+}
+
+// ec2Providers returns a provider for each -ec2-region.
+func ec2Providers() []Discovery {
+	c := &ec2Client{http: &http.Client{Timeout: time.Minute}}
+	var providers []Discovery
+	for _, region := range strings.Split(ec2Regions, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			providers = append(providers, ec2Provider{client: c, region: region})
+		}
+	}
+	return providers
+}
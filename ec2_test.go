@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAWS(t *testing.T) {
+	// The get-vanilla case of the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWS(req, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+
+	creds.Token = "session"
+	signAWS(req, creds, "us-east-1", "service", time.Now())
+	assert.Equal(t, "session", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+}
+
+func TestEC2Filters(t *testing.T) {
+	q, err := ec2Filters("tag:Env=prod, tag:Role=web|api")
+	assert.NoError(t, err)
+	assert.Equal(t, "instance-state-name", q.Get("Filter.1.Name"))
+	assert.Equal(t, "running", q.Get("Filter.1.Value.1"))
+	assert.Equal(t, "tag:Env", q.Get("Filter.2.Name"))
+	assert.Equal(t, "prod", q.Get("Filter.2.Value.1"))
+	assert.Equal(t, "tag:Role", q.Get("Filter.3.Name"))
+	assert.Equal(t, "api", q.Get("Filter.3.Value.2"))
+
+	_, err = ec2Filters("tag:Env")
+	assert.Error(t, err)
+}
+
+// ec2Page renders a DescribeInstances response with one instance.
+func ec2Page(id, name, private, public, next string) string {
+	tags := ""
+	if name != "" {
+		tags = fmt.Sprintf("<tagSet><item><key>Name</key><value>%s</value></item></tagSet>", name)
+	}
+	return fmt.Sprintf(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+<reservationSet><item><instancesSet><item><instanceId>%s</instanceId><privateIpAddress>%s</privateIpAddress><ipAddress>%s</ipAddress>%s</item></instancesSet></item></reservationSet>
+<nextToken>%s</nextToken></DescribeInstancesResponse>`, id, private, public, tags, next)
+}
+
+func TestListEC2(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	oldURL, oldFilter, oldPublic := ec2URL, ec2Filter, ec2Public
+	t.Cleanup(func() { ec2URL, ec2Filter, ec2Public = oldURL, oldFilter, oldPublic })
+	ec2Filter = "tag:Env=prod"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=AKID/")
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/ec2/aws4_request")
+		assert.Equal(t, "DescribeInstances", r.URL.Query().Get("Action"))
+		assert.Equal(t, "prod", r.URL.Query().Get("Filter.2.Value.1"))
+		switch r.URL.Query().Get("NextToken") {
+		case "":
+			fmt.Fprint(w, ec2Page("i-0a1", "web server", "10.0.0.1", "203.0.113.1", "page2"))
+		case "page2":
+			fmt.Fprint(w, ec2Page("i-0b2", "", "10.0.0.2", "", ""))
+		}
+	}))
+	defer srv.Close()
+	ec2URL = func(region string) string { return srv.URL + "/" }
+
+	c := &ec2Client{http: srv.Client()}
+	hosts, err := c.listEC2(context.Background(), "eu-west-1")
+	assert.NoError(t, err)
+	groups := []string{"aws", "aws/eu-west-1"}
+	assert.Equal(t, map[string]discovered{
+		"i-0a1": {entry: "web-server.i-0a1=10.0.0.1", groups: groups},
+		"i-0b2": {entry: "i-0b2=10.0.0.2", groups: groups},
+	}, hosts)
+	for _, h := range hosts {
+		assert.NoError(t, validateHost(h.entry))
+	}
+
+	ec2Public = true
+	hosts, err = c.listEC2(context.Background(), "eu-west-1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]discovered{"i-0a1": {entry: "web-server.i-0a1=203.0.113.1", groups: groups}}, hosts, "instances without a public IP are skipped")
+}
+
+func TestListEC2Error(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	oldURL := ec2URL
+	t.Cleanup(func() { ec2URL = oldURL })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `<Response><Errors><Error><Code>AuthFailure</Code><Message>AWS was not able to validate the provided access credentials</Message></Error></Errors></Response>`)
+	}))
+	defer srv.Close()
+	ec2URL = func(string) string { return srv.URL + "/" }
+
+	_, err := (&ec2Client{http: srv.Client()}).listEC2(context.Background(), "us-east-1")
+	assert.ErrorContains(t, err, "AuthFailure: AWS was not able to validate")
+}
+
+func TestEC2InstanceRoleCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	oldIMDS := imdsURL
+	t.Cleanup(func() { imdsURL = oldIMDS })
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/latest/api/token" {
+			assert.Equal(t, http.MethodPut, r.Method)
+			fmt.Fprint(w, "imds-token")
+			return
+		}
+		assert.Equal(t, "imds-token", r.Header.Get("X-aws-ec2-metadata-token"))
+		if strings.HasSuffix(r.URL.Path, "/") {
+			fmt.Fprint(w, "mosaic-role\n")
+			return
+		}
+		assert.Equal(t, "/latest/meta-data/iam/security-credentials/mosaic-role", r.URL.Path)
+		fmt.Fprintf(w, `{"AccessKeyId":"ASIA1","SecretAccessKey":"s","Token":"t","Expiration":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer srv.Close()
+	imdsURL = srv.URL
+
+	c := &ec2Client{http: srv.Client()}
+	creds, err := c.credentials(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ASIA1", creds.AccessKeyID)
+	assert.Equal(t, "t", creds.Token)
+
+	_, err = c.credentials(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, requests, "credentials are cached until they expire")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event is a notable occurrence worth surfacing to operators, such as the
+// start of a correlated incident.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`               // Machine-readable kind, e.g. "correlated_incident"
+	Key      string    `json:"key,omitempty"`      // What the event is about, e.g. a correlation group
+	Hosts    []string  `json:"hosts,omitempty"`    // Hosts involved
+	Priority string    `json:"priority,omitempty"` // Highest priority of the hosts involved, for routing alerts
+	Message  string    `json:"message"`            // Human-readable summary
+}
+
+// eventLog keeps the most recent events in memory.
+type eventLog struct {
+	mu     sync.Mutex
+	max    int
+	events []Event
+	notify func(Event) // Called with every added event, if set
+}
+
+// eventLogSize is how many events are retained for /api/events.
+const eventLogSize = 500
+
+var events = func() *eventLog {
+	l := newEventLog(eventLogSize)
+	l.notify = onEvent
+	return l
+}()
+
+// onEvent forwards a recorded event to WebSocket subscribers, notifiers
+// and event sinks such as -kafka.
+func onEvent(e Event) {
+	publishEvent(e)
+	notifications.dispatch(e)
+	sinks.recordEvent(e)
+}
+
+// newEventLog creates an event log retaining at most max events.
+func newEventLog(max int) *eventLog {
+	return &eventLog{max: max}
+}
+
+// add records e, stamping it with the current time and the priority of its
+// hosts if unset, and logs it.
+func (l *eventLog) add(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Priority == "" && len(e.Hosts) > 0 {
+		e.Priority = hostsPriority(e.Hosts)
+	}
+	log.Printf("event %s: %s", e.Type, e.Message)
+	l.mu.Lock()
+	l.events = append(l.events, e)
+	if len(l.events) > l.max {
+		l.events = l.events[len(l.events)-l.max:]
+	}
+	l.mu.Unlock()
+	if l.notify != nil {
+		l.notify(e)
+	}
+}
+
+// recent returns the retained events, newest first.
+func (l *eventLog) recent() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Event, len(l.events))
+	for i, e := range l.events {
+		out[len(l.events)-1-i] = e
+	}
+	return out
+}
+
+// eventsHandler serves the retained events as JSON, newest first.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events.recent())
+}
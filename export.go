@@ -0,0 +1,109 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushEvery is how many rows of /api/export.csv are sent at a time.
+const exportFlushEvery = 500
+
+// exportHeader is the header row of /api/export.csv.
+var exportHeader = []string{"time", "host", "latency_ms", "packet_loss", "state", "samples", "up"}
+
+// exportRow returns r as a row of /api/export.csv. Results stored as they
+// are have one sample.
+func exportRow(r HistoryResult) []string {
+	samples, up := r.weight()
+	return []string{
+		r.Time.UTC().Format("2006-01-02T15:04:05.000Z"),
+		r.Host,
+		strconv.Itoa(r.LatencyMs),
+		strconv.FormatFloat(r.PacketLoss, 'f', -1, 64),
+		r.State,
+		strconv.Itoa(samples),
+		strconv.Itoa(up),
+	}
+}
+
+// exportCSVHandler streams stored probe results as CSV, by host and time,
+// for spreadsheets. The query parameters from and to take the same times
+// as since and until of /api/history and default to a day ago and now;
+// host narrows the results down to one host. The response is sent in
+// chunks as the results are read, so a range of any length takes little
+// memory, and gzip-compressed if the client accepts it.
+func exportCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "no history is kept", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	from, to := now.Add(-24*time.Hour), now
+	q := r.URL.Query()
+	var err error
+	if s := q.Get("from"); s != "" {
+		if from, err = parseHistoryTime(s, now); err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		if to, err = parseHistoryTime(s, now); err != nil {
+			http.Error(w, "invalid to", http.StatusBadRequest)
+			return
+		}
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="mosaic-history.csv"`)
+	w.Header().Add("Vary", "Accept-Encoding")
+	var out io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = func() {
+			if gz, ok := out.(*gzip.Writer); ok {
+				gz.Flush()
+			}
+			f.Flush()
+		}
+	}
+	cw := csv.NewWriter(out)
+	cw.Write(exportHeader)
+	rows := 0
+	err = history.store.each(q.Get("host"), from, to, func(res HistoryResult) error {
+		if err := cw.Write(exportRow(res)); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushEvery == 0 {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			flush()
+		}
+		return nil
+	})
+	cw.Flush()
+	// The status is sent; a failure can only cut the export short
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		log.Printf("CSV export: %v", err)
+	}
+}
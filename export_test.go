@@ -0,0 +1,73 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportCSVHandler(t *testing.T) {
+	defer func(h *resultHistory) { history = h }(history)
+	s := newMemoryStore(10)
+	history = &resultHistory{store: s}
+	now := time.Now()
+	at := now.Add(-time.Hour).Truncate(time.Second)
+	s.write([]HistoryResult{
+		{Time: at, Host: "b", LatencyMs: 20, State: "up"},
+		{Time: at, Host: "a", PacketLoss: 100, State: "down"},
+		{Time: at.Add(time.Second), Host: "a", LatencyMs: 12, PacketLoss: 12.5, State: "degraded"},
+		{Time: now.Add(-48 * time.Hour), Host: "a", LatencyMs: 1, State: "up"},
+	})
+	get := func(query string, gz bool) (*httptest.ResponseRecorder, [][]string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/export.csv?"+query, nil)
+		if gz {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		exportCSVHandler(w, req)
+		if w.Code != http.StatusOK {
+			return w, nil
+		}
+		body := w.Body
+		var records [][]string
+		var err error
+		if gz {
+			zr, zerr := gzip.NewReader(body)
+			if zerr != nil {
+				t.Fatal(zerr)
+			}
+			records, err = csv.NewReader(zr).ReadAll()
+		} else {
+			records, err = csv.NewReader(body).ReadAll()
+		}
+		assert.NoError(t, err)
+		return w, records
+	}
+
+	w, records := get("", false)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	stamp := at.UTC().Format("2006-01-02T15:04:05.000Z")
+	assert.Equal(t, [][]string{
+		exportHeader,
+		{stamp, "a", "0", "100", "down", "1", "0"},
+		{at.Add(time.Second).UTC().Format("2006-01-02T15:04:05.000Z"), "a", "12", "12.5", "degraded", "1", "1"},
+		{stamp, "b", "20", "0", "up", "1", "1"},
+	}, records, "the last day by host and time")
+
+	w, records = get("host=a&from=72h&to="+now.Format(time.RFC3339), true)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Len(t, records, 4)
+
+	w, _ = get("from=tomorrow", false)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = get("from=1h&to=2h", false)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	history = nil
+	w, _ = get("", false)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// watchHostsFile reloads the hosts of -file whenever the file changes, set
+// with -watch-file, so regenerating the inventory takes effect without a
+// restart. Running probes and WebSocket clients are left alone.
+var watchHostsFile = true
+
+// fileSettle is how long the hosts file must stay unchanged before it is
+// read, so a file written in several steps is not read half-written.
+var fileSettle = time.Second
+
+// filePollInterval is how often the hosts file is checked where the platform
+// offers no change notifications.
+const filePollInterval = 2 * time.Second
+
+// watchHosts reloads the hosts of path each time it changes until ctx is
+// done. Failures to read or validate the file are logged and the running
+// hosts kept.
+func watchHosts(ctx context.Context, path string) {
+	changes, err := watchFile(ctx, path)
+	if err != nil {
+		log.Printf("Not watching %s for changes: %v", path, err)
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		}
+		settle := time.NewTimer(fileSettle)
+	settling:
+		for {
+			select {
+			case <-ctx.Done():
+				settle.Stop()
+				return
+			case <-changes:
+				settle.Reset(fileSettle)
+			case <-settle.C:
+				break settling
+			}
+		}
+		if _, err := reloadFromDisk(time.Now(), "hosts file changed"); err != nil {
+			log.Printf("Keeping the running hosts, %s changed but cannot be applied: %v", path, err)
+		}
+	}
+}
+
+// pollFile sends on the returned channel whenever the size or modification
+// time of path changes, checking every interval until ctx is done. A file
+// that disappears counts as changed, as does one that appears again.
+func pollFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	stamp := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	go func() {
+		mod, size := stamp()
+		for sleepCtx(ctx, interval) {
+			m, s := stamp()
+			if m.Equal(mod) && s == size {
+				continue
+			}
+			mod, size = m, s
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
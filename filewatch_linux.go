@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchFile is a variable to allow mocking file changes in tests. On Linux
+// it watches the file's directory with inotify, so replacing the file by
+// renaming a new one over it is noticed too, as is the "..data" symlink
+// swap Kubernetes uses to update mounted ConfigMaps. It falls back to
+// polling if inotify is not available.
+var watchFile = func(ctx context.Context, path string) (<-chan struct{}, error) {
+	dir, name := filepath.Split(filepath.Clean(path))
+	if dir == "" {
+		dir = "."
+	}
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return pollFile(ctx, path, filePollInterval), nil
+	}
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM
+	if _, err := unix.InotifyAddWatch(fd, dir, mask); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 64<<10)
+		for ctx.Err() == nil {
+			// Poll with a timeout so the watcher notices ctx being done
+			n, err := unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}, 500)
+			if err == unix.EINTR || n == 0 {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if n, err = unix.Read(fd, buf); err != nil || !inotifyTouches(buf[:n], name) {
+				continue
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// inotifyTouches reports whether the inotify events in buf concern name or
+// a Kubernetes ConfigMap's "..data" entries.
+func inotifyTouches(buf []byte, name string) bool {
+	for len(buf) >= unix.SizeofInotifyEvent {
+		// struct inotify_event: wd, mask, cookie, len, then the name
+		end := unix.SizeofInotifyEvent + int(binary.NativeEndian.Uint32(buf[12:16]))
+		if end > len(buf) {
+			break
+		}
+		evName := strings.TrimRight(string(buf[unix.SizeofInotifyEvent:end]), "\x00")
+		if evName == name || strings.HasPrefix(evName, "..") {
+			return true
+		}
+		buf = buf[end:]
+	}
+	return false
+}
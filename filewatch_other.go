@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "context"
+
+// watchFile is a variable to allow mocking file changes in tests. Without
+// inotify it polls the file every filePollInterval.
+var watchFile = func(ctx context.Context, path string) (<-chan struct{}, error) {
+	return pollFile(ctx, path, filePollInterval), nil
+}
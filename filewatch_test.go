@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withHostsFile points -file at a temporary file holding body.
+func withHostsFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts.txt")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldConfig, oldFile, oldFlag := configFile, hostsFile, hostsFlag
+	configFile, hostsFile, hostsFlag = "", path, ""
+	t.Cleanup(func() { configFile, hostsFile, hostsFlag = oldConfig, oldFile, oldFlag })
+	return path
+}
+
+func TestWatchHostsSettles(t *testing.T) {
+	withHosts(t, "a")
+	withHostsFile(t, "a\nb\n")
+	defer func(w func(context.Context, string) (<-chan struct{}, error), d time.Duration) {
+		watchFile, fileSettle = w, d
+	}(watchFile, fileSettle)
+	changes := make(chan struct{})
+	watchFile = func(context.Context, string) (<-chan struct{}, error) { return changes, nil }
+	fileSettle = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchHosts(ctx, hostsFile)
+	}()
+	changes <- struct{}{}
+	changes <- struct{}{}
+	assert.Equal(t, []string{"a"}, currentHosts(), "the file is read once it settles")
+	assert.Eventually(t, func() bool { return len(currentHosts()) == 2 }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestPollFile(t *testing.T) {
+	path := withHostsFile(t, "a\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := pollFile(ctx, path, 10*time.Millisecond)
+
+	select {
+	case <-changes:
+		t.Fatal("unchanged file reported as changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	os.WriteFile(path, []byte("a\nb\n"), 0o644)
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("change not reported")
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := withHostsFile(t, "a\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := watchFile(ctx, path)
+	assert.NoError(t, err)
+
+	// Replace the file the way inventory automation does
+	tmp := path + ".new"
+	os.WriteFile(tmp, []byte("a\nb\n"), 0o644)
+	assert.NoError(t, os.Rename(tmp, path))
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not reported")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// gcpProjects is the -gcp-project value: the comma-separated Google
+	// Cloud projects whose Compute Engine VMs are monitored. Empty turns GCP
+	// discovery off.
+	gcpProjects string
+	// gcpFilter is the -gcp-filter value: a Compute Engine list filter,
+	// e.g. "labels.env=prod".
+	gcpFilter string
+	// gcpPublic monitors the external IPs of VMs instead of their internal
+	// ones, set with -gcp-public.
+	gcpPublic bool
+)
+
+var (
+	// gcpComputeURL is the base URL of the Compute Engine API, a variable to
+	// allow mocking in tests.
+	gcpComputeURL = "https://compute.googleapis.com/compute/v1"
+	// gcpMetadataURL is the base URL of the GCE metadata server, a variable
+	// to allow mocking in tests.
+	gcpMetadataURL = "http://metadata.google.internal"
+)
+
+// gcpScope is the OAuth scope VMs are listed with.
+const gcpScope = "https://www.googleapis.com/auth/compute.readonly"
+
+// gcpProvider is the Compute Engine VMs of a project.
+type gcpProvider struct {
+	project string
+	token   *bearerToken
+	http    *http.Client
+}
+
+// gcpProviders returns a provider for each -gcp-project.
+func gcpProviders() []Discovery {
+	client := &http.Client{Timeout: time.Minute}
+	token := &bearerToken{fetch: func(ctx context.Context) (string, time.Duration, error) {
+		return gcpToken(ctx, client)
+	}}
+	var providers []Discovery
+	for _, p := range strings.Split(gcpProjects, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			providers = append(providers, gcpProvider{project: p, token: token, http: client})
+		}
+	}
+	return providers
+}
+
+// String implements Discovery.
+func (p gcpProvider) String() string { return "GCP " + p.project }
+
+// gcpTokenResponse is an OAuth token response of Google.
+type gcpTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"` // Seconds
+}
+
+// gcpToken gets an access token for the service account key file named by
+// GOOGLE_APPLICATION_CREDENTIALS, or else for the service account of the
+// VM mosaic runs on from the metadata server.
+func gcpToken(ctx context.Context, client *http.Client) (string, time.Duration, error) {
+	var req *http.Request
+	if file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); file != "" {
+		assertion, tokenURL, err := gcpAssertion(file, time.Now())
+		if err != nil {
+			return "", 0, err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode())); err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		var err error
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataURL+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("no GOOGLE_APPLICATION_CREDENTIALS and no metadata server: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("GCP token: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var t gcpTokenResponse
+	if err := json.Unmarshal(body, &t); err != nil {
+		return "", 0, fmt.Errorf("GCP token: %v", err)
+	}
+	return t.AccessToken, time.Duration(t.ExpiresIn) * time.Second, nil
+}
+
+// gcpAssertion creates the signed JWT a service account exchanges for an
+// access token, from the service account key file at file.
+//
+// Returns:
+//   - string: The JWT
+//   - string: The token URL of the key file
+//   - error: If the key file cannot be read or is not a service account key
+func gcpAssertion(file string, now time.Time) (string, string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", "", err
+	}
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return "", "", fmt.Errorf("%s: %v", file, err)
+	}
+	if key.Type != "service_account" {
+		return "", "", fmt.Errorf("%s: not a service account key", file)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", "", fmt.Errorf("%s: invalid private key", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if err != nil || !ok {
+		return "", "", fmt.Errorf("%s: private key is not an RSA key", file)
+	}
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	unsigned := enc(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + enc(map[string]any{
+		"iss":   key.ClientEmail,
+		"scope": gcpScope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), key.TokenURI, nil
+}
+
+// gcpInstance is the part of a Compute Engine VM mosaic reads.
+type gcpInstance struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	Status            string `json:"status"`
+	Zone              string `json:"zone"` // URL of the zone
+	NetworkInterfaces []struct {
+		NetworkIP     string `json:"networkIP"`
+		AccessConfigs []struct {
+			NatIP string `json:"natIP"`
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+}
+
+// gcpRegion returns the region of a zone URL, e.g. "europe-west1" for
+// ".../zones/europe-west1-b".
+func gcpRegion(zone string) string {
+	zone = path.Base(zone)
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// List implements Discovery: the running VMs of the project matching
+// -gcp-filter keyed by ID, as logical hosts named after the VM with the
+// internal IP of its first network interface, or its external IP with
+// -gcp-public, in the groups "gcp" and "gcp/<region>".
+func (p gcpProvider) List(ctx context.Context) (map[string]discovered, error) {
+	hosts := make(map[string]discovered)
+	query := url.Values{"returnPartialSuccess": {"true"}}
+	if gcpFilter != "" {
+		query.Set("filter", gcpFilter)
+	}
+	for {
+		token, err := p.token.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		u := gcpComputeURL + "/projects/" + url.PathEscape(p.project) + "/aggregated/instances?" + query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := p.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items map[string]struct {
+				Instances []gcpInstance `json:"instances"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+			Error         struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			if page.Error.Message != "" {
+				return nil, errors.New(page.Error.Message)
+			}
+			return nil, errors.New(resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid instance list: %v", err)
+		}
+		for _, scope := range page.Items {
+			for _, vm := range scope.Instances {
+				if vm.Status != "RUNNING" || len(vm.NetworkInterfaces) == 0 {
+					continue
+				}
+				nic := vm.NetworkInterfaces[0]
+				addr := nic.NetworkIP
+				if gcpPublic {
+					addr = ""
+					if len(nic.AccessConfigs) > 0 {
+						addr = nic.AccessConfigs[0].NatIP
+					}
+				}
+				if addr != "" {
+					hosts[vm.ID] = discovered{entry: logicalEntry(hostLabel(vm.Name), []string{addr}), groups: cloudGroups("gcp", gcpRegion(vm.Zone))}
+				}
+			}
+		}
+		if page.NextPageToken == "" {
+			return hosts, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCPRegion(t *testing.T) {
+	assert.Equal(t, "europe-west1", gcpRegion("https://www.googleapis.com/compute/v1/projects/p/zones/europe-west1-b"))
+	assert.Equal(t, "us-central1", gcpRegion("us-central1-a"))
+}
+
+func TestGCPAssertion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	file := filepath.Join(t.TempDir(), "key.json")
+	data, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "mosaic@shop-prod.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	assert.NoError(t, os.WriteFile(file, data, 0o600))
+
+	now := time.Unix(1700000000, 0)
+	jwt, tokenURL, err := gcpAssertion(file, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://oauth2.googleapis.com/token", tokenURL)
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("not a JWT: %s", jwt)
+	}
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.JSONEq(t, `{"iss":"mosaic@shop-prod.iam.gserviceaccount.com","scope":"https://www.googleapis.com/auth/compute.readonly",
+		"aud":"https://oauth2.googleapis.com/token","iat":1700000000,"exp":1700003600}`, string(claims))
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+
+	assert.NoError(t, os.WriteFile(file, []byte(`{"type":"authorized_user"}`), 0o600))
+	_, _, err = gcpAssertion(file, now)
+	assert.ErrorContains(t, err, "not a service account key")
+}
+
+func TestGCPList(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	oldCompute, oldMetadata, oldFilter, oldPublic := gcpComputeURL, gcpMetadataURL, gcpFilter, gcpPublic
+	t.Cleanup(func() {
+		gcpComputeURL, gcpMetadataURL, gcpFilter, gcpPublic = oldCompute, oldMetadata, oldFilter, oldPublic
+	})
+	gcpFilter = "labels.env=prod"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/computeMetadata/v1/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		fmt.Fprint(w, `{"access_token":"ya29","expires_in":3599}`)
+	})
+	mux.HandleFunc("/projects/shop-prod/aggregated/instances", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer ya29", r.Header.Get("Authorization"))
+		assert.Equal(t, "labels.env=prod", r.URL.Query().Get("filter"))
+		if r.URL.Query().Get("pageToken") == "" {
+			fmt.Fprint(w, `{"items":{"zones/europe-west1-b":{"instances":[
+				{"id":"101","name":"web-1","status":"RUNNING","zone":"https://x/zones/europe-west1-b","networkInterfaces":[{"networkIP":"10.132.0.2","accessConfigs":[{"natIP":"34.76.1.2"}]}]},
+				{"id":"102","name":"web-2","status":"TERMINATED","zone":"https://x/zones/europe-west1-b","networkInterfaces":[{"networkIP":"10.132.0.3"}]}]},
+				"zones/us-east1-c":{"warning":{"code":"NO_RESULTS_ON_PAGE"}}},"nextPageToken":"p2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"items":{"zones/us-east1-c":{"instances":[{"id":"103","name":"db-1","status":"RUNNING","zone":"https://x/zones/us-east1-c","networkInterfaces":[{"networkIP":"10.142.0.5"}]}]}}}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	gcpComputeURL, gcpMetadataURL = srv.URL, srv.URL
+
+	oldProjects := gcpProjects
+	t.Cleanup(func() { gcpProjects = oldProjects })
+	gcpProjects = "shop-prod"
+	p := gcpProviders()[0]
+	hosts, err := p.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]discovered{
+		"101": {entry: "web-1=10.132.0.2", groups: []string{"gcp", "gcp/europe-west1"}},
+		"103": {entry: "db-1=10.142.0.5", groups: []string{"gcp", "gcp/us-east1"}},
+	}, hosts)
+
+	gcpPublic = true
+	hosts, err = p.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"101"}, mapKeys(hosts), "VMs without an external IP are skipped")
+	assert.Equal(t, "web-1=34.76.1.2", hosts["101"].entry)
+}
+
+// mapKeys returns the keys of m.
+func mapKeys(m map[string]discovered) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// grafanaMetrics are the series of a host /api/grafana serves, named as
+// its targets "<metric>:<host>".
+var grafanaMetrics = []string{"latency_ms", "packet_loss", "uptime_percent"}
+
+// grafanaRange is the time range of a Grafana request.
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// grafanaQuery is the body of a query of the Grafana SimpleJSON data
+// source.
+type grafanaQuery struct {
+	Range         grafanaRange `json:"range"`
+	IntervalMs    int64        `json:"intervalMs"`
+	MaxDataPoints int          `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// GrafanaSeries is a series of an /api/grafana/query response: its target
+// and its [value, Unix milliseconds] points, value null where there are no
+// results.
+type GrafanaSeries struct {
+	Target     string   `json:"target"`
+	Datapoints [][2]any `json:"datapoints"`
+}
+
+// grafanaAnnotationQuery is the body of an annotation query of the Grafana
+// SimpleJSON data source. Its query is a host or "@group", or empty for
+// all hosts.
+type grafanaAnnotationQuery struct {
+	Range      grafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+// GrafanaAnnotation is an outage as an /api/grafana/annotations response
+// marks it on a chart.
+type GrafanaAnnotation struct {
+	Annotation any      `json:"annotation"` // The annotation of the query, echoed back
+	Time       int64    `json:"time"`       // Start in Unix milliseconds
+	TimeEnd    int64    `json:"timeEnd"`    // End in Unix milliseconds
+	IsRegion   bool     `json:"isRegion"`
+	Title      string   `json:"title"`
+	Text       string   `json:"text"`
+	Tags       []string `json:"tags"`
+}
+
+// grafanaHandler serves the stored history as a Grafana SimpleJSON (or
+// Infinity) data source, so Grafana charts it without a separate time
+// series database. Point the data source at /api/grafana:
+//
+//	GET  /api/grafana/             connection test
+//	POST /api/grafana/search       target names, "<metric>:<host>" or "<metric>:@<group>"
+//	POST /api/grafana/query        series of the targets in the range
+//	POST /api/grafana/annotations  outages in the range
+func grafanaHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "no history is kept", http.StatusNotFound)
+		return
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, "/api/grafana")
+	if endpoint == "" || endpoint == "/" {
+		w.Write([]byte("OK\n"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var out any
+	switch endpoint {
+	case "/search":
+		var q struct {
+			Target string `json:"target"`
+		}
+		json.Unmarshal(body, &q)
+		out = grafanaTargets(q.Target)
+	case "/query":
+		var q grafanaQuery
+		if err := json.Unmarshal(body, &q); err != nil {
+			http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if out, err = grafanaSeries(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "/annotations":
+		var q grafanaAnnotationQuery
+		if err := json.Unmarshal(body, &q); err != nil {
+			http.Error(w, "invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if out, err = grafanaAnnotations(q, body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// grafanaTargets returns the targets /api/grafana/search offers that
+// contain filter: every metric of each monitored host and group.
+func grafanaTargets(filter string) []string {
+	var names []string
+	groups := make(map[string]bool)
+	for h := range monitoredHosts() {
+		names = append(names, h)
+		for _, g := range hostGroups(h) {
+			groups["@"+g] = true
+		}
+	}
+	for g := range groups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+	targets := []string{}
+	for _, m := range grafanaMetrics {
+		for _, name := range names {
+			if t := m + ":" + name; strings.Contains(t, filter) {
+				targets = append(targets, t)
+			}
+		}
+	}
+	return targets
+}
+
+// grafanaSeries answers a query with a series per target, and per host of
+// a group target. The series are in buckets of the query's interval, or
+// wider to stay within its maxDataPoints, in whole seconds.
+func grafanaSeries(q grafanaQuery) ([]GrafanaSeries, error) {
+	from, to := q.Range.From, q.Range.To
+	if !from.Before(to) {
+		return nil, fmt.Errorf("range from must be before to")
+	}
+	step := max(time.Second, time.Duration(q.IntervalMs)*time.Millisecond)
+	if q.MaxDataPoints > 0 {
+		step = max(step, to.Sub(from)/time.Duration(q.MaxDataPoints))
+	}
+	step = step.Round(time.Second)
+	since := from.Truncate(step)
+	if to.Sub(since)/step > historyLimit {
+		return nil, fmt.Errorf("more than %d points; raise the interval", historyLimit)
+	}
+	out := []GrafanaSeries{}
+	for _, t := range q.Targets {
+		metric, host, ok := strings.Cut(t.Target, ":")
+		if !ok || !slices.Contains(grafanaMetrics, metric) || host == "" {
+			return nil, fmt.Errorf("invalid target %q: expected <metric>:<host>, metric one of %s", t.Target, strings.Join(grafanaMetrics, ", "))
+		}
+		hosts := []string{host}
+		if group, ok := strings.CutPrefix(host, "@"); ok {
+			hosts = groupMembers(group)
+		}
+		series, err := historySeries(history.store, hosts, since, to, step)
+		if err != nil {
+			return nil, err
+		}
+		for _, hs := range series.Hosts {
+			values := hs.UptimePercent
+			switch metric {
+			case "latency_ms":
+				values = hs.LatencyMs
+			case "packet_loss":
+				values = hs.PacketLoss
+			}
+			s := GrafanaSeries{Target: metric + ":" + hs.Host, Datapoints: make([][2]any, len(values))}
+			for i, v := range values {
+				s.Datapoints[i] = [2]any{v, series.Times[i].UnixMilli()}
+			}
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// grafanaAnnotations answers an annotation query with the outages of its
+// host or group in the range, as found by /api/incidents.
+func grafanaAnnotations(q grafanaAnnotationQuery, body []byte) ([]GrafanaAnnotation, error) {
+	var echo struct {
+		Annotation any `json:"annotation"`
+	}
+	json.Unmarshal(body, &echo)
+	host, keep := q.Annotation.Query, func(string) bool { return true }
+	if group, ok := strings.CutPrefix(host, "@"); ok {
+		members := groupMembers(group)
+		host, keep = "", func(h string) bool { return slices.Contains(members, h) }
+	}
+	incidents, err := findIncidents(history.store, host, q.Range.From, q.Range.To, 100, keep)
+	if err != nil {
+		return nil, err
+	}
+	out := []GrafanaAnnotation{}
+	for _, inc := range incidents {
+		end := inc.Start.Add(time.Duration(inc.DurationSeconds * float64(time.Second)))
+		text := fmt.Sprintf("down for %s", end.Sub(inc.Start).Round(time.Second))
+		if inc.Ongoing {
+			text += ", ongoing"
+		}
+		out = append(out, GrafanaAnnotation{
+			Annotation: echo.Annotation,
+			Time:       inc.Start.UnixMilli(),
+			TimeEnd:    end.UnixMilli(),
+			IsRegion:   true,
+			Title:      inc.Host + " down",
+			Text:       text,
+			Tags:       []string{"outage", inc.Host},
+		})
+	}
+	return out, nil
+}
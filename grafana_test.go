@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrafanaHandler(t *testing.T) {
+	withHosts(t, "db", "web1", "web2")
+	setDiscoveredGroups("web1", []string{"web"})
+	defer setDiscoveredGroups("web1", nil)
+	defer func(h *resultHistory) { history = h }(history)
+	s := newMemoryStore(10)
+	history = &resultHistory{store: s}
+	at := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	s.write([]HistoryResult{
+		{Time: at, Host: "db", LatencyMs: 10, State: "up"},
+		{Time: at, Host: "web1", LatencyMs: 30, State: "up"},
+		{Time: at.Add(2 * time.Minute), Host: "db", PacketLoss: 100, State: "down"},
+		{Time: at.Add(3 * time.Minute), Host: "db", LatencyMs: 20, State: "up"},
+	})
+	post := func(endpoint, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		grafanaHandler(w, httptest.NewRequest(http.MethodPost, "/api/grafana/"+endpoint, strings.NewReader(body)))
+		return w
+	}
+	rng := fmt.Sprintf(`"range": {"from": %q, "to": %q}`, at.Format(time.RFC3339), at.Add(4*time.Minute).Format(time.RFC3339))
+
+	w := httptest.NewRecorder()
+	grafanaHandler(w, httptest.NewRequest(http.MethodGet, "/api/grafana/", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "connection test")
+
+	var targets []string
+	assert.NoError(t, json.NewDecoder(post("search", `{"target": "latency_ms:"}`).Body).Decode(&targets))
+	assert.Equal(t, []string{"latency_ms:@web", "latency_ms:db", "latency_ms:web1", "latency_ms:web2"}, targets)
+
+	w = post("query", `{`+rng+`, "intervalMs": 60000, "maxDataPoints": 100,
+		"targets": [{"target": "latency_ms:db", "refId": "A"}, {"target": "uptime_percent:@web", "refId": "B"}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var series []struct {
+		Target     string        `json:"target"`
+		Datapoints [][2]*float64 `json:"datapoints"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&series))
+	if assert.Len(t, series, 2) {
+		assert.Equal(t, "latency_ms:db", series[0].Target)
+		if assert.Len(t, series[0].Datapoints, 4, "a point per minute") {
+			assert.Equal(t, 10.0, *series[0].Datapoints[0][0])
+			assert.Equal(t, float64(at.UnixMilli()), *series[0].Datapoints[0][1])
+			assert.Nil(t, series[0].Datapoints[1][0], "no results")
+			assert.Nil(t, series[0].Datapoints[2][0], "down")
+			assert.Equal(t, 20.0, *series[0].Datapoints[3][0])
+		}
+		assert.Equal(t, "uptime_percent:web1", series[1].Target, "a series per member of a group")
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post("query", `{`+rng+`, "targets": [{"target": "rtt:db"}]}`).Code)
+
+	w = post("annotations", `{`+rng+`, "annotation": {"name": "outages", "query": "db", "enable": true}}`)
+	var annotations []GrafanaAnnotation
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&annotations))
+	if assert.Len(t, annotations, 1) {
+		assert.Equal(t, at.Add(2*time.Minute).UnixMilli(), annotations[0].Time)
+		assert.Equal(t, at.Add(3*time.Minute).UnixMilli(), annotations[0].TimeEnd)
+		assert.Equal(t, "db down", annotations[0].Title)
+		assert.Equal(t, map[string]any{"name": "outages", "query": "db", "enable": true}, annotations[0].Annotation, "echoed back")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// graphiteAddr is the carbon server results are sent to in the
+	// plaintext protocol, set with -graphite as host:port, the port
+	// defaulting to 2003. Empty sends none.
+	graphiteAddr string
+	// graphitePrefix is the first part of the metric paths, set with
+	// -graphite-prefix.
+	graphitePrefix = "mosaic"
+)
+
+// graphiteUngrouped is the group part of the paths of hosts without a
+// group.
+const graphiteUngrouped = "ungrouped"
+
+// graphiteUnsafe matches what may not be part of a node of a Graphite
+// path: dots separate nodes, and other characters trip up its functions.
+var graphiteUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// graphiteSink sends the results of every cycle to carbon in the plaintext
+// protocol, over a connection per cycle, as
+//
+//	<prefix>.<group>.<host>.rtt 3 1714557600
+//	<prefix>.<group>.<host>.loss 0 1714557600
+//	<prefix>.<group>.<host>.up 1 1714557600
+type graphiteSink struct {
+	addr   string
+	prefix string
+	dial   func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// newGraphiteSink creates a sink sending to the carbon server at addr,
+// with port 2003 if it has none, under prefix.
+func newGraphiteSink(addr, prefix string) (*graphiteSink, error) {
+	addr = withDefaultPort(addr, "2003")
+	if _, _, err := net.SplitHostPort(addr); err != nil || strings.Contains(addr, "/") {
+		return nil, fmt.Errorf("invalid Graphite address %q: expected host:port", addr)
+	}
+	prefix = strings.Trim(prefix, ".")
+	if prefix == "" {
+		return nil, fmt.Errorf("the Graphite prefix must not be empty")
+	}
+	var d net.Dialer
+	return &graphiteSink{addr: addr, prefix: prefix, dial: d.DialContext}, nil
+}
+
+// Name implements resultSink.
+func (s *graphiteSink) Name() string { return "graphite" }
+
+// Push implements resultSink.
+func (s *graphiteSink) Push(ctx context.Context, now time.Time, statuses []HostStatus) error {
+	conn, err := s.dial(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte(strings.Join(graphiteLines(s.prefix, now, statuses), ""))); err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// graphiteLines returns the lines of statuses, under each group of a host,
+// or graphiteUngrouped. Dots and other unsafe characters in groups and
+// hosts become underscores, so 10.0.0.1 is 10_0_0_1. rtt is left out
+// while a host is down.
+func graphiteLines(prefix string, now time.Time, statuses []HostStatus) []string {
+	ts := " " + strconv.FormatInt(now.Unix(), 10) + "\n"
+	var lines []string
+	for _, st := range statuses {
+		groups := st.Groups
+		if len(groups) == 0 {
+			groups = []string{graphiteUngrouped}
+		}
+		up := "0"
+		if st.Alive {
+			up = "1"
+		}
+		host := graphiteNode(st.Host)
+		for _, g := range groups {
+			path := prefix + "." + graphiteNode(g) + "." + host + "."
+			if st.Alive {
+				lines = append(lines, path+"rtt "+strconv.Itoa(st.LatencyMs)+ts)
+			}
+			lines = append(lines,
+				path+"loss "+strconv.FormatFloat(st.PacketLoss, 'f', -1, 64)+ts,
+				path+"up "+up+ts)
+		}
+	}
+	return lines
+}
+
+// graphiteNode returns s as a node of a Graphite path.
+func graphiteNode(s string) string {
+	if s = graphiteUnsafe.ReplaceAllString(s, "_"); s == "" {
+		return "_"
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphiteLines(t *testing.T) {
+	now := time.Unix(1714557600, 0)
+	lines := graphiteLines("mosaic", now, []HostStatus{
+		{Host: "10.0.0.1", Alive: true, LatencyMs: 3, Groups: []string{"core", "dc 1"}},
+		{Host: "db.example.com", PacketLoss: 100},
+	})
+	assert.Equal(t, []string{
+		"mosaic.core.10_0_0_1.rtt 3 1714557600\n",
+		"mosaic.core.10_0_0_1.loss 0 1714557600\n",
+		"mosaic.core.10_0_0_1.up 1 1714557600\n",
+		"mosaic.dc_1.10_0_0_1.rtt 3 1714557600\n",
+		"mosaic.dc_1.10_0_0_1.loss 0 1714557600\n",
+		"mosaic.dc_1.10_0_0_1.up 1 1714557600\n",
+		"mosaic.ungrouped.db_example_com.loss 100 1714557600\n",
+		"mosaic.ungrouped.db_example_com.up 0 1714557600\n",
+	}, lines)
+}
+
+func TestGraphiteSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		received <- lines
+	}()
+
+	s, err := newGraphiteSink(ln.Addr().String(), "noc.mosaic.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, s.Push(context.Background(), time.Unix(1714557600, 0), []HostStatus{{Host: "sw1", Alive: true, LatencyMs: 7}}))
+	select {
+	case lines := <-received:
+		assert.Equal(t, []string{
+			"noc.mosaic.ungrouped.sw1.rtt 7 1714557600",
+			"noc.mosaic.ungrouped.sw1.loss 0 1714557600",
+			"noc.mosaic.ungrouped.sw1.up 1 1714557600",
+		}, lines)
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+
+	s, err = newGraphiteSink("carbon.example.com", "mosaic")
+	assert.NoError(t, err)
+	assert.Equal(t, "carbon.example.com:2003", s.addr)
+	_, err = newGraphiteSink("tcp://carbon:2003", "mosaic")
+	assert.Error(t, err)
+	_, err = newGraphiteSink("carbon", "..")
+	assert.Error(t, err)
+}
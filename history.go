@@ -0,0 +1,610 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// historyDB is the -history-db value: the database file, or the URL of
+	// a PostgreSQL database, every probe result is stored in, so history
+	// survives a restart. Empty keeps none.
+	historyDB string
+	// historyRetention is how long results are kept as they are, set with
+	// -history-retention. Older ones are averaged by historyTiers.
+	historyRetention = 24 * time.Hour
+	// historyBackend is the storage backend of -history-db, set with
+	// -history-backend.
+	historyBackend = "sqlite"
+	// historyInstance names this mosaic in a history database several
+	// instances share, set with -history-instance. Each instance reads and
+	// prunes only its own results. It defaults to the hostname.
+	historyInstance string
+)
+
+const (
+	// historyQueueSize is how many cycles of results may wait to be
+	// written before new ones are dropped.
+	historyQueueSize = 64
+	// historyPruneEvery is how often results past the retention period are
+	// downsampled or deleted.
+	historyPruneEvery = time.Hour
+	// historyLimit is the most results /api/history returns.
+	historyLimit = 10000
+	// historyPage is how many results historyStore.each reads at a time.
+	historyPage = 1000
+)
+
+// sqliteMigrations are the schema changes of a SQLite history, in order; a
+// database whose user_version is n has the first n applied. Only append to
+// the list.
+var sqliteMigrations = []string{
+	// 1: the results table, which databases from before user_version have
+	`CREATE TABLE IF NOT EXISTS results (
+		time INTEGER NOT NULL, -- Unix milliseconds
+		host TEXT NOT NULL,
+		rtt_ms INTEGER NOT NULL,
+		loss REAL NOT NULL,
+		state TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS results_host_time ON results (host, time);
+	CREATE INDEX IF NOT EXISTS results_time ON results (time)`,
+	// 2: how many results a downsampled one stands for, and how many of
+	// them were up
+	`ALTER TABLE results ADD COLUMN samples INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE results ADD COLUMN up INTEGER NOT NULL DEFAULT 1;
+	UPDATE results SET up = 0 WHERE state = 'down'`,
+}
+
+// HistoryResult is a stored probe result as served by /api/history.
+type HistoryResult struct {
+	Time       time.Time `json:"time"`
+	Host       string    `json:"host"`
+	LatencyMs  int       `json:"latency_ms"`        // Round-trip time, 0 when down
+	PacketLoss float64   `json:"packet_loss"`       // Packet loss percentage (0-100)
+	State      string    `json:"state"`             // "up", "degraded" or "down"
+	Samples    int       `json:"samples,omitempty"` // Results averaged into this one by downsampling, 0 for a single result
+	Up         int       `json:"up,omitempty"`      // Of the samples, those that were not down
+}
+
+// weight returns how many results r stands for and how many of them were
+// not down.
+func (r HistoryResult) weight() (samples, up int) {
+	if r.Samples > 0 {
+		return r.Samples, r.Up
+	}
+	if r.State == "down" {
+		return 1, 0
+	}
+	return 1, 1
+}
+
+// single returns r without a weight if it stands for one result only, as
+// results stored as they are do.
+func (r HistoryResult) single() HistoryResult {
+	if r.Samples == 1 {
+		r.Samples, r.Up = 0, 0
+	}
+	return r
+}
+
+// HistorySummary sums up the stored results of a host as served by
+// /api/history?summary=1.
+type HistorySummary struct {
+	Host          string    `json:"host,omitempty"`
+	Since         time.Time `json:"since"`
+	Results       int       `json:"results"`        // Stored results in the period
+	UptimePercent float64   `json:"uptime_percent"` // Share of results that were not down (0-100)
+	AvgLatencyMs  float64   `json:"avg_latency_ms"` // Average round-trip time of the results that were not down
+}
+
+// historyStore is a storage backend of the result history.
+type historyStore interface {
+	// write stores a batch of results at once.
+	write(batch []HistoryResult) error
+	// prune deletes the results from before t and returns how many.
+	prune(t time.Time) (int64, error)
+	// query returns the results in [since, until) of host, or of all hosts
+	// if empty, oldest first. Of more than limit results, the newest are
+	// returned.
+	query(host string, since, until time.Time, limit int) ([]HistoryResult, error)
+	// summarize sums up the results in [since, until) of host, or of all
+	// hosts if empty.
+	summarize(host string, since, until time.Time) (HistorySummary, error)
+	// buckets sums up the results in [since, until) of host, or of all
+	// hosts if empty, in buckets of step from since. Only buckets with
+	// results are returned, by host and time.
+	buckets(host string, since, until time.Time, step time.Duration) ([]HistoryBucket, error)
+	// each calls fn with the results in [since, until) of host, or of all
+	// hosts if empty, by host and time. The results are read a page at a
+	// time, and fn is not called while the store is locked, so a slow fn
+	// does not hold up writes.
+	each(host string, since, until time.Time, fn func(HistoryResult) error) error
+	// replace deletes the results in [since, until) of all hosts and
+	// stores batch instead, at once, returning how many were deleted.
+	replace(since, until time.Time, batch []HistoryResult) (int64, error)
+	// managesRetention reports whether the store bounds its results by
+	// itself, so resultHistory only prunes it past the last tier.
+	managesRetention() bool
+	// close closes the storage.
+	close() error
+}
+
+// historyBackends opens the storage of a -history-backend at the
+// -history-db path. Backends with dependencies the embedded build leaves
+// out register themselves from files built without it.
+var historyBackends = map[string]func(path string) (historyStore, error){
+	"sqlite": openSQLiteStore,
+	"rrd":    openRRDStore,
+}
+
+// resultHistory writes probe results to a historyStore. Results are queued
+// and written by a single worker in one batch per cycle, so a slow disk
+// never holds up the ping loop.
+type resultHistory struct {
+	store     historyStore
+	retention time.Duration
+	tiers     historyTiers // Downsampling past retention, nil to delete results then
+	compacted []time.Time  // Per tier, up to when results are downsampled
+	mu        sync.Mutex
+	queue     chan []HistoryResult
+	closed    bool          // Set by close; results are no longer queued
+	done      chan struct{} // Closed when the worker has returned
+}
+
+// history is the open -history-db, or the recent results kept in memory
+// without one. It is nil in the embedded build.
+var history *resultHistory
+
+// openHistory opens or creates the history at path with backend and starts
+// writing results to it, downsampling those older than retention by tiers.
+//
+// Parameters:
+//   - backend: A key of historyBackends, e.g. "sqlite"
+//   - path: The database file, or the URL of a PostgreSQL database
+//   - retention: How long results are kept as they are
+//   - tiers: The averages older results are kept as, nil to delete them
+//
+// Returns:
+//   - *resultHistory: The open history
+//   - error: If the backend is unknown or the database cannot be opened
+func openHistory(backend, path string, retention time.Duration, tiers historyTiers) (*resultHistory, error) {
+	if embeddedBuild {
+		return nil, errors.New("not supported in the embedded build")
+	}
+	open, ok := historyBackends[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown history backend %q", backend)
+	}
+	store, err := open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return newResultHistory(store, retention, tiers), nil
+}
+
+// newResultHistory starts writing results to store, downsampling those
+// older than retention by tiers, or deleting them without.
+func newResultHistory(store historyStore, retention time.Duration, tiers historyTiers) *resultHistory {
+	h := &resultHistory{
+		store:     store,
+		retention: retention,
+		tiers:     tiers,
+		compacted: make([]time.Time, len(tiers)),
+		queue:     make(chan []HistoryResult, historyQueueSize),
+		done:      make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+// sqliteStore keeps the history in a SQLite database.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// openSQLiteStore opens or creates the SQLite history at path.
+func openSQLiteStore(path string) (historyStore, error) {
+	if historyDriver == "" {
+		return nil, errors.New("SQLite is not included in this build")
+	}
+	db, err := sql.Open(historyDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; a single connection also keeps the pragmas
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000"} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := migrateSQLite(db, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// migrateSQLite applies the migrations a database does not have yet in one
+// transaction and records its new version as its user_version.
+//
+// Returns:
+//   - error: If a migration fails, which leaves the schema as it was, or
+//     the database is newer than this binary
+func migrateSQLite(db *sql.DB, migrations []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than this mosaic supports (%d)", version, len(migrations))
+	}
+	if version == len(migrations) {
+		return nil
+	}
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// resultState returns the state a result is stored with.
+func resultState(st HostStatus) string {
+	switch {
+	case !st.Alive:
+		return "down"
+	case st.Degraded:
+		return "degraded"
+	default:
+		return "up"
+	}
+}
+
+// record queues the results of a cycle for writing. When the queue is
+// full, the results are dropped and logged.
+func (h *resultHistory) record(statuses []HostStatus, now time.Time) {
+	if len(statuses) == 0 {
+		return
+	}
+	batch := make([]HistoryResult, len(statuses))
+	for i, st := range statuses {
+		batch[i] = HistoryResult{Time: now, Host: st.Host, LatencyMs: st.LatencyMs, PacketLoss: st.PacketLoss, State: resultState(st)}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.queue <- batch:
+	default:
+		log.Printf("History: queue full, dropped %d results", len(batch))
+	}
+}
+
+// run writes queued results until the queue is closed, downsampling and
+// pruning old ones on start and every historyPruneEvery. Running both in
+// the one worker keeps them from racing with writes.
+func (h *resultHistory) run() {
+	defer close(h.done)
+	h.compact(time.Now())
+	ticker := time.NewTicker(historyPruneEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch, ok := <-h.queue:
+			if !ok {
+				return
+			}
+			if err := h.store.write(batch); err != nil {
+				log.Printf("History: %v", err)
+			}
+		case <-ticker.C:
+			h.compact(time.Now())
+		}
+	}
+}
+
+// write implements historyStore in one transaction.
+func (s *sqliteStore) write(batch []HistoryResult) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := sqliteInsert(tx, batch); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqliteInsert inserts batch in tx.
+func sqliteInsert(tx *sql.Tx, batch []HistoryResult) error {
+	stmt, err := tx.Prepare("INSERT INTO results (time, host, rtt_ms, loss, state, samples, up) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range batch {
+		samples, up := r.weight()
+		if _, err := stmt.Exec(r.Time.UnixMilli(), r.Host, r.LatencyMs, r.PacketLoss, r.State, samples, up); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace implements historyStore in one transaction.
+func (s *sqliteStore) replace(since, until time.Time, batch []HistoryResult) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM results WHERE time >= ? AND time < ?", since.UnixMilli(), until.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := sqliteInsert(tx, batch); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// prune implements historyStore.
+func (s *sqliteStore) prune(t time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM results WHERE time < ?", t.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// query implements historyStore.
+func (s *sqliteStore) query(host string, since, until time.Time, limit int) ([]HistoryResult, error) {
+	q := "SELECT time, host, rtt_ms, loss, state, samples, up FROM results WHERE time >= ? AND time < ?"
+	args := []any{since.UnixMilli(), until.UnixMilli()}
+	if host != "" {
+		q += " AND host = ?"
+		args = append(args, host)
+	}
+	q += " ORDER BY time DESC, rowid DESC LIMIT ?"
+	args = append(args, limit)
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []HistoryResult{}
+	for rows.Next() {
+		var r HistoryResult
+		var ms int64
+		if err := rows.Scan(&ms, &r.Host, &r.LatencyMs, &r.PacketLoss, &r.State, &r.Samples, &r.Up); err != nil {
+			return nil, err
+		}
+		r.Time = time.UnixMilli(ms)
+		results = append(results, r.single())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.Reverse(results)
+	return results, nil
+}
+
+// each implements historyStore, releasing the connection between pages.
+func (s *sqliteStore) each(host string, since, until time.Time, fn func(HistoryResult) error) error {
+	q := `SELECT rowid, time, host, rtt_ms, loss, state, samples, up FROM results
+		WHERE time >= ? AND time < ? AND (host, time, rowid) > (?, ?, ?)`
+	if host != "" {
+		q += " AND host = ?"
+	}
+	q += " ORDER BY host, time, rowid LIMIT ?"
+	var lastHost string
+	var lastTime, lastRow int64
+	for {
+		args := []any{since.UnixMilli(), until.UnixMilli(), lastHost, lastTime, lastRow}
+		if host != "" {
+			args = append(args, host)
+		}
+		rows, err := s.db.Query(q, append(args, historyPage)...)
+		if err != nil {
+			return err
+		}
+		page := make([]HistoryResult, 0, historyPage)
+		for rows.Next() {
+			var r HistoryResult
+			if err := rows.Scan(&lastRow, &lastTime, &r.Host, &r.LatencyMs, &r.PacketLoss, &r.State, &r.Samples, &r.Up); err != nil {
+				rows.Close()
+				return err
+			}
+			r.Time, lastHost = time.UnixMilli(lastTime), r.Host
+			page = append(page, r.single())
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, r := range page {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		if len(page) < historyPage {
+			return nil
+		}
+	}
+}
+
+// summarize implements historyStore.
+func (s *sqliteStore) summarize(host string, since, until time.Time) (HistorySummary, error) {
+	q := "SELECT COALESCE(SUM(samples), 0), COALESCE(SUM(up), 0), COALESCE(1.0 * SUM(rtt_ms * up) / NULLIF(SUM(up), 0), 0) FROM results WHERE time >= ? AND time < ?"
+	args := []any{since.UnixMilli(), until.UnixMilli()}
+	if host != "" {
+		q += " AND host = ?"
+		args = append(args, host)
+	}
+	sum := HistorySummary{Host: host, Since: since}
+	var up int
+	if err := s.db.QueryRow(q, args...).Scan(&sum.Results, &up, &sum.AvgLatencyMs); err != nil {
+		return sum, err
+	}
+	if sum.Results > 0 {
+		sum.UptimePercent = 100 * float64(up) / float64(sum.Results)
+	}
+	return sum, nil
+}
+
+// buckets implements historyStore.
+func (s *sqliteStore) buckets(host string, since, until time.Time, step time.Duration) ([]HistoryBucket, error) {
+	q := `SELECT host, (time - ?) / ? AS n, COUNT(*), SUM(samples), SUM(up),
+		COALESCE(1.0 * SUM(rtt_ms * up) / NULLIF(SUM(up), 0), 0), SUM(loss * samples) / SUM(samples)
+		FROM results WHERE time >= ? AND time < ?`
+	args := []any{since.UnixMilli(), step.Milliseconds(), since.UnixMilli(), until.UnixMilli()}
+	if host != "" {
+		q += " AND host = ?"
+		args = append(args, host)
+	}
+	rows, err := s.db.Query(q+" GROUP BY host, n ORDER BY host, n", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	buckets := []HistoryBucket{}
+	for rows.Next() {
+		var b HistoryBucket
+		var n int64
+		if err := rows.Scan(&b.Host, &n, &b.Rows, &b.Results, &b.Up, &b.LatencyMs, &b.PacketLoss); err != nil {
+			return nil, err
+		}
+		b.Start = since.Add(time.Duration(n) * step)
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// managesRetention implements historyStore.
+func (s *sqliteStore) managesRetention() bool { return false }
+
+// close implements historyStore.
+func (s *sqliteStore) close() error {
+	return s.db.Close()
+}
+
+// close writes the results still queued and closes the database, giving up
+// on the queue when ctx is done.
+func (h *resultHistory) close(ctx context.Context) error {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+	h.mu.Unlock()
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return h.store.close()
+}
+
+// parseHistoryTime parses a time parameter of /api/history: an RFC 3339
+// time, or a duration such as "24h" meaning that long ago.
+func parseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// historyHandler serves stored probe results as JSON, oldest first. The
+// query parameters host, since and until narrow them down; since defaults
+// to an hour ago, or minutes ago with minutes, and until to now. limit
+// caps the results at the newest ones, historyLimit at most. With
+// summary=1, the uptime and average latency of the results are served
+// instead, see HistorySummary. With resolution, a duration, or group, the
+// results of host, the hosts of group or all hosts are averaged into
+// aligned buckets, see HistorySeries.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "no history is kept", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	since, until := now.Add(-time.Hour), now
+	q := r.URL.Query()
+	var err error
+	if s := q.Get("minutes"); s != "" {
+		m, err := strconv.Atoi(s)
+		if err != nil || m <= 0 {
+			http.Error(w, "invalid minutes", http.StatusBadRequest)
+			return
+		}
+		since = now.Add(-time.Duration(m) * time.Minute)
+	}
+	if s := q.Get("since"); s != "" {
+		if since, err = parseHistoryTime(s, now); err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("until"); s != "" {
+		if until, err = parseHistoryTime(s, now); err != nil {
+			http.Error(w, "invalid until", http.StatusBadRequest)
+			return
+		}
+	}
+	if q.Get("summary") == "1" {
+		summary, err := history.store.summarize(q.Get("host"), since, until)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+		return
+	}
+	if q.Has("resolution") || q.Has("group") {
+		historySeriesHandler(w, q, since, until)
+		return
+	}
+	limit := historyLimit
+	if s := q.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > historyLimit {
+			http.Error(w, fmt.Sprintf("limit must be 1 to %d", historyLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := history.store.query(q.Get("host"), since, until, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,285 @@
+//go:build !embedded
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func init() {
+	historyBackends["bolt"] = openBoltStore
+}
+
+// boltStore keeps the history in a bbolt key-value file, a pure-Go
+// alternative to SQLite. Each host has a bucket whose keys are the
+// big-endian Unix milliseconds of a result followed by a sequence number,
+// so keys sort by time and a time range is a cursor walk.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// boltRecord is a result as stored in a host bucket.
+type boltRecord struct {
+	LatencyMs  int     `json:"r"`
+	PacketLoss float64 `json:"l"`
+	State      string  `json:"s"`
+	Samples    int     `json:"n,omitempty"` // See HistoryResult
+	Up         int     `json:"u,omitempty"`
+}
+
+// openBoltStore opens or creates the bbolt history at path.
+func openBoltStore(path string) (historyStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// boltKey returns the key of a result at t with sequence number seq. A
+// seq of 0 gives the first possible key at t.
+func boltKey(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixMilli()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+// boltTime returns the time of a key.
+func boltTime(k []byte) time.Time {
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(k)))
+}
+
+// write implements historyStore in one transaction.
+func (s *boltStore) write(batch []HistoryResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error { return boltInsert(tx, batch) })
+}
+
+// boltInsert stores batch in tx.
+func boltInsert(tx *bolt.Tx, batch []HistoryResult) error {
+	for _, r := range batch {
+		b, err := tx.CreateBucketIfNotExists([]byte(r.Host))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		r = r.single()
+		v, err := json.Marshal(boltRecord{LatencyMs: r.LatencyMs, PacketLoss: r.PacketLoss, State: r.State, Samples: r.Samples, Up: r.Up})
+		if err != nil {
+			return err
+		}
+		if err := b.Put(boltKey(r.Time, seq), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace implements historyStore in one transaction.
+func (s *boltStore) replace(since, until time.Time, batch []HistoryResult) (int64, error) {
+	var n int64
+	start, end := boltKey(since, 0), boltKey(until, 0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.ForEach(func(_ []byte, b *bolt.Bucket) error {
+			c := b.Cursor()
+			// Seek again after each delete; Next may skip a key after one
+			for k, _ := c.Seek(start); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Seek(start) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return boltInsert(tx, batch)
+	})
+	return n, err
+}
+
+// prune implements historyStore. Buckets left empty are deleted.
+func (s *boltStore) prune(t time.Time) (int64, error) {
+	var n int64
+	end := boltKey(t, 0)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var empty [][]byte
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				n++
+			}
+			if k, _ := c.First(); k == nil {
+				empty = append(empty, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range empty {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// scan calls fn with the results in [since, until) of host, or of all
+// hosts if empty, host by host in time order.
+func (s *boltStore) scan(host string, since, until time.Time, fn func(HistoryResult) error) error {
+	start, end := boltKey(since, 0), boltKey(until, 0)
+	return s.db.View(func(tx *bolt.Tx) error {
+		walk := func(name []byte, b *bolt.Bucket) error {
+			c := b.Cursor()
+			for k, v := c.Seek(start); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+				var rec boltRecord
+				if err := json.Unmarshal(v, &rec); err != nil {
+					return err
+				}
+				err := fn(HistoryResult{Time: boltTime(k), Host: string(name), LatencyMs: rec.LatencyMs, PacketLoss: rec.PacketLoss, State: rec.State, Samples: rec.Samples, Up: rec.Up})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if host == "" {
+			return tx.ForEach(walk)
+		}
+		if b := tx.Bucket([]byte(host)); b != nil {
+			return walk([]byte(host), b)
+		}
+		return nil
+	})
+}
+
+// each implements historyStore, a page per read transaction; an open one
+// would keep writes from growing the file.
+func (s *boltStore) each(host string, since, until time.Time, fn func(HistoryResult) error) error {
+	hosts := []string{host}
+	if host == "" {
+		hosts = nil
+		err := s.db.View(func(tx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				hosts = append(hosts, string(name))
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	end := boltKey(until, 0)
+	for _, h := range hosts {
+		start := boltKey(since, 0)
+		for start != nil {
+			var page []HistoryResult
+			err := s.db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte(h))
+				if b == nil {
+					start = nil
+					return nil
+				}
+				c := b.Cursor()
+				k, v := c.Seek(start)
+				for ; k != nil && bytes.Compare(k, end) < 0 && len(page) < historyPage; k, v = c.Next() {
+					var rec boltRecord
+					if err := json.Unmarshal(v, &rec); err != nil {
+						return err
+					}
+					page = append(page, HistoryResult{Time: boltTime(k), Host: h, LatencyMs: rec.LatencyMs, PacketLoss: rec.PacketLoss, State: rec.State, Samples: rec.Samples, Up: rec.Up})
+				}
+				if k == nil || bytes.Compare(k, end) >= 0 {
+					start = nil
+				} else {
+					start = slices.Clone(k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, r := range page {
+				if err := fn(r); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// query implements historyStore.
+func (s *boltStore) query(host string, since, until time.Time, limit int) ([]HistoryResult, error) {
+	results := []HistoryResult{}
+	err := s.scan(host, since, until, func(r HistoryResult) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Hosts are walked one after another; order by time across them
+	slices.SortStableFunc(results, func(a, b HistoryResult) int { return a.Time.Compare(b.Time) })
+	if len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+	return results, nil
+}
+
+// summarize implements historyStore.
+func (s *boltStore) summarize(host string, since, until time.Time) (HistorySummary, error) {
+	sum := HistorySummary{Host: host, Since: since}
+	up, total := 0, 0
+	err := s.scan(host, since, until, func(r HistoryResult) error {
+		samples, u := r.weight()
+		sum.Results += samples
+		up += u
+		total += r.LatencyMs * u
+		return nil
+	})
+	if sum.Results > 0 {
+		sum.UptimePercent = 100 * float64(up) / float64(sum.Results)
+	}
+	if up > 0 {
+		sum.AvgLatencyMs = float64(total) / float64(up)
+	}
+	return sum, err
+}
+
+// buckets implements historyStore.
+func (s *boltStore) buckets(host string, since, until time.Time, step time.Duration) ([]HistoryBucket, error) {
+	b := newBucketer(since, step)
+	err := s.scan(host, since, until, func(r HistoryResult) error {
+		b.add(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.result(), nil
+}
+
+// managesRetention implements historyStore.
+func (s *boltStore) managesRetention() bool { return false }
+
+// close implements historyStore.
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
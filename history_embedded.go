@@ -0,0 +1,19 @@
+//go:build embedded
+
+package main
+
+import "fmt"
+
+// historyDriver is the database/sql driver of -history-db. The embedded
+// build leaves SQLite out to stay small, so it keeps no history.
+const historyDriver = ""
+
+// Backends whose dependencies the embedded build leaves out are known, but
+// cannot be opened.
+func init() {
+	for _, backend := range []string{"bolt", "postgres"} {
+		historyBackends[backend] = func(string) (historyStore, error) {
+			return nil, fmt.Errorf("the %s history backend is not available in this build", backend)
+		}
+	}
+}
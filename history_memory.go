@@ -0,0 +1,184 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historySize is how many recent results per host are kept in memory
+// without -history-db, set with -history-size. The default covers half an
+// hour at the default interval.
+var historySize = 900
+
+// historyStates are the states of results, indexed by ringEntry.state.
+var historyStates = []string{"up", "degraded", "down"}
+
+// ringEntry is a result in a memoryStore, kept small since there are
+// historySize of them per host.
+type ringEntry struct {
+	ms    int64 // Unix milliseconds
+	rtt   int32
+	loss  float32
+	state uint8 // Index into historyStates
+}
+
+// resultRing holds the most recent results of a host, overwriting the
+// oldest once full.
+type resultRing struct {
+	entries []ringEntry
+	next    int // Where the next result goes
+}
+
+// add stores e, overwriting the oldest result once the ring holds size.
+func (r *resultRing) add(e ringEntry, size int) {
+	if len(r.entries) < size {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+}
+
+// ordered returns the results oldest first.
+func (r *resultRing) ordered() []ringEntry {
+	return append(slices.Clone(r.entries[r.next:]), r.entries[:r.next]...)
+}
+
+// memoryStore keeps the last historySize results of every host in memory,
+// so recent history is available without a database. It is lost on
+// restart.
+type memoryStore struct {
+	mu    sync.Mutex
+	size  int
+	hosts map[string]*resultRing
+}
+
+// newMemoryStore creates a memory store keeping size results per host.
+func newMemoryStore(size int) *memoryStore {
+	return &memoryStore{size: size, hosts: make(map[string]*resultRing)}
+}
+
+// write implements historyStore.
+func (s *memoryStore) write(batch []HistoryResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range batch {
+		ring := s.hosts[r.Host]
+		if ring == nil {
+			ring = &resultRing{}
+			s.hosts[r.Host] = ring
+		}
+		state := slices.Index(historyStates, r.State)
+		ring.add(ringEntry{ms: r.Time.UnixMilli(), rtt: int32(r.LatencyMs), loss: float32(r.PacketLoss), state: uint8(state)}, s.size)
+	}
+	return nil
+}
+
+// prune implements historyStore. Hosts left without results, such as
+// removed ones, are forgotten.
+func (s *memoryStore) prune(t time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for host, ring := range s.hosts {
+		kept := slices.DeleteFunc(ring.ordered(), func(e ringEntry) bool { return e.ms < t.UnixMilli() })
+		if len(kept) == len(ring.entries) {
+			continue
+		}
+		n += int64(len(ring.entries) - len(kept))
+		if len(kept) == 0 {
+			delete(s.hosts, host)
+			continue
+		}
+		ring.entries, ring.next = kept, 0
+	}
+	return n, nil
+}
+
+// scan returns the results in [since, until) of host, or of all hosts if
+// empty, oldest first.
+func (s *memoryStore) scan(host string, since, until time.Time) []HistoryResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := []HistoryResult{}
+	for h, ring := range s.hosts {
+		if host != "" && h != host {
+			continue
+		}
+		for _, e := range ring.ordered() {
+			if e.ms >= since.UnixMilli() && e.ms < until.UnixMilli() {
+				results = append(results, HistoryResult{Time: time.UnixMilli(e.ms), Host: h, LatencyMs: int(e.rtt), PacketLoss: float64(e.loss), State: historyStates[e.state]})
+			}
+		}
+	}
+	slices.SortFunc(results, func(a, b HistoryResult) int {
+		return cmp.Or(a.Time.Compare(b.Time), strings.Compare(a.Host, b.Host))
+	})
+	return results
+}
+
+// query implements historyStore.
+func (s *memoryStore) query(host string, since, until time.Time, limit int) ([]HistoryResult, error) {
+	results := s.scan(host, since, until)
+	if len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+	return results, nil
+}
+
+// each implements historyStore.
+func (s *memoryStore) each(host string, since, until time.Time, fn func(HistoryResult) error) error {
+	results := s.scan(host, since, until)
+	slices.SortStableFunc(results, func(a, b HistoryResult) int { return strings.Compare(a.Host, b.Host) })
+	for _, r := range results {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// summarize implements historyStore.
+func (s *memoryStore) summarize(host string, since, until time.Time) (HistorySummary, error) {
+	sum := HistorySummary{Host: host, Since: since}
+	up, total := 0, 0
+	for _, r := range s.scan(host, since, until) {
+		sum.Results++
+		if r.State != "down" {
+			up++
+			total += r.LatencyMs
+		}
+	}
+	if sum.Results > 0 {
+		sum.UptimePercent = 100 * float64(up) / float64(sum.Results)
+	}
+	if up > 0 {
+		sum.AvgLatencyMs = float64(total) / float64(up)
+	}
+	return sum, nil
+}
+
+// buckets implements historyStore.
+func (s *memoryStore) buckets(host string, since, until time.Time, step time.Duration) ([]HistoryBucket, error) {
+	b := newBucketer(since, step)
+	for _, r := range s.scan(host, since, until) {
+		b.add(r)
+	}
+	return b.result(), nil
+}
+
+// replace implements historyStore. The memory history is bounded by
+// historySize and never downsampled.
+func (s *memoryStore) replace(since, until time.Time, batch []HistoryResult) (int64, error) {
+	return 0, errors.New("the memory history is not downsampled")
+}
+
+// close implements historyStore.
+func (s *memoryStore) close() error { return nil }
+
+// managesRetention implements historyStore.
+func (s *memoryStore) managesRetention() bool { return false }
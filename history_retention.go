@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// historyTier keeps the results older than the tier before it as averages
+// over Step, for Keep.
+type historyTier struct {
+	Step time.Duration
+	Keep time.Duration
+}
+
+// historyTiers are the downsampling tiers of a history, finest first.
+type historyTiers []historyTier
+
+// historyDownsample is the -history-downsample value: results past
+// -history-retention are kept as 1-minute averages for 30 days, then as
+// hourly averages for a year.
+var historyDownsample = historyTiers{
+	{Step: time.Minute, Keep: 30 * 24 * time.Hour},
+	{Step: time.Hour, Keep: 365 * 24 * time.Hour},
+}
+
+// shortDuration formats d without trailing zero units, e.g. "1m" or
+// "720h".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// String implements flag.Value.
+func (t *historyTiers) String() string {
+	var parts []string
+	for _, tier := range *t {
+		parts = append(parts, shortDuration(tier.Step)+"="+shortDuration(tier.Keep))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set implements flag.Value, parsing comma-separated step=keep pairs such
+// as "1m=720h,1h=8760h". An empty value turns downsampling off.
+func (t *historyTiers) Set(s string) error {
+	tiers := historyTiers{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		step, keep, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("invalid tier %q: expected step=keep, e.g. 1m=720h", part)
+		}
+		var tier historyTier
+		var err error
+		if tier.Step, err = time.ParseDuration(step); err != nil {
+			return fmt.Errorf("invalid tier %q: %v", part, err)
+		}
+		if tier.Keep, err = time.ParseDuration(keep); err != nil {
+			return fmt.Errorf("invalid tier %q: %v", part, err)
+		}
+		tiers = append(tiers, tier)
+	}
+	*t = tiers
+	return nil
+}
+
+// validate checks that each tier averages over a multiple of the step of
+// the one before, at least a second, and keeps results longer than the
+// one before, the first longer than retention.
+func (t historyTiers) validate(retention time.Duration) error {
+	step, keep := time.Duration(0), retention
+	for _, tier := range t {
+		switch {
+		case tier.Step < time.Second:
+			return fmt.Errorf("step %s is shorter than 1s", tier.Step)
+		case step > 0 && (tier.Step <= step || tier.Step%step != 0):
+			return fmt.Errorf("step %s is not a multiple of the step %s before it", tier.Step, step)
+		case tier.Keep <= keep:
+			return fmt.Errorf("%s=%s keeps results no longer than the tier before it (%s)", tier.Step, tier.Keep, keep)
+		}
+		step, keep = tier.Step, tier.Keep
+	}
+	return nil
+}
+
+// bucketResult returns the result standing for the results of b: their
+// averages, down if all of them were, degraded if some were and up
+// otherwise.
+func bucketResult(b HistoryBucket) HistoryResult {
+	r := HistoryResult{
+		Time:       b.Start,
+		Host:       b.Host,
+		LatencyMs:  int(math.Round(b.LatencyMs)),
+		PacketLoss: b.PacketLoss,
+		State:      "up",
+		Samples:    b.Results,
+		Up:         b.Up,
+	}
+	switch {
+	case b.Up == 0:
+		r.State = "down"
+	case b.Up < b.Results:
+		r.State = "degraded"
+	}
+	return r
+}
+
+// downsample replaces the results in [since, until) with their averages
+// per host over step. since and until are multiples of step. Buckets
+// before the first one holding more than one result were downsampled
+// before and are left as they are.
+//
+// Returns:
+//   - int64: How many results were replaced
+//   - int: How many averages replaced them
+//   - error: If the store fails
+func downsample(store historyStore, since, until time.Time, step time.Duration) (int64, int, error) {
+	buckets, err := store.buckets("", since, until, step)
+	if err != nil {
+		return 0, 0, err
+	}
+	from := until
+	for _, b := range buckets {
+		if b.Rows > 1 && b.Start.Before(from) {
+			from = b.Start
+		}
+	}
+	if !from.Before(until) {
+		return 0, 0, nil
+	}
+	var batch []HistoryResult
+	for _, b := range buckets {
+		if !b.Start.Before(from) {
+			batch = append(batch, bucketResult(b))
+		}
+	}
+	n, err := store.replace(from, until, batch)
+	return n, len(batch), err
+}
+
+// compact downsamples the results past the retention period by the tiers
+// and deletes those past the last one. Each tier continues where it left
+// off; after a restart, it starts over from its oldest results. Stores that
+// manage their retention are only pruned past the last tier, which clears
+// out the results of hosts no longer monitored.
+func (h *resultHistory) compact(now time.Time) {
+	keep := h.retention
+	for i, tier := range h.tiers {
+		until := now.Add(-keep).Truncate(tier.Step)
+		since := now.Add(-tier.Keep).Truncate(tier.Step)
+		if h.compacted[i].After(since) {
+			since = h.compacted[i]
+		}
+		keep = tier.Keep
+		if h.store.managesRetention() || !since.Before(until) {
+			continue
+		}
+		n, averages, err := downsample(h.store, since, until, tier.Step)
+		if err != nil {
+			log.Printf("History: downsampling to %s: %v", shortDuration(tier.Step), err)
+			continue
+		}
+		h.compacted[i] = until
+		if n > 0 {
+			log.Printf("History: averaged %d results into %d of %s", n, averages, shortDuration(tier.Step))
+		}
+	}
+	if n, err := h.store.prune(now.Add(-keep)); err != nil {
+		log.Printf("History: %v", err)
+	} else if n > 0 {
+		log.Printf("History: deleted %d results older than %s", n, keep)
+	}
+}
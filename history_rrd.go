@@ -0,0 +1,600 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rrdMagic starts every file of the rrd history.
+const rrdMagic = "MOSRRD01"
+
+// rrdSlotSize is the size in bytes of a slot of an rrd archive.
+const rrdSlotSize = 32
+
+// rrdMaxName is the longest file name of an rrd file. The files of hosts
+// whose escaped name would be longer are named after a hash instead,
+// starting with "@", which escaped names never contain.
+const rrdMaxName = 255
+
+// rrdArchive is a round-robin archive of an rrd file: rows slots, each
+// averaging the results of one step, the oldest overwritten as time goes
+// on.
+type rrdArchive struct {
+	step time.Duration
+	rows int
+}
+
+// rrdSlot is a slot of an rrd archive, stored little-endian in that order.
+type rrdSlot struct {
+	ms       int64   // Unix milliseconds of the latest result, 0 if empty
+	samples  uint32  // Results averaged into the slot
+	up       uint32  // Of them, those that were not down
+	degraded uint32  // Of them, those that were degraded
+	rtt      float32 // Average round-trip time of those that were up
+	loss     float32 // Average packet loss percentage
+}
+
+// rrdFile is the open file of a host in the rrd history, with the layout
+// it was created with. The file starts with a 16-byte header: the magic,
+// the number of archives and the length of the host name, followed by the
+// step and rows of each archive, the host name and the archives.
+type rrdFile struct {
+	f        *os.File
+	name     string // Host the file belongs to, empty in early files
+	archives []rrdArchive
+	offsets  []int64 // Where each archive starts
+}
+
+// rrdStore keeps the history in a pre-allocated file per host, like a
+// round-robin database: each file has a fixed-size archive of results for
+// the retention period and one of averages for each downsampling tier,
+// so disk usage is known up front and never grows.
+type rrdStore struct {
+	dir    string
+	layout []rrdArchive // Of newly created files
+	mu     sync.Mutex
+	files  map[string]*rrdFile
+}
+
+// rrdLayout returns the archives of an rrd file for results every
+// interval kept for retention, and averages kept by tiers.
+func rrdLayout(interval, retention time.Duration, tiers historyTiers) []rrdArchive {
+	step := max(time.Second, interval.Round(time.Second))
+	layout := []rrdArchive{{step: step, rows: int((retention + step - 1) / step)}}
+	for _, t := range tiers {
+		layout = append(layout, rrdArchive{step: t.Step, rows: int((t.Keep + t.Step - 1) / t.Step)})
+	}
+	return layout
+}
+
+// rrdFileSize returns the size in bytes of the rrd file of host with
+// archives.
+func rrdFileSize(host string, archives []rrdArchive) int64 {
+	size := int64(16 + 16*len(archives) + len(host))
+	for _, a := range archives {
+		size += int64(a.rows) * rrdSlotSize
+	}
+	return size
+}
+
+// openRRDStore opens or creates the rrd history in the directory path,
+// laying out new files for -interval, -history-retention and
+// -history-downsample.
+func openRRDStore(path string) (historyStore, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return &rrdStore{dir: path, layout: rrdLayout(pingInterval, historyRetention, historyDownsample), files: make(map[string]*rrdFile)}, nil
+}
+
+// path returns the file of host: its escaped name, or a hash of it if
+// that is too long for a file name.
+func (s *rrdStore) path(host string) string {
+	name := url.QueryEscape(host)
+	if len(name)+len(".rrd") > rrdMaxName {
+		sum := sha256.Sum256([]byte(host))
+		name = "@" + hex.EncodeToString(sum[:16])
+	}
+	return filepath.Join(s.dir, name+".rrd")
+}
+
+// file returns the open file of host, opening it, or creating it if
+// create is set. It returns nil if the host has no file. s.mu is held.
+func (s *rrdStore) file(host string, create bool) (*rrdFile, error) {
+	if f, ok := s.files[host]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(s.path(host), os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return nil, nil
+		}
+		return s.create(host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rf, err := readRRDHeader(f)
+	if err == nil && rf.name != "" && rf.name != host {
+		err = fmt.Errorf("holds the history of %s", rf.name)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", s.path(host), err)
+	}
+	s.files[host] = rf
+	return rf, nil
+}
+
+// create creates the file of host, allocating all of its space. s.mu is
+// held.
+func (s *rrdStore) create(host string) (*rrdFile, error) {
+	f, err := os.OpenFile(s.path(host), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 16+16*len(s.layout), 16+16*len(s.layout)+len(host))
+	copy(header, rrdMagic)
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(s.layout)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(host)))
+	for i, a := range s.layout {
+		binary.LittleEndian.PutUint64(header[16+16*i:], uint64(a.step.Milliseconds()))
+		binary.LittleEndian.PutUint64(header[24+16*i:], uint64(a.rows))
+	}
+	header = append(header, host...)
+	size := rrdFileSize(host, s.layout)
+	// Truncate leaves a sparse file; writing the zeros reserves the space
+	err = f.Truncate(size)
+	if err == nil {
+		_, err = f.WriteAt(header, 0)
+	}
+	if err == nil {
+		err = fillZeros(f, int64(len(header)), size)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(s.path(host))
+		return nil, err
+	}
+	rf := newRRDFile(f, host, slices.Clone(s.layout))
+	s.files[host] = rf
+	return rf, nil
+}
+
+// fillZeros writes zeros to f from start to end.
+func fillZeros(f *os.File, start, end int64) error {
+	zeros := make([]byte, 64<<10)
+	for off := start; off < end; off += int64(len(zeros)) {
+		n := min(int64(len(zeros)), end-off)
+		if _, err := f.WriteAt(zeros[:n], off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRRDHeader reads the layout of an rrd file.
+func readRRDHeader(f *os.File) (*rrdFile, error) {
+	head := make([]byte, 16)
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	if string(head[:8]) != rrdMagic {
+		return nil, errors.New("not a mosaic rrd file")
+	}
+	n := int(binary.LittleEndian.Uint32(head[8:]))
+	if n < 1 || n > 64 {
+		return nil, fmt.Errorf("invalid archive count %d", n)
+	}
+	nameLen := int(binary.LittleEndian.Uint32(head[12:]))
+	if nameLen > 64<<10 {
+		return nil, fmt.Errorf("invalid name length %d", nameLen)
+	}
+	buf := make([]byte, 16*n+nameLen)
+	if _, err := f.ReadAt(buf, 16); err != nil {
+		return nil, err
+	}
+	name := string(buf[16*n:])
+	archives := make([]rrdArchive, n)
+	for i := range archives {
+		archives[i].step = time.Duration(binary.LittleEndian.Uint64(buf[16*i:])) * time.Millisecond
+		archives[i].rows = int(binary.LittleEndian.Uint64(buf[8+16*i:]))
+		if archives[i].step <= 0 || archives[i].rows <= 0 {
+			return nil, fmt.Errorf("invalid archive %d", i+1)
+		}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < rrdFileSize(name, archives) {
+		return nil, errors.New("file is truncated")
+	}
+	return newRRDFile(f, name, archives), nil
+}
+
+// newRRDFile returns f, the file of host, with archives.
+func newRRDFile(f *os.File, host string, archives []rrdArchive) *rrdFile {
+	rf := &rrdFile{f: f, name: host, archives: archives}
+	off := int64(16 + 16*len(archives) + len(host))
+	for _, a := range archives {
+		rf.offsets = append(rf.offsets, off)
+		off += int64(a.rows) * rrdSlotSize
+	}
+	return rf
+}
+
+// decodeSlot decodes a slot from b.
+func decodeSlot(b []byte) rrdSlot {
+	le := binary.LittleEndian
+	return rrdSlot{
+		ms:       int64(le.Uint64(b)),
+		samples:  le.Uint32(b[8:]),
+		up:       le.Uint32(b[12:]),
+		degraded: le.Uint32(b[16:]),
+		rtt:      math.Float32frombits(le.Uint32(b[20:])),
+		loss:     math.Float32frombits(le.Uint32(b[24:])),
+	}
+}
+
+// encode encodes s into b.
+func (s rrdSlot) encode(b []byte) {
+	le := binary.LittleEndian
+	le.PutUint64(b, uint64(s.ms))
+	le.PutUint32(b[8:], s.samples)
+	le.PutUint32(b[12:], s.up)
+	le.PutUint32(b[16:], s.degraded)
+	le.PutUint32(b[20:], math.Float32bits(s.rtt))
+	le.PutUint32(b[24:], math.Float32bits(s.loss))
+	le.PutUint32(b[28:], 0)
+}
+
+// add consolidates r into the slot of each archive its time falls in,
+// starting the slot over if it holds an older step.
+func (rf *rrdFile) add(r HistoryResult) error {
+	ms := r.Time.UnixMilli()
+	samples, up := r.weight()
+	buf := make([]byte, rrdSlotSize)
+	for i, a := range rf.archives {
+		step := a.step.Milliseconds()
+		n := floorDiv(ms, step)
+		off := rf.offsets[i] + (n%int64(a.rows)+int64(a.rows))%int64(a.rows)*rrdSlotSize
+		if _, err := rf.f.ReadAt(buf, off); err != nil {
+			return err
+		}
+		slot := decodeSlot(buf)
+		switch {
+		case slot.ms != 0 && floorDiv(slot.ms, step) > n:
+			// A newer step already took the slot
+			continue
+		case slot.ms == 0 || floorDiv(slot.ms, step) < n:
+			slot = rrdSlot{}
+		}
+		total, totalUp := float64(slot.samples)+float64(samples), float64(slot.up)+float64(up)
+		slot.loss = float32((float64(slot.loss)*float64(slot.samples) + r.PacketLoss*float64(samples)) / total)
+		if totalUp > 0 {
+			slot.rtt = float32((float64(slot.rtt)*float64(slot.up) + float64(r.LatencyMs*up)) / totalUp)
+		}
+		slot.ms = max(slot.ms, ms)
+		slot.samples += uint32(samples)
+		slot.up += uint32(up)
+		if r.State == "degraded" {
+			slot.degraded += uint32(samples)
+		}
+		slot.encode(buf)
+		if _, err := rf.f.WriteAt(buf, off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// floorDiv returns a / b rounded down.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// read returns the results in [since, until) of archive i of host, oldest
+// first. A slot of several results is returned as their average at the
+// start of its step.
+func (rf *rrdFile) read(host string, i int, since, until time.Time) ([]HistoryResult, error) {
+	a := rf.archives[i]
+	buf := make([]byte, a.rows*rrdSlotSize)
+	if _, err := rf.f.ReadAt(buf, rf.offsets[i]); err != nil {
+		return nil, err
+	}
+	step := a.step.Milliseconds()
+	var results []HistoryResult
+	for off := 0; off < len(buf); off += rrdSlotSize {
+		slot := decodeSlot(buf[off:])
+		if slot.samples == 0 || slot.ms < since.UnixMilli() || slot.ms >= until.UnixMilli() {
+			continue
+		}
+		r := HistoryResult{
+			Time:       time.UnixMilli(slot.ms),
+			Host:       host,
+			LatencyMs:  int(math.Round(float64(slot.rtt))),
+			PacketLoss: float64(slot.loss),
+			State:      "up",
+			Samples:    int(slot.samples),
+			Up:         int(slot.up),
+		}
+		switch {
+		case slot.up == 0:
+			r.State = "down"
+		case slot.degraded > 0 || slot.up < slot.samples:
+			r.State = "degraded"
+		}
+		if slot.samples > 1 {
+			r.Time = time.UnixMilli(floorDiv(slot.ms, step) * step)
+		}
+		results = append(results, r.single())
+	}
+	slices.SortFunc(results, func(a, b HistoryResult) int { return a.Time.Compare(b.Time) })
+	return results, nil
+}
+
+// archiveFor returns the finest archive of rf that reaches back to since,
+// or the coarsest if none does.
+func (rf *rrdFile) archiveFor(since time.Time) int {
+	for i, a := range rf.archives {
+		if !time.Now().Add(-a.step * time.Duration(a.rows)).After(since) {
+			return i
+		}
+	}
+	return len(rf.archives) - 1
+}
+
+// hosts returns host, or every host with a file if empty, sorted.
+func (s *rrdStore) hosts(host string) ([]string, error) {
+	if host != "" {
+		return []string{host}, nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".rrd")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(name, "@") {
+			// Named after a hash; the host is in the header
+			if h := readRRDName(filepath.Join(s.dir, e.Name())); h != "" {
+				hosts = append(hosts, h)
+			}
+		} else if h, err := url.QueryUnescape(name); err == nil {
+			hosts = append(hosts, h)
+		}
+	}
+	slices.Sort(hosts)
+	return hosts, nil
+}
+
+// readRRDName returns the host of the rrd file at path, or "" if it cannot
+// be read.
+func readRRDName(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	rf, err := readRRDHeader(f)
+	if err != nil {
+		return ""
+	}
+	return rf.name
+}
+
+// each implements historyStore, reading a host at a time from the archive
+// that reaches back to since.
+func (s *rrdStore) each(host string, since, until time.Time, fn func(HistoryResult) error) error {
+	hosts, err := s.hosts(host)
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		s.mu.Lock()
+		rf, err := s.file(h, false)
+		var results []HistoryResult
+		if err == nil && rf != nil {
+			results, err = rf.read(h, rf.archiveFor(since), since, until)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write implements historyStore.
+func (s *rrdStore) write(batch []HistoryResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range batch {
+		rf, err := s.file(r.Host, true)
+		if err != nil {
+			return err
+		}
+		if err := rf.add(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune implements historyStore, emptying the slots of every archive
+// before t. It returns how many slots of the finest archives were emptied;
+// the other archives hold averages of them. resultHistory only prunes
+// past the last archive, which is overwritten as time goes on anyway, so
+// this deletes the files of hosts no longer monitored.
+func (s *rrdStore) prune(t time.Time) (int64, error) {
+	return s.clear(time.Time{}, t)
+}
+
+// clear empties the slots of every archive in [since, until) and deletes
+// the files left empty, such as those of hosts no longer monitored.
+//
+// Returns:
+//   - int64: How many slots of the finest archives were emptied
+//   - error: If a file cannot be read, written or deleted
+func (s *rrdStore) clear(since, until time.Time) (int64, error) {
+	hosts, err := s.hosts("")
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	empty := make([]byte, rrdSlotSize)
+	for _, h := range hosts {
+		rf, err := s.file(h, false)
+		if err != nil {
+			return n, err
+		}
+		if rf == nil {
+			continue
+		}
+		left := false
+		for i, a := range rf.archives {
+			buf := make([]byte, a.rows*rrdSlotSize)
+			if _, err := rf.f.ReadAt(buf, rf.offsets[i]); err != nil {
+				return n, err
+			}
+			changed := false
+			for off := 0; off < len(buf); off += rrdSlotSize {
+				slot := decodeSlot(buf[off:])
+				switch {
+				case slot.samples == 0:
+				case slot.ms >= since.UnixMilli() && slot.ms < until.UnixMilli():
+					copy(buf[off:off+rrdSlotSize], empty)
+					changed = true
+					if i == 0 {
+						n++
+					}
+				default:
+					left = true
+				}
+			}
+			if !changed {
+				continue
+			}
+			if _, err := rf.f.WriteAt(buf, rf.offsets[i]); err != nil {
+				return n, err
+			}
+		}
+		if !left {
+			if err := s.remove(h, rf); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// remove closes and deletes the file of host. s.mu is held.
+func (s *rrdStore) remove(host string, rf *rrdFile) error {
+	delete(s.files, host)
+	rf.f.Close()
+	return os.Remove(rf.f.Name())
+}
+
+// query implements historyStore.
+func (s *rrdStore) query(host string, since, until time.Time, limit int) ([]HistoryResult, error) {
+	results := []HistoryResult{}
+	err := s.each(host, since, until, func(r HistoryResult) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(results, func(a, b HistoryResult) int { return a.Time.Compare(b.Time) })
+	if len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+	return results, nil
+}
+
+// summarize implements historyStore.
+func (s *rrdStore) summarize(host string, since, until time.Time) (HistorySummary, error) {
+	sum := HistorySummary{Host: host, Since: since}
+	up, total := 0, 0
+	err := s.each(host, since, until, func(r HistoryResult) error {
+		samples, u := r.weight()
+		sum.Results += samples
+		up += u
+		total += r.LatencyMs * u
+		return nil
+	})
+	if sum.Results > 0 {
+		sum.UptimePercent = 100 * float64(up) / float64(sum.Results)
+	}
+	if up > 0 {
+		sum.AvgLatencyMs = float64(total) / float64(up)
+	}
+	return sum, err
+}
+
+// buckets implements historyStore.
+func (s *rrdStore) buckets(host string, since, until time.Time, step time.Duration) ([]HistoryBucket, error) {
+	b := newBucketer(since, step)
+	err := s.each(host, since, until, func(r HistoryResult) error {
+		b.add(r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.result(), nil
+}
+
+// replace implements historyStore, emptying the slots in [since, until)
+// and averaging batch into them. The rrd history averages results as it
+// writes them, so resultHistory never downsamples it.
+func (s *rrdStore) replace(since, until time.Time, batch []HistoryResult) (int64, error) {
+	n, err := s.clear(since, until)
+	if err != nil {
+		return n, err
+	}
+	return n, s.write(batch)
+}
+
+// managesRetention implements historyStore: the archives are fixed in size
+// and averaged as they are written.
+func (s *rrdStore) managesRetention() bool { return true }
+
+// close implements historyStore.
+func (s *rrdStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for h, rf := range s.files {
+		errs = append(errs, rf.f.Close())
+		delete(s.files, h)
+	}
+	return errors.Join(errs...)
+}
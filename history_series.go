@@ -0,0 +1,210 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyPoints is how many buckets a series has by default when
+// /api/history is given no resolution.
+const historyPoints = 300
+
+// HistoryBucket sums up the results of a host in one bucket of a series.
+type HistoryBucket struct {
+	Host       string
+	Start      time.Time
+	Rows       int     // Stored results in the bucket, some maybe downsampled
+	Results    int     // Results in the bucket, counting the samples of downsampled ones
+	Up         int     // Results that were not down
+	LatencyMs  float64 // Average round-trip time of the results that were not down
+	PacketLoss float64 // Average packet loss percentage
+}
+
+// HostSeries is the history of one host in a HistorySeries. Each slice has
+// a value per bucket, null where the host has no results in it.
+type HostSeries struct {
+	Host          string     `json:"host"`
+	LatencyMs     []*float64 `json:"latency_ms"`     // Average round-trip time, null if down throughout
+	PacketLoss    []*float64 `json:"packet_loss"`    // Average packet loss percentage (0-100)
+	UptimePercent []*float64 `json:"uptime_percent"` // Share of results that were not down (0-100)
+}
+
+// HistorySeries is the history of hosts in buckets of the same times, as
+// served by /api/history with resolution or group, ready to chart.
+type HistorySeries struct {
+	Since      time.Time    `json:"since"`
+	Until      time.Time    `json:"until"`
+	Resolution float64      `json:"resolution_seconds"`
+	Times      []time.Time  `json:"times"` // Start of each bucket
+	Hosts      []HostSeries `json:"hosts"`
+}
+
+// bucketer sums up results into buckets of step from since, for the
+// backends that cannot group results themselves.
+type bucketer struct {
+	since   time.Time
+	step    time.Duration
+	buckets map[string]map[int64]*HistoryBucket // By host and bucket number
+}
+
+// newBucketer creates a bucketer of buckets of step from since.
+func newBucketer(since time.Time, step time.Duration) *bucketer {
+	return &bucketer{since: since, step: step, buckets: make(map[string]map[int64]*HistoryBucket)}
+}
+
+// add counts r into its bucket.
+func (b *bucketer) add(r HistoryResult) {
+	n := int64(r.Time.Sub(b.since) / b.step)
+	host := b.buckets[r.Host]
+	if host == nil {
+		host = make(map[int64]*HistoryBucket)
+		b.buckets[r.Host] = host
+	}
+	bucket := host[n]
+	if bucket == nil {
+		bucket = &HistoryBucket{Host: r.Host, Start: b.since.Add(time.Duration(n) * b.step)}
+		host[n] = bucket
+	}
+	samples, up := r.weight()
+	bucket.Rows++
+	bucket.Results += samples
+	bucket.Up += up
+	bucket.PacketLoss += r.PacketLoss * float64(samples)
+	bucket.LatencyMs += float64(r.LatencyMs * up)
+}
+
+// result returns the buckets with results by host and time, turning the
+// sums into averages.
+func (b *bucketer) result() []HistoryBucket {
+	buckets := []HistoryBucket{}
+	for _, host := range b.buckets {
+		for _, bucket := range host {
+			bucket.PacketLoss /= float64(bucket.Results)
+			if bucket.Up > 0 {
+				bucket.LatencyMs /= float64(bucket.Up)
+			}
+			buckets = append(buckets, *bucket)
+		}
+	}
+	slices.SortFunc(buckets, func(a, b HistoryBucket) int {
+		return cmp.Or(strings.Compare(a.Host, b.Host), a.Start.Compare(b.Start))
+	})
+	return buckets
+}
+
+// groupMembers returns the monitored hosts in group, sorted.
+func groupMembers(group string) []string {
+	members := []string{}
+	for h := range monitoredHosts() {
+		if matchGroup("@"+group, h) {
+			members = append(members, h)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// historySeries lines up the results of hosts in buckets of step from
+// since to until.
+//
+// Parameters:
+//   - hosts: The hosts to serve a series of, even without results, or nil
+//     for every host with results
+//   - since: Start of the first bucket, a multiple of step
+//   - until: End of the series
+//   - step: Length of each bucket
+//
+// Returns:
+//   - HistorySeries: The series, hosts in order
+//   - error: If the store fails
+func historySeries(store historyStore, hosts []string, since, until time.Time, step time.Duration) (HistorySeries, error) {
+	series := HistorySeries{Since: since, Until: until, Resolution: step.Seconds(), Times: []time.Time{}, Hosts: []HostSeries{}}
+	n := int((until.Sub(since) + step - 1) / step)
+	for i := range n {
+		series.Times = append(series.Times, since.Add(time.Duration(i)*step))
+	}
+	var buckets []HistoryBucket
+	if hosts == nil {
+		var err error
+		if buckets, err = store.buckets("", since, until, step); err != nil {
+			return series, err
+		}
+		for _, b := range buckets {
+			if len(hosts) == 0 || hosts[len(hosts)-1] != b.Host {
+				hosts = append(hosts, b.Host)
+			}
+		}
+	} else {
+		for _, h := range hosts {
+			b, err := store.buckets(h, since, until, step)
+			if err != nil {
+				return series, err
+			}
+			buckets = append(buckets, b...)
+		}
+	}
+	index := make(map[string]int, len(hosts))
+	for _, h := range hosts {
+		index[h] = len(series.Hosts)
+		series.Hosts = append(series.Hosts, HostSeries{
+			Host:          h,
+			LatencyMs:     make([]*float64, n),
+			PacketLoss:    make([]*float64, n),
+			UptimePercent: make([]*float64, n),
+		})
+	}
+	for _, b := range buckets {
+		i := int(b.Start.Sub(since) / step)
+		if i < 0 || i >= n {
+			continue
+		}
+		s := &series.Hosts[index[b.Host]]
+		loss, uptime := b.PacketLoss, 100*float64(b.Up)/float64(b.Results)
+		s.PacketLoss[i], s.UptimePercent[i] = &loss, &uptime
+		if b.Up > 0 {
+			latency := b.LatencyMs
+			s.LatencyMs[i] = &latency
+		}
+	}
+	return series, nil
+}
+
+// historySeriesHandler serves the series of /api/history in [since, until)
+// for the query parameters q. The resolution defaults to historyPoints
+// buckets, in whole seconds; buckets start at multiples of it, so series of
+// different requests line up.
+func historySeriesHandler(w http.ResponseWriter, q url.Values, since, until time.Time) {
+	step := max(time.Second, until.Sub(since)/historyPoints).Round(time.Second)
+	if s := q.Get("resolution"); s != "" {
+		var err error
+		if step, err = time.ParseDuration(s); err != nil || step < time.Second {
+			http.Error(w, "resolution must be a duration of at least 1s", http.StatusBadRequest)
+			return
+		}
+	}
+	since = since.Truncate(step)
+	if until.Sub(since)/step > historyLimit {
+		http.Error(w, fmt.Sprintf("more than %d buckets; raise the resolution", historyLimit), http.StatusBadRequest)
+		return
+	}
+	var hosts []string
+	if host := q.Get("host"); host != "" {
+		hosts = []string{host}
+	} else if group := q.Get("group"); group != "" {
+		hosts = groupMembers(group)
+	}
+	series, err := historySeries(history.store, hosts, since, until, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
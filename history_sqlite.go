@@ -0,0 +1,8 @@
+//go:build !embedded
+
+package main
+
+import _ "modernc.org/sqlite" // Registers the "sqlite" driver for -history-db
+
+// historyDriver is the database/sql driver of -history-db.
+const historyDriver = "sqlite"
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testHistoryPath returns where a history database of backend is kept in a
+// test: a file in a temporary directory, or for the postgres backend the
+// database at the URL in MOSAIC_TEST_POSTGRES, as an instance of its own.
+// It skips the test in the embedded build.
+func testHistoryPath(t *testing.T, backend string) string {
+	if embeddedBuild {
+		t.Skip("the embedded build keeps no history")
+	}
+	path := filepath.Join(t.TempDir(), "history.db")
+	if backend == "postgres" {
+		if path = os.Getenv("MOSAIC_TEST_POSTGRES"); path == "" {
+			t.Skip("set MOSAIC_TEST_POSTGRES to a database URL to test the postgres backend")
+		}
+		old := historyInstance
+		t.Cleanup(func() { historyInstance = old })
+		historyInstance = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	}
+	if backend == "rrd" {
+		// A slot per second, so results a second apart stay apart
+		old := pingInterval
+		t.Cleanup(func() { pingInterval = old })
+		pingInterval = time.Second
+	}
+	return path
+}
+
+// openTestHistory opens a history database of backend at testHistoryPath.
+func openTestHistory(t *testing.T, backend string) (*resultHistory, string) {
+	path := testHistoryPath(t, backend)
+	h, err := openHistory(backend, path, 24*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, path
+}
+
+func TestHistoryPersists(t *testing.T) {
+	for backend := range historyBackends {
+		t.Run(backend, func(t *testing.T) { testHistoryPersists(t, backend) })
+	}
+}
+
+// testHistoryPersists stores results with backend and reads them back.
+func testHistoryPersists(t *testing.T, backend string) {
+	h, path := openTestHistory(t, backend)
+	now := time.Now().Truncate(time.Millisecond)
+	h.record([]HostStatus{
+		{Host: "a", Alive: true, LatencyMs: 12},
+		{Host: "b", Alive: true, Degraded: true, LatencyMs: 300, PacketLoss: 50},
+		{Host: "c", PacketLoss: 100},
+	}, now.Add(-time.Minute))
+	h.record([]HostStatus{{Host: "a", Alive: true, LatencyMs: 15}}, now)
+	h.record(nil, now)
+	assert.NoError(t, h.close(context.Background()))
+
+	// Results survive reopening, as after a restart
+	h, err := openHistory(backend, path, 24*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close(context.Background())
+	all, err := h.store.query("", now.Add(-time.Hour), now.Add(time.Second), historyLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, []HistoryResult{
+		{Time: now.Add(-time.Minute), Host: "a", LatencyMs: 12, State: "up"},
+		{Time: now.Add(-time.Minute), Host: "b", LatencyMs: 300, PacketLoss: 50, State: "degraded"},
+		{Time: now.Add(-time.Minute), Host: "c", PacketLoss: 100, State: "down"},
+		{Time: now, Host: "a", LatencyMs: 15, State: "up"},
+	}, all)
+
+	a, err := h.store.query("a", now.Add(-time.Hour), now.Add(time.Second), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []HistoryResult{{Time: now, Host: "a", LatencyMs: 15, State: "up"}}, a, "the limit keeps the newest")
+
+	summary, err := h.store.summarize("", now.Add(-time.Hour), now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, summary.Results)
+	assert.Equal(t, 75.0, summary.UptimePercent)
+	assert.Equal(t, 109.0, summary.AvgLatencyMs, "down results have no latency")
+
+	since := now.Add(-time.Hour).Truncate(time.Minute)
+	buckets, err := h.store.buckets("", since, now.Add(time.Second), time.Minute)
+	assert.NoError(t, err)
+	var got []string
+	for _, b := range buckets {
+		got = append(got, fmt.Sprintf("%s %d %d/%d %.0fms %.0f%%", b.Host, b.Start.Sub(since)/time.Minute, b.Up, b.Results, b.LatencyMs, b.PacketLoss))
+	}
+	last := int(now.Sub(since) / time.Minute)
+	assert.Equal(t, []string{
+		fmt.Sprintf("a %d 1/1 12ms 0%%", last-1),
+		fmt.Sprintf("a %d 1/1 15ms 0%%", last),
+		fmt.Sprintf("b %d 1/1 300ms 50%%", last-1),
+		fmt.Sprintf("c %d 0/1 0ms 100%%", last-1),
+	}, got)
+	buckets, _ = h.store.buckets("a", since, now.Add(time.Second), 2*time.Hour)
+	if assert.Len(t, buckets, 1) {
+		assert.Equal(t, 13.5, buckets[0].LatencyMs)
+	}
+
+	n, err := h.store.prune(now)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, n)
+	all, _ = h.store.query("", now.Add(-time.Hour), now.Add(time.Second), historyLimit)
+	assert.Len(t, all, 1)
+}
+
+func TestHistoryHandler(t *testing.T) {
+	defer func(h *resultHistory) { history = h }(history)
+	history = nil
+	w := httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest(http.MethodGet, "/api/history", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	h, _ := openTestHistory(t, "sqlite")
+	defer h.close(context.Background())
+	history = h
+	now := time.Now()
+	if err := h.store.write([]HistoryResult{
+		{Time: now.Add(-2 * time.Hour), Host: "a", State: "down"},
+		{Time: now.Add(-time.Minute), Host: "a", LatencyMs: 10, State: "up"},
+		{Time: now.Add(-time.Minute), Host: "b", LatencyMs: 20, State: "up"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) (int, []HistoryResult) {
+		w := httptest.NewRecorder()
+		historyHandler(w, httptest.NewRequest(http.MethodGet, "/api/history?"+query, nil))
+		var results []HistoryResult
+		json.NewDecoder(w.Body).Decode(&results)
+		return w.Code, results
+	}
+	code, results := get("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, results, 2, "the last hour by default")
+	_, results = get("host=a&since=3h")
+	assert.Len(t, results, 2)
+	assert.Equal(t, "down", results[0].State)
+	_, results = get("host=a&since=3h&until=" + now.Add(-time.Hour).Format(time.RFC3339))
+	assert.Len(t, results, 1)
+
+	w = httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest(http.MethodGet, "/api/history?host=a&since=3h&summary=1", nil))
+	var summary HistorySummary
+	json.NewDecoder(w.Body).Decode(&summary)
+	assert.Equal(t, 2, summary.Results)
+	assert.Equal(t, 50.0, summary.UptimePercent)
+	assert.Equal(t, 10.0, summary.AvgLatencyMs, "down results have no latency")
+
+	code, _ = get("since=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("limit=0")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := newMemoryStore(3)
+	now := time.Now().Truncate(time.Millisecond)
+	for i := range 5 {
+		s.write([]HistoryResult{
+			{Time: now.Add(time.Duration(i-5) * time.Minute), Host: "a", LatencyMs: i, State: "up"},
+			{Time: now.Add(time.Duration(i-5) * time.Minute), Host: "b", PacketLoss: 100, State: "down"},
+		})
+	}
+	a, err := s.query("a", now.Add(-time.Hour), now, historyLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, []HistoryResult{
+		{Time: now.Add(-3 * time.Minute), Host: "a", LatencyMs: 2, State: "up"},
+		{Time: now.Add(-2 * time.Minute), Host: "a", LatencyMs: 3, State: "up"},
+		{Time: now.Add(-time.Minute), Host: "a", LatencyMs: 4, State: "up"},
+	}, a, "the oldest results are overwritten")
+	all, _ := s.query("", now.Add(-time.Hour), now, 2)
+	assert.Equal(t, []string{"a", "b"}, []string{all[0].Host, all[1].Host})
+	summary, _ := s.summarize("", now.Add(-time.Hour), now)
+	assert.Equal(t, 6, summary.Results)
+	assert.Equal(t, 50.0, summary.UptimePercent)
+
+	n, _ := s.prune(now.Add(-90 * time.Second))
+	assert.EqualValues(t, 4, n)
+	s.write([]HistoryResult{{Time: now, Host: "a", LatencyMs: 5, State: "up"}})
+	a, _ = s.query("a", now.Add(-time.Hour), now.Add(time.Second), historyLimit)
+	assert.Equal(t, []int{4, 5}, []int{a[0].LatencyMs, a[1].LatencyMs})
+	n, _ = s.prune(now.Add(time.Second))
+	assert.EqualValues(t, 3, n)
+	assert.Empty(t, s.hosts, "hosts without results are forgotten")
+}
+
+func TestHistoryHandlerMinutes(t *testing.T) {
+	defer func(h *resultHistory) { history = h }(history)
+	s := newMemoryStore(10)
+	history = &resultHistory{store: s}
+	now := time.Now()
+	s.write([]HistoryResult{
+		{Time: now.Add(-40 * time.Minute), Host: "a", State: "up"},
+		{Time: now.Add(-20 * time.Minute), Host: "a", State: "up"},
+	})
+	w := httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest(http.MethodGet, "/api/history?host=a&minutes=30", nil))
+	var results []HistoryResult
+	json.NewDecoder(w.Body).Decode(&results)
+	assert.Len(t, results, 1)
+
+	w = httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest(http.MethodGet, "/api/history?minutes=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHistorySeries(t *testing.T) {
+	defer func(h *resultHistory, saved []string) { history, hosts = h, saved }(history, hosts)
+	defer setLabels(labels())
+	s := newMemoryStore(10)
+	history = &resultHistory{store: s}
+	hosts = []string{"a", "b", "c"}
+	setLabels(map[string]HostLabel{"a": {Groups: []string{"web"}}, "c": {Groups: []string{"web"}}})
+	since := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	s.write([]HistoryResult{
+		{Time: since.Add(30 * time.Second), Host: "a", LatencyMs: 10, State: "up"},
+		{Time: since.Add(40 * time.Second), Host: "a", PacketLoss: 100, State: "down"},
+		{Time: since.Add(50 * time.Second), Host: "b", LatencyMs: 5, State: "up"},
+		{Time: since.Add(2 * time.Minute), Host: "a", LatencyMs: 20, PacketLoss: 50, State: "degraded"},
+	})
+	get := func(query string) (int, HistorySeries) {
+		w := httptest.NewRecorder()
+		historyHandler(w, httptest.NewRequest(http.MethodGet, "/api/history?"+query, nil))
+		var series HistorySeries
+		json.NewDecoder(w.Body).Decode(&series)
+		return w.Code, series
+	}
+	value := func(f *float64) any {
+		if f == nil {
+			return nil
+		}
+		return *f
+	}
+
+	code, series := get("group=web&resolution=1m&since=" + since.Format(time.RFC3339))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 60.0, series.Resolution)
+	assert.Len(t, series.Times, 11, "up to now, the last bucket partly")
+	assert.True(t, series.Times[0].Equal(since))
+	if assert.Len(t, series.Hosts, 2, "hosts of the group, with results or not") {
+		a, c := series.Hosts[0], series.Hosts[1]
+		assert.Equal(t, "a", a.Host)
+		assert.Equal(t, []any{10.0, nil, 20.0}, []any{value(a.LatencyMs[0]), value(a.LatencyMs[1]), value(a.LatencyMs[2])})
+		assert.Equal(t, []any{50.0, nil, 100.0}, []any{value(a.UptimePercent[0]), value(a.UptimePercent[1]), value(a.UptimePercent[2])})
+		assert.Equal(t, 50.0, value(a.PacketLoss[0]))
+		assert.Equal(t, "c", c.Host)
+		assert.Len(t, c.LatencyMs, 11)
+		assert.Nil(t, c.LatencyMs[0])
+	}
+
+	_, series = get("resolution=5m&since=" + since.Format(time.RFC3339))
+	assert.Len(t, series.Times, 3)
+	assert.Equal(t, []string{"a", "b"}, []string{series.Hosts[0].Host, series.Hosts[1].Host}, "every host with results")
+	assert.Equal(t, 5.0, value(series.Hosts[1].LatencyMs[0]))
+	_, series = get("host=b&group=web&since=1h")
+	assert.Equal(t, 12.0, series.Resolution, "an hour in historyPoints buckets")
+	assert.Equal(t, "b", series.Hosts[0].Host)
+	_, series = get("group=none")
+	assert.Empty(t, series.Hosts)
+
+	code, _ = get("resolution=10ms")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("resolution=1s&since=24h")
+	assert.Equal(t, http.StatusBadRequest, code, "too many buckets")
+}
+
+func TestOpenHistoryBackend(t *testing.T) {
+	_, err := openHistory("csv", filepath.Join(t.TempDir(), "history.csv"), time.Hour, nil)
+	assert.Error(t, err)
+	_, err = openHistory("postgres", "postgres://mosaic@127.0.0.1:1/mosaic?sslmode=disable&connect_timeout=1", time.Hour, nil)
+	assert.Error(t, err, "the schema is migrated on open")
+}
+
+func TestHistoryTiersFlag(t *testing.T) {
+	var tiers historyTiers
+	assert.NoError(t, tiers.Set("1m=720h, 1h=8760h"))
+	assert.Equal(t, historyDownsample, tiers)
+	assert.Equal(t, "1m=720h,1h=8760h", tiers.String())
+	assert.NoError(t, tiers.Set(""))
+	assert.Empty(t, tiers, "empty turns downsampling off")
+	assert.Error(t, tiers.Set("1m"))
+	assert.Error(t, tiers.Set("1m=a month"))
+
+	assert.NoError(t, historyDownsample.validate(24*time.Hour))
+	for _, bad := range []historyTiers{
+		{{Step: time.Millisecond, Keep: time.Hour}},
+		{{Step: time.Minute, Keep: time.Hour}},
+		{{Step: time.Minute, Keep: 48 * time.Hour}, {Step: 90 * time.Second, Keep: 96 * time.Hour}},
+		{{Step: time.Minute, Keep: 48 * time.Hour}, {Step: time.Hour, Keep: 48 * time.Hour}},
+	} {
+		assert.Error(t, bad.validate(24*time.Hour), bad.String())
+	}
+}
+
+func TestHistoryDownsample(t *testing.T) {
+	for backend := range historyBackends {
+		t.Run(backend, func(t *testing.T) { testHistoryDownsample(t, backend) })
+	}
+}
+
+// testHistoryDownsample averages results of backend by tiers.
+func testHistoryDownsample(t *testing.T, backend string) {
+	if backend == "rrd" {
+		t.Skip("the rrd backend averages results as it writes them")
+	}
+	store, err := historyBackends[backend](testHistoryPath(t, backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	tiers := historyTiers{{Step: time.Minute, Keep: 48 * time.Hour}, {Step: time.Hour, Keep: 96 * time.Hour}}
+	h := &resultHistory{store: store, retention: 24 * time.Hour, tiers: tiers, compacted: make([]time.Time, len(tiers))}
+	now := time.Now().Truncate(time.Hour)
+	if err := store.write([]HistoryResult{
+		{Time: now.Add(-100 * time.Hour), Host: "b", LatencyMs: 1, State: "up"},
+		{Time: now.Add(-72*time.Hour + 10*time.Minute), Host: "b", LatencyMs: 100, State: "up"},
+		{Time: now.Add(-72*time.Hour + 40*time.Minute), Host: "b", LatencyMs: 200, State: "up"},
+		{Time: now.Add(-30*time.Hour + 10*time.Second), Host: "a", LatencyMs: 10, State: "up"},
+		{Time: now.Add(-30*time.Hour + 20*time.Second), Host: "a", PacketLoss: 90, State: "down"},
+		{Time: now.Add(-30*time.Hour + 30*time.Second), Host: "a", LatencyMs: 20, State: "up"},
+		{Time: now.Add(-time.Hour), Host: "a", LatencyMs: 5, State: "up"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []HistoryResult{
+		{Time: now.Add(-72 * time.Hour), Host: "b", LatencyMs: 150, State: "up", Samples: 2, Up: 2},
+		{Time: now.Add(-30 * time.Hour), Host: "a", LatencyMs: 15, PacketLoss: 30, State: "degraded", Samples: 3, Up: 2},
+		{Time: now.Add(-time.Hour), Host: "a", LatencyMs: 5, State: "up"},
+	}
+	h.compact(now)
+	all, err := store.query("", now.Add(-200*time.Hour), now, historyLimit)
+	assert.NoError(t, err)
+	assert.Equal(t, want, all, "averaged by tier, the oldest deleted")
+	h.compacted = make([]time.Time, len(tiers))
+	h.compact(now)
+	all, _ = store.query("", now.Add(-200*time.Hour), now, historyLimit)
+	assert.Equal(t, want, all, "averages are left as they are, as after a restart")
+
+	summary, err := store.summarize("", now.Add(-200*time.Hour), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, summary.Results, "averages count their samples")
+	assert.InDelta(t, 100*5/6.0, summary.UptimePercent, 0.001)
+	assert.Equal(t, 67.0, summary.AvgLatencyMs)
+	buckets, err := store.buckets("a", now.Add(-48*time.Hour), now, 48*time.Hour)
+	assert.NoError(t, err)
+	if assert.Len(t, buckets, 1) {
+		assert.Equal(t, []int{2, 4, 3}, []int{buckets[0].Rows, buckets[0].Results, buckets[0].Up})
+		assert.Equal(t, 35/3.0, buckets[0].LatencyMs)
+		assert.Equal(t, 22.5, buckets[0].PacketLoss)
+	}
+}
+
+func TestSQLiteMigration(t *testing.T) {
+	if embeddedBuild {
+		t.Skip("the embedded build keeps no history")
+	}
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := sql.Open(historyDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A database from before user_version
+	_, err = db.Exec(`CREATE TABLE results (time INTEGER NOT NULL, host TEXT NOT NULL, rtt_ms INTEGER NOT NULL, loss REAL NOT NULL, state TEXT NOT NULL);
+		INSERT INTO results VALUES (1000, 'a', 10, 0, 'up'), (2000, 'a', 0, 100, 'down')`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	summary, err := store.summarize("a", time.UnixMilli(0), time.UnixMilli(3000))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, summary.Results)
+	assert.Equal(t, 50.0, summary.UptimePercent, "down results are not counted as up")
+	assert.Equal(t, 10.0, summary.AvgLatencyMs)
+}
+
+func TestHistoryEach(t *testing.T) {
+	for backend := range historyBackends {
+		t.Run(backend, func(t *testing.T) {
+			store, err := historyBackends[backend](testHistoryPath(t, backend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.close()
+			now := time.Now().Truncate(time.Millisecond)
+			var batch []HistoryResult
+			for i := range historyPage + 10 {
+				batch = append(batch, HistoryResult{Time: now.Add(time.Duration(i-historyPage-10) * time.Second), Host: "b", LatencyMs: i, State: "up"})
+			}
+			batch = append(batch, HistoryResult{Time: now.Add(-time.Second), Host: "a", State: "down"})
+			if err := store.write(batch); err != nil {
+				t.Fatal(err)
+			}
+			var hosts []string
+			var latencies []int
+			err = store.each("", now.Add(-time.Hour), now, func(r HistoryResult) error {
+				if len(hosts) == 0 || hosts[len(hosts)-1] != r.Host {
+					hosts = append(hosts, r.Host)
+				}
+				if r.Host == "b" {
+					latencies = append(latencies, r.LatencyMs)
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a", "b"}, hosts)
+			if assert.Len(t, latencies, historyPage+10, "across pages") {
+				assert.True(t, slices.IsSorted(latencies), "oldest first")
+			}
+			n := 0
+			store.each("a", now.Add(-time.Hour), now, func(HistoryResult) error { n++; return nil })
+			assert.Equal(t, 1, n)
+		})
+	}
+}
+
+func TestRRDStore(t *testing.T) {
+	dir := t.TempDir()
+	s := &rrdStore{dir: dir, layout: []rrdArchive{{step: time.Second, rows: 5}, {step: time.Minute, rows: 3}}, files: make(map[string]*rrdFile)}
+	start := time.Now().Truncate(time.Minute).Add(-time.Hour)
+	for i := range 8 {
+		assert.NoError(t, s.write([]HistoryResult{{Time: start.Add(time.Duration(i) * time.Second), Host: "10.0.0.1:22", LatencyMs: 10 * (i + 1), State: "up"}}))
+	}
+	assert.NoError(t, s.write([]HistoryResult{{Time: start.Add(time.Second), Host: "10.0.0.1:22", State: "down"}}))
+	fi, err := os.Stat(filepath.Join(dir, "10.0.0.1%3A22.rrd"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(16+2*16+len("10.0.0.1:22")+8*rrdSlotSize), fi.Size(), "allocated up front")
+	}
+	assert.NoError(t, s.close())
+
+	// Files keep their layout when the settings change
+	s = &rrdStore{dir: dir, layout: []rrdArchive{{step: time.Second, rows: 100}}, files: make(map[string]*rrdFile)}
+	defer s.close()
+	rf, err := s.file("10.0.0.1:22", false)
+	assert.NoError(t, err)
+	assert.Len(t, rf.archives, 2)
+	raw, err := rf.read("10.0.0.1:22", 0, start, start.Add(time.Minute))
+	assert.NoError(t, err)
+	var latencies []int
+	for _, r := range raw {
+		latencies = append(latencies, r.LatencyMs)
+	}
+	assert.Equal(t, []int{40, 50, 60, 70, 80}, latencies, "the oldest slots are overwritten; a late result is dropped")
+	avg, err := rf.read("10.0.0.1:22", 1, start, start.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, []HistoryResult{{Time: start, Host: "10.0.0.1:22", LatencyMs: 45, State: "degraded", Samples: 9, Up: 8}}, avg)
+	hosts, _ := s.hosts("")
+	assert.Equal(t, []string{"10.0.0.1:22"}, hosts)
+}
+
+func TestRRDStoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	s := &rrdStore{dir: dir, layout: []rrdArchive{{step: time.Second, rows: 60}, {step: time.Minute, rows: 60}}, files: make(map[string]*rrdFile)}
+	defer s.close()
+	now := time.Now().Truncate(time.Minute)
+	long := "https://status.example.com/health?" + strings.Repeat("check=all&", 30)
+	assert.NoError(t, s.write([]HistoryResult{
+		{Time: now.Add(-30 * time.Minute), Host: "gone", LatencyMs: 5, State: "up"},
+		{Time: now.Add(-10 * time.Second), Host: long, LatencyMs: 7, State: "up"},
+		{Time: now.Add(-5 * time.Second), Host: "kept", LatencyMs: 9, State: "up"},
+	}))
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		assert.LessOrEqual(t, len(e.Name()), rrdMaxName)
+	}
+	hosts, _ := s.hosts("")
+	assert.Equal(t, []string{"gone", long, "kept"}, hosts, "hashed files name their host")
+	results, err := s.query(long, now.Add(-time.Minute), now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	// A host no longer written to has its file deleted once all of it is pruned
+	_, err = s.prune(now.Add(-time.Minute))
+	assert.NoError(t, err)
+	hosts, _ = s.hosts("")
+	assert.Equal(t, []string{long, "kept"}, hosts)
+	_, err = os.Stat(filepath.Join(dir, "gone.rrd"))
+	assert.True(t, os.IsNotExist(err))
+
+	n, err := s.replace(now.Add(-time.Minute), now, []HistoryResult{{Time: now.Add(-time.Minute), Host: "kept", LatencyMs: 3, State: "up", Samples: 2, Up: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	hosts, _ = s.hosts("")
+	assert.Equal(t, []string{"kept"}, hosts)
+	results, _ = s.query("kept", now.Add(-time.Hour), now, 10)
+	assert.Equal(t, []HistoryResult{{Time: now.Add(-time.Minute), Host: "kept", LatencyMs: 3, State: "up", Samples: 2, Up: 2}}, results)
+}
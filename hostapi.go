@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostEntry describes a monitored host as served by /api/hosts.
+type HostEntry struct {
+	Host     string     `json:"host"`
+	Dynamic  bool       `json:"dynamic"`            // Added at runtime through the API
+	Expires  *time.Time `json:"expires,omitempty"`  // When a host added with a TTL is removed
+	Disabled bool       `json:"disabled,omitempty"` // Parked: monitored but not probed
+}
+
+var (
+	hostsMu sync.RWMutex
+	// dynamicHosts holds hosts added through the API and their expiry,
+	// the zero time meaning they never expire.
+	dynamicHosts = make(map[string]time.Time)
+)
+
+// currentHosts returns a snapshot of the hosts to probe.
+func currentHosts() []string {
+	hostsMu.RLock()
+	defer hostsMu.RUnlock()
+	return append([]string(nil), hosts...)
+}
+
+// monitoredHosts returns the hosts to probe, mapped to whether they were
+// added at runtime.
+func monitoredHosts() map[string]bool {
+	hostsMu.RLock()
+	defer hostsMu.RUnlock()
+	m := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		_, m[h] = dynamicHosts[h]
+	}
+	return m
+}
+
+// addHost starts monitoring host. A positive ttl removes it again after that
+// long; adding a host that was already added at runtime renews its TTL.
+//
+// Parameters:
+//   - host: Host entry as accepted by -hosts
+//   - ttl: How long to monitor the host, or 0 for no limit
+//   - now: Current time
+//
+// Returns:
+//   - error: If the host is invalid or already configured statically
+func addHost(host string, ttl time.Duration, now time.Time) error {
+	if err := validateHost(host); err != nil {
+		return err
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = now.Add(ttl)
+	}
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	if _, ok := dynamicHosts[host]; ok {
+		dynamicHosts[host] = expires
+		return nil
+	}
+	for _, h := range hosts {
+		if h == host {
+			return fmt.Errorf("%s is already monitored", host)
+		}
+	}
+	hosts = append(hosts, host)
+	dynamicHosts[host] = expires
+	return nil
+}
+
+// removeHost stops monitoring a host that was added at runtime.
+//
+// Returns:
+//   - bool: False if host was not added through the API
+func removeHost(host string) bool {
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	if _, ok := dynamicHosts[host]; !ok {
+		return false
+	}
+	dropHostLocked(host)
+	return true
+}
+
+// expireHosts removes runtime hosts whose TTL ran out.
+//
+// Returns:
+//   - []string: The hosts that were removed
+func expireHosts(now time.Time) []string {
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	var expired []string
+	for h, exp := range dynamicHosts {
+		if !exp.IsZero() && !now.Before(exp) {
+			dropHostLocked(h)
+			expired = append(expired, h)
+		}
+	}
+	sort.Strings(expired)
+	for _, h := range expired {
+		events.add(Event{Time: now, Type: "host_expired", Hosts: []string{h}, Message: h + " removed after its TTL expired"})
+	}
+	return expired
+}
+
+// dropHostLocked removes host from the monitored set and forgets its packet
+// counters, schedule and state so it leaves no trace on the dashboard. The
+// caller must hold hostsMu.
+func dropHostLocked(host string) {
+	delete(dynamicHosts, host)
+	for i, h := range hosts {
+		if h == host {
+			hosts = append(hosts[:i:i], hosts[i+1:]...)
+			break
+		}
+	}
+	keys := []string{host}
+	if _, members, ok := splitAlias(host); ok {
+		keys = append(keys, members...)
+	}
+	hostStatsMu.Lock()
+	for _, k := range keys {
+		delete(hostStats, k)
+		// Paths of the host, e.g. "#direct", "#v6" or "#dscp-EF"
+		for key := range hostStats {
+			if strings.HasPrefix(key, k+"#") {
+				delete(hostStats, key)
+			}
+		}
+	}
+	hostStatsMu.Unlock()
+	scheduler.forget(host)
+	hostStates.forget(host)
+	resolved.forget(host)
+	maintenance.forget(host)
+}
+
+// hostEntries lists all monitored hosts.
+func hostEntries() []HostEntry {
+	o := overrides()
+	hostsMu.RLock()
+	defer hostsMu.RUnlock()
+	entries := make([]HostEntry, 0, len(hosts))
+	for _, h := range hosts {
+		e := HostEntry{Host: h, Disabled: o[h].disabled()}
+		if exp, ok := dynamicHosts[h]; ok {
+			e.Dynamic = true
+			if !exp.IsZero() {
+				exp := exp
+				e.Expires = &exp
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// hostsHandler lists monitored hosts (GET), adds a host (POST with
+// {"host": "...", "ttl": "4h"}), removes a runtime host (DELETE ?host=...)
+// or disables and enables one (PATCH with {"host": "...", "enabled": false}).
+// With -persist-hosts, hosts added without a TTL are saved to the hosts file
+// and configured hosts can be removed from it. All but GET need the
+// -api-token, and exec:// hosts cannot be added this way.
+func hostsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && !requireToken(w, r, "changing hosts through /api/hosts") {
+		return
+	}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Host string `json:"host"`
+			TTL  string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if runsCommand(req.Host) {
+			http.Error(w, "exec:// hosts can only be configured in -hosts, -file or -config", http.StatusForbidden)
+			return
+		}
+		var ttl time.Duration
+		var err error
+		if req.TTL != "" {
+			if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+		}
+		keep := persistHosts && ttl == 0
+		if keep {
+			err = keepHost(req.Host)
+		} else {
+			err = addHost(req.Host, ttl, time.Now())
+		}
+		if err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, errPersist) {
+				code = http.StatusInternalServerError
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		msg := req.Host + " added"
+		if ttl > 0 {
+			msg += " for " + ttl.String()
+		}
+		if keep {
+			msg += " to " + hostsSource()
+		}
+		events.add(Event{Type: "host_added", Hosts: []string{req.Host}, Message: msg})
+		status = http.StatusCreated
+	case http.MethodDelete:
+		host := r.URL.Query().Get("host")
+		msg := host + " removed"
+		switch {
+		case removeHost(host):
+		case !persistHosts:
+			http.Error(w, "no runtime host "+host, http.StatusNotFound)
+			return
+		default:
+			if err := unkeepHost(host); err != nil {
+				code := http.StatusNotFound
+				switch {
+				case errors.Is(err, errNotListed):
+					code = http.StatusConflict
+				case errors.Is(err, errPersist):
+					code = http.StatusInternalServerError
+				}
+				http.Error(w, err.Error(), code)
+				return
+			}
+			msg += " from " + hostsSource()
+		}
+		events.add(Event{Type: "host_removed", Hosts: []string{host}, Message: msg})
+	case http.MethodPatch:
+		var req struct {
+			Host    string `json:"host"`
+			Enabled *bool  `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := setEnabled(req.Host, *req.Enabled); err != nil {
+			code := http.StatusNotFound
+			if errors.Is(err, errPersist) {
+				code = http.StatusInternalServerError
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if *req.Enabled {
+			events.add(Event{Type: "host_enabled", Hosts: []string{req.Host}, Message: req.Host + " enabled"})
+		} else {
+			events.add(Event{Type: "host_disabled", Hosts: []string{req.Host}, Message: req.Host + " disabled"})
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE, PATCH")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(hostEntries())
+}
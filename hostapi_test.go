@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withHosts replaces the monitored host set for the duration of a test.
+func withHosts(t *testing.T, list ...string) {
+	t.Helper()
+	oldHosts, oldDynamic := hosts, dynamicHosts
+	hosts = list
+	dynamicHosts = make(map[string]time.Time)
+	t.Cleanup(func() { hosts, dynamicHosts = oldHosts, oldDynamic })
+}
+
+// withAPIToken sets an -api-token for the duration of a test.
+func withAPIToken(t *testing.T) {
+	t.Helper()
+	old := apiToken
+	apiToken = "test-token"
+	t.Cleanup(func() { apiToken = old })
+}
+
+// hostsRequest returns a request to /api/hosts carrying the -api-token.
+func hostsRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+apiToken)
+	return r
+}
+
+func TestAddHostWithTTL(t *testing.T) {
+	withHosts(t, "static")
+	now := time.Now()
+
+	assert.NoError(t, addHost("lab-vm", 4*time.Hour, now))
+	assert.NoError(t, addHost("forever", 0, now))
+	assert.Error(t, addHost("static", time.Hour, now), "static hosts can't be re-added")
+	assert.Error(t, addHost("gopher://x", time.Hour, now))
+	assert.Equal(t, []string{"static", "lab-vm", "forever"}, currentHosts())
+
+	// Re-adding renews the TTL
+	assert.NoError(t, addHost("lab-vm", 8*time.Hour, now))
+	assert.Equal(t, now.Add(8*time.Hour), dynamicHosts["lab-vm"])
+
+	assert.Empty(t, expireHosts(now.Add(7*time.Hour)))
+	assert.Equal(t, []string{"lab-vm"}, expireHosts(now.Add(8*time.Hour)))
+	assert.Equal(t, []string{"static", "forever"}, currentHosts())
+}
+
+func TestExpireHostForgetsStats(t *testing.T) {
+	withHosts(t)
+	now := time.Now()
+	assert.NoError(t, addHost("temp", time.Minute, now))
+	hostStatsMu.Lock()
+	hostStats["temp"] = &HostStats{Sent: 4}
+	hostStatsMu.Unlock()
+
+	expireHosts(now.Add(time.Minute))
+
+	hostStatsMu.Lock()
+	defer hostStatsMu.Unlock()
+	assert.NotContains(t, hostStats, "temp")
+}
+
+func TestRemoveHost(t *testing.T) {
+	withHosts(t, "static")
+	assert.NoError(t, addHost("temp", 0, time.Now()))
+	assert.False(t, removeHost("static"), "only runtime hosts can be removed")
+	assert.True(t, removeHost("temp"))
+	assert.False(t, removeHost("temp"))
+	assert.Equal(t, []string{"static"}, currentHosts())
+}
+
+func TestHostsHandler(t *testing.T) {
+	withHosts(t, "static")
+	withAPIToken(t)
+
+	rec := httptest.NewRecorder()
+	hostsHandler(rec, hostsRequest("POST", "/api/hosts", `{"host":"10.9.9.9","ttl":"4h"}`))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var entries []HostEntry
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	assert.Len(t, entries, 2)
+	assert.False(t, entries[0].Dynamic)
+	assert.True(t, entries[1].Dynamic)
+	assert.NotNil(t, entries[1].Expires)
+
+	rec = httptest.NewRecorder()
+	hostsHandler(rec, hostsRequest("POST", "/api/hosts", `{"host":"10.9.9.8","ttl":"soon"}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	hostsHandler(rec, hostsRequest("DELETE", "/api/hosts?host=10.9.9.9", ""))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"static"}, currentHosts())
+
+	rec = httptest.NewRecorder()
+	hostsHandler(rec, hostsRequest("DELETE", "/api/hosts?host=static", ""))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHostsHandlerNeedsToken(t *testing.T) {
+	withHosts(t, "static")
+	oldExec := allowExec
+	allowExec = true
+	defer func() { allowExec = oldExec }()
+	do := func(r *http.Request) int {
+		rec := httptest.NewRecorder()
+		hostsHandler(rec, r)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do(httptest.NewRequest("GET", "/api/hosts", nil)), "listing needs no token")
+	assert.Equal(t, http.StatusForbidden, do(hostsRequest("POST", "/api/hosts", `{"host":"10.9.9.9"}`)), "off without -api-token")
+
+	withAPIToken(t)
+	assert.Equal(t, http.StatusUnauthorized, do(httptest.NewRequest("POST", "/api/hosts", strings.NewReader(`{"host":"10.9.9.9"}`))))
+	assert.Equal(t, http.StatusUnauthorized, do(httptest.NewRequest("DELETE", "/api/hosts?host=static", nil)))
+	assert.Equal(t, http.StatusUnauthorized, do(httptest.NewRequest("PATCH", "/api/hosts", strings.NewReader(`{"host":"static","enabled":false}`))))
+
+	// Not even with -allow-exec
+	assert.Equal(t, http.StatusForbidden, do(hostsRequest("POST", "/api/hosts", `{"host":"exec:///bin/true"}`)))
+	assert.Equal(t, http.StatusForbidden, do(hostsRequest("POST", "/api/hosts", `{"host":"vpn=10.0.0.1|exec:///bin/true"}`)))
+	assert.Equal(t, []string{"static"}, currentHosts())
+}
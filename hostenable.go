@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// disabled reports whether o parks its host: the host stays configured with
+// its settings and history but is not probed.
+func (o HostOverride) disabled() bool {
+	return o.Enabled != nil && !*o.Enabled
+}
+
+// enabledHosts returns the hosts of hosts that are not disabled, in order.
+func enabledHosts(hosts []string) []string {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	out := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if !hostOverrides[h].disabled() {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// markDisabled returns the statuses to show for hosts: statuses, with the
+// disabled hosts marked as such, keeping their last result if they have
+// one. Disabled hosts are paused too, so they count nowhere a paused host
+// does not.
+func markDisabled(hosts []string, statuses []HostStatus) []HostStatus {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	parked := false
+	for _, o := range hostOverrides {
+		parked = parked || o.disabled()
+	}
+	if !parked {
+		return statuses
+	}
+	latest := make(map[string]HostStatus, len(statuses))
+	for _, s := range statuses {
+		latest[s.Host] = s
+	}
+	out := make([]HostStatus, 0, len(hosts))
+	for _, h := range hosts {
+		s, ok := latest[h]
+		if hostOverrides[h].disabled() {
+			s = HostStatus{Host: h, Name: s.Name, Paused: true, Disabled: true, LatencyMs: s.LatencyMs, PacketLoss: s.PacketLoss, Detail: "disabled"}
+		} else if !ok {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// setEnabled enables or disables a monitored host. With -persist-hosts and
+// -config, the change is saved to the overrides of the config file first,
+// unless the host was added at runtime.
+//
+// Parameters:
+//   - host: The monitored host
+//   - enabled: False to park the host, true to probe it again
+//
+// Returns:
+//   - error: If host is not monitored, or wraps errPersist if the config
+//     file cannot be written
+func setEnabled(host string, enabled bool) error {
+	hostsMu.RLock()
+	found := false
+	for _, h := range hosts {
+		found = found || h == host
+	}
+	_, dynamic := dynamicHosts[host]
+	hostsMu.RUnlock()
+	if !found {
+		return fmt.Errorf("%s is not monitored", host)
+	}
+	if persistHosts && configFile != "" && !dynamic {
+		if err := editConfigEnabled(host, enabled); err != nil {
+			return err
+		}
+	}
+	o := overrides()
+	ov := o[host]
+	ov.Enabled = nil
+	if !enabled {
+		ov.Enabled = &enabled
+	}
+	if ov == (HostOverride{}) {
+		delete(o, host)
+	} else {
+		o[host] = ov
+	}
+	setOverrides(o)
+	return nil
+}
+
+// editConfigEnabled sets "enabled: false" in the overrides of host in the
+// -config file, or removes it again.
+func editConfigEnabled(host string, enabled bool) error {
+	data, err := readFileForEdit(configFile)
+	if err != nil {
+		return err
+	}
+	data, err = editYAML(data, func(root *yaml.Node) error {
+		setConfigEnabled(root, host, enabled)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s in %s: %v", errPersist, host, configFile, err)
+	}
+	if err := writeFileAtomic(configFile, data); err != nil {
+		return fmt.Errorf("%w: %v", errPersist, err)
+	}
+	return nil
+}
+
+// setConfigEnabled edits the config mapping root: it adds "enabled: false"
+// to the overrides of host, or removes it along with overrides left empty.
+func setConfigEnabled(root *yaml.Node, host string, enabled bool) {
+	m := mappingValue(root, "overrides")
+	if m == nil && enabled {
+		return
+	}
+	if m == nil {
+		m = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "overrides"}, m)
+	}
+	o := mappingValue(m, host)
+	switch {
+	case o == nil && enabled:
+	case o == nil:
+		o = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Style: yaml.FlowStyle}
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: host}, o)
+		fallthrough
+	case !enabled:
+		removeNode(o, "enabled", 2)
+		o.Content = append(o.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "enabled"}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false"})
+	default:
+		removeNode(o, "enabled", 2)
+		if len(o.Content) == 0 {
+			removeNode(m, host, 2)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMarkDisabled(t *testing.T) {
+	defer setOverrides(overrides())
+	off := false
+	setOverrides(map[string]HostOverride{"lab01": {Enabled: &off, Interval: "10s"}, "lab02": {Enabled: &off}})
+	hosts := []string{"router", "lab01", "lab02"}
+	assert.Equal(t, []string{"router"}, enabledHosts(hosts))
+
+	statuses := markDisabled(hosts, []HostStatus{{Host: "router", Alive: true}, {Host: "lab01", Alive: true, LatencyMs: 7}})
+	assert.Equal(t, []HostStatus{
+		{Host: "router", Alive: true},
+		{Host: "lab01", Paused: true, Disabled: true, LatencyMs: 7, Detail: "disabled"},
+		{Host: "lab02", Paused: true, Disabled: true, Detail: "disabled"},
+	}, statuses, "disabled hosts keep their last result and show without one")
+}
+
+func TestSetConfigEnabled(t *testing.T) {
+	config := `hosts: [router, lab01]
+overrides:
+  lab01: {interval: 10s} # slow link
+`
+	disable := func(root string, host string, enabled bool) string {
+		data, err := editYAML([]byte(root), func(n *yaml.Node) error {
+			setConfigEnabled(n, host, enabled)
+			return nil
+		})
+		assert.NoError(t, err)
+		return string(data)
+	}
+	off := disable(config, "lab01", false)
+	assert.Contains(t, off, "lab01: {interval: 10s, enabled: false} # slow link")
+	assert.Equal(t, config, disable(off, "lab01", true), "enabling undoes disabling")
+
+	off = disable(config, "router", false)
+	fc, err := readConfigFile(writeConfigFile(t, off))
+	assert.NoError(t, err)
+	assert.True(t, fc.Overrides["router"].disabled())
+	assert.Equal(t, config, disable(off, "router", true), "empty overrides go")
+}
+
+func TestHostsHandlerEnables(t *testing.T) {
+	defer setOverrides(overrides())
+	setOverrides(nil)
+	withHosts(t, "router", "lab01")
+	withAPIToken(t)
+	path := writeConfigFile(t, "hosts: [router, lab01]\n")
+	old, oldPersist := configFile, persistHosts
+	configFile, persistHosts = path, true
+	defer func() { configFile, persistHosts = old, oldPersist }()
+	do := func(body string) int {
+		rec := httptest.NewRecorder()
+		hostsHandler(rec, hostsRequest("PATCH", "/api/hosts", body))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do(`{"host":"lab01","enabled":false}`))
+	assert.Equal(t, []HostEntry{{Host: "router"}, {Host: "lab01", Disabled: true}}, hostEntries())
+	data, _ := os.ReadFile(path)
+	assert.Contains(t, string(data), "enabled: false", "the config keeps the host parked")
+
+	assert.Equal(t, http.StatusOK, do(`{"host":"lab01","enabled":true}`))
+	assert.Equal(t, []HostEntry{{Host: "router"}, {Host: "lab01"}}, hostEntries())
+	assert.Empty(t, overrides())
+
+	assert.Equal(t, http.StatusNotFound, do(`{"host":"nope","enabled":false}`))
+	assert.Equal(t, http.StatusBadRequest, do(`{"host":"lab01"}`))
+}
@@ -0,0 +1,51 @@
+package main
+
+import "regexp"
+
+// hostInclude and hostExclude filter the hosts read from -file, -hosts and
+// -config, set with -include and -exclude, so one inventory can feed
+// several mosaic instances. Only hosts matching hostInclude are kept, then
+// those matching hostExclude dropped. Nil patterns filter nothing.
+var hostInclude, hostExclude *regexp.Regexp
+
+// filterHosts returns the hosts of list that pass hostInclude and
+// hostExclude. CIDR and range entries are filtered by the addresses they
+// expand to, so they must be expanded first.
+func filterHosts(list []string) []string {
+	if hostInclude == nil && hostExclude == nil {
+		return list
+	}
+	kept := make([]string, 0, len(list))
+	for _, h := range list {
+		if hostInclude != nil && !hostInclude.MatchString(h) {
+			continue
+		}
+		if hostExclude != nil && hostExclude.MatchString(h) {
+			continue
+		}
+		kept = append(kept, h)
+	}
+	return kept
+}
+
+// patternFlag returns a flag.Func setter that compiles its value into re.
+func patternFlag(re **regexp.Regexp) func(string) error {
+	return func(s string) error {
+		compiled, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		*re = compiled
+		return nil
+	}
+}
+
+// intakeHosts expands the CIDR and range entries of list and filters the
+// result with -include and -exclude.
+func intakeHosts(list []string) ([]string, error) {
+	expanded, err := expandHosts(list)
+	if err != nil {
+		return nil, err
+	}
+	return filterHosts(expanded), nil
+}
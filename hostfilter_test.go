@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withHostFilters sets -include and -exclude, "" for none.
+func withHostFilters(t *testing.T, include, exclude string) {
+	t.Helper()
+	oldInclude, oldExclude := hostInclude, hostExclude
+	hostInclude, hostExclude = nil, nil
+	if include != "" {
+		hostInclude = regexp.MustCompile(include)
+	}
+	if exclude != "" {
+		hostExclude = regexp.MustCompile(exclude)
+	}
+	t.Cleanup(func() { hostInclude, hostExclude = oldInclude, oldExclude })
+}
+
+func TestFilterHosts(t *testing.T) {
+	list := []string{"web01", "web02", "lab-web03", "db01:5432"}
+	withHostFilters(t, "", "")
+	assert.Equal(t, list, filterHosts(list))
+
+	withHostFilters(t, "web", "")
+	assert.Equal(t, []string{"web01", "web02", "lab-web03"}, filterHosts(list))
+
+	withHostFilters(t, "web", "^lab-")
+	assert.Equal(t, []string{"web01", "web02"}, filterHosts(list))
+
+	withHostFilters(t, "", ":5432$")
+	assert.Equal(t, []string{"web01", "web02", "lab-web03"}, filterHosts(list))
+}
+
+func TestReadHostsFilters(t *testing.T) {
+	withHostFilters(t, `^10\.0\.5\.`, `\.2$`)
+	hosts, _, err := readHosts("", "10.0.5.0/29,10.0.6.1,router")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.5.1", "10.0.5.3", "10.0.5.4", "10.0.5.5", "10.0.5.6"}, hosts, "CIDR entries are filtered by address")
+}
+
+func TestPatternFlag(t *testing.T) {
+	var re *regexp.Regexp
+	set := patternFlag(&re)
+	assert.NoError(t, set("^web"))
+	assert.True(t, re.MatchString("web01"))
+	assert.Error(t, set("(unclosed"))
+}
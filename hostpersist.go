@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// persistHosts makes hosts added through /api/hosts without a TTL permanent
+// by writing them to -file, or to -config without one, set with
+// -persist-hosts. Configured hosts can then be removed through the API too.
+var persistHosts bool
+
+var (
+	// errNotListed means a host is monitored but not listed in the hosts
+	// file by itself, e.g. because it came from a CIDR entry or -hosts.
+	errNotListed = errors.New("not listed")
+	// errPersist means the hosts file could not be written.
+	errPersist = errors.New("cannot save hosts")
+)
+
+// hostsSource returns the file hosts added through the API are written to.
+func hostsSource() string {
+	if hostsFile != "" {
+		return hostsFile
+	}
+	return configFile
+}
+
+// keepHost starts monitoring host permanently: it is written to the hosts
+// file first and then monitored as if it had been listed there. A host that
+// was added with a TTL loses it.
+//
+// Returns:
+//   - error: If the host is invalid or already configured, or wraps
+//     errPersist if the file cannot be written
+func keepHost(host string) error {
+	if err := validateHost(host); err != nil {
+		return err
+	}
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	_, dynamic := dynamicHosts[host]
+	if !dynamic {
+		for _, h := range hosts {
+			if h == host {
+				return fmt.Errorf("%s is already monitored", host)
+			}
+		}
+	}
+	if err := editHostsSource(host, true); err != nil {
+		return err
+	}
+	if dynamic {
+		delete(dynamicHosts, host)
+	} else {
+		hosts = append(hosts, host)
+	}
+	return nil
+}
+
+// unkeepHost removes a configured host from the hosts file and stops
+// monitoring it, dropping its thresholds, per-host settings, label and
+// dependencies.
+//
+// Returns:
+//   - error: Wraps errNotListed if host cannot be removed from the file
+//     alone, or errPersist if the file cannot be written
+func unkeepHost(host string) error {
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	found := false
+	for _, h := range hosts {
+		found = found || h == host
+	}
+	if !found {
+		return fmt.Errorf("%s is not monitored", host)
+	}
+	if err := editHostsSource(host, false); err != nil {
+		return err
+	}
+	dropHostLocked(host)
+	th := advisor.thresholds()
+	delete(th, host)
+	advisor.setThresholds(th)
+	o := overrides()
+	delete(o, host)
+	setOverrides(o)
+	l := labels()
+	delete(l, host)
+	setLabels(l)
+	p := hostStates.dependencies()
+	for child, parent := range p {
+		if child == host || parent == host {
+			delete(p, child)
+		}
+	}
+	hostStates.setDependencies(p)
+	return nil
+}
+
+// editHostsSource adds host to or removes it from the file returned by
+// hostsSource: a line of -file, or an entry of the hosts of -config.
+func editHostsSource(host string, add bool) error {
+	path := hostsSource()
+	if path == "" {
+		return fmt.Errorf("%w: no -file or -config", errPersist)
+	}
+	data, err := readFileForEdit(path)
+	if err != nil {
+		return err
+	}
+	if path == hostsFile {
+		data, err = editHostLines(data, host, add)
+	} else {
+		data, err = editConfigHosts(data, host, add)
+	}
+	if err != nil {
+		return fmt.Errorf("%s in %s: %w", host, path, err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("%w: %v", errPersist, err)
+	}
+	return nil
+}
+
+// readFileForEdit reads a file that is about to be edited.
+//
+// Returns:
+//   - error: Wraps errPersist if the file cannot be read
+func readFileForEdit(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errPersist, err)
+	}
+	return data, nil
+}
+
+// editHostLines appends host as a line of a hosts file, or removes the
+// lines that list it, also those in /etc/hosts format.
+func editHostLines(data []byte, host string, add bool) ([]byte, error) {
+	if add {
+		if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+			data = append(data, '\n')
+		}
+		return append(data, host+"\n"...), nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if h, _ := parseHostLine(line); h != host {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(lines) {
+		return nil, errNotListed
+	}
+	return []byte(strings.Join(kept, "")), nil
+}
+
+// editConfigHosts appends host to the hosts of a -config file, or removes
+// it from them together with its thresholds, overrides, label and
+// dependencies. Comments and the order of keys are kept.
+func editConfigHosts(data []byte, host string, add bool) ([]byte, error) {
+	return editYAML(data, func(root *yaml.Node) error {
+		return editConfigHostsNode(root, host, add)
+	})
+}
+
+// editConfigHostsNode is editConfigHosts on the config mapping root.
+func editConfigHostsNode(root *yaml.Node, host string, add bool) error {
+	list := mappingValue(root, "hosts")
+	switch {
+	case add && list == nil:
+		list = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "hosts"}, list)
+		fallthrough
+	case add:
+		list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: host})
+	case list == nil || !removeNode(list, host, 1):
+		return errNotListed
+	default:
+		for _, key := range []string{"thresholds", "overrides", "labels", "parents"} {
+			if m := mappingValue(root, key); m != nil {
+				removeNode(m, host, 2)
+			}
+		}
+		// Hosts that depended on it no longer have a parent
+		if m := mappingValue(root, "parents"); m != nil {
+			for i := 0; i+1 < len(m.Content); {
+				if m.Content[i+1].Value == host {
+					m.Content = append(m.Content[:i], m.Content[i+2:]...)
+					continue
+				}
+				i += 2
+			}
+		}
+	}
+	return nil
+}
+
+// editYAML applies edit to the top-level mapping of the YAML document data.
+// Comments and the order of keys are kept.
+func editYAML(data []byte, edit func(root *yaml.Node) error) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("config is not a YAML mapping")
+	}
+	if err := edit(doc.Content[0]); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mappingValue returns the value of key in mapping node m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeNode removes the entries of node n whose first node has the value
+// value. Entries are width nodes long: 1 for sequences, 2 for mappings.
+//
+// Returns:
+//   - bool: False if nothing was removed
+func removeNode(n *yaml.Node, value string, width int) bool {
+	removed := false
+	for i := 0; i+width <= len(n.Content); {
+		if n.Content[i].Value == value {
+			n.Content = append(n.Content[:i], n.Content[i+width:]...)
+			removed = true
+			continue
+		}
+		i += width
+	}
+	return removed
+}
+
+// writeFileAtomic replaces path with data by renaming a temporary file over
+// it, so readers such as the -file watcher never see it half-written. The
+// file keeps its permissions.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
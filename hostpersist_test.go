@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withPersistedHosts turns on -persist-hosts with -file pointing at a
+// temporary file holding body.
+func withPersistedHosts(t *testing.T, body string) string {
+	t.Helper()
+	path := withHostsFile(t, body)
+	old := persistHosts
+	persistHosts = true
+	t.Cleanup(func() { persistHosts = old })
+	return path
+}
+
+func TestEditHostLines(t *testing.T) {
+	data, err := editHostLines([]byte("a\nb"), "c", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb\nc\n", string(data))
+
+	data, err = editHostLines([]byte("a\n  b  \nc\n"), "b", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nc\n", string(data))
+
+	data, err = editHostLines([]byte("# lab\n10.0.5.1 core-sw1 # rack 3\n10.0.5.2\n"), "10.0.5.1", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "# lab\n10.0.5.2\n", string(data), "/etc/hosts lines go by their address")
+
+	_, err = editHostLines([]byte("10.0.5.0/24\n"), "10.0.5.7", false)
+	assert.ErrorIs(t, err, errNotListed)
+}
+
+func TestEditConfigHosts(t *testing.T) {
+	config := `# Office network
+hosts:
+  - router # the gateway
+  - nas
+overrides:
+  nas: {interval: 10s}
+parents:
+  nas: router
+server:
+  listen: ":9090"
+`
+	data, err := editConfigHosts([]byte(config), "db01:5432", true)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "# Office network")
+	assert.Contains(t, string(data), "- router # the gateway")
+	assert.Contains(t, string(data), "- db01:5432\n")
+
+	data, err = editConfigHosts([]byte(config), "nas", false)
+	assert.NoError(t, err)
+	fc, err := readConfigFile(writeConfigFile(t, string(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"router"}, fc.Hosts)
+	assert.Empty(t, fc.Overrides, "settings of a removed host go too")
+	assert.Empty(t, fc.Parents)
+	assert.Equal(t, ":9090", fc.Server.Listen)
+
+	data, err = editConfigHosts([]byte(config), "router", false)
+	assert.NoError(t, err)
+	fc, err = readConfigFile(writeConfigFile(t, string(data)))
+	assert.NoError(t, err)
+	assert.Empty(t, fc.Parents, "hosts depending on a removed host lose their parent")
+
+	_, err = editConfigHosts([]byte(config), "db01", false)
+	assert.ErrorIs(t, err, errNotListed)
+
+	data, err = editConfigHosts([]byte("interval: 5s\n"), "router", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "interval: 5s\nhosts:\n  - router\n", string(data))
+}
+
+func TestHostsHandlerPersists(t *testing.T) {
+	withHosts(t, "a", "10.0.5.1")
+	withAPIToken(t)
+	path := withPersistedHosts(t, "a\n10.0.5.0/31\n")
+	do := func(method, target, body string) int {
+		rec := httptest.NewRecorder()
+		hostsHandler(rec, hostsRequest(method, target, body))
+		return rec.Code
+	}
+	file := func() string {
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+
+	assert.Equal(t, http.StatusCreated, do("POST", "/api/hosts", `{"host":"b"}`))
+	assert.Equal(t, "a\n10.0.5.0/31\nb\n", file())
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/hosts", `{"host":"c\nd"}`), "one host is one line")
+	assert.Equal(t, "a\n10.0.5.0/31\nb\n", file())
+	assert.Equal(t, []string{"a", "10.0.5.1", "b"}, currentHosts())
+	assert.Equal(t, []HostEntry{{Host: "a"}, {Host: "10.0.5.1"}, {Host: "b"}}, hostEntries(), "saved hosts are not runtime hosts")
+
+	// Hosts with a TTL stay runtime hosts
+	assert.Equal(t, http.StatusCreated, do("POST", "/api/hosts", `{"host":"lab","ttl":"1h"}`))
+	assert.Equal(t, "a\n10.0.5.0/31\nb\n", file())
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/hosts", `{"host":"b"}`))
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/hosts?host=a", ""))
+	assert.Equal(t, "10.0.5.0/31\nb\n", file())
+	assert.Equal(t, []string{"10.0.5.1", "b", "lab"}, currentHosts())
+	assert.Equal(t, http.StatusConflict, do("DELETE", "/api/hosts?host=10.0.5.1", ""), "part of a CIDR entry")
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/hosts?host=nope", ""))
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/hosts?host=lab", ""))
+	assert.Equal(t, "10.0.5.0/31\nb\n", file())
+
+	// A file that cannot be written leaves the hosts alone
+	hostsFile = filepath.Join(t.TempDir(), "missing", "hosts.txt")
+	assert.Equal(t, http.StatusInternalServerError, do("POST", "/api/hosts", `{"host":"c"}`))
+	assert.Equal(t, []string{"10.0.5.1", "b"}, currentHosts())
+}
+
+func TestWriteFileAtomicKeepsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("a\n"), 0o600))
+	assert.NoError(t, writeFileAtomic(path, []byte("b\n")))
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, entries, 1, "no temporary file left behind")
+}
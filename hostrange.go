@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// expandLimit caps how many hosts a single CIDR or range entry may expand
+// to, set with -expand-limit, so a mistyped prefix length does not start probing a
+// whole /8.
+var expandLimit = 1024
+
+// expandHosts replaces the CIDR entries of list, e.g. "10.0.5.0/24", and
+// the address ranges, e.g. "192.168.1.10-192.168.1.50" or "192.168.1.10-50",
+// by the addresses they contain. Addresses already listed elsewhere are
+// skipped, so a subnet can be given alongside some of its hosts.
+//
+// Parameters:
+//   - list: Host entries as read from -file and -hosts
+//
+// Returns:
+//   - []string: The entries with every CIDR and range expanded in place
+//   - error: If a CIDR or range holds more than expandLimit hosts or a
+//     range ends before it starts
+func expandHosts(list []string) ([]string, error) {
+	seen := make(map[string]bool, len(list))
+	for _, h := range list {
+		seen[h] = true
+	}
+	result := make([]string, 0, len(list))
+	for _, h := range list {
+		var addrs []string
+		var err error
+		if prefix, perr := netip.ParsePrefix(h); perr == nil {
+			addrs, err = expandCIDR(prefix, expandLimit)
+		} else if from, to, ok := parseRange(h); ok {
+			addrs, err = expandRange(from, to, expandLimit)
+		} else {
+			result = append(result, h)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if !seen[a] {
+				seen[a] = true
+				result = append(result, a)
+			}
+		}
+	}
+	return result, nil
+}
+
+// expandCIDR lists the host addresses of prefix. IPv4 subnets larger than
+// a /31 leave out their network and broadcast addresses.
+//
+// Parameters:
+//   - prefix: The subnet, host bits are ignored
+//   - limit: Most addresses to return
+//
+// Returns:
+//   - []string: The addresses in ascending order
+//   - error: If the subnet holds more than limit addresses
+func expandCIDR(prefix netip.Prefix, limit int) ([]string, error) {
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	size := uint64(1) << min(hostBits, 63)
+	skipEnds := prefix.Addr().Is4() && hostBits > 1
+	if skipEnds {
+		size -= 2
+	}
+	switch {
+	case hostBits > 62:
+		return nil, fmt.Errorf("%s is too large to expand", prefix)
+	case size > uint64(limit):
+		return nil, fmt.Errorf("%s expands to %d hosts, more than -expand-limit %d", prefix, size, limit)
+	}
+	addrs := make([]string, 0, size)
+	a := prefix.Addr()
+	if skipEnds {
+		a = a.Next()
+	}
+	for range size {
+		addrs = append(addrs, a.String())
+		a = a.Next()
+	}
+	return addrs, nil
+}
+
+// parseRange splits an address range such as "192.168.1.10-192.168.1.50".
+// For IPv4 the end may be given as its last octet only, as in
+// "192.168.1.10-50".
+//
+// Returns:
+//   - netip.Addr: First address of the range
+//   - netip.Addr: Last address of the range
+//   - bool: False if s is not an address range
+func parseRange(s string) (netip.Addr, netip.Addr, bool) {
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	from, err := netip.ParseAddr(first)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	to, err := netip.ParseAddr(last)
+	if err != nil && from.Is4() {
+		octet, oerr := strconv.ParseUint(last, 10, 8)
+		if oerr != nil {
+			return netip.Addr{}, netip.Addr{}, false
+		}
+		b := from.As4()
+		b[3] = byte(octet)
+		to, err = netip.AddrFrom4(b), nil
+	}
+	return from, to, err == nil
+}
+
+// expandRange lists the addresses from from to to, both included.
+//
+// Parameters:
+//   - from: First address
+//   - to: Last address, of the same family
+//   - limit: Most addresses to return
+//
+// Returns:
+//   - []string: The addresses in ascending order
+//   - error: If the range ends before it starts, mixes address families or
+//     holds more than limit addresses
+func expandRange(from, to netip.Addr, limit int) ([]string, error) {
+	if from.Is4() != to.Is4() || to.Less(from) {
+		return nil, fmt.Errorf("invalid range %s-%s: must go from a lower to a higher address of the same family", from, to)
+	}
+	var addrs []string
+	for a := from; ; a = a.Next() {
+		if len(addrs) == limit {
+			return nil, fmt.Errorf("%s-%s expands to more than -expand-limit %d hosts", from, to, limit)
+		}
+		addrs = append(addrs, a.String())
+		if a == to {
+			return addrs, nil
+		}
+	}
+}
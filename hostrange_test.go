@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandCIDR(t *testing.T) {
+	addrs, err := expandCIDR(netip.MustParsePrefix("10.0.5.0/29"), 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.5.1", "10.0.5.2", "10.0.5.3", "10.0.5.4", "10.0.5.5", "10.0.5.6"}, addrs)
+
+	addrs, err = expandCIDR(netip.MustParsePrefix("10.0.5.77/24"), 1024)
+	assert.NoError(t, err)
+	assert.Len(t, addrs, 254, "host bits are ignored, network and broadcast left out")
+	assert.Equal(t, "10.0.5.254", addrs[253])
+
+	addrs, err = expandCIDR(netip.MustParsePrefix("10.0.5.8/31"), 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.5.8", "10.0.5.9"}, addrs)
+
+	addrs, err = expandCIDR(netip.MustParsePrefix("2001:db8::/126"), 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}, addrs)
+
+	_, err = expandCIDR(netip.MustParsePrefix("10.0.0.0/16"), 1024)
+	assert.EqualError(t, err, "10.0.0.0/16 expands to 65534 hosts, more than -expand-limit 1024")
+	_, err = expandCIDR(netip.MustParsePrefix("2001:db8::/64"), 1024)
+	assert.Error(t, err)
+}
+
+func TestReadHostsExpandsCIDR(t *testing.T) {
+	hosts, _, err := readHosts("", "router,10.0.5.0/30,10.0.5.2,https://example.com/health")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"router", "10.0.5.1", "10.0.5.2", "https://example.com/health"}, hosts, "listed addresses are not repeated")
+
+	defer func(limit int) { expandLimit = limit }(expandLimit)
+	expandLimit = 100
+	_, _, err = readHosts("", "10.0.5.0/24")
+	assert.Error(t, err)
+}
+
+func TestParseRange(t *testing.T) {
+	from, to, ok := parseRange("192.168.1.10-192.168.1.50")
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.10", from.String())
+	assert.Equal(t, "192.168.1.50", to.String())
+
+	_, to, ok = parseRange("192.168.1.10-50")
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.50", to.String())
+
+	_, _, ok = parseRange("2001:db8::1-2001:db8::5")
+	assert.True(t, ok)
+	for _, s := range []string{"web-01", "192.168.1.10", "192.168.1.10-300", "192.168.1.10-db01", "2001:db8::1-5"} {
+		_, _, ok := parseRange(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestExpandRange(t *testing.T) {
+	r := func(s string) ([]string, error) {
+		from, to, _ := parseRange(s)
+		return expandRange(from, to, 100)
+	}
+	addrs, err := r("192.168.1.254-192.168.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.254", "192.168.1.255", "192.168.2.0", "192.168.2.1"}, addrs, "ranges cross subnet boundaries")
+
+	addrs, err = r("10.0.0.7-10.0.0.7")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.7"}, addrs)
+
+	addrs, err = r("10.0.0.1-100")
+	assert.NoError(t, err)
+	assert.Len(t, addrs, 100)
+	_, err = r("10.0.0.1-101")
+	assert.EqualError(t, err, "10.0.0.1-10.0.0.101 expands to more than -expand-limit 100 hosts")
+	_, err = r("10.0.0.50-10")
+	assert.Error(t, err)
+	_, err = r("10.0.0.1-2001:db8::1")
+	assert.Error(t, err)
+}
+
+func TestReadHostsExpandsRanges(t *testing.T) {
+	hosts, _, err := readHosts("", "192.168.1.10-12,web-01,192.168.1.11")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.10", "192.168.1.12", "web-01", "192.168.1.11"}, hosts)
+}
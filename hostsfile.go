@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// parseHostLine parses a line of -file. Besides one host entry per line, the
+// file may be in /etc/hosts format: an IP address followed by its names,
+// e.g. "10.0.5.1 core-sw1 core-sw1.lab". The address is probed and the
+// first name shown as its display name. Lines starting with "#" are
+// comments, as is everything after a "#" on a line in /etc/hosts format.
+//
+// Parameters:
+//   - line: A line of the hosts file
+//
+// Returns:
+//   - host: The host entry, or "" for blank and comment lines
+//   - name: The display name given in /etc/hosts format, or ""
+func parseHostLine(line string) (host, name string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", ""
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return line, ""
+	}
+	if _, err := netip.ParseAddr(fields[0]); err != nil {
+		// Not an address, e.g. an exec:// check with its arguments
+		return line, ""
+	}
+	if !strings.HasPrefix(fields[1], "#") {
+		name = fields[1]
+	}
+	return fields[0], name
+}
+
+// withFileLabels returns a copy of l in which hosts get the display name
+// and groups given for them in the hosts file or -inventory where their
+// label has none, so labels of -config win.
+func withFileLabels(l, file map[string]HostLabel) map[string]HostLabel {
+	out := make(map[string]HostLabel, len(l)+len(file))
+	for h, v := range l {
+		out[h] = v
+	}
+	for h, f := range file {
+		v := out[h]
+		if v.Name == "" {
+			v.Name = f.Name
+		}
+		if len(v.Groups) == 0 {
+			v.Groups = f.Groups
+		}
+		out[h] = v
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHostLine(t *testing.T) {
+	for line, want := range map[string][2]string{
+		"router":                           {"router", ""},
+		"  # lab hosts":                    {"", ""},
+		"":                                 {"", ""},
+		"10.0.5.1 core-sw1 core-sw1.lab":   {"10.0.5.1", "core-sw1"},
+		"10.0.5.2\tnas # backups":          {"10.0.5.2", "nas"},
+		"10.0.5.3 # not named":             {"10.0.5.3", ""},
+		"fe80::1%eth0 ap01":                {"fe80::1%eth0", "ap01"},
+		"exec:///usr/bin/check --site=ams": {"exec:///usr/bin/check --site=ams", ""},
+		"https://example.com/health":       {"https://example.com/health", ""},
+	} {
+		host, name := parseHostLine(line)
+		assert.Equal(t, want, [2]string{host, name}, line)
+	}
+}
+
+func TestReadHostsEtcHosts(t *testing.T) {
+	withHostFilters(t, "", "^127\\.")
+	path := filepath.Join(t.TempDir(), "hosts")
+	err := os.WriteFile(path, []byte(`# Lab network
+127.0.0.1	localhost
+10.0.5.1	core-sw1 core-sw1.lab
+10.0.5.2	nas	# backups
+10.0.5.1	switch
+printer
+`), 0o644)
+	assert.NoError(t, err)
+
+	hosts, fileLabels, err := readHosts(path, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.5.1", "10.0.5.2", "printer"}, hosts, "repeated addresses are probed once")
+	assert.Equal(t, map[string]HostLabel{"10.0.5.1": {Name: "core-sw1"}, "10.0.5.2": {Name: "nas"}}, fileLabels, "only hosts kept by the filters are named")
+}
+
+func TestWithFileLabels(t *testing.T) {
+	l := map[string]HostLabel{"10.0.5.1": {Name: "Core switch"}, "10.0.5.2": {Notes: "rack 3"}}
+	got := withFileLabels(l, map[string]HostLabel{"10.0.5.1": {Name: "core-sw1", Groups: []string{"core"}}, "10.0.5.2": {Name: "nas"}, "10.0.5.3": {Name: "ap01"}})
+	assert.Equal(t, map[string]HostLabel{
+		"10.0.5.1": {Name: "Core switch", Groups: []string{"core"}},
+		"10.0.5.2": {Name: "nas", Notes: "rack 3"},
+		"10.0.5.3": {Name: "ap01"},
+	}, got, "labels of -config win")
+	assert.Equal(t, HostLabel{Notes: "rack 3"}, l["10.0.5.2"], "l is not changed")
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// icmpShared sends ICMP probes through the shared engine instead of a
+// pro-bing pinger with its own socket per host, set with -icmp-shared.
+var icmpShared = true
+
+// icmpEngine sends the echo requests of all ICMP probes over one socket per
+// address family and hands every reply to the probe waiting for it. With
+// thousands of hosts this saves a socket, and the syscalls to open and close
+// it, per host and cycle. Requests are told apart by sequence number, a
+// token in the payload and the address the reply came from.
+type icmpEngine struct {
+	mu      sync.Mutex
+	conns   map[int]*icmp.PacketConn // By IP version
+	errs    map[int]error            // Why a family's socket could not be opened
+	id      int
+	token   [8]byte
+	seq     uint16
+	waiters map[uint16]*echoWaiter
+}
+
+// echoWaiter is an echo request waiting for its reply.
+type echoWaiter struct {
+	dst     string
+	sent    time.Time
+	replies chan time.Duration
+}
+
+var sharedICMP = newICMPEngine()
+
+// newICMPEngine creates an engine; sockets are opened on first use.
+func newICMPEngine() *icmpEngine {
+	e := &icmpEngine{
+		conns:   make(map[int]*icmp.PacketConn),
+		errs:    make(map[int]error),
+		id:      os.Getpid() & 0xffff,
+		waiters: make(map[uint16]*echoWaiter),
+	}
+	rand.Read(e.token[:])
+	return e
+}
+
+// conn returns the socket for IP version v, opening it on first use with
+// the current ping mode and -source and -dscp applied. A socket that fails
+// to open is not retried.
+func (e *icmpEngine) conn(v int) (*icmp.PacketConn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.conns[v]; ok {
+		return c, nil
+	}
+	if err, ok := e.errs[v]; ok {
+		return nil, err
+	}
+	c, err := e.listen(v)
+	if err != nil {
+		e.errs[v] = err
+		return nil, err
+	}
+	e.conns[v] = c
+	go e.read(c, v)
+	return c, nil
+}
+
+// close closes the engine's sockets, ending their readers. Probes started
+// afterwards get the error of a closed socket.
+func (e *icmpEngine) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for v, c := range e.conns {
+		c.Close()
+		delete(e.conns, v)
+		e.errs[v] = net.ErrClosed
+	}
+}
+
+// listen opens the socket for IP version v.
+func (e *icmpEngine) listen(v int) (*icmp.PacketConn, error) {
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("the shared ICMP engine is not supported on Windows")
+	}
+	network, addr := "ip4:icmp", "0.0.0.0"
+	if v == 6 {
+		network, addr = "ip6:ipv6-icmp", "::"
+	}
+	if !pingPrivileged() {
+		network = "udp4"
+		if v == 6 {
+			network = "udp6"
+		}
+	}
+	if ip := net.ParseIP(probeSource); ip != nil {
+		if (ip.To4() != nil) != (v == 4) {
+			return nil, fmt.Errorf("source %s is not an IPv%d address", probeSource, v)
+		}
+		addr = ip.String()
+	}
+	c, err := icmp.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if probeDSCP > 0 {
+		if v == 4 {
+			err = c.IPv4PacketConn().SetTOS(probeDSCP << 2)
+		} else {
+			err = c.IPv6PacketConn().SetTrafficClass(probeDSCP << 2)
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// usable reports whether a probe with the given interface, source address
+// and DSCP can go over the shared sockets: only if it has no interface of
+// its own and uses the global source and DSCP the sockets were opened with.
+func (e *icmpEngine) usable(iface, source string, dscp int) bool {
+	return icmpShared && iface == "" && (source == "" || source == probeSource) && dscp == probeDSCP
+}
+
+// ping sends count echo requests of size payload bytes to dst, one every
+// interval, and waits until all are answered or timeout has passed since
+// the first was sent, like a pro-bing pinger.
+//
+// Returns:
+//   - probeResult: Packet counters and average round-trip time
+//   - error: If the socket for dst's address family cannot be opened
+func (e *icmpEngine) ping(dst net.IP, count, size int, interval, timeout time.Duration) (probeResult, error) {
+	v := 4
+	if dst.To4() == nil {
+		v = 6
+	}
+	c, err := e.conn(v)
+	if err != nil {
+		return probeResult{}, err
+	}
+	var to net.Addr = &net.IPAddr{IP: dst}
+	if !pingPrivileged() {
+		to = &net.UDPAddr{IP: dst}
+	}
+	if size < len(e.token) {
+		size = len(e.token)
+	}
+	payload := make([]byte, size)
+	copy(payload, e.token[:])
+
+	replies := make(chan time.Duration, count)
+	var seqs []uint16
+	defer func() {
+		e.mu.Lock()
+		for _, s := range seqs {
+			delete(e.waiters, s)
+		}
+		e.mu.Unlock()
+	}()
+
+	var res probeResult
+	var total time.Duration
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	send := func() error {
+		seq, b, err := e.echo(dst, payload, v, replies)
+		if err != nil {
+			return err
+		}
+		seqs = append(seqs, seq)
+		if _, err := c.WriteTo(b, to); err != nil {
+			res.Detail = err.Error()
+		}
+		res.Sent++
+		return nil
+	}
+	if err := send(); err != nil {
+		return probeResult{}, err
+	}
+wait:
+	for res.Recv < count {
+		select {
+		case rtt := <-replies:
+			res.Recv++
+			total += rtt
+		case <-tick.C:
+			if res.Sent < count {
+				if err := send(); err != nil {
+					res.Detail = err.Error()
+				}
+			}
+		case <-deadline.C:
+			break wait
+		}
+	}
+	if res.Recv > 0 {
+		res.Latency = total / time.Duration(res.Recv)
+	}
+	return res, nil
+}
+
+// errSeqExhausted is returned by echo when every sequence number is
+// waiting for a reply.
+var errSeqExhausted = errors.New("ICMP sequence space exhausted")
+
+// echo registers an echo request to dst and returns its sequence number and
+// marshalled message, or errSeqExhausted if no sequence number is free.
+func (e *icmpEngine) echo(dst net.IP, payload []byte, v int, replies chan time.Duration) (uint16, []byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Skip sequence numbers still waiting for a reply, up to one full wrap
+	e.seq++
+	for tries := 1; e.waiters[e.seq] != nil; tries++ {
+		if tries > math.MaxUint16 {
+			return 0, nil, errSeqExhausted
+		}
+		e.seq++
+	}
+	seq := e.seq
+	e.waiters[seq] = &echoWaiter{dst: dst.String(), sent: time.Now(), replies: replies}
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if v == 6 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	b, _ := (&icmp.Message{Type: typ, Body: &icmp.Echo{ID: e.id, Seq: int(seq), Data: payload}}).Marshal(nil)
+	return seq, b, nil
+}
+
+// read hands the echo replies read from c to their waiters until c fails.
+func (e *icmpEngine) read(c *icmp.PacketConn, v int) {
+	proto := 1
+	if v == 6 {
+		proto = 58
+	}
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		m, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || (m.Type != ipv4.ICMPTypeEchoReply && m.Type != ipv6.ICMPTypeEchoReply) {
+			continue
+		}
+		echo, ok := m.Body.(*icmp.Echo)
+		if !ok || len(echo.Data) < len(e.token) || string(echo.Data[:len(e.token)]) != string(e.token[:]) {
+			continue
+		}
+		e.deliver(uint16(echo.Seq), echo.ID, addrIP(from), now)
+	}
+}
+
+// deliver passes the round-trip time of the reply to sequence number seq
+// from src to its waiter. Unprivileged sockets get their identifier from
+// the kernel, so it is only checked on raw sockets.
+func (e *icmpEngine) deliver(seq uint16, id int, src net.IP, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w := e.waiters[seq]
+	if w == nil || w.dst != src.String() || (pingPrivileged() && id != e.id) {
+		return
+	}
+	delete(e.waiters, seq)
+	select {
+	case w.replies <- now.Sub(w.sent):
+	default:
+	}
+}
+
+// addrIP returns the IP address of a socket address.
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/icmp"
+)
+
+func TestICMPEngineMatchesReplies(t *testing.T) {
+	e := newICMPEngine()
+	dst := net.ParseIP("192.0.2.7")
+	replies := make(chan time.Duration, 2)
+	seq, b, err := e.echo(dst, make([]byte, 24), 4, replies)
+	assert.NoError(t, err)
+
+	m, err := icmp.ParseMessage(1, b)
+	assert.NoError(t, err)
+	echo := m.Body.(*icmp.Echo)
+	assert.Equal(t, int(seq), echo.Seq)
+	assert.Equal(t, e.id, echo.ID)
+
+	// Sequence numbers in flight are not handed out twice
+	seq2, _, _ := e.echo(dst, make([]byte, 24), 4, replies)
+	assert.NotEqual(t, seq, seq2)
+
+	// Replies from another address are not ours
+	e.deliver(seq, e.id, net.ParseIP("192.0.2.8"), time.Now())
+	assert.Len(t, replies, 0)
+	e.deliver(seq, e.id, dst, time.Now())
+	assert.Len(t, replies, 1)
+	// A duplicate reply is dropped
+	e.deliver(seq, e.id, dst, time.Now())
+	assert.Len(t, replies, 1)
+}
+
+func TestICMPEngineSeqExhausted(t *testing.T) {
+	e := newICMPEngine()
+	dst := net.ParseIP("192.0.2.7")
+	for i := 0; i <= math.MaxUint16; i++ {
+		_, _, err := e.echo(dst, nil, 4, nil)
+		assert.NoError(t, err)
+	}
+	_, _, err := e.echo(dst, nil, 4, nil)
+	assert.ErrorIs(t, err, errSeqExhausted, "all 65536 sequence numbers are in flight")
+
+	// A finished ping frees its sequence numbers
+	delete(e.waiters, 42)
+	seq, _, err := e.echo(dst, nil, 4, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(42), seq)
+}
+
+func TestICMPEngineUsable(t *testing.T) {
+	defer func(s string, d int, on bool) { probeSource, probeDSCP, icmpShared = s, d, on }(probeSource, probeDSCP, icmpShared)
+	probeSource, probeDSCP, icmpShared = "", 0, true
+	assert.True(t, sharedICMP.usable("", "", 0))
+	assert.False(t, sharedICMP.usable("wg0", "", 0), "tunnel hosts need their own socket")
+	assert.False(t, sharedICMP.usable("", "192.0.2.10", 0))
+	assert.False(t, sharedICMP.usable("", "", 46))
+	probeSource = "192.0.2.10"
+	assert.True(t, sharedICMP.usable("", "192.0.2.10", 0))
+	icmpShared = false
+	assert.False(t, sharedICMP.usable("", "", 0))
+}
+
+func TestICMPEnginePingLoopback(t *testing.T) {
+	e := newICMPEngine()
+	res, err := e.ping(net.ParseIP("127.0.0.1"), 2, minProbeSize, 10*time.Millisecond, time.Second)
+	if err != nil {
+		t.Skipf("no ICMP socket: %v", err)
+	}
+	assert.Equal(t, 2, res.Sent)
+	assert.Equal(t, 2, res.Recv)
+	assert.Greater(t, res.Latency, time.Duration(0))
+	e.mu.Lock()
+	assert.Empty(t, e.waiters, "answered requests are forgotten")
+	e.mu.Unlock()
+}
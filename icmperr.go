@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Reasons a host did not answer, reported in HostStatus.Reason.
+const (
+	reasonTimeout          = "timeout"
+	reasonNetUnreachable   = "net_unreachable"
+	reasonHostUnreachable  = "host_unreachable"
+	reasonProtoUnreachable = "protocol_unreachable"
+	reasonPortUnreachable  = "port_unreachable"
+	reasonAdminProhibited  = "admin_prohibited"
+	reasonTTLExceeded      = "ttl_exceeded"
+)
+
+// icmpErrorRetention is how long an ICMP error is kept for lookup.
+const icmpErrorRetention = time.Minute
+
+// icmpErrorLog remembers the latest ICMP error received for each
+// destination. pro-bing only looks at echo replies, so a separate raw socket
+// picks up the unreachable and time exceeded messages routers send back.
+type icmpErrorLog struct {
+	mu     sync.Mutex
+	once   sync.Once
+	errors map[string]icmpErrorRecord
+}
+
+// icmpErrorRecord is one ICMP error about a destination.
+type icmpErrorRecord struct {
+	reason string
+	from   string // Router or host that sent the error
+	at     time.Time
+}
+
+var icmpErrors = &icmpErrorLog{errors: make(map[string]icmpErrorRecord)}
+
+// start begins listening for ICMP errors, once. Without raw socket
+// privileges there is nothing to listen on and every loss is a timeout.
+func (l *icmpErrorLog) start() {
+	l.once.Do(func() {
+		if c, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0"); err == nil {
+			go l.listen(c, 1)
+		}
+		if c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::"); err == nil {
+			go l.listen(c, 58)
+		}
+	})
+}
+
+// listen records every ICMP error read from c until it fails.
+func (l *icmpErrorLog) listen(c *icmp.PacketConn, proto int) {
+	defer c.Close()
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := c.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		m, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		if dst, reason, ok := classifyICMP(m); ok {
+			l.record(dst, reason, from.String(), time.Now())
+		}
+	}
+}
+
+// record stores an ICMP error about dst and forgets stale ones.
+func (l *icmpErrorLog) record(dst net.IP, reason, from string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors[dst.String()] = icmpErrorRecord{reason: reason, from: from, at: now}
+	for k, r := range l.errors {
+		if now.Sub(r.at) > icmpErrorRetention {
+			delete(l.errors, k)
+		}
+	}
+}
+
+// lookup returns the latest ICMP error about dst received after since.
+func (l *icmpErrorLog) lookup(dst net.IP, since time.Time) (icmpErrorRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.errors[dst.String()]
+	if !ok || r.at.Before(since) {
+		return icmpErrorRecord{}, false
+	}
+	return r, true
+}
+
+// classifyICMP maps an ICMP error message to the destination of the packet
+// that triggered it and the reason it was not delivered.
+func classifyICMP(m *icmp.Message) (net.IP, string, bool) {
+	var data []byte
+	switch b := m.Body.(type) {
+	case *icmp.DstUnreach:
+		data = b.Data
+	case *icmp.TimeExceeded:
+		data = b.Data
+	default:
+		return nil, "", false
+	}
+	var reason string
+	switch m.Type {
+	case ipv4.ICMPTypeDestinationUnreachable:
+		switch m.Code {
+		case 0:
+			reason = reasonNetUnreachable
+		case 1:
+			reason = reasonHostUnreachable
+		case 2:
+			reason = reasonProtoUnreachable
+		case 3:
+			reason = reasonPortUnreachable
+		case 9, 10, 13:
+			reason = reasonAdminProhibited
+		default:
+			reason = reasonHostUnreachable
+		}
+	case ipv4.ICMPTypeTimeExceeded:
+		reason = reasonTTLExceeded
+	case ipv6.ICMPTypeDestinationUnreachable:
+		switch m.Code {
+		case 0:
+			reason = reasonNetUnreachable
+		case 1, 5, 6:
+			reason = reasonAdminProhibited
+		case 4:
+			reason = reasonPortUnreachable
+		default:
+			reason = reasonHostUnreachable
+		}
+	case ipv6.ICMPTypeTimeExceeded:
+		reason = reasonTTLExceeded
+	default:
+		return nil, "", false
+	}
+	// The error quotes the header of the undeliverable packet
+	switch {
+	case len(data) >= 20 && data[0]>>4 == 4:
+		return net.IP(data[16:20]), reason, true
+	case len(data) >= 40 && data[0]>>4 == 6:
+		return net.IP(data[24:40]), reason, true
+	}
+	return nil, "", false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ping "github.com/prometheus-community/pro-bing"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// quotedHeader returns the start of an IP packet to dst as quoted in ICMP errors.
+func quotedHeader(dst net.IP) []byte {
+	if v4 := dst.To4(); v4 != nil {
+		h := make([]byte, 28)
+		h[0] = 0x45
+		copy(h[16:20], v4)
+		return h
+	}
+	h := make([]byte, 48)
+	h[0] = 0x60
+	copy(h[24:40], dst)
+	return h
+}
+
+func TestClassifyICMP(t *testing.T) {
+	target := net.ParseIP("192.0.2.7")
+	cases := []struct {
+		typ    icmp.Type
+		code   int
+		reason string
+	}{
+		{ipv4.ICMPTypeDestinationUnreachable, 0, reasonNetUnreachable},
+		{ipv4.ICMPTypeDestinationUnreachable, 1, reasonHostUnreachable},
+		{ipv4.ICMPTypeDestinationUnreachable, 13, reasonAdminProhibited},
+		{ipv4.ICMPTypeDestinationUnreachable, 3, reasonPortUnreachable},
+	}
+	for _, c := range cases {
+		dst, reason, ok := classifyICMP(&icmp.Message{Type: c.typ, Code: c.code, Body: &icmp.DstUnreach{Data: quotedHeader(target)}})
+		assert.True(t, ok)
+		assert.Equal(t, c.reason, reason)
+		assert.True(t, dst.Equal(target))
+	}
+
+	dst, reason, ok := classifyICMP(&icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quotedHeader(target)}})
+	assert.True(t, ok)
+	assert.Equal(t, reasonTTLExceeded, reason)
+	assert.True(t, dst.Equal(target))
+
+	v6 := net.ParseIP("2001:db8::7")
+	dst, reason, ok = classifyICMP(&icmp.Message{Type: ipv6.ICMPTypeDestinationUnreachable, Code: 1, Body: &icmp.DstUnreach{Data: quotedHeader(v6)}})
+	assert.True(t, ok)
+	assert.Equal(t, reasonAdminProhibited, reason)
+	assert.True(t, dst.Equal(v6))
+
+	_, _, ok = classifyICMP(&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{}})
+	assert.False(t, ok)
+	_, _, ok = classifyICMP(&icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Body: &icmp.DstUnreach{Data: []byte{0x45}}})
+	assert.False(t, ok)
+}
+
+func TestICMPErrorLog(t *testing.T) {
+	l := &icmpErrorLog{errors: make(map[string]icmpErrorRecord)}
+	now := time.Now()
+	target := net.ParseIP("192.0.2.7")
+	l.record(target, reasonAdminProhibited, "192.0.2.1", now)
+
+	r, ok := l.lookup(target, now.Add(-time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, reasonAdminProhibited, r.reason)
+	assert.Equal(t, "192.0.2.1", r.from)
+
+	// Errors from before the probe started belong to an earlier probe
+	_, ok = l.lookup(target, now.Add(time.Second))
+	assert.False(t, ok)
+
+	l.record(net.ParseIP("192.0.2.8"), reasonTimeout, "x", now.Add(2*icmpErrorRetention))
+	_, ok = l.lookup(target, time.Time{})
+	assert.False(t, ok, "stale errors are dropped")
+}
+
+func TestPingHostTimeoutReason(t *testing.T) {
+	oldNewPinger := newPinger
+	defer func() { newPinger = oldNewPinger }()
+	mockPing := new(MockPinger)
+	mockPing.On("Run").Return(nil)
+	mockPing.On("SetPrivileged", true).Return()
+	mockPing.On("Statistics").Return(&ping.Statistics{PacketsSent: 3})
+	newPinger = func(addr string) Pinger { return mockPing }
+	defer func() {
+		hostStatsMu.Lock()
+		delete(hostStats, "silent-host")
+		hostStatsMu.Unlock()
+	}()
+
+	status := pingHost("silent-host")
+	assert.False(t, status.Alive)
+	assert.Equal(t, reasonTimeout, status.Reason)
+}
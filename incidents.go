@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Incident is an outage of a host found in its stored results, as served by
+// /api/incidents.
+type Incident struct {
+	Host            string     `json:"host"`
+	Start           time.Time  `json:"start"`            // Time of the first result of the outage
+	End             *time.Time `json:"end,omitempty"`    // Time of the first result after it, nil while it lasts
+	Ongoing         bool       `json:"ongoing"`          // Whether the outage lasts past the last result in the range
+	DurationSeconds float64    `json:"duration_seconds"` // Until End, or until the last result of the outage while it lasts
+	MaxLoss         float64    `json:"max_loss"`         // Highest packet loss percentage of its results
+	Results         int        `json:"results"`          // Stored results it spans
+}
+
+// findIncidents returns the outages of host, or of all hosts if empty, in
+// the results of store in [since, until), newest first. An outage is a run
+// of results with at least minLoss percent packet loss; it ends with the
+// next result with less. Only hosts for which keep returns true are looked
+// at.
+//
+// Parameters:
+//   - store: The history to search
+//   - host: The host whose outages to return, empty for all hosts
+//   - since, until: The range of results to search
+//   - minLoss: Packet loss percentage from which a result counts as an outage (0-100]
+//   - keep: Whether to look at a host
+//
+// Returns:
+//   - []Incident: The outages, newest first
+//   - error: If the history cannot be read
+func findIncidents(store historyStore, host string, since, until time.Time, minLoss float64, keep func(string) bool) ([]Incident, error) {
+	incidents := []Incident{}
+	var open *Incident
+	var last time.Time // Time of the last result of open
+	finish := func(end *time.Time) {
+		if open == nil {
+			return
+		}
+		open.End, open.Ongoing = end, end == nil
+		if end == nil {
+			open.DurationSeconds = last.Sub(open.Start).Seconds()
+		} else {
+			open.DurationSeconds = end.Sub(open.Start).Seconds()
+		}
+		incidents = append(incidents, *open)
+		open = nil
+	}
+	err := store.each(host, since, until, func(r HistoryResult) error {
+		if open != nil && open.Host != r.Host {
+			finish(nil)
+		}
+		if !keep(r.Host) {
+			return nil
+		}
+		if r.PacketLoss < minLoss {
+			if open != nil {
+				end := r.Time
+				finish(&end)
+			}
+			return nil
+		}
+		if open == nil {
+			open = &Incident{Host: r.Host, Start: r.Time}
+		}
+		open.MaxLoss = max(open.MaxLoss, r.PacketLoss)
+		open.Results++
+		last = r.Time
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	finish(nil)
+	slices.SortStableFunc(incidents, func(a, b Incident) int { return b.Start.Compare(a.Start) })
+	return incidents, nil
+}
+
+// incidentsHandler serves the outages found in the stored history as JSON,
+// newest first, so how long a host was down is answered without reading
+// through its results. since and until take the same times as for
+// /api/history and default to a day ago and now; host or group narrow the
+// outages down, and min_loss, the packet loss percentage from which a
+// result counts as an outage, defaults to 100.
+//
+//	GET /api/incidents?host=vpn-gw&since=12h
+func incidentsHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "no history is kept", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	since, until := now.Add(-24*time.Hour), now
+	q := r.URL.Query()
+	var err error
+	if s := q.Get("since"); s != "" {
+		if since, err = parseHistoryTime(s, now); err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("until"); s != "" {
+		if until, err = parseHistoryTime(s, now); err != nil {
+			http.Error(w, "invalid until", http.StatusBadRequest)
+			return
+		}
+	}
+	if !since.Before(until) {
+		http.Error(w, "since must be before until", http.StatusBadRequest)
+		return
+	}
+	minLoss := 100.0
+	if s := q.Get("min_loss"); s != "" {
+		if minLoss, err = strconv.ParseFloat(s, 64); err != nil || minLoss <= 0 || minLoss > 100 {
+			http.Error(w, "invalid min_loss, want a percentage above 0", http.StatusBadRequest)
+			return
+		}
+	}
+	keep := func(string) bool { return true }
+	if group := q.Get("group"); group != "" {
+		members := groupMembers(group)
+		keep = func(h string) bool { return slices.Contains(members, h) }
+	}
+	incidents, err := findIncidents(history.store, q.Get("host"), since, until, minLoss, keep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(incidents)
+}
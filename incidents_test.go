@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncidentsHandler(t *testing.T) {
+	defer func(h *resultHistory) { history = h }(history)
+	s := newMemoryStore(10)
+	history = &resultHistory{store: s}
+	at := time.Now().Add(-time.Hour).Truncate(time.Second)
+	sec := func(n int) time.Time { return at.Add(time.Duration(n) * time.Second) }
+	s.write([]HistoryResult{
+		{Time: sec(0), Host: "a", LatencyMs: 10, State: "up"},
+		{Time: sec(1), Host: "a", PacketLoss: 100, State: "down"},
+		{Time: sec(2), Host: "a", PacketLoss: 100, State: "down"},
+		{Time: sec(2), Host: "b", PacketLoss: 100, State: "down"},
+		{Time: sec(3), Host: "a", LatencyMs: 10, State: "up"},
+		{Time: sec(4), Host: "a", LatencyMs: 10, PacketLoss: 50, State: "degraded"},
+		{Time: sec(5), Host: "a", PacketLoss: 100, State: "down"},
+	})
+	get := func(query string) (int, []Incident) {
+		w := httptest.NewRecorder()
+		incidentsHandler(w, httptest.NewRequest(http.MethodGet, "/api/incidents?"+query, nil))
+		var incidents []Incident
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&incidents))
+		}
+		return w.Code, incidents
+	}
+
+	code, incidents := get("")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, incidents, 3) {
+		assert.Equal(t, "a", incidents[0].Host, "newest first")
+		assert.True(t, incidents[0].Start.Equal(sec(5)))
+		assert.True(t, incidents[0].Ongoing)
+		assert.Nil(t, incidents[0].End)
+		assert.Equal(t, "b", incidents[1].Host)
+		assert.True(t, incidents[1].Ongoing)
+		assert.True(t, incidents[2].Start.Equal(sec(1)))
+		if assert.NotNil(t, incidents[2].End) {
+			assert.True(t, incidents[2].End.Equal(sec(3)), "ends with the first answer")
+		}
+		assert.False(t, incidents[2].Ongoing)
+		assert.Equal(t, 2.0, incidents[2].DurationSeconds)
+		assert.Equal(t, 100.0, incidents[2].MaxLoss)
+		assert.Equal(t, 2, incidents[2].Results)
+	}
+
+	_, incidents = get("host=a&min_loss=50")
+	if assert.Len(t, incidents, 2) {
+		assert.True(t, incidents[0].Start.Equal(sec(4)), "heavy loss leads into the outage")
+		assert.Equal(t, 2, incidents[0].Results)
+		assert.Equal(t, 1.0, incidents[0].DurationSeconds)
+	}
+
+	_, incidents = get("since=30m")
+	assert.Empty(t, incidents)
+
+	code, _ = get("min_loss=0")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("since=1h&until=2h")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// influxURL is the InfluxDB server results are written to, set with
+	// -influx-url, e.g. http://influxdb:8086. Empty writes none. User and
+	// password in it authenticate with InfluxDB 1.x.
+	influxURL string
+	// influxDB is the InfluxDB 1.x database to write to, set with
+	// -influx-db.
+	influxDB string
+	// influxBucket and influxOrg are the InfluxDB 2.x bucket to write to and
+	// its organization, set with -influx-bucket and -influx-org.
+	influxBucket, influxOrg string
+	// influxToken is the InfluxDB 2.x API token, set with -influx-token.
+	// ${VAR} references are expanded from the environment.
+	influxToken string
+)
+
+const (
+	// influxMeasurement is the measurement results are written as.
+	influxMeasurement = "mosaic"
+	// influxBatch is the most lines written in one request.
+	influxBatch = 5000
+)
+
+// influxSink writes the results of every cycle to InfluxDB in line
+// protocol, a point per host, through the 1.x /write or the 2.x
+// /api/v2/write API.
+type influxSink struct {
+	write   string            // Write URL with its query
+	headers map[string]string // Sent with every write, for authentication
+}
+
+// newInfluxSink creates a sink writing to the InfluxDB at base: to the
+// database db of InfluxDB 1.x, or to bucket of org of InfluxDB 2.x.
+//
+// Parameters:
+//   - base: URL of the server, with user and password for 1.x if needed
+//   - db: 1.x database, empty for 2.x
+//   - bucket, org: 2.x bucket and organization, empty for 1.x
+//   - token: 2.x API token, may reference ${VAR}s
+//
+// Returns:
+//   - *influxSink: The sink
+//   - error: If base is not an http(s) URL or not exactly one of db and
+//     bucket is given
+func newInfluxSink(base, db, bucket, org, token string) (*influxSink, error) {
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q: expected an http(s) URL such as http://influxdb:8086", base)
+	}
+	s := &influxSink{headers: make(map[string]string)}
+	q := url.Values{"precision": {"ms"}}
+	switch {
+	case db != "" && bucket == "":
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/write"
+		q.Set("db", db)
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			creds := u.User.Username() + ":" + os.ExpandEnv(pass)
+			s.headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+			u.User = nil
+		}
+	case bucket != "" && db == "":
+		if org == "" {
+			return nil, fmt.Errorf("-influx-bucket needs -influx-org")
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+		q.Set("bucket", bucket)
+		q.Set("org", org)
+		if token != "" {
+			s.headers["Authorization"] = "Token " + os.ExpandEnv(token)
+		}
+	default:
+		return nil, fmt.Errorf("-influx-url needs either -influx-db for InfluxDB 1.x or -influx-bucket for 2.x")
+	}
+	u.RawQuery = q.Encode()
+	s.write = u.String()
+	return s, nil
+}
+
+// Name implements resultSink.
+func (s *influxSink) Name() string { return "influxdb" }
+
+// Push implements resultSink, writing influxBatch lines at a time. Retrying
+// a push writes its first batches again, which InfluxDB takes as the same
+// points.
+func (s *influxSink) Push(ctx context.Context, now time.Time, statuses []HostStatus) error {
+	lines := influxLines(now, statuses)
+	for len(lines) > 0 {
+		n := min(len(lines), influxBatch)
+		body := []byte(strings.Join(lines[:n], "\n") + "\n")
+		if err := postSink(ctx, s.write, "text/plain; charset=utf-8", s.headers, body); err != nil {
+			return err
+		}
+		lines = lines[n:]
+	}
+	return nil
+}
+
+// influxLines returns a line per status, tagged with the host and its name
+// and groups from its label:
+//
+//	mosaic,host=10.0.0.1,name=core-sw,groups=core\,prod latency_ms=3i,packet_loss=0,up=1i,state="up" 1714557600000
+//
+// A host that is down has no latency_ms.
+func influxLines(now time.Time, statuses []HostStatus) []string {
+	ts := strconv.FormatInt(now.UnixMilli(), 10)
+	lines := make([]string, 0, len(statuses))
+	for _, st := range statuses {
+		var b strings.Builder
+		b.WriteString(influxMeasurement)
+		b.WriteString(",host=" + influxTag(st.Host))
+		if st.Name != "" {
+			b.WriteString(",name=" + influxTag(st.Name))
+		}
+		if len(st.Groups) > 0 {
+			b.WriteString(",groups=" + influxTag(strings.Join(st.Groups, ",")))
+		}
+		b.WriteByte(' ')
+		up := 0
+		if st.Alive {
+			up = 1
+			fmt.Fprintf(&b, "latency_ms=%di,", st.LatencyMs)
+		}
+		fmt.Fprintf(&b, "packet_loss=%s,up=%di,state=%q ", strconv.FormatFloat(st.PacketLoss, 'f', -1, 64), up, resultState(st))
+		b.WriteString(ts)
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// influxTagEscaper escapes the characters line protocol gives a meaning in
+// tag keys and values.
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", "")
+
+// influxTag returns s escaped as a tag value. Empty values are not allowed,
+// so an empty s becomes "-".
+func influxTag(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return influxTagEscaper.Replace(s)
+}
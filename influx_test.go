@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfluxLines(t *testing.T) {
+	now := time.UnixMilli(1714557600000)
+	lines := influxLines(now, []HostStatus{
+		{Host: "10.0.0.1", Name: "core sw", Alive: true, LatencyMs: 3, Groups: []string{"core", "prod"}},
+		{Host: "db=1", PacketLoss: 100},
+		{Host: "web", Alive: true, Degraded: true, LatencyMs: 40, PacketLoss: 12.5},
+	})
+	assert.Equal(t, []string{
+		`mosaic,host=10.0.0.1,name=core\ sw,groups=core\,prod latency_ms=3i,packet_loss=0,up=1i,state="up" 1714557600000`,
+		`mosaic,host=db\=1 packet_loss=100,up=0i,state="down" 1714557600000`,
+		`mosaic,host=web latency_ms=40i,packet_loss=12.5,up=1i,state="degraded" 1714557600000`,
+	}, lines)
+}
+
+func TestInfluxSink(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	t.Setenv("MOSAIC_TEST_INFLUX_TOKEN", "secret")
+	s, err := newInfluxSink(srv.URL, "", "mosaic", "noc", "${MOSAIC_TEST_INFLUX_TOKEN}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make([]HostStatus, influxBatch+1)
+	for i := range statuses {
+		statuses[i] = HostStatus{Host: "h", Alive: true}
+	}
+	assert.NoError(t, s.Push(context.Background(), time.Now(), statuses))
+	if assert.Len(t, requests, 2, "in batches") {
+		assert.Equal(t, "/api/v2/write", requests[0].URL.Path)
+		assert.Equal(t, "mosaic", requests[0].URL.Query().Get("bucket"))
+		assert.Equal(t, "noc", requests[0].URL.Query().Get("org"))
+		assert.Equal(t, "ms", requests[0].URL.Query().Get("precision"))
+		assert.Equal(t, "Token secret", requests[0].Header.Get("Authorization"))
+		assert.Equal(t, influxBatch, strings.Count(bodies[0], "\n"))
+		assert.Equal(t, 1, strings.Count(bodies[1], "\n"))
+	}
+
+	requests = nil
+	s, err = newInfluxSink(strings.Replace(srv.URL, "http://", "http://mosaic:pw@", 1), "metrics", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, s.Push(context.Background(), time.Now(), statuses[:1]))
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, "/write", requests[0].URL.Path)
+		assert.Equal(t, "metrics", requests[0].URL.Query().Get("db"))
+		user, pass, ok := requests[0].BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "mosaic", user)
+		assert.Equal(t, "pw", pass)
+	}
+
+	for _, bad := range [][5]string{
+		{"influxdb:8086", "db"},
+		{srv.URL, "", "", "", ""},
+		{srv.URL, "db", "bucket", "org", ""},
+		{srv.URL, "", "bucket", "", ""},
+	} {
+		_, err := newInfluxSink(bad[0], bad[1], bad[2], bad[3], bad[4])
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// k8sSource is the -k8s value: "in-cluster" to discover hosts through
+	// the service account of the pod mosaic runs in, or the path of a
+	// kubeconfig file. Empty turns discovery off.
+	k8sSource string
+	// k8sPods is the -k8s-pods label selector, e.g. "app=web". Pods
+	// matching it are monitored along with the nodes.
+	k8sPods string
+	// k8sNamespace is the -k8s-namespace value: the namespace pods are
+	// discovered in, or "" for all namespaces.
+	k8sNamespace string
+)
+
+const (
+	// k8sServiceAccount is where Kubernetes mounts the service account
+	// token and CA of a pod.
+	k8sServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// k8sWatchTimeout bounds a watch request, after which the resources
+	// are listed again, so missed events cannot go unnoticed for long.
+	k8sWatchTimeout = 5 * time.Minute
+)
+
+// k8sClient talks to the Kubernetes API server.
+type k8sClient struct {
+	server string // Base URL, e.g. https://10.96.0.1:443
+	token  string // Bearer token, or "" with client certificates
+	http   *http.Client
+}
+
+// newK8sClient creates a client for -k8s: from the service account of the
+// pod for "in-cluster", from the current context of a kubeconfig file
+// otherwise.
+func newK8sClient(source string) (*k8sClient, error) {
+	if source == "in-cluster" {
+		return inClusterClient(k8sServiceAccount)
+	}
+	return kubeconfigClient(source)
+}
+
+// inClusterClient creates a client from the service account mounted at dir
+// and the KUBERNETES_SERVICE_HOST and _PORT variables of the pod.
+func inClusterClient(dir string) (*k8sClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST is not set")
+	}
+	token, err := os.ReadFile(filepath.Join(dir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := k8sTLS(ca, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return &k8sClient{
+		server: "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		http:   &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+// kubeconfig is the part of a kubeconfig file mosaic reads.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string         `yaml:"token"`
+			TokenFile             string         `yaml:"tokenFile"`
+			ClientCertificate     string         `yaml:"client-certificate"`
+			ClientCertificateData string         `yaml:"client-certificate-data"`
+			ClientKey             string         `yaml:"client-key"`
+			ClientKeyData         string         `yaml:"client-key-data"`
+			Exec                  map[string]any `yaml:"exec"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// kubeconfigClient creates a client for the current context of the
+// kubeconfig file at path. Users may authenticate with a token or a client
+// certificate; exec credential plugins are not supported. Relative file
+// references are resolved against the directory of the file.
+func kubeconfigClient(path string) (*k8sClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("kubeconfig %s: %v", path, err)
+	}
+	// load returns the contents of a base64 data field or a referenced file
+	load := func(data, file string) ([]byte, error) {
+		if data != "" {
+			return base64.StdEncoding.DecodeString(data)
+		}
+		if file == "" {
+			return nil, nil
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		return os.ReadFile(file)
+	}
+
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kubeconfig %s: no context %q", path, kc.CurrentContext)
+	}
+	client := &k8sClient{}
+	var ca []byte
+	insecure := false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		client.server = strings.TrimSuffix(c.Cluster.Server, "/")
+		insecure = c.Cluster.InsecureSkipTLSVerify
+		if ca, err = load(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority); err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: cluster %s: %v", path, clusterName, err)
+		}
+	}
+	if client.server == "" {
+		return nil, fmt.Errorf("kubeconfig %s: no server for cluster %q", path, clusterName)
+	}
+	var cert, key []byte
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		if u.User.Exec != nil {
+			return nil, fmt.Errorf("kubeconfig %s: user %s: exec credential plugins are not supported, use a token or client certificate", path, userName)
+		}
+		token, err := load("", u.User.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: user %s: %v", path, userName, err)
+		}
+		client.token = strings.TrimSpace(string(token))
+		if u.User.Token != "" {
+			client.token = u.User.Token
+		}
+		if cert, err = load(u.User.ClientCertificateData, u.User.ClientCertificate); err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: user %s: %v", path, userName, err)
+		}
+		if key, err = load(u.User.ClientKeyData, u.User.ClientKey); err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: user %s: %v", path, userName, err)
+		}
+	}
+	tlsConfig, err := k8sTLS(ca, cert, key, insecure)
+	if err != nil {
+		return nil, fmt.Errorf("kubeconfig %s: %v", path, err)
+	}
+	client.http = &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}}
+	return client, nil
+}
+
+// k8sTLS returns the TLS configuration for an API server with the CA
+// certificate ca, the system roots without one, and the client certificate
+// cert and key, if given.
+func k8sTLS(ca, cert, key []byte, insecure bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if len(ca) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid certificate authority")
+		}
+	}
+	if len(cert) > 0 || len(key) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+// get sends a GET request for path and query to the API server.
+func (c *k8sClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &k8sError{code: resp.StatusCode, message: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+// k8sError is an error response of the API server.
+type k8sError struct {
+	code    int
+	message string
+}
+
+func (e *k8sError) Error() string {
+	return fmt.Sprintf("%s %s", http.StatusText(e.code), e.message)
+}
+
+// k8sObject is the part of a node or pod mosaic reads.
+type k8sObject struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec struct {
+		HostNetwork bool `json:"hostNetwork"`
+	} `json:"spec"`
+	Status struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"` // Nodes
+		Phase  string `json:"phase"` // Pods
+		PodIPs []struct {
+			IP string `json:"ip"`
+		} `json:"podIPs"`
+	} `json:"status"`
+}
+
+// k8sResource is a kind of object discovery lists and watches.
+type k8sResource struct {
+	kind  string
+	path  string
+	query url.Values
+	entry func(k8sObject) string // The host entry of an object, "" for none
+}
+
+// k8sResources returns the resources to discover for the flags: the nodes,
+// and the pods matching -k8s-pods in -k8s-namespace if it is set.
+func k8sResources() []k8sResource {
+	resources := []k8sResource{{kind: "node", path: "/api/v1/nodes", query: url.Values{}, entry: nodeEntry}}
+	if k8sPods != "" {
+		path := "/api/v1/pods"
+		if k8sNamespace != "" {
+			path = "/api/v1/namespaces/" + url.PathEscape(k8sNamespace) + "/pods"
+		}
+		resources = append(resources, k8sResource{kind: "pod", path: path, query: url.Values{"labelSelector": {k8sPods}}, entry: podEntry})
+	}
+	return resources
+}
+
+// nodeEntry returns the host entry of a node: a logical host named after
+// the node with its internal addresses, or its external ones without any,
+// e.g. "worker-1=10.0.0.5|fd00::5".
+func nodeEntry(o k8sObject) string {
+	var internal, external []string
+	for _, a := range o.Status.Addresses {
+		switch a.Type {
+		case "InternalIP":
+			internal = append(internal, a.Address)
+		case "ExternalIP":
+			external = append(external, a.Address)
+		}
+	}
+	if len(internal) == 0 {
+		internal = external
+	}
+	return logicalEntry(o.Metadata.Name, internal)
+}
+
+// podEntry returns the host entry of a running pod: a logical host named
+// "pod.namespace" with its IPs. Pods on the host network have the node's
+// addresses, which the node entry already monitors, so they get none.
+func podEntry(o k8sObject) string {
+	if o.Status.Phase != "Running" || o.Spec.HostNetwork {
+		return ""
+	}
+	var ips []string
+	for _, ip := range o.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	return logicalEntry(o.Metadata.Name+"."+o.Metadata.Namespace, ips)
+}
+
+// objectKey identifies an object among those of its kind.
+func objectKey(o k8sObject) string {
+	return o.Metadata.Namespace + "/" + o.Metadata.Name
+}
+
+// discoverK8s monitors the nodes of the cluster, and the pods matching
+// -k8s-pods, until ctx is done. Each resource is listed and then watched
+// for changes, so hosts follow the cluster as it scales; after an error or
+// an expired watch it is listed again.
+func discoverK8s(ctx context.Context, c *k8sClient) {
+	d := startReconciler(ctx, "Kubernetes")
+	for _, res := range k8sResources() {
+		go runDiscovery(ctx, "Kubernetes discovery of "+res.kind+"s", func(ctx context.Context) error {
+			return followK8s(ctx, c, d, res)
+		})
+	}
+}
+
+// followK8s lists the objects of res into d and then watches them until the watch
+// ends.
+//
+// Returns:
+//   - error: If the API server cannot be reached or rejects a request; nil
+//     when a watch ended normally or expired
+func followK8s(ctx context.Context, c *k8sClient, d *reconciler, res k8sResource) error {
+	resp, err := c.get(ctx, res.path, res.query)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []k8sObject `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("invalid %s list: %v", res.kind, err)
+	}
+	entries := make(map[string]discovered, len(list.Items))
+	for _, o := range list.Items {
+		if e := res.entry(o); e != "" {
+			entries[objectKey(o)] = discovered{entry: e}
+		}
+	}
+	d.replace(res.kind, entries)
+
+	query := url.Values{}
+	for k, v := range res.query {
+		query[k] = v
+	}
+	query.Set("watch", "true")
+	query.Set("resourceVersion", list.Metadata.ResourceVersion)
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", fmt.Sprint(int(k8sWatchTimeout.Seconds())))
+	resp, err = c.get(ctx, res.path, query)
+	if err != nil {
+		var apiErr *k8sError
+		if errors.As(err, &apiErr) && apiErr.code == http.StatusGone {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("invalid %s watch event: %v", res.kind, err)
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var o k8sObject
+			if err := json.Unmarshal(event.Object, &o); err != nil {
+				return fmt.Errorf("invalid %s watch event: %v", res.kind, err)
+			}
+			e := res.entry(o)
+			if event.Type == "DELETED" {
+				e = ""
+			}
+			d.set(res.kind, objectKey(o), discovered{entry: e})
+		case "ERROR":
+			// Usually 410 Gone: the resource version is too old, list again
+			return nil
+		}
+	}
+	return scanner.Err()
+}
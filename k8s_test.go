@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// k8sNode renders a node with the given internal addresses as JSON.
+func k8sNode(name string, addrs ...string) string {
+	list := ""
+	for i, a := range addrs {
+		if i > 0 {
+			list += ","
+		}
+		list += fmt.Sprintf(`{"type":"InternalIP","address":%q}`, a)
+	}
+	return fmt.Sprintf(`{"metadata":{"name":%q},"status":{"addresses":[{"type":"Hostname","address":%q},%s]}}`, name, name, list)
+}
+
+func TestNodeAndPodEntries(t *testing.T) {
+	var node k8sObject
+	assert.NoError(t, json.Unmarshal([]byte(k8sNode("worker-1", "10.0.0.5", "fd00::5")), &node))
+	assert.Equal(t, "worker-1=10.0.0.5|fd00::5", nodeEntry(node))
+	assert.NoError(t, validateHost(nodeEntry(node)))
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"metadata":{"name":"edge"},"status":{"addresses":[{"type":"ExternalIP","address":"203.0.113.5"}]}}`), &node))
+	assert.Equal(t, "edge=203.0.113.5", nodeEntry(node), "external addresses without internal ones")
+
+	var pod k8sObject
+	assert.NoError(t, json.Unmarshal([]byte(`{"metadata":{"name":"web-7d9f","namespace":"shop"},"status":{"phase":"Pending"}}`), &pod))
+	assert.Empty(t, podEntry(pod), "pods without an IP yet")
+	assert.NoError(t, json.Unmarshal([]byte(`{"status":{"phase":"Running","podIPs":[{"ip":"10.244.1.7"}]}}`), &pod))
+	assert.Equal(t, "web-7d9f.shop=10.244.1.7", podEntry(pod))
+	pod.Spec.HostNetwork = true
+	assert.Empty(t, podEntry(pod), "host network pods are covered by their node")
+}
+
+func TestK8sDiscoveryFollowsCluster(t *testing.T) {
+	withHosts(t, "static")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		if r.URL.Query().Get("watch") != "true" {
+			fmt.Fprintf(w, `{"metadata":{"resourceVersion":"41"},"items":[%s,%s]}`, k8sNode("a", "10.0.0.1"), k8sNode("b", "10.0.0.2"))
+			return
+		}
+		assert.Equal(t, "41", r.URL.Query().Get("resourceVersion"))
+		fmt.Fprintf(w, "{\"type\":\"ADDED\",\"object\":%s}\n", k8sNode("c", "10.0.0.3"))
+		fmt.Fprintf(w, "{\"type\":\"DELETED\",\"object\":%s}\n", k8sNode("a", "10.0.0.1"))
+		fmt.Fprintf(w, "{\"type\":\"MODIFIED\",\"object\":%s}\n", k8sNode("b", "10.0.0.2", "fd00::2"))
+	}))
+	defer srv.Close()
+
+	c := &k8sClient{server: srv.URL, token: "secret", http: srv.Client()}
+	d := newReconciler("Kubernetes")
+	assert.NoError(t, followK8s(context.Background(), c, d, k8sResources()[0]))
+	assert.Equal(t, []string{"static", "c=10.0.0.3", "b=10.0.0.2|fd00::2"}, currentHosts())
+
+	// Hosts of objects that are gone from a fresh list are removed
+	d.replace("node", nil)
+	assert.Equal(t, []string{"static"}, currentHosts())
+}
+
+func TestK8sDiscoveryListError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nodes is forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	c := &k8sClient{server: srv.URL, http: srv.Client()}
+	err := followK8s(context.Background(), c, newReconciler("Kubernetes"), k8sResources()[0])
+	assert.ErrorContains(t, err, "nodes is forbidden")
+}
+
+func TestK8sPodResource(t *testing.T) {
+	oldPods, oldNamespace := k8sPods, k8sNamespace
+	t.Cleanup(func() { k8sPods, k8sNamespace = oldPods, oldNamespace })
+
+	k8sPods, k8sNamespace = "", ""
+	assert.Len(t, k8sResources(), 1, "only nodes without -k8s-pods")
+
+	k8sPods, k8sNamespace = "app=web", "shop"
+	res := k8sResources()
+	assert.Len(t, res, 2)
+	assert.Equal(t, "/api/v1/namespaces/shop/pods", res[1].path)
+	assert.Equal(t, "app=web", res[1].query.Get("labelSelector"))
+}
+
+func TestKubeconfigClient(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	config := `apiVersion: v1
+kind: Config
+current-context: prod
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev}
+- name: prod
+  context: {cluster: prod, user: admin}
+clusters:
+- name: dev
+  cluster: {server: "https://dev.example.com"}
+- name: prod
+  cluster:
+    server: "https://k8s.example.com:6443/"
+    insecure-skip-tls-verify: true
+users:
+- name: admin
+  user: {tokenFile: token}
+`
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("s3cret\n"), 0o600))
+
+	c, err := kubeconfigClient(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "https://k8s.example.com:6443", c.server)
+	assert.Equal(t, "s3cret", c.token, "tokenFile is relative to the kubeconfig")
+
+	bad := map[string]string{
+		"no context":  "current-context: gone\n",
+		"exec plugin": "current-context: x\ncontexts: [{name: x, context: {cluster: x, user: x}}]\nclusters: [{name: x, cluster: {server: https://x}}]\nusers: [{name: x, user: {exec: {command: aws}}}]\n",
+		"bad ca":      "current-context: x\ncontexts: [{name: x, context: {cluster: x}}]\nclusters: [{name: x, cluster: {server: https://x, certificate-authority-data: " + base64.StdEncoding.EncodeToString([]byte("junk")) + "}}]\n",
+	}
+	for name, config := range bad {
+		assert.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+		_, err := kubeconfigClient(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestInClusterClientOutsideCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	_, err := inClusterClient(t.TempDir())
+	assert.ErrorContains(t, err, "not running in a Kubernetes pod")
+}
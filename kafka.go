@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// kafkaBrokers are the comma-separated host:port bootstrap brokers
+	// results and events are published to, set with -kafka, the port
+	// defaulting to 9092. Empty publishes none.
+	kafkaBrokers string
+	// kafkaTopic is the topic every probe result is published to, set with
+	// -kafka-topic.
+	kafkaTopic = "mosaic.results"
+	// kafkaEventTopic is the topic every event is published to, set with
+	// -kafka-event-topic.
+	kafkaEventTopic = "mosaic.events"
+	// kafkaTLS connects to the brokers over TLS, set with -kafka-tls.
+	kafkaTLS bool
+)
+
+const (
+	// kafkaClientID identifies mosaic in the brokers' logs and quotas.
+	kafkaClientID = "mosaic"
+	// kafkaBatchBytes bounds the records sent to a partition in one
+	// request, well below the brokers' default message.max.bytes of 1 MB.
+	kafkaBatchBytes = 512 << 10
+	// kafkaProduceTimeout is how long the leader waits for the write.
+	kafkaProduceTimeout = 5 * time.Second
+)
+
+// Kafka API keys and versions mosaic speaks: those every broker since 0.11
+// supports, the first with record batches.
+const (
+	kafkaAPIProduce  = 0
+	kafkaAPIMetadata = 3
+
+	kafkaProduceVersion  = 3
+	kafkaMetadataVersion = 1
+)
+
+// kafkaRejected are the Kafka error codes for records the broker will
+// never take: MESSAGE_TOO_LARGE, INVALID_TOPIC_EXCEPTION,
+// RECORD_LIST_TOO_LARGE, TOPIC_AUTHORIZATION_FAILED and INVALID_RECORD.
+var kafkaRejected = []int16{10, 17, 18, 29, 87}
+
+// kafkaCastagnoli is the CRC-32C table record batches are checked with.
+var kafkaCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaRecord is a record to publish.
+type kafkaRecord struct {
+	key   []byte // Picks the partition; nil spreads records over all
+	value []byte
+	time  time.Time
+}
+
+// kafkaSink publishes every probe result and every event to Kafka as JSON,
+// a record per result keyed by its host, so a host's results stay in order
+// on one partition. It speaks just enough of the Kafka protocol to find
+// the partition leaders and produce to them, without compression, and
+// keeps its connections between pushes. Its pushes are run by a single
+// sink worker, so it needs no locking.
+type kafkaSink struct {
+	brokers    []string
+	topic      string
+	eventTopic string
+	tls        *tls.Config // nil for plaintext
+	dial       func(ctx context.Context, network, addr string) (net.Conn, error)
+	conns      map[string]*kafkaConn // By broker address
+	leaders    map[string][]string   // Leader address of each partition, by topic
+	next       int                   // Partition of the next record without a key
+}
+
+// newKafkaSink creates a sink publishing results to topic and events to
+// eventTopic through the comma-separated bootstrap brokers, with port
+// 9092 if they have none.
+//
+// Parameters:
+//   - brokers: Comma-separated host:port bootstrap brokers
+//   - topic: Topic of the probe results
+//   - eventTopic: Topic of the events
+//   - useTLS: Whether to connect over TLS
+//
+// Returns:
+//   - *kafkaSink: The sink
+//   - error: If a broker is not host:port or a topic is not a valid name
+func newKafkaSink(brokers, topic, eventTopic string, useTLS bool) (*kafkaSink, error) {
+	s := &kafkaSink{topic: topic, eventTopic: eventTopic}
+	for _, b := range strings.Split(brokers, ",") {
+		if b = strings.TrimSpace(b); b == "" {
+			continue
+		}
+		b = withDefaultPort(b, "9092")
+		if _, _, err := net.SplitHostPort(b); err != nil || strings.Contains(b, "/") {
+			return nil, fmt.Errorf("invalid Kafka broker %q: expected host:port", b)
+		}
+		s.brokers = append(s.brokers, b)
+	}
+	if len(s.brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka brokers given")
+	}
+	for _, t := range []string{topic, eventTopic} {
+		if !validKafkaTopic(t) {
+			return nil, fmt.Errorf("invalid Kafka topic %q: expected up to 249 letters, digits, '.', '_' or '-'", t)
+		}
+	}
+	if useTLS {
+		s.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	var d net.Dialer
+	s.dial = d.DialContext
+	return s, nil
+}
+
+// validKafkaTopic reports whether t is a name Kafka accepts for a topic.
+func validKafkaTopic(t string) bool {
+	if t == "" || t == "." || t == ".." || len(t) > 249 {
+		return false
+	}
+	for _, c := range t {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Name implements resultSink.
+func (s *kafkaSink) Name() string { return "kafka" }
+
+// Push implements resultSink, publishing each status as a -results-log
+// line keyed by its host.
+func (s *kafkaSink) Push(ctx context.Context, now time.Time, statuses []HostStatus) error {
+	records := make([]kafkaRecord, 0, len(statuses))
+	for _, st := range statuses {
+		value, err := json.Marshal(ResultLogLine{Time: now, State: resultState(st), HostStatus: st})
+		if err != nil {
+			return err
+		}
+		records = append(records, kafkaRecord{key: []byte(st.Host), value: value, time: now})
+	}
+	return s.produce(ctx, s.topic, records)
+}
+
+// PushEvent implements eventSink, publishing e as JSON keyed by what it is
+// about: its key, or else its first host.
+func (s *kafkaSink) PushEvent(ctx context.Context, e Event) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	r := kafkaRecord{value: value, time: e.Time}
+	switch {
+	case e.Key != "":
+		r.key = []byte(e.Key)
+	case len(e.Hosts) > 0:
+		r.key = []byte(e.Hosts[0])
+	}
+	return s.produce(ctx, s.eventTopic, []kafkaRecord{r})
+}
+
+// produce publishes records to topic. After a failure other than a
+// rejection, the connections and leaders are dropped, so the next attempt
+// starts over in case leadership moved or a broker went away.
+func (s *kafkaSink) produce(ctx context.Context, topic string, records []kafkaRecord) error {
+	err := s.send(ctx, topic, records)
+	if err != nil && !errors.Is(err, errSinkRejected) {
+		s.reset()
+	}
+	return err
+}
+
+// send splits records by partition and sends them to the partition
+// leaders, at most kafkaBatchBytes per partition and request. A retry
+// after a partial failure publishes the records already sent again.
+func (s *kafkaSink) send(ctx context.Context, topic string, records []kafkaRecord) error {
+	leaders, err := s.partitions(ctx, topic)
+	if err != nil {
+		return err
+	}
+	parts := make([][]kafkaRecord, len(leaders))
+	for _, r := range records {
+		p := s.partition(r.key, len(leaders))
+		parts[p] = append(parts[p], r)
+	}
+	for {
+		byLeader := make(map[string]map[int32][]byte)
+		for p, rs := range parts {
+			if len(rs) == 0 {
+				continue
+			}
+			if leaders[p] == "" {
+				return fmt.Errorf("partition %d of %s has no leader", p, topic)
+			}
+			n, size := 1, len(rs[0].key)+len(rs[0].value)
+			for n < len(rs) && size+len(rs[n].key)+len(rs[n].value) <= kafkaBatchBytes {
+				size += len(rs[n].key) + len(rs[n].value)
+				n++
+			}
+			if byLeader[leaders[p]] == nil {
+				byLeader[leaders[p]] = make(map[int32][]byte)
+			}
+			byLeader[leaders[p]][int32(p)] = kafkaRecordBatch(rs[:n])
+			parts[p] = rs[n:]
+		}
+		if len(byLeader) == 0 {
+			return nil
+		}
+		for addr, batches := range byLeader {
+			if err := s.produceTo(ctx, addr, topic, batches); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// partition returns the partition of n for key, chosen like the Java
+// client's default partitioner so consumers agree on it: murmur2 of the
+// key, or round robin without a key.
+func (s *kafkaSink) partition(key []byte, n int) int {
+	if key == nil {
+		s.next = (s.next + 1) % n
+		return s.next
+	}
+	return int(kafkaMurmur2(key)&0x7fffffff) % n
+}
+
+// partitions returns the leader address of each partition of topic,
+// asking the bootstrap brokers in turn unless already known.
+func (s *kafkaSink) partitions(ctx context.Context, topic string) ([]string, error) {
+	if leaders, ok := s.leaders[topic]; ok {
+		return leaders, nil
+	}
+	var errs []error
+	for _, addr := range s.brokers {
+		leaders, err := s.metadata(ctx, addr, topic)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		if s.leaders == nil {
+			s.leaders = make(map[string][]string)
+		}
+		s.leaders[topic] = leaders
+		return leaders, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// metadata asks the broker at addr for the partitions of topic and their
+// leaders. Brokers that allow it create a missing topic, reporting
+// LEADER_NOT_AVAILABLE until it is ready.
+func (s *kafkaSink) metadata(ctx context.Context, addr, topic string) ([]string, error) {
+	conn, err := s.conn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	var req []byte
+	req = binary.BigEndian.AppendUint32(req, 1)
+	req = kafkaAppendString(req, topic)
+	resp, err := conn.roundTrip(ctx, kafkaAPIMetadata, kafkaMetadataVersion, req)
+	if err != nil {
+		return nil, err
+	}
+	r := &kafkaReader{b: resp}
+	brokers := make(map[int32]string)
+	for range r.count() {
+		id, host, port := r.int32(), r.string(), r.int32()
+		r.string() // Rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.int32() // Controller
+	var leaders []string
+	found := false
+	for range r.count() {
+		code, name := r.int16(), r.string()
+		r.int8() // Internal
+		if name != topic {
+			r.err = fmt.Errorf("unexpected topic %q in metadata", name)
+			break
+		}
+		found = true
+		if code != 0 {
+			return nil, kafkaError(topic, code)
+		}
+		for range r.count() {
+			r.int16() // Partition error, e.g. LEADER_NOT_AVAILABLE; its leader is -1
+			index, leader := r.int32(), r.int32()
+			for range r.count() { // Replicas
+				r.int32()
+			}
+			for range r.count() { // In-sync replicas
+				r.int32()
+			}
+			if index < 0 || index >= 1<<16 {
+				r.err = fmt.Errorf("invalid partition %d of %s", index, topic)
+				break
+			}
+			for int(index) >= len(leaders) {
+				leaders = append(leaders, "")
+			}
+			leaders[index] = brokers[leader]
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !found || len(leaders) == 0 {
+		return nil, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return leaders, nil
+}
+
+// produceTo sends the record batches, by partition, to their leader at
+// addr and waits for it to write them.
+func (s *kafkaSink) produceTo(ctx context.Context, addr, topic string, batches map[int32][]byte) error {
+	conn, err := s.conn(ctx, addr)
+	if err != nil {
+		return err
+	}
+	var req []byte
+	req = binary.BigEndian.AppendUint16(req, 0xffff) // No transactional id
+	req = binary.BigEndian.AppendUint16(req, 1)      // acks: the leader wrote it
+	req = binary.BigEndian.AppendUint32(req, uint32(kafkaProduceTimeout.Milliseconds()))
+	req = binary.BigEndian.AppendUint32(req, 1)
+	req = kafkaAppendString(req, topic)
+	req = binary.BigEndian.AppendUint32(req, uint32(len(batches)))
+	for _, p := range slices.Sorted(maps.Keys(batches)) {
+		req = binary.BigEndian.AppendUint32(req, uint32(p))
+		req = binary.BigEndian.AppendUint32(req, uint32(len(batches[p])))
+		req = append(req, batches[p]...)
+	}
+	resp, err := conn.roundTrip(ctx, kafkaAPIProduce, kafkaProduceVersion, req)
+	if err != nil {
+		return err
+	}
+	r := &kafkaReader{b: resp}
+	var errs []error
+	for range r.count() {
+		name := r.string()
+		for range r.count() {
+			index, code := r.int32(), r.int16()
+			r.int64() // Base offset
+			r.int64() // Log append time
+			if code != 0 {
+				errs = append(errs, fmt.Errorf("partition %d: %w", index, kafkaError(name, code)))
+			}
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return errors.Join(errs...)
+}
+
+// kafkaError returns the error for a Kafka error code about topic,
+// wrapping errSinkRejected for the codes in kafkaRejected.
+func kafkaError(topic string, code int16) error {
+	if slices.Contains(kafkaRejected, code) {
+		return fmt.Errorf("%w: error %d for topic %s", errSinkRejected, code, topic)
+	}
+	return fmt.Errorf("error %d for topic %s", code, topic)
+}
+
+// conn returns the connection to the broker at addr, dialing it if needed.
+func (s *kafkaSink) conn(ctx context.Context, addr string) (*kafkaConn, error) {
+	if c, ok := s.conns[addr]; ok {
+		return c, nil
+	}
+	nc, err := s.dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.tls != nil {
+		cfg := s.tls.Clone()
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		tc := tls.Client(nc, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+	if s.conns == nil {
+		s.conns = make(map[string]*kafkaConn)
+	}
+	c := &kafkaConn{Conn: nc}
+	s.conns[addr] = c
+	return c, nil
+}
+
+// reset closes the connections and forgets the partition leaders.
+func (s *kafkaSink) reset() {
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns, s.leaders = nil, nil
+}
+
+// kafkaConn is a connection to a broker.
+type kafkaConn struct {
+	net.Conn
+	correlation int32 // Id of the last request
+}
+
+// roundTrip sends a request with the given API key and version and body,
+// and returns the body of its response.
+func (c *kafkaConn) roundTrip(ctx context.Context, key, version int16, body []byte) ([]byte, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sinkTimeout)
+	}
+	c.SetDeadline(deadline)
+	c.correlation++
+	var req []byte
+	req = binary.BigEndian.AppendUint32(req, 0) // Size, set below
+	req = binary.BigEndian.AppendUint16(req, uint16(key))
+	req = binary.BigEndian.AppendUint16(req, uint16(version))
+	req = binary.BigEndian.AppendUint32(req, uint32(c.correlation))
+	req = kafkaAppendString(req, kafkaClientID)
+	req = append(req, body...)
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+	if _, err := c.Write(req); err != nil {
+		return nil, err
+	}
+	var head [8]byte
+	if _, err := io.ReadFull(c, head[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(head[:4])
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	if id := int32(binary.BigEndian.Uint32(head[4:])); id != c.correlation {
+		return nil, fmt.Errorf("response %d does not match request %d", id, c.correlation)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// kafkaRecordBatch encodes records as an uncompressed record batch, the
+// message format of Kafka 0.11 and later.
+func kafkaRecordBatch(records []kafkaRecord) []byte {
+	first := records[0].time.UnixMilli()
+	last := first
+	var recs []byte
+	for i, r := range records {
+		ts := r.time.UnixMilli()
+		last = max(last, ts)
+		rec := []byte{0} // Attributes
+		rec = binary.AppendVarint(rec, ts-first)
+		rec = binary.AppendVarint(rec, int64(i))
+		if r.key == nil {
+			rec = binary.AppendVarint(rec, -1)
+		} else {
+			rec = binary.AppendVarint(rec, int64(len(r.key)))
+			rec = append(rec, r.key...)
+		}
+		rec = binary.AppendVarint(rec, int64(len(r.value)))
+		rec = append(rec, r.value...)
+		rec = binary.AppendVarint(rec, 0) // Headers
+		recs = binary.AppendVarint(recs, int64(len(rec)))
+		recs = append(recs, rec...)
+	}
+	// Everything from the attributes on is covered by the CRC
+	var tail []byte
+	tail = binary.BigEndian.AppendUint16(tail, 0) // Attributes: no compression, create time
+	tail = binary.BigEndian.AppendUint32(tail, uint32(len(records)-1))
+	tail = binary.BigEndian.AppendUint64(tail, uint64(first))
+	tail = binary.BigEndian.AppendUint64(tail, uint64(last))
+	tail = binary.BigEndian.AppendUint64(tail, ^uint64(0)) // No producer id
+	tail = binary.BigEndian.AppendUint16(tail, 0xffff)     // No producer epoch
+	tail = binary.BigEndian.AppendUint32(tail, 0xffffffff) // No base sequence
+	tail = binary.BigEndian.AppendUint32(tail, uint32(len(records)))
+	tail = append(tail, recs...)
+	var b []byte
+	b = binary.BigEndian.AppendUint64(b, 0)                       // Base offset, assigned by the broker
+	b = binary.BigEndian.AppendUint32(b, uint32(4+1+4+len(tail))) // Length of the rest
+	b = binary.BigEndian.AppendUint32(b, 0xffffffff)              // No partition leader epoch
+	b = append(b, 2)                                              // Magic
+	b = binary.BigEndian.AppendUint32(b, crc32.Checksum(tail, kafkaCastagnoli))
+	return append(b, tail...)
+}
+
+// kafkaMurmur2 is the 32-bit murmur2 hash the Java client partitions keys
+// with.
+func kafkaMurmur2(data []byte) int32 {
+	const m = 0x5bd1e995
+	h := uint32(0x9747b28c) ^ uint32(len(data))
+	n := len(data) &^ 3
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch len(data) & 3 {
+	case 3:
+		h ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[n])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// kafkaAppendString appends s as a Kafka string: its length as an int16,
+// then its bytes.
+func kafkaAppendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// kafkaReader decodes a Kafka response. After the first error, which it
+// keeps in err, it returns zero values.
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes, or nil if there are fewer.
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string returns a string, or "" for a null one.
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+// count returns the length of an array, 0 for a null one, bounded by the
+// bytes left so a corrupt length cannot make the caller loop for long.
+func (r *kafkaReader) count() int {
+	n := int(r.int32())
+	if n < 0 {
+		return 0
+	}
+	if n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKafka is a broker answering Metadata with itself as the leader of
+// every partition and recording the records produced to it.
+type fakeKafka struct {
+	ln         net.Listener
+	partitions int
+	errorCode  int16 // Returned for every produced partition
+
+	mu       sync.Mutex
+	produced map[string][]fakeKafkaRecord // By topic
+	requests int                          // Produce requests
+}
+
+// fakeKafkaRecord is a record as the broker decoded it.
+type fakeKafkaRecord struct {
+	partition int32
+	key       string
+	value     []byte
+}
+
+func newFakeKafka(t *testing.T, partitions int) *fakeKafka {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &fakeKafka{ln: ln, partitions: partitions, produced: make(map[string][]fakeKafkaRecord)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go k.serve(t, conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return k
+}
+
+// setError makes the broker fail every produced partition with code.
+func (k *fakeKafka) setError(code int16) {
+	k.mu.Lock()
+	k.errorCode = code
+	k.mu.Unlock()
+}
+
+func (k *fakeKafka) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		r := &kafkaReader{b: req}
+		key, version, correlation := r.int16(), r.int16(), r.int32()
+		assert.Equal(t, kafkaClientID, r.string())
+		var resp []byte
+		resp = binary.BigEndian.AppendUint32(resp, uint32(correlation))
+		switch key {
+		case kafkaAPIMetadata:
+			assert.EqualValues(t, kafkaMetadataVersion, version)
+			r.count()
+			topic := r.string()
+			host, port, _ := net.SplitHostPort(k.ln.Addr().String())
+			portNum, _ := strconv.Atoi(port)
+			resp = binary.BigEndian.AppendUint32(resp, 1)
+			resp = binary.BigEndian.AppendUint32(resp, 7) // Node id
+			resp = kafkaAppendString(resp, host)
+			resp = binary.BigEndian.AppendUint32(resp, uint32(portNum))
+			resp = binary.BigEndian.AppendUint16(resp, 0xffff) // No rack
+			resp = binary.BigEndian.AppendUint32(resp, 7)      // Controller
+			resp = binary.BigEndian.AppendUint32(resp, 1)
+			resp = binary.BigEndian.AppendUint16(resp, 0)
+			resp = kafkaAppendString(resp, topic)
+			resp = append(resp, 0)
+			resp = binary.BigEndian.AppendUint32(resp, uint32(k.partitions))
+			for p := range k.partitions {
+				resp = binary.BigEndian.AppendUint16(resp, 0)
+				resp = binary.BigEndian.AppendUint32(resp, uint32(p))
+				resp = binary.BigEndian.AppendUint32(resp, 7)
+				resp = binary.BigEndian.AppendUint32(resp, 1)
+				resp = binary.BigEndian.AppendUint32(resp, 7)
+				resp = binary.BigEndian.AppendUint32(resp, 1)
+				resp = binary.BigEndian.AppendUint32(resp, 7)
+			}
+		case kafkaAPIProduce:
+			assert.EqualValues(t, kafkaProduceVersion, version)
+			assert.Equal(t, "", r.string(), "no transactional id")
+			assert.EqualValues(t, 1, r.int16(), "acks")
+			r.int32()
+			r.count()
+			topic := r.string()
+			resp = binary.BigEndian.AppendUint32(resp, 1)
+			resp = kafkaAppendString(resp, topic)
+			n := r.count()
+			resp = binary.BigEndian.AppendUint32(resp, uint32(n))
+			k.mu.Lock()
+			k.requests++
+			for range n {
+				p := r.int32()
+				batch := r.next(int(r.int32()))
+				k.produced[topic] = append(k.produced[topic], decodeKafkaBatch(t, p, batch)...)
+				resp = binary.BigEndian.AppendUint32(resp, uint32(p))
+				resp = binary.BigEndian.AppendUint16(resp, uint16(k.errorCode))
+				resp = binary.BigEndian.AppendUint64(resp, 0)
+				resp = binary.BigEndian.AppendUint64(resp, ^uint64(0))
+			}
+			k.mu.Unlock()
+			resp = binary.BigEndian.AppendUint32(resp, 0) // Throttle time
+		default:
+			t.Errorf("unexpected API key %d", key)
+			return
+		}
+		assert.NoError(t, r.err)
+		conn.Write(binary.BigEndian.AppendUint32(nil, uint32(len(resp))))
+		conn.Write(resp)
+	}
+}
+
+// decodeKafkaBatch decodes a record batch, checking its length and CRC.
+func decodeKafkaBatch(t *testing.T, partition int32, b []byte) []fakeKafkaRecord {
+	r := &kafkaReader{b: b}
+	r.int64() // Base offset
+	assert.EqualValues(t, len(b)-12, r.int32(), "batch length")
+	r.int32() // Partition leader epoch
+	assert.EqualValues(t, 2, r.int8(), "magic")
+	assert.Equal(t, crc32.Checksum(r.b[4:], crc32.MakeTable(crc32.Castagnoli)), uint32(r.int32()), "CRC")
+	assert.EqualValues(t, 0, r.int16(), "attributes")
+	r.next(4 + 8 + 8 + 8 + 2 + 4)
+	n := r.int32()
+	var records []fakeKafkaRecord
+	for range n {
+		length, m := binary.Varint(r.b)
+		rec := r.next(m + int(length))[m:]
+		rec = rec[1:] // Attributes
+		for range 2 { // Timestamp and offset deltas
+			_, m = binary.Varint(rec)
+			rec = rec[m:]
+		}
+		keyLen, m := binary.Varint(rec)
+		rec = rec[m:]
+		var key string
+		if keyLen >= 0 {
+			key, rec = string(rec[:keyLen]), rec[keyLen:]
+		}
+		valueLen, m := binary.Varint(rec)
+		rec = rec[m:]
+		records = append(records, fakeKafkaRecord{partition: partition, key: key, value: rec[:valueLen]})
+		assert.Equal(t, []byte{0}, rec[valueLen:], "no headers")
+	}
+	assert.NoError(t, r.err)
+	assert.Empty(t, r.b)
+	return records
+}
+
+func TestKafkaSink(t *testing.T) {
+	k := newFakeKafka(t, 3)
+	s, err := newKafkaSink(k.ln.Addr().String(), "mosaic.results", "mosaic.events", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1714557600, 0).UTC()
+	statuses := []HostStatus{
+		{Host: "10.0.0.1", Alive: true, LatencyMs: 3, Name: "core-sw"},
+		{Host: "10.0.0.2", PacketLoss: 100},
+	}
+	assert.NoError(t, s.Push(context.Background(), now, statuses))
+	assert.NoError(t, s.PushEvent(context.Background(), Event{Time: now, Type: "host_down", Hosts: []string{"10.0.0.2"}, Message: "10.0.0.2 is down"}))
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	results := k.produced["mosaic.results"]
+	if assert.Len(t, results, 2) {
+		byHost := make(map[string]fakeKafkaRecord)
+		for _, r := range results {
+			byHost[r.key] = r
+		}
+		var line ResultLogLine
+		assert.NoError(t, json.Unmarshal(byHost["10.0.0.1"].value, &line))
+		assert.Equal(t, now, line.Time)
+		assert.Equal(t, "up", line.State)
+		assert.Equal(t, "core-sw", line.Name)
+		assert.Equal(t, int32(s.partition([]byte("10.0.0.1"), 3)), byHost["10.0.0.1"].partition)
+		assert.NoError(t, json.Unmarshal(byHost["10.0.0.2"].value, &line))
+		assert.Equal(t, "down", line.State)
+	}
+	events := k.produced["mosaic.events"]
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "10.0.0.2", events[0].key)
+		var e Event
+		assert.NoError(t, json.Unmarshal(events[0].value, &e))
+		assert.Equal(t, "host_down", e.Type)
+	}
+	assert.Len(t, s.conns, 1, "the connection is kept")
+}
+
+func TestKafkaSinkBatches(t *testing.T) {
+	k := newFakeKafka(t, 1)
+	s, err := newKafkaSink(k.ln.Addr().String(), "results", "events", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make([]HostStatus, 3)
+	for i := range statuses {
+		statuses[i] = HostStatus{Host: "h", Reason: strings.Repeat("x", kafkaBatchBytes/2)}
+	}
+	assert.NoError(t, s.Push(context.Background(), time.Now(), statuses))
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	assert.Len(t, k.produced["results"], 3)
+	assert.Equal(t, 3, k.requests, "a batch stays below kafkaBatchBytes")
+}
+
+func TestKafkaSinkErrors(t *testing.T) {
+	k := newFakeKafka(t, 1)
+	s, err := newKafkaSink(k.ln.Addr().String(), "results", "events", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.setError(29) // TOPIC_AUTHORIZATION_FAILED
+	err = s.Push(context.Background(), time.Now(), []HostStatus{{Host: "h"}})
+	assert.ErrorIs(t, err, errSinkRejected)
+	assert.NotNil(t, s.conns, "kept after a rejection")
+
+	k.setError(6) // NOT_LEADER_FOR_PARTITION
+	err = s.Push(context.Background(), time.Now(), []HostStatus{{Host: "h"}})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errSinkRejected)
+	assert.Nil(t, s.conns, "reconnects after a failure")
+	assert.Nil(t, s.leaders)
+
+	k.setError(0)
+	assert.NoError(t, s.Push(context.Background(), time.Now(), []HostStatus{{Host: "h"}}))
+}
+
+func TestNewKafkaSink(t *testing.T) {
+	s, err := newKafkaSink("kafka1, kafka2:9093,", "mosaic.results", "mosaic.events", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kafka1:9092", "kafka2:9093"}, s.brokers)
+	assert.NotNil(t, s.tls)
+	_, err = newKafkaSink("", "a", "b", false)
+	assert.Error(t, err)
+	_, err = newKafkaSink("kafka", "bad topic", "b", false)
+	assert.Error(t, err)
+	_, err = newKafkaSink("kafka", "a", "..", false)
+	assert.Error(t, err)
+}
+
+func TestKafkaMurmur2(t *testing.T) {
+	// The Java client's test vectors
+	for in, want := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		assert.Equal(t, want, kafkaMurmur2([]byte(in)), in)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HostLabel is a friendly name and free-text notes shown for a host on the
+// dashboard, e.g. "Office FW – rack 3, port 17", how important the host
+// is and the groups it belongs to. Probes keep using the host entry itself.
+type HostLabel struct {
+	Name     string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Notes    string   `json:"notes,omitempty" yaml:"notes,omitempty"`
+	Priority string   `json:"priority,omitempty" yaml:"priority,omitempty"` // critical, high, normal or low; normal if empty
+	Groups   []string `json:"groups,omitempty" yaml:"groups,omitempty"`     // e.g. the Ansible inventory groups of the host
+}
+
+var (
+	labelsMu sync.RWMutex
+	// hostLabels holds the display names and notes of hosts, set with
+	// -config or through /api/config/reload.
+	hostLabels = make(map[string]HostLabel)
+	// discoveredGroups holds the groups a discovery source such as -consul
+	// puts its hosts in. A label's groups take precedence.
+	discoveredGroups = make(map[string][]string)
+)
+
+// labels returns a copy of the host labels.
+func labels() map[string]HostLabel {
+	labelsMu.RLock()
+	defer labelsMu.RUnlock()
+	l := make(map[string]HostLabel, len(hostLabels))
+	for h, v := range hostLabels {
+		l[h] = v
+	}
+	return l
+}
+
+// setLabels replaces the host labels.
+func setLabels(l map[string]HostLabel) {
+	labelsMu.Lock()
+	defer labelsMu.Unlock()
+	hostLabels = make(map[string]HostLabel, len(l))
+	for h, v := range l {
+		hostLabels[h] = v
+	}
+}
+
+// setDiscoveredGroups sets the groups a discovery source reports for host,
+// nil to forget them.
+func setDiscoveredGroups(host string, groups []string) {
+	labelsMu.Lock()
+	defer labelsMu.Unlock()
+	if len(groups) == 0 {
+		delete(discoveredGroups, host)
+		return
+	}
+	discoveredGroups[host] = groups
+}
+
+// annotateLabels sets the display name, notes, priority and groups of
+// statuses from the host labels. A label's name replaces the name of a logical host.
+func annotateLabels(statuses []HostStatus) {
+	labelsMu.RLock()
+	defer labelsMu.RUnlock()
+	for i := range statuses {
+		statuses[i].Groups = discoveredGroups[statuses[i].Host]
+		l, ok := hostLabels[statuses[i].Host]
+		if !ok {
+			continue
+		}
+		if l.Name != "" {
+			statuses[i].Name = l.Name
+		}
+		statuses[i].Notes = l.Notes
+		statuses[i].Priority = l.Priority
+		if len(l.Groups) > 0 {
+			statuses[i].Groups = l.Groups
+		}
+	}
+}
+
+// hostGroups returns the groups of host from its label, or those its
+// discovery source reports without any.
+func hostGroups(host string) []string {
+	labelsMu.RLock()
+	defer labelsMu.RUnlock()
+	if g := hostLabels[host].Groups; len(g) > 0 {
+		return g
+	}
+	return discoveredGroups[host]
+}
+
+// equal reports whether l and p are the same label.
+func (l HostLabel) equal(p HostLabel) bool {
+	return l.String() == p.String()
+}
+
+// String renders l as `"Office FW" (rack 3) [critical] @core @office`.
+func (l HostLabel) String() string {
+	var parts []string
+	if l.Name != "" {
+		parts = append(parts, strconv.Quote(l.Name))
+	}
+	if l.Notes != "" {
+		parts = append(parts, fmt.Sprintf("(%s)", l.Notes))
+	}
+	if l.Priority != "" {
+		parts = append(parts, fmt.Sprintf("[%s]", l.Priority))
+	}
+	for _, g := range l.Groups {
+		parts = append(parts, "@"+g)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotateLabels(t *testing.T) {
+	defer setLabels(labels())
+	setLabels(map[string]HostLabel{
+		"10.0.0.1": {Name: "Office FW – rack 3, port 17", Notes: "Ask facilities before rebooting"},
+		"core":     {Notes: "Core switches", Priority: priorityCritical},
+	})
+	statuses := []HostStatus{{Host: "10.0.0.1"}, {Host: "core", Name: "core"}, {Host: "8.8.8.8"}}
+	annotateLabels(statuses)
+	assert.Equal(t, "Office FW – rack 3, port 17", statuses[0].Name)
+	assert.Equal(t, "Ask facilities before rebooting", statuses[0].Notes)
+	assert.Equal(t, "core", statuses[1].Name, "notes alone keep a logical host's name")
+	assert.Equal(t, "Core switches", statuses[1].Notes)
+	assert.Equal(t, priorityCritical, statuses[1].Priority)
+	assert.Equal(t, HostStatus{Host: "8.8.8.8"}, statuses[2])
+}
+
+func TestConfigLabels(t *testing.T) {
+	old := Config{Hosts: []string{"a", "b"}, Labels: map[string]HostLabel{"a": {Name: "Gateway"}}}
+	next := Config{Hosts: []string{"a", "b"}, Labels: map[string]HostLabel{"b": {Name: "NAS", Notes: "rack 2"}}}
+	assert.NoError(t, next.validate())
+	assert.Error(t, Config{Hosts: []string{"a"}, Labels: map[string]HostLabel{"c": {Name: "C"}}}.validate())
+
+	d := diffConfig(old, next)
+	assert.Len(t, d.LabelsChanged, 2)
+	assert.Equal(t, "~ a label \"Gateway\" -> none\n~ b label none -> \"NAS\" (rack 2)\n", d.String())
+	assert.True(t, diffConfig(next, next).empty())
+	assert.Equal(t, `(rack 2) [low]`, HostLabel{Notes: "rack 2", Priority: priorityLow}.String())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+var (
+	// discoverLAN turns on ARP sweeps of the attached IPv4 subnets for live
+	// hosts, set with -discover-lan.
+	discoverLAN bool
+	// lanExclude is the -discover-lan-exclude value: comma-separated
+	// addresses, CIDRs and ranges a sweep leaves out.
+	lanExclude string
+)
+
+var (
+	// attachedSubnets lists the subnets to sweep, a variable to allow mocking
+	// in tests.
+	attachedSubnets = lanSubnets
+	// sweepSubnet sends the ARP requests of a sweep, a variable to allow
+	// mocking in tests.
+	sweepSubnet = arpSweep
+)
+
+// lanSubnet is an attached IPv4 subnet an ARP sweep covers.
+type lanSubnet struct {
+	iface  *net.Interface
+	src    net.IP // Address of the interface in the subnet
+	prefix netip.Prefix
+}
+
+// lanSubnets returns the IPv4 subnets of the interfaces that are up and
+// have an Ethernet address, leaving out loopback and point-to-point links.
+func lanSubnets() ([]lanSubnet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var subnets []lanSubnet
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&(net.FlagLoopback|net.FlagPointToPoint) != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if !ok || n.IP.To4() == nil {
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(n.IP.To4())
+			bits, _ := n.Mask.Size()
+			if bits < 31 {
+				subnets = append(subnets, lanSubnet{iface: iface, src: n.IP.To4(), prefix: netip.PrefixFrom(ip, bits).Masked()})
+			}
+		}
+	}
+	return subnets, nil
+}
+
+// parseLANExclude parses -discover-lan-exclude.
+//
+// Returns:
+//   - func(netip.Addr) bool: Reports whether an address is excluded
+//   - error: If an entry is not an address, CIDR or range
+func parseLANExclude(s string) (func(netip.Addr) bool, error) {
+	var prefixes []netip.Prefix
+	var ranges [][2]netip.Addr
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		if a, err := netip.ParseAddr(e); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+		} else if p, err := netip.ParsePrefix(e); err == nil {
+			prefixes = append(prefixes, p.Masked())
+		} else if from, to, ok := parseRange(e); ok {
+			ranges = append(ranges, [2]netip.Addr{from, to})
+		} else {
+			return nil, fmt.Errorf("invalid -discover-lan-exclude entry %q, expected an address, CIDR or range", e)
+		}
+	}
+	return func(a netip.Addr) bool {
+		for _, p := range prefixes {
+			if p.Contains(a) {
+				return true
+			}
+		}
+		for _, r := range ranges {
+			if a.Compare(r[0]) >= 0 && a.Compare(r[1]) <= 0 {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// lanProvider is the live hosts of the attached subnets. Hosts once found
+// stay monitored, as a host that stops answering is down, not gone.
+type lanProvider struct {
+	mu      sync.Mutex
+	found   map[string]discovered
+	skipped map[netip.Prefix]bool // Subnets too large to sweep, logged once
+}
+
+// String implements Discovery.
+func (p *lanProvider) String() string { return "LAN" }
+
+// List implements Discovery: ARP-sweeps every attached subnet that holds at
+// most -expand-limit hosts and returns the hosts that answered this or an
+// earlier sweep keyed by address, in the groups "lan" and "lan/<interface>".
+// Addresses of mosaic itself and those of -discover-lan-exclude are left
+// out.
+func (p *lanProvider) List(ctx context.Context) (map[string]discovered, error) {
+	excluded, err := parseLANExclude(lanExclude)
+	if err != nil {
+		return nil, err
+	}
+	subnets, err := attachedSubnets()
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.found == nil {
+		p.found = make(map[string]discovered)
+		p.skipped = make(map[netip.Prefix]bool)
+	}
+	for _, s := range subnets {
+		addrs, err := expandCIDR(s.prefix, expandLimit)
+		if err != nil {
+			if !p.skipped[s.prefix] {
+				p.skipped[s.prefix] = true
+				log.Printf("LAN discovery: skipping %s on %s: %v", s.prefix, s.iface.Name, err)
+			}
+			continue
+		}
+		var targets []net.IP
+		for _, a := range addrs {
+			ip := netip.MustParseAddr(a)
+			if a != s.src.String() && !excluded(ip) {
+				targets = append(targets, net.IP(ip.AsSlice()))
+			}
+		}
+		live, err := sweepSubnet(ctx, s.iface, s.src, targets)
+		if err != nil {
+			return nil, fmt.Errorf("sweep of %s on %s: %v", s.prefix, s.iface.Name, err)
+		}
+		for _, ip := range live {
+			p.found[ip] = discovered{entry: ip, groups: []string{"lan", "lan/" + s.iface.Name}}
+		}
+	}
+	return maps.Clone(p.found), nil
+}
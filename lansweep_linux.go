@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// lanSweepSupported reports whether -discover-lan works on this platform.
+const lanSweepSupported = true
+
+// arpSweep sends an ARP request for each of targets on iface from src and
+// collects the replies until -timeout after the last request. Needs the
+// same raw socket privileges as ICMP.
+//
+// Returns:
+//   - []string: The addresses of the targets that answered
+//   - error: If the socket cannot be opened or ctx is done
+func arpSweep(ctx context.Context, iface *net.Interface, src net.IP, targets []net.IP) ([]string, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("arp socket: %w", err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}); err != nil {
+		return nil, fmt.Errorf("arp bind: %w", err)
+	}
+	pending := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		pending[t.String()] = true
+	}
+	var live []string
+	// receive reads the replies that arrived so far
+	receive := func() {
+		buf := make([]byte, 128)
+		for {
+			n, _, err := unix.Recvfrom(fd, buf, unix.MSG_DONTWAIT)
+			if err != nil {
+				return
+			}
+			if n < 28 {
+				continue
+			}
+			sender := net.IP(buf[14:18]).String()
+			if _, ok := parseARPReply(buf[:n], net.IP(buf[14:18])); ok && pending[sender] {
+				delete(pending, sender)
+				live = append(live, sender)
+			}
+		}
+	}
+
+	broadcast := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index, Halen: 6}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	// Pace the requests so a sweep does not flood the segment with
+	// broadcasts
+	tick := time.NewTicker(2 * time.Millisecond)
+	defer tick.Stop()
+	for _, t := range targets {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-tick.C:
+		}
+		unix.Sendto(fd, buildARPRequest(iface.HardwareAddr, src, t), 0, broadcast)
+		receive()
+	}
+	deadline := time.Now().Add(probeTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+		receive()
+	}
+	return live, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// lanSweepSupported reports whether -discover-lan works on this platform.
+const lanSweepSupported = false
+
+// arpSweep is only implemented on Linux, where AF_PACKET sockets are available.
+func arpSweep(ctx context.Context, iface *net.Interface, src net.IP, targets []net.IP) ([]string, error) {
+	return nil, fmt.Errorf("LAN discovery is only supported on Linux")
+}
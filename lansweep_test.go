@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLANExclude(t *testing.T) {
+	excluded, err := parseLANExclude("192.168.1.1, 192.168.1.128/25,192.168.1.10-20")
+	assert.NoError(t, err)
+	for addr, want := range map[string]bool{
+		"192.168.1.1":   true,
+		"192.168.1.2":   false,
+		"192.168.1.15":  true,
+		"192.168.1.21":  false,
+		"192.168.1.200": true,
+	} {
+		assert.Equal(t, want, excluded(netip.MustParseAddr(addr)), addr)
+	}
+
+	_, err = parseLANExclude("printer")
+	assert.Error(t, err)
+}
+
+func TestLANProvider(t *testing.T) {
+	oldSubnets, oldSweep, oldExclude := attachedSubnets, sweepSubnet, lanExclude
+	t.Cleanup(func() { attachedSubnets, sweepSubnet, lanExclude = oldSubnets, oldSweep, oldExclude })
+	eth0 := &net.Interface{Name: "eth0", HardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1}}
+	attachedSubnets = func() ([]lanSubnet, error) {
+		return []lanSubnet{
+			{iface: eth0, src: net.ParseIP("192.168.1.2").To4(), prefix: netip.MustParsePrefix("192.168.1.0/29")},
+			{iface: &net.Interface{Name: "docker0"}, src: net.ParseIP("172.17.0.1").To4(), prefix: netip.MustParsePrefix("172.17.0.0/16")},
+		}, nil
+	}
+	lanExclude = "192.168.1.1"
+	var swept []string
+	live := []string{"192.168.1.3", "192.168.1.5"}
+	sweepSubnet = func(ctx context.Context, iface *net.Interface, src net.IP, targets []net.IP) ([]string, error) {
+		swept = nil
+		for _, ip := range targets {
+			swept = append(swept, ip.String())
+		}
+		return live, nil
+	}
+
+	p := &lanProvider{}
+	hosts, err := p.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.3", "192.168.1.4", "192.168.1.5", "192.168.1.6"}, swept, "own and excluded addresses are not swept, subnets over -expand-limit are skipped")
+	groups := []string{"lan", "lan/eth0"}
+	assert.Equal(t, map[string]discovered{
+		"192.168.1.3": {entry: "192.168.1.3", groups: groups},
+		"192.168.1.5": {entry: "192.168.1.5", groups: groups},
+	}, hosts)
+
+	live = []string{"192.168.1.4"}
+	hosts, err = p.List(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 3, "hosts that stop answering stay monitored")
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// lldpSeeds is the -lldp value: the comma-separated switches, by name or
+	// IPv4 address, whose LLDP and CDP neighbor tables are walked over SNMP.
+	// Empty turns neighbor discovery off.
+	lldpSeeds string
+	// lldpDepth is how many hops from the seeds neighbors are walked in
+	// turn, set with -lldp-depth. 0 walks the seeds only.
+	lldpDepth = 2
+)
+
+// OIDs of the neighbor tables.
+const (
+	// lldpRemSysName is the system name column of the LLDP-MIB remote
+	// table, indexed by time mark, local port and remote index.
+	lldpRemSysName = "1.0.8802.1.1.2.1.4.1.1.9"
+	// lldpRemManAddrIfSubtype is a column of the LLDP-MIB remote management
+	// address table, whose index ends in the address subtype, length and
+	// bytes.
+	lldpRemManAddrIfSubtype = "1.0.8802.1.1.2.1.4.2.1.3"
+	// cdpCacheAddress and cdpCacheDeviceID are columns of the CISCO-CDP-MIB
+	// cache table, indexed by interface and device index.
+	cdpCacheAddress  = "1.3.6.1.4.1.9.9.23.1.2.1.1.4"
+	cdpCacheDeviceID = "1.3.6.1.4.1.9.9.23.1.2.1.1.6"
+	// snmpSysName is the name of the walked device itself.
+	snmpSysName = "1.3.6.1.2.1.1.5"
+)
+
+// lldpNeighbor is a device a switch reports in its LLDP or CDP table.
+type lldpNeighbor struct {
+	name string
+	addr string // IPv4 management address
+}
+
+// oidKey returns the arcs of oid after the first n as a map key.
+func oidKey(oid []int, n int) string {
+	return fmt.Sprint(oid[n:])
+}
+
+// walkNeighbors walks the LLDP and CDP tables of the switch at addr. Neighbors
+// without an IPv4 management address are left out.
+//
+// Returns:
+//   - string: The sysName of the switch
+//   - []lldpNeighbor: Its neighbors
+//   - error: If the switch does not answer SNMP
+func walkNeighbors(ctx context.Context, addr string) (string, []lldpNeighbor, error) {
+	self, err := snmpWalk(ctx, addr, snmpSysName)
+	if err != nil {
+		return "", nil, err
+	}
+	var name string
+	if len(self) > 0 {
+		name = self[0].String()
+	}
+	var found []lldpNeighbor
+
+	// LLDP: join the names and addresses by time mark, port and index
+	names, err := snmpWalk(ctx, addr, lldpRemSysName)
+	if err != nil {
+		return "", nil, err
+	}
+	rootLen := len(parseOID(lldpRemSysName))
+	byRemote := make(map[string]string)
+	for _, v := range names {
+		if len(v.oid) == rootLen+3 {
+			byRemote[oidKey(v.oid, rootLen)] = v.String()
+		}
+	}
+	addrs, err := snmpWalk(ctx, addr, lldpRemManAddrIfSubtype)
+	if err != nil {
+		return "", nil, err
+	}
+	rootLen = len(parseOID(lldpRemManAddrIfSubtype))
+	for _, v := range addrs {
+		// time mark, port, index, subtype 1 (IPv4), length 4, 4 bytes
+		idx := v.oid[rootLen:]
+		if len(idx) != 9 || idx[3] != 1 || idx[4] != 4 {
+			continue
+		}
+		ip := net.IPv4(byte(idx[5]), byte(idx[6]), byte(idx[7]), byte(idx[8])).String()
+		found = append(found, lldpNeighbor{name: byRemote[fmt.Sprint(idx[:3])], addr: ip})
+	}
+
+	// CDP: the address is the value, of type IP when it has 4 bytes
+	ids, err := snmpWalk(ctx, addr, cdpCacheDeviceID)
+	if err != nil {
+		return "", nil, err
+	}
+	rootLen = len(parseOID(cdpCacheDeviceID))
+	byDevice := make(map[string]string)
+	for _, v := range ids {
+		byDevice[oidKey(v.oid, rootLen)] = v.String()
+	}
+	cdp, err := snmpWalk(ctx, addr, cdpCacheAddress)
+	if err != nil {
+		return "", nil, err
+	}
+	rootLen = len(parseOID(cdpCacheAddress))
+	for _, v := range cdp {
+		if len(v.value) == 4 {
+			found = append(found, lldpNeighbor{name: byDevice[oidKey(v.oid, rootLen)], addr: net.IP(v.value).String()})
+		}
+	}
+	return name, found, nil
+}
+
+// lldpProvider is the devices around the -lldp switches.
+type lldpProvider struct{}
+
+// String implements Discovery.
+func (lldpProvider) String() string { return "LLDP" }
+
+// deviceEntry returns the host entry of a device: a logical host named
+// after it with its address, e.g. "core-sw1=10.0.0.1", or its address
+// alone when it has no name.
+func deviceEntry(name, addr string) string {
+	// Names may be domain names or CDP device IDs such as "sw2(FOC1234)"
+	name, _, _ = strings.Cut(name, "(")
+	if name = strings.TrimSpace(name); name == "" {
+		return addr
+	}
+	return logicalEntry(hostLabel(name), []string{addr})
+}
+
+// List implements Discovery: walks the neighbor tables of the seeds and, up
+// to -lldp-depth hops away, of their neighbors, and returns the seeds and
+// every neighbor with an IPv4 management address keyed by address, in the
+// group "lldp". Each neighbor depends on the switch it was first seen
+// from, so the board shows the topology and a switch outage does not raise
+// an alert for every device behind it.
+func (lldpProvider) List(ctx context.Context) (map[string]discovered, error) {
+	hosts := make(map[string]discovered)
+	type visit struct {
+		addr  string
+		depth int
+	}
+	var queue []visit
+	for _, s := range strings.Split(lldpSeeds, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		// Key seeds by address, as they show up among each other's neighbors
+		if net.ParseIP(s) == nil {
+			ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", s)
+			if err != nil {
+				return nil, err
+			}
+			s = ips[0].String()
+		}
+		queue = append(queue, visit{addr: s})
+	}
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		if seen[v.addr] {
+			continue
+		}
+		seen[v.addr] = true
+		name, found, err := walkNeighbors(ctx, v.addr)
+		if err != nil {
+			if v.depth == 0 {
+				return nil, err
+			}
+			// Most neighbors are hosts without SNMP
+			continue
+		}
+		if v.depth == 0 {
+			hosts[v.addr] = discovered{entry: deviceEntry(name, v.addr), groups: []string{"lldp"}}
+		}
+		self := hosts[v.addr].entry
+		for _, n := range found {
+			if _, ok := hosts[n.addr]; ok || seen[n.addr] {
+				continue
+			}
+			hosts[n.addr] = discovered{entry: deviceEntry(n.name, n.addr), groups: []string{"lldp"}, parent: self}
+			if v.depth < lldpDepth {
+				queue = append(queue, visit{addr: n.addr, depth: v.depth + 1})
+			}
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lldpRemote returns the MIB variables of an LLDP neighbor on port.
+func lldpRemote(port, index, name string, ip [4]byte) []mibVar {
+	idx := ".0." + port + "." + index
+	addr := ".1.4." + net.IP(ip[:]).String()
+	return []mibVar{
+		{lldpRemSysName + idx, berOctetString, []byte(name)},
+		{lldpRemManAddrIfSubtype + idx + addr, berInteger, []byte{2}},
+	}
+}
+
+func TestDeviceEntry(t *testing.T) {
+	assert.Equal(t, "sw2=10.0.0.2", deviceEntry("sw2(FOC1234X0AB)", "10.0.0.2"))
+	assert.Equal(t, "core-sw1.corp=10.0.0.1", deviceEntry("core-sw1.corp", "10.0.0.1"))
+	assert.Equal(t, "10.0.0.9", deviceEntry("", "10.0.0.9"))
+}
+
+func TestLLDPProvider(t *testing.T) {
+	oldSeeds, oldDepth, oldPort, oldTimeout := lldpSeeds, lldpDepth, snmpPort, probeTimeout
+	t.Cleanup(func() { lldpSeeds, lldpDepth, snmpPort, probeTimeout = oldSeeds, oldDepth, oldPort, oldTimeout })
+	probeTimeout = 50 * time.Millisecond
+
+	// core-sw1 sees access-sw2 over LLDP and a phone over CDP, access-sw2
+	// sees core-sw1 back and a printer without a name
+	core := append([]mibVar{{snmpSysName + ".0", berOctetString, []byte("core-sw1")}},
+		lldpRemote("1", "1", "access-sw2", [4]byte{127, 0, 0, 2})...)
+	core = append(core,
+		mibVar{cdpCacheAddress + ".10005.1", berOctetString, []byte{127, 0, 0, 3}},
+		mibVar{cdpCacheDeviceID + ".10005.1", berOctetString, []byte("phone(SEP0011)")})
+	access := append([]mibVar{{snmpSysName + ".0", berOctetString, []byte("access-sw2")}},
+		lldpRemote("48", "1", "core-sw1", [4]byte{127, 0, 0, 1})...)
+	access = append(access, lldpRemote("7", "2", "", [4]byte{127, 0, 0, 4})...)
+	_, snmpPort, _ = net.SplitHostPort(serveSNMP(t, "127.0.0.1:0", "public", core))
+	serveSNMP(t, "127.0.0.2:"+snmpPort, "public", access)
+
+	lldpSeeds, lldpDepth = "127.0.0.1", 1
+	hosts, err := lldpProvider{}.List(context.Background())
+	assert.NoError(t, err)
+	core1, access2 := "core-sw1=127.0.0.1", "access-sw2=127.0.0.2"
+	groups := []string{"lldp"}
+	assert.Equal(t, map[string]discovered{
+		"127.0.0.1": {entry: core1, groups: groups},
+		"127.0.0.2": {entry: access2, groups: groups, parent: core1},
+		"127.0.0.3": {entry: "phone=127.0.0.3", groups: groups, parent: core1},
+		"127.0.0.4": {entry: "127.0.0.4", groups: groups, parent: access2},
+	}, hosts)
+
+	lldpDepth = 0
+	hosts, err = lldpProvider{}.List(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 3, "only the seed is walked")
+
+	lldpSeeds = "127.0.0.9"
+	_, err = lldpProvider{}.List(context.Background())
+	assert.Error(t, err, "seeds must answer")
+}
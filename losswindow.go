@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+// lossWindow is how far back packet loss is calculated, set with
+// -loss-window, so a host that was down yesterday does not show loss today.
+// Zero keeps every probe.
+var lossWindow = 5 * time.Minute
+
+// lossProbes caps the probes packet loss is calculated over, set with
+// -loss-probes. Zero means no cap besides lossWindow.
+var lossProbes int
+
+// lossSample is the packet counters of one probe.
+type lossSample struct {
+	at   time.Time
+	sent int
+	recv int
+}
+
+// add records the counters of a probe made at now and drops the probes
+// that fell out of the window, keeping Sent and Recv the totals of the
+// probes left.
+//
+// Parameters:
+//   - now: When the probe was made
+//   - sent: Packets sent by the probe
+//   - recv: Packets received by the probe
+//   - window: How long probes are kept, 0 for no limit
+//   - maxProbes: How many probes are kept, 0 for no limit
+func (hs *HostStats) add(now time.Time, sent, recv int, window time.Duration, maxProbes int) {
+	hs.samples = append(hs.samples, lossSample{at: now, sent: sent, recv: recv})
+	hs.Sent += sent
+	hs.Recv += recv
+	drop := 0
+	for drop < len(hs.samples)-1 {
+		s := hs.samples[drop]
+		if (window <= 0 || now.Sub(s.at) <= window) && (maxProbes <= 0 || len(hs.samples)-drop <= maxProbes) {
+			break
+		}
+		hs.Sent -= s.sent
+		hs.Recv -= s.recv
+		drop++
+	}
+	hs.samples = hs.samples[drop:]
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLossWindowByTime(t *testing.T) {
+	hs := &HostStats{}
+	start := time.Now()
+	hs.add(start, 4, 0, 5*time.Minute, 0)
+	hs.add(start.Add(time.Minute), 4, 4, 5*time.Minute, 0)
+	assert.Equal(t, 8, hs.Sent)
+	assert.Equal(t, 4, hs.Recv)
+
+	// The outage falls out of the window
+	hs.add(start.Add(6*time.Minute), 4, 4, 5*time.Minute, 0)
+	assert.Equal(t, 8, hs.Sent)
+	assert.Equal(t, 8, hs.Recv)
+	assert.Len(t, hs.samples, 2)
+
+	// The latest probe is always kept, even with a tiny window
+	hs.add(start.Add(time.Hour), 1, 0, time.Second, 0)
+	assert.Equal(t, 1, hs.Sent)
+	assert.Equal(t, 0, hs.Recv)
+}
+
+func TestLossWindowByProbes(t *testing.T) {
+	hs := &HostStats{}
+	now := time.Now()
+	hs.add(now, 1, 0, 0, 3)
+	for i := 0; i < 3; i++ {
+		hs.add(now, 1, 1, 0, 3)
+	}
+	assert.Equal(t, 3, hs.Sent)
+	assert.Equal(t, 3, hs.Recv)
+
+	// Without limits every probe counts
+	hs = &HostStats{}
+	hs.add(now, 1, 0, 0, 0)
+	hs.add(now.Add(24*time.Hour), 1, 1, 0, 0)
+	assert.Equal(t, 2, hs.Sent)
+}
+
+func TestRecordStatsWindow(t *testing.T) {
+	defer func(n int) { lossProbes = n }(lossProbes)
+	defer func() {
+		hostStatsMu.Lock()
+		delete(hostStats, "window-host")
+		hostStatsMu.Unlock()
+	}()
+	lossProbes = 2
+	assert.Equal(t, 100.0, recordStats("window-host", probeResult{Sent: 2}))
+	assert.Equal(t, 50.0, recordStats("window-host", probeResult{Sent: 2, Recv: 2}))
+	assert.Equal(t, 0.0, recordStats("window-host", probeResult{Sent: 2, Recv: 2}), "the lost probe fell out of the window")
+}
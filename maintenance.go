@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostPause describes a host in maintenance as served by /api/maintenance.
+type HostPause struct {
+	Host   string     `json:"host"`
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"`  // When probing resumes by itself
+	Reason string     `json:"reason,omitempty"` // Why the host is paused, e.g. "firmware upgrade"
+}
+
+// maintenanceList holds the hosts whose probing is paused, e.g. for a planned
+// reboot. Paused hosts are not probed, so their downtime neither adds packet
+// loss nor raises alerts; the dashboard shows them grey.
+type maintenanceList struct {
+	mu     sync.Mutex
+	paused map[string]HostPause
+}
+
+var maintenance = newMaintenanceList()
+
+// newMaintenanceList creates a list without paused hosts.
+func newMaintenanceList() *maintenanceList {
+	return &maintenanceList{paused: make(map[string]HostPause)}
+}
+
+// pause stops probing host. A positive d resumes it after that long;
+// pausing a paused host replaces its pause.
+//
+// Parameters:
+//   - host: The monitored host to pause
+//   - d: How long to pause it, or 0 until resumed
+//   - reason: Shown on the dashboard
+//   - now: Current time
+func (m *maintenanceList) pause(host string, d time.Duration, reason string, now time.Time) {
+	p := HostPause{Host: host, Since: now, Reason: reason}
+	if d > 0 {
+		until := now.Add(d)
+		p.Until = &until
+	}
+	m.mu.Lock()
+	m.paused[host] = p
+	m.mu.Unlock()
+	msg := host + " paused for maintenance"
+	if d > 0 {
+		msg += " for " + d.String()
+	}
+	if reason != "" {
+		msg += ": " + reason
+	}
+	events.add(Event{Time: now, Type: "maintenance_started", Hosts: []string{host}, Message: msg})
+}
+
+// resume starts probing host again.
+//
+// Returns:
+//   - bool: False if host was not paused
+func (m *maintenanceList) resume(host string, now time.Time) bool {
+	m.mu.Lock()
+	_, ok := m.paused[host]
+	delete(m.paused, host)
+	m.mu.Unlock()
+	if ok {
+		events.add(Event{Time: now, Type: "maintenance_ended", Hosts: []string{host}, Message: host + " resumed after maintenance"})
+	}
+	return ok
+}
+
+// expire resumes the hosts whose pause ran out.
+func (m *maintenanceList) expire(now time.Time) {
+	m.mu.Lock()
+	var ended []string
+	for h, p := range m.paused {
+		if p.Until != nil && !now.Before(*p.Until) {
+			ended = append(ended, h)
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(ended)
+	for _, h := range ended {
+		m.resume(h, now)
+	}
+}
+
+// isPaused reports whether host is in maintenance.
+func (m *maintenanceList) isPaused(host string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.paused[host]
+	return ok
+}
+
+// active returns the hosts of hosts that are not paused, in order.
+func (m *maintenanceList) active(hosts []string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.paused) == 0 {
+		return hosts
+	}
+	out := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		if _, ok := m.paused[h]; !ok {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// mark returns the statuses to show for hosts: the latest of each host from
+// statuses, with paused hosts marked as such, keeping their last result if
+// they have one.
+func (m *maintenanceList) mark(hosts []string, statuses []HostStatus) []HostStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.paused) == 0 {
+		return statuses
+	}
+	latest := make(map[string]HostStatus, len(statuses))
+	for _, s := range statuses {
+		latest[s.Host] = s
+	}
+	out := make([]HostStatus, 0, len(hosts))
+	for _, h := range hosts {
+		s, ok := latest[h]
+		if p, paused := m.paused[h]; paused {
+			s = HostStatus{Host: h, Name: s.Name, Paused: true, LatencyMs: s.LatencyMs, PacketLoss: s.PacketLoss, Detail: p.Reason}
+		} else if !ok {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// forget drops the pause of host without an event, e.g. when the host is
+// removed.
+func (m *maintenanceList) forget(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.paused, host)
+}
+
+// list returns the paused hosts, sorted by host.
+func (m *maintenanceList) list() []HostPause {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]HostPause, 0, len(m.paused))
+	for _, p := range m.paused {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// parsePauseList pauses the comma-separated hosts of the -pause flag until
+// they are resumed through the API. Hosts that are not monitored are
+// reported.
+//
+// Returns:
+//   - []string: The hosts in arg that are not monitored
+func parsePauseList(arg string, monitored []string, now time.Time) []string {
+	known := make(map[string]bool, len(monitored))
+	for _, h := range monitored {
+		known[h] = true
+	}
+	var unknown []string
+	for _, h := range strings.Split(arg, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if !known[h] {
+			unknown = append(unknown, h)
+			continue
+		}
+		maintenance.pause(h, 0, "", now)
+	}
+	return unknown
+}
+
+// maintenanceHandler lists paused hosts (GET), pauses a host (POST with
+// {"host": "...", "duration": "30m", "reason": "..."}) or resumes one
+// (DELETE ?host=...).
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Host     string `json:"host"`
+			Duration string `json:"duration"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		var d time.Duration
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+		}
+		monitored := false
+		for _, h := range currentHosts() {
+			monitored = monitored || h == req.Host
+		}
+		if !monitored {
+			http.Error(w, "unknown host "+req.Host, http.StatusNotFound)
+			return
+		}
+		maintenance.pause(req.Host, d, req.Reason, time.Now())
+	case http.MethodDelete:
+		host := r.URL.Query().Get("host")
+		if !maintenance.resume(host, time.Now()) {
+			http.Error(w, "host "+host+" is not paused", http.StatusNotFound)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maintenance.list())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withMaintenance gives the test an empty maintenance list.
+func withMaintenance(t *testing.T) {
+	saved := maintenance
+	maintenance = newMaintenanceList()
+	t.Cleanup(func() { maintenance = saved })
+}
+
+func TestMaintenancePauseAndExpire(t *testing.T) {
+	withMaintenance(t)
+	now := time.Now()
+	maintenance.pause("db01", 30*time.Minute, "firmware upgrade", now)
+	maintenance.pause("web01", 0, "", now)
+	assert.True(t, maintenance.isPaused("db01"))
+	assert.Equal(t, []string{"db02"}, maintenance.active([]string{"db01", "db02", "web01"}))
+	assert.Equal(t, "db01 paused for maintenance for 30m0s: firmware upgrade", events.recent()[1].Message)
+
+	maintenance.expire(now.Add(29 * time.Minute))
+	assert.True(t, maintenance.isPaused("db01"))
+	maintenance.expire(now.Add(30 * time.Minute))
+	assert.False(t, maintenance.isPaused("db01"))
+	assert.Equal(t, "maintenance_ended", events.recent()[0].Type)
+	assert.True(t, maintenance.isPaused("web01"), "pauses without a duration last until resumed")
+
+	assert.True(t, maintenance.resume("web01", now))
+	assert.False(t, maintenance.resume("web01", now))
+}
+
+func TestMaintenanceMark(t *testing.T) {
+	withMaintenance(t)
+	maintenance.pause("b", 0, "reboot", time.Now())
+	maintenance.pause("c", 0, "", time.Now())
+	statuses := []HostStatus{{Host: "a", Alive: true}, {Host: "b", Alive: true, LatencyMs: 7}}
+	assert.Equal(t, []HostStatus{
+		{Host: "a", Alive: true},
+		{Host: "b", Paused: true, LatencyMs: 7, Detail: "reboot"},
+		{Host: "c", Paused: true},
+	}, maintenance.mark([]string{"a", "b", "c", "d"}, statuses), "paused hosts show even before their first probe")
+}
+
+func TestParsePauseList(t *testing.T) {
+	withMaintenance(t)
+	unknown := parsePauseList("db01, nas ,", []string{"db01", "web01"}, time.Now())
+	assert.Equal(t, []string{"nas"}, unknown)
+	assert.True(t, maintenance.isPaused("db01"))
+}
+
+func TestMaintenanceHandler(t *testing.T) {
+	withHosts(t, "db01", "web01")
+	withMaintenance(t)
+
+	rec := httptest.NewRecorder()
+	maintenanceHandler(rec, httptest.NewRequest("POST", "/api/maintenance", strings.NewReader(`{"host":"db01","duration":"1h","reason":"reboot"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var paused []HostPause
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &paused))
+	if assert.Len(t, paused, 1) {
+		assert.Equal(t, "reboot", paused[0].Reason)
+		assert.NotNil(t, paused[0].Until)
+	}
+
+	rec = httptest.NewRecorder()
+	maintenanceHandler(rec, httptest.NewRequest("POST", "/api/maintenance", strings.NewReader(`{"host":"nas"}`)))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = httptest.NewRecorder()
+	maintenanceHandler(rec, httptest.NewRequest("POST", "/api/maintenance", strings.NewReader(`{"host":"web01","duration":"-1h"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	maintenanceHandler(rec, httptest.NewRequest("DELETE", "/api/maintenance?host=db01", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "[]\n", rec.Body.String())
+	rec = httptest.NewRecorder()
+	maintenanceHandler(rec, httptest.NewRequest("DELETE", "/api/maintenance?host=db01", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRunCycleSkipsPaused(t *testing.T) {
+	withHosts(t, "sim://a?latency=0s", "sim://b?latency=0s")
+	withMaintenance(t)
+	defer func(saved *schedulerStats) { scheduler = saved }(scheduler)
+	scheduler = newSchedulerStats()
+	maintenance.pause("sim://b?latency=0s", 0, "", time.Now())
+
+	fresh := runCycle(context.Background(), false)
+	if assert.Len(t, fresh, 1) {
+		assert.Equal(t, "sim://a?latency=0s", fresh[0].Host)
+	}
+	assert.Len(t, scheduler.snapshot().Hosts, 1, "paused hosts are not scheduled")
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	// mdnsBrowse turns on mDNS discovery of the devices on the local
+	// network, set with -mdns.
+	mdnsBrowse bool
+	// mdnsServices is the -mdns-service value: the comma-separated service
+	// types to browse, e.g. "_ipp._tcp,_smb._tcp", or "" for every type
+	// advertised on the network.
+	mdnsServices string
+)
+
+// mdnsAddr is the multicast group mDNS queries are sent to, a variable to
+// allow mocking in tests.
+var mdnsAddr = "224.0.0.251:5353"
+
+// mdnsWait is how long answers to a query are collected, as responders
+// delay their answers by up to half a second and busy ones longer.
+var mdnsWait = 2 * time.Second
+
+// mdnsServiceTypes is the name whose PTR records list the service types
+// advertised on the network (RFC 6763 section 9).
+const mdnsServiceTypes = "_services._dns-sd._udp.local."
+
+// mdnsProvider is the devices advertising services with mDNS on the local
+// network.
+type mdnsProvider struct{}
+
+// String implements Discovery.
+func (mdnsProvider) String() string { return "mDNS" }
+
+// mdnsQuery sends one query for the PTR, SRV, A or AAAA records of names
+// and returns every record of the answers received within mdnsWait. The
+// query goes out from an ephemeral port, so responders answer it with
+// unicast (RFC 6762 section 6.7) and nothing has to listen on port 5353.
+func mdnsQuery(ctx context.Context, typ dnsmessage.Type, names []string) ([]dnsmessage.Resource, error) {
+	var questions []dnsmessage.Question
+	for _, name := range names {
+		qname, err := dnsmessage.NewName(name)
+		if err != nil {
+			continue
+		}
+		questions = append(questions, dnsmessage.Question{Name: qname, Type: typ, Class: dnsmessage.ClassINET})
+	}
+	if len(questions) == 0 {
+		return nil, nil
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:])},
+		Questions: questions,
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(mdnsWait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	if _, err := conn.WriteToUDP(query, group); err != nil {
+		return nil, err
+	}
+	var records []dnsmessage.Resource
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends the collection
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return records, ctx.Err()
+			}
+			return nil, err
+		}
+		var reply dnsmessage.Message
+		if reply.Unpack(buf[:n]) != nil || !reply.Response {
+			continue
+		}
+		records = append(records, reply.Answers...)
+		records = append(records, reply.Additionals...)
+	}
+}
+
+// mdnsRecords holds the records a browse has learned, by lowercased name.
+type mdnsRecords struct {
+	ptr   map[string][]string // Service type to instances, or service types
+	srv   map[string]string   // Instance to target host
+	addrs map[string][]string // Target host to addresses
+}
+
+// add files the records rs.
+func (r *mdnsRecords) add(rs []dnsmessage.Resource) {
+	for _, rr := range rs {
+		name := strings.ToLower(rr.Header.Name.String())
+		switch b := rr.Body.(type) {
+		case *dnsmessage.PTRResource:
+			ptr := strings.ToLower(b.PTR.String())
+			if !slices.Contains(r.ptr[name], ptr) {
+				r.ptr[name] = append(r.ptr[name], ptr)
+			}
+		case *dnsmessage.SRVResource:
+			r.srv[name] = strings.ToLower(b.Target.String())
+		case *dnsmessage.AResource:
+			r.addAddr(name, net.IP(b.A[:]))
+		case *dnsmessage.AAAAResource:
+			r.addAddr(name, net.IP(b.AAAA[:]))
+		}
+	}
+}
+
+// addAddr adds ip to the addresses of host. Link-local IPv6 addresses are
+// left out, as they cannot be probed without a zone.
+func (r *mdnsRecords) addAddr(host string, ip net.IP) {
+	if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+		return
+	}
+	if s := ip.String(); !slices.Contains(r.addrs[host], s) {
+		r.addrs[host] = append(r.addrs[host], s)
+	}
+}
+
+// List implements Discovery: browses the -mdns-service types, or every
+// advertised type, and returns each device that advertises one of them
+// keyed by its host name, as a logical host named after it with its
+// addresses, e.g. "nas=192.168.1.20", in the group "mdns".
+func (p mdnsProvider) List(ctx context.Context) (map[string]discovered, error) {
+	r := &mdnsRecords{ptr: make(map[string][]string), srv: make(map[string]string), addrs: make(map[string][]string)}
+	var types []string
+	for _, t := range strings.Split(mdnsServices, ",") {
+		if t = strings.Trim(strings.TrimSpace(t), "."); t != "" {
+			if !strings.HasSuffix(t, ".local") {
+				t += ".local"
+			}
+			types = append(types, strings.ToLower(t)+".")
+		}
+	}
+	if len(types) == 0 {
+		rs, err := mdnsQuery(ctx, dnsmessage.TypePTR, []string{mdnsServiceTypes})
+		if err != nil {
+			return nil, err
+		}
+		r.add(rs)
+		types = r.ptr[mdnsServiceTypes]
+	}
+
+	// Responders usually send the SRV and address records along with the
+	// PTR records, ask for what is missing
+	rs, err := mdnsQuery(ctx, dnsmessage.TypePTR, types)
+	if err != nil {
+		return nil, err
+	}
+	r.add(rs)
+	var instances, missing []string
+	for _, t := range types {
+		instances = append(instances, r.ptr[t]...)
+	}
+	for _, i := range instances {
+		if _, ok := r.srv[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+	if rs, err = mdnsQuery(ctx, dnsmessage.TypeSRV, missing); err != nil {
+		return nil, err
+	}
+	r.add(rs)
+	missing = nil
+	for _, i := range instances {
+		if target, ok := r.srv[i]; ok && len(r.addrs[target]) == 0 && !slices.Contains(missing, target) {
+			missing = append(missing, target)
+		}
+	}
+	if rs, err = mdnsQuery(ctx, dnsmessage.TypeA, missing); err != nil {
+		return nil, err
+	}
+	r.add(rs)
+
+	hosts := make(map[string]discovered)
+	for _, i := range instances {
+		target := r.srv[i]
+		addrs := r.addrs[target]
+		if len(addrs) == 0 {
+			continue
+		}
+		sort.Strings(addrs)
+		name := strings.TrimSuffix(strings.TrimSuffix(target, "."), ".local")
+		hosts[target] = discovered{entry: logicalEntry(hostLabel(name), addrs), groups: []string{"mdns"}}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsRR returns a resource record of name.
+func mdnsRR(name string, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: 120},
+		Body:   body,
+	}
+}
+
+// serveMDNS answers mDNS queries on a local UDP socket from records, by
+// name and type, sending extras of the name along as additionals, and
+// points mdnsAddr at it.
+func serveMDNS(t *testing.T, records map[string]map[dnsmessage.Type][]dnsmessage.ResourceBody, extras map[string][]dnsmessage.Resource) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldAddr, oldWait := mdnsAddr, mdnsWait
+	mdnsAddr, mdnsWait = conn.LocalAddr().String(), 200*time.Millisecond
+	t.Cleanup(func() {
+		conn.Close()
+		mdnsAddr, mdnsWait = oldAddr, oldWait
+	})
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil {
+				continue
+			}
+			reply := dnsmessage.Message{Header: dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true}}
+			for _, q := range query.Questions {
+				// Names compare case-insensitively
+				for name, types := range records {
+					if strings.EqualFold(name, q.Name.String()) {
+						for _, body := range types[q.Type] {
+							reply.Answers = append(reply.Answers, mdnsRR(name, body))
+						}
+						reply.Additionals = append(reply.Additionals, extras[name]...)
+					}
+				}
+			}
+			if len(reply.Answers) == 0 {
+				continue
+			}
+			if msg, err := reply.Pack(); err == nil {
+				conn.WriteToUDP(msg, from)
+			}
+		}
+	}()
+}
+
+func TestMDNSProvider(t *testing.T) {
+	ptr := func(name string) dnsmessage.ResourceBody {
+		return &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName(name)}
+	}
+	srv := func(target string) dnsmessage.ResourceBody {
+		return &dnsmessage.SRVResource{Target: dnsmessage.MustNewName(target), Port: 631}
+	}
+	serveMDNS(t, map[string]map[dnsmessage.Type][]dnsmessage.ResourceBody{
+		mdnsServiceTypes:       {dnsmessage.TypePTR: {ptr("_ipp._tcp.local."), ptr("_smb._tcp.local.")}},
+		"_ipp._tcp.local.":     {dnsmessage.TypePTR: {ptr("Office Printer._ipp._tcp.local.")}},
+		"_smb._tcp.local.":     {dnsmessage.TypePTR: {ptr("NAS._smb._tcp.local."), ptr("Gone._smb._tcp.local.")}},
+		"NAS._smb._tcp.local.": {dnsmessage.TypeSRV: {srv("nas.local.")}},
+		"nas.local.": {dnsmessage.TypeA: {
+			&dnsmessage.AResource{A: [4]byte{192, 168, 1, 20}},
+			&dnsmessage.AAAAResource{AAAA: [16]byte{0xfe, 0x80, 15: 1}},
+			&dnsmessage.AAAAResource{AAAA: [16]byte{0xfd, 15: 0x20}},
+		}},
+	}, map[string][]dnsmessage.Resource{
+		// The printer sends its SRV and address along, the NAS has to be asked
+		"_ipp._tcp.local.": {
+			mdnsRR("Office Printer._ipp._tcp.local.", srv("printer.local.")),
+			mdnsRR("printer.local.", &dnsmessage.AResource{A: [4]byte{192, 168, 1, 30}}),
+		},
+	})
+	oldServices := mdnsServices
+	t.Cleanup(func() { mdnsServices = oldServices })
+
+	mdnsServices = ""
+	hosts, err := mdnsProvider{}.List(context.Background())
+	assert.NoError(t, err)
+	groups := []string{"mdns"}
+	assert.Equal(t, map[string]discovered{
+		"printer.local.": {entry: "printer=192.168.1.30", groups: groups},
+		"nas.local.":     {entry: "nas=192.168.1.20|fd00::20", groups: groups},
+	}, hosts, "link-local addresses and instances without a target are left out")
+
+	mdnsServices = "_ipp._tcp"
+	hosts, err = mdnsProvider{}.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]discovered{"printer.local.": {entry: "printer=192.168.1.30", groups: groups}}, hosts)
+}
+
+func TestMDNSNothingAdvertised(t *testing.T) {
+	serveMDNS(t, nil, nil)
+	hosts, err := mdnsProvider{}.List(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, hosts)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchNeighbors enables the neighbor table watcher, set with
+// -watch-neighbors: after every cycle the kernel's ARP and NDP entries for
+// the monitored hosts are compared with the previous ones, and a changed MAC
+// address is recorded as a "mac_changed" event since it usually means an IP
+// conflict or spoofing.
+var watchNeighbors bool
+
+// Layout of rtnetlink neighbor messages, see rtnetlink(7). Repeated here so
+// the parsing can be tested on every platform.
+const (
+	ndMsgLen      = 12 // struct ndmsg
+	ndaDst        = 1  // NDA_DST: IP address
+	ndaLLAddr     = 2  // NDA_LLADDR: link layer address
+	nudIncomplete = 0x01
+	nudFailed     = 0x20
+	nudNoARP      = 0x40
+)
+
+// neighborWatch remembers the MAC address last seen for each address of the
+// monitored hosts.
+type neighborWatch struct {
+	mu    sync.Mutex
+	addrs map[string][]string         // Addresses per host entry, resolved until found
+	macs  map[string]net.HardwareAddr // Last MAC per address
+}
+
+var neighbors = newNeighborWatch()
+
+// newNeighborWatch creates an empty neighbor watcher.
+func newNeighborWatch() *neighborWatch {
+	return &neighborWatch{addrs: make(map[string][]string), macs: make(map[string]net.HardwareAddr)}
+}
+
+// neighborAddrs returns the addresses of host that can show up in the
+// neighbor table. Logical hosts are expanded, scheme, ports and options are
+// dropped and names are resolved.
+func neighborAddrs(host string) []string {
+	if _, members, ok := splitAlias(host); ok {
+		var addrs []string
+		for _, m := range members {
+			addrs = append(addrs, neighborAddrs(m)...)
+		}
+		return addrs
+	}
+	_, addr := splitScheme(host)
+	addr, _ = splitOptions(addr)
+	if name, _, ok := splitPorts(addr); ok {
+		addr = name
+	} else if name, _, err := net.SplitHostPort(addr); err == nil {
+		addr = name
+	}
+	addr = strings.Trim(addr, "[]")
+	if ip := net.ParseIP(addr); ip != nil {
+		return []string{ip.String()}
+	}
+	ips, err := lookupIP(context.Background(), "ip", addr)
+	if err != nil {
+		return nil
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs
+}
+
+// check compares table with the MAC addresses seen before and records an
+// event for every address of a monitored host whose MAC changed. Addresses
+// missing from the table keep their last MAC, so an entry that ages out and
+// comes back unchanged is not reported.
+//
+// Parameters:
+//   - hosts: The monitored hosts
+//   - table: MAC address per IP address, as returned by readNeighbors
+//   - now: Time of the check
+//
+// Returns:
+//   - []Event: The recorded events
+func (w *neighborWatch) check(hosts []string, table map[string]net.HardwareAddr, now time.Time) []Event {
+	w.mu.Lock()
+	// Rebuilt on every check so removed hosts are forgotten
+	addrs := make(map[string][]string, len(hosts))
+	macs := make(map[string]net.HardwareAddr)
+	var changes []Event
+	for _, host := range hosts {
+		a, ok := w.addrs[host]
+		if !ok || len(a) == 0 {
+			a = neighborAddrs(host)
+		}
+		addrs[host] = a
+		for _, ip := range a {
+			prev, seen := w.macs[ip]
+			mac, ok := table[ip]
+			if !ok {
+				if seen {
+					macs[ip] = prev
+				}
+				continue
+			}
+			macs[ip] = mac
+			if seen && !bytes.Equal(prev, mac) {
+				changes = append(changes, Event{Time: now, Type: "mac_changed", Key: ip, Hosts: []string{host},
+					Message: fmt.Sprintf("%s (%s) moved from %s to %s: possible IP conflict or spoofing", host, ip, prev, mac)})
+			}
+		}
+	}
+	w.addrs = addrs
+	w.macs = macs
+	w.mu.Unlock()
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	for _, e := range changes {
+		events.add(e)
+	}
+	return changes
+}
+
+// checkNeighbors reads the neighbor table and checks it for MAC changes of
+// the monitored hosts.
+func checkNeighbors(hosts []string, now time.Time) {
+	table, err := readNeighbors()
+	if err != nil {
+		log.Printf("neighbor table: %v", err)
+		return
+	}
+	neighbors.check(hosts, table, now)
+}
+
+// parseNeighbor decodes the body of an RTM_NEWNEIGH message: an ndmsg
+// header followed by route attributes.
+//
+// Returns:
+//   - string: The IP address of the entry
+//   - net.HardwareAddr: The MAC address it resolves to
+//   - bool: False for incomplete, failed or non-Ethernet entries
+func parseNeighbor(data []byte) (string, net.HardwareAddr, bool) {
+	if len(data) < ndMsgLen {
+		return "", nil, false
+	}
+	if binary.NativeEndian.Uint16(data[8:10])&(nudIncomplete|nudFailed|nudNoARP) != 0 {
+		return "", nil, false
+	}
+	var ip net.IP
+	var mac net.HardwareAddr
+	for b := data[ndMsgLen:]; len(b) >= 4; {
+		l := int(binary.NativeEndian.Uint16(b[0:2]))
+		if l < 4 || l > len(b) {
+			break
+		}
+		switch binary.NativeEndian.Uint16(b[2:4]) {
+		case ndaDst:
+			ip = net.IP(append([]byte(nil), b[4:l]...))
+		case ndaLLAddr:
+			mac = net.HardwareAddr(append([]byte(nil), b[4:l]...))
+		}
+		// Attributes are padded to 4 bytes
+		l = (l + 3) &^ 3
+		if l >= len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	if len(ip) != net.IPv4len && len(ip) != net.IPv6len || len(mac) != 6 {
+		return "", nil, false
+	}
+	return ip.String(), mac, true
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// readNeighbors is a variable to allow mocking the neighbor table in tests.
+// On Linux it dumps the kernel's ARP and NDP entries over rtnetlink, which
+// needs no privileges.
+var readNeighbors = func() (map[string]net.HardwareAddr, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETNEIGH, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("dump neighbors: %w", err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("parse neighbors: %w", err)
+	}
+	table := make(map[string]net.HardwareAddr)
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWNEIGH {
+			continue
+		}
+		if ip, mac, ok := parseNeighbor(m.Data); ok {
+			table[ip] = mac
+		}
+	}
+	return table, nil
+}
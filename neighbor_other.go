@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// readNeighbors is only implemented on Linux, where the neighbor table can
+// be read over rtnetlink.
+var readNeighbors = func() (map[string]net.HardwareAddr, error) {
+	return nil, fmt.Errorf("watching the neighbor table is only supported on Linux")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNeighborAddrs(t *testing.T) {
+	defer func(f func(context.Context, string, string) ([]net.IP, error)) { lookupIP = f }(lookupIP)
+	lookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("192.168.1.30")}, nil
+	}
+
+	assert.Equal(t, []string{"192.168.1.20"}, neighborAddrs("192.168.1.20"))
+	assert.Equal(t, []string{"192.168.1.20"}, neighborAddrs("192.168.1.20:22,80"))
+	assert.Equal(t, []string{"192.168.1.20"}, neighborAddrs("ssh://192.168.1.20:2222"))
+	assert.Equal(t, []string{"fe80::1"}, neighborAddrs("[fe80::1]:443"))
+	assert.Equal(t, []string{"192.168.1.20"}, neighborAddrs("arp://192.168.1.20?iface=eth0"))
+	assert.Equal(t, []string{"192.168.1.30"}, neighborAddrs("nas"))
+	assert.Equal(t, []string{"192.168.1.20", "192.168.1.30"}, neighborAddrs("edge=192.168.1.20|nas"))
+}
+
+func TestNeighborWatch(t *testing.T) {
+	mac := func(s string) net.HardwareAddr {
+		m, _ := net.ParseMAC(s)
+		return m
+	}
+	w := newNeighborWatch()
+	now := time.Now()
+	hosts := []string{"192.168.1.20", "192.168.1.21"}
+
+	// The first sighting is not a change
+	assert.Empty(t, w.check(hosts, map[string]net.HardwareAddr{"192.168.1.20": mac("aa:bb:cc:00:00:01")}, now))
+	// Nor is an entry that ages out and comes back unchanged
+	assert.Empty(t, w.check(hosts, map[string]net.HardwareAddr{}, now))
+	assert.Empty(t, w.check(hosts, map[string]net.HardwareAddr{"192.168.1.20": mac("aa:bb:cc:00:00:01")}, now))
+
+	changes := w.check(hosts, map[string]net.HardwareAddr{
+		"192.168.1.20": mac("de:ad:be:ef:00:02"),
+		"192.168.1.99": mac("de:ad:be:ef:00:03"), // Not monitored
+	}, now)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, "mac_changed", changes[0].Type)
+		assert.Equal(t, "192.168.1.20", changes[0].Key)
+		assert.Equal(t, []string{"192.168.1.20"}, changes[0].Hosts)
+		assert.Contains(t, changes[0].Message, "aa:bb:cc:00:00:01 to de:ad:be:ef:00:02")
+	}
+	assert.Equal(t, "mac_changed", events.recent()[0].Type)
+
+	// Removed hosts are forgotten
+	w.check(nil, nil, now)
+	assert.Empty(t, w.check(hosts, map[string]net.HardwareAddr{"192.168.1.20": mac("aa:bb:cc:00:00:01")}, now))
+}
+
+func TestParseNeighbor(t *testing.T) {
+	attr := func(typ uint16, v []byte) []byte {
+		b := make([]byte, 4, 8+len(v))
+		binary.NativeEndian.PutUint16(b[0:], uint16(4+len(v)))
+		binary.NativeEndian.PutUint16(b[2:], typ)
+		b = append(b, v...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	msg := func(state uint16, attrs ...[]byte) []byte {
+		b := make([]byte, ndMsgLen)
+		binary.NativeEndian.PutUint16(b[8:], state)
+		for _, a := range attrs {
+			b = append(b, a...)
+		}
+		return b
+	}
+	mac := []byte{0xaa, 0xbb, 0xcc, 0, 0, 1}
+
+	ip, got, ok := parseNeighbor(msg(0x02, attr(ndaDst, []byte{192, 168, 1, 20}), attr(ndaLLAddr, mac)))
+	assert.True(t, ok)
+	assert.Equal(t, "192.168.1.20", ip)
+	assert.Equal(t, "aa:bb:cc:00:00:01", got.String())
+
+	ip, _, ok = parseNeighbor(msg(0x02, attr(ndaDst, net.ParseIP("fe80::1")), attr(ndaLLAddr, mac)))
+	assert.True(t, ok)
+	assert.Equal(t, "fe80::1", ip)
+
+	_, _, ok = parseNeighbor(msg(nudFailed, attr(ndaDst, []byte{192, 168, 1, 20}), attr(ndaLLAddr, mac)))
+	assert.False(t, ok)
+	_, _, ok = parseNeighbor(msg(0x02, attr(ndaDst, []byte{192, 168, 1, 20})))
+	assert.False(t, ok)
+	_, _, ok = parseNeighbor(msg(0x02)[:8])
+	assert.False(t, ok)
+}
+
+func TestReadNeighbors(t *testing.T) {
+	_, err := readNeighbors()
+	if runtime.GOOS == "linux" {
+		assert.NoError(t, err)
+	} else {
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// nmapFile is the -nmap-xml value: the XML output of an nmap discovery
+// scan (nmap -oX) whose live hosts are monitored along with those of -file
+// and -hosts. It is re-read like -file.
+var nmapFile string
+
+// nmapRun is the part of nmap's XML output mosaic reads.
+type nmapRun struct {
+	Hosts []nmapHost `xml:"host"`
+}
+
+// nmapHost is a scanned host with how nmap found it up and its ports.
+type nmapHost struct {
+	Status struct {
+		State  string `xml:"state,attr"`  // "up" or "down"
+		Reason string `xml:"reason,attr"` // e.g. "echo-reply", "syn-ack" or "user-set" with -Pn
+	} `xml:"status"`
+	Addresses []struct {
+		Addr string `xml:"addr,attr"`
+		Type string `xml:"addrtype,attr"` // "ipv4", "ipv6" or "mac"
+	} `xml:"address"`
+	Hostnames []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"hostnames>hostname"`
+	Ports []struct {
+		Protocol string `xml:"protocol,attr"`
+		ID       string `xml:"portid,attr"`
+		State    struct {
+			State string `xml:"state,attr"`
+		} `xml:"state"`
+	} `xml:"ports>port"`
+}
+
+// nmapICMPReasons are the reasons for a host being up that show it
+// answers ICMP, so it can be pinged.
+var nmapICMPReasons = map[string]bool{"echo-reply": true, "timestamp-reply": true, "localhost-response": true}
+
+// readNmapXML reads the hosts nmap found up in the XML scan report at path.
+// Hosts that answered ICMP during the scan are pinged. Those found up
+// otherwise, e.g. by a TCP SYN or with -Pn, are probed on their open TCP
+// ports instead, since ICMP was filtered; without open ports they are
+// pinged all the same. The first hostname of a host becomes its display
+// name.
+//
+// Parameters:
+//   - path: The XML report
+//
+// Returns:
+//   - []string: The host entries in report order
+//   - map[string]HostLabel: The names of hosts nmap resolved
+//   - error: If the file cannot be read or parsed
+func readNmapXML(path string) ([]string, map[string]HostLabel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var run nmapRun
+	if err := xml.Unmarshal(data, &run); err != nil {
+		return nil, nil, fmt.Errorf("nmap report %s: %v", path, err)
+	}
+	var hosts []string
+	labels := make(map[string]HostLabel)
+	for _, h := range run.Hosts {
+		if h.Status.State != "up" {
+			continue
+		}
+		entry := h.address()
+		if entry == "" {
+			continue
+		}
+		if !nmapICMPReasons[h.Status.Reason] {
+			if ports := h.openTCPPorts(); len(ports) > 0 {
+				entry = net.JoinHostPort(entry, ports[0])
+				if len(ports) > 1 {
+					entry += "," + strings.Join(ports[1:], ",")
+				}
+			}
+		}
+		hosts = append(hosts, entry)
+		if len(h.Hostnames) > 0 && h.Hostnames[0].Name != "" {
+			labels[entry] = HostLabel{Name: h.Hostnames[0].Name}
+		}
+	}
+	return hosts, labels, nil
+}
+
+// address returns the IPv4 address of h, or its IPv6 address without one.
+func (h nmapHost) address() string {
+	addr := ""
+	for _, a := range h.Addresses {
+		switch {
+		case a.Type == "ipv4":
+			return a.Addr
+		case a.Type == "ipv6" && addr == "":
+			addr = a.Addr
+		}
+	}
+	return addr
+}
+
+// openTCPPorts returns the TCP ports nmap found open on h.
+func (h nmapHost) openTCPPorts() []string {
+	var ports []string
+	for _, p := range h.Ports {
+		if p.Protocol == "tcp" && p.State.State == "open" && isPort(p.ID) {
+			ports = append(ports, p.ID)
+		}
+	}
+	return ports
+}
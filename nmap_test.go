@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const nmapReport = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE nmaprun>
+<nmaprun scanner="nmap" args="nmap -oX scan.xml 10.0.5.0/24">
+<host><status state="up" reason="echo-reply" reason_ttl="64"/>
+<address addr="10.0.5.1" addrtype="ipv4"/><address addr="00:11:22:33:44:55" addrtype="mac"/>
+<hostnames><hostname name="core-sw1.lab" type="PTR"/></hostnames>
+<ports><port protocol="tcp" portid="22"><state state="open" reason="syn-ack"/></port></ports>
+</host>
+<host><status state="up" reason="syn-ack" reason_ttl="0"/>
+<address addr="10.0.5.7" addrtype="ipv4"/>
+<hostnames/>
+<ports>
+<port protocol="tcp" portid="443"><state state="open" reason="syn-ack"/></port>
+<port protocol="tcp" portid="3389"><state state="filtered" reason="no-response"/></port>
+<port protocol="udp" portid="161"><state state="open" reason="udp-response"/></port>
+<port protocol="tcp" portid="22"><state state="open" reason="syn-ack"/></port>
+</ports>
+</host>
+<host><status state="up" reason="user-set" reason_ttl="0"/>
+<address addr="2001:db8::9" addrtype="ipv6"/>
+<ports><port protocol="tcp" portid="80"><state state="open" reason="syn-ack"/></port></ports>
+</host>
+<host><status state="up" reason="arp-response" reason_ttl="0"/>
+<address addr="10.0.5.8" addrtype="ipv4"/>
+</host>
+<host><status state="down" reason="no-response" reason_ttl="0"/>
+<address addr="10.0.5.9" addrtype="ipv4"/>
+</host>
+</nmaprun>
+`
+
+func TestReadNmapXML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.xml")
+	if err := os.WriteFile(path, []byte(nmapReport), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hosts, l, err := readNmapXML(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.5.1", "10.0.5.7:443,22", "[2001:db8::9]:80", "10.0.5.8"}, hosts,
+		"hosts that answered ICMP are pinged, the others probed on their open TCP ports")
+	assert.Equal(t, map[string]HostLabel{"10.0.5.1": {Name: "core-sw1.lab"}}, l)
+	for _, h := range hosts {
+		assert.NoError(t, validateHost(h), h)
+	}
+
+	if err := os.WriteFile(path, []byte("<nmaprun><host>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = readNmapXML(path)
+	assert.ErrorContains(t, err, "nmap report")
+}
+
+func TestReadHostsNmapXML(t *testing.T) {
+	defer func(f string) { nmapFile = f }(nmapFile)
+	nmapFile = filepath.Join(t.TempDir(), "scan.xml")
+	if err := os.WriteFile(nmapFile, []byte(nmapReport), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withHostFilters(t, `^10\.0\.5\.[17]\b`, "")
+	hosts, fileLabels, err := readHosts("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.5.1", "10.0.5.7:443,22"}, hosts)
+	assert.Equal(t, map[string]HostLabel{"10.0.5.1": {Name: "core-sw1.lab"}}, fileLabels)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyNotifier fails its first failures deliveries and records the rest.
+type flakyNotifier struct {
+	mu        sync.Mutex
+	failures  int
+	attempts  int
+	delivered []Event
+	done      chan struct{}
+}
+
+func (n *flakyNotifier) Name() string { return "flaky" }
+
+func (n *flakyNotifier) Notify(ctx context.Context, e Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.attempts++
+	if n.attempts <= n.failures {
+		return errors.New("connection refused")
+	}
+	n.delivered = append(n.delivered, e)
+	n.done <- struct{}{}
+	return nil
+}
+
+// testDispatcher returns a dispatcher that records its backoff waits instead
+// of sleeping.
+func testDispatcher(waits *[]time.Duration) *notifyDispatcher {
+	d := newNotifyDispatcher(time.Second, 4*time.Second)
+	d.sleep = func(w time.Duration) { *waits = append(*waits, w) }
+	return d
+}
+
+func TestNotifyRetriesWithBackoff(t *testing.T) {
+	var waits []time.Duration
+	d := testDispatcher(&waits)
+	n := &flakyNotifier{failures: 3, done: make(chan struct{}, 1)}
+
+	d.deliver(n, Event{Type: "correlated_incident", Message: "sgp down"})
+
+	assert.Equal(t, 4, n.attempts)
+	assert.Len(t, n.delivered, 1)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, waits)
+	assert.Empty(t, d.deadLettered())
+}
+
+func TestNotifyDeadLetter(t *testing.T) {
+	var waits []time.Duration
+	d := testDispatcher(&waits)
+	n := &flakyNotifier{failures: notifyAttempts}
+
+	d.deliver(n, Event{Type: "self_alert", Message: "cycle_utilization"})
+
+	assert.Equal(t, notifyAttempts, n.attempts)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}, waits)
+	letters := d.deadLettered()
+	if len(letters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(letters))
+	}
+	assert.Equal(t, "flaky", letters[0].Notifier)
+	assert.Equal(t, notifyAttempts, letters[0].Attempts)
+	assert.Equal(t, "connection refused", letters[0].Error)
+	assert.Equal(t, "cycle_utilization", letters[0].Event.Message)
+}
+
+func TestNotifyDispatchOnlyAlerts(t *testing.T) {
+	var waits []time.Duration
+	d := testDispatcher(&waits)
+	n := &flakyNotifier{done: make(chan struct{}, 2)}
+	d.add(n)
+
+	d.dispatch(Event{Type: "host_added", Message: "ignored"})
+	d.dispatch(Event{Type: "correlated_incident", Message: "first"})
+	d.dispatch(Event{Type: "correlated_incident_resolved", Message: "second"})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-n.done:
+		case <-time.After(time.Second):
+			t.Fatal("notification not delivered")
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if assert.Len(t, n.delivered, 2) {
+		assert.Equal(t, "first", n.delivered[0].Message)
+		assert.Equal(t, "second", n.delivered[1].Message)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var bodies []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	e := Event{Type: "correlated_incident", Message: "sgp down"}
+
+	slack, err := parseNotifier("slack=" + server.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, slack.Notify(context.Background(), e))
+	assert.JSONEq(t, `{"text":"sgp down"}`, bodies[0])
+
+	webhook, err := parseNotifier("webhook=" + server.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, webhook.Notify(context.Background(), e))
+	var got Event
+	assert.NoError(t, json.Unmarshal([]byte(bodies[1]), &got))
+	assert.Equal(t, e.Message, got.Message)
+
+	status = http.StatusServiceUnavailable
+	assert.Error(t, webhook.Notify(context.Background(), e))
+}
+
+func TestNotifyRoutesByPriority(t *testing.T) {
+	var waits []time.Duration
+	d := testDispatcher(&waits)
+	n := &flakyNotifier{done: make(chan struct{}, 2)}
+	d.add(&routedNotifier{Notifier: n, min: priorityHigh})
+
+	d.dispatch(Event{Type: "host_flapping", Priority: priorityNormal, Message: "test VM"})
+	d.dispatch(Event{Type: "host_flapping", Priority: priorityCritical, Message: "core switch"})
+	d.dispatch(Event{Type: "self_alert", Message: "mosaic"})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-n.done:
+		case <-time.After(time.Second):
+			t.Fatal("notification not delivered")
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if assert.Len(t, n.delivered, 2) {
+		assert.Equal(t, "core switch", n.delivered[0].Message)
+		assert.Equal(t, "mosaic", n.delivered[1].Message, "alerts not about hosts go everywhere")
+	}
+}
+
+func TestParseNotifier(t *testing.T) {
+	routed, err := parseNotifier("webhook@critical=https://pager.example.com/mosaic")
+	assert.NoError(t, err)
+	if r, ok := routed.(*routedNotifier); assert.True(t, ok) {
+		assert.Equal(t, priorityCritical, r.min)
+		assert.Equal(t, "webhook", r.Name())
+	}
+
+	n, err := parseNotifier("smtp=smtp://user:pw@mail.example.com?from=mosaic@example.com&to=noc@example.com,ops@example.com")
+	assert.NoError(t, err)
+	if s, ok := n.(*smtpNotifier); assert.True(t, ok) {
+		assert.Equal(t, "mail.example.com:25", s.addr)
+		assert.Equal(t, []string{"noc@example.com", "ops@example.com"}, s.to)
+		assert.NotNil(t, s.auth)
+	}
+
+	for _, bad := range []string{
+		"slack",
+		"pager=https://example.com",
+		"webhook=ftp://example.com",
+		"smtp=smtp://mail.example.com?from=mosaic@example.com",
+		"webhook@urgent=https://example.com",
+		"webhook@=https://example.com",
+	} {
+		_, err := parseNotifier(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestDeadLettersHandler(t *testing.T) {
+	old := notifications
+	defer func() { notifications = old }()
+	notifications = newNotifyDispatcher(time.Second, time.Minute)
+	notifications.deadLetterLocked(DeadLetter{Notifier: "slack", Event: Event{Message: "older"}})
+	notifications.deadLetterLocked(DeadLetter{Notifier: "smtp", Event: Event{Message: "newer"}})
+
+	w := httptest.NewRecorder()
+	deadLettersHandler(w, httptest.NewRequest(http.MethodGet, "/api/notifications/dead-letters", nil))
+
+	var letters []DeadLetter
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&letters))
+	if assert.Len(t, letters, 2) {
+		assert.Equal(t, "newer", letters[0].Event.Message)
+		assert.Equal(t, "older", letters[1].Event.Message)
+	}
+}
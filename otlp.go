@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// otlpEndpoint is the base URL of the OpenTelemetry collector results
+	// are pushed to over OTLP/HTTP, set with -otlp-endpoint, e.g.
+	// http://otel-collector:4318. Empty pushes none.
+	otlpEndpoint string
+	// otlpHeaders are comma-separated key=value headers sent with every
+	// push, set with -otlp-headers, e.g. for an API key. ${VAR} references
+	// in the values are expanded from the environment.
+	otlpHeaders string
+	// otlpSpans also pushes a span per probe, set with -otlp-spans.
+	otlpSpans bool
+)
+
+// otlpScope names mosaic as the instrumentation scope of what it pushes.
+const otlpScope = "mosaic"
+
+// OTLP span kind and status codes, as numbered by its protobuf definitions.
+const (
+	otlpSpanKindClient = 3
+	otlpStatusOK       = 1
+	otlpStatusError    = 2
+)
+
+// The OTLP/HTTP JSON encoding of what mosaic pushes. 64-bit integers are
+// strings, as the protobuf JSON mapping has them.
+type (
+	otlpValue struct {
+		StringValue *string         `json:"stringValue,omitempty"`
+		ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+	}
+	otlpArrayValue struct {
+		Values []otlpValue `json:"values"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpInstrumentationScope struct {
+		Name string `json:"name"`
+	}
+	otlpDataPoint struct {
+		Attributes   []otlpKeyValue `json:"attributes"`
+		TimeUnixNano string         `json:"timeUnixNano"`
+		AsDouble     *float64       `json:"asDouble,omitempty"`
+		AsInt        string         `json:"asInt,omitempty"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpMetric struct {
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+		Unit        string    `json:"unit"`
+		Gauge       otlpGauge `json:"gauge"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpInstrumentationScope `json:"scope"`
+		Metrics []otlpMetric             `json:"metrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpMetricsRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpInstrumentationScope `json:"scope"`
+		Spans []otlpSpan               `json:"spans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpTracesRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+)
+
+// otlpSink pushes the results of every cycle to an OpenTelemetry collector
+// as gauges, and optionally a span per probe, over OTLP/HTTP with JSON
+// encoding, which every collector accepts and needs no protobuf code.
+type otlpSink struct {
+	endpoint string            // Base URL; /v1/metrics and /v1/traces are added
+	headers  map[string]string // Sent with every push
+	spans    bool
+	resource otlpResource
+}
+
+// newOTLPSink creates a sink pushing to the collector at endpoint.
+//
+// Parameters:
+//   - endpoint: Base URL of the collector's OTLP/HTTP receiver
+//   - headers: Comma-separated key=value headers, may be empty; ${VAR}
+//     references in the values are expanded from the environment
+//   - spans: Whether to push a span per probe as well
+//
+// Returns:
+//   - *otlpSink: The sink
+//   - error: If endpoint is not an http(s) URL or headers are malformed
+func newOTLPSink(endpoint, headers string, spans bool) (*otlpSink, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: expected an http(s) URL such as http://otel-collector:4318", endpoint)
+	}
+	s := &otlpSink{endpoint: strings.TrimSuffix(endpoint, "/"), headers: make(map[string]string), spans: spans}
+	for _, h := range strings.Split(headers, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(h, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q: expected key=value", h)
+		}
+		s.headers[strings.TrimSpace(k)] = os.ExpandEnv(strings.TrimSpace(v))
+	}
+	instance, _ := os.Hostname()
+	s.resource.Attributes = []otlpKeyValue{otlpString("service.name", "mosaic"), otlpString("service.instance.id", instance)}
+	return s, nil
+}
+
+// Name implements resultSink.
+func (s *otlpSink) Name() string { return "otlp" }
+
+// Push implements resultSink: the gauges mosaic.host.up,
+// mosaic.host.latency and mosaic.host.packet_loss with a data point per
+// host, and with -otlp-spans a span per probe.
+func (s *otlpSink) Push(ctx context.Context, now time.Time, statuses []HostStatus) error {
+	body, err := json.Marshal(s.metrics(now, statuses))
+	if err != nil {
+		return err
+	}
+	if err := postSink(ctx, s.endpoint+"/v1/metrics", "application/json", s.headers, body); err != nil {
+		return err
+	}
+	if !s.spans {
+		return nil
+	}
+	if body, err = json.Marshal(s.traces(now, statuses)); err != nil {
+		return err
+	}
+	return postSink(ctx, s.endpoint+"/v1/traces", "application/json", s.headers, body)
+}
+
+// metrics returns the gauges of statuses as an OTLP export request.
+// Latency has no data point for hosts that are down, and a gauge without
+// data points is left out.
+func (s *otlpSink) metrics(now time.Time, statuses []HostStatus) otlpMetricsRequest {
+	at := strconv.FormatInt(now.UnixNano(), 10)
+	up := otlpMetric{Name: "mosaic.host.up", Description: "Whether the host answered its last probe.", Unit: "1"}
+	latency := otlpMetric{Name: "mosaic.host.latency", Description: "Round-trip time of the host's last probe.", Unit: "ms"}
+	loss := otlpMetric{Name: "mosaic.host.packet_loss", Description: "Packet loss of the host's last probe.", Unit: "%"}
+	for _, st := range statuses {
+		attrs := otlpHostAttributes(st)
+		alive := "0"
+		if st.Alive {
+			alive = "1"
+			ms := float64(st.LatencyMs)
+			latency.Gauge.DataPoints = append(latency.Gauge.DataPoints, otlpDataPoint{Attributes: attrs, TimeUnixNano: at, AsDouble: &ms})
+		}
+		up.Gauge.DataPoints = append(up.Gauge.DataPoints, otlpDataPoint{Attributes: attrs, TimeUnixNano: at, AsInt: alive})
+		pct := st.PacketLoss
+		loss.Gauge.DataPoints = append(loss.Gauge.DataPoints, otlpDataPoint{Attributes: attrs, TimeUnixNano: at, AsDouble: &pct})
+	}
+	scope := otlpScopeMetrics{Scope: otlpInstrumentationScope{Name: otlpScope}}
+	for _, m := range []otlpMetric{up, latency, loss} {
+		if len(m.Gauge.DataPoints) > 0 {
+			scope.Metrics = append(scope.Metrics, m)
+		}
+	}
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{Resource: s.resource, ScopeMetrics: []otlpScopeMetrics{scope}}}}
+}
+
+// traces returns a span per status as an OTLP export request, each its own
+// trace. Probes are not timed individually, so a span ends at now and
+// lasts the round-trip time, or the probe timeout if the host is down.
+func (s *otlpSink) traces(now time.Time, statuses []HostStatus) otlpTracesRequest {
+	spans := make([]otlpSpan, 0, len(statuses))
+	for _, st := range statuses {
+		took := time.Duration(st.LatencyMs) * time.Millisecond
+		status := otlpStatus{Code: otlpStatusOK}
+		if !st.Alive {
+			took = settingsFor(st.Host).Timeout
+			status = otlpStatus{Code: otlpStatusError, Message: "no answer"}
+			if st.Reason != "" {
+				status.Message = st.Reason
+			}
+		}
+		attrs := append(otlpHostAttributes(st), otlpString("mosaic.state", resultState(st)))
+		if st.Detail != "" {
+			attrs = append(attrs, otlpString("mosaic.detail", st.Detail))
+		}
+		spans = append(spans, otlpSpan{
+			TraceID:           otlpID(16),
+			SpanID:            otlpID(8),
+			Name:              "probe " + st.Host,
+			Kind:              otlpSpanKindClient,
+			StartTimeUnixNano: strconv.FormatInt(now.Add(-took).UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(now.UnixNano(), 10),
+			Attributes:        attrs,
+			Status:            status,
+		})
+	}
+	scope := otlpScopeSpans{Scope: otlpInstrumentationScope{Name: otlpScope}, Spans: spans}
+	return otlpTracesRequest{ResourceSpans: []otlpResourceSpans{{Resource: s.resource, ScopeSpans: []otlpScopeSpans{scope}}}}
+}
+
+// otlpHostAttributes returns the attributes identifying the host of st:
+// mosaic.host, and mosaic.name and mosaic.groups if it has them.
+func otlpHostAttributes(st HostStatus) []otlpKeyValue {
+	attrs := []otlpKeyValue{otlpString("mosaic.host", st.Host)}
+	if st.Name != "" {
+		attrs = append(attrs, otlpString("mosaic.name", st.Name))
+	}
+	if len(st.Groups) > 0 {
+		groups := &otlpArrayValue{}
+		for _, g := range st.Groups {
+			groups.Values = append(groups.Values, otlpValue{StringValue: &g})
+		}
+		attrs = append(attrs, otlpKeyValue{Key: "mosaic.groups", Value: otlpValue{ArrayValue: groups}})
+	}
+	return attrs
+}
+
+// otlpString returns a string attribute.
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+// otlpID returns a random trace or span id of n bytes, hex-encoded as the
+// OTLP JSON encoding has them.
+func otlpID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOTLPSink(t *testing.T) {
+	bodies := make(map[string]map[string]any)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var body map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies[r.URL.Path] = body
+	}))
+	defer srv.Close()
+
+	t.Setenv("MOSAIC_TEST_OTLP_KEY", "secret")
+	s, err := newOTLPSink(srv.URL+"/", "Authorization=Bearer ${MOSAIC_TEST_OTLP_KEY}", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1714557600, 0)
+	err = s.Push(context.Background(), now, []HostStatus{
+		{Host: "10.0.0.1", Name: "core-sw", Alive: true, LatencyMs: 3, Groups: []string{"core"}},
+		{Host: "10.0.0.2", PacketLoss: 100, Reason: "host_unreachable"},
+	})
+	assert.NoError(t, err)
+
+	metrics, _ := json.Marshal(bodies["/v1/metrics"])
+	assert.Contains(t, string(metrics), `{"key":"service.name","value":{"stringValue":"mosaic"}}`)
+	var req otlpMetricsRequest
+	assert.NoError(t, json.Unmarshal(metrics, &req))
+	if assert.Len(t, req.ResourceMetrics, 1) && assert.Len(t, req.ResourceMetrics[0].ScopeMetrics, 1) {
+		m := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+		if assert.Len(t, m, 3) {
+			assert.Equal(t, "mosaic.host.up", m[0].Name)
+			assert.Equal(t, "1", m[0].Gauge.DataPoints[0].AsInt)
+			assert.Equal(t, "0", m[0].Gauge.DataPoints[1].AsInt)
+			assert.Equal(t, "1714557600000000000", m[0].Gauge.DataPoints[0].TimeUnixNano)
+			assert.Equal(t, "mosaic.host.latency", m[1].Name)
+			assert.Len(t, m[1].Gauge.DataPoints, 1, "no latency of a host that is down")
+			assert.Equal(t, 3.0, *m[1].Gauge.DataPoints[0].AsDouble)
+			assert.Equal(t, 100.0, *m[2].Gauge.DataPoints[1].AsDouble)
+		}
+	}
+	assert.Contains(t, string(metrics), `{"key":"mosaic.groups","value":{"arrayValue":{"values":[{"stringValue":"core"}]}}}`)
+
+	traces, _ := json.Marshal(bodies["/v1/traces"])
+	var treq otlpTracesRequest
+	assert.NoError(t, json.Unmarshal(traces, &treq))
+	if assert.Len(t, treq.ResourceSpans, 1) {
+		spans := treq.ResourceSpans[0].ScopeSpans[0].Spans
+		if assert.Len(t, spans, 2) {
+			assert.Len(t, spans[0].TraceID, 32)
+			assert.Len(t, spans[0].SpanID, 16)
+			assert.Equal(t, "1714557599997000000", spans[0].StartTimeUnixNano, "lasts the round-trip time")
+			assert.Equal(t, otlpStatusOK, spans[0].Status.Code)
+			assert.Equal(t, otlpStatusError, spans[1].Status.Code)
+			assert.Equal(t, "host_unreachable", spans[1].Status.Message)
+		}
+	}
+
+	for _, bad := range []string{"otel-collector:4318", "ftp://collector", "http://"} {
+		_, err := newOTLPSink(bad, "", false)
+		assert.Error(t, err, bad)
+	}
+	_, err = newOTLPSink("http://collector:4318", "api-key", false)
+	assert.Error(t, err)
+}
+
+func TestOTLPSinkRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "bad payload", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	s, _ := newOTLPSink(srv.URL, "", false)
+	err := s.Push(context.Background(), time.Now(), []HostStatus{{Host: "a", Alive: true}})
+	assert.ErrorIs(t, err, errSinkRejected)
+	assert.True(t, strings.HasSuffix(err.Error(), "400 Bad Request"))
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// parquetRowGroup is how many results a Parquet file holds per row group,
+// the unit a reader loads at once; the writer keeps one in memory.
+const parquetRowGroup = 100000
+
+// runExport implements "mosaic export": it reads the results of a
+// -history-db directly, without the running mosaic, and writes them to a
+// Parquet file per UTC day, <out>/<YYYY-MM-DD>.parquet, for pandas or
+// DuckDB. The columns are those of /api/export.csv. Files of days that
+// already have one are replaced.
+//
+// Parameters:
+//   - args: Command-line arguments following "export"
+//   - w: Where to report the files written
+//
+// Returns:
+//   - error: Invalid flags, an unreadable history or an unwritable file
+func runExport(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "parquet", "File format, parquet")
+	fs.StringVar(&historyDB, "history-db", "", "Database to read the history from")
+	fs.StringVar(&historyBackend, "history-backend", historyBackend, "Storage of -history-db: sqlite, bolt, postgres or rrd")
+	fs.StringVar(&historyInstance, "history-instance", "", "Name of the mosaic whose history to read from a shared -history-db (default the hostname)")
+	since := fs.String("since", "", "First day to export, an RFC 3339 time or a duration ago (default as far back as results are kept)")
+	until := fs.String("until", "", "End of the export, an RFC 3339 time or a duration ago (default now)")
+	out := fs.String("out", ".", "Directory to write the files to")
+	fs.DurationVar(&historyRetention, "history-retention", historyRetention, "How long results are kept as they are, which with -history-downsample bounds the default -since")
+	fs.Var(&historyDownsample, "history-downsample", "Downsampling tiers, which bound the default -since")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || historyDB == "" {
+		return errors.New("usage: mosaic export -history-db path [-format parquet] [-since time] [-until time] [-out dir]")
+	}
+	if *format != "parquet" {
+		return fmt.Errorf("unknown format %q, want parquet", *format)
+	}
+	if embeddedBuild {
+		return errors.New("not supported in the embedded build")
+	}
+	now := time.Now()
+	from, to := now.Add(-historyRetention), now
+	if len(historyDownsample) > 0 {
+		from = now.Add(-historyDownsample[len(historyDownsample)-1].Keep)
+	}
+	var err error
+	if *since != "" {
+		if from, err = parseHistoryTime(*since, now); err != nil {
+			return fmt.Errorf("invalid -since: %v", err)
+		}
+	}
+	if *until != "" {
+		if to, err = parseHistoryTime(*until, now); err != nil {
+			return fmt.Errorf("invalid -until: %v", err)
+		}
+	}
+	open, ok := historyBackends[historyBackend]
+	if !ok {
+		return fmt.Errorf("unknown history backend %q", historyBackend)
+	}
+	if historyInstance == "" {
+		historyInstance, _ = os.Hostname()
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	store, err := open(historyDB)
+	if err != nil {
+		return err
+	}
+	defer store.close()
+	// A bucket per day tells which days have results
+	const day = 24 * time.Hour
+	days, err := store.buckets("", from.UTC().Truncate(day), to, day)
+	if err != nil {
+		return fmt.Errorf("%s: %v", historyDB, err)
+	}
+	seen := make(map[time.Time]bool)
+	for _, b := range days {
+		start := b.Start.UTC()
+		if seen[start] {
+			continue
+		}
+		seen[start] = true
+		path := filepath.Join(*out, start.Format("2006-01-02")+".parquet")
+		first, end := start, start.Add(day)
+		if first.Before(from) {
+			first = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		n, err := exportParquetDay(store, path, first, end)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if n == 0 {
+			// The day began before -since, and so did its results
+			continue
+		}
+		fmt.Fprintf(w, "Wrote %d results to %s\n", n, path)
+	}
+	if len(seen) == 0 {
+		fmt.Fprintf(w, "No results from %s to %s\n", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// exportParquetDay writes the results of store in [since, until) to a
+// Parquet file at path. The file is written next to it first and renamed
+// into place, so a reader never sees half of it, and not at all without
+// results.
+//
+// Returns:
+//   - int: The results written
+//   - error: If the history cannot be read or the file written
+func exportParquetDay(store historyStore, path string, since, until time.Time) (int, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	pw := newParquetWriter(f)
+	err = store.each("", since, until, func(r HistoryResult) error { return pw.add(r) })
+	if err == nil {
+		err = pw.close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || pw.total == 0 {
+		return 0, err
+	}
+	return pw.total, os.Rename(tmp, path)
+}
+
+// Parquet physical types, converted types and encodings, as numbered by
+// the format's Thrift definitions.
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// parquetColumn is a column of the files of "mosaic export", holding the
+// PLAIN-encoded values of the row group being written.
+type parquetColumn struct {
+	name      string
+	kind      int32 // Physical type
+	converted int32 // Converted type, or -1 for none
+	values    []byte
+}
+
+// parquetChunk is where a column chunk of a written row group is.
+type parquetChunk struct {
+	offset, size int64
+}
+
+// parquetWriter writes HistoryResults as a Parquet file: uncompressed, a
+// PLAIN-encoded data page per column and row group, all columns required.
+// This is the least every Parquet reader understands, and needs no library.
+type parquetWriter struct {
+	w      *bufio.Writer
+	offset int64 // Bytes written
+	cols   []parquetColumn
+	rows   int              // Rows of the pending row group
+	groups [][]parquetChunk // Chunks of the written row groups, by column
+	sizes  []int            // Rows of the written row groups
+	total  int              // Rows added
+	err    error
+}
+
+// newParquetWriter starts a Parquet file on w with the columns of
+// exportHeader.
+func newParquetWriter(w io.Writer) *parquetWriter {
+	pw := &parquetWriter{w: bufio.NewWriter(w), cols: []parquetColumn{
+		{name: exportHeader[0], kind: parquetInt64, converted: parquetTimestampMillis},
+		{name: exportHeader[1], kind: parquetByteArray, converted: parquetUTF8},
+		{name: exportHeader[2], kind: parquetInt32, converted: -1},
+		{name: exportHeader[3], kind: parquetDouble, converted: -1},
+		{name: exportHeader[4], kind: parquetByteArray, converted: parquetUTF8},
+		{name: exportHeader[5], kind: parquetInt32, converted: -1},
+		{name: exportHeader[6], kind: parquetInt32, converted: -1},
+	}}
+	pw.write([]byte("PAR1"))
+	return pw
+}
+
+// write writes b to the file, keeping the first error.
+func (pw *parquetWriter) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+// add appends r as a row, writing the row group once it is full. Results
+// stored as they are have one sample, as in /api/export.csv.
+func (pw *parquetWriter) add(r HistoryResult) error {
+	samples, up := r.weight()
+	c := pw.cols
+	c[0].values = binary.LittleEndian.AppendUint64(c[0].values, uint64(r.Time.UnixMilli()))
+	c[1].values = appendParquetString(c[1].values, r.Host)
+	c[2].values = binary.LittleEndian.AppendUint32(c[2].values, uint32(r.LatencyMs))
+	c[3].values = binary.LittleEndian.AppendUint64(c[3].values, math.Float64bits(r.PacketLoss))
+	c[4].values = appendParquetString(c[4].values, r.State)
+	c[5].values = binary.LittleEndian.AppendUint32(c[5].values, uint32(samples))
+	c[6].values = binary.LittleEndian.AppendUint32(c[6].values, uint32(up))
+	pw.rows++
+	pw.total++
+	if pw.rows == parquetRowGroup {
+		pw.flush()
+	}
+	return pw.err
+}
+
+// appendParquetString appends s to b PLAIN-encoded: its length, then its
+// bytes.
+func appendParquetString(b []byte, s string) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// flush writes the pending rows as a row group, a data page per column.
+func (pw *parquetWriter) flush() {
+	if pw.rows == 0 {
+		return
+	}
+	chunks := make([]parquetChunk, len(pw.cols))
+	for i := range pw.cols {
+		c := &pw.cols[i]
+		var t thriftWriter
+		t.begin(0)
+		t.i32(1, 0) // DATA_PAGE
+		t.i32(2, int32(len(c.values)))
+		t.i32(3, int32(len(c.values)))
+		t.begin(5)
+		t.i32(1, int32(pw.rows))
+		t.i32(2, parquetPlain)
+		t.i32(3, parquetRLE)
+		t.i32(4, parquetRLE)
+		t.end()
+		t.end()
+		chunks[i].offset = pw.offset
+		pw.write(t.b)
+		pw.write(c.values)
+		chunks[i].size = pw.offset - chunks[i].offset
+		c.values = c.values[:0]
+	}
+	pw.groups = append(pw.groups, chunks)
+	pw.sizes = append(pw.sizes, pw.rows)
+	pw.rows = 0
+}
+
+// close writes the pending rows and the footer describing the file.
+func (pw *parquetWriter) close() error {
+	pw.flush()
+	var t thriftWriter
+	t.begin(0)
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(pw.cols)+1)
+	t.begin(0)
+	t.str(4, "schema")
+	t.i32(5, int32(len(pw.cols)))
+	t.end()
+	for _, c := range pw.cols {
+		t.begin(0)
+		t.i32(1, c.kind)
+		t.i32(3, 0) // REQUIRED
+		t.str(4, c.name)
+		if c.converted >= 0 {
+			t.i32(6, c.converted)
+		}
+		t.end()
+	}
+	t.i64(3, int64(pw.total))
+	t.list(4, thriftStruct, len(pw.groups))
+	for g, chunks := range pw.groups {
+		t.begin(0)
+		t.list(1, thriftStruct, len(chunks))
+		var size int64
+		for i, ch := range chunks {
+			size += ch.size
+			t.begin(0)
+			t.i64(2, ch.offset)
+			t.begin(3)
+			t.i32(1, pw.cols[i].kind)
+			t.list(2, thriftI32, 2)
+			t.elemI32(parquetPlain)
+			t.elemI32(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.elemStr(pw.cols[i].name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, int64(pw.sizes[g]))
+			t.i64(6, ch.size)
+			t.i64(7, ch.size)
+			t.i64(9, ch.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, size)
+		t.i64(3, int64(pw.sizes[g]))
+		t.end()
+	}
+	t.str(6, "mosaic")
+	t.end()
+	pw.write(t.b)
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(t.b))))
+	pw.write([]byte("PAR1"))
+	if pw.err == nil {
+		pw.err = pw.w.Flush()
+	}
+	return pw.err
+}
+
+// Types of the Thrift compact protocol Parquet metadata is encoded in.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs in the Thrift compact protocol, as far as
+// Parquet metadata needs it.
+type thriftWriter struct {
+	b    []byte
+	last []int16 // Id of the last field of each open struct
+}
+
+// field writes the header of field id of type typ: the difference to the
+// id of the field before it if small, the id itself otherwise.
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		t.b = append(t.b, byte(d)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.b = binary.AppendVarint(t.b, int64(id))
+	}
+	*last = id
+}
+
+// i32 writes field id as an i32.
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.elemI32(v)
+}
+
+// i64 writes field id as an i64.
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.b = binary.AppendVarint(t.b, v)
+}
+
+// str writes field id as a string.
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemStr(s)
+}
+
+// elemI32 writes an i32 element of a list.
+func (t *thriftWriter) elemI32(v int32) {
+	t.b = binary.AppendVarint(t.b, int64(v))
+}
+
+// elemStr writes a string element of a list.
+func (t *thriftWriter) elemStr(s string) {
+	t.b = binary.AppendUvarint(t.b, uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+// list writes the header of field id as a list of n elements of type
+// elem, which follow it.
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elem)
+		return
+	}
+	t.b = append(t.b, 0xf0|elem)
+	t.b = binary.AppendUvarint(t.b, uint64(n))
+}
+
+// begin opens a struct: field id of a struct, or with id 0 a list element
+// or the outermost struct. end closes it.
+func (t *thriftWriter) begin(id int16) {
+	if id > 0 {
+		t.field(id, thriftStruct)
+	}
+	t.last = append(t.last, 0)
+}
+
+// end closes the struct opened last.
+func (t *thriftWriter) end() {
+	t.b = append(t.b, 0)
+	t.last = t.last[:len(t.last)-1]
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunExport(t *testing.T) {
+	defer func(db, backend, instance string, retention time.Duration, tiers historyTiers) {
+		historyDB, historyBackend, historyInstance = db, backend, instance
+		historyRetention, historyDownsample = retention, tiers
+	}(historyDB, historyBackend, historyInstance, historyRetention, historyDownsample)
+	path := testHistoryPath(t, "sqlite")
+	store, err := openSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Now().UTC().Truncate(24 * time.Hour).Add(-48 * time.Hour)
+	assert.NoError(t, store.write([]HistoryResult{
+		{Time: day.Add(time.Hour), Host: "core-sw", LatencyMs: 3, State: "up"},
+		{Time: day.Add(2 * time.Hour), Host: "core-sw", PacketLoss: 100, State: "down"},
+		{Time: day.Add(25 * time.Hour), Host: "vpn-gw", LatencyMs: 40, State: "up"},
+	}))
+	store.close()
+
+	out := filepath.Join(t.TempDir(), "parquet")
+	var log bytes.Buffer
+	assert.NoError(t, runExport([]string{"-history-db", path, "-since", "96h", "-out", out}, &log))
+	assert.Contains(t, log.String(), "Wrote 2 results")
+	assert.Contains(t, log.String(), "Wrote 1 results")
+
+	first, err := os.ReadFile(filepath.Join(out, day.Format("2006-01-02")+".parquet"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("PAR1"), first[:4])
+		assert.Equal(t, []byte("PAR1"), first[len(first)-4:])
+		footer := int(binary.LittleEndian.Uint32(first[len(first)-8:]))
+		assert.Less(t, footer, len(first)-12, "the footer length points into the file")
+		assert.Contains(t, string(first), "core-sw")
+		assert.NotContains(t, string(first), "vpn-gw", "a file per day")
+	}
+	_, err = os.Stat(filepath.Join(out, day.Add(24*time.Hour).Format("2006-01-02")+".parquet"))
+	assert.NoError(t, err)
+	files, _ := filepath.Glob(filepath.Join(out, "*"))
+	assert.Len(t, files, 2, "no files for days without results, nor temporary ones")
+
+	assert.ErrorContains(t, runExport([]string{"-history-db", path, "-format", "orc"}, &log), "unknown format")
+	assert.Error(t, runExport(nil, &log), "needs -history-db")
+}
+
+func TestParquetWriterRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	pw := newParquetWriter(&buf)
+	at := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < parquetRowGroup+1; i++ {
+		assert.NoError(t, pw.add(HistoryResult{Time: at.Add(time.Duration(i) * time.Second), Host: "a", State: "up"}))
+	}
+	assert.NoError(t, pw.close())
+	assert.Len(t, pw.groups, 2)
+	assert.Equal(t, []int{parquetRowGroup, 1}, pw.sizes)
+	// A required PLAIN int64 column is its values, right after the page header
+	ch := pw.groups[1][0]
+	b := buf.Bytes()
+	assert.Equal(t, uint64(at.Add(parquetRowGroup*time.Second).UnixMilli()), binary.LittleEndian.Uint64(b[ch.offset+ch.size-8:]))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+
+	"golang.org/x/net/icmp"
+)
+
+// ICMP ping modes. Privileged pings use raw sockets and need root or
+// CAP_NET_RAW; unprivileged pings use ICMP datagram ("UDP") sockets, which
+// macOS allows by default and Linux allows to groups in
+// net.ipv4.ping_group_range.
+const (
+	pingModeAuto         = "auto"
+	pingModePrivileged   = "privileged"
+	pingModeUnprivileged = "unprivileged"
+	pingModeUnavailable  = "unavailable"
+)
+
+// PingMode describes how ICMP hosts are pinged, served by /api/ping-mode.
+type PingMode struct {
+	Mode     string `json:"mode"`             // privileged, unprivileged or unavailable
+	Platform string `json:"platform"`         // Operating system mosaic runs on
+	Detail   string `json:"detail,omitempty"` // Why this mode was chosen and how to change it
+}
+
+var (
+	pingModeMu sync.Mutex
+	// pingMode is the active mode, set at startup by detectPingMode.
+	pingMode = PingMode{Mode: pingModePrivileged, Platform: runtime.GOOS}
+)
+
+// canListenICMP is a variable to allow mocking socket privileges in tests.
+// It reports whether an ICMP socket can be opened on network, e.g.
+// "ip4:icmp" for raw or "udp4" for datagram sockets.
+var canListenICMP = func(network string) bool {
+	addr := "0.0.0.0"
+	if network == "ip6:ipv6-icmp" || network == "udp6" {
+		addr = "::"
+	}
+	c, err := icmp.ListenPacket(network, addr)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// pingPermissionHint tells how to allow ICMP pings on goos.
+func pingPermissionHint(goos string) string {
+	switch goos {
+	case "linux":
+		return "grant CAP_NET_RAW (sudo setcap cap_net_raw+ep ./mosaic) or allow unprivileged ping (sudo sysctl -w net.ipv4.ping_group_range=\"0 2147483647\")"
+	case "darwin":
+		return "unprivileged ping should work out of the box; run with sudo for raw sockets"
+	}
+	return "run mosaic as root"
+}
+
+// detectPingMode chooses how ICMP hosts are pinged by trying to open the
+// sockets each mode needs. In auto mode raw sockets are preferred, since only
+// they receive the ICMP errors that explain why a host is down (see
+// icmpErrorLog), with datagram sockets as the fallback. A mode requested with
+// -ping-mode is used even if its socket can't be opened, with a warning in
+// Detail. Windows only supports privileged pings.
+//
+// Parameters:
+//   - requested: auto, privileged or unprivileged
+//   - goos: The operating system, usually runtime.GOOS
+//
+// Returns:
+//   - PingMode: The mode to use
+func detectPingMode(requested, goos string) PingMode {
+	mode := PingMode{Platform: goos}
+	if goos == "windows" {
+		mode.Mode = pingModePrivileged
+		mode.Detail = "Windows only supports privileged ICMP"
+		return mode
+	}
+	raw := canListenICMP("ip4:icmp") || canListenICMP("ip6:ipv6-icmp")
+	udp := canListenICMP("udp4") || canListenICMP("udp6")
+	switch requested {
+	case pingModePrivileged:
+		mode.Mode = pingModePrivileged
+		if !raw {
+			mode.Detail = "raw ICMP sockets unavailable, pings will fail: " + pingPermissionHint(goos)
+		}
+	case pingModeUnprivileged:
+		mode.Mode = pingModeUnprivileged
+		if !udp {
+			mode.Detail = "ICMP datagram sockets unavailable, pings will fail: " + pingPermissionHint(goos)
+		}
+	default:
+		switch {
+		case raw:
+			mode.Mode = pingModePrivileged
+		case udp:
+			mode.Mode = pingModeUnprivileged
+			mode.Detail = "no raw socket privileges, pinging over ICMP datagram sockets; down hosts are reported as timeouts"
+		default:
+			mode.Mode = pingModeUnavailable
+			mode.Detail = "cannot open ICMP sockets, ICMP hosts will show as down: " + pingPermissionHint(goos)
+		}
+	}
+	return mode
+}
+
+// parsePingMode validates a -ping-mode value.
+func parsePingMode(s string) (string, error) {
+	switch s {
+	case pingModeAuto, pingModePrivileged, pingModeUnprivileged:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid ping mode %q: expected auto, privileged or unprivileged", s)
+}
+
+// setPingMode makes mode the active ping mode.
+func setPingMode(mode PingMode) {
+	pingModeMu.Lock()
+	pingMode = mode
+	pingModeMu.Unlock()
+}
+
+// currentPingMode returns the active ping mode.
+func currentPingMode() PingMode {
+	pingModeMu.Lock()
+	defer pingModeMu.Unlock()
+	return pingMode
+}
+
+// pingPrivileged reports whether pingers use raw sockets. When no mode
+// works, privileged pingers fail with the clearer error.
+func pingPrivileged() bool {
+	return currentPingMode().Mode != pingModeUnprivileged
+}
+
+// pingModeHandler serves the active ping mode as JSON.
+func pingModeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentPingMode())
+}
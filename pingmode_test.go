@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockICMPSockets makes only the given socket networks openable.
+func mockICMPSockets(t *testing.T, networks ...string) {
+	old := canListenICMP
+	t.Cleanup(func() { canListenICMP = old })
+	canListenICMP = func(network string) bool {
+		for _, n := range networks {
+			if n == network {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestDetectPingMode(t *testing.T) {
+	mockICMPSockets(t, "ip4:icmp", "udp4")
+	mode := detectPingMode(pingModeAuto, "linux")
+	assert.Equal(t, pingModePrivileged, mode.Mode)
+	assert.Empty(t, mode.Detail)
+
+	mockICMPSockets(t, "udp4", "udp6")
+	mode = detectPingMode(pingModeAuto, "linux")
+	assert.Equal(t, pingModeUnprivileged, mode.Mode)
+	assert.Contains(t, mode.Detail, "datagram")
+
+	mockICMPSockets(t)
+	mode = detectPingMode(pingModeAuto, "linux")
+	assert.Equal(t, pingModeUnavailable, mode.Mode)
+	assert.Contains(t, mode.Detail, "ping_group_range")
+	assert.Equal(t, "linux", mode.Platform)
+
+	// Windows has no datagram ICMP sockets
+	mode = detectPingMode(pingModeAuto, "windows")
+	assert.Equal(t, pingModePrivileged, mode.Mode)
+}
+
+func TestDetectPingModeRequested(t *testing.T) {
+	mockICMPSockets(t, "ip4:icmp")
+	mode := detectPingMode(pingModeUnprivileged, "darwin")
+	assert.Equal(t, pingModeUnprivileged, mode.Mode)
+	assert.Contains(t, mode.Detail, "unavailable")
+
+	mode = detectPingMode(pingModePrivileged, "darwin")
+	assert.Equal(t, pingModePrivileged, mode.Mode)
+	assert.Empty(t, mode.Detail)
+
+	_, err := parsePingMode("raw")
+	assert.Error(t, err)
+	m, err := parsePingMode("unprivileged")
+	assert.NoError(t, err)
+	assert.Equal(t, pingModeUnprivileged, m)
+}
+
+func TestPingPrivileged(t *testing.T) {
+	defer setPingMode(currentPingMode())
+
+	setPingMode(PingMode{Mode: pingModeUnprivileged})
+	assert.False(t, pingPrivileged())
+	setPingMode(PingMode{Mode: pingModeUnavailable})
+	assert.True(t, pingPrivileged())
+	setPingMode(PingMode{Mode: pingModePrivileged, Platform: "linux"})
+	assert.True(t, pingPrivileged())
+
+	w := httptest.NewRecorder()
+	pingModeHandler(w, httptest.NewRequest(http.MethodGet, "/api/ping-mode", nil))
+	var got PingMode
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.Equal(t, PingMode{Mode: pingModePrivileged, Platform: "linux"}, got)
+}
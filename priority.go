@@ -0,0 +1,83 @@
+package main
+
+import "fmt"
+
+// Host priorities, from the most to the least important. Hosts without a
+// priority in their label are normal.
+const (
+	priorityCritical = "critical"
+	priorityHigh     = "high"
+	priorityNormal   = "normal"
+	priorityLow      = "low"
+)
+
+// priorityRanks orders the priorities; a higher rank is more important.
+var priorityRanks = map[string]int{priorityLow: 0, "": 1, priorityNormal: 1, priorityHigh: 2, priorityCritical: 3}
+
+// WorstStatus summarizes the most important trouble on the board: the
+// highest priority any unhealthy host has, and the worst state among the
+// hosts of that priority.
+type WorstStatus struct {
+	Priority string   `json:"priority"` // "critical", "high", "normal" or "low"
+	State    string   `json:"state"`    // "down" or "degraded"
+	Hosts    []string `json:"hosts"`    // The hosts of that priority in that state, in board order
+}
+
+// validatePriority reports an error if p is not a priority.
+func validatePriority(p string) error {
+	if _, ok := priorityRanks[p]; !ok {
+		return fmt.Errorf("invalid priority %q: must be critical, high, normal or low", p)
+	}
+	return nil
+}
+
+// hostsPriority returns the highest priority of hosts from their labels.
+func hostsPriority(hosts []string) string {
+	labelsMu.RLock()
+	defer labelsMu.RUnlock()
+	best := priorityLow
+	for _, h := range hosts {
+		if p := hostLabels[h].Priority; priorityRanks[p] > priorityRanks[best] {
+			best = p
+		}
+	}
+	if best == "" {
+		return priorityNormal
+	}
+	return best
+}
+
+// worstStatus finds the worst status of statuses. Hosts count as unhealthy
+// when they are down, degraded or slower than their warning threshold, but
+// not while they are paused or unreachable behind a parent.
+//
+// Parameters:
+//   - statuses: The statuses of the probed hosts, with priorities and
+//     thresholds annotated
+//
+// Returns:
+//   - *WorstStatus: The worst status, or nil if every host is healthy
+func worstStatus(statuses []HostStatus) *WorstStatus {
+	var worst *WorstStatus
+	rank := -1
+	for _, s := range statuses {
+		if s.Paused || s.Unreachable || !unhealthy(s) {
+			continue
+		}
+		p := s.Priority
+		if p == "" {
+			p = priorityNormal
+		}
+		state := stateDegraded
+		if !s.Alive {
+			state = stateDown
+		}
+		switch {
+		case priorityRanks[p] > rank || (priorityRanks[p] == rank && state == stateDown && worst.State != stateDown):
+			worst, rank = &WorstStatus{Priority: p, State: state, Hosts: []string{s.Host}}, priorityRanks[p]
+		case priorityRanks[p] == rank && state == worst.State:
+			worst.Hosts = append(worst.Hosts, s.Host)
+		}
+	}
+	return worst
+}
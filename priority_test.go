@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePriority(t *testing.T) {
+	for _, p := range []string{"", "critical", "high", "normal", "low"} {
+		assert.NoError(t, validatePriority(p), p)
+	}
+	assert.Error(t, validatePriority("urgent"))
+	assert.Error(t, Config{Hosts: []string{"a"}, Labels: map[string]HostLabel{"a": {Priority: "urgent"}}}.validate())
+}
+
+func TestHostsPriority(t *testing.T) {
+	defer setLabels(labels())
+	setLabels(map[string]HostLabel{"core-sw1": {Priority: priorityCritical}, "lab-vm": {Priority: priorityLow}})
+	assert.Equal(t, priorityCritical, hostsPriority([]string{"lab-vm", "core-sw1"}))
+	assert.Equal(t, priorityNormal, hostsPriority([]string{"lab-vm", "nas"}))
+	assert.Equal(t, priorityLow, hostsPriority([]string{"lab-vm"}))
+}
+
+func TestWorstStatus(t *testing.T) {
+	assert.Nil(t, worstStatus([]HostStatus{{Host: "a", Alive: true, LatencyMs: 5, WarnMs: 150}}))
+
+	statuses := []HostStatus{
+		{Host: "lab-vm", Priority: priorityLow},
+		{Host: "core-sw1", Alive: true, LatencyMs: 300, WarnMs: 150, Priority: priorityCritical},
+		{Host: "core-sw2", Alive: true, LatencyMs: 5, WarnMs: 150, Priority: priorityCritical},
+		{Host: "nas"},
+	}
+	assert.Equal(t, &WorstStatus{Priority: priorityCritical, State: stateDegraded, Hosts: []string{"core-sw1"}}, worstStatus(statuses),
+		"a slow core switch is worse than a dead test VM")
+
+	statuses = append(statuses, HostStatus{Host: "core-sw3", Priority: priorityCritical}, HostStatus{Host: "core-sw4", Priority: priorityCritical, Unreachable: true})
+	assert.Equal(t, &WorstStatus{Priority: priorityCritical, State: stateDown, Hosts: []string{"core-sw3"}}, worstStatus(statuses))
+
+	assert.Equal(t, &WorstStatus{Priority: priorityNormal, State: stateDown, Hosts: []string{"nas"}}, worstStatus(statuses[3:4]))
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// probeResult is the outcome of a single check against a host.
+// Connection-oriented probes count one attempt as one sent packet.
+type probeResult struct {
+	Sent     int           // Packets or attempts sent
+	Recv     int           // Packets or attempts answered
+	Latency  time.Duration // Average round-trip or handshake time
+	Offset   time.Duration // Clock offset reported by time probes
+	Degraded bool          // The host answered, but not with the expected response
+	Detail   string        // Optional human-readable explanation
+	Reason   string        // Why nothing answered, e.g. "host_unreachable"
+	Ports    []PortStatus  // Per-port results of multi-port TCP probes
+	Err      error         // Set when the probe could not be run at all
+}
+
+// probers maps a host scheme to the function probing it. Hosts without a
+// scheme (e.g. "8.8.8.8") are pinged with ICMP.
+var probers = map[string]func(addr string) probeResult{}
+
+func init() {
+	probers[""] = pingICMP
+	probers["icmp"] = pingICMP
+	probers["arp"] = probeARP
+	probers["tcp"] = probeTCP
+	probers["udp"] = probeUDP
+	probers["ssh"] = probeSSH
+	probers["smtp"] = probeSMTP
+	probers["smtps"] = probeSMTPS
+	probers["ntp"] = probeNTP
+	probers["exec"] = probeExec
+	probers["sim"] = probeSim
+	probers["http"] = func(addr string) probeResult { return probeHTTP("http://" + addr) }
+	probers["https"] = func(addr string) probeResult { return probeHTTP("https://" + addr) }
+}
+
+var (
+	// probeTimeout bounds how long a probe may take, for ICMP including all
+	// echo requests of a cycle.
+	probeTimeout = 2 * time.Second
+	// probeCount is the number of ICMP echo requests sent per cycle.
+	probeCount = 1
+	// probeSize is the ICMP echo payload size in bytes.
+	probeSize = minProbeSize
+)
+
+// minProbeSize is the smallest payload pro-bing can match replies with.
+const minProbeSize = 24
+
+// splitScheme splits a host entry such as "ssh://jump01:2222" into its
+// scheme and address. Entries without "://" have an empty scheme, except
+// "host:port[,port...]" entries which are TCP probes.
+func splitScheme(host string) (string, string) {
+	if scheme, addr, ok := strings.Cut(host, "://"); ok {
+		return strings.ToLower(scheme), addr
+	}
+	if _, _, ok := splitPorts(host); ok {
+		return "tcp", host
+	}
+	return "", host
+}
+
+// splitOptions separates probe options given as a query string from the
+// address, e.g. "mail:587?ehlo=mon.example.com".
+func splitOptions(addr string) (string, url.Values) {
+	addr, query, _ := strings.Cut(addr, "?")
+	opts, _ := url.ParseQuery(query)
+	return addr, opts
+}
+
+// validateHost reports an error if host uses a scheme no probe handles.
+// For logical hosts every address is checked. Control characters and
+// whitespace are rejected, except in exec:// commands and logical host
+// names, since hosts files list one entry per line and /etc/hosts format
+// separates names by whitespace.
+func validateHost(host string) error {
+	if strings.IndexFunc(host, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%q: control characters are not allowed", host)
+	}
+	if strings.TrimSpace(host) != host {
+		return fmt.Errorf("%q: leading or trailing whitespace is not allowed", host)
+	}
+	if _, members, ok := splitAlias(host); ok {
+		for _, m := range members {
+			if _, _, nested := splitAlias(m); nested {
+				return fmt.Errorf("%s: aliases cannot be nested", host)
+			}
+			if err := validateHost(m); err != nil {
+				return fmt.Errorf("%s: %w", host, err)
+			}
+		}
+		return nil
+	}
+	scheme, addr := splitScheme(host)
+	if _, ok := probers[scheme]; !ok {
+		return fmt.Errorf("%s: unknown probe type %q", host, scheme)
+	}
+	if addr == "" {
+		return fmt.Errorf("%s: missing address", host)
+	}
+	if scheme != "exec" && strings.IndexFunc(addr, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("%q: whitespace is only allowed in exec:// commands and logical host names", host)
+	}
+	if scheme == "exec" && !allowExec {
+		return fmt.Errorf("%s: exec probes are disabled, start with -allow-exec to enable them", host)
+	}
+	switch scheme {
+	case "postgres", "postgresql", "mysql", "redis":
+		if denied := deniedEnv(addr); len(denied) > 0 {
+			return fmt.Errorf("%s: $%s is not in -env-allow", host, denied[0])
+		}
+	}
+	if scheme == "" || scheme == "icmp" {
+		_, opts := splitOptions(addr)
+		switch opts.Get("family") {
+		case "", familyIPv4, familyIPv6, familyDual:
+		default:
+			return fmt.Errorf("%s: family must be 4, 6 or dual", host)
+		}
+		if v := opts.Get("dscp"); v != "" {
+			if _, err := dscpOption(v); err != nil {
+				return fmt.Errorf("%s: %v", host, err)
+			}
+		}
+		if src := opts.Get("source"); src != "" {
+			if err := validSource(src); err != nil {
+				return fmt.Errorf("%s: %v", host, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envAllow holds the environment variables database probe addresses may
+// reference, set with -env-allow. Any other variable would let a host entry
+// send a secret of mosaic's environment to the server it names, so hosts
+// referencing one are rejected.
+var envAllow = make(map[string]bool)
+
+// setEnvAllow parses the comma-separated -env-allow list of variable names.
+func setEnvAllow(s string) {
+	allow := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allow[name] = true
+		}
+	}
+	envAllow = allow
+}
+
+// expandEnv replaces $VAR and ${VAR} in addr by the variables in
+// -env-allow and any others by nothing.
+func expandEnv(addr string) string {
+	return os.Expand(addr, func(name string) string {
+		if !envAllow[name] {
+			return ""
+		}
+		return os.Getenv(name)
+	})
+}
+
+// deniedEnv returns the environment variables addr references that are not
+// in -env-allow.
+func deniedEnv(addr string) []string {
+	var denied []string
+	os.Expand(addr, func(name string) string {
+		if !envAllow[name] {
+			denied = append(denied, name)
+		}
+		return ""
+	})
+	return denied
+}
+
+// withDefaultPort appends port to addr unless it already carries one.
+func withDefaultPort(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// arpInterface is the default interface for arp:// probes, set with
+// -arp-iface. When empty the interface whose subnet contains the target is
+// used.
+var arpInterface string
+
+// arpInterfaceFor picks the interface to send ARP requests for target on and
+// returns it together with the local IPv4 address to send from.
+func arpInterfaceFor(target net.IP, name string) (*net.Interface, net.IP, error) {
+	var ifaces []net.Interface
+	if name != "" {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		ifaces = []net.Interface{*iface}
+	} else {
+		var err error
+		if ifaces, err = net.Interfaces(); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil || len(ifaces[i].HardwareAddr) != 6 {
+			continue
+		}
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if !ok || n.IP.To4() == nil {
+				continue
+			}
+			if name != "" || n.Contains(target) {
+				return &ifaces[i], n.IP.To4(), nil
+			}
+		}
+	}
+	if name != "" {
+		return nil, nil, fmt.Errorf("interface %s has no IPv4 address", name)
+	}
+	return nil, nil, fmt.Errorf("%s is not on a local subnet, set -arp-iface or ?iface=", target)
+}
+
+// buildARPRequest builds an Ethernet/IPv4 ARP "who-has" request (RFC 826).
+func buildARPRequest(srcMAC net.HardwareAddr, srcIP, target net.IP) []byte {
+	p := make([]byte, 28)
+	binary.BigEndian.PutUint16(p[0:], 1)      // Ethernet
+	binary.BigEndian.PutUint16(p[2:], 0x0800) // IPv4
+	p[4], p[5] = 6, 4
+	binary.BigEndian.PutUint16(p[6:], 1) // Request
+	copy(p[8:14], srcMAC)
+	copy(p[14:18], srcIP.To4())
+	copy(p[24:28], target.To4())
+	return p
+}
+
+// parseARPReply returns the hardware address announced by an ARP reply for
+// target, or false if p is anything else.
+func parseARPReply(p []byte, target net.IP) (net.HardwareAddr, bool) {
+	if len(p) < 28 || binary.BigEndian.Uint16(p[6:]) != 2 || p[4] != 6 || p[5] != 4 {
+		return nil, false
+	}
+	if !bytes.Equal(p[14:18], target.To4()) {
+		return nil, false
+	}
+	return net.HardwareAddr(append([]byte(nil), p[8:14]...)), true
+}
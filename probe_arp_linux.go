@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// probeARP checks liveness of a host on the local segment by sending an ARP
+// request and waiting for the reply. Devices that drop ICMP still have to
+// answer ARP to take part in IPv4. Needs the same raw socket privileges as
+// ICMP.
+//
+// Parameters:
+//   - addr: IPv4 address, optionally with "?iface=eth0" to pick the interface
+//
+// Returns:
+//   - probeResult: One attempt, answered if the target replied, with its MAC
+//     address as the detail
+func probeARP(addr string) probeResult {
+	addr, opts := splitOptions(addr)
+	target := net.ParseIP(addr).To4()
+	if target == nil {
+		return probeResult{Err: fmt.Errorf("arp probes need an IPv4 address, got %q", addr)}
+	}
+	name := opts.Get("iface")
+	if name == "" {
+		name = arpInterface
+	}
+	iface, srcIP, err := arpInterfaceFor(target, name)
+	if err != nil {
+		return probeResult{Err: err}
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return probeResult{Err: fmt.Errorf("arp socket: %w", err)}
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index}); err != nil {
+		return probeResult{Err: fmt.Errorf("arp bind: %w", err)}
+	}
+
+	start := time.Now()
+	deadline := start.Add(probeTimeout)
+	broadcast := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ARP), Ifindex: iface.Index, Halen: 6}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err := unix.Sendto(fd, buildARPRequest(iface.HardwareAddr, srcIP, target), 0, broadcast); err != nil {
+		return probeResult{Sent: 1, Detail: err.Error()}
+	}
+
+	buf := make([]byte, 128)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return probeResult{Sent: 1}
+		}
+		tv := unix.NsecToTimeval(remaining.Nanoseconds())
+		unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return probeResult{Sent: 1}
+		}
+		if mac, ok := parseARPReply(buf[:n], target); ok {
+			return probeResult{Sent: 1, Recv: 1, Latency: time.Since(start), Detail: "is-at " + mac.String()}
+		}
+	}
+}
+
+// htons converts a 16-bit value to network byte order as AF_PACKET expects.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// probeARP is only implemented on Linux, where AF_PACKET sockets are available.
+func probeARP(addr string) probeResult {
+	return probeResult{Err: fmt.Errorf("arp probes are only supported on Linux")}
+}
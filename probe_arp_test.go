@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestARPPackets(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	target := net.ParseIP("192.168.1.20")
+	req := buildARPRequest(mac, net.ParseIP("192.168.1.2"), target)
+	assert.Len(t, req, 28)
+	assert.Equal(t, []byte{0, 1, 8, 0, 6, 4, 0, 1}, req[:8])
+	assert.Equal(t, []byte(mac), req[8:14])
+	assert.Equal(t, []byte{192, 168, 1, 20}, req[24:28])
+
+	// A request is not a reply
+	_, ok := parseARPReply(req, target)
+	assert.False(t, ok)
+
+	reply := append([]byte(nil), req...)
+	reply[7] = 2
+	copy(reply[8:14], []byte{0xaa, 0xbb, 0xcc, 0, 0, 1})
+	copy(reply[14:18], target.To4())
+	got, ok := parseARPReply(reply, target)
+	assert.True(t, ok)
+	assert.Equal(t, "aa:bb:cc:00:00:01", got.String())
+
+	_, ok = parseARPReply(reply, net.ParseIP("192.168.1.21"))
+	assert.False(t, ok)
+	_, ok = parseARPReply(reply[:20], target)
+	assert.False(t, ok)
+}
+
+func TestARPInterfaceFor(t *testing.T) {
+	_, _, err := arpInterfaceFor(net.ParseIP("203.0.113.9"), "no-such-iface0")
+	assert.Error(t, err)
+	assert.Error(t, probeARP("db01").Err)
+}
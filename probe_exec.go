@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// allowExec enables exec:// probes. It is off by default because a host
+// entry would otherwise be enough to run arbitrary commands.
+var allowExec = false
+
+// runsCommand reports whether host, or a member of it if it is a logical
+// host, is an exec:// probe.
+func runsCommand(host string) bool {
+	if _, members, ok := splitAlias(host); ok {
+		for _, m := range members {
+			if runsCommand(m) {
+				return true
+			}
+		}
+		return false
+	}
+	scheme, _ := splitScheme(host)
+	return scheme == "exec"
+}
+
+// execOutput is the optional JSON line a check script may print on stdout.
+type execOutput struct {
+	LatencyMs *float64 `json:"latency_ms"` // Overrides the measured execution time
+	Loss      *float64 `json:"loss"`       // Packet loss percentage (0-100)
+	Sent      *int     `json:"sent"`       // Packets sent, alternative to loss
+	Recv      *int     `json:"recv"`       // Packets received, alternative to loss
+	Detail    string   `json:"detail"`     // Shown in the tooltip
+}
+
+// probeExec runs an external check and maps its result onto a probe result,
+// following the Nagios plugin convention for exit codes:
+//
+//	exec:///usr/local/bin/check_vpn.sh --site=ams
+//
+// Exit code 0 is up, 1 is degraded (warning), anything else or a timeout is
+// down. If the last line of stdout is a JSON object it may set latency_ms,
+// loss (or sent/recv) and detail; otherwise the first output line becomes the
+// detail and the latency is the command's run time.
+func probeExec(addr string) probeResult {
+	args := strings.Fields(addr)
+	if len(args) == 0 {
+		return probeResult{Err: errors.New("empty command")}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	// Don't wait for children of the check that keep stdout open after a timeout
+	cmd.WaitDelay = 100 * time.Millisecond
+	start := time.Now()
+	err := cmd.Run()
+	res := probeResult{Sent: 1, Latency: time.Since(start)}
+
+	var exitErr *exec.ExitError
+	code := 0
+	switch {
+	case ctx.Err() != nil:
+		res.Detail = "timed out after " + probeTimeout.String()
+		return res
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		return probeResult{Err: err}
+	}
+
+	counted := applyExecOutput(&res, stdout.String())
+	switch code {
+	case 0, 1:
+		if !counted {
+			res.Recv = res.Sent
+		}
+		res.Degraded = code == 1
+	default:
+		res.Recv = 0
+		if res.Detail == "" {
+			res.Detail = fmt.Sprintf("exit status %d", code)
+		}
+	}
+	return res
+}
+
+// applyExecOutput fills res from a check's stdout as described in probeExec
+// and reports whether the output set the packet counters.
+func applyExecOutput(res *probeResult, out string) bool {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	var parsed execOutput
+	if !strings.HasPrefix(last, "{") || json.Unmarshal([]byte(last), &parsed) != nil {
+		res.Detail = strings.TrimSpace(lines[0])
+		return false
+	}
+	res.Detail = parsed.Detail
+	if parsed.LatencyMs != nil {
+		res.Latency = time.Duration(*parsed.LatencyMs * float64(time.Millisecond))
+	}
+	switch {
+	case parsed.Sent != nil && parsed.Recv != nil:
+		res.Sent = *parsed.Sent
+		res.Recv = *parsed.Recv
+	case parsed.Loss != nil:
+		// Express the loss percentage as packets out of 100
+		res.Sent = 100
+		res.Recv = 100 - int(math.Round(math.Min(math.Max(*parsed.Loss, 0), 100)))
+	default:
+		return false
+	}
+	return true
+}
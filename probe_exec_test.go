@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeScript creates an executable shell script in a temporary directory.
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "check.sh")
+	assert.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755))
+	return path
+}
+
+func TestProbeExecExitCodes(t *testing.T) {
+	res := probeExec(writeScript(t, "echo OK - all good; exit 0"))
+	assert.Equal(t, 1, res.Recv)
+	assert.False(t, res.Degraded)
+	assert.Equal(t, "OK - all good", res.Detail)
+
+	res = probeExec(writeScript(t, "echo WARNING - slow; exit 1"))
+	assert.Equal(t, 1, res.Recv)
+	assert.True(t, res.Degraded)
+
+	res = probeExec(writeScript(t, "exit 2"))
+	assert.Equal(t, 1, res.Sent)
+	assert.Equal(t, 0, res.Recv)
+	assert.Equal(t, "exit status 2", res.Detail)
+}
+
+func TestProbeExecArgumentsAndJSON(t *testing.T) {
+	script := writeScript(t, `echo "checking $1"; echo '{"latency_ms": 42.5, "sent": 10, "recv": 9, "detail": "'$1'"}'`)
+	res := probeExec(script + " ams")
+	assert.Equal(t, 10, res.Sent)
+	assert.Equal(t, 9, res.Recv)
+	assert.Equal(t, 42500*time.Microsecond, res.Latency)
+	assert.Equal(t, "ams", res.Detail)
+
+	res = probeExec(writeScript(t, `echo '{"loss": 25}'`))
+	assert.Equal(t, 100, res.Sent)
+	assert.Equal(t, 75, res.Recv)
+}
+
+func TestProbeExecTimeout(t *testing.T) {
+	old := probeTimeout
+	defer func() { probeTimeout = old }()
+	probeTimeout = 100 * time.Millisecond
+
+	res := probeExec(writeScript(t, "sleep 5"))
+	assert.Equal(t, 0, res.Recv)
+	assert.Contains(t, res.Detail, "timed out")
+}
+
+func TestProbeExecMissingCommand(t *testing.T) {
+	assert.Error(t, probeExec("/nonexistent/check").Err)
+	assert.Error(t, probeExec("  ").Err)
+}
+
+func TestValidateHostExec(t *testing.T) {
+	defer func() { allowExec = false }()
+	assert.Error(t, validateHost("exec:///bin/true"))
+	allowExec = true
+	assert.NoError(t, validateHost("exec:///bin/true"))
+}
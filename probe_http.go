@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// maxHTTPBody limits how much of a response body is read for matching.
+const maxHTTPBody = 1 << 20
+
+// probeHTTP fetches a URL and checks the response. Options are given in the
+// URL fragment, which is never sent to the server:
+//
+//	https://app.example.com/health
+//	https://app.example.com/health#contains=OK
+//	https://app.example.com/status#regex=%22db%22%3A%5Cs*%22up%22
+//	https://10.0.0.5/#insecure=1
+//	https://edge.example.com/#h3=1
+//
+// Connection failures and timeouts are down. A 4xx/5xx status, or a
+// successful response whose body lacks the expected substring or does not
+// match the regex, is degraded so "up but serving an error page" stands out.
+// With h3=1 the request is made over HTTP/3 (QUIC) and the QUIC handshake and
+// first-byte times are added to the detail.
+func probeHTTP(rawURL string) probeResult {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return probeResult{Err: err}
+	}
+	opts, _ := url.ParseQuery(u.Fragment)
+	u.Fragment = ""
+	var re *regexp.Regexp
+	if expr := opts.Get("regex"); expr != "" {
+		if re, err = regexp.Compile(expr); err != nil {
+			return probeResult{Err: fmt.Errorf("invalid regex: %w", err)}
+		}
+	}
+	contains := opts.Get("contains")
+
+	dialer, err := probeDialer("tcp")
+	if err != nil {
+		return probeResult{Err: err}
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.Get("insecure") == "1"}
+	client := &http.Client{
+		Timeout: probeTimeout,
+		Transport: &http.Transport{
+			DialContext:       dialer.DialContext,
+			DisableKeepAlives: true,
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   tlsConfig,
+		},
+	}
+	var handshake time.Duration
+	if opts.Get("h3") == "1" {
+		h3 := &http3.Transport{
+			TLSClientConfig: tlsConfig,
+			QUICConfig:      &quic.Config{HandshakeIdleTimeout: probeTimeout},
+			Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+				start := time.Now()
+				conn, err := quic.DialAddr(ctx, addr, tlsCfg, cfg)
+				handshake = time.Since(start)
+				return conn, err
+			},
+		}
+		defer h3.Close()
+		client.Transport = h3
+	}
+	start := time.Now()
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return probeResult{Sent: 1, Detail: err.Error()}
+	}
+	defer resp.Body.Close()
+	firstByte := time.Since(start)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	latency := time.Since(start)
+	if err != nil {
+		return probeResult{Sent: 1, Detail: err.Error()}
+	}
+
+	res := probeResult{Sent: 1, Recv: 1, Latency: latency, Detail: resp.Status}
+	if handshake > 0 {
+		res.Detail = fmt.Sprintf("%s, handshake %dms, first byte %dms", resp.Status, handshake.Milliseconds(), firstByte.Milliseconds())
+	}
+	switch {
+	case resp.StatusCode >= 400:
+		res.Degraded = true
+	case contains != "" && !strings.Contains(string(body), contains):
+		res.Degraded = true
+		res.Detail = fmt.Sprintf("%s, body does not contain %q", res.Detail, contains)
+	case re != nil && !re.Match(body):
+		res.Degraded = true
+		res.Detail = fmt.Sprintf("%s, body does not match %q", res.Detail, re)
+	}
+	return res
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Write([]byte(`{"status": "ok", "db": "up"}`))
+		case "/maintenance":
+			w.Write([]byte(`<h1>Down for maintenance</h1>`))
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	res := probeHTTP(srv.URL + "/health")
+	assert.Equal(t, 1, res.Recv)
+	assert.False(t, res.Degraded)
+	assert.Equal(t, "200 OK", res.Detail)
+
+	res = probeHTTP(srv.URL + "/broken")
+	assert.Equal(t, 1, res.Recv)
+	assert.True(t, res.Degraded)
+	assert.Equal(t, "500 Internal Server Error", res.Detail)
+
+	assert.False(t, probeHTTP(srv.URL+"/health#contains=ok").Degraded)
+	res = probeHTTP(srv.URL + "/maintenance#contains=ok")
+	assert.True(t, res.Degraded)
+	assert.Contains(t, res.Detail, "does not contain")
+
+	regex := "#regex=" + url.QueryEscape(`"db":\s*"up"`)
+	assert.False(t, probeHTTP(srv.URL+"/health"+regex).Degraded)
+	assert.True(t, probeHTTP(srv.URL+"/maintenance"+regex).Degraded)
+
+	assert.Error(t, probeHTTP(srv.URL+"/health#regex=(").Err)
+}
+
+func TestProbeHTTPS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	// The test server's certificate is self-signed
+	res := probeHTTP(srv.URL)
+	assert.Equal(t, 0, res.Recv)
+	res = probeHTTP(srv.URL + "#insecure=1")
+	assert.Equal(t, 1, res.Recv)
+}
+
+func TestProbeHTTP3(t *testing.T) {
+	// Borrow the self-signed certificate of an httptest TLS server
+	tlsSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsSrv.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(tlsSrv.TLS),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, 3, r.ProtoMajor)
+			w.Write([]byte("ok over quic"))
+		}),
+	}
+	go srv.Serve(conn)
+	defer srv.Close()
+
+	base := "https://" + conn.LocalAddr().String() + "/"
+	res := probeHTTP(base + "#h3=1&insecure=1&contains=quic")
+	assert.Equal(t, 1, res.Recv)
+	assert.False(t, res.Degraded)
+	assert.Regexp(t, `^200 OK, handshake \d+ms, first byte \d+ms$`, res.Detail)
+
+	res = probeHTTP(base + "#h3=1&insecure=1&contains=tcp")
+	assert.True(t, res.Degraded)
+	assert.Contains(t, res.Detail, "handshake")
+
+	// Nothing answers HTTP/3 on a TCP-only server
+	defer func(saved time.Duration) { probeTimeout = saved }(probeTimeout)
+	probeTimeout = 200 * time.Millisecond
+	assert.Equal(t, 0, probeHTTP(tlsSrv.URL+"#h3=1&insecure=1").Recv)
+}
+
+func TestPingHostHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.URL.Fragment)
+		w.Write([]byte("healthy"))
+	}))
+	defer srv.Close()
+	host := srv.URL + "/#contains=healthy"
+	defer func() {
+		hostStatsMu.Lock()
+		delete(hostStats, host)
+		hostStatsMu.Unlock()
+	}()
+
+	status := pingHost(host)
+	assert.True(t, status.Alive)
+	assert.False(t, status.Degraded)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// defaultMaxNTPOffset is the clock offset above which an NTP server is
+// reported degraded unless the host sets max_offset.
+const defaultMaxNTPOffset = 100 * time.Millisecond
+
+// ntpEpochOffset is the number of seconds between 1900-01-01 and 1970-01-01.
+const ntpEpochOffset = 2208988800
+
+// probeNTP queries an NTP server (SNTPv4, port 123 by default) and reports
+// the round-trip delay as latency along with the local clock's offset:
+//
+//	ntp://ntp1.internal
+//	ntp://ntp1.internal?max_offset=50ms
+//
+// The host is degraded when the offset exceeds max_offset or the server
+// answers with a kiss-of-death (stratum 0) or unsynchronised reply.
+func probeNTP(addr string) probeResult {
+	addr, opts := splitOptions(addr)
+	maxOffset := defaultMaxNTPOffset
+	if v := opts.Get("max_offset"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return probeResult{Err: fmt.Errorf("invalid max_offset %q: %w", v, err)}
+		}
+		maxOffset = d
+	}
+
+	conn, err := dialProbe("udp", withDefaultPort(addr, "123"))
+	if err != nil {
+		return probeResult{Err: err}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+
+	req := make([]byte, 48)
+	req[0] = 0<<6 | 4<<3 | 3 // LI = 0, version 4, mode 3 (client)
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(t1))
+	if _, err := conn.Write(req); err != nil {
+		return probeResult{Sent: 1}
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t4 := time.Now()
+	if err != nil || n < 48 || resp[0]&0x7 != 4 {
+		return probeResult{Sent: 1}
+	}
+	if binary.BigEndian.Uint64(resp[24:]) != toNTPTime(t1) {
+		return probeResult{Sent: 1, Detail: "reply does not match request"}
+	}
+
+	t2 := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	res := probeResult{Sent: 1, Recv: 1, Latency: delay, Offset: offset}
+
+	stratum := resp[1]
+	leap := resp[0] >> 6
+	switch {
+	case stratum == 0:
+		res.Degraded = true
+		res.Detail = fmt.Sprintf("kiss-of-death %q", resp[12:16])
+	case leap == 3:
+		res.Degraded = true
+		res.Detail = "server clock not synchronised"
+	case offset > maxOffset || -offset > maxOffset:
+		res.Degraded = true
+		res.Detail = fmt.Sprintf("offset %s exceeds %s", offset.Round(time.Microsecond), maxOffset)
+	default:
+		res.Detail = fmt.Sprintf("offset %s, stratum %d", offset.Round(time.Microsecond), stratum)
+	}
+	return res
+}
+
+// toNTPTime converts t into a 64-bit NTP timestamp.
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+// fromNTPTime converts a 64-bit NTP timestamp into a time.Time.
+func fromNTPTime(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
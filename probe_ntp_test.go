@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ntpServer answers SNTP requests with a clock skewed by skew.
+func ntpServer(t *testing.T, stratum byte, skew time.Duration) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 48 {
+				continue
+			}
+			resp := make([]byte, 48)
+			resp[0] = 4<<3 | 4 // version 4, mode 4 (server)
+			resp[1] = stratum
+			copy(resp[12:16], "RATE")
+			copy(resp[24:32], buf[40:48])
+			now := time.Now().Add(skew)
+			binary.BigEndian.PutUint64(resp[32:], toNTPTime(now))
+			binary.BigEndian.PutUint64(resp[40:], toNTPTime(now))
+			conn.WriteTo(resp, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	now := time.Now()
+	back := fromNTPTime(toNTPTime(now))
+	assert.InDelta(t, 0, float64(back.Sub(now)), float64(time.Microsecond))
+}
+
+func TestProbeNTP(t *testing.T) {
+	res := probeNTP(ntpServer(t, 2, 0))
+	assert.NoError(t, res.Err)
+	assert.Equal(t, 1, res.Recv)
+	assert.False(t, res.Degraded)
+	assert.Less(t, res.Offset.Abs(), 50*time.Millisecond)
+
+	res = probeNTP(ntpServer(t, 2, 500*time.Millisecond))
+	assert.True(t, res.Degraded)
+	assert.InDelta(t, float64(500*time.Millisecond), float64(res.Offset), float64(50*time.Millisecond))
+
+	res = probeNTP(ntpServer(t, 2, 500*time.Millisecond) + "?max_offset=1s")
+	assert.False(t, res.Degraded)
+
+	res = probeNTP(ntpServer(t, 0, 0))
+	assert.True(t, res.Degraded)
+	assert.Contains(t, res.Detail, "RATE")
+
+	res = probeNTP("127.0.0.1?max_offset=soon")
+	assert.Error(t, res.Err)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"time"
+)
+
+// probeSMTP checks a mail server on port 25 (or the given port, e.g. 587) by
+// reading its greeting and, with the "ehlo" option, issuing EHLO:
+//
+//	smtp://mail.example.com
+//	smtp://mail.example.com:587?ehlo=monitor.example.com
+//
+// A 4xx/5xx reply means the server is reachable but refusing mail, so the
+// host is reported degraded rather than down.
+func probeSMTP(addr string) probeResult {
+	addr, opts := splitOptions(addr)
+	return smtpCheck(withDefaultPort(addr, "25"), opts.Has("ehlo"), opts.Get("ehlo"), false)
+}
+
+// probeSMTPS is probeSMTP for implicit TLS submission, port 465 by default.
+func probeSMTPS(addr string) probeResult {
+	addr, opts := splitOptions(addr)
+	return smtpCheck(withDefaultPort(addr, "465"), opts.Has("ehlo"), opts.Get("ehlo"), true)
+}
+
+// smtpCheck performs the SMTP conversation used by probeSMTP and probeSMTPS.
+//
+// Parameters:
+//   - addr: host:port of the mail server
+//   - ehlo: Whether to issue EHLO after the greeting
+//   - name: Name announced in EHLO, "mosaic" if empty
+//   - implicitTLS: Whether to wrap the connection in TLS before the greeting
+//
+// Returns:
+//   - probeResult: One attempt, degraded on a 4xx/5xx reply
+func smtpCheck(addr string, ehlo bool, name string, implicitTLS bool) probeResult {
+	start := time.Now()
+	conn, err := dialProbe("tcp", addr)
+	if err != nil {
+		return probeResult{Sent: 1}
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(probeTimeout))
+
+	if implicitTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tc.Handshake(); err != nil {
+			return probeResult{Sent: 1, Detail: err.Error()}
+		}
+		conn = tc
+	}
+
+	tp := textproto.NewConn(conn)
+	code, msg, err := tp.ReadResponse(2)
+	if err == nil && ehlo {
+		if name == "" {
+			name = "mosaic"
+		}
+		if _, err = tp.Cmd("EHLO %s", name); err == nil {
+			code, msg, err = tp.ReadResponse(2)
+		}
+	}
+	latency := time.Since(start)
+
+	var protoErr *textproto.Error
+	switch {
+	case errors.As(err, &protoErr):
+		return probeResult{Sent: 1, Recv: 1, Latency: latency, Degraded: true, Detail: fmt.Sprintf("%d %s", code, msg)}
+	case err != nil:
+		return probeResult{Sent: 1}
+	}
+	tp.Cmd("QUIT")
+	return probeResult{Sent: 1, Recv: 1, Latency: latency}
+}
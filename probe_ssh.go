@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// probeSSH connects to an SSH server and waits for its identification banner.
+// The reported latency covers the TCP handshake up to the banner, which is a
+// better signal than ICMP for jump hosts where ping is filtered.
+//
+// Parameters:
+//   - addr: host or host:port, port 22 is used when omitted
+//
+// Returns:
+//   - probeResult: One attempt, answered if a valid "SSH-" banner arrived
+func probeSSH(addr string) probeResult {
+	addr = withDefaultPort(addr, "22")
+	start := time.Now()
+	conn, err := dialProbe("tcp", addr)
+	if err != nil {
+		return probeResult{Sent: 1}
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(probeTimeout))
+
+	if _, err := readSSHBanner(bufio.NewReader(conn)); err != nil {
+		return probeResult{Sent: 1}
+	}
+	return probeResult{Sent: 1, Recv: 1, Latency: time.Since(start)}
+}
+
+// readSSHBanner reads lines until the SSH identification string. RFC 4253
+// allows servers to send other lines before it, so those are skipped.
+func readSSHBanner(r *bufio.Reader) (string, error) {
+	for i := 0; i < 10; i++ {
+		line, err := r.ReadString('\n')
+		if strings.HasPrefix(line, "SSH-") {
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no SSH banner received")
+}
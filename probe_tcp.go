@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PortStatus is the result of checking one TCP port of a multi-port host.
+type PortStatus struct {
+	Port      string `json:"port"`       // TCP port number
+	Open      bool   `json:"open"`       // Whether a connection was accepted
+	LatencyMs int    `json:"latency_ms"` // TCP handshake time in milliseconds
+}
+
+// splitPorts splits a host entry such as "db01:5432,6432,22" into the host and
+// its list of ports. ok is false unless every port is a valid number, so
+// plain hosts and bare IPv6 addresses are left alone.
+func splitPorts(addr string) (host string, ports []string, ok bool) {
+	first, rest, _ := strings.Cut(addr, ",")
+	host, port, err := net.SplitHostPort(first)
+	if err != nil || host == "" {
+		return "", nil, false
+	}
+	ports = []string{port}
+	if rest != "" {
+		ports = append(ports, strings.Split(rest, ",")...)
+	}
+	for i, p := range ports {
+		p = strings.TrimSpace(p)
+		if !isPort(p) {
+			return "", nil, false
+		}
+		ports[i] = p
+	}
+	return host, ports, true
+}
+
+// isPort reports whether s is a valid TCP port number.
+func isPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+// probeTCP connects to every port of a "host:port[,port...]" entry in
+// parallel. Each port counts as one sent packet, so partial outages show up
+// as packet loss, and the host is degraded while only some ports are open.
+//
+// Parameters:
+//   - addr: host:port, optionally followed by more comma-separated ports
+//
+// Returns:
+//   - probeResult: One attempt per port, with the average handshake time of
+//     the open ones and a per-port breakdown in Ports
+func probeTCP(addr string) probeResult {
+	host, ports, ok := splitPorts(addr)
+	if !ok {
+		return probeResult{Err: fmt.Errorf("%s: expected host:port[,port...]", addr)}
+	}
+	statuses := make([]PortStatus, len(ports))
+	latencies := make([]time.Duration, len(ports))
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i int, port string) {
+			defer wg.Done()
+			statuses[i].Port = port
+			start := time.Now()
+			conn, err := dialProbe("tcp", net.JoinHostPort(host, port))
+			if err != nil {
+				return
+			}
+			latencies[i] = time.Since(start)
+			conn.Close()
+			statuses[i].Open = true
+			statuses[i].LatencyMs = int(latencies[i].Milliseconds())
+		}(i, port)
+	}
+	wg.Wait()
+
+	res := probeResult{Sent: len(ports), Ports: statuses}
+	var total time.Duration
+	var closed []string
+	for i, s := range statuses {
+		if s.Open {
+			res.Recv++
+			total += latencies[i]
+		} else {
+			closed = append(closed, s.Port)
+		}
+	}
+	if res.Recv > 0 {
+		res.Latency = total / time.Duration(res.Recv)
+	}
+	if len(closed) > 0 {
+		res.Degraded = true
+		res.Detail = "closed: " + strings.Join(closed, ", ")
+	}
+	return res
+}
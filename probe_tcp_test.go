@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitPorts(t *testing.T) {
+	host, ports, ok := splitPorts("db01:5432, 6432,22")
+	assert.True(t, ok)
+	assert.Equal(t, "db01", host)
+	assert.Equal(t, []string{"5432", "6432", "22"}, ports)
+
+	host, ports, ok = splitPorts("[fd00::1]:22,80")
+	assert.True(t, ok)
+	assert.Equal(t, "fd00::1", host)
+	assert.Equal(t, []string{"22", "80"}, ports)
+
+	for _, addr := range []string{"db01", "fd00::1", "db01:ssh", "db01:22,x", "db01:70000", ":22"} {
+		_, _, ok = splitPorts(addr)
+		assert.False(t, ok, addr)
+	}
+	scheme, _ := splitScheme("db01:5432,22")
+	assert.Equal(t, "tcp", scheme)
+	scheme, _ = splitScheme("8.8.8.8")
+	assert.Equal(t, "", scheme)
+}
+
+func TestReadHostsPortList(t *testing.T) {
+	hosts, _, err := readHosts("", "db01:5432,6432,22,8.8.8.8,web01:443,80")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"db01:5432,6432,22", "8.8.8.8", "web01:443,80"}, hosts)
+}
+
+func TestProbeTCP(t *testing.T) {
+	open := serveOnce(t, func(net.Conn) {})
+	_, openPort, _ := net.SplitHostPort(open)
+	// Grab a free port and release it so connections are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closedPort := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	res := probeTCP("127.0.0.1:" + openPort)
+	assert.Equal(t, 1, res.Sent)
+	assert.Equal(t, 1, res.Recv)
+	assert.False(t, res.Degraded)
+
+	host := "127.0.0.1:" + openPort + "," + closedPort
+	defer func() {
+		hostStatsMu.Lock()
+		delete(hostStats, host)
+		hostStatsMu.Unlock()
+	}()
+	status := pingHost(host)
+	assert.True(t, status.Alive)
+	assert.True(t, status.Degraded)
+	assert.Equal(t, 50.0, status.PacketLoss)
+	assert.Equal(t, "closed: "+closedPort, status.Detail)
+	assert.Len(t, status.Ports, 2)
+	assert.True(t, status.Ports[0].Open)
+	assert.False(t, status.Ports[1].Open)
+
+	assert.Error(t, probeTCP("db01").Err)
+}
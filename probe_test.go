@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveOnce starts a TCP listener on localhost that runs handle for every
+// accepted connection and returns its address.
+func serveOnce(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSplitScheme(t *testing.T) {
+	scheme, addr := splitScheme("SSH://jump01:2222")
+	assert.Equal(t, "ssh", scheme)
+	assert.Equal(t, "jump01:2222", addr)
+
+	scheme, addr = splitScheme("8.8.8.8")
+	assert.Equal(t, "", scheme)
+	assert.Equal(t, "8.8.8.8", addr)
+}
+
+func TestValidateHost(t *testing.T) {
+	assert.NoError(t, validateHost("8.8.8.8"))
+	assert.NoError(t, validateHost("ssh://jump01"))
+	assert.Error(t, validateHost("gopher://jump01"))
+	assert.Error(t, validateHost("ssh://"))
+	assert.Error(t, validateHost("10.0.0.1\n10.0.0.2"))
+	assert.Error(t, validateHost("ssh://jump01\r"))
+	assert.Error(t, validateHost("10.0.0.1 core-sw1"), "would read back as /etc/hosts format")
+	assert.Error(t, validateHost(" 8.8.8.8"))
+	assert.NoError(t, validateHost("Core router=10.0.0.1 | 10.0.0.2"))
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	assert.Equal(t, "jump01:22", withDefaultPort("jump01", "22"))
+	assert.Equal(t, "jump01:2222", withDefaultPort("jump01:2222", "22"))
+	assert.Equal(t, "[::1]:22", withDefaultPort("::1", "22"))
+	assert.Equal(t, "[::1]:22", withDefaultPort("[::1]", "22"))
+}
+
+func TestProbeSSH(t *testing.T) {
+	addr := serveOnce(t, func(c net.Conn) {
+		c.Write([]byte("Welcome\r\nSSH-2.0-OpenSSH_9.6\r\n"))
+	})
+	res := probeSSH(addr)
+	assert.Equal(t, 1, res.Sent)
+	assert.Equal(t, 1, res.Recv)
+
+	// A listener that is not an SSH server counts as a lost attempt
+	addr = serveOnce(t, func(c net.Conn) {
+		c.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	})
+	res = probeSSH(addr)
+	assert.Equal(t, 1, res.Sent)
+	assert.Equal(t, 0, res.Recv)
+}
+
+func TestPingHostSSH(t *testing.T) {
+	addr := serveOnce(t, func(c net.Conn) {
+		c.Write([]byte("SSH-2.0-test\r\n"))
+	})
+	host := "ssh://" + addr
+	defer func() {
+		hostStatsMu.Lock()
+		delete(hostStats, host)
+		hostStatsMu.Unlock()
+	}()
+
+	status := pingHost(host)
+	assert.True(t, status.Alive)
+	assert.True(t, status.LatencyMs >= 0)
+	assert.Equal(t, 0.0, status.PacketLoss)
+
+	status = pingHost("gopher://" + addr)
+	assert.False(t, status.Alive)
+	assert.Equal(t, 100.0, status.PacketLoss)
+}
+
+// smtpServer replies with greeting and answers EHLO with ehloReply.
+func smtpServer(t *testing.T, greeting, ehloReply string) string {
+	return serveOnce(t, func(c net.Conn) {
+		tp := textproto.NewConn(c)
+		tp.PrintfLine("%s", greeting)
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				tp.PrintfLine("%s", ehloReply)
+			case line == "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			}
+		}
+	})
+}
+
+func TestProbeSMTP(t *testing.T) {
+	res := probeSMTP(smtpServer(t, "220 mail ESMTP ready", "250 mail"))
+	assert.Equal(t, 1, res.Recv)
+	assert.False(t, res.Degraded)
+
+	res = probeSMTP(smtpServer(t, "421 mail too busy", "250 mail"))
+	assert.Equal(t, 1, res.Recv)
+	assert.True(t, res.Degraded)
+	assert.Equal(t, "421 mail too busy", res.Detail)
+
+	// EHLO is only checked when requested
+	addr := smtpServer(t, "220 mail ESMTP ready", "550 go away")
+	assert.False(t, probeSMTP(addr).Degraded)
+	res = probeSMTP(addr + "?ehlo=monitor.example.com")
+	assert.True(t, res.Degraded)
+	assert.Equal(t, "550 go away", res.Detail)
+
+	// A non-SMTP reply is not a mail server at all
+	res = probeSMTP(serveOnce(t, func(c net.Conn) { c.Write([]byte("SSH-2.0-x\r\n")) }))
+	assert.Equal(t, 1, res.Sent)
+	assert.Equal(t, 0, res.Recv)
+}
+
+func TestPingHostDegraded(t *testing.T) {
+	host := "smtp://" + smtpServer(t, "554 no service", "250 ok")
+	defer func() {
+		hostStatsMu.Lock()
+		delete(hostStats, host)
+		hostStatsMu.Unlock()
+	}()
+
+	status := pingHost(host)
+	assert.True(t, status.Alive)
+	assert.True(t, status.Degraded)
+	assert.Equal(t, "554 no service", status.Detail)
+}
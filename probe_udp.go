@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// quicGreaseVersion is a reserved QUIC version (RFC 9000 §15) no server
+// implements, so every QUIC endpoint must answer with Version Negotiation.
+const quicGreaseVersion = 0x1a2a3a4a
+
+// probeUDP sends one datagram and waits for a reply. Options:
+//
+//	udp://dns01:53?payload_hex=...&expect_hex=...
+//	udp://game01:27015?payload=status&expect=online
+//	udp://doq.example.com:853?quic=1
+//
+// Without expect any reply counts. A reply that does not contain the expected
+// bytes is degraded. With quic=1 a QUIC Initial carrying an unsupported
+// version is sent and the server's Version Negotiation packet is the reply,
+// which proves a QUIC stack is listening without needing credentials or ALPN.
+//
+// Parameters:
+//   - addr: host:port with optional query options, port 443 in QUIC mode
+//
+// Returns:
+//   - probeResult: One attempt, answered if a datagram came back in time
+func probeUDP(addr string) probeResult {
+	addr, opts := splitOptions(addr)
+	quic := opts.Get("quic") == "1"
+	if quic {
+		addr = withDefaultPort(addr, "443")
+	}
+	payload, err := udpOption(opts.Get("payload"), opts.Get("payload_hex"))
+	if err != nil {
+		return probeResult{Err: fmt.Errorf("invalid payload: %w", err)}
+	}
+	expect, err := udpOption(opts.Get("expect"), opts.Get("expect_hex"))
+	if err != nil {
+		return probeResult{Err: fmt.Errorf("invalid expect: %w", err)}
+	}
+	var scid []byte
+	if quic {
+		payload, scid = quicVersionProbe()
+	}
+
+	start := time.Now()
+	conn, err := dialProbe("udp", addr)
+	if err != nil {
+		return probeResult{Sent: 1, Detail: err.Error()}
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(probeTimeout))
+	if _, err := conn.Write(payload); err != nil {
+		return probeResult{Sent: 1, Detail: err.Error()}
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		// A refused port surfaces here as an ICMP port unreachable
+		return probeResult{Sent: 1, Detail: err.Error()}
+	}
+	res := probeResult{Sent: 1, Recv: 1, Latency: time.Since(start)}
+	reply := buf[:n]
+	switch {
+	case quic:
+		versions, err := parseVersionNegotiation(reply, scid)
+		if err != nil {
+			res.Degraded = true
+			res.Detail = err.Error()
+		} else {
+			res.Detail = "QUIC versions: " + strings.Join(versions, ", ")
+		}
+	case len(expect) > 0 && !bytes.Contains(reply, expect):
+		res.Degraded = true
+		res.Detail = fmt.Sprintf("reply does not contain %q", expect)
+	}
+	return res
+}
+
+// udpOption returns the text option, or the decoded hex option if given.
+func udpOption(text, hexText string) ([]byte, error) {
+	if hexText != "" {
+		return hex.DecodeString(hexText)
+	}
+	return []byte(text), nil
+}
+
+// quicVersionProbe builds a long-header QUIC Initial packet with a reserved
+// version, padded to the 1200 bytes servers require before responding.
+// It returns the packet and the source connection ID the reply must echo.
+func quicVersionProbe() (packet, scid []byte) {
+	dcid := make([]byte, 8)
+	scid = make([]byte, 8)
+	rand.Read(dcid)
+	rand.Read(scid)
+	packet = make([]byte, 0, 1200)
+	packet = append(packet, 0xc0)
+	packet = binary.BigEndian.AppendUint32(packet, quicGreaseVersion)
+	packet = append(packet, byte(len(dcid)))
+	packet = append(packet, dcid...)
+	packet = append(packet, byte(len(scid)))
+	packet = append(packet, scid...)
+	return packet[:1200], scid
+}
+
+// parseVersionNegotiation validates a QUIC Version Negotiation packet
+// (RFC 9000 §17.2.1) answering our probe and lists the offered versions.
+func parseVersionNegotiation(p, scid []byte) ([]string, error) {
+	if len(p) < 7 || p[0]&0x80 == 0 || binary.BigEndian.Uint32(p[1:5]) != 0 {
+		return nil, fmt.Errorf("reply is not a QUIC version negotiation packet")
+	}
+	dcidLen := int(p[5])
+	if len(p) < 6+dcidLen+1 || !bytes.Equal(p[6:6+dcidLen], scid) {
+		return nil, fmt.Errorf("QUIC version negotiation does not match the probe")
+	}
+	rest := p[6+dcidLen:]
+	scidLen := int(rest[0])
+	if len(rest) < 1+scidLen {
+		return nil, fmt.Errorf("truncated QUIC version negotiation packet")
+	}
+	rest = rest[1+scidLen:]
+	var versions []string
+	for ; len(rest) >= 4; rest = rest[4:] {
+		versions = append(versions, quicVersionName(binary.BigEndian.Uint32(rest)))
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("QUIC version negotiation lists no versions")
+	}
+	return versions, nil
+}
+
+// quicVersionName names well-known QUIC versions.
+func quicVersionName(v uint32) string {
+	switch {
+	case v == 0x00000001:
+		return "v1"
+	case v == 0x6b3343cf:
+		return "v2"
+	case v&0xffffff00 == 0xff000000:
+		return fmt.Sprintf("draft-%d", v&0xff)
+	case v&0x0f0f0f0f == 0x0a0a0a0a:
+		return "grease"
+	}
+	return fmt.Sprintf("0x%08x", v)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveUDP answers every datagram with reply(packet) until the test ends.
+func serveUDP(t *testing.T, reply func([]byte) []byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(reply(buf[:n]), from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestProbeUDP(t *testing.T) {
+	addr := serveUDP(t, func(p []byte) []byte {
+		if bytes.Equal(p, []byte{0xca, 0xfe}) {
+			return []byte("online")
+		}
+		return []byte("who?")
+	})
+
+	res := probeUDP(addr + "?payload_hex=cafe&expect=online")
+	assert.Equal(t, 1, res.Recv)
+	assert.False(t, res.Degraded)
+
+	res = probeUDP(addr + "?payload=status&expect=online")
+	assert.Equal(t, 1, res.Recv)
+	assert.True(t, res.Degraded)
+
+	assert.Error(t, probeUDP(addr+"?payload_hex=zz").Err)
+}
+
+func TestProbeUDPQUIC(t *testing.T) {
+	addr := serveUDP(t, func(p []byte) []byte {
+		if len(p) < 1200 || binary.BigEndian.Uint32(p[1:5]) != quicGreaseVersion {
+			return nil
+		}
+		dcid := p[6 : 6+p[5]]
+		scid := p[7+p[5] : 7+p[5]+p[6+p[5]]]
+		// Version Negotiation swaps the connection IDs
+		vn := []byte{0x80, 0, 0, 0, 0, byte(len(scid))}
+		vn = append(vn, scid...)
+		vn = append(vn, byte(len(dcid)))
+		vn = append(vn, dcid...)
+		vn = binary.BigEndian.AppendUint32(vn, 1)
+		vn = binary.BigEndian.AppendUint32(vn, 0x6b3343cf)
+		return vn
+	})
+
+	res := probeUDP(addr + "?quic=1")
+	assert.Equal(t, 1, res.Recv)
+	assert.False(t, res.Degraded)
+	assert.Equal(t, "QUIC versions: v1, v2", res.Detail)
+
+	_, err := parseVersionNegotiation([]byte("not quic"), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// The embedded build (go build -tags embedded) is meant for OpenWrt routers
+// and small ARM boards. It only probes hosts and serves the live mosaic: no
+// history (SLA, learned thresholds, event log, correlated incidents), no
+// alerting (notifications, self alerts, neighbor watching) and no sessions
+// or endpoints that change state. Host files, host entries and per-host
+// overrides are the same as in the full build.
+
+// embeddedIgnoredFlags are the flags of features left out of the embedded
+// build. They are still accepted, so the same command line works with both
+// builds, but have no effect.
+var embeddedIgnoredFlags = map[string]bool{
+	"weights":         true,
+	"mac":             true,
+	"wol-broadcast":   true,
+	"self-alerts":     true,
+	"self-tile":       true,
+	"watch-neighbors": true,
+	"notify":          true,
+	"persist-hosts":   true,
+	"demo":            true,
+	"report-email":    true,
+}
+
+// applyEmbeddedProfile turns off the features the embedded build leaves out
+// and warns about flags that were set for them. Flags with a value to parse
+// are skipped by main instead. It does nothing in the full build.
+//
+// Parameters:
+//   - fs: The parsed command line flags
+func applyEmbeddedProfile(fs *flag.FlagSet) {
+	if !embeddedBuild {
+		return
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if embeddedIgnoredFlags[f.Name] {
+			log.Printf("-%s has no effect in the embedded build", f.Name)
+		}
+	})
+	selfTile = false
+	watchNeighbors = false
+	persistHosts = false
+	demoEnabled = false
+	reportEmail = ""
+	events = newEventLog(0)
+}
+
+// registerRoutes registers the HTTP handlers on mux. The embedded build only
+// serves the dashboard, the live WebSocket and read-only state.
+func registerRoutes(mux *http.ServeMux) {
+	mux.Handle("/ws", websocket.Server{Handler: wsHandler, Handshake: wsHandshake})
+	mux.HandleFunc("/api/scheduler", schedulerHandler)
+	mux.HandleFunc("/api/ping-mode", pingModeHandler)
+	mux.HandleFunc("/api/capabilities", capabilitiesHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/", dashboardHandler)
+	if embeddedBuild {
+		return
+	}
+	mux.HandleFunc("/api/sla", slaHandler)
+	mux.HandleFunc("/api/thresholds", csrfProtect(thresholdsHandler))
+	mux.HandleFunc("/api/events", eventsHandler)
+	mux.HandleFunc("/api/history", historyHandler)
+	mux.HandleFunc("/api/export.csv", exportCSVHandler)
+	mux.HandleFunc("/api/incidents", incidentsHandler)
+	mux.HandleFunc("/api/report", reportHandler)
+	mux.HandleFunc("/api/grafana", grafanaHandler)
+	mux.HandleFunc("/api/grafana/", grafanaHandler)
+	mux.HandleFunc("/api/backup", backupHandler)
+	mux.HandleFunc("/api/hosts", csrfProtect(hostsHandler))
+	mux.HandleFunc("/api/maintenance", csrfProtect(maintenanceHandler))
+	mux.HandleFunc("/api/config/reload", csrfProtect(configReloadHandler))
+	mux.HandleFunc("/api/config/export", configExportHandler)
+	mux.HandleFunc("/api/config", csrfProtect(configHandler))
+	mux.HandleFunc("/api/wol", csrfProtect(wolHandler))
+	mux.HandleFunc("/api/demo", demoHandler)
+	mux.HandleFunc("/api/notifications/dead-letters", deadLettersHandler)
+}
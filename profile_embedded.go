@@ -0,0 +1,6 @@
+//go:build embedded
+
+package main
+
+// embeddedBuild is true in binaries built with -tags embedded.
+const embeddedBuild = true
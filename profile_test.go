@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+	code := func(path string) int {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, code("/api/capabilities"))
+	assert.Equal(t, http.StatusOK, code("/api/scheduler"))
+
+	// History and state-changing endpoints are left out of the embedded
+	// build; "/" catches them and serves the dashboard instead
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/events", nil))
+	body, _ := io.ReadAll(w.Body)
+	if embeddedBuild {
+		assert.Contains(t, string(body), "<html")
+		assert.Empty(t, w.Result().Cookies(), "no sessions in the embedded build")
+	} else {
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestApplyEmbeddedProfile(t *testing.T) {
+	defer func(tile, watch, demo bool, l *eventLog) {
+		selfTile, watchNeighbors, demoEnabled, events = tile, watch, demo, l
+	}(selfTile, watchNeighbors, demoEnabled, events)
+	fs := flag.NewFlagSet("mosaic", flag.ContinueOnError)
+	fs.BoolVar(&selfTile, "self-tile", false, "")
+	fs.BoolVar(&watchNeighbors, "watch-neighbors", false, "")
+	assert.NoError(t, fs.Parse([]string{"-self-tile", "-watch-neighbors"}))
+
+	applyEmbeddedProfile(fs)
+	assert.Equal(t, !embeddedBuild, selfTile)
+	assert.Equal(t, !embeddedBuild, watchNeighbors)
+	assert.Equal(t, !embeddedBuild, capabilities().Features["sla"])
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// packetLimiter caps the rate of outbound probe packets across all hosts,
+// set with -max-pps, so monitoring thousands of targets neither trips IDS
+// systems nor saturates a small uplink. Packets are paced evenly: every
+// packet reserves the next free slot of 1/pps seconds.
+type packetLimiter struct {
+	mu    sync.Mutex
+	pps   int       // Packets per second, 0 for no limit
+	next  time.Time // Start of the next free slot
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+var packetLimit = newPacketLimiter(0)
+
+// newPacketLimiter creates a limiter allowing pps packets per second, or any
+// rate if pps is 0.
+func newPacketLimiter(pps int) *packetLimiter {
+	return &packetLimiter{pps: pps, now: time.Now, sleep: time.Sleep}
+}
+
+// rate returns the packets per second allowed, 0 for no limit.
+func (l *packetLimiter) rate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pps
+}
+
+// reserve books slots for n packets and returns how long to wait before
+// sending them.
+func (l *packetLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pps <= 0 || n <= 0 {
+		return 0
+	}
+	now := l.now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.pps))
+	return wait
+}
+
+// wait blocks until n packets may be sent.
+func (l *packetLimiter) wait(n int) {
+	if d := l.reserve(n); d > 0 {
+		l.sleep(d)
+	}
+}
+
+// probePackets returns how many packets probing addr with the given scheme
+// sends: the echo requests of an ICMP host, one connection or datagram for
+// the other probe types.
+func probePackets(scheme, addr string) int {
+	if scheme != "" && scheme != "icmp" {
+		return 1
+	}
+	_, opts := splitOptions(addr)
+	if n, err := strconv.Atoi(opts.Get("count")); err == nil && n > 0 {
+		return n
+	}
+	return probeCount
+}
+
+// paced wraps a prober so it waits for the packet limiter before probing.
+func paced(scheme string, probe func(string) probeResult) func(string) probeResult {
+	return func(addr string) probeResult {
+		packetLimit.wait(probePackets(scheme, addr))
+		return probe(addr)
+	}
+}
+
+// smearOffset spreads the starts of a cycle's probes evenly instead of
+// firing them in one burst. The i-th of n probes starts this long after the
+// cycle. Probes are spread over the part of the interval that still lets the
+// last one time out before the next cycle, so smearing alone never causes
+// an overrun.
+//
+// Parameters:
+//   - i: Index of the probe in the cycle
+//   - n: Number of probes in the cycle
+//   - interval: Time between cycle starts
+//   - timeout: Longest a probe may take
+//
+// Returns:
+//   - time.Duration: Delay before starting the probe
+func smearOffset(i, n int, interval, timeout time.Duration) time.Duration {
+	window := interval - timeout
+	if n <= 1 || window <= 0 {
+		return 0
+	}
+	return window * time.Duration(i) / time.Duration(n)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketLimiterReserve(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newPacketLimiter(100)
+	l.now = func() time.Time { return now }
+
+	assert.Equal(t, time.Duration(0), l.reserve(1))
+	assert.Equal(t, 10*time.Millisecond, l.reserve(3))
+	assert.Equal(t, 40*time.Millisecond, l.reserve(1))
+
+	// Unused slots are not saved up for a burst
+	now = now.Add(time.Second)
+	assert.Equal(t, time.Duration(0), l.reserve(1))
+	assert.Equal(t, 10*time.Millisecond, l.reserve(1))
+}
+
+func TestPacketLimiterUnlimited(t *testing.T) {
+	l := newPacketLimiter(0)
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, time.Duration(0), l.reserve(5))
+	}
+	assert.Equal(t, 0, l.rate())
+}
+
+func TestPacedProbes(t *testing.T) {
+	defer func(l *packetLimiter, c int) { packetLimit, probeCount = l, c }(packetLimit, probeCount)
+	probeCount = 2
+	packetLimit = newPacketLimiter(10)
+	var mu sync.Mutex
+	var waits []time.Duration
+	packetLimit.sleep = func(d time.Duration) {
+		mu.Lock()
+		waits = append(waits, d)
+		mu.Unlock()
+	}
+	probe := paced("", func(string) probeResult { return probeResult{Sent: 1, Recv: 1} })
+
+	probe("10.0.0.1")
+	probe("10.0.0.2?count=5")
+	probe("10.0.0.3")
+
+	// Two packets of the first host, then five of the second, at 100ms each
+	if assert.Len(t, waits, 2) {
+		assert.InDelta(t, float64(200*time.Millisecond), float64(waits[0]), float64(20*time.Millisecond))
+		assert.InDelta(t, float64(700*time.Millisecond), float64(waits[1]), float64(20*time.Millisecond))
+	}
+	assert.Equal(t, 1, probePackets("tcp", "db01:5432"))
+}
+
+func TestSmearOffset(t *testing.T) {
+	assert.Equal(t, time.Duration(0), smearOffset(0, 4, 2*time.Second, time.Second))
+	assert.Equal(t, 250*time.Millisecond, smearOffset(1, 4, 2*time.Second, time.Second))
+	assert.Equal(t, 750*time.Millisecond, smearOffset(3, 4, 2*time.Second, time.Second))
+	// No room to spread without risking an overrun
+	assert.Equal(t, time.Duration(0), smearOffset(3, 4, time.Second, 2*time.Second))
+	assert.Equal(t, time.Duration(0), smearOffset(0, 1, 2*time.Second, time.Second))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// loopWake cuts the wait of pingLoop short, so a configuration reloaded from
+// disk reaches the dashboard right away instead of after the next interval.
+var loopWake = make(chan struct{}, 1)
+
+// wakeLoop starts the next cycle of pingLoop now. It never blocks.
+func wakeLoop() {
+	select {
+	case loopWake <- struct{}{}:
+	default:
+	}
+}
+
+// waitCycle waits for d, until wakeLoop is called or until ctx is done.
+func waitCycle(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-loopWake:
+	case <-ctx.Done():
+	}
+}
+
+// reloadFromDisk applies the configuration on disk, as a reload with an
+// empty body would, records a "config_applied" event if it changed anything
+// and wakes the ping loop, so probes of new hosts start and removed hosts
+// leave the dashboard at once.
+//
+// Parameters:
+//   - now: When the reload was triggered
+//   - reason: What triggered it, prefixed to the event message
+//
+// Returns:
+//   - ConfigDiff: What changed, empty if nothing did
+//   - error: If the configuration cannot be read or is invalid
+func reloadFromDisk(now time.Time, reason string) (ConfigDiff, error) {
+	next, err := diskConfig()
+	if err != nil {
+		return ConfigDiff{}, err
+	}
+	if err := next.validate(); err != nil {
+		return ConfigDiff{}, err
+	}
+	next = next.withSettings(currentSettings())
+	diff := diffConfig(currentConfig(), next)
+	if diff.empty() {
+		return diff, nil
+	}
+	applyConfig(next, diff)
+	events.add(Event{Time: now, Type: "config_applied", Message: reason + ": " + strings.ReplaceAll(strings.TrimSpace(diff.String()), "\n", "; ")})
+	wakeLoop()
+	return diff, nil
+}
+
+// reloadOnHangup reloads the configuration from disk whenever a signal
+// arrives on hangup, until ctx is done. Like other daemons, mosaic reloads
+// on SIGHUP; failures are logged and the running configuration kept.
+func reloadOnHangup(ctx context.Context, hangup <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+		}
+		diff, err := reloadFromDisk(time.Now(), "reloaded on SIGHUP")
+		switch {
+		case err != nil:
+			log.Printf("Keeping the running configuration, reload on SIGHUP failed: %v", err)
+		case diff.empty():
+			log.Println("Reloaded on SIGHUP: no changes")
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadFromDisk(t *testing.T) {
+	withHosts(t, "a", "b")
+	path := withHostsFile(t, "a\nc\n")
+	old := events
+	defer func() { events = old }()
+	events = newEventLog(10)
+
+	diff, err := reloadFromDisk(time.Now(), "hosts file changed")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, diff.HostsAdded)
+	assert.Equal(t, []string{"b"}, diff.HostsRemoved)
+	assert.Equal(t, []string{"a", "c"}, currentHosts())
+	if recent := events.recent(); assert.Len(t, recent, 1) {
+		assert.Equal(t, "config_applied", recent[0].Type)
+		assert.Equal(t, "hosts file changed: + c; - b", recent[0].Message)
+	}
+
+	diff, err = reloadFromDisk(time.Now(), "hosts file changed")
+	assert.NoError(t, err)
+	assert.True(t, diff.empty())
+	assert.Len(t, events.recent(), 1, "an unchanged file records nothing")
+
+	// A file emptied while being rewritten keeps the running hosts
+	os.WriteFile(path, nil, 0o644)
+	_, err = reloadFromDisk(time.Now(), "hosts file changed")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"a", "c"}, currentHosts())
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	withHosts(t, "a")
+	withHostsFile(t, "b\n")
+	// Drop a wake-up left over from other tests
+	select {
+	case <-loopWake:
+	default:
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	hangup := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reloadOnHangup(ctx, hangup)
+	}()
+	hangup <- syscall.SIGHUP
+	assert.Eventually(t, func() bool { return len(currentHosts()) == 1 && currentHosts()[0] == "b" }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	// The reload woke the ping loop
+	start := time.Now()
+	waitCycle(context.Background(), time.Minute)
+	assert.Less(t, time.Since(start), time.Second)
+}
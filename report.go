@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// reportEmail is the smtp:// URL scheduled reports are mailed through,
+	// in the form -notify takes after smtp=, set with -report-email. Empty
+	// sends none.
+	reportEmail string
+	// reportSchedule is when reports are mailed, set with -report-schedule:
+	// "daily" or "weekly", on Mondays, optionally with "@HH:MM" local time.
+	reportSchedule = "weekly@08:00"
+)
+
+const (
+	// reportWorst is how many hosts a report lists by downtime.
+	reportWorst = 10
+	// reportLongest is how many outages a report lists by duration.
+	reportLongest = 10
+	// reportRetry is the wait between attempts to mail a report.
+	reportRetry = time.Minute
+)
+
+// reportPlan is a parsed -report-schedule.
+type reportPlan struct {
+	weekly       bool
+	hour, minute int
+}
+
+// parseReportSchedule parses a -report-schedule value such as "weekly",
+// "daily@07:30" or "weekly@09:00". The time defaults to 08:00.
+func parseReportSchedule(s string) (reportPlan, error) {
+	every, at, _ := strings.Cut(s, "@")
+	p := reportPlan{hour: 8}
+	switch every {
+	case "daily":
+	case "weekly":
+		p.weekly = true
+	default:
+		return p, fmt.Errorf("invalid report schedule %q: expected daily or weekly", s)
+	}
+	if at == "" {
+		return p, nil
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return p, fmt.Errorf("invalid report schedule %q: expected a time as HH:MM after @", s)
+	}
+	p.hour, p.minute = t.Hour(), t.Minute()
+	return p, nil
+}
+
+// period returns how far back a report of p reaches.
+func (p reportPlan) period() time.Duration {
+	if p.weekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// next returns when the first report of p after now is due, in now's time
+// zone: on the next day at its time, or on the next Monday if weekly.
+func (p reportPlan) next(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), p.hour, p.minute, 0, 0, now.Location())
+	days := 1
+	if p.weekly {
+		t = t.AddDate(0, 0, (int(time.Monday)-int(t.Weekday())+7)%7)
+		days = 7
+	}
+	for !t.After(now) {
+		t = t.AddDate(0, 0, days)
+	}
+	return t
+}
+
+// Report is the summary of a period mailed by -report-email and served by
+// /api/report.
+type Report struct {
+	Title         string
+	Since, Until  time.Time
+	Hosts         int        // Hosts with results in the period
+	UptimePercent float64    // Average uptime of the hosts
+	DownMinutes   float64    // Sum of the downtime of the hosts
+	Worst         []SLAEntry // Hosts that were down, by impact, at most reportWorst
+	Incidents     int        // Outages in the period
+	Longest       []Incident // The longest outages, at most reportLongest
+}
+
+// buildReport sums up the results in store from since to until: the
+// uptime of the hosts, those down the longest, weighted by -weights, and
+// the longest outages.
+func buildReport(store historyStore, title string, since, until time.Time) (Report, error) {
+	all := func(string) bool { return true }
+	summary, err := sla.historyReport(store, since, until, nil, all)
+	if err != nil {
+		return Report{}, err
+	}
+	incidents, err := findIncidents(store, "", since, until, 100, all)
+	if err != nil {
+		return Report{}, err
+	}
+	rep := Report{Title: title, Since: since, Until: until, Hosts: len(summary.Hosts), UptimePercent: 100, Incidents: len(incidents)}
+	var uptime float64
+	for _, e := range summary.Hosts {
+		uptime += e.UptimePercent
+		rep.DownMinutes += e.DownMinutes
+		if e.DownMinutes > 0 && len(rep.Worst) < reportWorst {
+			rep.Worst = append(rep.Worst, e)
+		}
+	}
+	if len(summary.Hosts) > 0 {
+		rep.UptimePercent = uptime / float64(len(summary.Hosts))
+	}
+	slices.SortStableFunc(incidents, func(a, b Incident) int { return cmp.Compare(b.DurationSeconds, a.DurationSeconds) })
+	rep.Longest = incidents[:min(len(incidents), reportLongest)]
+	return rep, nil
+}
+
+// reportTemplate renders a Report as an HTML mail. Mail clients ignore
+// style sheets, so it is styled inline.
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct":  func(f float64) string { return strconv.FormatFloat(f, 'f', 2, 64) + " %" },
+	"mins": func(f float64) string { return (time.Duration(f * float64(time.Minute))).Round(time.Second).String() },
+	"secs": func(f float64) string { return (time.Duration(f * float64(time.Second))).Round(time.Second).String() },
+	"at":   func(t time.Time) string { return t.Format("Mon 2006-01-02 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif; color: #222">
+<h2>{{.Title}}</h2>
+<p>{{at .Since}} to {{at .Until}}</p>
+<table cellpadding="6" style="border-collapse: collapse">
+<tr><td>Hosts</td><td><b>{{.Hosts}}</b></td></tr>
+<tr><td>Average uptime</td><td><b>{{pct .UptimePercent}}</b></td></tr>
+<tr><td>Downtime</td><td><b>{{mins .DownMinutes}}</b></td></tr>
+<tr><td>Outages</td><td><b>{{.Incidents}}</b></td></tr>
+</table>
+<h3>Worst hosts</h3>
+{{if .Worst}}<table cellpadding="6" border="1" style="border-collapse: collapse">
+<tr><th align="left">Host</th><th>Uptime</th><th>Downtime</th><th>Weight</th></tr>
+{{range .Worst}}<tr><td>{{.Host}}</td><td align="right">{{pct .UptimePercent}}</td><td align="right">{{mins .DownMinutes}}</td><td align="right">{{.Weight}}</td></tr>
+{{end}}</table>{{else}}<p>No host was down.</p>{{end}}
+<h3>Longest outages</h3>
+{{if .Longest}}<table cellpadding="6" border="1" style="border-collapse: collapse">
+<tr><th align="left">Host</th><th>Start</th><th>Duration</th></tr>
+{{range .Longest}}<tr><td>{{.Host}}</td><td>{{at .Start}}</td><td align="right">{{secs .DurationSeconds}}{{if .Ongoing}} (ongoing){{end}}</td></tr>
+{{end}}</table>{{else}}<p>No outages.</p>{{end}}
+</body></html>
+`))
+
+// renderReport builds the report of the period of p up to now and renders
+// it as HTML.
+//
+// Returns:
+//   - string: The title of the report, for the subject of its mail
+//   - []byte: The HTML report
+//   - error: If the history cannot be read
+func renderReport(p reportPlan, now time.Time) (string, []byte, error) {
+	title := "mosaic daily report"
+	if p.weekly {
+		title = "mosaic weekly report"
+	}
+	title += ", " + now.Format("2006-01-02")
+	rep, err := buildReport(history.store, title, now.Add(-p.period()), now)
+	if err != nil {
+		return "", nil, err
+	}
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, rep); err != nil {
+		return "", nil, err
+	}
+	return title, buf.Bytes(), nil
+}
+
+// mailReports mails a report through n whenever p is due, until ctx is
+// done. A report that cannot be mailed is tried notifyAttempts times,
+// reportRetry apart, and then logged and skipped.
+func mailReports(ctx context.Context, p reportPlan, n *smtpNotifier) {
+	for {
+		timer := time.NewTimer(time.Until(p.next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		title, body, err := renderReport(p, time.Now())
+		if err != nil {
+			log.Printf("Report: %v", err)
+			continue
+		}
+		headers := fmt.Sprintf("Subject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\n", title)
+		for attempt := 1; attempt <= notifyAttempts; attempt++ {
+			sendCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+			err = n.send(sendCtx, headers, string(body))
+			cancel()
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+			log.Printf("Report via smtp failed (attempt %d/%d): %v", attempt, notifyAttempts, err)
+			if attempt < notifyAttempts {
+				select {
+				case <-ctx.Done():
+				case <-time.After(reportRetry):
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Report %q not sent: %v", title, err)
+		}
+	}
+}
+
+// reportHandler serves the report of the last day, or of the last week with
+// period=week, as the HTML mailed by -report-email, to preview it.
+//
+//	GET /api/report?period=week
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "no history is kept", http.StatusNotFound)
+		return
+	}
+	p := reportPlan{}
+	switch r.URL.Query().Get("period") {
+	case "", "day":
+	case "week":
+		p.weekly = true
+	default:
+		http.Error(w, "invalid period, want day or week", http.StatusBadRequest)
+		return
+	}
+	_, body, err := renderReport(p, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(body)
+}
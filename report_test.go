@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportSchedule(t *testing.T) {
+	p, err := parseReportSchedule("weekly@08:00")
+	assert.NoError(t, err)
+	wed := time.Date(2024, 5, 8, 10, 0, 0, 0, time.UTC)
+	mon := time.Date(2024, 5, 13, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, mon, p.next(wed), "the next Monday")
+	assert.Equal(t, mon, p.next(mon.Add(-time.Hour)), "later on a Monday")
+	assert.Equal(t, mon.AddDate(0, 0, 7), p.next(mon), "a week after one was due")
+	assert.Equal(t, 7*24*time.Hour, p.period())
+
+	p, err = parseReportSchedule("daily@07:30")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 9, 7, 30, 0, 0, time.UTC), p.next(wed))
+	assert.Equal(t, 24*time.Hour, p.period())
+
+	p, err = parseReportSchedule("daily")
+	assert.NoError(t, err)
+	assert.Equal(t, reportPlan{hour: 8}, p)
+
+	for _, bad := range []string{"", "hourly", "daily@25:00", "weekly@8"} {
+		_, err := parseReportSchedule(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	defer func(h *resultHistory) { history = h }(history)
+	s := newMemoryStore(10)
+	history = &resultHistory{store: s}
+	at := time.Now().Add(-time.Hour).Truncate(time.Second)
+	s.write([]HistoryResult{
+		{Time: at, Host: "db<1>", PacketLoss: 100, State: "down"},
+		{Time: at, Host: "web", LatencyMs: 10, State: "up"},
+		{Time: at.Add(time.Minute), Host: "db<1>", LatencyMs: 10, State: "up"},
+	})
+
+	w := httptest.NewRecorder()
+	reportHandler(w, httptest.NewRequest(http.MethodGet, "/api/report?period=week", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, "mosaic weekly report")
+	assert.Contains(t, body, "<td>db&lt;1&gt;</td>", "hosts are escaped")
+	assert.Contains(t, body, "<td align=\"right\">1m0s</td>", "the outage lasted until the next answer")
+	assert.NotContains(t, body, "No host was down")
+
+	rep, err := buildReport(s, "", at.Add(-time.Hour), at.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rep.Hosts)
+	assert.Equal(t, 75.0, rep.UptimePercent)
+	assert.Equal(t, 1, rep.Incidents)
+	if assert.Len(t, rep.Worst, 1) {
+		assert.Equal(t, "db<1>", rep.Worst[0].Host)
+	}
+
+	w = httptest.NewRecorder()
+	reportHandler(w, httptest.NewRequest(http.MethodGet, "/api/report?period=year", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// resultsLogPath is the -results-log file every probe result is
+	// appended to as a JSON line. Empty logs none.
+	resultsLogPath string
+	// resultsLogSize is the size in MiB at which the -results-log is
+	// rotated, set with -results-log-size.
+	resultsLogSize = 100
+	// resultsLogKeep is how many rotated -results-log files are kept, set
+	// with -results-log-keep.
+	resultsLogKeep = 3
+)
+
+// resultsLogQueueSize is how many cycles of results may wait to be written
+// to the -results-log before new ones are dropped.
+const resultsLogQueueSize = 64
+
+// ResultLogLine is a line of the -results-log: a probe result with the
+// time of its cycle and its state as stored in the history.
+type ResultLogLine struct {
+	Time  time.Time `json:"time"`
+	State string    `json:"state"` // "up", "degraded" or "down"
+	HostStatus
+}
+
+// resultsLog appends probe results to a file as JSON lines for log
+// shippers such as Promtail or the Splunk forwarder, rotating it by size.
+// Like the history, lines are queued and written by a single worker.
+type resultsLog struct {
+	path    string
+	maxSize int64 // Size in bytes from which the file is rotated
+	keep    int   // Rotated files kept, path.1 being the newest
+	file    *os.File
+	size    int64
+	mu      sync.Mutex
+	queue   chan []byte
+	closed  bool          // Set by close; lines are no longer queued
+	done    chan struct{} // Closed when the worker has returned
+}
+
+// resultLog is the open -results-log, nil without one.
+var resultLog *resultsLog
+
+// openResultsLog opens or creates the log at path and starts appending
+// results to it.
+//
+// Parameters:
+//   - path: The log file
+//   - maxSize: Size in bytes from which the file is rotated
+//   - keep: How many rotated files are kept, 0 to start over in place
+//
+// Returns:
+//   - *resultsLog: The open log
+//   - error: If the file cannot be opened
+func openResultsLog(path string, maxSize int64, keep int) (*resultsLog, error) {
+	l := &resultsLog{path: path, maxSize: maxSize, keep: keep, queue: make(chan []byte, resultsLogQueueSize), done: make(chan struct{})}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	go l.run()
+	return l, nil
+}
+
+// open opens the log file for appending.
+func (l *resultsLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, fi.Size()
+	return nil
+}
+
+// record queues the results of a cycle as lines, labeled like the status
+// the dashboard gets. When the queue is full, they are dropped and logged.
+func (l *resultsLog) record(statuses []HostStatus, now time.Time) {
+	if len(statuses) == 0 {
+		return
+	}
+	statuses = slices.Clone(statuses)
+	annotateLabels(statuses)
+	var batch []byte
+	for _, st := range statuses {
+		line, err := json.Marshal(ResultLogLine{Time: now, State: resultState(st), HostStatus: st})
+		if err != nil {
+			continue
+		}
+		batch = append(append(batch, line...), '\n')
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- batch:
+	default:
+		log.Printf("Results log: queue full, dropped %d results", len(statuses))
+	}
+}
+
+// run writes queued lines until the queue is closed.
+func (l *resultsLog) run() {
+	defer close(l.done)
+	for batch := range l.queue {
+		if err := l.write(batch); err != nil {
+			log.Printf("Results log: %v", err)
+		}
+	}
+}
+
+// write appends batch, first rotating the file if it would grow past
+// maxSize.
+func (l *resultsLog) write(batch []byte) error {
+	if l.file == nil {
+		// A rotation failed to reopen the file; try again
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	if l.size > 0 && l.size+int64(len(batch)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(batch)
+	l.size += int64(n)
+	return err
+}
+
+// rotate renames the file to path.1, shifting older ones up to path.keep
+// and deleting the oldest, and starts a new one.
+func (l *resultsLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	if l.keep == 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return l.open()
+	}
+	for i := l.keep - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// close writes the lines still queued and closes the file, giving up on
+// the queue when ctx is done.
+func (l *resultsLog) close(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.queue)
+	}
+	l.mu.Unlock()
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readResultsLog returns the lines of a results log file.
+func readResultsLog(t *testing.T, path string) []ResultLogLine {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []ResultLogLine
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var line ResultLogLine
+		assert.NoError(t, json.Unmarshal(sc.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestResultsLog(t *testing.T) {
+	defer setLabels(labels())
+	setLabels(map[string]HostLabel{"a": {Name: "Router", Groups: []string{"core"}}})
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	l, err := openResultsLog(path, 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+	fresh := []HostStatus{{Host: "a", Alive: true, LatencyMs: 12}, {Host: "b", PacketLoss: 100, Reason: "timeout"}}
+	l.record(fresh, now)
+	l.record(nil, now)
+	assert.NoError(t, l.close(context.Background()))
+	l.record(fresh, now)
+	assert.Empty(t, fresh[0].Name, "the results are labeled in a copy")
+
+	lines := readResultsLog(t, path)
+	if assert.Len(t, lines, 2) {
+		assert.True(t, lines[0].Time.Equal(now))
+		assert.Equal(t, "a", lines[0].Host)
+		assert.Equal(t, "up", lines[0].State)
+		assert.Equal(t, 12, lines[0].LatencyMs)
+		assert.Equal(t, "Router", lines[0].Name)
+		assert.Equal(t, []string{"core"}, lines[0].Groups)
+		assert.Equal(t, "down", lines[1].State)
+		assert.Equal(t, "timeout", lines[1].Reason)
+	}
+}
+
+func TestResultsLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	// An existing file is appended to
+	assert.NoError(t, os.WriteFile(path, []byte("{}\n"), 0o644))
+	l, err := openResultsLog(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		l.record([]HostStatus{{Host: "a", Alive: true, LatencyMs: i}}, time.Now())
+	}
+	assert.NoError(t, l.close(context.Background()))
+
+	var latencies []int
+	for _, p := range []string{path + ".2", path + ".1", path} {
+		for _, line := range readResultsLog(t, p) {
+			latencies = append(latencies, line.LatencyMs)
+		}
+		fi, _ := os.Stat(p)
+		assert.LessOrEqual(t, fi.Size(), int64(200))
+	}
+	assert.Equal(t, []int{2, 3, 4}, latencies, "a line per file, the oldest deleted")
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pingInterval is the target time between the starts of two ping cycles.
+var pingInterval = 2 * time.Second
+
+// probeJitter randomizes every host's interval by up to this fraction in
+// either direction, set with -jitter, so probes of many hosts and of several
+// mosaic instances don't line up into periodic bursts.
+var probeJitter float64
+
+// downInterval is how often a host is probed while it is down, set with
+// -down-interval, so its recovery shows within a second instead of after a
+// whole interval. Zero, the default, keeps down hosts on their normal
+// interval, so an outage doesn't multiply the probes sent to it.
+var downInterval time.Duration
+
+// maxJitter is the largest -jitter accepted; more would let probes of a host
+// bunch up.
+const maxJitter = 0.5
+
+// jitterRand is a variable to allow deterministic jitter in tests. It
+// returns a number in [0, 1).
+var jitterRand = rand.Float64
+
+// jittered returns interval randomized by probeJitter.
+func jittered(interval time.Duration) time.Duration {
+	if probeJitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration((jitterRand()*2-1)*probeJitter*float64(interval))
+}
+
+// HostSchedule describes when a host was last probed and when it is due next.
+type HostSchedule struct {
+	Host           string    `json:"host"`
+	LastProbe      time.Time `json:"last_probe"`       // Start of the most recent probe
+	LastDurationMs float64   `json:"last_duration_ms"` // How long the most recent probe took
+	NextProbe      time.Time `json:"next_probe"`       // When the host is due next
+	Down           bool      `json:"down"`             // Last probe failed, so it is probed every downInterval
+	index          int       // Position in the schedule queue, -1 while being probed
+}
+
+// scheduleQueue is a min-heap of host schedules ordered by their next probe,
+// so finding the due hosts and the next wake-up does not mean scanning every
+// host. It implements heap.Interface.
+type scheduleQueue []*HostSchedule
+
+func (q scheduleQueue) Len() int           { return len(q) }
+func (q scheduleQueue) Less(i, j int) bool { return q[i].NextProbe.Before(q[j].NextProbe) }
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x any) {
+	hs := x.(*HostSchedule)
+	hs.index = len(*q)
+	*q = append(*q, hs)
+}
+
+func (q *scheduleQueue) Pop() any {
+	old := *q
+	hs := old[len(old)-1]
+	old[len(old)-1] = nil
+	hs.index = -1
+	*q = old[:len(old)-1]
+	return hs
+}
+
+// dueBy adds the hosts in the queue due by now to set, walking only the
+// part of the heap that is due.
+func (q scheduleQueue) dueBy(now time.Time, set map[string]bool) {
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(q) || q[i].NextProbe.After(now) {
+			continue
+		}
+		set[q[i].Host] = true
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+}
+
+// SchedulerState is the payload served by /api/scheduler. An overrun is a
+// cycle that took longer than the interval, meaning the configured interval
+// is no longer achievable; OverrunMs says by how much.
+type SchedulerState struct {
+	IntervalMs    float64        `json:"interval_ms"`     // Configured interval between cycle starts
+	Cycles        int            `json:"cycles"`          // Completed cycles
+	CycleStarted  time.Time      `json:"cycle_started"`   // Start of the current or last cycle
+	LastCycleMs   float64        `json:"last_cycle_ms"`   // Duration of the last completed cycle
+	MaxCycleMs    float64        `json:"max_cycle_ms"`    // Longest cycle so far
+	AvgCycleMs    float64        `json:"avg_cycle_ms"`    // Mean cycle duration
+	Overruns      int            `json:"overruns"`        // Cycles that exceeded the interval
+	LastOverrunMs float64        `json:"last_overrun_ms"` // How far the last overrun exceeded the interval
+	QueueDepth    int            `json:"queue_depth"`     // Probes of the current cycle not finished yet
+	Workers       int            `json:"workers"`         // Probes that can run at once
+	MaxPPS        int            `json:"max_pps"`         // Outbound packet rate limit, 0 for none
+	Hosts         []HostSchedule `json:"hosts"`
+}
+
+// schedulerStats records the timing of ping cycles for /api/scheduler and
+// decides which hosts are due in a cycle. Every host keeps its own schedule,
+// so hosts with a longer interval sit out cycles, and it keeps the latest
+// status of each host for the cycles it sits out. Hosts waiting for their
+// next probe are kept in queue; hosts being probed are pending instead.
+type schedulerStats struct {
+	mu      sync.Mutex
+	state   SchedulerState
+	total   time.Duration
+	byHost  map[string]*HostSchedule
+	queue   scheduleQueue
+	pending map[string]bool
+	latest  map[string]HostStatus
+	fresh   []HostStatus // Results stored since the last drainFresh
+}
+
+var scheduler = newSchedulerStats()
+
+// newSchedulerStats creates an empty schedulerStats.
+func newSchedulerStats() *schedulerStats {
+	return &schedulerStats{
+		byHost:  make(map[string]*HostSchedule),
+		pending: make(map[string]bool),
+		latest:  make(map[string]HostStatus),
+	}
+}
+
+// due returns the hosts to probe in a cycle starting at now: those never
+// probed and those whose next probe time has come, unless a probe of theirs
+// is still running.
+func (s *schedulerStats) due(hosts []string, now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ready := make(map[string]bool)
+	s.queue.dueBy(now, ready)
+	var due []string
+	for _, h := range hosts {
+		if _, known := s.byHost[h]; !known || ready[h] {
+			due = append(due, h)
+		}
+	}
+	return due
+}
+
+// beginCycle marks the start of a cycle probing the given hosts.
+func (s *schedulerStats) beginCycle(hosts []string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.CycleStarted = now
+	s.launchLocked(hosts, now)
+}
+
+// launch marks the given hosts as being probed from now on, without
+// starting a cycle.
+func (s *schedulerStats) launch(hosts []string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.launchLocked(hosts, now)
+}
+
+// launchLocked is launch for callers holding s.mu.
+func (s *schedulerStats) launchLocked(hosts []string, now time.Time) {
+	for _, h := range hosts {
+		hs := s.byHost[h]
+		if hs == nil {
+			hs = &HostSchedule{Host: h, index: -1}
+			s.byHost[h] = hs
+		}
+		if hs.index >= 0 {
+			heap.Remove(&s.queue, hs.index)
+		}
+		hs.LastProbe = now
+		s.pending[h] = true
+	}
+}
+
+// probeDone records that host finished probing after d and schedules its
+// next probe one interval of its own, with jitter, after the start of this
+// one, or one downInterval after it while the host is down.
+func (s *schedulerStats) probeDone(host string, d time.Duration) {
+	interval := jittered(settingsFor(host).Interval)
+	retry := jittered(downInterval)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, host)
+	if hs := s.byHost[host]; hs != nil {
+		if hs.Down && downInterval > 0 && retry < interval {
+			interval = retry
+		}
+		hs.LastDurationMs = msFloat(d)
+		hs.NextProbe = hs.LastProbe.Add(interval)
+		if hs.index >= 0 {
+			heap.Fix(&s.queue, hs.index)
+		} else {
+			heap.Push(&s.queue, hs)
+		}
+	}
+}
+
+// stagger gives the hosts of hosts that have no schedule yet one, with their
+// first probes spread evenly over the next interval instead of all due at
+// once.
+func (s *schedulerStats) stagger(hosts []string, now time.Time, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var fresh []string
+	for _, h := range hosts {
+		if _, ok := s.byHost[h]; !ok {
+			fresh = append(fresh, h)
+		}
+	}
+	for i, h := range fresh {
+		hs := &HostSchedule{Host: h, NextProbe: now.Add(interval * time.Duration(i) / time.Duration(len(fresh)))}
+		s.byHost[h] = hs
+		heap.Push(&s.queue, hs)
+	}
+}
+
+// store keeps status as the latest of its host, unless the host was
+// forgotten while it was being probed.
+func (s *schedulerStats) store(status HostStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hs, ok := s.byHost[status.Host]; ok {
+		hs.Down = !status.Alive || status.Failures > 0
+		s.latest[status.Host] = status
+		s.fresh = append(s.fresh, status)
+	}
+}
+
+// drainFresh returns the results stored since the previous call, in the
+// order the probes finished.
+func (s *schedulerStats) drainFresh() []HostStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fresh := s.fresh
+	s.fresh = nil
+	return fresh
+}
+
+// results returns the latest status of each of hosts that has one, in order.
+func (s *schedulerStats) results(hosts []string) []HostStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]HostStatus, 0, len(hosts))
+	for _, h := range hosts {
+		if st, ok := s.latest[h]; ok {
+			statuses = append(statuses, st)
+		}
+	}
+	return statuses
+}
+
+// forget drops everything known about host.
+func (s *schedulerStats) forget(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hs := s.byHost[host]; hs != nil && hs.index >= 0 {
+		heap.Remove(&s.queue, hs.index)
+	}
+	delete(s.byHost, host)
+	delete(s.pending, host)
+	delete(s.latest, host)
+	for i := 0; i < len(s.fresh); i++ {
+		if s.fresh[i].Host == host {
+			s.fresh = append(s.fresh[:i], s.fresh[i+1:]...)
+			i--
+		}
+	}
+}
+
+// endCycle records the completion of the current cycle.
+//
+// Parameters:
+//   - interval: Target time between cycle starts
+//   - now: Time the cycle finished
+//
+// Returns:
+//   - time.Duration: How long to wait before starting the next cycle, at
+//     most until the next interval or the next host falls due
+func (s *schedulerStats) endCycle(interval time.Duration, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := now.Sub(s.state.CycleStarted)
+	s.state.Cycles++
+	s.total += elapsed
+	s.state.IntervalMs = msFloat(interval)
+	s.state.LastCycleMs = msFloat(elapsed)
+	s.state.AvgCycleMs = msFloat(s.total / time.Duration(s.state.Cycles))
+	if s.state.LastCycleMs > s.state.MaxCycleMs {
+		s.state.MaxCycleMs = s.state.LastCycleMs
+	}
+	wait := interval - elapsed
+	if wait < 0 {
+		s.state.Overruns++
+		s.state.LastOverrunMs = msFloat(-wait)
+		wait = 0
+	}
+	if len(s.queue) > 0 && s.queue[0].NextProbe.Sub(now) < wait {
+		wait = s.queue[0].NextProbe.Sub(now)
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// snapshot returns a copy of the current scheduler state.
+func (s *schedulerStats) snapshot() SchedulerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state
+	st.QueueDepth = len(s.pending)
+	st.Workers = probes.size
+	st.MaxPPS = packetLimit.rate()
+	st.Hosts = make([]HostSchedule, 0, len(s.byHost))
+	for _, hs := range s.byHost {
+		st.Hosts = append(st.Hosts, *hs)
+	}
+	sort.Slice(st.Hosts, func(i, j int) bool { return st.Hosts[i].Host < st.Hosts[j].Host })
+	return st
+}
+
+// msFloat converts d to fractional milliseconds.
+func msFloat(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// schedulerHandler serves the scheduler state as JSON.
+func schedulerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduler.snapshot())
+}
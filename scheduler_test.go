@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerStatsCycle(t *testing.T) {
+	s := newSchedulerStats()
+	start := time.Now()
+
+	s.beginCycle([]string{"a", "b"}, start)
+	assert.Equal(t, 2, s.snapshot().QueueDepth)
+	s.probeDone("a", 300*time.Millisecond)
+	assert.Equal(t, 1, s.snapshot().QueueDepth)
+	s.probeDone("b", 500*time.Millisecond)
+
+	wait := s.endCycle(2*time.Second, start.Add(500*time.Millisecond))
+	assert.Equal(t, 1500*time.Millisecond, wait)
+
+	st := s.snapshot()
+	assert.Equal(t, 0, st.QueueDepth)
+	assert.Equal(t, 1, st.Cycles)
+	assert.Equal(t, 0, st.Overruns)
+	assert.Equal(t, 500.0, st.LastCycleMs)
+	assert.Equal(t, 2000.0, st.IntervalMs)
+	assert.Len(t, st.Hosts, 2)
+	assert.Equal(t, "a", st.Hosts[0].Host)
+	assert.Equal(t, 300.0, st.Hosts[0].LastDurationMs)
+	assert.Equal(t, start.Add(2*time.Second), st.Hosts[0].NextProbe)
+}
+
+func TestSchedulerStatsOverrun(t *testing.T) {
+	s := newSchedulerStats()
+	start := time.Now()
+
+	s.beginCycle([]string{"slow"}, start)
+	s.probeDone("slow", 3*time.Second)
+	wait := s.endCycle(2*time.Second, start.Add(3*time.Second))
+	assert.Equal(t, time.Duration(0), wait, "an overrun cycle is followed immediately by the next")
+
+	s.beginCycle([]string{"slow"}, start.Add(3*time.Second))
+	s.probeDone("slow", time.Second)
+	s.endCycle(2*time.Second, start.Add(4*time.Second))
+
+	st := s.snapshot()
+	assert.Equal(t, 2, st.Cycles)
+	assert.Equal(t, 1, st.Overruns)
+	assert.Equal(t, 1000.0, st.LastOverrunMs)
+	assert.Equal(t, 3000.0, st.MaxCycleMs)
+	assert.Equal(t, 2000.0, st.AvgCycleMs)
+}
+
+func TestSchedulerHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	schedulerHandler(rec, httptest.NewRequest("GET", "/api/scheduler", nil))
+	var st SchedulerState
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &st))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+}
+
+func TestSchedulerPerHostInterval(t *testing.T) {
+	defer setOverrides(overrides())
+	setOverrides(map[string]HostOverride{"sat": {Interval: "10s"}})
+	s := newSchedulerStats()
+	start := time.Now()
+
+	assert.Equal(t, []string{"lan", "sat"}, s.due([]string{"lan", "sat"}, start))
+	s.beginCycle([]string{"lan", "sat"}, start)
+	assert.Empty(t, s.due([]string{"lan", "sat"}, start), "hosts being probed are not due")
+	s.store(HostStatus{Host: "lan", Alive: true})
+	s.probeDone("lan", 10*time.Millisecond)
+	s.store(HostStatus{Host: "sat", Alive: true, LatencyMs: 600})
+	s.probeDone("sat", 600*time.Millisecond)
+	assert.Equal(t, 2*time.Second-600*time.Millisecond, s.endCycle(2*time.Second, start.Add(600*time.Millisecond)))
+
+	next := start.Add(2 * time.Second)
+	assert.Equal(t, []string{"lan"}, s.due([]string{"lan", "sat"}, next))
+	assert.Equal(t, []string{"lan", "sat"}, s.due([]string{"lan", "sat"}, start.Add(10*time.Second)))
+
+	// Hosts sitting out a cycle keep their latest status
+	assert.Equal(t, 600, s.results([]string{"lan", "sat"})[1].LatencyMs)
+	s.forget("sat")
+	s.store(HostStatus{Host: "sat"})
+	assert.Len(t, s.results([]string{"lan", "sat"}), 1, "results of forgotten hosts are dropped")
+}
+
+func TestSchedulerWakesForShorterInterval(t *testing.T) {
+	defer setOverrides(overrides())
+	setOverrides(map[string]HostOverride{"lan": {Interval: "500ms"}})
+	s := newSchedulerStats()
+	start := time.Now()
+	s.beginCycle([]string{"lan", "wan"}, start)
+	s.probeDone("lan", 10*time.Millisecond)
+	s.probeDone("wan", 10*time.Millisecond)
+	assert.Equal(t, 400*time.Millisecond, s.endCycle(2*time.Second, start.Add(100*time.Millisecond)))
+}
+
+func TestSchedulerDownInterval(t *testing.T) {
+	defer func(d time.Duration) { downInterval = d }(downInterval)
+	downInterval = 500 * time.Millisecond
+	s := newSchedulerStats()
+	start := time.Now()
+	s.beginCycle([]string{"up", "dead"}, start)
+	s.store(HostStatus{Host: "up", Alive: true})
+	s.probeDone("up", 10*time.Millisecond)
+	s.store(HostStatus{Host: "dead"})
+	s.probeDone("dead", 10*time.Millisecond)
+	assert.Equal(t, start.Add(downInterval), s.byHost["dead"].NextProbe, "down hosts are retried quickly")
+	assert.Equal(t, start.Add(2*time.Second), s.byHost["up"].NextProbe)
+	assert.Equal(t, downInterval, s.endCycle(2*time.Second, start))
+
+	// Once it answers again the host goes back to its normal interval
+	next := start.Add(downInterval)
+	assert.Equal(t, []string{"dead"}, s.due([]string{"up", "dead"}, next))
+	s.beginCycle([]string{"dead"}, next)
+	s.store(HostStatus{Host: "dead", Alive: true})
+	s.probeDone("dead", 10*time.Millisecond)
+	assert.False(t, s.byHost["dead"].Down)
+	assert.Equal(t, next.Add(2*time.Second), s.byHost["dead"].NextProbe)
+
+	downInterval = 0
+	s.beginCycle([]string{"up"}, next)
+	s.store(HostStatus{Host: "up"})
+	s.probeDone("up", 10*time.Millisecond)
+	assert.Equal(t, next.Add(2*time.Second), s.byHost["up"].NextProbe, "-down-interval 0 keeps the interval")
+}
+
+func TestRunCycleDetachesSlowHosts(t *testing.T) {
+	withHosts(t, "sim://lan?latency=0s", "sim://sat?latency=300ms")
+	defer func(saved *schedulerStats) { scheduler = saved }(scheduler)
+	defer setOverrides(overrides())
+	scheduler = newSchedulerStats()
+	setOverrides(map[string]HostOverride{"sim://sat?latency=300ms": {Timeout: "5s"}})
+
+	start := time.Now()
+	fresh := runCycle(context.Background(), false)
+	assert.Less(t, time.Since(start), 300*time.Millisecond, "the cycle does not wait for the slow host")
+	assert.Len(t, fresh, 1)
+	assert.Equal(t, "sim://lan?latency=0s", fresh[0].Host)
+
+	// The slow host's result is kept for the following cycles
+	assert.Eventually(t, func() bool { return scheduler.snapshot().QueueDepth == 0 }, time.Second, 10*time.Millisecond)
+	assert.Len(t, scheduler.results(currentHosts()), 2)
+}
+
+func TestRunCycleSmearsProbes(t *testing.T) {
+	withHosts(t, "sim://a?latency=0s", "sim://b?latency=0s", "sim://c?latency=0s", "sim://d?latency=0s")
+	defer func(saved *schedulerStats, l *packetLimiter, i, to time.Duration) {
+		scheduler, packetLimit, pingInterval, probeTimeout = saved, l, i, to
+	}(scheduler, packetLimit, pingInterval, probeTimeout)
+	scheduler = newSchedulerStats()
+	packetLimit = newPacketLimiter(10000)
+	pingInterval, probeTimeout = 500*time.Millisecond, 100*time.Millisecond
+
+	start := time.Now()
+	assert.Len(t, runCycle(context.Background(), false), 4)
+	elapsed := time.Since(start)
+	// The last of four probes starts 3/4 into the 400ms window
+	assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+	assert.Less(t, elapsed, pingInterval)
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	defer func(j float64, r func() float64) { probeJitter, jitterRand = j, r }(probeJitter, jitterRand)
+	probeJitter = 0.1
+	s := newSchedulerStats()
+	start := time.Now()
+	s.beginCycle([]string{"early", "late"}, start)
+
+	jitterRand = func() float64 { return 0 }
+	s.probeDone("early", time.Millisecond)
+	jitterRand = func() float64 { return 0.75 }
+	s.probeDone("late", time.Millisecond)
+
+	st := s.snapshot()
+	assert.Equal(t, start.Add(1800*time.Millisecond), st.Hosts[0].NextProbe)
+	assert.Equal(t, start.Add(2100*time.Millisecond), st.Hosts[1].NextProbe)
+	// The next cycle starts when the earliest host falls due
+	assert.Equal(t, 1800*time.Millisecond, s.endCycle(2*time.Second, start))
+
+	probeJitter = 0
+	assert.Equal(t, 2*time.Second, jittered(2*time.Second))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selfHost is the name of the tile reporting mosaic's own health.
+const selfHost = "mosaic"
+
+// defaultSelfAlerts are the thresholds on mosaic's own loop metrics, fired
+// when a metric reaches them:
+//   - cycle_utilization: cycle duration as a fraction of the interval
+//   - broadcast_seconds: time to push one update to all dashboard clients
+//   - timeout_ratio: fraction of probes in a cycle that got no answer; every
+//     probe failing at once usually means mosaic itself lost the network
+var defaultSelfAlerts = map[string]float64{
+	"cycle_utilization": 0.9,
+	"broadcast_seconds": 0.5,
+	"timeout_ratio":     1,
+}
+
+// loopMetrics records the health of the ping loop itself.
+type loopMetrics struct {
+	mu           sync.Mutex
+	alerts       map[string]float64 // Threshold per alert, 0 disables it
+	firing       map[string]bool
+	values       map[string]float64 // Latest value per alert
+	cycles       int
+	probes       int
+	timeouts     int
+	cycle        time.Duration
+	interval     time.Duration
+	lastStart    time.Time
+	probesPerSec float64
+	broadcast    time.Duration
+}
+
+var (
+	selfMetrics = newLoopMetrics(defaultSelfAlerts)
+	// selfTile adds a tile for mosaic itself that degrades while a self
+	// alert fires.
+	selfTile bool
+)
+
+// newLoopMetrics creates loopMetrics alerting on the given thresholds.
+func newLoopMetrics(alerts map[string]float64) *loopMetrics {
+	m := &loopMetrics{alerts: make(map[string]float64), firing: make(map[string]bool), values: make(map[string]float64)}
+	for name, v := range alerts {
+		m.alerts[name] = v
+	}
+	return m
+}
+
+// parseSelfAlerts overrides default self alert thresholds from a
+// comma-separated "name=threshold" list; a threshold of 0 disables an alert.
+func parseSelfAlerts(s string) (map[string]float64, error) {
+	alerts := make(map[string]float64)
+	for name, v := range defaultSelfAlerts {
+		alerts[name] = v
+	}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if _, known := defaultSelfAlerts[name]; !ok || !known {
+			return nil, fmt.Errorf("invalid self alert %q", pair)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("invalid self alert threshold %q", pair)
+		}
+		alerts[name] = v
+	}
+	return alerts, nil
+}
+
+// recordCycle accounts for a finished round of probes and evaluates the self
+// alerts, emitting an event whenever one starts or stops firing.
+//
+// Parameters:
+//   - statuses: Results of the cycle's probes
+//   - start: When the cycle started
+//   - elapsed: How long probing took
+//   - interval: The configured interval between cycles
+//
+// Returns:
+//   - HostStatus: Status of mosaic itself, degraded while an alert fires
+func (m *loopMetrics) recordCycle(statuses []HostStatus, start time.Time, elapsed, interval time.Duration) HostStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	timeouts := 0
+	for _, s := range statuses {
+		// Failures not yet confirmed as down count as well
+		if !s.Alive || s.Failures > 0 {
+			timeouts++
+		}
+	}
+	if !m.lastStart.IsZero() {
+		if since := start.Sub(m.lastStart); since > 0 {
+			m.probesPerSec = float64(len(statuses)) / since.Seconds()
+		}
+	}
+	m.lastStart = start
+	m.cycles++
+	m.probes += len(statuses)
+	m.timeouts += timeouts
+	m.cycle = elapsed
+	m.interval = interval
+
+	m.values["cycle_utilization"] = 0
+	if interval > 0 {
+		m.values["cycle_utilization"] = elapsed.Seconds() / interval.Seconds()
+	}
+	m.values["broadcast_seconds"] = m.broadcast.Seconds()
+	m.values["timeout_ratio"] = 0
+	if len(statuses) > 0 {
+		m.values["timeout_ratio"] = float64(timeouts) / float64(len(statuses))
+	}
+
+	var firing []string
+	for _, name := range sortedKeys(m.alerts) {
+		threshold, value := m.alerts[name], m.values[name]
+		fire := threshold > 0 && value >= threshold
+		switch {
+		case fire && !m.firing[name]:
+			events.add(Event{Time: start.Add(elapsed), Type: "self_alert", Key: name, Hosts: []string{selfHost},
+				Message: fmt.Sprintf("mosaic %s is %.2f (threshold %.2f)", name, value, threshold)})
+		case !fire && m.firing[name]:
+			events.add(Event{Time: start.Add(elapsed), Type: "self_alert_resolved", Key: name, Hosts: []string{selfHost},
+				Message: fmt.Sprintf("mosaic %s is back to %.2f", name, value)})
+		}
+		m.firing[name] = fire
+		if fire {
+			firing = append(firing, fmt.Sprintf("%s %.2f", name, value))
+		}
+	}
+	status := HostStatus{Host: selfHost, Alive: true, LatencyMs: int(elapsed.Milliseconds())}
+	if len(firing) > 0 {
+		status.Degraded = true
+		status.Detail = strings.Join(firing, ", ")
+	}
+	return status
+}
+
+// recordBroadcast stores how long the last broadcast took.
+func (m *loopMetrics) recordBroadcast(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.broadcast = d
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeMetric writes one metric in the Prometheus text exposition format.
+func writeMetric(b *strings.Builder, name, typ, help string, value float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// metricsHandler serves mosaic's own loop metrics for Prometheus to scrape.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	sched := scheduler.snapshot()
+	clientsMu.Lock()
+	nclients := len(clients)
+	clientsMu.Unlock()
+
+	m := selfMetrics
+	m.mu.Lock()
+	var b strings.Builder
+	writeMetric(&b, "mosaic_cycles_total", "counter", "Completed ping cycles.", float64(m.cycles))
+	writeMetric(&b, "mosaic_cycle_duration_seconds", "gauge", "Duration of the last ping cycle.", m.cycle.Seconds())
+	writeMetric(&b, "mosaic_cycle_interval_seconds", "gauge", "Configured interval between ping cycles.", m.interval.Seconds())
+	writeMetric(&b, "mosaic_probes_total", "counter", "Host probes run.", float64(m.probes))
+	writeMetric(&b, "mosaic_probe_timeouts_total", "counter", "Host probes that got no answer.", float64(m.timeouts))
+	writeMetric(&b, "mosaic_probes_per_second", "gauge", "Probe rate over the last cycle.", m.probesPerSec)
+	writeMetric(&b, "mosaic_broadcast_duration_seconds", "gauge", "Time to push the last update to all dashboard clients.", m.broadcast.Seconds())
+	writeMetric(&b, "mosaic_scheduler_overruns_total", "counter", "Cycles that took longer than the interval.", float64(sched.Overruns))
+	writeMetric(&b, "mosaic_scheduler_queue_depth", "gauge", "Probes of the current cycle not finished yet.", float64(sched.QueueDepth))
+	writeMetric(&b, "mosaic_websocket_clients", "gauge", "Connected dashboard clients.", float64(nclients))
+	b.WriteString("# HELP mosaic_self_alert Whether a self alert is firing.\n# TYPE mosaic_self_alert gauge\n")
+	for _, name := range sortedKeys(m.alerts) {
+		v := 0
+		if m.firing[name] {
+			v = 1
+		}
+		fmt.Fprintf(&b, "mosaic_self_alert{alert=%q} %d\n", name, v)
+	}
+	m.mu.Unlock()
+	writeAggregateMetrics(&b)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
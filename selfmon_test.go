@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSelfAlerts(t *testing.T) {
+	alerts, err := parseSelfAlerts("cycle_utilization=0.5, timeout_ratio=0")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, alerts["cycle_utilization"])
+	assert.Equal(t, 0.0, alerts["timeout_ratio"])
+	assert.Equal(t, defaultSelfAlerts["broadcast_seconds"], alerts["broadcast_seconds"])
+
+	_, err = parseSelfAlerts("cpu=1")
+	assert.Error(t, err)
+	_, err = parseSelfAlerts("timeout_ratio=x")
+	assert.Error(t, err)
+}
+
+func TestLoopMetricsAlerts(t *testing.T) {
+	m := newLoopMetrics(map[string]float64{"cycle_utilization": 0.9, "timeout_ratio": 1})
+	start := time.Now()
+	up := []HostStatus{{Host: "a", Alive: true}, {Host: "b", Alive: true}}
+
+	status := m.recordCycle(up, start, 500*time.Millisecond, time.Second)
+	assert.Equal(t, selfHost, status.Host)
+	assert.False(t, status.Degraded)
+
+	// A cycle using the whole interval fires, and every probe failing too
+	down := []HostStatus{{Host: "a"}, {Host: "b"}}
+	status = m.recordCycle(down, start.Add(time.Second), time.Second, time.Second)
+	assert.True(t, status.Degraded)
+	assert.Equal(t, "cycle_utilization 1.00, timeout_ratio 1.00", status.Detail)
+	assert.Equal(t, 2.0, m.probesPerSec)
+	assert.Equal(t, "self_alert", events.recent()[0].Type)
+
+	status = m.recordCycle(up, start.Add(2*time.Second), 100*time.Millisecond, time.Second)
+	assert.False(t, status.Degraded)
+	assert.Equal(t, "self_alert_resolved", events.recent()[0].Type)
+	assert.Equal(t, 6, m.probes)
+	assert.Equal(t, 2, m.timeouts)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	defer func(saved *loopMetrics) { selfMetrics = saved }(selfMetrics)
+	selfMetrics = newLoopMetrics(defaultSelfAlerts)
+	selfMetrics.recordCycle([]HostStatus{{Host: "a"}}, time.Now(), 250*time.Millisecond, time.Second)
+	selfMetrics.recordBroadcast(3 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	assert.Contains(t, body, "# TYPE mosaic_cycles_total counter\nmosaic_cycles_total 1\n")
+	assert.Contains(t, body, "mosaic_cycle_duration_seconds 0.25\n")
+	assert.Contains(t, body, "mosaic_probe_timeouts_total 1\n")
+	assert.Contains(t, body, "mosaic_broadcast_duration_seconds 0.003\n")
+	assert.Contains(t, body, `mosaic_self_alert{alert="timeout_ratio"} 1`)
+	assert.Contains(t, body, `mosaic_self_alert{alert="cycle_utilization"} 0`)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProbeSettings control the ping cadence and the shape of ICMP probes.
+type ProbeSettings struct {
+	Interval time.Duration // Time between the starts of two ping cycles
+	Count    int           // ICMP echo requests per host and cycle
+	Timeout  time.Duration // How long a probe may take
+	Size     int           // ICMP echo payload size in bytes
+}
+
+// pendingSettings holds settings changed at runtime until the ping loop
+// picks them up between two cycles, so no probe sees a half-applied change.
+var pendingSettings struct {
+	mu       sync.Mutex
+	settings *ProbeSettings
+}
+
+// currentSettings returns the settings in effect. Settings only change under
+// pendingSettings.mu, so handlers can read them while a cycle runs.
+func currentSettings() ProbeSettings {
+	pendingSettings.mu.Lock()
+	defer pendingSettings.mu.Unlock()
+	return ProbeSettings{Interval: pingInterval, Count: probeCount, Timeout: probeTimeout, Size: probeSize}
+}
+
+// validate reports settings that would break probing.
+func (s ProbeSettings) validate() error {
+	switch {
+	case s.Interval <= 0:
+		return fmt.Errorf("interval must be positive")
+	case s.Count < 1:
+		return fmt.Errorf("count must be at least 1")
+	case s.Timeout <= 0:
+		return fmt.Errorf("timeout must be positive")
+	case s.Size < minProbeSize:
+		return fmt.Errorf("size must be at least %d bytes", minProbeSize)
+	}
+	return nil
+}
+
+// apply makes s the settings in effect. Only call it between cycles with
+// pendingSettings.mu held.
+func (s ProbeSettings) apply() {
+	pingInterval, probeCount, probeTimeout, probeSize = s.Interval, s.Count, s.Timeout, s.Size
+}
+
+// queueSettings schedules s to take effect before the next cycle.
+func queueSettings(s ProbeSettings) {
+	pendingSettings.mu.Lock()
+	defer pendingSettings.mu.Unlock()
+	pendingSettings.settings = &s
+}
+
+// applyPendingSettings applies settings queued since the last cycle.
+func applyPendingSettings() {
+	pendingSettings.mu.Lock()
+	defer pendingSettings.mu.Unlock()
+	if pendingSettings.settings != nil {
+		pendingSettings.settings.apply()
+		pendingSettings.settings = nil
+	}
+}
+
+// icmpSendInterval spreads count echo requests over the probe timeout,
+// leaving room for the last reply, and never waits more than a second.
+func icmpSendInterval(count int, timeout time.Duration) time.Duration {
+	interval := timeout / time.Duration(count+1)
+	if interval > time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// HostOverride replaces some probe settings for one host, e.g. a longer
+// interval and timeout for a satellite link. Empty fields keep the global
+// setting. Durations are strings such as "10s".
+type HostOverride struct {
+	Interval string `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Timeout  string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Count    int    `json:"count,omitempty" yaml:"count,omitempty"`
+	Source   string `json:"source,omitempty" yaml:"source,omitempty"`   // Interface or address to ping from
+	Enabled  *bool  `json:"enabled,omitempty" yaml:"enabled,omitempty"` // False parks the host without probing it
+}
+
+var (
+	overridesMu sync.RWMutex
+	// hostOverrides holds per-host probe settings, set with -override or
+	// through /api/config/reload.
+	hostOverrides = make(map[string]HostOverride)
+)
+
+// apply returns base with the fields set in o replaced.
+func (o HostOverride) apply(base ProbeSettings) (ProbeSettings, error) {
+	s := base
+	var err error
+	if o.Interval != "" {
+		if s.Interval, err = time.ParseDuration(o.Interval); err != nil {
+			return s, fmt.Errorf("invalid interval %q", o.Interval)
+		}
+	}
+	if o.Timeout != "" {
+		if s.Timeout, err = time.ParseDuration(o.Timeout); err != nil {
+			return s, fmt.Errorf("invalid timeout %q", o.Timeout)
+		}
+	}
+	if o.Count != 0 {
+		s.Count = o.Count
+	}
+	if o.Source != "" {
+		if err := validSource(o.Source); err != nil {
+			return s, err
+		}
+	}
+	return s, s.validate()
+}
+
+// equal reports whether o and p hold the same settings.
+func (o HostOverride) equal(p HostOverride) bool {
+	return o.String() == p.String()
+}
+
+// String renders o as "interval=10s timeout=8s count=3 source=eth1".
+func (o HostOverride) String() string {
+	var parts []string
+	if o.Interval != "" {
+		parts = append(parts, "interval="+o.Interval)
+	}
+	if o.Timeout != "" {
+		parts = append(parts, "timeout="+o.Timeout)
+	}
+	if o.Count != 0 {
+		parts = append(parts, fmt.Sprintf("count=%d", o.Count))
+	}
+	if o.Source != "" {
+		parts = append(parts, "source="+o.Source)
+	}
+	if o.Enabled != nil {
+		parts = append(parts, fmt.Sprintf("enabled=%t", *o.Enabled))
+	}
+	return strings.Join(parts, " ")
+}
+
+// settingsFor returns the probe settings of host: the global ones with its
+// override applied.
+func settingsFor(host string) ProbeSettings {
+	base := currentSettings()
+	overridesMu.RLock()
+	o, ok := hostOverrides[host]
+	overridesMu.RUnlock()
+	if !ok {
+		return base
+	}
+	if s, err := o.apply(base); err == nil {
+		return s
+	}
+	return base
+}
+
+// withOverride adds the count, timeout and source overridden for an ICMP
+// host as options of its address, where pingICMP picks them up. Options already in
+// the entry take precedence.
+func withOverride(host, addr string) string {
+	overridesMu.RLock()
+	o, ok := hostOverrides[host]
+	overridesMu.RUnlock()
+	if !ok {
+		return addr
+	}
+	_, opts := splitOptions(addr)
+	if o.Count != 0 && opts.Get("count") == "" {
+		addr = withOption(addr, "count", strconv.Itoa(o.Count))
+	}
+	if o.Timeout != "" && opts.Get("timeout") == "" {
+		addr = withOption(addr, "timeout", o.Timeout)
+	}
+	if o.Source != "" && opts.Get("source") == "" {
+		addr = withOption(addr, "source", o.Source)
+	}
+	return addr
+}
+
+// overrides returns a copy of the per-host overrides.
+func overrides() map[string]HostOverride {
+	overridesMu.RLock()
+	defer overridesMu.RUnlock()
+	out := make(map[string]HostOverride, len(hostOverrides))
+	for h, o := range hostOverrides {
+		out[h] = o
+	}
+	return out
+}
+
+// setOverrides replaces the per-host overrides.
+func setOverrides(o map[string]HostOverride) {
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+	hostOverrides = make(map[string]HostOverride, len(o))
+	for h, v := range o {
+		hostOverrides[h] = v
+	}
+}
+
+// overrideFlag collects repeated -override flags such as
+// "sat01 interval=10s timeout=8s count=3 warn=800 crit=1500". The host comes
+// first and may itself contain spaces, as exec:// entries do; warn and crit
+// set the host's latency thresholds, loss-warn and loss-crit its packet loss
+// thresholds in percent.
+type overrideFlag struct {
+	overrides  map[string]HostOverride
+	thresholds map[string]Thresholds
+}
+
+var overrideFlags overrideFlag
+
+// String implements flag.Value.
+func (f *overrideFlag) String() string {
+	var parts []string
+	for h, o := range f.overrides {
+		parts = append(parts, h+" "+o.String())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// Set implements flag.Value.
+func (f *overrideFlag) Set(s string) error {
+	host, o, th, err := parseOverride(s)
+	if err != nil {
+		return err
+	}
+	if f.overrides == nil {
+		f.overrides = make(map[string]HostOverride)
+		f.thresholds = make(map[string]Thresholds)
+	}
+	if o != (HostOverride{}) {
+		f.overrides[host] = o
+	}
+	if th != (Thresholds{}) {
+		f.thresholds[host] = th
+	}
+	return nil
+}
+
+// parseOverride parses one -override value.
+//
+// Parameters:
+//   - s: Host entry followed by space-separated key=value settings
+//
+// Returns:
+//   - string: The host entry
+//   - HostOverride: Its probe settings
+//   - Thresholds: Its latency and loss thresholds, zero if not given
+//   - error: If the host or settings are missing or a setting is invalid
+func parseOverride(s string) (string, HostOverride, Thresholds, error) {
+	var o HostOverride
+	var th Thresholds
+	fields := strings.Fields(s)
+	// Settings are the trailing key=value fields, the rest is the host
+	n := len(fields)
+	for ; n > 1; n-- {
+		ok, err := setOverride(&o, &th, fields[n-1])
+		if err != nil {
+			return "", o, th, fmt.Errorf("invalid override %q: %v", s, err)
+		}
+		if !ok {
+			break
+		}
+	}
+	if n == len(fields) || n == 0 {
+		return "", o, th, fmt.Errorf("invalid override %q: expected host followed by settings", s)
+	}
+	if _, err := o.apply(currentSettings()); err != nil {
+		return "", o, th, fmt.Errorf("invalid override %q: %v", s, err)
+	}
+	return strings.Join(fields[:n], " "), o, th, nil
+}
+
+// setOverride applies one key=value field of an -override value. ok is false
+// if the field is not a setting.
+func setOverride(o *HostOverride, th *Thresholds, field string) (ok bool, err error) {
+	key, value, _ := strings.Cut(field, "=")
+	switch key {
+	case "interval":
+		o.Interval = value
+	case "timeout":
+		o.Timeout = value
+	case "count":
+		o.Count, err = strconv.Atoi(value)
+	case "source":
+		o.Source = value
+		return true, nil
+	case "enabled":
+		enabled, perr := strconv.ParseBool(value)
+		if perr != nil {
+			return true, fmt.Errorf("enabled must be true or false")
+		}
+		o.Enabled = &enabled
+		return true, nil
+	case "warn":
+		th.WarnMs, err = strconv.Atoi(value)
+	case "crit":
+		th.CritMs, err = strconv.Atoi(value)
+	case "loss-warn":
+		th.LossWarnPct, err = strconv.ParseFloat(value, 64)
+	case "loss-crit":
+		th.LossCritPct, err = strconv.ParseFloat(value, 64)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("%s must be a number", key)
+	}
+	return true, nil
+}
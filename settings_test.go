@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeSettingsValidate(t *testing.T) {
+	valid := ProbeSettings{Interval: 2 * time.Second, Count: 1, Timeout: time.Second, Size: 24}
+	assert.NoError(t, valid.validate())
+
+	for _, s := range []ProbeSettings{
+		{Interval: 0, Count: 1, Timeout: time.Second, Size: 24},
+		{Interval: time.Second, Count: 0, Timeout: time.Second, Size: 24},
+		{Interval: time.Second, Count: 1, Timeout: 0, Size: 24},
+		{Interval: time.Second, Count: 1, Timeout: time.Second, Size: 8},
+	} {
+		assert.Error(t, s.validate(), "%+v", s)
+	}
+}
+
+func TestPendingSettings(t *testing.T) {
+	old := currentSettings()
+	defer old.apply()
+
+	next := ProbeSettings{Interval: 5 * time.Second, Count: 3, Timeout: 1500 * time.Millisecond, Size: 64}
+	queueSettings(next)
+	assert.Equal(t, old, currentSettings(), "queued settings wait for the next cycle")
+	applyPendingSettings()
+	assert.Equal(t, next, currentSettings())
+	assert.Equal(t, 3, probeCount)
+
+	// Nothing queued leaves the settings alone
+	applyPendingSettings()
+	assert.Equal(t, next, currentSettings())
+}
+
+func TestICMPSendInterval(t *testing.T) {
+	assert.Equal(t, time.Second, icmpSendInterval(1, 2*time.Second))
+	assert.Equal(t, 500*time.Millisecond, icmpSendInterval(3, 2*time.Second))
+	assert.Equal(t, time.Second, icmpSendInterval(5, time.Minute))
+}
+
+func TestParseOverride(t *testing.T) {
+	host, o, th, err := parseOverride("sat01 interval=10s timeout=8s count=3 warn=800 crit=1500")
+	assert.NoError(t, err)
+	assert.Equal(t, "sat01", host)
+	assert.Equal(t, HostOverride{Interval: "10s", Timeout: "8s", Count: 3}, o)
+	assert.Equal(t, Thresholds{WarnMs: 800, CritMs: 1500}, th)
+
+	// Hosts may contain spaces and "=" themselves
+	host, o, _, err = parseOverride("exec:///usr/bin/check --site=ams interval=30s")
+	assert.NoError(t, err)
+	assert.Equal(t, "exec:///usr/bin/check --site=ams", host)
+	assert.Equal(t, "30s", o.Interval)
+
+	// Loss thresholds apply to groups of hosts too
+	host, _, th, err = parseOverride("sat* loss-warn=5 loss-crit=50")
+	assert.NoError(t, err)
+	assert.Equal(t, "sat*", host)
+	assert.Equal(t, Thresholds{LossWarnPct: 5, LossCritPct: 50}, th)
+
+	// Disabled hosts stay configured
+	_, o, _, err = parseOverride("lab01 enabled=false")
+	assert.NoError(t, err)
+	assert.True(t, o.disabled())
+
+	for _, bad := range []string{"sat01", "interval=10s", "sat01 enabled=maybe", "sat01 interval=soon", "sat01 count=x", "sat01 color=red", "sat01 count=-1"} {
+		_, _, _, err := parseOverride(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestOverrideFlag(t *testing.T) {
+	var f overrideFlag
+	assert.NoError(t, f.Set("sat01 interval=10s warn=800"))
+	assert.NoError(t, f.Set("lan01 interval=1s"))
+	assert.Equal(t, HostOverride{Interval: "10s"}, f.overrides["sat01"])
+	assert.Equal(t, Thresholds{WarnMs: 800}, f.thresholds["sat01"])
+	assert.NotContains(t, f.thresholds, "lan01")
+	assert.Equal(t, "lan01 interval=1s, sat01 interval=10s", f.String())
+}
+
+func TestSettingsFor(t *testing.T) {
+	defer setOverrides(overrides())
+	setOverrides(map[string]HostOverride{"sat01": {Interval: "10s", Timeout: "8s", Count: 3}})
+
+	s := settingsFor("sat01")
+	assert.Equal(t, 10*time.Second, s.Interval)
+	assert.Equal(t, 8*time.Second, s.Timeout)
+	assert.Equal(t, 3, s.Count)
+	assert.Equal(t, probeSize, s.Size)
+	assert.Equal(t, currentSettings(), settingsFor("lan01"))
+
+	assert.Equal(t, "sat01?count=3&timeout=8s", withOverride("sat01", "sat01"))
+	assert.Equal(t, "sat01?count=5&timeout=8s", withOverride("sat01", "sat01?count=5"), "options in the entry win")
+	assert.Equal(t, "lan01", withOverride("lan01", "lan01"))
+}
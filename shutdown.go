@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// shutdownGrace bounds how long mosaic waits on SIGTERM or SIGINT for
+// running probes, HTTP requests and queued notifications before it exits
+// anyway. Kubernetes sends SIGKILL 30 seconds after SIGTERM by default.
+const shutdownGrace = 20 * time.Second
+
+// sleepCtx waits for d or until ctx is done.
+//
+// Returns:
+//   - bool: False if ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// serve runs srv until ctx is done and then shuts mosaic down gracefully:
+// the ping loop stops starting probes, running probes finish, the HTTP
+// server stops accepting requests and finishes the open ones, WebSocket
+// clients get a close frame, queued notifications are delivered, and
+// queued results are written to -history-db and -results-log and pushed
+// to sinks such as -otlp-endpoint. All of it within shutdownGrace.
+//
+// Parameters:
+//   - ctx: Done when mosaic is asked to stop
+//   - srv: The HTTP server to run
+//   - loopDone: Closed when the ping loop has returned
+//
+// Returns:
+//   - error: If the server fails to start or run
+func serve(ctx context.Context, srv *http.Server, loopDone <-chan struct{}) error {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Println("Shutting down")
+	grace, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+
+	select {
+	case <-loopDone:
+		if err := probes.drain(grace); err != nil {
+			log.Printf("Shutdown: probes still running: %v", err)
+		}
+	case <-grace.Done():
+		// The loop may still be submitting, so the pool stays open
+		log.Printf("Shutdown: ping loop still running: %v", grace.Err())
+	}
+	sharedICMP.close()
+	// Shutdown does not track hijacked connections, so WebSocket clients are
+	// closed separately
+	closeClients()
+	if err := srv.Shutdown(grace); err != nil {
+		log.Printf("Shutdown: HTTP server: %v", err)
+	}
+	if err := notifications.drain(grace); err != nil {
+		log.Printf("Shutdown: notifications not delivered: %v", err)
+	}
+	if history != nil {
+		if err := history.close(grace); err != nil {
+			log.Printf("Shutdown: history not written: %v", err)
+		}
+	}
+	if resultLog != nil {
+		if err := resultLog.close(grace); err != nil {
+			log.Printf("Shutdown: results log not written: %v", err)
+		}
+	}
+	if err := sinks.drain(grace); err != nil {
+		log.Printf("Shutdown: results not pushed: %v", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// closeClients sends every WebSocket client a normal close frame and
+// forgets it.
+func closeClients() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for ws := range clients {
+		ws.Close()
+		delete(clients, ws)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func TestSleepCtx(t *testing.T) {
+	assert.True(t, sleepCtx(context.Background(), time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	assert.False(t, sleepCtx(ctx, time.Hour))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.False(t, sleepCtx(ctx, 0))
+}
+
+func TestProbePoolDrain(t *testing.T) {
+	p := newProbePool(2)
+	var finished atomic.Int32
+	for i := 0; i < 3; i++ {
+		p.submit(func() {
+			time.Sleep(20 * time.Millisecond)
+			finished.Add(1)
+		})
+	}
+	assert.NoError(t, p.drain(context.Background()))
+	assert.Equal(t, int32(3), finished.Load(), "running and queued probes finish")
+
+	p = newProbePool(1)
+	p.submit(func() { time.Sleep(time.Second) })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.drain(ctx), context.DeadlineExceeded)
+}
+
+func TestNotifyDrain(t *testing.T) {
+	var waits []time.Duration
+	d := testDispatcher(&waits)
+	n := &flakyNotifier{failures: 1, done: make(chan struct{}, 2)}
+	d.add(n)
+	d.dispatch(Event{Type: "self_alert", Message: "queued"})
+
+	assert.NoError(t, d.drain(context.Background()))
+	assert.Len(t, n.delivered, 1, "queued notifications are delivered before exit")
+	d.dispatch(Event{Type: "self_alert", Message: "too late"})
+	assert.Len(t, n.delivered, 1)
+	assert.NoError(t, d.drain(context.Background()), "draining twice is harmless")
+}
+
+func TestPingLoopStops(t *testing.T) {
+	withHosts(t, "sim://a?latency=0s")
+	defer func(saved *schedulerStats) { scheduler = saved }(scheduler)
+	scheduler = newSchedulerStats()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pingLoop(ctx, false)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pingLoop did not return after cancel")
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	defer func(p *probePool, e *icmpEngine, d *notifyDispatcher) { probes, sharedICMP, notifications = p, e, d }(probes, sharedICMP, notifications)
+	probes, sharedICMP, notifications = newProbePool(2), newICMPEngine(), newNotifyDispatcher(time.Second, time.Second)
+
+	// A connected WebSocket client gets closed
+	ts := httptest.NewServer(websocket.Handler(wsHandler))
+	defer ts.Close()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", "", ts.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer ws.Close()
+	assert.Eventually(t, func() bool {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		return len(clients) > 0
+	}, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	loopDone := make(chan struct{})
+	close(loopDone)
+	errc := make(chan error, 1)
+	go func() { errc <- serve(ctx, &http.Server{Addr: "127.0.0.1:0"}, loopDone) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after cancel")
+	}
+	var msg string
+	assert.Error(t, websocket.Message.Receive(ws, &msg), "the client sees the connection close")
+}
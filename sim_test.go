@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeSim(t *testing.T) {
+	defer func(saved *simulator) { simulation = saved }(simulation)
+	simulation = newSimulator()
+	simulation.latency = time.Millisecond
+
+	res := probeSim("edge01?latency=3ms")
+	assert.Equal(t, 1, res.Recv)
+	assert.Equal(t, 3*time.Millisecond, res.Latency)
+
+	simulation.setDown("edge01", true)
+	assert.Equal(t, 0, probeSim("edge01").Recv)
+	simulation.setDown("edge01", false)
+	assert.Equal(t, 1, probeSim("edge01").Recv)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// resultSink pushes the probe results of every cycle to an external
+// system, such as an OpenTelemetry collector. Push returns an error for
+// failed pushes, which are retried unless it wraps errSinkRejected.
+type resultSink interface {
+	// Name identifies the sink in logs
+	Name() string
+	// Push delivers the results of the cycle at now, giving up when ctx is
+	// done
+	Push(ctx context.Context, now time.Time, statuses []HostStatus) error
+}
+
+// eventSink is a resultSink that also delivers events, such as a host
+// going down.
+type eventSink interface {
+	resultSink
+	// PushEvent delivers e, giving up when ctx is done
+	PushEvent(ctx context.Context, e Event) error
+}
+
+// errSinkRejected marks a push the receiving system refused, e.g. with
+// HTTP 400, which would fail the same way again and is not retried.
+var errSinkRejected = errors.New("rejected")
+
+const (
+	// sinkAttempts is how often a push is tried before its results are
+	// dropped.
+	sinkAttempts = 4
+	// sinkQueueSize is how many cycles of results may wait per sink.
+	sinkQueueSize = 64
+	// sinkTimeout bounds a single push.
+	sinkTimeout = 10 * time.Second
+)
+
+// sinkBatch is the results of a cycle, or an event, waiting to be pushed.
+type sinkBatch struct {
+	now      time.Time
+	statuses []HostStatus
+	event    *Event // Set for an event, pushed to event sinks only
+}
+
+// String describes b for logs.
+func (b sinkBatch) String() string {
+	if b.event != nil {
+		return "event " + b.event.Type
+	}
+	return fmt.Sprintf("%d results", len(b.statuses))
+}
+
+// sinkDispatcher hands the results of every cycle to every sink, and
+// events to those that take them. Like
+// notifications, each sink has its own queue and worker, so a slow or
+// unreachable one neither delays the others nor the ping loop. Failed
+// pushes are retried with exponential backoff and then dropped: the next
+// cycle brings fresh results anyway.
+type sinkDispatcher struct {
+	mu         sync.Mutex
+	queues     map[resultSink]chan sinkBatch
+	backoff    time.Duration // Wait before the first retry, doubled after each
+	maxBackoff time.Duration
+	sleep      func(time.Duration)
+	workers    sync.WaitGroup // Sink workers still pushing
+	closed     bool           // Set by drain; results are no longer queued
+}
+
+// sinks pushes results to the sinks configured by flags such as
+// -otlp-endpoint.
+var sinks = newSinkDispatcher(time.Second, 10*time.Second)
+
+// newSinkDispatcher creates a dispatcher without sinks.
+func newSinkDispatcher(backoff, maxBackoff time.Duration) *sinkDispatcher {
+	return &sinkDispatcher{queues: make(map[resultSink]chan sinkBatch), backoff: backoff, maxBackoff: maxBackoff, sleep: time.Sleep}
+}
+
+// add starts pushing results to s.
+func (d *sinkDispatcher) add(s resultSink) {
+	q := make(chan sinkBatch, sinkQueueSize)
+	d.mu.Lock()
+	d.queues[s] = q
+	d.mu.Unlock()
+	d.workers.Add(1)
+	go func() {
+		defer d.workers.Done()
+		for b := range q {
+			d.push(s, b)
+		}
+	}()
+}
+
+// record queues the results of a cycle for every sink, labeled like the
+// status the dashboard gets. Results that don't fit in a full queue are
+// dropped and logged.
+func (d *sinkDispatcher) record(statuses []HostStatus, now time.Time) {
+	if len(statuses) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || len(d.queues) == 0 {
+		return
+	}
+	statuses = slices.Clone(statuses)
+	annotateLabels(statuses)
+	for s, q := range d.queues {
+		select {
+		case q <- sinkBatch{now: now, statuses: statuses}:
+		default:
+			log.Printf("Sink %s: queue full, dropped %d results", s.Name(), len(statuses))
+		}
+	}
+}
+
+// recordEvent queues e for every event sink. An event that doesn't fit in
+// a full queue is dropped and logged.
+func (d *sinkDispatcher) recordEvent(e Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	for s, q := range d.queues {
+		if _, ok := s.(eventSink); !ok {
+			continue
+		}
+		select {
+		case q <- sinkBatch{now: e.Time, event: &e}:
+		default:
+			log.Printf("Sink %s: queue full, dropped event %s", s.Name(), e.Type)
+		}
+	}
+}
+
+// push tries to push b to s until it succeeds, is rejected or sinkAttempts
+// attempts failed, waiting backoff, 2*backoff, ... up to maxBackoff between
+// attempts.
+func (d *sinkDispatcher) push(s resultSink, b sinkBatch) {
+	wait := d.backoff
+	for attempt := 1; attempt <= sinkAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+		var err error
+		if b.event != nil {
+			err = s.(eventSink).PushEvent(ctx, *b.event)
+		} else {
+			err = s.Push(ctx, b.now, b.statuses)
+		}
+		cancel()
+		if err == nil {
+			return
+		}
+		if errors.Is(err, errSinkRejected) {
+			log.Printf("Sink %s: dropped %s: %v", s.Name(), b, err)
+			return
+		}
+		if attempt == sinkAttempts {
+			log.Printf("Sink %s: dropped %s after %d attempts: %v", s.Name(), b, sinkAttempts, err)
+			return
+		}
+		log.Printf("Sink %s failed (attempt %d/%d): %v", s.Name(), attempt, sinkAttempts, err)
+		d.sleep(wait)
+		if wait *= 2; wait > d.maxBackoff {
+			wait = d.maxBackoff
+		}
+	}
+}
+
+// drain stops queueing results and waits until the results already queued
+// are pushed or dropped, or ctx is done.
+//
+// Returns:
+//   - error: ctx's error if results were still pending when it was done
+func (d *sinkDispatcher) drain(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, q := range d.queues {
+			close(q)
+		}
+	}
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// postSink posts body to url with the given content type and headers. Any
+// status other than 2xx is a failure; a 4xx status other than 408 and 429
+// wraps errSinkRejected, since sending the same body again will not help.
+func postSink(ctx context.Context, url, contentType string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s returned %s", errSinkRejected, url, resp.Status)
+	}
+	return fmt.Errorf("%s returned %s", url, resp.Status)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakySink fails its first failures pushes with err and records the rest.
+type flakySink struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	attempts int
+	pushed   [][]HostStatus
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+
+func (s *flakySink) Push(ctx context.Context, now time.Time, statuses []HostStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.attempts <= s.failures {
+		return s.err
+	}
+	s.pushed = append(s.pushed, statuses)
+	return nil
+}
+
+func TestSinkRetries(t *testing.T) {
+	var waits []time.Duration
+	d := newSinkDispatcher(time.Second, 2*time.Second)
+	d.sleep = func(w time.Duration) { waits = append(waits, w) }
+
+	s := &flakySink{failures: 2, err: errors.New("connection refused")}
+	d.push(s, sinkBatch{statuses: []HostStatus{{Host: "a"}}})
+	assert.Equal(t, 3, s.attempts)
+	assert.Len(t, s.pushed, 1)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, waits)
+
+	waits = nil
+	s = &flakySink{failures: sinkAttempts, err: errors.New("connection refused")}
+	d.push(s, sinkBatch{statuses: []HostStatus{{Host: "a"}}})
+	assert.Equal(t, sinkAttempts, s.attempts, "dropped after the last attempt")
+	assert.Empty(t, s.pushed)
+
+	s = &flakySink{failures: 1, err: fmt.Errorf("%w: bad request", errSinkRejected)}
+	d.push(s, sinkBatch{statuses: []HostStatus{{Host: "a"}}})
+	assert.Equal(t, 1, s.attempts, "a rejected push is not retried")
+}
+
+func TestSinkRecordAndDrain(t *testing.T) {
+	defer setLabels(labels())
+	setLabels(map[string]HostLabel{"10.0.0.1": {Name: "core-sw", Groups: []string{"core"}}})
+	d := newSinkDispatcher(time.Millisecond, time.Millisecond)
+	s := &flakySink{}
+	d.add(s)
+	fresh := []HostStatus{{Host: "10.0.0.1", Alive: true}}
+	d.record(fresh, time.Now())
+	assert.NoError(t, d.drain(context.Background()))
+	d.record(fresh, time.Now())
+	if assert.Len(t, s.pushed, 1, "nothing is queued after drain") {
+		assert.Equal(t, "core-sw", s.pushed[0][0].Name)
+		assert.Equal(t, []string{"core"}, s.pushed[0][0].Groups)
+	}
+	assert.Empty(t, fresh[0].Name, "the cycle's statuses are not changed")
+}
+
+// eventRecorder is a flakySink that also records events.
+type eventRecorder struct {
+	flakySink
+	events []Event
+}
+
+func (s *eventRecorder) PushEvent(ctx context.Context, e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestSinkRecordEvent(t *testing.T) {
+	d := newSinkDispatcher(time.Millisecond, time.Millisecond)
+	results, events := &flakySink{}, &eventRecorder{}
+	d.add(results)
+	d.add(events)
+	d.recordEvent(Event{Type: "host_down", Hosts: []string{"10.0.0.1"}})
+	assert.NoError(t, d.drain(context.Background()))
+	assert.Zero(t, results.attempts, "events only go to event sinks")
+	if assert.Len(t, events.events, 1) {
+		assert.Equal(t, "host_down", events.events[0].Type)
+	}
+	assert.Empty(t, events.pushed)
+}
+
+func TestPostSink(t *testing.T) {
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	post := func() error {
+		return postSink(context.Background(), srv.URL, "text/plain", map[string]string{"X-Token": "secret"}, []byte("x"))
+	}
+	assert.NoError(t, post())
+	status = http.StatusBadRequest
+	assert.ErrorIs(t, post(), errSinkRejected)
+	status = http.StatusTooManyRequests
+	err := post()
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errSinkRejected, "retried")
+	status = http.StatusServiceUnavailable
+	assert.NotErrorIs(t, post(), errSinkRejected)
+}
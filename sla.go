@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SLAEntry summarises the availability of a single host and the business
+// impact of its downtime since monitoring started.
+type SLAEntry struct {
+	Host          string  `json:"host"`           // Hostname or IP address being monitored
+	Weight        float64 `json:"weight"`         // Criticality weight / cost per minute of downtime
+	UptimePercent float64 `json:"uptime_percent"` // Share of observed time the host was up (0-100)
+	DownMinutes   float64 `json:"down_minutes"`   // Observed downtime in minutes
+	ImpactMinutes float64 `json:"impact_minutes"` // DownMinutes multiplied by Weight
+}
+
+// SLAGroup summarises the availability of the hosts of an inventory, label
+// or discovery group in an SLA report from the history.
+type SLAGroup struct {
+	Group         string  `json:"group"`
+	Hosts         int     `json:"hosts"`          // Hosts of the group with results in the report
+	UptimePercent float64 `json:"uptime_percent"` // Share of the observed time of its hosts they were up (0-100)
+	DownMinutes   float64 `json:"down_minutes"`   // Sum of the DownMinutes of its hosts
+	ImpactMinutes float64 `json:"impact_minutes"` // Sum of the ImpactMinutes of its hosts
+}
+
+// SLAReport is the payload served by /api/sla. Hosts are ordered by impact so
+// the chronic offenders worth fixing first are at the top.
+type SLAReport struct {
+	Since              time.Time  `json:"since"`                      // When observation started
+	Until              *time.Time `json:"until,omitempty"`            // End of a report from the history
+	ExcludedMinutes    float64    `json:"excluded_minutes,omitempty"` // Time left out of a report from the history
+	TotalImpactMinutes float64    `json:"total_impact_minutes"`       // Sum of ImpactMinutes over all hosts
+	Hosts              []SLAEntry `json:"hosts"`                      // Per-host breakdown, highest impact first
+	Groups             []SLAGroup `json:"groups,omitempty"`           // Per-group breakdown of a report from the history, highest impact first
+}
+
+// slaWindow is a time range left out of an SLA report, e.g. a planned
+// maintenance window.
+type slaWindow struct {
+	start, end time.Time
+}
+
+// slaWindows is how far back the window parameter of /api/sla reaches.
+var slaWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// slaTracker accumulates observed and down time per host.
+type slaTracker struct {
+	mu       sync.Mutex
+	since    time.Time
+	last     time.Time
+	weights  map[string]float64
+	observed map[string]time.Duration
+	down     map[string]time.Duration
+}
+
+var sla = newSLATracker(nil)
+
+// newSLATracker creates a tracker using the given per-host weights.
+// Hosts without an explicit weight count with a weight of 1.
+func newSLATracker(weights map[string]float64) *slaTracker {
+	if weights == nil {
+		weights = make(map[string]float64)
+	}
+	return &slaTracker{
+		weights:  weights,
+		observed: make(map[string]time.Duration),
+		down:     make(map[string]time.Duration),
+	}
+}
+
+// weight returns the configured weight for host, defaulting to 1.
+func (t *slaTracker) weight(host string) float64 {
+	if w, ok := t.weights[host]; ok {
+		return w
+	}
+	return 1
+}
+
+// record attributes the time elapsed since the previous call to the state
+// each host was found in. The first call only marks the start of observation.
+//
+// Parameters:
+//   - statuses: Result of the latest ping cycle
+//   - now: Time at which the cycle finished
+func (t *slaTracker) record(statuses []HostStatus, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last.IsZero() {
+		t.since = now
+		t.last = now
+		return
+	}
+	elapsed := now.Sub(t.last)
+	t.last = now
+	for _, s := range statuses {
+		// Planned maintenance is not downtime
+		if s.Paused {
+			continue
+		}
+		t.observed[s.Host] += elapsed
+		if !s.Alive {
+			t.down[s.Host] += elapsed
+		}
+	}
+}
+
+// report builds an SLAReport from the accumulated observations.
+func (t *slaTracker) report() SLAReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rep := SLAReport{Since: t.since, Hosts: []SLAEntry{}}
+	for host, observed := range t.observed {
+		down := t.down[host]
+		e := SLAEntry{
+			Host:          host,
+			Weight:        t.weight(host),
+			UptimePercent: 100,
+			DownMinutes:   down.Minutes(),
+		}
+		if observed > 0 {
+			e.UptimePercent = 100 * float64(observed-down) / float64(observed)
+		}
+		e.ImpactMinutes = e.DownMinutes * e.Weight
+		rep.TotalImpactMinutes += e.ImpactMinutes
+		rep.Hosts = append(rep.Hosts, e)
+	}
+	sortSLAEntries(rep.Hosts)
+	return rep
+}
+
+// sortSLAEntries orders entries by impact, highest first, and by host.
+func sortSLAEntries(entries []SLAEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].ImpactMinutes != entries[j].ImpactMinutes {
+			return entries[i].ImpactMinutes > entries[j].ImpactMinutes
+		}
+		return entries[i].Host < entries[j].Host
+	})
+}
+
+// slaHandler serves the availability and downtime impact report as JSON:
+// since startup, or from the stored history with any of window (day, week
+// or month back from now), since and until, which take the same times as
+// for /api/history. The latter may leave out time ranges with exclude,
+// repeatable, and narrow the hosts down to a group.
+//
+//	GET /api/sla?window=month&exclude=2024-05-04T22:00:00Z/2024-05-05T02:00:00Z
+func slaHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !q.Has("window") && !q.Has("since") && !q.Has("until") && !q.Has("exclude") && !q.Has("group") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sla.report())
+		return
+	}
+	if history == nil {
+		http.Error(w, "no history is kept", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	since, until := now.Add(-24*time.Hour), now
+	if s := q.Get("window"); s != "" {
+		d, ok := slaWindows[s]
+		if !ok {
+			http.Error(w, "invalid window, want day, week or month", http.StatusBadRequest)
+			return
+		}
+		since = now.Add(-d)
+	}
+	var err error
+	if s := q.Get("since"); s != "" {
+		if since, err = parseHistoryTime(s, now); err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("until"); s != "" {
+		if until, err = parseHistoryTime(s, now); err != nil {
+			http.Error(w, "invalid until", http.StatusBadRequest)
+			return
+		}
+	}
+	if !since.Before(until) {
+		http.Error(w, "since must be before until", http.StatusBadRequest)
+		return
+	}
+	var exclude []slaWindow
+	for _, s := range q["exclude"] {
+		win, err := parseSLAWindow(s, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		exclude = append(exclude, win)
+	}
+	keep := func(string) bool { return true }
+	if group := q.Get("group"); group != "" {
+		members := groupMembers(group)
+		keep = func(h string) bool { return slices.Contains(members, h) }
+	}
+	rep, err := sla.historyReport(history.store, since, until, exclude, keep)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// historyReport builds an SLAReport over [since, until) from the results in
+// store, leaving out the exclude windows. A result stands for the time until
+// the next probe of its host: its interval while up, and its -down-interval
+// while down, if that is shorter. A downsampled result counts as the samples
+// it stands for, so uptimes stay exact past the retention. Hosts in
+// maintenance are not probed and have no results while paused, so their
+// pauses never count.
+//
+// Parameters:
+//   - store: The history to report on
+//   - since, until: The range of the report
+//   - exclude: Windows within the range to leave out
+//   - keep: Whether to report on a host
+//
+// Returns:
+//   - SLAReport: The report, with a breakdown per group
+//   - error: If the history cannot be read
+func (t *slaTracker) historyReport(store historyStore, since, until time.Time, exclude []slaWindow, keep func(string) bool) (SLAReport, error) {
+	type tally struct{ results, up int }
+	byHost := make(map[string]*tally)
+	ranges := slaRanges(since, until, exclude)
+	rep := SLAReport{Since: since, Until: &until, Hosts: []SLAEntry{}}
+	rep.ExcludedMinutes = until.Sub(since).Minutes()
+	for _, r := range ranges {
+		rep.ExcludedMinutes -= r.end.Sub(r.start).Minutes()
+		buckets, err := store.buckets("", r.start, r.end, r.end.Sub(r.start))
+		if err != nil {
+			return rep, err
+		}
+		for _, b := range buckets {
+			if !keep(b.Host) {
+				continue
+			}
+			n := byHost[b.Host]
+			if n == nil {
+				n = &tally{}
+				byHost[b.Host] = n
+			}
+			n.results += b.Results
+			n.up += b.Up
+		}
+	}
+	groups := make(map[string]*SLAGroup)
+	groupUp := make(map[string]float64) // Minutes the hosts of a group were up
+	for host, n := range byHost {
+		upMinutes := float64(n.up) * settingsFor(host).Interval.Minutes()
+		e := SLAEntry{
+			Host:          host,
+			Weight:        t.weight(host),
+			UptimePercent: 100,
+			DownMinutes:   float64(n.results-n.up) * slaDownInterval(host).Minutes(),
+		}
+		if observed := upMinutes + e.DownMinutes; observed > 0 {
+			e.UptimePercent = 100 * upMinutes / observed
+		}
+		e.ImpactMinutes = e.DownMinutes * e.Weight
+		rep.TotalImpactMinutes += e.ImpactMinutes
+		rep.Hosts = append(rep.Hosts, e)
+		for _, name := range hostGroups(host) {
+			g := groups[name]
+			if g == nil {
+				g = &SLAGroup{Group: name}
+				groups[name] = g
+			}
+			g.Hosts++
+			g.DownMinutes += e.DownMinutes
+			g.ImpactMinutes += e.ImpactMinutes
+			groupUp[name] += upMinutes
+		}
+	}
+	sortSLAEntries(rep.Hosts)
+	for name, g := range groups {
+		g.UptimePercent = 100
+		if observed := groupUp[name] + g.DownMinutes; observed > 0 {
+			g.UptimePercent = 100 * groupUp[name] / observed
+		}
+		rep.Groups = append(rep.Groups, *g)
+	}
+	sort.Slice(rep.Groups, func(i, j int) bool {
+		if rep.Groups[i].ImpactMinutes != rep.Groups[j].ImpactMinutes {
+			return rep.Groups[i].ImpactMinutes > rep.Groups[j].ImpactMinutes
+		}
+		return rep.Groups[i].Group < rep.Groups[j].Group
+	})
+	return rep, nil
+}
+
+// slaDownInterval returns how often host is probed while it is down: every
+// -down-interval if that is shorter than its own interval.
+func slaDownInterval(host string) time.Duration {
+	interval := settingsFor(host).Interval
+	if downInterval > 0 && downInterval < interval {
+		return downInterval
+	}
+	return interval
+}
+
+// slaRanges returns the parts of [since, until) outside of the exclude
+// windows, in order.
+func slaRanges(since, until time.Time, exclude []slaWindow) []slaWindow {
+	sorted := slices.Clone(exclude)
+	slices.SortFunc(sorted, func(a, b slaWindow) int { return a.start.Compare(b.start) })
+	var ranges []slaWindow
+	start := since
+	for _, w := range sorted {
+		if w.start.After(start) {
+			end := w.start
+			if end.After(until) {
+				end = until
+			}
+			ranges = append(ranges, slaWindow{start, end})
+		}
+		if w.end.After(start) {
+			start = w.end
+		}
+		if !start.Before(until) {
+			return ranges
+		}
+	}
+	return append(ranges, slaWindow{start, until})
+}
+
+// parseSLAWindow parses an exclude parameter of /api/sla: two times as
+// /api/history takes them separated by a slash, e.g.
+// "2024-05-04T22:00:00Z/2024-05-05T02:00:00Z".
+func parseSLAWindow(s string, now time.Time) (slaWindow, error) {
+	from, to, ok := strings.Cut(s, "/")
+	if !ok {
+		return slaWindow{}, fmt.Errorf("invalid exclude %q: expected start/end", s)
+	}
+	start, err := parseHistoryTime(from, now)
+	if err != nil {
+		return slaWindow{}, fmt.Errorf("invalid exclude %q: %v", s, err)
+	}
+	end, err := parseHistoryTime(to, now)
+	if err != nil {
+		return slaWindow{}, fmt.Errorf("invalid exclude %q: %v", s, err)
+	}
+	if !start.Before(end) {
+		return slaWindow{}, fmt.Errorf("invalid exclude %q: start must be before end", s)
+	}
+	return slaWindow{start, end}, nil
+}
+
+// parseWeights parses a comma-separated list of host=weight pairs as given
+// on the command line, e.g. "db01=5,10.0.0.1=0.5".
+//
+// Parameters:
+//   - s: The raw flag value
+//
+// Returns:
+//   - map[string]float64: Weight per host
+//   - error: If a pair is malformed or the weight is negative
+func parseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		host, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid weight %q: expected host=weight", pair)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", host, val)
+		}
+		weights[strings.TrimSpace(host)] = w
+	}
+	return weights, nil
+}
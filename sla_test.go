@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWeights(t *testing.T) {
+	weights, err := parseWeights("db01=5, web01 = 0.5,,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"db01": 5, "web01": 0.5}, weights)
+
+	_, err = parseWeights("db01")
+	assert.Error(t, err)
+	_, err = parseWeights("db01=-1")
+	assert.Error(t, err)
+	_, err = parseWeights("db01=abc")
+	assert.Error(t, err)
+}
+
+func TestSLATrackerReport(t *testing.T) {
+	tracker := newSLATracker(map[string]float64{"db": 10})
+	start := time.Now()
+
+	// The first call only marks the start of observation
+	tracker.record([]HostStatus{{Host: "db", Alive: false}, {Host: "web", Alive: false}}, start)
+	tracker.record([]HostStatus{{Host: "db", Alive: false}, {Host: "web", Alive: false}}, start.Add(time.Minute))
+	tracker.record([]HostStatus{{Host: "db", Alive: true}, {Host: "web", Alive: false}}, start.Add(2*time.Minute))
+
+	rep := tracker.report()
+	assert.Equal(t, start, rep.Since)
+	assert.Len(t, rep.Hosts, 2)
+
+	// db was down one minute out of two, but weighs 10x
+	assert.Equal(t, "db", rep.Hosts[0].Host)
+	assert.InDelta(t, 50.0, rep.Hosts[0].UptimePercent, 0.001)
+	assert.InDelta(t, 1.0, rep.Hosts[0].DownMinutes, 0.001)
+	assert.InDelta(t, 10.0, rep.Hosts[0].ImpactMinutes, 0.001)
+
+	assert.Equal(t, "web", rep.Hosts[1].Host)
+	assert.Equal(t, 1.0, rep.Hosts[1].Weight)
+	assert.InDelta(t, 0.0, rep.Hosts[1].UptimePercent, 0.001)
+	assert.InDelta(t, 2.0, rep.Hosts[1].ImpactMinutes, 0.001)
+
+	assert.InDelta(t, 12.0, rep.TotalImpactMinutes, 0.001)
+}
+
+func TestSLATrackerSkipsPaused(t *testing.T) {
+	tracker := newSLATracker(nil)
+	start := time.Now()
+	tracker.record([]HostStatus{{Host: "db", Alive: true}}, start)
+	tracker.record([]HostStatus{{Host: "db", Paused: true}}, start.Add(time.Minute))
+	tracker.record([]HostStatus{{Host: "db", Alive: true}}, start.Add(2*time.Minute))
+
+	rep := tracker.report()
+	if assert.Len(t, rep.Hosts, 1) {
+		assert.Equal(t, 100.0, rep.Hosts[0].UptimePercent, "maintenance is not downtime")
+	}
+}
+
+func TestSLAHandler(t *testing.T) {
+	old := sla
+	defer func() { sla = old }()
+	sla = newSLATracker(nil)
+
+	rec := httptest.NewRecorder()
+	slaHandler(rec, httptest.NewRequest("GET", "/api/sla", nil))
+
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var rep SLAReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rep))
+	assert.Empty(t, rep.Hosts)
+}
+
+func TestSLAHandlerHistory(t *testing.T) {
+	withHosts(t, "db", "web1", "web2")
+	old := sla
+	defer func() { sla = old }()
+	sla = newSLATracker(map[string]float64{"db": 10})
+	defer func(h *resultHistory) { history = h }(history)
+	s := newMemoryStore(10)
+	history = &resultHistory{store: s}
+	for _, h := range []string{"web1", "web2"} {
+		setDiscoveredGroups(h, []string{"web"})
+		defer setDiscoveredGroups(h, nil)
+	}
+	at := time.Now().Add(-2 * time.Hour).Truncate(time.Minute)
+	minute := func(n int) time.Time { return at.Add(time.Duration(n) * time.Minute) }
+	s.write([]HistoryResult{
+		{Time: minute(0), Host: "db", State: "up"},
+		{Time: minute(0), Host: "web1", State: "up"},
+		{Time: minute(1), Host: "db", State: "down", PacketLoss: 100},
+		{Time: minute(1), Host: "web1", State: "up"},
+		{Time: minute(1), Host: "web2", State: "down", PacketLoss: 100},
+		{Time: minute(2), Host: "db", State: "down", PacketLoss: 100},
+		{Time: minute(3), Host: "db", State: "up"},
+	})
+	get := func(query string) (int, SLAReport) {
+		rec := httptest.NewRecorder()
+		slaHandler(rec, httptest.NewRequest("GET", "/api/sla?"+query, nil))
+		var rep SLAReport
+		if rec.Code == 200 {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rep))
+		}
+		return rec.Code, rep
+	}
+	interval := settingsFor("db").Interval.Minutes()
+
+	code, rep := get("window=day")
+	assert.Equal(t, 200, code)
+	assert.NotNil(t, rep.Until)
+	if assert.Len(t, rep.Hosts, 3) {
+		assert.Equal(t, "db", rep.Hosts[0].Host, "weighs 10")
+		assert.InDelta(t, 50.0, rep.Hosts[0].UptimePercent, 0.001)
+		assert.InDelta(t, 2*interval, rep.Hosts[0].DownMinutes, 0.001)
+		assert.InDelta(t, 20*interval, rep.Hosts[0].ImpactMinutes, 0.001)
+	}
+	if assert.Len(t, rep.Groups, 1) {
+		assert.Equal(t, SLAGroup{Group: "web", Hosts: 2, UptimePercent: 200.0 / 3, DownMinutes: interval, ImpactMinutes: interval}, rep.Groups[0])
+	}
+
+	// A maintenance window is not downtime
+	code, rep = get("window=day&exclude=" + minute(1).Format(time.RFC3339) + "/" + minute(2).Format(time.RFC3339))
+	assert.Equal(t, 200, code)
+	assert.InDelta(t, 1.0, rep.ExcludedMinutes, 0.001)
+	assert.Len(t, rep.Hosts, 2, "web2 has no results left")
+	if assert.Len(t, rep.Groups, 1) {
+		assert.Equal(t, 100.0, rep.Groups[0].UptimePercent)
+	}
+
+	// Down hosts are probed every -down-interval, so a failed probe stands
+	// for less time
+	defer func(d time.Duration) { downInterval = d }(downInterval)
+	downInterval = settingsFor("db").Interval / 4
+	_, rep = get("window=day")
+	if assert.Len(t, rep.Hosts, 3) {
+		assert.Equal(t, "db", rep.Hosts[0].Host)
+		assert.InDelta(t, 80.0, rep.Hosts[0].UptimePercent, 0.001)
+		assert.InDelta(t, interval/2, rep.Hosts[0].DownMinutes, 0.001)
+		assert.InDelta(t, 5*interval, rep.Hosts[0].ImpactMinutes, 0.001)
+	}
+	downInterval = 0
+
+	_, rep = get("group=web&since=3h")
+	assert.Len(t, rep.Hosts, 2)
+	_, rep = get("since=30m")
+	assert.Empty(t, rep.Hosts)
+
+	code, _ = get("window=year")
+	assert.Equal(t, 400, code)
+	code, _ = get("exclude=1h")
+	assert.Equal(t, 400, code)
+	code, _ = get("exclude=1h/2h")
+	assert.Equal(t, 400, code, "start after end")
+}
+
+func TestSLAHistoryDownsampled(t *testing.T) {
+	store, err := historyBackends["sqlite"](testHistoryPath(t, "sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	withHosts(t, "db")
+	at := time.Now().Add(-2 * time.Hour).Truncate(time.Minute)
+	if err := store.write([]HistoryResult{
+		{Time: at, Host: "db", State: "up"},
+		{Time: at.Add(time.Minute), Host: "db", State: "down", PacketLoss: 100},
+		{Time: at.Add(10 * time.Minute), Host: "db", State: "degraded", PacketLoss: 50, Samples: 60, Up: 30},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	interval := settingsFor("db").Interval.Minutes()
+	rep, err := newSLATracker(map[string]float64{"db": 10}).historyReport(store, at.Add(-time.Hour), time.Now(), nil, func(string) bool { return true })
+	assert.NoError(t, err)
+	if assert.Len(t, rep.Hosts, 1) {
+		assert.InDelta(t, 50.0, rep.Hosts[0].UptimePercent, 0.001, "downsampled results count their samples")
+		assert.InDelta(t, 31*interval, rep.Hosts[0].DownMinutes, 0.001)
+		assert.InDelta(t, 310*interval, rep.Hosts[0].ImpactMinutes, 0.001)
+	}
+}
+
+func TestSLARanges(t *testing.T) {
+	at := time.Now()
+	h := func(n int) time.Time { return at.Add(time.Duration(n) * time.Hour) }
+	assert.Equal(t, []slaWindow{{h(0), h(10)}}, slaRanges(h(0), h(10), nil))
+	assert.Equal(t, []slaWindow{{h(0), h(2)}, {h(4), h(5)}, {h(7), h(10)}},
+		slaRanges(h(0), h(10), []slaWindow{{h(5), h(7)}, {h(2), h(3)}, {h(3), h(4)}}), "in order, adjacent windows joined")
+	assert.Equal(t, []slaWindow{{h(1), h(9)}}, slaRanges(h(0), h(10), []slaWindow{{h(-1), h(1)}, {h(9), h(11)}}))
+	assert.Empty(t, slaRanges(h(0), h(10), []slaWindow{{h(-1), h(11)}}))
+}
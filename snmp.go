@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snmpCommunity is the SNMPv2c community string, set with -snmp-community.
+var snmpCommunity = "public"
+
+// snmpPort is the port of SNMP agents, a variable to allow mocking in
+// tests.
+var snmpPort = "161"
+
+// BER tags of SNMP (RFC 3416).
+const (
+	berInteger       = 0x02
+	berOctetString   = 0x04
+	berNull          = 0x05
+	berOID           = 0x06
+	berSequence      = 0x30
+	snmpGetResponse  = 0xa2
+	snmpGetBulk      = 0xa5
+	snmpEndOfMibView = 0x82
+)
+
+// snmpVar is a variable binding of an SNMP response.
+type snmpVar struct {
+	oid   []int
+	tag   byte // BER tag of the value
+	value []byte
+}
+
+// String returns the value of v as text, for OCTET STRING values.
+func (v snmpVar) String() string { return string(v.value) }
+
+// Int returns the value of v as a number, for INTEGER values.
+func (v snmpVar) Int() int {
+	n := 0
+	for i, b := range v.value {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int(b)
+	}
+	return n
+}
+
+// parseOID parses a dotted OID such as "1.3.6.1.2.1.1.5".
+func parseOID(s string) []int {
+	var oid []int
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		oid = append(oid, n)
+	}
+	return oid
+}
+
+// hasPrefix reports whether oid lies below root.
+func hasPrefix(oid, root []int) bool {
+	if len(oid) <= len(root) {
+		return false
+	}
+	for i := range root {
+		if oid[i] != root[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compareOIDs orders OIDs lexicographically by arc.
+func compareOIDs(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return len(a) - len(b)
+}
+
+// berTLV encodes a BER tag-length-value.
+func berTLV(tag byte, content []byte) []byte {
+	b := []byte{tag}
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
+
+// berInt encodes an INTEGER.
+func berInt(n int) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if (n == 0 && b[0]&0x80 == 0) || (n == -1 && b[0]&0x80 != 0) {
+			return berTLV(berInteger, b)
+		}
+	}
+}
+
+// berOIDValue encodes an OBJECT IDENTIFIER.
+func berOIDValue(oid []int) []byte {
+	if len(oid) < 2 {
+		return berTLV(berOID, nil)
+	}
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		var arc []byte
+		arc = append(arc, byte(n&0x7f))
+		for n >>= 7; n > 0; n >>= 7 {
+			arc = append([]byte{byte(n&0x7f | 0x80)}, arc...)
+		}
+		b = append(b, arc...)
+	}
+	return berTLV(berOID, b)
+}
+
+// berRead splits the first tag-length-value off b.
+//
+// Returns:
+//   - byte: The tag
+//   - []byte: The content
+//   - []byte: What follows the value in b
+//   - error: If b is truncated
+func berRead(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("truncated BER value")
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < size {
+			return 0, nil, nil, errors.New("invalid BER length")
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if len(b) < n {
+		return 0, nil, nil, errors.New("truncated BER value")
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// berParseOID decodes the content of an OBJECT IDENTIFIER.
+func berParseOID(b []byte) []int {
+	if len(b) == 0 {
+		return nil
+	}
+	oid := []int{int(b[0]) / 40, int(b[0]) % 40}
+	n := 0
+	for _, c := range b[1:] {
+		n = n<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		}
+	}
+	return oid
+}
+
+// snmpBulkRequest encodes an SNMPv2c GetBulkRequest for the variables
+// following oid.
+func snmpBulkRequest(community string, id int, oid []int, repetitions int) []byte {
+	varbind := berTLV(berSequence, append(berOIDValue(oid), berNull, 0))
+	pdu := berTLV(snmpGetBulk, concat(berInt(id), berInt(0), berInt(repetitions), berTLV(berSequence, varbind)))
+	return berTLV(berSequence, concat(berInt(1), berTLV(berOctetString, []byte(community)), pdu))
+}
+
+// concat joins byte slices.
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// parseSNMPResponse decodes an SNMP response message.
+//
+// Returns:
+//   - int: The request ID
+//   - []snmpVar: The variable bindings
+//   - error: If the message is malformed or reports an error status
+func parseSNMPResponse(b []byte) (int, []snmpVar, error) {
+	tag, msg, _, err := berRead(b)
+	if err != nil || tag != berSequence {
+		return 0, nil, errors.New("invalid SNMP message")
+	}
+	for range 2 { // Version and community
+		if _, _, msg, err = berRead(msg); err != nil {
+			return 0, nil, err
+		}
+	}
+	tag, pdu, _, err := berRead(msg)
+	if err != nil || tag != snmpGetResponse {
+		return 0, nil, errors.New("not an SNMP response")
+	}
+	var ints []int
+	for range 3 { // Request ID, error status and error index
+		var v []byte
+		if _, v, pdu, err = berRead(pdu); err != nil {
+			return 0, nil, err
+		}
+		ints = append(ints, snmpVar{value: v}.Int())
+	}
+	if ints[1] != 0 {
+		return ints[0], nil, fmt.Errorf("SNMP error status %d", ints[1])
+	}
+	_, list, _, err := berRead(pdu)
+	if err != nil {
+		return 0, nil, err
+	}
+	var vars []snmpVar
+	for len(list) > 0 {
+		var vb, oid, value []byte
+		var valueTag byte
+		if _, vb, list, err = berRead(list); err != nil {
+			return 0, nil, err
+		}
+		if _, oid, vb, err = berRead(vb); err != nil {
+			return 0, nil, err
+		}
+		if valueTag, value, _, err = berRead(vb); err != nil {
+			return 0, nil, err
+		}
+		vars = append(vars, snmpVar{oid: berParseOID(oid), tag: valueTag, value: value})
+	}
+	return ints[0], vars, nil
+}
+
+// snmpWalk reads the subtree of root from the SNMPv2c agent at addr with
+// GetBulk requests, each tried twice with a timeout of -timeout.
+//
+// Parameters:
+//   - addr: host or host:port of the agent, snmpPort by default
+//   - root: Dotted OID of the subtree, e.g. "1.0.8802.1.1.2.1.4.1.1.9"
+//
+// Returns:
+//   - []snmpVar: The variables of the subtree in order
+//   - error: If the agent does not answer
+func snmpWalk(ctx context.Context, addr, root string) ([]snmpVar, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), snmpPort)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	rootOID := parseOID(root)
+	var vars []snmpVar
+	next := rootOID
+	buf := make([]byte, 65535)
+	for {
+		var idBytes [4]byte
+		rand.Read(idBytes[:])
+		id := int(binary.BigEndian.Uint32(idBytes[:]) & 0x7fffffff)
+		req := snmpBulkRequest(snmpCommunity, id, next, 25)
+		var got []snmpVar
+		for attempt := 0; ; attempt++ {
+			if _, err := conn.Write(req); err != nil {
+				return nil, err
+			}
+			conn.SetReadDeadline(time.Now().Add(probeTimeout))
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if attempt < 1 {
+					continue
+				}
+				return nil, fmt.Errorf("SNMP %s: %v", addr, err)
+			}
+			rid, v, err := parseSNMPResponse(buf[:n])
+			if err != nil {
+				return nil, fmt.Errorf("SNMP %s: %v", addr, err)
+			}
+			if rid == id {
+				got = v
+				break
+			}
+		}
+		if len(got) == 0 {
+			return vars, nil
+		}
+		for _, v := range got {
+			if v.tag == snmpEndOfMibView || !hasPrefix(v.oid, rootOID) {
+				return vars, nil
+			}
+			vars = append(vars, v)
+		}
+		last := got[len(got)-1].oid
+		if compareOIDs(last, next) <= 0 {
+			return nil, fmt.Errorf("SNMP %s: agent returned %v after %v", addr, last, next)
+		}
+		next = last
+	}
+}
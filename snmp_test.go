@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mibVar is a variable served by a fake SNMP agent.
+type mibVar struct {
+	oid   string
+	tag   byte
+	value []byte
+}
+
+// serveSNMP answers SNMPv2c GetBulk requests for community on a UDP socket
+// at addr from mib and returns the address it listens on.
+func serveSNMP(t *testing.T, addr, community string, mib []mibVar) string {
+	sort.Slice(mib, func(i, j int) bool { return compareOIDs(parseOID(mib[i].oid), parseOID(mib[j].oid)) < 0 })
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// Message: version, community, GetBulk PDU
+			_, msg, _, _ := berRead(buf[:n])
+			_, _, msg, _ = berRead(msg)
+			_, c, msg, _ := berRead(msg)
+			if string(c) != community {
+				continue
+			}
+			_, pdu, _, _ := berRead(msg)
+			_, id, pdu, _ := berRead(pdu)
+			_, _, pdu, _ = berRead(pdu)
+			_, reps, pdu, _ := berRead(pdu)
+			_, list, _, _ := berRead(pdu)
+			_, vb, _, _ := berRead(list)
+			_, oid, _, _ := berRead(vb)
+			after := berParseOID(oid)
+
+			var varbinds []byte
+			count := 0
+			for _, v := range mib {
+				if o := parseOID(v.oid); compareOIDs(o, after) > 0 && count < (snmpVar{value: reps}).Int() {
+					varbinds = append(varbinds, berTLV(berSequence, append(berOIDValue(o), berTLV(v.tag, v.value)...))...)
+					count++
+				}
+			}
+			if count == 0 {
+				varbinds = berTLV(berSequence, append(berOIDValue(after), snmpEndOfMibView, 0))
+			}
+			pduOut := berTLV(snmpGetResponse, concat(berTLV(berInteger, id), berInt(0), berInt(0), berTLV(berSequence, varbinds)))
+			conn.WriteTo(berTLV(berSequence, concat(berInt(1), berTLV(berOctetString, c), pduOut)), from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestBER(t *testing.T) {
+	for _, n := range []int{0, 1, 127, 128, 255, 256, 0x7fffffff, -1, -129} {
+		_, v, rest, err := berRead(berInt(n))
+		assert.NoError(t, err)
+		assert.Empty(t, rest)
+		assert.Equal(t, n, snmpVar{value: v}.Int(), n)
+	}
+	oid := parseOID("1.3.6.1.4.1.9.9.23.1.2.1.1.6.10001.3")
+	_, v, _, _ := berRead(berOIDValue(oid))
+	assert.Equal(t, oid, berParseOID(v))
+
+	long := make([]byte, 300)
+	_, v, _, err := berRead(berTLV(berOctetString, long))
+	assert.NoError(t, err)
+	assert.Len(t, v, 300)
+	_, _, _, err = berRead([]byte{berOctetString, 5, 1})
+	assert.Error(t, err)
+}
+
+func TestSNMPWalk(t *testing.T) {
+	oldCommunity := snmpCommunity
+	t.Cleanup(func() { snmpCommunity = oldCommunity })
+	snmpCommunity = "lab"
+	var mib []mibVar
+	mib = append(mib, mibVar{"1.3.6.1.2.1.1.5.0", berOctetString, []byte("core-sw1")})
+	for i := range 60 { // More than one GetBulk
+		mib = append(mib, mibVar{"1.3.6.1.2.1.2.2.1.2." + strconv.Itoa(i+1), berOctetString, []byte("port")})
+	}
+	mib = append(mib, mibVar{"1.3.6.1.2.1.4.1.0", berInteger, []byte{1}})
+	addr := serveSNMP(t, "127.0.0.1:0", "lab", mib)
+
+	vars, err := snmpWalk(context.Background(), addr, "1.3.6.1.2.1.2.2.1.2")
+	assert.NoError(t, err)
+	assert.Len(t, vars, 60, "the walk stops at the end of the subtree")
+	assert.Equal(t, parseOID("1.3.6.1.2.1.2.2.1.2.60"), vars[59].oid)
+
+	vars, err = snmpWalk(context.Background(), addr, "1.3.6.1.2.1.99")
+	assert.NoError(t, err)
+	assert.Empty(t, vars, "end of the MIB")
+
+	oldTimeout := probeTimeout
+	probeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { probeTimeout = oldTimeout })
+	snmpCommunity = "wrong"
+	_, err = snmpWalk(context.Background(), addr, "1.3.6.1.2.1.1.5")
+	assert.Error(t, err, "agents ignore other communities")
+}
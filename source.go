@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// probeSource is the interface name or address probes are sent from, set
+// with -source; empty lets the operating system choose. ICMP hosts can pick
+// their own with the source option ("8.8.8.8?source=wwan0") or a per-host
+// override.
+var probeSource string
+
+// interfaceAddrs is a variable to allow mocking interfaces in tests
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return iface.Addrs()
+}
+
+// validSource checks that source looks like an IP address or an interface
+// name, without requiring the interface to exist yet.
+func validSource(source string) error {
+	if net.ParseIP(source) != nil {
+		return nil
+	}
+	if source == "" || len(source) > 15 || strings.ContainsAny(source, " \t/:") {
+		return fmt.Errorf("invalid source %q: expected an IP address or interface name", source)
+	}
+	return nil
+}
+
+// sourceIP returns the address to bind probes from source to: the address
+// itself, or the first address of the named interface, IPv4 preferred.
+func sourceIP(source string) (net.IP, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		return ip, nil
+	}
+	addrs, err := interfaceAddrs(source)
+	if err != nil {
+		return nil, fmt.Errorf("source %s: %w", source, err)
+	}
+	var first net.IP
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if n.IP.To4() != nil {
+			return n.IP, nil
+		}
+		if first == nil {
+			first = n.IP
+		}
+	}
+	if first == nil {
+		return nil, fmt.Errorf("source %s has no usable address", source)
+	}
+	return first, nil
+}
+
+// probeDialer returns a dialer for TCP and UDP probes that connects from
+// probeSource, marks packets with probeDSCP and gives up after the probe
+// timeout.
+//
+// Parameters:
+//   - network: "tcp" or "udp", optionally with a 4 or 6 suffix
+//
+// Returns:
+//   - *net.Dialer: The dialer
+//   - error: If the source has no address to bind to
+func probeDialer(network string) (*net.Dialer, error) {
+	d := &net.Dialer{Timeout: probeTimeout}
+	if probeDSCP > 0 {
+		d.Control = dscpControl(probeDSCP)
+	}
+	if probeSource == "" {
+		return d, nil
+	}
+	ip, err := sourceIP(probeSource)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(network, "udp") {
+		d.LocalAddr = &net.UDPAddr{IP: ip}
+	} else {
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	return d, nil
+}
+
+// dialProbe connects to addr like net.DialTimeout with the probe timeout,
+// from probeSource.
+func dialProbe(network, addr string) (net.Conn, error) {
+	d, err := probeDialer(network)
+	if err != nil {
+		return nil, err
+	}
+	return d.Dial(network, addr)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockInterfaces makes interfaceAddrs return the given CIDRs per interface.
+func mockInterfaces(t *testing.T, ifaces map[string][]string) {
+	old := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = old })
+	interfaceAddrs = func(name string) ([]net.Addr, error) {
+		cidrs, ok := ifaces[name]
+		if !ok {
+			return nil, errors.New("no such network interface")
+		}
+		var addrs []net.Addr
+		for _, c := range cidrs {
+			ip, n, _ := net.ParseCIDR(c)
+			n.IP = ip
+			addrs = append(addrs, n)
+		}
+		return addrs, nil
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	mockInterfaces(t, map[string][]string{
+		"eth1":  {"fe80::1/64", "2001:db8::10/64", "192.0.2.10/24"},
+		"wg0":   {"fe80::2/64", "fd00::5/64"},
+		"empty": {"fe80::3/64"},
+	})
+
+	ip, err := sourceIP("eth1")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.10", ip.String(), "IPv4 is preferred")
+	ip, err = sourceIP("wg0")
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::5", ip.String(), "link-local addresses are skipped")
+	ip, err = sourceIP("198.51.100.7")
+	assert.NoError(t, err)
+	assert.Equal(t, "198.51.100.7", ip.String())
+
+	_, err = sourceIP("empty")
+	assert.Error(t, err)
+	_, err = sourceIP("eth9")
+	assert.Error(t, err)
+}
+
+func TestValidSource(t *testing.T) {
+	assert.NoError(t, validSource("eth1"))
+	assert.NoError(t, validSource("2001:db8::1"))
+	assert.Error(t, validSource(""))
+	assert.Error(t, validSource("eth1 eth2"))
+	assert.Error(t, validSource("a-very-long-interface-name"))
+
+	assert.NoError(t, validateHost("8.8.8.8?source=wwan0"))
+	assert.Error(t, validateHost("8.8.8.8?source=10.0.0.0/8"))
+}
+
+func TestProbeDialerSource(t *testing.T) {
+	defer func(s string) { probeSource = s }(probeSource)
+	probeSource = ""
+	d, err := probeDialer("tcp")
+	assert.NoError(t, err)
+	assert.Nil(t, d.LocalAddr)
+
+	probeSource = "127.0.0.1"
+	d, err = probeDialer("tcp")
+	assert.NoError(t, err)
+	assert.Equal(t, &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, d.LocalAddr)
+	d, err = probeDialer("udp")
+	assert.NoError(t, err)
+	assert.Equal(t, &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, d.LocalAddr)
+
+	// Probes connect from the source address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	conn, err := dialProbe("tcp", ln.Addr().String())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+		conn.Close()
+	}
+
+	mockInterfaces(t, map[string][]string{})
+	probeSource = "eth9"
+	_, err = dialProbe("tcp", ln.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestOverrideSource(t *testing.T) {
+	defer setOverrides(overrides())
+	host, o, _, err := parseOverride("uplink-b source=wwan0 count=2")
+	assert.NoError(t, err)
+	assert.Equal(t, "uplink-b", host)
+	assert.Equal(t, HostOverride{Count: 2, Source: "wwan0"}, o)
+	assert.Equal(t, "count=2 source=wwan0", o.String())
+
+	setOverrides(map[string]HostOverride{"8.8.8.8": o, "1.1.1.1": o})
+	assert.Equal(t, "8.8.8.8?count=2&source=wwan0", withOverride("8.8.8.8", "8.8.8.8"))
+	assert.Equal(t, "1.1.1.1?source=eth1&count=2", withOverride("1.1.1.1", "1.1.1.1?source=eth1"), "the entry's own source wins")
+
+	_, _, _, err = parseOverride("uplink-b source=10.0.0.0/8")
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// probeStagger spreads the probes of all hosts evenly over the interval,
+// set with -stagger, so mosaic sends a steady trickle of packets instead of
+// a burst at the start of every cycle.
+var probeStagger bool
+
+// staggerSlots is how many times per interval staggerLoop starts the probes
+// that fell due, so a host's probe starts at most an interval/staggerSlots
+// late.
+const staggerSlots = 20
+
+// staggerLoop is pingLoop with -stagger. New hosts get their first probe
+// spread evenly over the next interval and keep that phase, drifting apart
+// further with -jitter. Every interval/staggerSlots the hosts that fell due
+// are handed to the worker pool without waiting for them, and once per
+// interval the results that came in are broadcast like a cycle's. A cycle's
+// duration is then how long starting the probes had to wait for free
+// workers, so /api/scheduler reports an overrun only when the pool cannot
+// keep up. It returns once ctx is done.
+//
+// Parameters:
+//   - ctx: Stops the loop when done
+//   - showLoss: If true, the dashboard will display packet loss instead of latency
+func staggerLoop(ctx context.Context, showLoss bool) {
+	for ctx.Err() == nil {
+		runStaggered(ctx, showLoss)
+	}
+}
+
+// runStaggered runs one interval of staggerLoop, ending it early when ctx is
+// done.
+//
+// Returns:
+//   - []HostStatus: Status of each host whose probe finished during the interval
+func runStaggered(ctx context.Context, showLoss bool) []HostStatus {
+	applyPendingSettings()
+	start := time.Now()
+	expireHosts(start)
+	maintenance.expire(start)
+	interval := pingInterval
+	scheduler.beginCycle(nil, start)
+	var busy time.Duration
+	var hosts []string
+	for slot := 0; slot < staggerSlots; slot++ {
+		if !sleepCtx(ctx, time.Until(start.Add(interval*time.Duration(slot)/staggerSlots))) {
+			break
+		}
+		now := time.Now()
+		hosts = currentHosts()
+		hosts = dedup.collapse(hosts, scheduler.results(hosts))
+		scheduler.stagger(hosts, now, interval)
+		due := scheduler.due(enabledHosts(maintenance.active(hosts)), now)
+		scheduler.launch(due, now)
+		for _, host := range due {
+			submitProbe(host, func() {})
+		}
+		busy += time.Since(now)
+	}
+	sleepCtx(ctx, time.Until(start.Add(interval)))
+	fresh := scheduler.drainFresh()
+	finishCycle(hosts, fresh, start, busy, showLoss)
+	scheduler.endCycle(interval, start.Add(busy))
+	return fresh
+}
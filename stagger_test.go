@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulerStagger(t *testing.T) {
+	s := newSchedulerStats()
+	now := time.Now()
+	hosts := []string{"a", "b", "c", "d"}
+	s.stagger(hosts, now, 4*time.Second)
+	for i, h := range hosts {
+		assert.Equal(t, now.Add(time.Duration(i)*time.Second), s.byHost[h].NextProbe, h)
+	}
+	assert.Equal(t, []string{"a"}, s.due(hosts, now))
+	assert.Equal(t, []string{"a", "b", "c"}, s.due(hosts, now.Add(2*time.Second)))
+
+	// Known hosts keep their phase; new ones are spread over the interval
+	s.stagger(append(hosts, "e", "f"), now.Add(time.Second), 4*time.Second)
+	assert.Equal(t, now.Add(time.Second), s.byHost["b"].NextProbe)
+	assert.Equal(t, now.Add(3*time.Second), s.byHost["f"].NextProbe)
+}
+
+func TestSchedulerDrainFresh(t *testing.T) {
+	s := newSchedulerStats()
+	now := time.Now()
+	s.beginCycle([]string{"a", "b"}, now)
+	s.store(HostStatus{Host: "b", Alive: true})
+	s.store(HostStatus{Host: "a"})
+	s.forget("b")
+	s.store(HostStatus{Host: "gone"})
+	assert.Equal(t, []HostStatus{{Host: "a"}}, s.drainFresh(), "forgotten hosts are dropped")
+	assert.Empty(t, s.drainFresh())
+}
+
+func TestRunStaggered(t *testing.T) {
+	withHosts(t, "sim://a?latency=0s", "sim://b?latency=0s")
+	defer func(saved *schedulerStats, d time.Duration) { scheduler, pingInterval = saved, d }(scheduler, pingInterval)
+	scheduler = newSchedulerStats()
+	pingInterval = 100 * time.Millisecond
+
+	start := time.Now()
+	fresh := runStaggered(context.Background(), false)
+	assert.GreaterOrEqual(t, time.Since(start), pingInterval, "an interval is broadcast as a whole")
+	assert.Len(t, fresh, 2)
+	snap := scheduler.snapshot()
+	assert.Len(t, snap.Hosts, 2)
+	assert.Zero(t, snap.Overruns)
+}
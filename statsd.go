@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// statsdAddr is the StatsD or DogStatsD agent results are sent to over
+	// UDP, set with -statsd as host:port, the port defaulting to 8125.
+	// Empty sends none.
+	statsdAddr string
+	// statsdPrefix is the start of the metric names, set with
+	// -statsd-prefix.
+	statsdPrefix = "mosaic"
+	// statsdTags sends the host as DogStatsD tags instead of as part of the
+	// metric names, set with -statsd-tags.
+	statsdTags bool
+)
+
+// statsdPacket is the most bytes sent in one datagram, so it fits an
+// Ethernet frame without fragmenting.
+const statsdPacket = 1432
+
+// statsdTagEscaper replaces the characters that end a DogStatsD tag.
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "\n", "")
+
+// statsdSink sends a timing and gauges per probe to a StatsD agent, such as
+// Telegraf's statsd input or the Datadog agent:
+//
+//	mosaic.10_0_0_1.rtt:3|ms
+//	mosaic.10_0_0_1.loss:0|g
+//	mosaic.10_0_0_1.up:1|g
+//
+// or, with tags, mosaic.rtt:3|ms|#host:10.0.0.1,name:core-sw,group:core.
+type statsdSink struct {
+	addr   string
+	prefix string
+	tags   bool
+	dial   func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// newStatsdSink creates a sink sending to the agent at addr, with port
+// 8125 if it has none, metric names starting with prefix, and DogStatsD
+// tags if tags is set.
+func newStatsdSink(addr, prefix string, tags bool) (*statsdSink, error) {
+	addr = withDefaultPort(addr, "8125")
+	if _, _, err := net.SplitHostPort(addr); err != nil || strings.Contains(addr, "/") {
+		return nil, fmt.Errorf("invalid StatsD address %q: expected host:port", addr)
+	}
+	prefix = strings.Trim(prefix, ".")
+	if prefix == "" {
+		return nil, fmt.Errorf("the StatsD prefix must not be empty")
+	}
+	var d net.Dialer
+	return &statsdSink{addr: addr, prefix: prefix, tags: tags, dial: d.DialContext}, nil
+}
+
+// Name implements resultSink.
+func (s *statsdSink) Name() string { return "statsd" }
+
+// Push implements resultSink, packing as many lines into a datagram as fit
+// in statsdPacket bytes. The agent's address is resolved again every
+// cycle, so it may move.
+func (s *statsdSink) Push(ctx context.Context, now time.Time, statuses []HostStatus) error {
+	conn, err := s.dial(ctx, "udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet []byte
+	for _, line := range s.lines(statuses) {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdPacket {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lines returns the metrics of statuses: rtt as a timing in milliseconds,
+// left out while a host is down, and loss and up as gauges. The host is
+// a node of the metric names, with dots and other unsafe characters as
+// underscores, or with tags the host, name and group tags.
+func (s *statsdSink) lines(statuses []HostStatus) []string {
+	var lines []string
+	for _, st := range statuses {
+		name, suffix := s.prefix+"."+graphiteNode(st.Host)+".", ""
+		if s.tags {
+			name = s.prefix + "."
+			tags := []string{"host:" + statsdTagEscaper.Replace(st.Host)}
+			if st.Name != "" {
+				tags = append(tags, "name:"+statsdTagEscaper.Replace(st.Name))
+			}
+			for _, g := range st.Groups {
+				tags = append(tags, "group:"+statsdTagEscaper.Replace(g))
+			}
+			suffix = "|#" + strings.Join(tags, ",")
+		}
+		up := "1"
+		if st.Alive {
+			lines = append(lines, name+"rtt:"+strconv.Itoa(st.LatencyMs)+"|ms"+suffix)
+		} else {
+			up = "0"
+		}
+		lines = append(lines,
+			name+"loss:"+strconv.FormatFloat(st.PacketLoss, 'f', -1, 64)+"|g"+suffix,
+			name+"up:"+up+"|g"+suffix)
+	}
+	return lines
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsdLines(t *testing.T) {
+	statuses := []HostStatus{
+		{Host: "10.0.0.1", Name: "core-sw", Alive: true, LatencyMs: 3, Groups: []string{"core", "a,b"}},
+		{Host: "db", PacketLoss: 100},
+	}
+	s, _ := newStatsdSink("127.0.0.1", "mosaic", false)
+	assert.Equal(t, []string{
+		"mosaic.10_0_0_1.rtt:3|ms",
+		"mosaic.10_0_0_1.loss:0|g",
+		"mosaic.10_0_0_1.up:1|g",
+		"mosaic.db.loss:100|g",
+		"mosaic.db.up:0|g",
+	}, s.lines(statuses))
+
+	s, _ = newStatsdSink("127.0.0.1", "noc.", true)
+	assert.Equal(t, []string{
+		"noc.rtt:3|ms|#host:10.0.0.1,name:core-sw,group:core,group:a_b",
+		"noc.loss:0|g|#host:10.0.0.1,name:core-sw,group:core,group:a_b",
+		"noc.up:1|g|#host:10.0.0.1,name:core-sw,group:core,group:a_b",
+		"noc.loss:100|g|#host:db",
+		"noc.up:0|g|#host:db",
+	}, s.lines(statuses))
+}
+
+func TestStatsdSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, err := newStatsdSink(pc.LocalAddr().String(), "mosaic", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make([]HostStatus, 100)
+	for i := range statuses {
+		statuses[i] = HostStatus{Host: "host", Alive: true, LatencyMs: i}
+	}
+	assert.NoError(t, s.Push(context.Background(), time.Now(), statuses))
+
+	var lines []string
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(lines) < 3*len(statuses) {
+		n, _, err := pc.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			break
+		}
+		assert.LessOrEqual(t, n, statsdPacket)
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+	assert.Len(t, lines, 3*len(statuses), "every line arrives whole")
+	assert.Equal(t, "mosaic.host.rtt:0|ms", lines[0])
+
+	s, err = newStatsdSink("localhost", "mosaic", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:8125", s.addr)
+	_, err = newStatsdSink("udp://localhost:8125", "mosaic", false)
+	assert.Error(t, err)
+}
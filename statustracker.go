@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// confirmDown is how many probes in a row must fail before a host is shown
+// as down, set with -confirm. Until then the tile turns yellow, so a single
+// lost packet does not flip it red.
+var confirmDown = 1
+
+// latencySmoothing is the weight of the newest sample in the exponentially
+// weighted moving average shown as a host's latency, set with -smoothing, so
+// a single spike does not flip a tile's color. Zero shows every sample as is.
+var latencySmoothing float64
+
+// flapCount and flapWindow define a flapping host, set with -flap-count and
+// -flap-window: one that changed state more than flapCount times within
+// flapWindow. It counts as stable again once no more than half as many
+// changes are left in the window. A flapCount of zero turns detection off.
+var (
+	flapCount  = 5
+	flapWindow = 10 * time.Minute
+)
+
+// Host states kept by the status tracker.
+const (
+	stateUp       = "up"
+	stateDegraded = "degraded"
+	stateDown     = "down"
+)
+
+// trackedHost is the state of one host across probes.
+type trackedHost struct {
+	state       string
+	failures    int         // Probes in a row that got no answer
+	latencyMs   int         // Latency shown for the last answered probe
+	ewmaMs      float64     // Moving average of the latency, 0 before the first answer
+	changes     []time.Time // State changes within flapWindow, oldest first
+	flapping    bool
+	unreachable bool      // Whether the host was last down behind a parent that is down
+	downAt      time.Time // When the change into down was counted, zero if it was not
+	reportedAt  time.Time // When the host was reported down, zero while it is not
+}
+
+// statusTracker turns the results of single probes into the up, degraded
+// or down state of each host. A host that answers is up, or degraded if the
+// probe says so, right away; one that stops answering is only down after
+// confirmDown failed probes in a row. A host changing state too often is
+// marked as flapping. Hosts may depend on a parent, e.g. the router they
+// are behind, and are only unreachable rather than down while it is down.
+type statusTracker struct {
+	mu      sync.Mutex
+	hosts   map[string]*trackedHost
+	parents map[string]string // Host -> the host it depends on
+	// found holds the parents discovery sources report, such as the switch
+	// a host was seen behind, for hosts without a configured parent
+	found map[string]string
+}
+
+var hostStates = newStatusTracker()
+
+// newStatusTracker creates an empty statusTracker.
+func newStatusTracker() *statusTracker {
+	return &statusTracker{hosts: make(map[string]*trackedHost), parents: make(map[string]string), found: make(map[string]string)}
+}
+
+// update moves the host of status to its next state and returns status as
+// it should be shown. A failure not yet confirmed is shown as degraded with
+// the latency of the last answer. With latencySmoothing the latency shown is
+// the moving average, and the sample itself goes into RawLatencyMs. A host
+// starting or stopping to flap is recorded as a "host_flapping" or
+// "host_flapping_resolved" event; the state changes in between are not.
+//
+// Parameters:
+//   - status: Result of a single probe of the host
+//   - now: When the probe finished
+//
+// Returns:
+//   - HostStatus: The status with Failures and Flapping set and Alive and
+//     Degraded reflecting the host's state
+func (t *statusTracker) update(status HostStatus, now time.Time) HostStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	th := t.hosts[status.Host]
+	if th == nil {
+		th = &trackedHost{}
+		t.hosts[status.Host] = th
+	}
+	prev := th.state
+	status = th.next(status)
+	if prev != "" && th.state != prev {
+		t.countChange(th, status.Host, now)
+	}
+	th.checkFlapping(status.Host, now)
+	status.Flapping = th.flapping
+	return status
+}
+
+// countChange records a state change of th towards flapping, unless host
+// went down behind a parent that is down. A host that was unreachable when
+// it comes back takes back the change into down as well, since its parent
+// may only have turned out to be down after it was counted.
+func (t *statusTracker) countChange(th *trackedHost, host string, now time.Time) {
+	switch {
+	case th.unreachable:
+		if n := len(th.changes); n > 0 && th.changes[n-1] == th.downAt {
+			th.changes = th.changes[:n-1]
+		}
+		th.downAt = time.Time{}
+	case th.state == stateDown && t.parentDownLocked(host):
+		th.downAt = time.Time{}
+	default:
+		th.changes = append(th.changes, now)
+		if th.state == stateDown {
+			th.downAt = now
+		}
+	}
+}
+
+// parentDownLocked reports whether the parent of host was down at its last
+// probe, for callers holding t.mu.
+func (t *statusTracker) parentDownLocked(host string) bool {
+	parent := t.hosts[t.parentLocked(host)]
+	return parent != nil && (parent.state == stateDown || parent.unreachable)
+}
+
+// next moves th to the state status puts it in.
+func (th *trackedHost) next(status HostStatus) HostStatus {
+	if status.Alive {
+		th.failures = 0
+		if latencySmoothing > 0 {
+			status.RawLatencyMs = status.LatencyMs
+			if th.ewmaMs == 0 {
+				th.ewmaMs = float64(status.LatencyMs)
+			} else {
+				th.ewmaMs += latencySmoothing * (float64(status.LatencyMs) - th.ewmaMs)
+			}
+			status.LatencyMs = int(math.Round(th.ewmaMs))
+		}
+		th.latencyMs = status.LatencyMs
+		th.state = stateUp
+		if status.Degraded {
+			th.state = stateDegraded
+		}
+		return status
+	}
+	th.failures++
+	status.Failures = th.failures
+	if th.state == stateDown || th.failures >= confirmDown {
+		th.state = stateDown
+		return status
+	}
+	detail := fmt.Sprintf("no answer, %d of %d failures before down", th.failures, confirmDown)
+	if status.Detail != "" {
+		detail += ": " + status.Detail
+	}
+	status.Alive = true
+	status.Degraded = true
+	status.LatencyMs = th.latencyMs
+	status.Reason = ""
+	status.Detail = detail
+	return status
+}
+
+// checkFlapping drops the state changes of th that left flapWindow and
+// starts or stops it flapping.
+func (th *trackedHost) checkFlapping(host string, now time.Time) {
+	drop := 0
+	for drop < len(th.changes) && now.Sub(th.changes[drop]) > flapWindow {
+		drop++
+	}
+	th.changes = th.changes[drop:]
+	n := len(th.changes)
+	switch {
+	case !th.flapping && flapCount > 0 && n > flapCount:
+		th.flapping = true
+		events.add(Event{Time: now, Type: "host_flapping", Key: host, Hosts: []string{host},
+			Message: fmt.Sprintf("%s is flapping: %d state changes in %s", host, n, flapWindow)})
+	case th.flapping && (flapCount == 0 || n <= flapCount/2):
+		th.flapping = false
+		events.add(Event{Time: now, Type: "host_flapping_resolved", Key: host, Hosts: []string{host},
+			Message: fmt.Sprintf("%s is stable again as %s", host, th.state)})
+	}
+}
+
+// notifyChanges records a "host_down" event for each host confirmed down
+// and a "host_up" event once a host reported down is no longer, so a single
+// host going down is notified even when no incident correlates it. Hosts
+// that are paused, flapping or unreachable behind a parent that is down are
+// not reported: flapping has events of its own, and the parent's event
+// names the hosts behind it that are unreachable.
+//
+// Parameters:
+//   - statuses: Latest status of every host, marked by markUnreachable
+//   - now: When the cycle finished
+func (t *statusTracker) notifyChanges(statuses []HostStatus, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	behind := make(map[string][]string)
+	for _, st := range statuses {
+		if st.Unreachable {
+			behind[st.Parent] = append(behind[st.Parent], st.Host)
+		}
+	}
+	for _, st := range statuses {
+		th := t.hosts[st.Host]
+		if th == nil || th.flapping || st.Paused || st.Unreachable {
+			continue
+		}
+		switch {
+		case th.state == stateDown && th.reportedAt.IsZero():
+			th.reportedAt = now
+			msg := st.Host + " is down"
+			if children := behind[st.Host]; len(children) > 0 {
+				msg += fmt.Sprintf(", %d hosts behind it unreachable: %s", len(children), strings.Join(children, ", "))
+			}
+			events.add(Event{Time: now, Type: "host_down", Key: st.Host, Hosts: []string{st.Host}, Message: msg})
+		case th.state != stateDown && !th.reportedAt.IsZero():
+			events.add(Event{Time: now, Type: "host_up", Key: st.Host, Hosts: []string{st.Host},
+				Message: fmt.Sprintf("%s is %s again after %s down", st.Host, th.state, now.Sub(th.reportedAt).Round(time.Second))})
+			th.reportedAt = time.Time{}
+		}
+	}
+}
+
+// forget drops the state of host.
+func (t *statusTracker) forget(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.hosts, host)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusTrackerConfirmsDown(t *testing.T) {
+	defer func(n int) { confirmDown = n }(confirmDown)
+	confirmDown = 3
+	tr := newStatusTracker()
+	now := time.Now()
+	up := HostStatus{Host: "db01", Alive: true, LatencyMs: 12}
+	lost := HostStatus{Host: "db01", PacketLoss: 100, Reason: "timeout", Detail: "request timed out"}
+
+	assert.Equal(t, up, tr.update(up, now))
+	var st HostStatus
+	for i := 1; i < 3; i++ {
+		st = tr.update(lost, now)
+		assert.True(t, st.Alive, "failure %d is not confirmed yet", i)
+		assert.True(t, st.Degraded)
+		assert.Equal(t, 12, st.LatencyMs, "the last latency is kept")
+		assert.Equal(t, i, st.Failures)
+		assert.Empty(t, st.Reason)
+	}
+	assert.Equal(t, "no answer, 2 of 3 failures before down: request timed out", st.Detail)
+
+	st = tr.update(lost, now)
+	assert.False(t, st.Alive, "the third failure in a row confirms down")
+	assert.Equal(t, 3, st.Failures)
+	assert.Equal(t, "timeout", st.Reason)
+	st = tr.update(lost, now)
+	assert.False(t, st.Alive, "a down host stays down")
+
+	// One answer brings it back, and failures count from zero again
+	assert.Equal(t, up, tr.update(up, now))
+	assert.True(t, tr.update(lost, now).Alive)
+}
+
+func TestStatusTrackerDefault(t *testing.T) {
+	tr := newStatusTracker()
+	now := time.Now()
+	st := tr.update(HostStatus{Host: "a", PacketLoss: 100}, now)
+	assert.False(t, st.Alive, "without -confirm the first failure is down")
+	assert.Equal(t, 1, st.Failures)
+
+	tr.forget("a")
+	assert.Empty(t, tr.hosts)
+}
+
+func TestStatusTrackerSmoothing(t *testing.T) {
+	defer func(a float64) { latencySmoothing = a }(latencySmoothing)
+	latencySmoothing = 0.25
+	tr := newStatusTracker()
+	now := time.Now()
+	sample := func(ms int) HostStatus { return tr.update(HostStatus{Host: "wan", Alive: true, LatencyMs: ms}, now) }
+
+	assert.Equal(t, 40, sample(40).LatencyMs, "the first sample starts the average")
+	st := sample(200)
+	assert.Equal(t, 80, st.LatencyMs, "a spike moves the average by a quarter")
+	assert.Equal(t, 200, st.RawLatencyMs)
+	assert.Equal(t, 70, sample(40).LatencyMs)
+
+	// An unconfirmed failure keeps the smoothed latency
+	defer func(n int) { confirmDown = n }(confirmDown)
+	confirmDown = 2
+	assert.Equal(t, 70, tr.update(HostStatus{Host: "wan"}, now).LatencyMs)
+
+	latencySmoothing = 0
+	assert.Equal(t, HostStatus{Host: "wan", Alive: true, LatencyMs: 500}, sample(500), "without -smoothing samples are shown as is")
+}
+
+func TestStatusTrackerFlapping(t *testing.T) {
+	old := events
+	defer func() { events = old }()
+	events = newEventLog(10)
+	defer func(n int, w time.Duration) { flapCount, flapWindow = n, w }(flapCount, flapWindow)
+	flapCount, flapWindow = 3, time.Minute
+	tr := newStatusTracker()
+	now := time.Now()
+	up := HostStatus{Host: "wifi-ap", Alive: true}
+	down := HostStatus{Host: "wifi-ap"}
+
+	at := func(sec int) time.Time { return now.Add(time.Duration(sec) * time.Second) }
+
+	// Three changes are not flapping yet, nor is staying down
+	tr.update(up, at(0))
+	tr.update(down, at(1))
+	tr.update(up, at(2))
+	tr.update(down, at(3))
+	assert.False(t, tr.update(down, at(4)).Flapping)
+	assert.Empty(t, events.recent())
+
+	st := tr.update(up, at(5))
+	assert.True(t, st.Flapping, "the fourth change within a minute is")
+	assert.True(t, st.Alive)
+	assert.Len(t, events.recent(), 1)
+	assert.Equal(t, "host_flapping", events.recent()[0].Type)
+
+	// Further changes don't notify again
+	tr.update(down, at(6))
+	tr.update(up, at(7))
+	assert.Len(t, events.recent(), 1)
+
+	// Once all but one change left the window the host is stable again
+	assert.True(t, tr.update(up, at(66)).Flapping, "two changes left is still above half the count")
+	assert.False(t, tr.update(up, at(67)).Flapping)
+	assert.Equal(t, "host_flapping_resolved", events.recent()[0].Type)
+	assert.Equal(t, "wifi-ap is stable again as up", events.recent()[0].Message)
+}
+
+func TestStatusTrackerNotifiesDown(t *testing.T) {
+	var waits []time.Duration
+	oldEvents, oldNotifications := events, notifications
+	defer func() { events, notifications = oldEvents, oldNotifications }()
+	notifications = testDispatcher(&waits)
+	n := &flakyNotifier{done: make(chan struct{}, 10)}
+	notifications.add(n)
+	events = newEventLog(10)
+	events.notify = notifications.dispatch
+	defer func(n int) { confirmDown = n }(confirmDown)
+	confirmDown = 2
+	tr := newStatusTracker()
+	now := time.Now()
+	cycle := func(sec int, alive bool) {
+		at := now.Add(time.Duration(sec) * time.Second)
+		tr.notifyChanges([]HostStatus{tr.update(HostStatus{Host: "vpn-gw", Alive: alive}, at)}, at)
+	}
+
+	cycle(0, true)
+	cycle(1, false)
+	assert.Empty(t, events.recent(), "not confirmed yet")
+	for sec := 2; sec <= 5; sec++ {
+		cycle(sec, false)
+	}
+	cycle(65, true)
+	cycle(66, true)
+	assert.NoError(t, notifications.drain(context.Background()))
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if assert.Len(t, n.delivered, 2, "one notification per change") {
+		assert.Equal(t, "host_down", n.delivered[0].Type)
+		assert.Equal(t, "vpn-gw is down", n.delivered[0].Message)
+		assert.Equal(t, "host_up", n.delivered[1].Type)
+		assert.Equal(t, "vpn-gw is up again after 1m3s down", n.delivered[1].Message)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// tailscaleSource is the -tailscale value: "local" or the path of the
+// tailscaled socket to list the peers the local node sees, or "api" to list
+// the devices of the tailnet with the Tailscale API key in
+// TAILSCALE_API_KEY. Empty turns Tailscale discovery off.
+var tailscaleSource string
+
+var (
+	// tailscaleSocket is the default socket of the tailscaled local API, a
+	// variable to allow mocking in tests.
+	tailscaleSocket = "/var/run/tailscale/tailscaled.sock"
+	// tailscaleAPIURL is the base URL of the Tailscale API, a variable to
+	// allow mocking in tests.
+	tailscaleAPIURL = "https://api.tailscale.com"
+)
+
+// tailscaleProvider is the nodes of a tailnet.
+type tailscaleProvider struct {
+	local bool   // Ask tailscaled instead of the Tailscale API
+	key   string // API key
+	http  *http.Client
+}
+
+// newTailscaleProvider creates the provider of a -tailscale source.
+func newTailscaleProvider(source string) *tailscaleProvider {
+	if source == "api" {
+		return &tailscaleProvider{key: os.Getenv("TAILSCALE_API_KEY"), http: &http.Client{Timeout: time.Minute}}
+	}
+	socket := tailscaleSocket
+	if source != "local" {
+		socket = source
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &tailscaleProvider{local: true, http: &http.Client{Transport: transport, Timeout: time.Minute}}
+}
+
+// String implements Discovery.
+func (p *tailscaleProvider) String() string {
+	if p.local {
+		return "Tailscale"
+	}
+	return "Tailscale API"
+}
+
+// tailscaleNode is a node of the tailnet as both APIs describe it.
+type tailscaleNode struct {
+	id       string
+	hostname string // Name of the machine
+	dnsName  string // MagicDNS name, unique in the tailnet
+	addrs    []string
+	tags     []string
+}
+
+// tailscaleEntry returns the host entry of a node: a logical host named
+// after the first label of its MagicDNS name, or else its hostname, with
+// its Tailscale IPv4 address, e.g. "laptop=100.64.0.2", or its IPv6
+// address if it has none.
+func tailscaleEntry(n tailscaleNode) string {
+	name, _, _ := strings.Cut(n.dnsName, ".")
+	if name == "" {
+		name = n.hostname
+	}
+	var addr string
+	for _, a := range n.addrs {
+		if ip := net.ParseIP(a); ip != nil && (addr == "" || ip.To4() != nil && net.ParseIP(addr).To4() == nil) {
+			addr = a
+		}
+	}
+	if name == "" || addr == "" {
+		return ""
+	}
+	return logicalEntry(hostLabel(name), []string{addr})
+}
+
+// get sends a GET request for path to tailscaled or the Tailscale API and
+// decodes the JSON response into v.
+func (p *tailscaleProvider) get(ctx context.Context, path string, v any) error {
+	base := tailscaleAPIURL
+	if p.local {
+		base = "http://local-tailscaled.sock"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return err
+	}
+	if p.key != "" {
+		req.Header.Set("Authorization", "Bearer "+p.key)
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response to %s: %v", path, err)
+	}
+	return nil
+}
+
+// nodes lists the peers of the local node, or the devices of the tailnet.
+func (p *tailscaleProvider) nodes(ctx context.Context) ([]tailscaleNode, error) {
+	var nodes []tailscaleNode
+	if p.local {
+		var status struct {
+			Peer map[string]struct {
+				ID           string   `json:"ID"`
+				HostName     string   `json:"HostName"`
+				DNSName      string   `json:"DNSName"`
+				TailscaleIPs []string `json:"TailscaleIPs"`
+				Tags         []string `json:"Tags"`
+			} `json:"Peer"`
+		}
+		if err := p.get(ctx, "/localapi/v0/status", &status); err != nil {
+			return nil, err
+		}
+		for _, peer := range status.Peer {
+			nodes = append(nodes, tailscaleNode{id: peer.ID, hostname: peer.HostName, dnsName: peer.DNSName, addrs: peer.TailscaleIPs, tags: peer.Tags})
+		}
+		return nodes, nil
+	}
+	var list struct {
+		Devices []struct {
+			NodeID    string   `json:"nodeId"`
+			Hostname  string   `json:"hostname"`
+			Name      string   `json:"name"`
+			Addresses []string `json:"addresses"`
+			Tags      []string `json:"tags"`
+		} `json:"devices"`
+	}
+	if err := p.get(ctx, "/api/v2/tailnet/-/devices", &list); err != nil {
+		return nil, err
+	}
+	for _, d := range list.Devices {
+		nodes = append(nodes, tailscaleNode{id: d.NodeID, hostname: d.Hostname, dnsName: d.Name, addrs: d.Addresses, tags: d.Tags})
+	}
+	return nodes, nil
+}
+
+// List implements Discovery: the nodes of the tailnet keyed by node ID,
+// online or not, in the group "tailscale" and a "tailscale/<tag>" group for
+// each of their ACL tags.
+func (p *tailscaleProvider) List(ctx context.Context) (map[string]discovered, error) {
+	nodes, err := p.nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]discovered)
+	for _, n := range nodes {
+		groups := []string{"tailscale"}
+		for _, tag := range n.tags {
+			groups = append(groups, "tailscale/"+strings.TrimPrefix(tag, "tag:"))
+		}
+		if e := tailscaleEntry(n); e != "" {
+			hosts[n.id] = discovered{entry: e, groups: groups}
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailscaleEntry(t *testing.T) {
+	n := tailscaleNode{hostname: "Laptop", dnsName: "laptop-1.tail1234.ts.net.", addrs: []string{"fd7a:115c:a1e0::2", "100.64.0.2"}}
+	assert.Equal(t, "laptop-1=100.64.0.2", tailscaleEntry(n), "MagicDNS name, IPv4 preferred")
+	assert.NoError(t, validateHost(tailscaleEntry(n)))
+
+	n.dnsName, n.addrs = "", []string{"fd7a:115c:a1e0::2"}
+	assert.Equal(t, "Laptop=fd7a:115c:a1e0::2", tailscaleEntry(n))
+
+	assert.Empty(t, tailscaleEntry(tailscaleNode{hostname: "new"}), "nodes without addresses")
+}
+
+func TestTailscaleLocalAPI(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/localapi/v0/status", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "local-tailscaled.sock", r.Host)
+		fmt.Fprint(w, `{"Self":{"ID":"n0","HostName":"mosaic","TailscaleIPs":["100.64.0.1"]},
+			"Peer":{"nodekey:a":{"ID":"n1","HostName":"nas","DNSName":"nas.tail1234.ts.net.","TailscaleIPs":["100.64.0.5"],"Tags":["tag:storage"],"Online":false}}}`)
+	})
+	socket := filepath.Join(t.TempDir(), "tailscaled.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	p := newTailscaleProvider(socket)
+	assert.Equal(t, "Tailscale", p.String())
+	hosts, err := p.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]discovered{
+		"n1": {entry: "nas=100.64.0.5", groups: []string{"tailscale", "tailscale/storage"}},
+	}, hosts, "offline peers are kept, the node itself is not monitored")
+}
+
+func TestTailscaleAPI(t *testing.T) {
+	t.Setenv("TAILSCALE_API_KEY", "tskey-api-x")
+	oldURL := tailscaleAPIURL
+	t.Cleanup(func() { tailscaleAPIURL = oldURL })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tskey-api-x" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"message":"API token invalid"}`)
+			return
+		}
+		assert.Equal(t, "/api/v2/tailnet/-/devices", r.URL.Path)
+		fmt.Fprint(w, `{"devices":[{"nodeId":"n1","hostname":"nas","name":"nas.tail1234.ts.net","addresses":["100.64.0.5","fd7a:115c:a1e0::5"]},
+			{"nodeId":"n2","hostname":"web","name":"web.tail1234.ts.net","addresses":["100.64.0.6"],"tags":["tag:prod"]}]}`)
+	}))
+	defer srv.Close()
+	tailscaleAPIURL = srv.URL
+
+	p := newTailscaleProvider("api")
+	hosts, err := p.List(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]discovered{
+		"n1": {entry: "nas=100.64.0.5", groups: []string{"tailscale"}},
+		"n2": {entry: "web=100.64.0.6", groups: []string{"tailscale", "tailscale/prod"}},
+	}, hosts)
+
+	p.key = "wrong"
+	_, err = p.List(context.Background())
+	assert.ErrorContains(t, err, "API token invalid")
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Thresholds are the limits used to color a host's tile. Latency above
+// WarnMs renders yellow, above CritMs red; with -show-loss, packet loss
+// above LossWarnPct renders yellow, from LossCritPct on red. Zero fields
+// of per-host thresholds inherit the global ones.
+type Thresholds struct {
+	WarnMs      int     `json:"warn_ms" yaml:"warn_ms"`
+	CritMs      int     `json:"crit_ms" yaml:"crit_ms"`
+	LossWarnPct float64 `json:"loss_warn_pct,omitempty" yaml:"loss_warn_pct,omitempty"`
+	LossCritPct float64 `json:"loss_crit_pct,omitempty" yaml:"loss_crit_pct,omitempty"`
+}
+
+// globalThresholds apply to hosts without thresholds of their own, set with
+// -warn, -crit, -loss-warn and -loss-crit. A CritMs of 0 never turns a tile
+// red for its latency.
+var globalThresholds = Thresholds{WarnMs: 150, LossCritPct: 20}
+
+// validate checks that each critical threshold is above its warning
+// threshold where both are given.
+func (t Thresholds) validate() error {
+	switch {
+	case t.WarnMs < 0 || t.CritMs < 0 || (t.CritMs > 0 && t.CritMs <= t.WarnMs):
+		return fmt.Errorf("critical threshold must be above the warning threshold")
+	case t.LossWarnPct < 0 || t.LossCritPct < 0 || t.LossCritPct > 100 || (t.LossCritPct > 0 && t.LossCritPct <= t.LossWarnPct):
+		return fmt.Errorf("critical loss threshold must be above the warning loss threshold and at most 100")
+	}
+	return nil
+}
+
+// merge returns t with the non-zero fields of o on top.
+func (t Thresholds) merge(o Thresholds) Thresholds {
+	if o.WarnMs != 0 {
+		t.WarnMs = o.WarnMs
+	}
+	if o.CritMs != 0 {
+		t.CritMs = o.CritMs
+	}
+	if o.LossWarnPct != 0 {
+		t.LossWarnPct = o.LossWarnPct
+	}
+	if o.LossCritPct != 0 {
+		t.LossCritPct = o.LossCritPct
+	}
+	return t
+}
+
+// matchGroup reports whether host belongs to the group of hosts named by
+// pattern, in which "*" stands for any run of characters, e.g. "sat*" or
+// "*.branch.example.com", or which is "@" and a group of the host's label,
+// e.g. "@webservers". Other patterns without "*" name no group.
+func matchGroup(pattern, host string) bool {
+	if group, ok := strings.CutPrefix(pattern, "@"); ok {
+		for _, g := range hostGroups(host) {
+			if g == group {
+				return true
+			}
+		}
+		return false
+	}
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 || !strings.HasPrefix(host, parts[0]) {
+		return false
+	}
+	host = host[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(host, part)
+		if i < 0 {
+			return false
+		}
+		host = host[i+len(part):]
+	}
+	return strings.HasSuffix(host, last)
+}
+
+// ThresholdSuggestion is a proposed set of thresholds for a single host,
+// derived from its recent latency distribution.
+type ThresholdSuggestion struct {
+	Host      string      `json:"host"`
+	Samples   int         `json:"samples"`           // Number of latency samples considered
+	P95Ms     int         `json:"p95_ms"`            // 95th percentile latency
+	P99Ms     int         `json:"p99_ms"`            // 99th percentile latency
+	Suggested Thresholds  `json:"suggested"`         // Proposed thresholds
+	Current   *Thresholds `json:"current,omitempty"` // Thresholds currently in effect, if any
+}
+
+const (
+	// thresholdSampleSize is how many recent latency samples are kept per host.
+	thresholdSampleSize = 1000
+	// thresholdMinSamples is the minimum history required before suggesting.
+	thresholdMinSamples = 30
+	// defaultThresholdMargin is the headroom in percent added on top of the percentiles.
+	defaultThresholdMargin = 25.0
+)
+
+// thresholdAdvisor keeps recent latency samples per host, suggests
+// thresholds from them and holds the thresholds accepted by the operator.
+type thresholdAdvisor struct {
+	mu       sync.Mutex
+	samples  map[string][]int
+	accepted map[string]Thresholds
+}
+
+var advisor = newThresholdAdvisor()
+
+// newThresholdAdvisor creates an advisor without any history.
+func newThresholdAdvisor() *thresholdAdvisor {
+	return &thresholdAdvisor{
+		samples:  make(map[string][]int),
+		accepted: make(map[string]Thresholds),
+	}
+}
+
+// record stores the latency of every alive host and annotates statuses with
+// the thresholds accepted for them.
+func (a *thresholdAdvisor) record(statuses []HostStatus) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range statuses {
+		if s.Alive {
+			buf := append(a.samples[s.Host], s.LatencyMs)
+			if len(buf) > thresholdSampleSize {
+				buf = buf[len(buf)-thresholdSampleSize:]
+			}
+			a.samples[s.Host] = buf
+		}
+	}
+	a.annotateLocked(statuses)
+}
+
+// annotate sets the thresholds accepted for each host on its status without
+// recording a sample, for statuses carried over from an earlier cycle.
+func (a *thresholdAdvisor) annotate(statuses []HostStatus) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.annotateLocked(statuses)
+}
+
+// annotateLocked is annotate for callers holding a.mu.
+func (a *thresholdAdvisor) annotateLocked(statuses []HostStatus) {
+	for i, s := range statuses {
+		th := a.effectiveLocked(s.Host)
+		statuses[i].WarnMs = th.WarnMs
+		statuses[i].CritMs = th.CritMs
+		statuses[i].LossWarnPct = th.LossWarnPct
+		statuses[i].LossCritPct = th.LossCritPct
+	}
+}
+
+// effectiveLocked returns the thresholds in effect for host: the global
+// ones, overlaid by those of the most specific group pattern matching host,
+// overlaid by its own. The longest pattern is the most specific, ties go to
+// the first in sort order.
+func (a *thresholdAdvisor) effectiveLocked(host string) Thresholds {
+	th := globalThresholds
+	group := ""
+	for pattern := range a.accepted {
+		longer := len(pattern) > len(group) || (len(pattern) == len(group) && pattern < group)
+		if longer && matchGroup(pattern, host) {
+			group = pattern
+		}
+	}
+	if group != "" {
+		th = th.merge(a.accepted[group])
+	}
+	if own, ok := a.accepted[host]; ok {
+		th = th.merge(own)
+	}
+	return th
+}
+
+// suggest proposes thresholds for every host with enough history.
+// The warning threshold is p95 plus margin percent, the critical threshold
+// p99 plus twice the margin, and is always above the warning threshold.
+//
+// Parameters:
+//   - margin: Headroom in percent added on top of the percentiles
+//
+// Returns:
+//   - []ThresholdSuggestion: Suggestions ordered by host
+func (a *thresholdAdvisor) suggest(margin float64) []ThresholdSuggestion {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := []ThresholdSuggestion{}
+	for host, buf := range a.samples {
+		if len(buf) < thresholdMinSamples {
+			continue
+		}
+		sorted := append([]int(nil), buf...)
+		sort.Ints(sorted)
+		p95 := percentile(sorted, 95)
+		p99 := percentile(sorted, 99)
+		warn := int(math.Ceil(float64(p95) * (1 + margin/100)))
+		crit := int(math.Ceil(float64(p99) * (1 + 2*margin/100)))
+		if warn < 1 {
+			warn = 1
+		}
+		if crit <= warn {
+			crit = warn + 1
+		}
+		s := ThresholdSuggestion{
+			Host:      host,
+			Samples:   len(buf),
+			P95Ms:     p95,
+			P99Ms:     p99,
+			Suggested: Thresholds{WarnMs: warn, CritMs: crit},
+		}
+		if th, ok := a.accepted[host]; ok {
+			s.Current = &th
+		}
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Host < result[j].Host })
+	return result
+}
+
+// accept makes the suggested thresholds effective. If hosts is empty every
+// suggestion is accepted.
+//
+// Returns:
+//   - []ThresholdSuggestion: The suggestions that were applied
+func (a *thresholdAdvisor) accept(margin float64, hosts []string) []ThresholdSuggestion {
+	want := make(map[string]bool)
+	for _, h := range hosts {
+		want[h] = true
+	}
+	applied := []ThresholdSuggestion{}
+	suggestions := a.suggest(margin)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range suggestions {
+		if len(want) > 0 && !want[s.Host] {
+			continue
+		}
+		// Learned thresholds only cover latency, keep the loss thresholds
+		th := a.accepted[s.Host]
+		th.WarnMs, th.CritMs = s.Suggested.WarnMs, s.Suggested.CritMs
+		a.accepted[s.Host] = th
+		applied = append(applied, s)
+	}
+	return applied
+}
+
+// thresholds returns a copy of the accepted thresholds by host.
+func (a *thresholdAdvisor) thresholds() map[string]Thresholds {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	result := make(map[string]Thresholds, len(a.accepted))
+	for h, th := range a.accepted {
+		result[h] = th
+	}
+	return result
+}
+
+// setThresholds replaces all accepted thresholds.
+func (a *thresholdAdvisor) setThresholds(th map[string]Thresholds) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accepted = make(map[string]Thresholds, len(th))
+	for h, t := range th {
+		a.accepted[h] = t
+	}
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank method.
+func percentile(sorted []int, p float64) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// thresholdsHandler serves threshold suggestions (GET) and accepts them in
+// bulk (POST). The optional "margin" query parameter sets the headroom in
+// percent; a POST body of {"hosts": [...]} limits which hosts are accepted.
+func thresholdsHandler(w http.ResponseWriter, r *http.Request) {
+	margin := defaultThresholdMargin
+	if m := r.URL.Query().Get("margin"); m != "" {
+		v, err := strconv.ParseFloat(m, 64)
+		if err != nil || v < 0 {
+			http.Error(w, "invalid margin", http.StatusBadRequest)
+			return
+		}
+		margin = v
+	}
+
+	var result []ThresholdSuggestion
+	switch r.Method {
+	case http.MethodGet:
+		result = advisor.suggest(margin)
+	case http.MethodPost:
+		var req struct {
+			Hosts []string `json:"hosts"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		result = advisor.accept(margin, req.Hosts)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, 10, percentile(sorted, 95))
+	assert.Equal(t, 5, percentile(sorted, 50))
+	assert.Equal(t, 1, percentile(sorted, 0))
+	assert.Equal(t, 0, percentile(nil, 95))
+}
+
+// feed records n cycles in which host answered with latencies 1..n ms.
+func feed(a *thresholdAdvisor, host string, n int) {
+	for i := 1; i <= n; i++ {
+		a.record([]HostStatus{{Host: host, Alive: true, LatencyMs: i}})
+	}
+}
+
+func TestThresholdAdvisorSuggest(t *testing.T) {
+	a := newThresholdAdvisor()
+	feed(a, "enough", 100)
+	feed(a, "too-few", thresholdMinSamples-1)
+	// Down samples are not part of the latency distribution
+	a.record([]HostStatus{{Host: "enough", Alive: false}})
+
+	suggestions := a.suggest(20)
+	assert.Len(t, suggestions, 1)
+	s := suggestions[0]
+	assert.Equal(t, "enough", s.Host)
+	assert.Equal(t, 100, s.Samples)
+	assert.Equal(t, 95, s.P95Ms)
+	assert.Equal(t, 99, s.P99Ms)
+	assert.Equal(t, Thresholds{WarnMs: 114, CritMs: 139}, s.Suggested)
+	assert.Nil(t, s.Current)
+}
+
+func TestThresholdAdvisorSampleWindow(t *testing.T) {
+	a := newThresholdAdvisor()
+	feed(a, "h", thresholdSampleSize+10)
+	assert.Len(t, a.samples["h"], thresholdSampleSize)
+	assert.Equal(t, 11, a.samples["h"][0])
+}
+
+func TestThresholdAdvisorAccept(t *testing.T) {
+	a := newThresholdAdvisor()
+	feed(a, "a", 50)
+	feed(a, "b", 50)
+
+	applied := a.accept(0, []string{"b"})
+	assert.Len(t, applied, 1)
+	assert.Equal(t, "b", applied[0].Host)
+
+	statuses := []HostStatus{{Host: "a", Alive: true}, {Host: "b", Alive: true}}
+	a.record(statuses)
+	assert.Equal(t, globalThresholds.WarnMs, statuses[0].WarnMs, "hosts without thresholds get the global ones")
+	assert.Equal(t, 48, statuses[1].WarnMs)
+	assert.Equal(t, 50, statuses[1].CritMs)
+
+	// Accepting without a host list applies every suggestion
+	applied = a.accept(0, nil)
+	assert.Len(t, applied, 2)
+	assert.NotNil(t, a.suggest(0)[0].Current)
+}
+
+func TestMatchGroup(t *testing.T) {
+	assert.True(t, matchGroup("sat*", "sat01"))
+	assert.True(t, matchGroup("*.branch.example.com", "fw.branch.example.com"))
+	assert.True(t, matchGroup("https://*/health", "https://api.example.com/v1/health"))
+	assert.True(t, matchGroup("*", "anything"))
+	assert.False(t, matchGroup("sat*", "db-sat01"))
+	assert.False(t, matchGroup("ab*ba", "aba"), "parts must not overlap")
+	assert.False(t, matchGroup("sat01", "sat01"), "patterns without * name no group")
+}
+
+func TestThresholdsValidate(t *testing.T) {
+	assert.NoError(t, Thresholds{WarnMs: 200, CritMs: 400, LossWarnPct: 5, LossCritPct: 50}.validate())
+	assert.NoError(t, Thresholds{LossWarnPct: 5}.validate(), "zero fields are inherited")
+	assert.Error(t, Thresholds{WarnMs: 400, CritMs: 200}.validate())
+	assert.Error(t, Thresholds{LossWarnPct: 50, LossCritPct: 5}.validate())
+	assert.Error(t, Thresholds{LossCritPct: 120}.validate())
+}
+
+func TestEffectiveThresholds(t *testing.T) {
+	a := newThresholdAdvisor()
+	a.setThresholds(map[string]Thresholds{
+		"sat*":   {WarnMs: 800, CritMs: 1500, LossCritPct: 50},
+		"sat0*":  {WarnMs: 900},
+		"sat01":  {CritMs: 2000},
+		"router": {LossWarnPct: 5},
+	})
+	statuses := []HostStatus{{Host: "sat01"}, {Host: "sat02"}, {Host: "satx"}, {Host: "router"}, {Host: "db01"}}
+	a.annotate(statuses)
+
+	g := globalThresholds
+	assert.Equal(t, []int{900, 900, 800, g.WarnMs, g.WarnMs}, []int{statuses[0].WarnMs, statuses[1].WarnMs, statuses[2].WarnMs, statuses[3].WarnMs, statuses[4].WarnMs}, "the longest matching pattern wins")
+	assert.Equal(t, 2000, statuses[0].CritMs, "the host's own thresholds come last")
+	assert.Equal(t, g.CritMs, statuses[1].CritMs, "fields the longer pattern leaves out come from the global ones, not the shorter pattern")
+	assert.Equal(t, 50.0, statuses[2].LossCritPct)
+	assert.Equal(t, 5.0, statuses[3].LossWarnPct)
+	assert.Equal(t, g.LossCritPct, statuses[3].LossCritPct)
+}
+
+func TestThresholdsHandler(t *testing.T) {
+	old := advisor
+	defer func() { advisor = old }()
+	advisor = newThresholdAdvisor()
+	feed(advisor, "h", 40)
+
+	rec := httptest.NewRecorder()
+	thresholdsHandler(rec, httptest.NewRequest("GET", "/api/thresholds?margin=10", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var got []ThresholdSuggestion
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Len(t, got, 1)
+
+	rec = httptest.NewRecorder()
+	thresholdsHandler(rec, httptest.NewRequest("POST", "/api/thresholds", strings.NewReader(`{"hosts":["h"]}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, advisor.accepted, "h")
+
+	rec = httptest.NewRecorder()
+	thresholdsHandler(rec, httptest.NewRequest("GET", "/api/thresholds?margin=x", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	thresholdsHandler(rec, httptest.NewRequest("DELETE", "/api/thresholds", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
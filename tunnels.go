@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// PathStatus is the result of probing a host over one network path, used to
+// show tunnel and direct (underlay) reachability side by side.
+type PathStatus struct {
+	Path       string  `json:"path"`        // Interface name, or "direct" for the default route
+	Alive      bool    `json:"alive"`       // Whether the host answered over this path
+	LatencyMs  int     `json:"latency_ms"`  // Average round-trip time over this path
+	PacketLoss float64 `json:"packet_loss"` // Packet loss over this path within the loss window
+}
+
+// tunnelGroup binds ICMP probes for a group of hosts to a tunnel interface
+// such as a WireGuard or tun device.
+type tunnelGroup struct {
+	iface string
+	nets  []*net.IPNet
+	names map[string]bool
+}
+
+// tunnelFlag collects repeated -tunnel flags.
+type tunnelFlag []tunnelGroup
+
+var (
+	tunnels tunnelFlag
+	// tunnelCompare additionally probes tunnelled hosts over the direct path.
+	tunnelCompare bool
+)
+
+// String implements flag.Value.
+func (f *tunnelFlag) String() string {
+	var parts []string
+	for _, g := range *f {
+		parts = append(parts, g.iface)
+	}
+	return strings.Join(parts, ";")
+}
+
+// Set implements flag.Value, parsing "wg0=10.10.0.0/16,db01.internal".
+func (f *tunnelFlag) Set(v string) error {
+	g, err := parseTunnelGroup(v)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, g)
+	return nil
+}
+
+// parseTunnelGroup parses an interface name followed by a comma-separated
+// list of CIDRs, IP addresses and host names routed through it.
+func parseTunnelGroup(v string) (tunnelGroup, error) {
+	iface, members, ok := strings.Cut(v, "=")
+	iface = strings.TrimSpace(iface)
+	if !ok || iface == "" {
+		return tunnelGroup{}, fmt.Errorf("invalid tunnel %q: expected iface=cidr|host,...", v)
+	}
+	g := tunnelGroup{iface: iface, names: make(map[string]bool)}
+	for _, m := range strings.Split(members, ",") {
+		m = strings.TrimSpace(m)
+		switch {
+		case m == "":
+		case strings.Contains(m, "/"):
+			_, n, err := net.ParseCIDR(m)
+			if err != nil {
+				return tunnelGroup{}, fmt.Errorf("invalid tunnel %q: %v", v, err)
+			}
+			g.nets = append(g.nets, n)
+		default:
+			g.names[m] = true
+		}
+	}
+	return g, nil
+}
+
+// tunnelFor returns the tunnel interface an ICMP host should be probed
+// through, or "" if it is not part of any tunnel group.
+func tunnelFor(scheme, addr string) string {
+	if scheme != "" && scheme != "icmp" {
+		return ""
+	}
+	addr, _ = splitOptions(addr)
+	ip := net.ParseIP(addr)
+	for _, g := range tunnels {
+		if g.names[addr] {
+			return g.iface
+		}
+		for _, n := range g.nets {
+			if ip != nil && n.Contains(ip) {
+				return g.iface
+			}
+		}
+	}
+	return ""
+}
+
+// withOption appends key=value to the options of a probe address.
+func withOption(addr, key, value string) string {
+	sep := "?"
+	if strings.Contains(addr, "?") {
+		sep = "&"
+	}
+	return addr + sep + key + "=" + value
+}
+
+// pathStatus summarises a probe over one path, accumulating its packet loss
+// under key so each path keeps its own statistics.
+func pathStatus(path, key string, res probeResult) PathStatus {
+	if res.Err != nil {
+		return PathStatus{Path: path, PacketLoss: 100.0}
+	}
+	return PathStatus{
+		Path:       path,
+		Alive:      res.Recv > 0,
+		LatencyMs:  int(res.Latency.Milliseconds()),
+		PacketLoss: recordStats(key, res),
+	}
+}
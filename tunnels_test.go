@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTunnelGroup(t *testing.T) {
+	g, err := parseTunnelGroup("wg0=10.10.0.0/16, db01.internal")
+	assert.NoError(t, err)
+	assert.Equal(t, "wg0", g.iface)
+	assert.Len(t, g.nets, 1)
+	assert.True(t, g.names["db01.internal"])
+
+	_, err = parseTunnelGroup("10.10.0.0/16")
+	assert.Error(t, err)
+	_, err = parseTunnelGroup("wg0=10.10.0.0/99")
+	assert.Error(t, err)
+}
+
+func TestTunnelFor(t *testing.T) {
+	defer func(saved tunnelFlag) { tunnels = saved }(tunnels)
+	tunnels = nil
+	assert.NoError(t, tunnels.Set("wg0=10.10.0.0/16,db01"))
+	assert.NoError(t, tunnels.Set("tun1=fd00::/64"))
+
+	assert.Equal(t, "wg0", tunnelFor("", "10.10.3.4"))
+	assert.Equal(t, "wg0", tunnelFor("icmp", "db01"))
+	assert.Equal(t, "tun1", tunnelFor("", "fd00::7"))
+	assert.Equal(t, "", tunnelFor("", "8.8.8.8"))
+	assert.Equal(t, "", tunnelFor("ssh", "db01"))
+	assert.Equal(t, "wg0;tun1", tunnels.String())
+}
+
+func TestPingHostTunnelCompare(t *testing.T) {
+	defer func(saved tunnelFlag, compare bool) { tunnels, tunnelCompare = saved, compare }(tunnels, tunnelCompare)
+	defer func(saved func(string) probeResult) { probers[""] = saved }(probers[""])
+	tunnels, tunnelCompare = nil, true
+	assert.NoError(t, tunnels.Set("wg0=10.10.0.0/16"))
+
+	// The tunnel is down while the underlay still reaches the host
+	probers[""] = func(addr string) probeResult {
+		if strings.Contains(addr, "iface=wg0") {
+			return probeResult{Sent: 1}
+		}
+		return probeResult{Sent: 1, Recv: 1}
+	}
+	defer func() {
+		hostStatsMu.Lock()
+		for _, key := range []string{"10.10.0.7", "10.10.0.7#direct", "192.0.2.1"} {
+			delete(hostStats, key)
+		}
+		hostStatsMu.Unlock()
+	}()
+
+	status := pingHost("10.10.0.7")
+	assert.False(t, status.Alive)
+	assert.Equal(t, []PathStatus{
+		{Path: "wg0", Alive: false, PacketLoss: 100},
+		{Path: "direct", Alive: true, PacketLoss: 0},
+	}, status.Paths)
+
+	// Hosts outside any tunnel group are probed once, without paths
+	status = pingHost("192.0.2.1")
+	assert.True(t, status.Alive)
+	assert.Empty(t, status.Paths)
+}
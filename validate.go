@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// validateResolveTimeout bounds each name lookup of "mosaic validate".
+	validateResolveTimeout = 5 * time.Second
+	// validateResolvers is how many names "mosaic validate" looks up at once.
+	validateResolvers = 16
+)
+
+// lookupHost is a variable to allow mocking in tests
+var lookupHost = net.DefaultResolver.LookupHost
+
+// probeNames returns the hostnames host is probed at that need resolving:
+// none for IP addresses and for exec:// and sim:// hosts, one per address
+// of a logical host.
+func probeNames(host string) []string {
+	if _, members, ok := splitAlias(host); ok {
+		var names []string
+		for _, m := range members {
+			names = append(names, probeNames(m)...)
+		}
+		return names
+	}
+	scheme, addr := splitScheme(host)
+	if scheme == "exec" || scheme == "sim" {
+		return nil
+	}
+	addr, _ = splitOptions(addr)
+	addr, _, _ = strings.Cut(addr, ",")
+	if u, err := url.Parse("//" + addr); err == nil && u.Hostname() != "" {
+		addr = u.Hostname()
+	}
+	addr = strings.Trim(addr, "[]")
+	if _, err := netip.ParseAddr(addr); err == nil || addr == "" {
+		return nil
+	}
+	return []string{addr}
+}
+
+// checkConfig implements the part of "mosaic validate" that goes beyond the
+// checks at startup: it resolves the names of all hosts and reports every
+// one that does not resolve, followed by a summary.
+//
+// Parameters:
+//   - w: Where to write the problems and the summary
+//   - list: The monitored hosts, already validated
+//
+// Returns:
+//   - error: If a name does not resolve
+func checkConfig(w io.Writer, list []string) error {
+	type lookup struct {
+		host, name string
+		err        error
+	}
+	var lookups []*lookup
+	for _, h := range list {
+		for _, name := range probeNames(h) {
+			lookups = append(lookups, &lookup{host: h, name: name})
+		}
+	}
+	sem := make(chan struct{}, validateResolvers)
+	var wg sync.WaitGroup
+	for _, l := range lookups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(l *lookup) {
+			defer func() { <-sem; wg.Done() }()
+			ctx, cancel := context.WithTimeout(context.Background(), validateResolveTimeout)
+			defer cancel()
+			_, l.err = lookupHost(ctx, l.name)
+		}(l)
+	}
+	wg.Wait()
+
+	failed := make(map[string]bool)
+	for _, l := range lookups {
+		if l.err != nil {
+			failed[l.host] = true
+			fmt.Fprintf(w, "%s: cannot resolve %s: %v\n", l.host, l.name, l.err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d hosts cannot be resolved", len(failed), len(list))
+	}
+	fmt.Fprintf(w, "OK: %d hosts, %d names resolved\n", len(list), len(lookups))
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeNames(t *testing.T) {
+	for host, want := range map[string][]string{
+		"8.8.8.8":                         nil,
+		"fe80::1%eth0":                    nil,
+		"router":                          {"router"},
+		"sat01?count=3":                   {"sat01"},
+		"db01:5432,6432":                  {"db01"},
+		"[2001:db8::1]:443":               nil,
+		"https://example.com/health":      {"example.com"},
+		"postgres://mon:pw@db02:5432/app": {"db02"},
+		"exec:///usr/bin/check --site=a":  nil,
+		"sim://host-00001":                nil,
+		"core-sw1=10.0.0.1|ssh://jump01":  {"jump01"},
+	} {
+		assert.Equal(t, want, probeNames(host), host)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	defer func(f func(context.Context, string) ([]string, error)) { lookupHost = f }(lookupHost)
+	lookupHost = func(_ context.Context, name string) ([]string, error) {
+		if name == "nas" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"192.0.2.1"}, nil
+	}
+	var out bytes.Buffer
+	assert.NoError(t, checkConfig(&out, []string{"8.8.8.8", "router", "https://example.com/"}))
+	assert.Equal(t, "OK: 3 hosts, 2 names resolved\n", out.String())
+
+	out.Reset()
+	err := checkConfig(&out, []string{"router", "nas", "nas:445"})
+	assert.EqualError(t, err, "2 of 3 hosts cannot be resolved")
+	assert.Equal(t, "nas: cannot resolve nas: no such host\nnas:445: cannot resolve nas: no such host\n", out.String())
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	macsMu sync.RWMutex
+	// hostMACs holds the MAC address of hosts that can be woken with
+	// Wake-on-LAN, set with -mac.
+	hostMACs = make(map[string]net.HardwareAddr)
+	// wolAddr is where magic packets are sent, normally the broadcast
+	// address of the hosts' segment.
+	wolAddr = "255.255.255.255:9"
+)
+
+// parseMACs parses a comma-separated list of host=MAC pairs, e.g.
+// "lab01=00:11:22:33:44:55,lab02=00-11-22-33-44-66".
+//
+// Parameters:
+//   - s: The raw flag value
+//
+// Returns:
+//   - map[string]net.HardwareAddr: MAC address per host
+//   - error: If a pair is malformed or a MAC address is invalid
+func parseMACs(s string) (map[string]net.HardwareAddr, error) {
+	macs := make(map[string]net.HardwareAddr)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// Split at the last "=" since host entries may carry "?key=value" options
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid MAC %q: expected host=mac", pair)
+		}
+		host, value := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		mac, err := net.ParseMAC(value)
+		if err != nil || len(mac) != 6 {
+			return nil, fmt.Errorf("invalid MAC for %s: %q", host, value)
+		}
+		macs[host] = mac
+	}
+	return macs, nil
+}
+
+// annotateMACs adds the configured MAC address to each status so the
+// dashboard can offer to wake hosts that are down.
+func annotateMACs(statuses []HostStatus) {
+	macsMu.RLock()
+	defer macsMu.RUnlock()
+	for i := range statuses {
+		if mac, ok := hostMACs[statuses[i].Host]; ok {
+			statuses[i].MAC = mac.String()
+		}
+	}
+}
+
+// magicPacket builds a Wake-on-LAN magic packet: six 0xff bytes followed by
+// the target MAC address repeated 16 times.
+func magicPacket(mac net.HardwareAddr) []byte {
+	p := make([]byte, 0, 102)
+	for i := 0; i < 6; i++ {
+		p = append(p, 0xff)
+	}
+	for i := 0; i < 16; i++ {
+		p = append(p, mac...)
+	}
+	return p
+}
+
+// sendWOL broadcasts a magic packet for mac to wolAddr.
+func sendWOL(mac net.HardwareAddr) error {
+	conn, err := net.Dial("udp", wolAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(magicPacket(mac))
+	return err
+}
+
+// wolHandler sends a Wake-on-LAN packet to a host with a configured MAC
+// address: POST /api/wol?host=lab01
+func wolHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	host := r.URL.Query().Get("host")
+	macsMu.RLock()
+	mac, ok := hostMACs[host]
+	macsMu.RUnlock()
+	if !ok {
+		http.Error(w, "no MAC address configured for "+host, http.StatusNotFound)
+		return
+	}
+	if err := sendWOL(mac); err != nil {
+		http.Error(w, "failed to send magic packet: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	events.add(Event{Time: time.Now(), Type: "wol_sent", Hosts: []string{host}, Message: fmt.Sprintf("Wake-on-LAN sent to %s (%s)", host, mac)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"host": host, "mac": mac.String()})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMACs(t *testing.T) {
+	macs, err := parseMACs("lab01=00:11:22:33:44:55, 10.0.0.5?iface=eth1=00-11-22-33-44-66")
+	assert.NoError(t, err)
+	assert.Equal(t, "00:11:22:33:44:55", macs["lab01"].String())
+	assert.Equal(t, "00:11:22:33:44:66", macs["10.0.0.5?iface=eth1"].String())
+
+	_, err = parseMACs("lab01")
+	assert.Error(t, err)
+	_, err = parseMACs("lab01=zz:11:22:33:44:55")
+	assert.Error(t, err)
+}
+
+func TestMagicPacket(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	p := magicPacket(mac)
+	assert.Len(t, p, 102)
+	assert.Equal(t, bytes.Repeat([]byte{0xff}, 6), p[:6])
+	assert.Equal(t, []byte(mac), p[96:])
+}
+
+func TestWOLHandler(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	defer func(addr string, macs map[string]net.HardwareAddr) { wolAddr, hostMACs = addr, macs }(wolAddr, hostMACs)
+	wolAddr = conn.LocalAddr().String()
+	hostMACs, _ = parseMACs("lab01=00:11:22:33:44:55")
+
+	w := httptest.NewRecorder()
+	wolHandler(w, httptest.NewRequest(http.MethodPost, "/api/wol?host=lab01", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	buf := make([]byte, 200)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, magicPacket(hostMACs["lab01"]), buf[:n])
+
+	w = httptest.NewRecorder()
+	wolHandler(w, httptest.NewRequest(http.MethodPost, "/api/wol?host=lab02", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	statuses := []HostStatus{{Host: "lab01"}, {Host: "lab02"}}
+	annotateMACs(statuses)
+	assert.Equal(t, "00:11:22:33:44:55", statuses[0].MAC)
+	assert.Empty(t, statuses[1].MAC)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultWorkers is how many probes run at once unless set with -workers.
+// Every running ICMP probe holds a socket, so it stays well below the usual
+// limit of 1024 open files.
+const defaultWorkers = 512
+
+// probePool runs probes on a fixed set of workers. A cycle hands every due
+// host to the pool instead of starting a goroutine per host, so the number of
+// probes in flight, and of sockets they hold, stays bounded with thousands
+// of hosts. Submitting blocks while every worker is busy.
+type probePool struct {
+	size    int
+	jobs    chan func()
+	running sync.WaitGroup // Submitted jobs not finished yet
+}
+
+var probes = newProbePool(defaultWorkers)
+
+// newProbePool starts a pool of size workers.
+func newProbePool(size int) *probePool {
+	p := &probePool{size: size, jobs: make(chan func())}
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// work runs submitted jobs until the pool is closed.
+func (p *probePool) work() {
+	for job := range p.jobs {
+		job()
+		p.running.Done()
+	}
+}
+
+// submit runs job on the next free worker, waiting for one if all are busy.
+func (p *probePool) submit(job func()) {
+	p.running.Add(1)
+	p.jobs <- job
+}
+
+// close stops the workers once they finish their current job.
+func (p *probePool) close() {
+	close(p.jobs)
+}
+
+// drain closes the pool and waits until every submitted job finished or ctx
+// is done. Nothing may be submitted afterwards.
+//
+// Returns:
+//   - error: ctx's error if jobs were still running when it was done
+func (p *probePool) drain(ctx context.Context) error {
+	p.close()
+	done := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
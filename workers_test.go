@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbePoolBounded(t *testing.T) {
+	p := newProbePool(3)
+	defer p.close()
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		p.submit(func() {
+			defer wg.Done()
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+		})
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), peak.Load(), "never more probes in flight than workers")
+}
+
+func TestScheduleQueueManyHosts(t *testing.T) {
+	defer func(j float64) { probeJitter = j }(probeJitter)
+	probeJitter = 0.5
+	s := newSchedulerStats()
+	start := time.Now()
+	hosts := make([]string, 10000)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+	}
+
+	assert.Len(t, s.due(hosts, start), len(hosts), "new hosts are due at once")
+	s.beginCycle(hosts, start)
+	for _, h := range hosts {
+		s.probeDone(h, time.Millisecond)
+	}
+	wait := s.endCycle(pingInterval, start.Add(time.Millisecond))
+
+	// The queue agrees with a scan of every schedule
+	first := start.Add(time.Hour)
+	for _, hs := range s.byHost {
+		if hs.NextProbe.Before(first) {
+			first = hs.NextProbe
+		}
+	}
+	assert.Equal(t, first.Sub(start.Add(time.Millisecond)), wait)
+	at := start.Add(pingInterval)
+	var want int
+	for _, hs := range s.byHost {
+		if !hs.NextProbe.After(at) {
+			want++
+		}
+	}
+	due := s.due(hosts, at)
+	assert.Len(t, due, want)
+	assert.Greater(t, want, 0)
+	assert.Less(t, want, len(hosts))
+
+	// Due hosts leave the queue while probed, forgotten hosts for good
+	s.beginCycle(due, at)
+	assert.Empty(t, s.due(hosts, at))
+	assert.Len(t, s.queue, len(hosts)-len(due))
+	queued := len(s.queue)
+	for _, h := range hosts {
+		if s.byHost[h].index >= 0 {
+			s.forget(h)
+			break
+		}
+	}
+	assert.Len(t, s.queue, queued-1)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocket topics a client can subscribe to.
+const (
+	topicStatus = "status" // PingResult after every cycle
+	topicEvents = "events" // Every Event as it is recorded
+	topicAlerts = "alerts" // Only events that start or end an alert
+	topicAgents = "agents" // Health of the probing agent, once per cycle
+)
+
+// wsTopics lists the known topics.
+var wsTopics = []string{topicStatus, topicEvents, topicAlerts, topicAgents}
+
+// alertEventTypes are the event types published on the alerts topic.
+var alertEventTypes = map[string]bool{
+	"correlated_incident":          true,
+	"correlated_incident_resolved": true,
+	"self_alert":                   true,
+	"self_alert_resolved":          true,
+	"mac_changed":                  true,
+	"dns_changed":                  true,
+	"host_flapping":                true,
+	"host_flapping_resolved":       true,
+	"host_down":                    true,
+	"host_up":                      true,
+	"discovery_changed":            true,
+}
+
+// TopicMessage wraps a message sent to clients that chose their topics.
+type TopicMessage struct {
+	Topic string      `json:"topic"`
+	Data  interface{} `json:"data"`
+}
+
+// SubscribeRequest is sent by a client to replace its subscription, e.g.
+// {"subscribe":["alerts"]}.
+type SubscribeRequest struct {
+	Subscribe []string `json:"subscribe"`
+}
+
+// wsSubscription is what a connected client receives. Clients that connect
+// without ?topics= get the bare status stream, as before topics existed.
+type wsSubscription struct {
+	topics   map[string]bool
+	envelope bool // Wrap messages in a TopicMessage
+}
+
+// legacySubscription is the subscription of a client that did not ask for
+// topics.
+func legacySubscription() *wsSubscription {
+	return &wsSubscription{topics: map[string]bool{topicStatus: true}}
+}
+
+// parseTopics parses a list of topic names.
+//
+// Parameters:
+//   - names: Topic names; empty entries are ignored
+//
+// Returns:
+//   - map[string]bool: The set of topics
+//   - error: If a name is not a known topic
+func parseTopics(names []string) (map[string]bool, error) {
+	topics := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, t := range wsTopics {
+			known = known || t == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown topic %q", name)
+		}
+		topics[name] = true
+	}
+	return topics, nil
+}
+
+// publish sends data to every client subscribed to topic. Clients whose
+// connection fails are dropped.
+//
+// Parameters:
+//   - topic: One of wsTopics
+//   - data: The message, marshaled to JSON
+func publish(topic string, data interface{}) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	var bare, wrapped []byte
+	for c, sub := range clients {
+		if !sub.topics[topic] {
+			continue
+		}
+		msg := &bare
+		if sub.envelope {
+			msg = &wrapped
+		}
+		if *msg == nil {
+			var v interface{} = data
+			if sub.envelope {
+				v = TopicMessage{Topic: topic, Data: data}
+			}
+			b, err := jsonMarshal(v)
+			if err != nil {
+				log.Printf("Error marshaling %s message: %v", topic, err)
+				return
+			}
+			*msg = b
+		}
+		if err := websocket.Message.Send(c, string(*msg)); err != nil {
+			c.Close()
+			delete(clients, c)
+		}
+	}
+}
+
+// publishEvent sends a recorded event to the events topic, and to the alerts
+// topic if it starts or ends an alert.
+func publishEvent(e Event) {
+	publish(topicEvents, e)
+	if alertEventTypes[e.Type] {
+		publish(topicAlerts, e)
+	}
+}
+
+// subscribe replaces the topics of a connected client.
+func subscribe(ws *websocket.Conn, topics map[string]bool) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if sub, ok := clients[ws]; ok {
+		sub.topics = topics
+		sub.envelope = true
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func TestParseTopics(t *testing.T) {
+	topics, err := parseTopics([]string{"status", " alerts", ""})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"status": true, "alerts": true}, topics)
+
+	_, err = parseTopics([]string{"firehose"})
+	assert.Error(t, err)
+}
+
+// dialTopics connects a WebSocket client to server and waits until the
+// handler registered it. Clients that choose topics first receive the
+// capabilities message, which is checked and skipped.
+func dialTopics(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	clientsMu.Lock()
+	before := len(clients)
+	clientsMu.Unlock()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws"+query, "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	if query != "" {
+		var msg TopicMessage
+		assert.NoError(t, json.Unmarshal([]byte(receive(t, ws)), &msg))
+		assert.Equal(t, topicCapabilities, msg.Topic)
+	}
+	assert.Eventually(t, func() bool {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		return len(clients) > before
+	}, time.Second, 5*time.Millisecond)
+	return ws
+}
+
+// receive reads the next message from ws.
+func receive(t *testing.T, ws *websocket.Conn) string {
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var msg string
+	if err := websocket.Message.Receive(ws, &msg); err != nil {
+		t.Fatalf("Failed to receive message: %v", err)
+	}
+	return msg
+}
+
+func TestWSTopics(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(wsHandler))
+	defer server.Close()
+
+	legacy := dialTopics(t, server, "")
+	defer legacy.Close()
+	alerts := dialTopics(t, server, "?topics=alerts")
+	defer alerts.Close()
+
+	events.add(Event{Type: "host_added", Message: "not an alert"})
+	events.add(Event{Type: "self_alert", Key: "timeout_ratio", Message: "alert"})
+	broadcast(PingResult{Statuses: []HostStatus{{Host: "a", Alive: true}}})
+
+	var msg TopicMessage
+	assert.NoError(t, json.Unmarshal([]byte(receive(t, alerts)), &msg))
+	assert.Equal(t, "alerts", msg.Topic)
+	assert.Equal(t, "self_alert", msg.Data.(map[string]interface{})["type"])
+
+	// Clients without topics keep getting the bare status stream
+	var result PingResult
+	assert.NoError(t, json.Unmarshal([]byte(receive(t, legacy)), &result))
+	assert.Equal(t, "a", result.Statuses[0].Host)
+
+	// Changing the subscription takes effect for later messages
+	assert.NoError(t, websocket.JSON.Send(alerts, SubscribeRequest{Subscribe: []string{"agents"}}))
+	assert.Eventually(t, func() bool {
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		for _, sub := range clients {
+			if sub.topics[topicAgents] {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 5*time.Millisecond)
+	publish(topicAgents, HostStatus{Host: selfHost, Alive: true})
+	assert.NoError(t, json.Unmarshal([]byte(receive(t, alerts)), &msg))
+	assert.Equal(t, "agents", msg.Topic)
+}
+
+func TestWSUnknownTopic(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(wsHandler))
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?topics=firehose", "", "http://localhost/")
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket: %v", err)
+	}
+	defer ws.Close()
+	assert.Contains(t, receive(t, ws), "unknown topic")
+}